@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/httputil"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/services"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+var (
+	adminInstance *services.DocumentAdminFunction
+	once          sync.Once
+	initErr       error
+)
+
+func init() {
+	// --- Set up structured logging ---
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	// Register the HTTP function with the framework.
+	// "HandleDocumentAdmin" is the entry point name configured in GCP.
+	functions.HTTP("HandleDocumentAdmin", handleDocumentAdmin)
+}
+
+// main is required by the Go Functions Framework.
+func main() {}
+
+// handleDocumentAdmin routes the document admin endpoints: GET /documents and
+// POST /documents/{id}:cancel.
+func handleDocumentAdmin(w http.ResponseWriter, r *http.Request) {
+	// Use sync.Once for robust, one-time initialization of clients.
+	once.Do(func() {
+		adminInstance, initErr = services.NewDocumentAdmin(context.Background())
+		if initErr == nil {
+			httputil.CloseOnShutdown(adminInstance)
+		}
+	})
+	if initErr != nil {
+		slog.Error("Critical: DocumentAdmin initialization failed", "error", initErr)
+		http.Error(w, "Internal Server Error: failed to initialize service", http.StatusInternalServerError)
+		return
+	}
+
+	if docID, ok := cancelTarget(r.URL.Path); ok {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handleCancel(w, r, docID)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasSuffix(r.URL.Path, "/documents") {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	handleDocuments(w, r)
+}
+
+// cancelTarget returns the document ID of a /documents/{id}:cancel path.
+func cancelTarget(path string) (string, bool) {
+	i := strings.LastIndex(path, "/documents/")
+	if i < 0 || !strings.HasSuffix(path, ":cancel") {
+		return "", false
+	}
+	docID := strings.TrimSuffix(path[i+len("/documents/"):], ":cancel")
+	if docID == "" || strings.Contains(docID, "/") {
+		return "", false
+	}
+	return docID, true
+}
+
+// handleCancel serves POST /documents/{id}:cancel.
+func handleCancel(w http.ResponseWriter, r *http.Request, docID string) {
+	res, err := adminInstance.CancelDocument(r.Context(), docID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrDocumentNotFound):
+			http.Error(w, "Not Found: "+err.Error(), http.StatusNotFound)
+		case errors.Is(err, services.ErrDocumentNotCancellable):
+			http.Error(w, "Conflict: "+err.Error(), http.StatusConflict)
+		default:
+			// The specific error is already logged inside the service.
+			http.Error(w, "Internal Server Error: processing failed", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		slog.Error("Failed to write response", "error", err, "documentId", docID)
+		http.Error(w, "Internal Server Error: failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// handleDocuments serves GET /documents?status=...&createdAfter=...&createdBefore=...
+// &filenameContains=...&pageSize=...&pageToken=..., with times in RFC 3339.
+func handleDocuments(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := models.DocumentListRequest{
+		Status:           query.Get("status"),
+		FilenameContains: query.Get("filenameContains"),
+		PageToken:        query.Get("pageToken"),
+	}
+	for _, param := range []struct {
+		name string
+		dest *time.Time
+	}{{"createdAfter", &req.CreatedAfter}, {"createdBefore", &req.CreatedBefore}} {
+		if raw := query.Get(param.name); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				http.Error(w, "Bad Request: "+param.name+" must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+			*param.dest = t
+		}
+	}
+	if raw := query.Get("pageSize"); raw != "" {
+		pageSize, err := strconv.Atoi(raw)
+		if err != nil {
+			http.Error(w, "Bad Request: pageSize must be an integer", http.StatusBadRequest)
+			return
+		}
+		req.PageSize = pageSize
+	}
+
+	res, err := adminInstance.ListDocuments(r.Context(), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidDocumentQuery) {
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// The specific error is already logged inside the service.
+		http.Error(w, "Internal Server Error: processing failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		slog.Error("Failed to write response", "error", err)
+		http.Error(w, "Internal Server Error: failed to encode response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/httputil"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/services"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+var (
+	statusInstance *services.StatusFunction
+	once           sync.Once
+	initErr        error
+)
+
+func init() {
+	// --- Set up structured logging ---
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	// Register the HTTP function with the framework.
+	// "HandleDocumentStatus" is the entry point name configured in GCP.
+	functions.HTTP("HandleDocumentStatus", handleDocumentStatus)
+}
+
+// main is required by the Go Functions Framework.
+func main() {}
+
+// handleDocumentStatus routes the read-only document endpoints.
+func handleDocumentStatus(w http.ResponseWriter, r *http.Request) {
+	// Use sync.Once for robust, one-time initialization of clients.
+	once.Do(func() {
+		statusInstance, initErr = services.NewStatus(context.Background())
+		if initErr == nil {
+			httputil.CloseOnShutdown(statusInstance)
+		}
+	})
+	if initErr != nil {
+		slog.Error("Critical: Status initialization failed", "error", initErr)
+		http.Error(w, "Internal Server Error: failed to initialize service", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/status"):
+		handleStatus(w, r)
+	case strings.HasSuffix(r.URL.Path, "/artifacts"):
+		handleArtifacts(w, r)
+	case strings.HasSuffix(r.URL.Path, "/estimate"):
+		handleEstimate(w, r)
+	default:
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}
+}
+
+// handleStatus serves GET /status?documentId=...|fileHash=...&tenantId=...&includePages=true.
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := models.DocumentStatusRequest{
+		DocumentID:   query.Get("documentId"),
+		FileHash:     query.Get("fileHash"),
+		TenantID:     query.Get("tenantId"),
+		IncludePages: query.Get("includePages") == "true",
+	}
+	if req.DocumentID == "" && req.FileHash == "" {
+		http.Error(w, "Bad Request: documentId or fileHash query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	res, err := statusInstance.GetStatus(r.Context(), &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrDocumentNotFound):
+			http.Error(w, "Not Found: "+err.Error(), http.StatusNotFound)
+		case errors.Is(err, services.ErrInvalidStatusRequest):
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		default:
+			// The specific error is already logged inside the service.
+			http.Error(w, "Internal Server Error: processing failed", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSON(w, res, res.DocumentID)
+}
+
+// handleArtifacts serves GET /artifacts?documentId=...&tenantId=...&class=...&pageSize=...&pageToken=...&signed=true.
+func handleArtifacts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := models.ArtifactListRequest{
+		DocumentID: query.Get("documentId"),
+		TenantID:   query.Get("tenantId"),
+		Class:      query.Get("class"),
+		PageToken:  query.Get("pageToken"),
+		Signed:     query.Get("signed") == "true",
+	}
+	if req.DocumentID == "" {
+		http.Error(w, "Bad Request: documentId query parameter is required", http.StatusBadRequest)
+		return
+	}
+	if raw := query.Get("pageSize"); raw != "" {
+		pageSize, err := strconv.Atoi(raw)
+		if err != nil {
+			http.Error(w, "Bad Request: pageSize must be an integer", http.StatusBadRequest)
+			return
+		}
+		req.PageSize = pageSize
+	}
+
+	res, err := statusInstance.ListArtifacts(r.Context(), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrDocumentNotFound) {
+			http.Error(w, "Not Found: "+err.Error(), http.StatusNotFound)
+			return
+		}
+		// The specific error is already logged inside the service.
+		http.Error(w, "Internal Server Error: processing failed", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, res, req.DocumentID)
+}
+
+// handleEstimate serves GET /estimate?documentId=...&tenantId=..., the estimated cost of
+// translating every page of a document.
+func handleEstimate(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := models.DocumentEstimateRequest{
+		DocumentID: query.Get("documentId"),
+		TenantID:   query.Get("tenantId"),
+	}
+	if req.DocumentID == "" {
+		http.Error(w, "Bad Request: documentId query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	res, err := statusInstance.EstimateDocument(r.Context(), &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrDocumentNotFound):
+			http.Error(w, "Not Found: "+err.Error(), http.StatusNotFound)
+		case errors.Is(err, services.ErrInvalidStatusRequest):
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		case models.CodeOf(err) == models.ErrorCodeNotFoundSource:
+			http.Error(w, "Conflict: the document's split pages no longer exist", http.StatusConflict)
+		default:
+			// The specific error is already logged inside the service.
+			http.Error(w, "Internal Server Error: processing failed", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSON(w, res, res.DocumentID)
+}
+
+func writeJSON(w http.ResponseWriter, res any, documentID string) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		slog.Error("Failed to write response", "error", err, "documentId", documentID)
+		http.Error(w, "Internal Server Error: failed to encode response", http.StatusInternalServerError)
+	}
+}
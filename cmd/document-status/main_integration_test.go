@@ -0,0 +1,84 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/services"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestHandleStatus(t *testing.T) {
+	ctx := context.Background()
+	emulators := testsupport.RequireEmulators(t)
+	firestoreClient, _ := emulators.Clients(ctx, t)
+	collection := fmt.Sprintf("documents-%d", time.Now().UnixNano())
+	t.Setenv("PROJECT_ID", emulators.ProjectID)
+	t.Setenv("FIRESTORE_COLLECTION", collection)
+	instance, err := services.NewStatus(ctx)
+	if err != nil {
+		t.Fatalf("NewStatus() error = %v", err)
+	}
+	t.Cleanup(func() { instance.Close() })
+	useStatus(t, instance, nil)
+
+	docRef := firestoreClient.Collection(collection).Doc("doc-1")
+	if _, err := docRef.Set(ctx, map[string]interface{}{
+		"status": "TRANSLATING", "pageCount": 3, "tenantId": "acme", "fileHash": "abc123",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for pageNumber, pageStatus := range map[int]string{1: models.PageStatusTranslated, 2: models.PageStatusFailedSoft, 3: models.PageStatusPending} {
+		page := map[string]interface{}{"pageNumber": pageNumber, "status": pageStatus, "updatedAt": time.Now()}
+		if _, err := docRef.Collection("pages").Doc(models.PageDocID(pageNumber)).Set(ctx, page); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantCode  int
+		wantPages int
+	}{
+		{name: "by document ID", query: "documentId=doc-1", wantCode: http.StatusOK},
+		{name: "with pages", query: "documentId=doc-1&includePages=true", wantCode: http.StatusOK, wantPages: 3},
+		{name: "by file hash of the tenant", query: "fileHash=abc123&tenantId=acme", wantCode: http.StatusOK},
+		{name: "of another tenant", query: "documentId=doc-1&tenantId=other", wantCode: http.StatusNotFound},
+		{name: "by file hash of another tenant", query: "fileHash=abc123&tenantId=other", wantCode: http.StatusNotFound},
+		{name: "missing", query: "documentId=doc-missing", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleDocumentStatus(w, httptest.NewRequest(http.MethodGet, "/status?"+tt.query, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			var res models.DocumentStatusResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("response %q is not JSON: %v", w.Body, err)
+			}
+			if res.DocumentID != "doc-1" || res.Status != "TRANSLATING" || res.PageCount != 3 || res.PagesCompleted != 2 {
+				t.Errorf("response = %+v, want doc-1 translating with 2 of 3 pages completed", res)
+			}
+			if res.PageStatusCounts[models.PageStatusPending] != 1 || len(res.Pages) != tt.wantPages {
+				t.Errorf("response counts %v and %d pages, want 1 pending and %d pages", res.PageStatusCounts, len(res.Pages), tt.wantPages)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/services"
+)
+
+// useStatus stands in for the one-time initialization, as if it had returned instance
+// and err, until the test ends.
+func useStatus(t *testing.T, instance *services.StatusFunction, err error) {
+	t.Helper()
+	once = sync.Once{}
+	once.Do(func() {})
+	statusInstance, initErr = instance, err
+	t.Cleanup(func() {
+		once = sync.Once{}
+		statusInstance, initErr = nil, nil
+	})
+}
+
+func TestHandleDocumentStatusRejectsBeforeReading(t *testing.T) {
+	tests := []struct {
+		name     string
+		initErr  error
+		method   string
+		target   string
+		wantCode int
+		wantBody string
+	}{
+		{name: "initialization failed", initErr: errors.New("PROJECT_ID must be set"), method: http.MethodGet, target: "/status?documentId=doc-1", wantCode: http.StatusInternalServerError, wantBody: "failed to initialize service"},
+		{name: "not a GET", method: http.MethodPost, target: "/status?documentId=doc-1", wantCode: http.StatusMethodNotAllowed},
+		{name: "unknown path", method: http.MethodGet, target: "/documents?documentId=doc-1", wantCode: http.StatusNotFound},
+		{name: "status without a document", method: http.MethodGet, target: "/status?tenantId=acme", wantCode: http.StatusBadRequest, wantBody: "documentId or fileHash"},
+		{name: "artifacts without a document", method: http.MethodGet, target: "/artifacts", wantCode: http.StatusBadRequest, wantBody: "documentId"},
+		{name: "artifacts with an invalid page size", method: http.MethodGet, target: "/artifacts?documentId=doc-1&pageSize=ten", wantCode: http.StatusBadRequest, wantBody: "pageSize must be an integer"},
+		{name: "estimate without a document", method: http.MethodGet, target: "/estimate", wantCode: http.StatusBadRequest, wantBody: "documentId"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// No request reaches the service, so it needs no clients.
+			useStatus(t, nil, tt.initErr)
+			w := httptest.NewRecorder()
+			handleDocumentStatus(w, httptest.NewRequest(tt.method, tt.target, nil))
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", w.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/httputil"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/services"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+var (
+	uploaderInstance *services.DocumentUploaderFunction
+	once             sync.Once
+	initErr          error
+	limits           httputil.Limits
+)
+
+// maxFilenameFieldBytes bounds the "filename" form field.
+const maxFilenameFieldBytes = 1024
+
+func init() {
+	// --- Set up structured logging ---
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	functions.HTTP("HandleUploadDocument", handleUploadDocument)
+}
+
+// main is required by the Go Functions Framework.
+func main() {}
+
+// initialize creates the uploader on first use and returns the initialization error.
+func initialize() error {
+	// Use sync.Once for robust, one-time initialization of clients.
+	once.Do(func() {
+		limits, initErr = httputil.LoadLimits()
+		if initErr != nil {
+			return
+		}
+		uploaderInstance, initErr = services.NewDocumentUploader(context.Background())
+		if initErr == nil {
+			httputil.CloseOnShutdown(uploaderInstance)
+		}
+	})
+	return initErr
+}
+
+// handleUploadDocument serves POST /upload. The PDF is either the whole body, sent as
+// application/pdf with its name in a Content-Disposition header, or the "file" part of a
+// multipart/form-data body, named by a "filename" field sent before it or by the part's
+// own file name. A new upload is answered with 201 and a duplicate with 200.
+func handleUploadDocument(w http.ResponseWriter, r *http.Request) {
+	if httputil.IsHealthCheck(r) {
+		httputil.ServeHealth(w, r, initialize, func(deep bool) map[string]func(context.Context) error {
+			return uploaderInstance.HealthChecks(deep)
+		})
+		return
+	}
+	if err := initialize(); err != nil {
+		slog.Error("Critical: Uploader initialization failed", "error", err)
+		http.Error(w, "Internal Server Error: failed to initialize service", http.StatusInternalServerError)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasSuffix(r.URL.Path, "/upload") {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, uploaderInstance.MaxUploadBytes())
+	file, filename, status, err := uploadedFile(r, body)
+	if err != nil {
+		slog.Warn("Invalid upload request", "error", err)
+		http.Error(w, http.StatusText(status)+": "+err.Error(), status)
+		return
+	}
+
+	ctx, cancel := limits.Context(r)
+	defer cancel()
+	res, err := uploaderInstance.Upload(ctx, filename, file)
+	if err != nil {
+		// The specific error is already logged inside the Upload method.
+		httputil.WriteProcessError(w, ctx, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if res.Duplicate {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		slog.Error("Failed to write response", "error", err, "documentId", res.DocumentID)
+	}
+}
+
+// uploadedFile returns the PDF of an upload request and its file name, which may be
+// empty. On failure it also returns the HTTP status to answer with.
+func uploadedFile(r *http.Request, body io.Reader) (io.Reader, string, int, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, "", http.StatusUnsupportedMediaType, errors.New("Content-Type must be application/pdf or multipart/form-data")
+	}
+	switch mediaType {
+	case "application/pdf":
+		return body, contentDispositionFilename(r.Header.Get("Content-Disposition")), 0, nil
+	case "multipart/form-data":
+		return multipartFile(multipart.NewReader(body, params["boundary"]))
+	}
+	return nil, "", http.StatusUnsupportedMediaType, errors.New("Content-Type must be application/pdf or multipart/form-data")
+}
+
+// multipartFile returns the "file" part of a multipart body and the file name given by
+// an earlier "filename" field or by the part itself. Other fields are ignored.
+func multipartFile(reader *multipart.Reader) (io.Reader, string, int, error) {
+	filename := ""
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, "", http.StatusBadRequest, errors.New("multipart body has no \"file\" part")
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, "", http.StatusRequestEntityTooLarge, err
+			}
+			return nil, "", http.StatusBadRequest, err
+		}
+		switch part.FormName() {
+		case "filename":
+			value, err := io.ReadAll(io.LimitReader(part, maxFilenameFieldBytes))
+			if err != nil {
+				return nil, "", http.StatusBadRequest, err
+			}
+			filename = strings.TrimSpace(string(value))
+		case "file":
+			if filename == "" {
+				filename = part.FileName()
+			}
+			return part, filename, 0, nil
+		}
+	}
+}
+
+// contentDispositionFilename returns the filename parameter of a Content-Disposition
+// header, or "" if there is none.
+func contentDispositionFilename(header string) string {
+	if header == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(params["filename"])
+}
@@ -0,0 +1,155 @@
+// Command localflow runs the whole document pipeline over a PDF on the local filesystem,
+// for developing prompts and parsing changes without deploying the functions.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/services"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+const usage = `Usage: localflow [flags] <file.pdf>
+
+Runs the pipeline in process: the PDF is optimized and split into pages, each page is
+translated to markdown, the pages are joined with page anchors, cleaned and split into
+sections. Nothing is read from or written to Cloud Storage or Firestore. Outputs are
+written under <out>/<sha256 of the PDF>/:
+
+  _pages/00003.md   the translation of each page
+  _master.md        the joined pages
+  _cleaned.md       the cleaned document
+  *.md              the sections (nested under directories with SECTION_LAYOUT=nested)
+  _index.json       the section manifest
+
+Outputs of an earlier run of the same PDF are replaced. Settings the functions read
+from the environment (GEMINI_MAX_RETRIES, CLEANER_CHUNK_*, SECTION_LAYOUT, ...) are
+read the same way; see scripts/setup_env.sh.
+
+Examples:
+
+  # Translate pages 3-7 with Gemini, using application default credentials.
+  localflow --project my-project --pages 3-7 spec.pdf
+
+  # Try another model for every stage.
+  localflow --project my-project --model gemini-1.5-flash spec.pdf
+
+  # Check splitting and section layout offline, without calling Vertex AI.
+  localflow --skip-llm --out /tmp/flow spec.pdf
+
+Flags:
+`
+
+func main() {
+	flags := flag.NewFlagSet("localflow", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	outDir := flags.String("out", "out", "Directory to write outputs under.")
+	project := flags.String("project", gcp.GetEnv("PROJECT_ID", ""), "GCP project for Vertex AI. Defaults to $PROJECT_ID.")
+	region := flags.String("region", gcp.GetEnv("VERTEX_AI_REGION", "us-central1"), "Vertex AI region.")
+	model := flags.String("model", "", "Gemini model for every stage. Defaults to the per-stage model settings.")
+	skipLLM := flags.Bool("skip-llm", false, "Use canned offline models instead of Vertex AI: pages get placeholder text, cleaning leaves the document unchanged and sections are split by headers.")
+	pages := flags.String("pages", "", "Pages to translate, as N or N-M. Defaults to every page.")
+	verbose := flags.Bool("v", false, "Log at debug level.")
+	flags.Parse(os.Args[1:])
+
+	level := slog.LevelInfo
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	startPage, endPage, err := parsePages(*pages)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "localflow: %v\n", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	outDirAbs, err := filepath.Abs(*outDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "localflow: %v\n", err)
+		os.Exit(1)
+	}
+	opts := services.LocalFlowOptions{
+		PDFPath:   flags.Arg(0),
+		Store:     objectstore.NewLocal(filepath.Dir(outDirAbs)),
+		Bucket:    filepath.Base(outDirAbs),
+		StartPage: startPage,
+		EndPage:   endPage,
+	}
+
+	if *skipLLM {
+		opts.Translator = offlineTranslator{}
+		opts.Cleaner = offlineCleaner{}
+		opts.SectionSplitter = offlineSectionSplitter{}
+	} else {
+		if *project == "" {
+			fmt.Fprintln(os.Stderr, "localflow: --project (or $PROJECT_ID) is required unless --skip-llm is set")
+			os.Exit(2)
+		}
+		models := gcp.LoadVertexModelConfig()
+		if *model != "" {
+			models = gcp.VertexModelConfig{TranslatorModelName: *model, CleanerModelName: *model, SectionSplitterModelName: *model, BundleSplitterModelName: *model}
+		}
+		vertexClient, err := gcp.NewVertexClient(ctx, *project, *region, models)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "localflow: failed to create vertex client: %v\n", err)
+			os.Exit(1)
+		}
+		defer vertexClient.Close()
+		opts.Translator = vertexClient.Translator()
+		opts.Cleaner = vertexClient.Cleaner()
+		opts.SectionSplitter = vertexClient.SectionSplitter()
+	}
+
+	result, err := services.RunLocalFlow(ctx, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "localflow: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d sections to %s\n", result.SectionCount, filepath.Join(outDirAbs, result.DocumentID))
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(result)
+}
+
+// parsePages parses a --pages value of the form "N" or "N-M". An empty value selects
+// every page and is returned as zeros.
+func parsePages(value string) (int, int, error) {
+	if value == "" {
+		return 0, 0, nil
+	}
+	startText, endText, isRange := strings.Cut(value, "-")
+	start, err := strconv.Atoi(strings.TrimSpace(startText))
+	if err != nil || start < 1 {
+		return 0, 0, fmt.Errorf("invalid --pages %q: expected N or N-M", value)
+	}
+	if !isRange {
+		return start, start, nil
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(endText))
+	if err != nil || end < start {
+		return 0, 0, fmt.Errorf("invalid --pages %q: expected N or N-M", value)
+	}
+	return start, end, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/vertexai/genai"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// The offline models stand in for Gemini under --skip-llm. They give every stage a
+// deterministic, well-formed response, so the rest of the pipeline can be exercised
+// without credentials.
+
+// offlineTranslator answers every page with placeholder markdown naming the page's size.
+type offlineTranslator struct{}
+
+func (offlineTranslator) GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	size := 0
+	for _, part := range parts {
+		if blob, ok := part.(genai.Blob); ok {
+			size = len(blob.Data)
+		}
+	}
+	return offlineResponse(fmt.Sprintf("# Offline translation\n\nThis page was not translated because --skip-llm is set. The page PDF is %d bytes.", size)), ctx.Err()
+}
+
+// offlineCleaner returns the markdown it is given unchanged. The markdown is the first
+// part and the prompt the second.
+type offlineCleaner struct{}
+
+func (offlineCleaner) GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	text, _ := parts[0].(genai.Text)
+	return offlineResponse(string(text)), ctx.Err()
+}
+
+// offlineSectionSplitter returns no JSON, so the section splitter falls back to splitting
+// by headers.
+type offlineSectionSplitter struct{}
+
+func (offlineSectionSplitter) GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	return offlineResponse(""), ctx.Err()
+}
+
+// offlineResponse builds a complete single-candidate response holding text.
+func offlineResponse(text string) *genai.GenerateContentResponse {
+	return &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{
+			Content:      &genai.Content{Role: "model", Parts: []genai.Part{genai.Text(text)}},
+			FinishReason: genai.FinishReasonStop,
+		}},
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"sync"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/httputil"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/services"
 		_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
@@ -18,6 +20,7 @@ var (
 	aggregatorInstance *services.AggregatorFunction
 	once               sync.Once
 	initErr            error
+	limits             httputil.Limits
 )
 
 func init() {
@@ -26,32 +29,53 @@ func init() {
 	slog.SetDefault(logger)
 
 	functions.HTTP("HandleAggregateMarkdown", handleAggregateMarkdown)
+	// AggregateMarkdownFromPubSub is triggered by its topic when ORCHESTRATION_MODE is pubsub.
+	functions.CloudEvent("AggregateMarkdownFromPubSub", aggregateMarkdownEvent)
 }
 
 func main() {}
 
-// handleAggregateMarkdown is the HTTP handler for the aggregation service.
-func handleAggregateMarkdown(w http.ResponseWriter, r *http.Request) {
+// initialize creates the aggregator on first use and returns the initialization error.
+func initialize() error {
+	// Use sync.Once for robust, one-time initialization of clients.
 	once.Do(func() {
+		limits, initErr = httputil.LoadLimits()
+		if initErr != nil {
+			return
+		}
 		aggregatorInstance, initErr = services.NewAggregator(context.Background())
+		if initErr == nil {
+			httputil.CloseOnShutdown(aggregatorInstance)
+		}
 	})
-	if initErr != nil {
-		slog.Error("Critical: Aggregator initialization failed", "error", initErr)
+	return initErr
+}
+
+// handleAggregateMarkdown is the HTTP handler for the aggregation service.
+func handleAggregateMarkdown(w http.ResponseWriter, r *http.Request) {
+	if httputil.IsHealthCheck(r) {
+		httputil.ServeHealth(w, r, initialize, func(deep bool) map[string]func(context.Context) error {
+			return aggregatorInstance.HealthChecks(deep)
+		})
+		return
+	}
+	if err := initialize(); err != nil {
+		slog.Error("Critical: Aggregator initialization failed", "error", err)
 		http.Error(w, "Internal Server Error: failed to initialize service", http.StatusInternalServerError)
 		return
 	}
 
 	var req models.MarkdownAggregatorRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		slog.Warn("Could not decode request body", "error", err)
-		http.Error(w, "Bad Request: could not parse JSON", http.StatusBadRequest)
+	if !httputil.DecodeJSON(w, r, limits, &req) {
 		return
 	}
 
-	res, err := aggregatorInstance.Process(r.Context(), &req)
+	ctx, cancel := limits.Context(r)
+	defer cancel()
+	res, err := aggregatorInstance.Process(ctx, &req)
 	if err != nil {
 		// Error is already logged with context in the Process method.
-		http.Error(w, "Internal Server Error: processing failed", http.StatusInternalServerError)
+		httputil.WriteProcessError(w, ctx, err)
 		return
 	}
 
@@ -66,3 +90,14 @@ func handleAggregateMarkdown(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error: failed to encode response", http.StatusInternalServerError)
 	}
 }
+
+// aggregateMarkdownEvent is the entry point of the Pub/Sub orchestration mode. It processes the
+// request published to the service's topic and reports the outcome to the pipeline
+// coordinator. Returning an error has Pub/Sub redeliver the message.
+func aggregateMarkdownEvent(ctx context.Context, e cloudevents.Event) error {
+	if err := initialize(); err != nil {
+		slog.Error("Critical: Aggregator initialization failed", "error", err)
+		return err
+	}
+	return aggregatorInstance.ProcessMessage(ctx, e.Data())
+}
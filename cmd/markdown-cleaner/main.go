@@ -9,6 +9,8 @@ import (
 	"sync"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/httputil"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/services"
 		_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
@@ -18,6 +20,7 @@ var (
 	cleanerInstance *services.CleanerFunction
 	once            sync.Once
 	initErr         error
+	limits          httputil.Limits
 )
 
 func init() {
@@ -30,36 +33,56 @@ func init() {
 	// Register the HTTP function with the framework.
 	// "HandleCleanMarkdown" is the entry point name configured in GCP.
 	functions.HTTP("HandleCleanMarkdown", handleCleanMarkdown)
+	// CleanMarkdownFromPubSub is triggered by its topic when ORCHESTRATION_MODE is pubsub.
+	functions.CloudEvent("CleanMarkdownFromPubSub", cleanMarkdownEvent)
 }
 
 // main is required by the Go Functions Framework.
 func main() {}
 
-// handleCleanMarkdown is the HTTP handler for the cleanup service.
-func handleCleanMarkdown(w http.ResponseWriter, r *http.Request) {
+// initialize creates the cleaner on first use and returns the initialization error.
+func initialize() error {
 	// Use sync.Once for robust, one-time initialization of clients.
 	once.Do(func() {
+		limits, initErr = httputil.LoadLimits()
+		if initErr != nil {
+			return
+		}
 		cleanerInstance, initErr = services.NewCleaner(context.Background())
+		if initErr == nil {
+			httputil.CloseOnShutdown(cleanerInstance)
+		}
 	})
-	if initErr != nil {
-		slog.Error("Critical: Cleaner initialization failed", "error", initErr)
+	return initErr
+}
+
+// handleCleanMarkdown is the HTTP handler for the cleanup service.
+func handleCleanMarkdown(w http.ResponseWriter, r *http.Request) {
+	if httputil.IsHealthCheck(r) {
+		httputil.ServeHealth(w, r, initialize, func(deep bool) map[string]func(context.Context) error {
+			return cleanerInstance.HealthChecks(deep)
+		})
+		return
+	}
+	if err := initialize(); err != nil {
+		slog.Error("Critical: Cleaner initialization failed", "error", err)
 		http.Error(w, "Internal Server Error: failed to initialize service", http.StatusInternalServerError)
 		return
 	}
 
 	// Decode the incoming JSON request from the workflow.
 	var req models.MarkdownCleanerRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		slog.Warn("Could not decode request body", "error", err)
-		http.Error(w, "Bad Request: could not parse JSON", http.StatusBadRequest)
+	if !httputil.DecodeJSON(w, r, limits, &req) {
 		return
 	}
 
 	// Delegate to the business logic.
-	res, err := cleanerInstance.Process(r.Context(), &req)
+	ctx, cancel := limits.Context(r)
+	defer cancel()
+	res, err := cleanerInstance.Process(ctx, &req)
 	if err != nil {
 		// The specific error is already logged inside the Process method with context.
-		http.Error(w, "Internal Server Error: processing failed", http.StatusInternalServerError)
+		httputil.WriteProcessError(w, ctx, err)
 		return
 	}
 
@@ -75,3 +98,14 @@ func handleCleanMarkdown(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error: failed to encode response", http.StatusInternalServerError)
 	}
 }
+
+// cleanMarkdownEvent is the entry point of the Pub/Sub orchestration mode. It processes the
+// request published to the service's topic and reports the outcome to the pipeline
+// coordinator. Returning an error has Pub/Sub redeliver the message.
+func cleanMarkdownEvent(ctx context.Context, e cloudevents.Event) error {
+	if err := initialize(); err != nil {
+		slog.Error("Critical: Cleaner initialization failed", "error", err)
+		return err
+	}
+	return cleanerInstance.ProcessMessage(ctx, e.Data())
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/httputil"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/services"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+var (
+	retranslatorInstance *services.RetranslatorFunction
+	once                 sync.Once
+	initErr              error
+)
+
+func init() {
+	// --- Set up structured logging ---
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	// Register the HTTP function with the framework.
+	// "HandleRetranslatePages" is the entry point name configured in GCP.
+	functions.HTTP("HandleRetranslatePages", handleRetranslatePages)
+}
+
+// main is required by the Go Functions Framework.
+func main() {}
+
+// handleRetranslatePages is the HTTP handler.
+func handleRetranslatePages(w http.ResponseWriter, r *http.Request) {
+	// Use sync.Once for robust, one-time initialization of clients.
+	once.Do(func() {
+		retranslatorInstance, initErr = services.NewRetranslator(context.Background())
+		if initErr == nil {
+			httputil.CloseOnShutdown(retranslatorInstance)
+		}
+	})
+	if initErr != nil {
+		slog.Error("Critical: Retranslator initialization failed", "error", initErr)
+		http.Error(w, "Internal Server Error: failed to initialize service", http.StatusInternalServerError)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.PageRetranslateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Warn("Could not decode request body", "error", err)
+		http.Error(w, "Bad Request: could not parse JSON", http.StatusBadRequest)
+		return
+	}
+
+	// Delegate to the business logic.
+	res, err := retranslatorInstance.Process(r.Context(), &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidRetranslateRequest):
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		case errors.Is(err, services.ErrDocumentNotFound):
+			http.Error(w, "Not Found: "+err.Error(), http.StatusNotFound)
+		case errors.Is(err, services.ErrExecutionActive):
+			http.Error(w, "Conflict: "+err.Error(), http.StatusConflict)
+		default:
+			// The specific error is already logged inside the Process method.
+			http.Error(w, "Internal Server Error: processing failed", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		slog.Error("Failed to write response", "error", err, "documentId", req.DocumentID)
+		http.Error(w, "Internal Server Error: failed to encode response", http.StatusInternalServerError)
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"sync"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/httputil"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/services"
 	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
@@ -18,6 +20,7 @@ var (
 	translatorInstance *services.TranslatorFunction
 	once               sync.Once
 	initErr            error
+	limits             httputil.Limits
 )
 
 func init() {
@@ -28,36 +31,56 @@ func init() {
 	// Register the HTTP function with the framework.
 	// "HandleTranslatePage" is the entry point name we'll see in GCP.
 	functions.HTTP("HandleTranslatePage", handleTranslatePage)
+	// TranslatePageFromPubSub is triggered by its topic when ORCHESTRATION_MODE is pubsub.
+	functions.CloudEvent("TranslatePageFromPubSub", translatePageEvent)
 }
 
 // main is required by the Go Functions Framework.
 func main() {}
 
-// handleTranslatePage is the HTTP handler.
-func handleTranslatePage(w http.ResponseWriter, r *http.Request) {
+// initialize creates the translator on first use and returns the initialization error.
+func initialize() error {
 	// Use sync.Once for robust, one-time initialization of clients.
 	once.Do(func() {
+		limits, initErr = httputil.LoadLimits()
+		if initErr != nil {
+			return
+		}
 		translatorInstance, initErr = services.NewTranslator(context.Background())
+		if initErr == nil {
+			httputil.CloseOnShutdown(translatorInstance)
+		}
 	})
-	if initErr != nil {
-		slog.Error("Critical: Translator initialization failed", "error", initErr)
+	return initErr
+}
+
+// handleTranslatePage is the HTTP handler.
+func handleTranslatePage(w http.ResponseWriter, r *http.Request) {
+	if httputil.IsHealthCheck(r) {
+		httputil.ServeHealth(w, r, initialize, func(deep bool) map[string]func(context.Context) error {
+			return translatorInstance.HealthChecks(deep)
+		})
+		return
+	}
+	if err := initialize(); err != nil {
+		slog.Error("Critical: Translator initialization failed", "error", err)
 		http.Error(w, "Internal Server Error: failed to initialize service", http.StatusInternalServerError)
 		return
 	}
 
 	// Decode the incoming JSON request from the workflow.
 	var req models.PageTranslatorRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		slog.Warn("Could not decode request body", "error", err)
-		http.Error(w, "Bad Request: could not parse JSON", http.StatusBadRequest)
+	if !httputil.DecodeJSON(w, r, limits, &req) {
 		return
 	}
 
 	// Delegate to the business logic.
-	res, err := translatorInstance.Process(r.Context(), &req)
+	ctx, cancel := limits.Context(r)
+	defer cancel()
+	res, err := translatorInstance.Process(ctx, &req)
 	if err != nil {
 		// The specific error is already logged inside the Process method.
-		http.Error(w, "Internal Server Error: processing failed", http.StatusInternalServerError)
+		httputil.WriteProcessError(w, ctx, err)
 		return
 	}
 
@@ -75,3 +98,14 @@ func handleTranslatePage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error: failed to encode response", http.StatusInternalServerError)
 	}
 }
+
+// translatePageEvent is the entry point of the Pub/Sub orchestration mode. It processes the
+// request published to the service's topic and reports the outcome to the pipeline
+// coordinator. Returning an error has Pub/Sub redeliver the message.
+func translatePageEvent(ctx context.Context, e cloudevents.Event) error {
+	if err := initialize(); err != nil {
+		slog.Error("Critical: Translator initialization failed", "error", err)
+		return err
+	}
+	return translatorInstance.ProcessMessage(ctx, e.Data())
+}
@@ -2,8 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"log/slog"
 	"os"
 	"sync"
@@ -16,6 +14,8 @@ import (
 
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/httputil"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/services"
 )
 
@@ -43,6 +43,9 @@ func splitAndPublish(ctx context.Context, e cloudevents.Event) error {
 	// Use sync.Once for robust, one-time initialization of clients.
 	once.Do(func() {
 		pdfSplitterInstance, initErr = services.NewPDFSplitter(context.Background())
+		if initErr == nil {
+			httputil.CloseOnShutdown(pdfSplitterInstance)
+		}
 	})
 	if initErr != nil {
 		// If initialization fails, log the fatal error and the function will terminate.
@@ -50,15 +53,14 @@ func splitAndPublish(ctx context.Context, e cloudevents.Event) error {
 		return initErr
 	}
 
-	// Unmarshal the event's data payload into our specific struct.
-	var gcsEvent services.GCSEvent
-	if err := json.Unmarshal(e.Data(), &gcsEvent); err != nil {
-		slog.Error("Failed to unmarshal event data", "error", err, "data", string(e.Data()))
-		return fmt.Errorf("json.Unmarshal: %w", err)
+	// Continue the trace of the upload event, if it carries one.
+	if traceparent, ok := e.Extensions()["traceparent"].(string); ok {
+		ctx = gcp.ContextWithTraceparent(ctx, traceparent)
 	}
 
-	// Delegate the actual processing to our business logic method.
-	err := pdfSplitterInstance.Process(ctx, gcsEvent)
+	// Delegate the parsing of the event's payload, in whichever format it was delivered,
+	// and the actual processing to our business logic method.
+	err := pdfSplitterInstance.ProcessEvent(ctx, e.Data())
 	if err != nil {
 		// The error is already logged with context within the Process method.
 		// Returning it marks the function invocation as failed.
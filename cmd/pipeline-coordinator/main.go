@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/httputil"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/services"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+var (
+	coordinatorInstance *services.CoordinatorFunction
+	once                sync.Once
+	initErr             error
+)
+
+func init() {
+	// --- Set up structured logging ---
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	// Register the CloudEvent function, triggered by the coordinator topic when
+	// ORCHESTRATION_MODE is pubsub.
+	functions.CloudEvent("CoordinatePipeline", coordinatePipeline)
+}
+
+// main is required by the Go Functions Framework.
+func main() {}
+
+// coordinatePipeline is the Cloud Function entry point. It receives the completion of
+// each stage and publishes the request of the next one. Returning an error has Pub/Sub
+// redeliver the completion.
+func coordinatePipeline(ctx context.Context, e cloudevents.Event) error {
+	// Use sync.Once for robust, one-time initialization of clients.
+	once.Do(func() {
+		coordinatorInstance, initErr = services.NewCoordinator(context.Background())
+		if initErr == nil {
+			httputil.CloseOnShutdown(coordinatorInstance)
+		}
+	})
+	if initErr != nil {
+		slog.Error("Critical: Coordinator initialization failed", "error", initErr)
+		return initErr
+	}
+	return coordinatorInstance.ProcessMessage(ctx, e.Data())
+}
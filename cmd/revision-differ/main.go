@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/httputil"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/services"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+var (
+	differInstance *services.RevisionDifferFunction
+	once           sync.Once
+	initErr        error
+)
+
+func init() {
+	// --- Set up structured logging ---
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	// Register the HTTP function with the framework.
+	// "HandleDiffRevisions" is the entry point name configured in GCP.
+	functions.HTTP("HandleDiffRevisions", handleDiffRevisions)
+}
+
+// main is required by the Go Functions Framework.
+func main() {}
+
+// handleDiffRevisions is the HTTP handler for the revision diff service.
+func handleDiffRevisions(w http.ResponseWriter, r *http.Request) {
+	// Use sync.Once for robust, one-time initialization of clients.
+	once.Do(func() {
+		differInstance, initErr = services.NewRevisionDiffer(context.Background())
+		if initErr == nil {
+			httputil.CloseOnShutdown(differInstance)
+		}
+	})
+	if initErr != nil {
+		slog.Error("Critical: RevisionDiffer initialization failed", "error", initErr)
+		http.Error(w, "Internal Server Error: failed to initialize service", http.StatusInternalServerError)
+		return
+	}
+
+	// Decode the incoming JSON request.
+	var req models.RevisionDiffRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Warn("Could not decode request body", "error", err)
+		http.Error(w, "Bad Request: could not parse JSON", http.StatusBadRequest)
+		return
+	}
+
+	// Delegate to the business logic.
+	res, err := differInstance.Process(r.Context(), &req)
+	if err != nil {
+		// The specific error is already logged inside the Process method.
+		httputil.WriteProcessError(w, r.Context(), err)
+		return
+	}
+
+	// If successful, encode the response.
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		slog.Error(
+			"Failed to write response",
+			"error", err,
+			"documentId", req.DocumentID,
+			"executionId", req.ExecutionID,
+		)
+		http.Error(w, "Internal Server Error: failed to encode response", http.StatusInternalServerError)
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"sync"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/httputil"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/services"
 	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
@@ -18,6 +20,7 @@ var (
 	splitterInstance *services.SectionSplitterFunction
 	once             sync.Once
 	initErr          error
+	limits           httputil.Limits
 )
 
 func init() {
@@ -28,37 +31,57 @@ func init() {
 	// Register the HTTP function with the framework.
 	// "HandleSplitSections" is the entry point name configured in GCP.
 	functions.HTTP("HandleSplitSections", handleSplitSections)
+	// SplitSectionsFromPubSub is triggered by its topic when ORCHESTRATION_MODE is pubsub.
+	functions.CloudEvent("SplitSectionsFromPubSub", splitSectionsEvent)
 }
 
 // main is required by the Go Functions Framework.
 func main() {}
 
-// handleSplitSections is the HTTP handler for the section splitting service.
-func handleSplitSections(w http.ResponseWriter, r *http.Request) {
+// initialize creates the section splitter on first use and returns the initialization error.
+func initialize() error {
 	// Use sync.Once for robust, one-time initialization of clients.
 	once.Do(func() {
+		limits, initErr = httputil.LoadLimits()
+		if initErr != nil {
+			return
+		}
 		// This now calls the correctly named constructor.
 		splitterInstance, initErr = services.NewSectionSplitter(context.Background())
+		if initErr == nil {
+			httputil.CloseOnShutdown(splitterInstance)
+		}
 	})
-	if initErr != nil {
-		slog.Error("Critical: SectionSplitter initialization failed", "error", initErr)
+	return initErr
+}
+
+// handleSplitSections is the HTTP handler for the section splitting service.
+func handleSplitSections(w http.ResponseWriter, r *http.Request) {
+	if httputil.IsHealthCheck(r) {
+		httputil.ServeHealth(w, r, initialize, func(deep bool) map[string]func(context.Context) error {
+			return splitterInstance.HealthChecks(deep)
+		})
+		return
+	}
+	if err := initialize(); err != nil {
+		slog.Error("Critical: SectionSplitter initialization failed", "error", err)
 		http.Error(w, "Internal Server Error: failed to initialize service", http.StatusInternalServerError)
 		return
 	}
 
 	// Decode the incoming JSON request from the workflow.
 	var req models.SectionSplitterRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		slog.Warn("Could not decode request body", "error", err)
-		http.Error(w, "Bad Request: could not parse JSON", http.StatusBadRequest)
+	if !httputil.DecodeJSON(w, r, limits, &req) {
 		return
 	}
 
 	// Delegate to the business logic.
-	res, err := splitterInstance.Process(r.Context(), &req)
+	ctx, cancel := limits.Context(r)
+	defer cancel()
+	res, err := splitterInstance.Process(ctx, &req)
 	if err != nil {
 		// The specific error is already logged inside the Process method.
-		http.Error(w, "Internal Server Error: processing failed", http.StatusInternalServerError)
+		httputil.WriteProcessError(w, ctx, err)
 		return
 	}
 
@@ -74,3 +97,14 @@ func handleSplitSections(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error: failed to encode response", http.StatusInternalServerError)
 	}
 }
+
+// splitSectionsEvent is the entry point of the Pub/Sub orchestration mode. It processes the
+// request published to the service's topic and reports the outcome to the pipeline
+// coordinator. Returning an error has Pub/Sub redeliver the message.
+func splitSectionsEvent(ctx context.Context, e cloudevents.Event) error {
+	if err := initialize(); err != nil {
+		slog.Error("Critical: SectionSplitter initialization failed", "error", err)
+		return err
+	}
+	return splitterInstance.ProcessMessage(ctx, e.Data())
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/httputil"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/services"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+var (
+	sweeperInstance *services.StuckSweeperFunction
+	once            sync.Once
+	initErr         error
+)
+
+func init() {
+	// --- Set up structured logging ---
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	// Register the CloudEvent function, triggered through the sweep topic by a Cloud
+	// Scheduler job.
+	functions.CloudEvent("SweepStuckDocuments", sweepStuckDocuments)
+}
+
+// main is required by the Go Functions Framework.
+func main() {}
+
+// sweepStuckDocuments is the Cloud Function entry point. The scheduled message carries
+// nothing the sweep needs. Returning an error has Pub/Sub redeliver it.
+func sweepStuckDocuments(ctx context.Context, e cloudevents.Event) error {
+	// Use sync.Once for robust, one-time initialization of clients.
+	once.Do(func() {
+		sweeperInstance, initErr = services.NewStuckSweeper(context.Background())
+		if initErr == nil {
+			httputil.CloseOnShutdown(sweeperInstance)
+		}
+	})
+	if initErr != nil {
+		slog.Error("Critical: Stuck-document sweeper initialization failed", "error", initErr)
+		return initErr
+	}
+	_, err := sweeperInstance.Process(ctx)
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/httputil"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/services"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+var (
+	adminInstance *services.TenantAdminFunction
+	once          sync.Once
+	initErr       error
+)
+
+func init() {
+	// --- Set up structured logging ---
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	// Register the HTTP function with the framework.
+	// "HandleTenantAdmin" is the entry point name configured in GCP.
+	functions.HTTP("HandleTenantAdmin", handleTenantAdmin)
+}
+
+// main is required by the Go Functions Framework.
+func main() {}
+
+// handleTenantAdmin serves GET (read), POST (create) and PUT (update) for tenant configs.
+func handleTenantAdmin(w http.ResponseWriter, r *http.Request) {
+	// Use sync.Once for robust, one-time initialization of clients.
+	once.Do(func() {
+		adminInstance, initErr = services.NewTenantAdmin(context.Background())
+		if initErr == nil {
+			httputil.CloseOnShutdown(adminInstance)
+		}
+	})
+	if initErr != nil {
+		slog.Error("Critical: TenantAdmin initialization failed", "error", initErr)
+		http.Error(w, "Internal Server Error: failed to initialize service", http.StatusInternalServerError)
+		return
+	}
+
+	var (
+		res *models.TenantConfig
+		err error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		tenantID := r.URL.Query().Get("tenantId")
+		if tenantID == "" {
+			http.Error(w, "Bad Request: tenantId query parameter is required", http.StatusBadRequest)
+			return
+		}
+		res, err = adminInstance.Get(r.Context(), tenantID)
+	case http.MethodPost, http.MethodPut:
+		var req models.TenantConfig
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.Warn("Could not decode request body", "error", err)
+			http.Error(w, "Bad Request: could not parse JSON", http.StatusBadRequest)
+			return
+		}
+		res, err = adminInstance.Put(r.Context(), &req, r.Method == http.MethodPost)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidTenantConfig):
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		case errors.Is(err, services.ErrTenantNotFound):
+			http.Error(w, "Not Found: "+err.Error(), http.StatusNotFound)
+		case errors.Is(err, services.ErrTenantExists):
+			http.Error(w, "Conflict: "+err.Error(), http.StatusConflict)
+		default:
+			// The specific error is already logged inside the service.
+			http.Error(w, "Internal Server Error: processing failed", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		slog.Error("Failed to write response", "error", err, "tenantId", res.TenantID)
+		http.Error(w, "Internal Server Error: failed to encode response", http.StatusInternalServerError)
+	}
+}
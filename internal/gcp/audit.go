@@ -0,0 +1,271 @@
+package gcp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// AuditRecord is what was sent to and received from the model in one call.
+type AuditRecord struct {
+	Stage      string `json:"stage"`
+	DocumentID string `json:"documentId"`
+	// Unit is the page, chunk or part of the document the call was made for.
+	Unit              string                `json:"unit"`
+	Model             string                `json:"model"`
+	SystemInstruction []AuditPart           `json:"systemInstruction,omitempty"`
+	Prompt            []AuditPart           `json:"prompt"`
+	GenerationConfig  AuditGenerationConfig `json:"generationConfig"`
+	// Response is the text of the first candidate, and FinishReason and SafetyRatings its
+	// finish reason and ratings. They are empty when the model returned no candidate.
+	Response      string              `json:"response"`
+	FinishReason  string              `json:"finishReason,omitempty"`
+	SafetyRatings []AuditSafetyRating `json:"safetyRatings,omitempty"`
+	// BlockReason and PromptSafetyRatings are set when the prompt itself was blocked.
+	BlockReason         string              `json:"blockReason,omitempty"`
+	PromptSafetyRatings []AuditSafetyRating `json:"promptSafetyRatings,omitempty"`
+	Usage               *models.TokenUsage  `json:"usage,omitempty"`
+	Error               string              `json:"error,omitempty"`
+	StartedAt           time.Time           `json:"startedAt"`
+	CompletedAt         time.Time           `json:"completedAt"`
+	LatencyMs           int64               `json:"latencyMs"`
+}
+
+// AuditPart is one part of a prompt. Text is kept in full; files are named by their URI,
+// and inline data by its SHA-256 hash and size.
+type AuditPart struct {
+	Type     string `json:"type"` // "text", "file" or "blob".
+	Text     string `json:"text,omitempty"`
+	MIMEType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+	Size     int    `json:"size,omitempty"`
+}
+
+// AuditGenerationConfig is the generation config a call was made with.
+type AuditGenerationConfig struct {
+	Temperature      *float32 `json:"temperature,omitempty"`
+	TopP             *float32 `json:"topP,omitempty"`
+	TopK             *int32   `json:"topK,omitempty"`
+	CandidateCount   *int32   `json:"candidateCount,omitempty"`
+	MaxOutputTokens  *int32   `json:"maxOutputTokens,omitempty"`
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	ResponseMIMEType string   `json:"responseMimeType,omitempty"`
+}
+
+// AuditSafetyRating is a safety rating of a prompt or response.
+type AuditSafetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability"`
+	Severity    string `json:"severity,omitempty"`
+	Blocked     bool   `json:"blocked,omitempty"`
+}
+
+// NewAuditRecord describes a call of model with parts that took from startedAt to
+// completedAt and returned resp and err. A blocked prompt or response is described from
+// the *genai.BlockedError, as the model returns no response for it.
+func NewAuditRecord(model *genai.GenerativeModel, parts []genai.Part, resp *genai.GenerateContentResponse, err error, startedAt, completedAt time.Time) AuditRecord {
+	record := AuditRecord{
+		Model:       model.Name(),
+		Prompt:      auditParts(parts),
+		StartedAt:   startedAt,
+		CompletedAt: completedAt,
+		LatencyMs:   completedAt.Sub(startedAt).Milliseconds(),
+		GenerationConfig: AuditGenerationConfig{
+			Temperature:      model.Temperature,
+			TopP:             model.TopP,
+			TopK:             model.TopK,
+			CandidateCount:   model.CandidateCount,
+			MaxOutputTokens:  model.MaxOutputTokens,
+			StopSequences:    model.StopSequences,
+			ResponseMIMEType: model.ResponseMIMEType,
+		},
+	}
+	if model.SystemInstruction != nil {
+		record.SystemInstruction = auditParts(model.SystemInstruction.Parts)
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	candidate := (*genai.Candidate)(nil)
+	var feedback *genai.PromptFeedback
+	var blocked *genai.BlockedError
+	switch {
+	case resp != nil:
+		if len(resp.Candidates) > 0 {
+			candidate = resp.Candidates[0]
+		}
+		feedback = resp.PromptFeedback
+		if resp.UsageMetadata != nil {
+			record.Usage = &models.TokenUsage{
+				PromptTokens:     int64(resp.UsageMetadata.PromptTokenCount),
+				CandidatesTokens: int64(resp.UsageMetadata.CandidatesTokenCount),
+				TotalTokens:      int64(resp.UsageMetadata.TotalTokenCount),
+			}
+		}
+	case errors.As(err, &blocked):
+		candidate, feedback = blocked.Candidate, blocked.PromptFeedback
+	}
+	if candidate != nil {
+		if candidate.Content != nil {
+			for _, part := range candidate.Content.Parts {
+				if text, ok := part.(genai.Text); ok {
+					record.Response += string(text)
+				}
+			}
+		}
+		record.FinishReason = candidate.FinishReason.String()
+		record.SafetyRatings = auditSafetyRatings(candidate.SafetyRatings)
+	}
+	if feedback != nil {
+		record.BlockReason = feedback.BlockReason.String()
+		record.PromptSafetyRatings = auditSafetyRatings(feedback.SafetyRatings)
+	}
+	return record
+}
+
+func auditParts(parts []genai.Part) []AuditPart {
+	audited := make([]AuditPart, 0, len(parts))
+	for _, part := range parts {
+		switch p := part.(type) {
+		case genai.Text:
+			audited = append(audited, AuditPart{Type: "text", Text: string(p)})
+		case genai.FileData:
+			audited = append(audited, AuditPart{Type: "file", MIMEType: p.MIMEType, FileURI: p.FileURI})
+		case genai.Blob:
+			sum := sha256.Sum256(p.Data)
+			audited = append(audited, AuditPart{Type: "blob", MIMEType: p.MIMEType, SHA256: hex.EncodeToString(sum[:]), Size: len(p.Data)})
+		default:
+			audited = append(audited, AuditPart{Type: fmt.Sprintf("%T", part)})
+		}
+	}
+	return audited
+}
+
+func auditSafetyRatings(ratings []*genai.SafetyRating) []AuditSafetyRating {
+	var audited []AuditSafetyRating
+	for _, rating := range ratings {
+		if rating == nil {
+			continue
+		}
+		audited = append(audited, AuditSafetyRating{
+			Category:    rating.Category.String(),
+			Probability: rating.Probability.String(),
+			Severity:    rating.Severity.String(),
+			Blocked:     rating.Blocked,
+		})
+	}
+	return audited
+}
+
+type auditScopeKey struct{}
+
+type auditScope struct {
+	documentID string
+	unit       string
+}
+
+// WithAuditScope returns a context whose model calls are audited as made for unit, such as
+// a page or chunk, of the document docID. Calls without a scope are not audited.
+func WithAuditScope(ctx context.Context, docID, unit string) context.Context {
+	return context.WithValue(ctx, auditScopeKey{}, auditScope{documentID: docID, unit: unit})
+}
+
+// Auditor stores an AuditRecord of every model call made with an audit scope, as
+// {docId}/audit/{stage}/{unit}-{startedAt}.json in its bucket. A page can be sent to the
+// model several times, for retries, continuations and second passes, so each call's
+// record is named by when it started.
+type Auditor struct {
+	bucket       objectstore.Bucket
+	writeTimeout time.Duration
+	closeOnce    sync.Once
+	close        func() error
+}
+
+// NewAuditorFromEnv returns an Auditor writing to AUDIT_BUCKET, or nil when it is not set,
+// which disables auditing. AUDIT_WRITE_TIMEOUT bounds how long a call waits for its record
+// to be written.
+func NewAuditorFromEnv(ctx context.Context) (*Auditor, error) {
+	bucket := GetEnv("AUDIT_BUCKET", "")
+	if bucket == "" {
+		return nil, nil
+	}
+	writeTimeout, err := time.ParseDuration(GetEnv("AUDIT_WRITE_TIMEOUT", "5s"))
+	if err != nil || writeTimeout <= 0 {
+		return nil, fmt.Errorf("AUDIT_WRITE_TIMEOUT must be a positive duration")
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client for audit records: %w", err)
+	}
+	return &Auditor{
+		bucket:       objectstore.NewGCS(client).Bucket(bucket),
+		writeTimeout: writeTimeout,
+		close:        client.Close,
+	}, nil
+}
+
+// Close releases the auditor's storage client. It may be called more than once.
+func (a *Auditor) Close() error {
+	var err error
+	a.closeOnce.Do(func() {
+		if a.close != nil {
+			err = a.close()
+		}
+	})
+	return err
+}
+
+// auditObjectName returns the name of a call's audit record.
+func auditObjectName(record AuditRecord) string {
+	return fmt.Sprintf("%s/audit/%s/%s-%s.json", record.DocumentID, record.Stage, record.Unit, record.StartedAt.UTC().Format("20060102T150405.000000000Z"))
+}
+
+// write stores record. Auditing is best effort: it never fails the call being audited, so
+// errors are only logged, and the write is abandoned after the write timeout.
+func (a *Auditor) write(ctx context.Context, record AuditRecord) {
+	logCtx := slog.With("documentId", record.DocumentID, "stage", record.Stage, "unit", record.Unit)
+	data, err := json.Marshal(record)
+	if err != nil {
+		logCtx.Error("Failed to encode audit record", "error", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), a.writeTimeout)
+	defer cancel()
+	objectName := auditObjectName(record)
+	if err := SaveToGCSAtomically(ctx, logCtx, a.bucket, objectName, string(data)); err != nil {
+		logCtx.Error("Failed to write audit record", "error", err, "gcsObject", objectName)
+	}
+}
+
+// auditedGenerator audits each call of a stage's model made with an audit scope.
+type auditedGenerator struct {
+	model   *genai.GenerativeModel
+	stage   string
+	auditor *Auditor
+}
+
+// GenerateContent implements ContentGenerator.
+func (g *auditedGenerator) GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	startedAt := time.Now()
+	resp, err := g.model.GenerateContent(ctx, parts...)
+	if scope, ok := ctx.Value(auditScopeKey{}).(auditScope); ok {
+		record := NewAuditRecord(g.model, parts, resp, err, startedAt, time.Now())
+		record.Stage, record.DocumentID, record.Unit = g.stage, scope.documentID, scope.unit
+		g.auditor.write(ctx, record)
+	}
+	return resp, err
+}
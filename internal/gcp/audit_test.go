@@ -0,0 +1,149 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/vertexai/genai"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+// pageContent stands in for the content of a document page, which audit records and logs
+// must not hold.
+var pageContent = []byte("%PDF-1.7 CONFIDENTIAL pump P-101 impeller clearance 0.35 mm")
+
+func auditTestModel() *genai.GenerativeModel {
+	model := (&genai.Client{}).GenerativeModel("gemini-test")
+	model.SetTemperature(0.2)
+	model.SetMaxOutputTokens(8192)
+	model.StopSequences = []string{"<END>"}
+	model.ResponseMIMEType = "text/plain"
+	model.SystemInstruction = genai.NewUserContent(genai.Text("Translate the page to markdown."))
+	return model
+}
+
+func TestNewAuditRecord(t *testing.T) {
+	model := auditTestModel()
+	parts := []genai.Part{
+		genai.Text("Translate page 3."),
+		genai.FileData{MIMEType: "application/pdf", FileURI: "gs://pages/doc-1/00003.pdf"},
+		genai.Blob{MIMEType: "application/pdf", Data: pageContent},
+	}
+	resp := &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{
+			Content:       genai.NewUserContent(genai.Text("# Pump\n"), genai.Text("Text.")),
+			FinishReason:  genai.FinishReasonStop,
+			SafetyRatings: []*genai.SafetyRating{nil, {Category: genai.HarmCategoryDangerousContent, Probability: genai.HarmProbabilityLow}},
+		}},
+		UsageMetadata: &genai.UsageMetadata{PromptTokenCount: 1200, CandidatesTokenCount: 300, TotalTokenCount: 1500},
+	}
+	startedAt := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	record := NewAuditRecord(model, parts, resp, nil, startedAt, startedAt.Add(1500*time.Millisecond))
+
+	sum := sha256.Sum256(pageContent)
+	wantPrompt := []AuditPart{
+		{Type: "text", Text: "Translate page 3."},
+		{Type: "file", MIMEType: "application/pdf", FileURI: "gs://pages/doc-1/00003.pdf"},
+		{Type: "blob", MIMEType: "application/pdf", SHA256: hex.EncodeToString(sum[:]), Size: len(pageContent)},
+	}
+	if !slices.Equal(record.Prompt, wantPrompt) {
+		t.Errorf("Prompt = %+v, want %+v", record.Prompt, wantPrompt)
+	}
+	if want := []AuditPart{{Type: "text", Text: "Translate the page to markdown."}}; !slices.Equal(record.SystemInstruction, want) {
+		t.Errorf("SystemInstruction = %+v, want %+v", record.SystemInstruction, want)
+	}
+	if record.Model != "gemini-test" || record.Response != "# Pump\nText." || record.FinishReason != genai.FinishReasonStop.String() {
+		t.Errorf("record = model %q, response %q, finish reason %q", record.Model, record.Response, record.FinishReason)
+	}
+	config := record.GenerationConfig
+	if config.Temperature == nil || *config.Temperature != 0.2 || config.MaxOutputTokens == nil || *config.MaxOutputTokens != 8192 ||
+		!slices.Equal(config.StopSequences, []string{"<END>"}) || config.ResponseMIMEType != "text/plain" || config.TopK != nil {
+		t.Errorf("GenerationConfig = %+v", config)
+	}
+	wantRatings := []AuditSafetyRating{{Category: genai.HarmCategoryDangerousContent.String(), Probability: genai.HarmProbabilityLow.String(), Severity: genai.HarmSeverityUnspecified.String()}}
+	if !slices.Equal(record.SafetyRatings, wantRatings) {
+		t.Errorf("SafetyRatings = %+v, want %+v", record.SafetyRatings, wantRatings)
+	}
+	if want := (models.TokenUsage{PromptTokens: 1200, CandidatesTokens: 300, TotalTokens: 1500}); record.Usage == nil || *record.Usage != want {
+		t.Errorf("Usage = %+v, want %+v", record.Usage, want)
+	}
+	if record.LatencyMs != 1500 || !record.StartedAt.Equal(startedAt) || record.Error != "" || record.BlockReason != "" {
+		t.Errorf("record = %+v", record)
+	}
+	assertNoPageContent(t, "audit record", mustMarshal(t, record))
+}
+
+func TestNewAuditRecordBlocked(t *testing.T) {
+	blocked := &genai.BlockedError{PromptFeedback: &genai.PromptFeedback{
+		BlockReason:   genai.BlockedReasonSafety,
+		SafetyRatings: []*genai.SafetyRating{{Category: genai.HarmCategoryDangerousContent, Probability: genai.HarmProbabilityHigh, Blocked: true}},
+	}}
+	startedAt := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	record := NewAuditRecord(auditTestModel(), []genai.Part{genai.Blob{MIMEType: "application/pdf", Data: pageContent}}, nil, blocked, startedAt, startedAt)
+
+	if record.BlockReason != genai.BlockedReasonSafety.String() || len(record.PromptSafetyRatings) != 1 || !record.PromptSafetyRatings[0].Blocked {
+		t.Errorf("record = block reason %q, prompt ratings %+v", record.BlockReason, record.PromptSafetyRatings)
+	}
+	if record.Error != blocked.Error() || record.Response != "" || record.FinishReason != "" || record.Usage != nil {
+		t.Errorf("record = %+v", record)
+	}
+	assertNoPageContent(t, "audit record", mustMarshal(t, record))
+}
+
+func TestAuditorWriteDoesNotLogPageContent(t *testing.T) {
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	store := objectstore.NewMemory()
+	auditor := &Auditor{bucket: store.Bucket("audit"), writeTimeout: time.Second}
+	startedAt := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	record := NewAuditRecord(auditTestModel(), []genai.Part{genai.Blob{MIMEType: "application/pdf", Data: pageContent}}, nil, errors.New("quota exceeded"), startedAt, startedAt)
+	record.Stage, record.DocumentID, record.Unit = "translator", "doc-1", "00003"
+
+	auditor.write(context.Background(), record)
+	data, ok := store.Get("audit", "doc-1/audit/translator/00003-20240506T070809.000000000Z.json")
+	if !ok {
+		t.Fatalf("audit record not written; logs:\n%s", logs.String())
+	}
+	assertNoPageContent(t, "stored audit record", data)
+
+	store.FailWrites(errors.New("bucket unavailable"))
+	auditor.write(context.Background(), record)
+	if !strings.Contains(logs.String(), "Failed to write audit record") {
+		t.Errorf("failed write not logged; logs:\n%s", logs.String())
+	}
+	assertNoPageContent(t, "logs", logs.Bytes())
+}
+
+func mustMarshal(t *testing.T, record AuditRecord) []byte {
+	t.Helper()
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// assertNoPageContent fails the test if data holds pageContent, raw or base64-encoded.
+func assertNoPageContent(t *testing.T, what string, data []byte) {
+	t.Helper()
+	for _, encoded := range []string{"CONFIDENTIAL", base64.StdEncoding.EncodeToString(pageContent)} {
+		if strings.Contains(string(data), encoded) {
+			t.Errorf("%s holds page content:\n%s", what, data)
+		}
+	}
+}
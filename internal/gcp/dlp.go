@@ -0,0 +1,79 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/dlp/v2"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Redactor replaces sensitive content in text. DLPClient implements it; tests can
+// substitute a fake.
+type Redactor interface {
+	// Redact returns text with each finding replaced by its info type, such as
+	// "[EMAIL_ADDRESS]", and the number of findings replaced. location is the region
+	// the text is processed in, or "" for the global endpoint.
+	Redact(ctx context.Context, text, location string) (string, int, error)
+}
+
+// DLPClient redacts text with the Cloud DLP API.
+type DLPClient struct {
+	service   *dlp.Service
+	projectID string
+	infoTypes []string
+}
+
+// NewDLPClient creates a client redacting infoTypes, such as "PERSON_NAME", in
+// projectID.
+func NewDLPClient(ctx context.Context, projectID string, infoTypes []string) (*DLPClient, error) {
+	if len(infoTypes) == 0 {
+		return nil, fmt.Errorf("NewDLPClient: at least one info type is required")
+	}
+	service, err := dlp.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DLP client: %w", err)
+	}
+	return &DLPClient{service: service, projectID: projectID, infoTypes: infoTypes}, nil
+}
+
+// Redact implements Redactor.
+func (c *DLPClient) Redact(ctx context.Context, text, location string) (string, int, error) {
+	if location == "" {
+		location = "global"
+	}
+	infoTypes := make([]*dlp.GooglePrivacyDlpV2InfoType, len(c.infoTypes))
+	for i, name := range c.infoTypes {
+		infoTypes[i] = &dlp.GooglePrivacyDlpV2InfoType{Name: name}
+	}
+	req := &dlp.GooglePrivacyDlpV2DeidentifyContentRequest{
+		Item:          &dlp.GooglePrivacyDlpV2ContentItem{Value: text},
+		InspectConfig: &dlp.GooglePrivacyDlpV2InspectConfig{InfoTypes: infoTypes},
+		DeidentifyConfig: &dlp.GooglePrivacyDlpV2DeidentifyConfig{
+			InfoTypeTransformations: &dlp.GooglePrivacyDlpV2InfoTypeTransformations{
+				Transformations: []*dlp.GooglePrivacyDlpV2InfoTypeTransformation{{
+					PrimitiveTransformation: &dlp.GooglePrivacyDlpV2PrimitiveTransformation{
+						ReplaceWithInfoTypeConfig: &dlp.GooglePrivacyDlpV2ReplaceWithInfoTypeConfig{},
+					},
+				}},
+			},
+		},
+	}
+	parent := fmt.Sprintf("projects/%s/locations/%s", c.projectID, location)
+	resp, err := c.service.Projects.Locations.Content.Deidentify(parent, req).Context(ctx).Do()
+	if err != nil {
+		return "", 0, fmt.Errorf("DLP de-identification failed: %w", err)
+	}
+	if resp.Item == nil {
+		return "", 0, fmt.Errorf("DLP de-identification returned no content")
+	}
+	findings := 0
+	if resp.Overview != nil {
+		for _, summary := range resp.Overview.TransformationSummaries {
+			for _, result := range summary.Results {
+				findings += int(result.Count)
+			}
+		}
+	}
+	return resp.Item.Value, findings, nil
+}
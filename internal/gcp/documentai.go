@@ -0,0 +1,58 @@
+package gcp
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/documentai/v1"
+	"google.golang.org/api/option"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// OCRClient reads the text of a PDF. DocumentAIClient implements it; tests can substitute
+// a fake.
+type OCRClient interface {
+	OCR(ctx context.Context, pdf []byte) (string, error)
+}
+
+// DocumentAIClient runs PDFs through a Document AI OCR processor.
+type DocumentAIClient struct {
+	service   *documentai.Service
+	processor string
+}
+
+// NewDocumentAIClient creates a client of processor, given by its full resource name:
+// "projects/{project}/locations/{location}/processors/{id}". Processors are served from
+// their location's regional endpoint.
+func NewDocumentAIClient(ctx context.Context, processor string) (*DocumentAIClient, error) {
+	parts := strings.Split(processor, "/")
+	if len(parts) != 6 || parts[0] != "projects" || parts[2] != "locations" || parts[4] != "processors" || parts[1] == "" || parts[3] == "" || parts[5] == "" {
+		return nil, fmt.Errorf("invalid Document AI processor %q: expected projects/{project}/locations/{location}/processors/{id}", processor)
+	}
+	endpoint := fmt.Sprintf("https://%s-documentai.googleapis.com/", parts[3])
+	service, err := documentai.NewService(ctx, option.WithEndpoint(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Document AI client: %w", err)
+	}
+	return &DocumentAIClient{service: service, processor: processor}, nil
+}
+
+// OCR implements OCRClient.
+func (c *DocumentAIClient) OCR(ctx context.Context, pdf []byte) (string, error) {
+	req := &documentai.GoogleCloudDocumentaiV1ProcessRequest{
+		RawDocument: &documentai.GoogleCloudDocumentaiV1RawDocument{
+			Content:  base64.StdEncoding.EncodeToString(pdf),
+			MimeType: "application/pdf",
+		},
+	}
+	resp, err := c.service.Projects.Locations.Processors.Process(c.processor, req).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("document AI processing failed: %w", err)
+	}
+	if resp.Document == nil {
+		return "", nil
+	}
+	return resp.Document.Text, nil
+}
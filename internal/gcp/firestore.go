@@ -3,9 +3,14 @@ package gcp
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"cloud.google.com/go/firestore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 		_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
 )
 
@@ -22,4 +27,65 @@ func NewFirestoreClient(ctx context.Context, projectID string) (*firestore.Clien
 	}
 
 	return client, nil
-}
\ No newline at end of file
+}
+
+// Retry settings for UpdateWithRetry. Each attempt has its own deadline, so the writes
+// of one update take at most about 22 seconds.
+const (
+	updateAttempts       = 4
+	updateAttemptTimeout = 5 * time.Second
+	updateRetryBackoff   = 250 * time.Millisecond
+)
+
+// UpdateWithRetry applies updates to docRef independently of ctx's cancellation and
+// deadline, so that a status can still be recorded after the request that produced it
+// was cancelled or ran out of time. Unavailable and DeadlineExceeded errors are retried.
+// A write whose reply is lost may have been applied, so updates must be idempotent:
+// plain values and ArrayUnion are, Increment is not.
+func UpdateWithRetry(ctx context.Context, docRef *firestore.DocumentRef, updates []firestore.Update) error {
+	ctx = context.WithoutCancel(ctx)
+	backoff := updateRetryBackoff
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, updateAttemptTimeout)
+		_, err := docRef.Update(attemptCtx, updates)
+		cancel()
+		if err == nil || attempt == updateAttempts || !isRetryableUpdateError(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// isRetryableUpdateError reports whether a failed update may succeed if made again.
+func isRetryableUpdateError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// AppendStatusTransition sets a document's status and appends the transition to its
+// statusHistory in a single write, along with any extra updates. Each entry carries its
+// own timestamp, so ArrayUnion never drops a repeated transition, and a retried write
+// never appends one twice. The write is made with UpdateWithRetry.
+func AppendStatusTransition(ctx context.Context, docRef *firestore.DocumentRef, status, stage, detail string, extra ...firestore.Update) error {
+	transition := models.StatusTransition{
+		Status:    status,
+		Timestamp: time.Now(),
+		Stage:     stage,
+		Detail:    detail,
+	}
+	updates := append([]firestore.Update{
+		{Path: "status", Value: status},
+		{Path: "statusHistory", Value: firestore.ArrayUnion(transition)},
+	}, extra...)
+	if err := UpdateWithRetry(ctx, docRef, updates); err != nil {
+		return fmt.Errorf("failed to record status %s: %w", status, err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+//go:build integration
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatusWrittenAfterTheRequestEnds(t *testing.T) {
+	ctx := context.Background()
+	client, _ := testsupport.RequireEmulators(t).Clients(ctx, t)
+	collection := client.Collection(fmt.Sprintf("documents-%d", time.Now().UnixNano()))
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	expired, cancelExpired := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancelExpired()
+	tests := []struct {
+		name string
+		ctx  context.Context
+	}{
+		{name: "cancelled", ctx: cancelled},
+		{name: "deadline exceeded", ctx: expired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docRef := collection.Doc(tt.name)
+			if _, err := docRef.Set(ctx, map[string]interface{}{"status": "TRANSLATING"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := UpdateWithRetry(tt.ctx, docRef, []firestore.Update{{Path: "errorDetails", Value: "request timed out"}}); err != nil {
+				t.Fatalf("UpdateWithRetry() error = %v", err)
+			}
+			if err := AppendStatusTransition(tt.ctx, docRef, "FAILED", models.TimingStageAggregator, "request timed out"); err != nil {
+				t.Fatalf("AppendStatusTransition() error = %v", err)
+			}
+
+			snap, err := docRef.Get(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var doc models.Document
+			if err := snap.DataTo(&doc); err != nil {
+				t.Fatal(err)
+			}
+			if doc.Status != "FAILED" || doc.ErrorDetails != "request timed out" {
+				t.Errorf("document = status %q, details %q; want both written", doc.Status, doc.ErrorDetails)
+			}
+			if len(doc.StatusHistory) != 1 || doc.StatusHistory[0].Status != "FAILED" || doc.StatusHistory[0].Stage != models.TimingStageAggregator {
+				t.Errorf("statusHistory = %+v, want the FAILED transition", doc.StatusHistory)
+			}
+		})
+	}
+}
+
+func TestUpdateWithRetryDoesNotRetryAMissingDocument(t *testing.T) {
+	ctx := context.Background()
+	client, _ := testsupport.RequireEmulators(t).Clients(ctx, t)
+	docRef := client.Collection(fmt.Sprintf("documents-%d", time.Now().UnixNano())).Doc("doc-missing")
+
+	start := time.Now()
+	err := UpdateWithRetry(ctx, docRef, []firestore.Update{{Path: "status", Value: "FAILED"}})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("UpdateWithRetry() of a missing document = %v, want NotFound", err)
+	}
+	if elapsed := time.Since(start); elapsed >= updateRetryBackoff {
+		t.Errorf("UpdateWithRetry() took %v, want no retry", elapsed)
+	}
+}
@@ -0,0 +1,34 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsRetryableUpdateError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "unavailable", err: status.Error(codes.Unavailable, "connection reset"), want: true},
+		{name: "server deadline", err: status.Error(codes.DeadlineExceeded, "deadline exceeded"), want: true},
+		{name: "attempt deadline", err: fmt.Errorf("update: %w", context.DeadlineExceeded), want: true},
+		{name: "cancelled", err: context.Canceled},
+		{name: "not found", err: status.Error(codes.NotFound, "no document")},
+		{name: "permission denied", err: status.Error(codes.PermissionDenied, "denied")},
+		{name: "other", err: errors.New("invalid update")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableUpdateError(tt.err); got != tt.want {
+				t.Errorf("isRetryableUpdateError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package gcp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// ErrLeaseHeld is returned by AcquireLease when another holder's lease on the key has
+// not expired yet.
+var ErrLeaseHeld = errors.New("lease is held by another holder")
+
+// ErrLeaseLost is returned by Renew when the lease expired and was taken by another
+// holder, or was removed.
+var ErrLeaseLost = errors.New("lease is no longer held")
+
+// Lease is a claim on a key that lasts until ExpiresAt, so that a holder that crashes
+// without releasing it only blocks the key for one ttl.
+type Lease struct {
+	Key       string
+	Holder    string
+	ExpiresAt time.Time
+	client    *firestore.Client
+	ref       *firestore.DocumentRef
+}
+
+// leaseRecord is the Firestore document of a lease.
+type leaseRecord struct {
+	Key        string    `firestore:"key"`
+	Holder     string    `firestore:"holder"`
+	AcquiredAt time.Time `firestore:"acquiredAt"`
+	ExpiresAt  time.Time `firestore:"expiresAt"`
+}
+
+// AcquireLease claims key for ttl in a transaction on a document of collection, failing
+// with ErrLeaseHeld if another holder's lease has not expired. An expired lease is taken
+// over. The document ID is a hash of key, so any string may be used as one.
+func AcquireLease(ctx context.Context, client *firestore.Client, collection, key string, ttl time.Duration) (*Lease, error) {
+	holder, err := newLeaseHolder()
+	if err != nil {
+		return nil, err
+	}
+	ref := client.Collection(collection).Doc(leaseDocID(key))
+	var expiresAt time.Time
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		now := time.Now()
+		if snap.Exists() {
+			var current leaseRecord
+			if err := snap.DataTo(&current); err != nil {
+				return fmt.Errorf("failed to decode lease: %w", err)
+			}
+			if now.Before(current.ExpiresAt) {
+				return fmt.Errorf("%w until %s", ErrLeaseHeld, current.ExpiresAt.Format(time.RFC3339))
+			}
+		}
+		expiresAt = now.Add(ttl)
+		return tx.Set(ref, leaseRecord{Key: key, Holder: holder, AcquiredAt: now, ExpiresAt: expiresAt})
+	})
+	if err != nil {
+		if errors.Is(err, ErrLeaseHeld) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to acquire lease on %s: %w", key, err)
+	}
+	return &Lease{Key: key, Holder: holder, ExpiresAt: expiresAt, client: client, ref: ref}, nil
+}
+
+// Renew extends the lease to ttl from now, failing with ErrLeaseLost if it is no longer
+// held. A lease that expired but was not taken over is still held.
+func (l *Lease) Renew(ctx context.Context, ttl time.Duration) error {
+	var expiresAt time.Time
+	err := l.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		held, err := l.heldIn(tx)
+		if err != nil || !held {
+			return err
+		}
+		expiresAt = time.Now().Add(ttl)
+		return tx.Update(l.ref, []firestore.Update{{Path: "expiresAt", Value: expiresAt}})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to renew lease on %s: %w", l.Key, err)
+	}
+	if expiresAt.IsZero() {
+		return fmt.Errorf("failed to renew lease on %s: %w", l.Key, ErrLeaseLost)
+	}
+	l.ExpiresAt = expiresAt
+	return nil
+}
+
+// Release gives the lease up, unless it has already been taken over. Like status writes,
+// it is made even if ctx was cancelled.
+func (l *Lease) Release(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), updateAttemptTimeout)
+	defer cancel()
+	err := l.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		held, err := l.heldIn(tx)
+		if err != nil || !held {
+			return err
+		}
+		return tx.Delete(l.ref)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release lease on %s: %w", l.Key, err)
+	}
+	return nil
+}
+
+// heldIn reports, within tx, whether the lease document still names this holder.
+func (l *Lease) heldIn(tx *firestore.Transaction) (bool, error) {
+	snap, err := tx.Get(l.ref)
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var current leaseRecord
+	if err := snap.DataTo(&current); err != nil {
+		return false, fmt.Errorf("failed to decode lease: %w", err)
+	}
+	return current.Holder == l.Holder, nil
+}
+
+// leaseDocID returns the document ID of key's lease. Keys may hold slashes, which
+// document IDs cannot.
+func leaseDocID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newLeaseHolder returns a random ID for a new holder.
+func newLeaseHolder() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate lease holder: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,112 @@
+//go:build integration
+
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestAcquireLease(t *testing.T) {
+	ctx := context.Background()
+	client, _ := testsupport.RequireEmulators(t).Clients(ctx, t)
+	collection := fmt.Sprintf("leases-%d", time.Now().UnixNano())
+	const key = "gs://uploads/in/spec.pdf#1"
+
+	first, err := AcquireLease(ctx, client, collection, key, time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLease() error = %v", err)
+	}
+	if _, err := AcquireLease(ctx, client, collection, key, time.Minute); !errors.Is(err, ErrLeaseHeld) {
+		t.Fatalf("AcquireLease() of a held key error = %v, want ErrLeaseHeld", err)
+	}
+	other, err := AcquireLease(ctx, client, collection, "gs://uploads/in/spec.pdf#2", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLease() of another generation error = %v", err)
+	}
+	if err := other.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.Renew(ctx, time.Minute); err != nil {
+		t.Errorf("Renew() error = %v", err)
+	}
+	if err := first.Release(ctx); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	again, err := AcquireLease(ctx, client, collection, key, time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLease() after Release() error = %v", err)
+	}
+	if err := again.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireLeaseTakesOverAnExpiredLease(t *testing.T) {
+	ctx := context.Background()
+	client, _ := testsupport.RequireEmulators(t).Clients(ctx, t)
+	collection := fmt.Sprintf("leases-%d", time.Now().UnixNano())
+	const key = "gs://uploads/in/spec.pdf#1"
+
+	crashed, err := AcquireLease(ctx, client, collection, key, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	successor, err := AcquireLease(ctx, client, collection, key, time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLease() of an expired lease error = %v", err)
+	}
+
+	if err := crashed.Renew(ctx, time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Renew() of a taken-over lease error = %v, want ErrLeaseLost", err)
+	}
+	// Releasing a taken-over lease must leave the successor's in place.
+	if err := crashed.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AcquireLease(ctx, client, collection, key, time.Minute); !errors.Is(err, ErrLeaseHeld) {
+		t.Errorf("AcquireLease() after the old holder released error = %v, want ErrLeaseHeld", err)
+	}
+	if err := successor.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireLeaseHasOneWinner(t *testing.T) {
+	ctx := context.Background()
+	client, _ := testsupport.RequireEmulators(t).Clients(ctx, t)
+	collection := fmt.Sprintf("leases-%d", time.Now().UnixNano())
+	const instances = 8
+
+	var wg sync.WaitGroup
+	errs := make([]error, instances)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = AcquireLease(ctx, client, collection, "gs://uploads/in/spec.pdf#1", time.Minute)
+		}()
+	}
+	wg.Wait()
+
+	acquired := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			acquired++
+		case !errors.Is(err, ErrLeaseHeld):
+			t.Errorf("AcquireLease() error = %v, want nil or ErrLeaseHeld", err)
+		}
+	}
+	if acquired != 1 {
+		t.Errorf("%d instances acquired the lease, want 1", acquired)
+	}
+}
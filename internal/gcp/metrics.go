@@ -0,0 +1,128 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/vertexai/genai"
+	mexporter "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/metric"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// metricsFlushInterval is the least time between two flushes. Cloud Monitoring rejects
+// points of a time series written more often than every five seconds.
+const metricsFlushInterval = 10 * time.Second
+
+var (
+	metricsOnce   sync.Once
+	metricsErr    error
+	meterProvider *sdkmetric.MeterProvider // Nil while metrics are disabled.
+	flushMu       sync.Mutex
+	lastFlush     time.Time
+)
+
+// The pipeline's instruments. They are created against the global meter provider, so
+// they record nothing until InitMetrics or SetMeterProvider installs one.
+var (
+	meter                  = otel.Meter(TracerName)
+	pagesTranslated, _     = meter.Int64Counter("pages_translated_total", metric.WithDescription("Page translations finished, by response status."))
+	geminiLatency, _       = meter.Float64Histogram("gemini_latency_ms", metric.WithDescription("Duration of Gemini calls, by stage and gRPC status code."), metric.WithUnit("ms"))
+	gcsUploadRetries, _    = meter.Int64Counter("gcs_upload_retries_total", metric.WithDescription("Split page uploads retried after a failed attempt."))
+	documentsCompleted, _  = meter.Int64Counter("documents_completed_total", metric.WithDescription("Documents marked COMPLETE."))
+	aggregationDuration, _ = meter.Float64Histogram("aggregation_duration_ms", metric.WithDescription("Duration of successful aggregations."), metric.WithUnit("ms"))
+)
+
+// InitMetrics installs a meter provider that exports to Cloud Monitoring when
+// ENABLE_METRICS is "true". Otherwise the instruments are no-ops and no credentials are
+// needed. Only the first call has any effect, so every service constructor may call it.
+func InitMetrics(ctx context.Context, projectID string) error {
+	metricsOnce.Do(func() {
+		if GetEnv("ENABLE_METRICS", "false") != "true" {
+			return
+		}
+		exporter, err := mexporter.New(mexporter.WithProjectID(projectID))
+		if err != nil {
+			metricsErr = fmt.Errorf("failed to create Cloud Monitoring exporter: %w", err)
+			return
+		}
+		SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter))))
+		slog.Info("Metrics enabled.", "exporter", "cloudmonitoring")
+	})
+	return metricsErr
+}
+
+// SetMeterProvider installs provider as the global meter provider flushed by FlushMetrics.
+// InitMetrics calls it; tests can use it to install a manual reader.
+func SetMeterProvider(provider *sdkmetric.MeterProvider) {
+	meterProvider = provider
+	otel.SetMeterProvider(provider)
+}
+
+// FlushMetrics exports the metrics recorded so far, unless they were exported less than
+// metricsFlushInterval ago. Like FlushTraces it is called before each request returns. A
+// failed export is only logged.
+func FlushMetrics(ctx context.Context) {
+	if meterProvider == nil {
+		return
+	}
+	flushMu.Lock()
+	if time.Since(lastFlush) < metricsFlushInterval {
+		flushMu.Unlock()
+		return
+	}
+	lastFlush = time.Now()
+	flushMu.Unlock()
+	if err := meterProvider.ForceFlush(ctx); err != nil {
+		slog.Warn("Failed to flush metrics", "error", err)
+	}
+}
+
+// RecordPageTranslated counts a finished page translation. status is the response status,
+// or "error" for a request that failed.
+func RecordPageTranslated(ctx context.Context, status string) {
+	pagesTranslated.Add(ctx, 1, metric.WithAttributes(attribute.String("status", status)))
+}
+
+// RecordGCSUploadRetry counts an upload attempt that failed and is retried.
+func RecordGCSUploadRetry(ctx context.Context) {
+	gcsUploadRetries.Add(ctx, 1)
+}
+
+// RecordDocumentCompleted counts a document marked COMPLETE.
+func RecordDocumentCompleted(ctx context.Context) {
+	documentsCompleted.Add(ctx, 1)
+}
+
+// RecordAggregationDuration records how long a successful aggregation took.
+func RecordAggregationDuration(ctx context.Context, d time.Duration) {
+	aggregationDuration.Record(ctx, milliseconds(d))
+}
+
+// meteredGenerator records the latency of each call of a stage's model.
+type meteredGenerator struct {
+	model ContentGenerator
+	stage string
+}
+
+// GenerateContent implements ContentGenerator.
+func (g *meteredGenerator) GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	start := time.Now()
+	resp, err := g.model.GenerateContent(ctx, parts...)
+	geminiLatency.Record(ctx, milliseconds(time.Since(start)), metric.WithAttributes(
+		attribute.String("stage", g.stage),
+		attribute.String("code", status.Code(err).String()),
+	))
+	return resp, err
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,147 @@
+package gcp
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/vertexai/genai"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	testReaderOnce sync.Once
+	testReader     *sdkmetric.ManualReader
+)
+
+// metricsReader installs a meter provider read by a manual reader, once for the whole
+// test binary: the instruments are bound to the first provider installed after them.
+// Values are cumulative, so tests compare them before and after recording.
+func metricsReader() *sdkmetric.ManualReader {
+	testReaderOnce.Do(func() {
+		testReader = sdkmetric.NewManualReader()
+		SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(testReader)))
+	})
+	return testReader
+}
+
+// metricValue returns the count and sum of the named metric's point with attrs: a
+// counter's count is its value, and a histogram's is its number of recordings.
+func metricValue(t *testing.T, reader *sdkmetric.ManualReader, name string, attrs ...attribute.KeyValue) (uint64, float64) {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("failed to collect metrics: %v", err)
+	}
+	set := attribute.NewSet(attrs...)
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if m.Name != name {
+				continue
+			}
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, point := range data.DataPoints {
+					if point.Attributes.Equals(&set) {
+						return uint64(point.Value), float64(point.Value)
+					}
+				}
+			case metricdata.Histogram[float64]:
+				for _, point := range data.DataPoints {
+					if point.Attributes.Equals(&set) {
+						return point.Count, point.Sum
+					}
+				}
+			default:
+				t.Fatalf("metric %s is a %T", name, m.Data)
+			}
+		}
+	}
+	return 0, 0
+}
+
+func TestRecordMetrics(t *testing.T) {
+	reader := metricsReader()
+	ctx := context.Background()
+	success := attribute.String("status", "success")
+	failed := attribute.String("status", "error")
+	successBefore, _ := metricValue(t, reader, "pages_translated_total", success)
+	failedBefore, _ := metricValue(t, reader, "pages_translated_total", failed)
+	retriesBefore, _ := metricValue(t, reader, "gcs_upload_retries_total")
+	completedBefore, _ := metricValue(t, reader, "documents_completed_total")
+	aggregationsBefore, aggregationMsBefore := metricValue(t, reader, "aggregation_duration_ms")
+
+	RecordPageTranslated(ctx, "success")
+	RecordPageTranslated(ctx, "success")
+	RecordPageTranslated(ctx, "error")
+	RecordGCSUploadRetry(ctx)
+	RecordDocumentCompleted(ctx)
+	RecordAggregationDuration(ctx, 1500*time.Millisecond)
+
+	if got, _ := metricValue(t, reader, "pages_translated_total", success); got-successBefore != 2 {
+		t.Errorf("pages_translated_total{status=success} rose by %d, want 2", got-successBefore)
+	}
+	if got, _ := metricValue(t, reader, "pages_translated_total", failed); got-failedBefore != 1 {
+		t.Errorf("pages_translated_total{status=error} rose by %d, want 1", got-failedBefore)
+	}
+	if got, _ := metricValue(t, reader, "gcs_upload_retries_total"); got-retriesBefore != 1 {
+		t.Errorf("gcs_upload_retries_total rose by %d, want 1", got-retriesBefore)
+	}
+	if got, _ := metricValue(t, reader, "documents_completed_total"); got-completedBefore != 1 {
+		t.Errorf("documents_completed_total rose by %d, want 1", got-completedBefore)
+	}
+	count, sum := metricValue(t, reader, "aggregation_duration_ms")
+	if count-aggregationsBefore != 1 || sum-aggregationMsBefore != 1500 {
+		t.Errorf("aggregation_duration_ms rose by %d recordings of %gms, want one of 1500ms", count-aggregationsBefore, sum-aggregationMsBefore)
+	}
+}
+
+// stubGenerator answers every call with err after delay.
+type stubGenerator struct {
+	delay time.Duration
+	err   error
+}
+
+func (g stubGenerator) GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	time.Sleep(g.delay)
+	if g.err != nil {
+		return nil, g.err
+	}
+	return &genai.GenerateContentResponse{}, nil
+}
+
+func TestMeteredGeneratorRecordsLatency(t *testing.T) {
+	reader := metricsReader()
+	tests := []struct {
+		name     string
+		model    stubGenerator
+		wantCode codes.Code
+	}{
+		{name: "success", model: stubGenerator{delay: 5 * time.Millisecond}, wantCode: codes.OK},
+		{name: "quota", model: stubGenerator{delay: 5 * time.Millisecond, err: status.Error(codes.ResourceExhausted, "quota")}, wantCode: codes.ResourceExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs := []attribute.KeyValue{attribute.String("stage", "cleaner"), attribute.String("code", tt.wantCode.String())}
+			countBefore, sumBefore := metricValue(t, reader, "gemini_latency_ms", attrs...)
+
+			model := &meteredGenerator{model: tt.model, stage: "cleaner"}
+			if _, err := model.GenerateContent(context.Background(), genai.Text("clean")); status.Code(err) != tt.wantCode {
+				t.Fatalf("GenerateContent() error = %v, want code %s passed through", err, tt.wantCode)
+			}
+
+			count, sum := metricValue(t, reader, "gemini_latency_ms", attrs...)
+			if count-countBefore != 1 {
+				t.Errorf("gemini_latency_ms{stage=cleaner,code=%s} rose by %d recordings, want 1", tt.wantCode, count-countBefore)
+			}
+			if latency := sum - sumBefore; latency < 5 {
+				t.Errorf("recorded latency = %gms, want at least the call's 5ms", latency)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewGCS returns a Client backed by a Cloud Storage client.
+func NewGCS(client *storage.Client) Client {
+	return gcsClient{client: client}
+}
+
+// WrapBucket returns a Bucket backed by an existing Cloud Storage bucket handle.
+func WrapBucket(handle *storage.BucketHandle) Bucket {
+	return gcsBucket{handle: handle}
+}
+
+type gcsClient struct {
+	client *storage.Client
+}
+
+func (c gcsClient) Bucket(name string) Bucket {
+	return gcsBucket{handle: c.client.Bucket(name)}
+}
+
+type gcsBucket struct {
+	handle *storage.BucketHandle
+}
+
+func (b gcsBucket) Name() string {
+	return b.handle.BucketName()
+}
+
+func (b gcsBucket) NewReader(ctx context.Context, object string) (Reader, error) {
+	reader, err := b.handle.Object(object).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return gcsReader{reader}, nil
+}
+
+func (b gcsBucket) NewWriter(ctx context.Context, object string, opts WriterOptions) Writer {
+	obj := b.handle.Object(object)
+	if opts.DoesNotExist {
+		obj = obj.If(storage.Conditions{DoesNotExist: true})
+	}
+	writer := obj.NewWriter(ctx)
+	writer.ContentType = opts.ContentType
+	writer.ContentEncoding = opts.ContentEncoding
+	writer.Metadata = opts.Metadata
+	writer.SendCRC32C = opts.SendCRC32C
+	writer.CRC32C = opts.CRC32C
+	switch {
+	case opts.ChunkSize > 0:
+		writer.ChunkSize = opts.ChunkSize
+	case opts.ChunkSize < 0:
+		writer.ChunkSize = 0
+	}
+	return gcsWriter{writer}
+}
+
+func (b gcsBucket) Attrs(ctx context.Context, object string) (*storage.ObjectAttrs, error) {
+	return b.handle.Object(object).Attrs(ctx)
+}
+
+func (b gcsBucket) List(ctx context.Context, prefix string) ([]*storage.ObjectAttrs, error) {
+	it := b.handle.Objects(ctx, &storage.Query{Prefix: prefix})
+	var objects []*storage.ObjectAttrs
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, attrs)
+	}
+}
+
+func (b gcsBucket) Delete(ctx context.Context, object string, generation int64) error {
+	obj := b.handle.Object(object)
+	if generation != 0 {
+		obj = obj.If(storage.Conditions{GenerationMatch: generation})
+	}
+	return mapPreconditionError(obj.Delete(ctx))
+}
+
+func (b gcsBucket) Copy(ctx context.Context, src, dst string) error {
+	_, err := b.handle.Object(dst).CopierFrom(b.handle.Object(src)).Run(ctx)
+	return err
+}
+
+type gcsReader struct {
+	*storage.Reader
+}
+
+func (r gcsReader) Size() int64 {
+	return r.Attrs.Size
+}
+
+// gcsWriter maps a failed DoesNotExist precondition, which the client may report from
+// either Write or Close, and over either the JSON or the gRPC API, to ErrPreconditionFailed.
+type gcsWriter struct {
+	*storage.Writer
+}
+
+func (w gcsWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	return n, mapPreconditionError(err)
+}
+
+func (w gcsWriter) Close() error {
+	return mapPreconditionError(w.Writer.Close())
+}
+
+func mapPreconditionError(err error) error {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed {
+		return fmt.Errorf("%w: %v", ErrPreconditionFailed, err)
+	}
+	if err != nil && status.Code(err) == codes.FailedPrecondition {
+		return fmt.Errorf("%w: %v", ErrPreconditionFailed, err)
+	}
+	return err
+}
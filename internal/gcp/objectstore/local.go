@@ -0,0 +1,364 @@
+package objectstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// localMetadataDir is the directory, at the top of each bucket, that holds the content
+// type and metadata of each object, so that the objects' own files contain only content.
+const localMetadataDir = ".objectstore"
+
+// Local is a Client backed by a directory on the local filesystem, for running the
+// pipeline without Cloud Storage. Each bucket is a subdirectory of the root and each
+// object a file under it. Files whose names start with a dot, such as writes in
+// progress, are not objects. Generations are the files' modification times in
+// nanoseconds.
+type Local struct {
+	root string
+}
+
+// localAttrs is the sidecar record of an object's attributes.
+type localAttrs struct {
+	ContentType     string            `json:"contentType,omitempty"`
+	ContentEncoding string            `json:"contentEncoding,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+}
+
+// NewLocal returns a store rooted at dir.
+func NewLocal(dir string) *Local {
+	return &Local{root: dir}
+}
+
+// Bucket implements Client. Buckets spring into existence on first write.
+func (l *Local) Bucket(name string) Bucket {
+	return localBucket{store: l, name: name}
+}
+
+type localBucket struct {
+	store *Local
+	name  string
+}
+
+// path returns the file holding object, rejecting names that would escape the bucket.
+func (b localBucket) path(object string) (string, error) {
+	if object == "" || !filepath.IsLocal(filepath.FromSlash(object)) {
+		return "", fmt.Errorf("objectstore: invalid local object name %q", object)
+	}
+	return filepath.Join(b.store.root, b.name, filepath.FromSlash(object)), nil
+}
+
+func (b localBucket) attrsPath(object string) string {
+	return filepath.Join(b.store.root, b.name, localMetadataDir, filepath.FromSlash(object)+".json")
+}
+
+// storedAttrs reads the sidecar record of an object's attributes. An object without one
+// has none.
+func (b localBucket) storedAttrs(object string) (localAttrs, error) {
+	var stored localAttrs
+	sidecar, err := os.ReadFile(b.attrsPath(object))
+	if errors.Is(err, fs.ErrNotExist) {
+		return stored, nil
+	}
+	if err != nil {
+		return stored, err
+	}
+	if err := json.Unmarshal(sidecar, &stored); err != nil {
+		return stored, fmt.Errorf("objectstore: corrupt attributes for %s: %w", object, err)
+	}
+	return stored, nil
+}
+
+func (b localBucket) Name() string {
+	return b.name
+}
+
+func (b localBucket) NewReader(ctx context.Context, object string) (Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	path, err := b.path(object)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", path, ErrObjectNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	stored, err := b.storedAttrs(object)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return decodeReader(localReader{File: file, size: info.Size()}, stored.ContentEncoding)
+}
+
+func (b localBucket) NewWriter(ctx context.Context, object string, opts WriterOptions) Writer {
+	return &localWriter{ctx: ctx, bucket: b, object: object, opts: opts}
+}
+
+func (b localBucket) Attrs(ctx context.Context, object string) (*storage.ObjectAttrs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	path, err := b.path(object)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", path, ErrObjectNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.objectAttrs(object, path, info)
+}
+
+func (b localBucket) objectAttrs(object, path string, info fs.FileInfo) (*storage.ObjectAttrs, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	attrs := &storage.ObjectAttrs{
+		Bucket:     b.name,
+		Name:       object,
+		Size:       info.Size(),
+		CRC32C:     crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)),
+		Generation: info.ModTime().UnixNano(),
+		Updated:    info.ModTime(),
+	}
+	stored, err := b.storedAttrs(object)
+	if err != nil {
+		return nil, err
+	}
+	attrs.ContentType = stored.ContentType
+	attrs.ContentEncoding = stored.ContentEncoding
+	attrs.Metadata = stored.Metadata
+	return attrs, nil
+}
+
+func (b localBucket) List(ctx context.Context, prefix string) ([]*storage.ObjectAttrs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(b.store.root, b.name)
+	var objects []*storage.ObjectAttrs
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == dir {
+			return fs.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), ".") && path != dir {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		object := filepath.ToSlash(rel)
+		if !strings.HasPrefix(object, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		attrs, err := b.objectAttrs(object, path, info)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, attrs)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("objectstore: failed to list %s: %w", dir, err)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects, nil
+}
+
+func (b localBucket) Delete(ctx context.Context, object string, generation int64) error {
+	attrs, err := b.Attrs(ctx, object)
+	if err != nil {
+		return err
+	}
+	path, _ := b.path(object)
+	if generation != 0 && attrs.Generation != generation {
+		return fmt.Errorf("%s: %w", path, ErrPreconditionFailed)
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if err := os.Remove(b.attrsPath(object)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Copy writes the stored bytes of src to dst through a writer, which moves them into
+// place in one rename.
+func (b localBucket) Copy(ctx context.Context, src, dst string) error {
+	if _, err := b.Attrs(ctx, src); err != nil {
+		return err
+	}
+	path, _ := b.path(src)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	stored, err := b.storedAttrs(src)
+	if err != nil {
+		return err
+	}
+	writer := b.NewWriter(ctx, dst, WriterOptions{
+		ContentType:     stored.ContentType,
+		ContentEncoding: stored.ContentEncoding,
+		Metadata:        stored.Metadata,
+	})
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+type localReader struct {
+	*os.File
+	size int64
+}
+
+func (r localReader) Size() int64 { return r.size }
+
+// localWriter writes to a temporary file and moves it into place on Close, so a partly
+// written object is never visible.
+type localWriter struct {
+	ctx    context.Context
+	bucket localBucket
+	object string
+	opts   WriterOptions
+	file   *os.File
+	crc    uint32
+	err    error
+	closed bool
+}
+
+func (w *localWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("objectstore: write to closed writer for %s", w.object)
+	}
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.file == nil {
+		if w.err = w.open(); w.err != nil {
+			return 0, w.err
+		}
+	}
+	w.crc = crc32.Update(w.crc, crc32.MakeTable(crc32.Castagnoli), p)
+	return w.file.Write(p)
+}
+
+// open creates the temporary file next to the object's final path.
+func (w *localWriter) open() error {
+	path, err := w.bucket.path(w.object)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	w.file, err = os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	return err
+}
+
+func (w *localWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.file == nil && w.err == nil {
+		// Nothing was written; the object is empty.
+		w.err = w.open()
+	}
+	if w.file != nil {
+		defer os.Remove(w.file.Name())
+		if err := w.file.Close(); err != nil && w.err == nil {
+			w.err = err
+		}
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+
+	path, _ := w.bucket.path(w.object)
+	if w.opts.SendCRC32C && w.crc != w.opts.CRC32C {
+		return fmt.Errorf("objectstore: %s: CRC32C mismatch: got %08x, want %08x", path, w.crc, w.opts.CRC32C)
+	}
+	if w.opts.DoesNotExist {
+		// A hard link fails if the target exists, which makes the check and the commit
+		// a single step.
+		if err := os.Link(w.file.Name(), path); err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				return fmt.Errorf("%s: %w", path, ErrPreconditionFailed)
+			}
+			return err
+		}
+	} else if err := os.Rename(w.file.Name(), path); err != nil {
+		return err
+	}
+	return w.writeAttrs()
+}
+
+// writeAttrs records the object's content type and metadata in its sidecar file.
+func (w *localWriter) writeAttrs() error {
+	path := w.bucket.attrsPath(w.object)
+	if w.opts.ContentType == "" && w.opts.ContentEncoding == "" && len(w.opts.Metadata) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(localAttrs{ContentType: w.opts.ContentType, ContentEncoding: w.opts.ContentEncoding, Metadata: w.opts.Metadata})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+var _ Client = (*Local)(nil)
@@ -0,0 +1,241 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Memory is an in-memory Client for tests. It is safe for concurrent use.
+type Memory struct {
+	mu         sync.Mutex
+	objects    map[string]map[string]*memoryObject // bucket -> object name -> object
+	generation int64
+	writeErrs  []error
+}
+
+type memoryObject struct {
+	data  []byte
+	attrs storage.ObjectAttrs
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{objects: make(map[string]map[string]*memoryObject)}
+}
+
+// Bucket implements Client. Buckets spring into existence on first use.
+func (m *Memory) Bucket(name string) Bucket {
+	return memoryBucket{store: m, name: name}
+}
+
+// Put stores an object directly, bypassing preconditions.
+func (m *Memory) Put(bucket, object string, data []byte, metadata map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(bucket, object, data, WriterOptions{Metadata: metadata})
+}
+
+// Get returns an object's content and whether it exists.
+func (m *Memory) Get(bucket, object string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.objects[bucket][object]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), obj.data...), true
+}
+
+// FailWrites makes the next len(errs) writer Close calls fail with the given errors, in
+// order, without storing anything.
+func (m *Memory) FailWrites(errs ...error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writeErrs = append(m.writeErrs, errs...)
+}
+
+func (m *Memory) put(bucket, object string, data []byte, opts WriterOptions) {
+	if m.objects[bucket] == nil {
+		m.objects[bucket] = make(map[string]*memoryObject)
+	}
+	m.generation++
+	copied := make(map[string]string, len(opts.Metadata))
+	for k, v := range opts.Metadata {
+		copied[k] = v
+	}
+	m.objects[bucket][object] = &memoryObject{
+		data: append([]byte(nil), data...),
+		attrs: storage.ObjectAttrs{
+			Bucket:          bucket,
+			Name:            object,
+			Size:            int64(len(data)),
+			CRC32C:          crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)),
+			ContentType:     opts.ContentType,
+			ContentEncoding: opts.ContentEncoding,
+			Metadata:        copied,
+			Generation:      m.generation,
+			Updated:         time.Now(),
+		},
+	}
+}
+
+type memoryBucket struct {
+	store *Memory
+	name  string
+}
+
+func (b memoryBucket) Name() string {
+	return b.name
+}
+
+func (b memoryBucket) NewReader(ctx context.Context, object string) (Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+	obj, ok := b.store.objects[b.name][object]
+	if !ok {
+		return nil, fmt.Errorf("gs://%s/%s: %w", b.name, object, ErrObjectNotExist)
+	}
+	return decodeReader(memoryReader{Reader: bytes.NewReader(obj.data), size: obj.attrs.Size}, obj.attrs.ContentEncoding)
+}
+
+func (b memoryBucket) NewWriter(ctx context.Context, object string, opts WriterOptions) Writer {
+	return &memoryWriter{ctx: ctx, bucket: b, object: object, opts: opts}
+}
+
+func (b memoryBucket) Attrs(ctx context.Context, object string) (*storage.ObjectAttrs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+	obj, ok := b.store.objects[b.name][object]
+	if !ok {
+		return nil, fmt.Errorf("gs://%s/%s: %w", b.name, object, ErrObjectNotExist)
+	}
+	attrs := obj.attrs
+	return &attrs, nil
+}
+
+func (b memoryBucket) List(ctx context.Context, prefix string) ([]*storage.ObjectAttrs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+	var objects []*storage.ObjectAttrs
+	for name, obj := range b.store.objects[b.name] {
+		if strings.HasPrefix(name, prefix) {
+			attrs := obj.attrs
+			objects = append(objects, &attrs)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects, nil
+}
+
+func (b memoryBucket) Delete(ctx context.Context, object string, generation int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+	obj, ok := b.store.objects[b.name][object]
+	if !ok {
+		return fmt.Errorf("gs://%s/%s: %w", b.name, object, ErrObjectNotExist)
+	}
+	if generation != 0 && obj.attrs.Generation != generation {
+		return fmt.Errorf("gs://%s/%s: %w", b.name, object, ErrPreconditionFailed)
+	}
+	delete(b.store.objects[b.name], object)
+	return nil
+}
+
+func (b memoryBucket) Copy(ctx context.Context, src, dst string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+	obj, ok := b.store.objects[b.name][src]
+	if !ok {
+		return fmt.Errorf("gs://%s/%s: %w", b.name, src, ErrObjectNotExist)
+	}
+	b.store.put(b.name, dst, obj.data, WriterOptions{
+		ContentType:     obj.attrs.ContentType,
+		ContentEncoding: obj.attrs.ContentEncoding,
+		Metadata:        obj.attrs.Metadata,
+	})
+	return nil
+}
+
+type memoryReader struct {
+	*bytes.Reader
+	size int64
+}
+
+func (r memoryReader) Close() error { return nil }
+
+func (r memoryReader) Size() int64 { return r.size }
+
+// memoryWriter buffers content and commits it on Close, mirroring the all-or-nothing
+// behaviour of a Cloud Storage upload.
+type memoryWriter struct {
+	ctx    context.Context
+	bucket memoryBucket
+	object string
+	opts   WriterOptions
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (w *memoryWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("objectstore: write to closed writer for %s", w.object)
+	}
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.buf.Write(p)
+}
+
+func (w *memoryWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+
+	store := w.bucket.store
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.writeErrs) > 0 {
+		err := store.writeErrs[0]
+		store.writeErrs = store.writeErrs[1:]
+		return err
+	}
+	if _, exists := store.objects[w.bucket.name][w.object]; exists && w.opts.DoesNotExist {
+		return fmt.Errorf("gs://%s/%s: %w", w.bucket.name, w.object, ErrPreconditionFailed)
+	}
+	if w.opts.SendCRC32C {
+		if sum := crc32.Checksum(w.buf.Bytes(), crc32.MakeTable(crc32.Castagnoli)); sum != w.opts.CRC32C {
+			return fmt.Errorf("objectstore: gs://%s/%s: CRC32C mismatch: got %08x, want %08x", w.bucket.name, w.object, sum, w.opts.CRC32C)
+		}
+	}
+	store.put(w.bucket.name, w.object, w.buf.Bytes(), w.opts)
+	return nil
+}
+
+var _ Client = (*Memory)(nil)
@@ -0,0 +1,103 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestMemoryList(t *testing.T) {
+	store := NewMemory()
+	for _, name := range []string{"doc-1/00002.md", "doc-1/00001.md", "doc-10/00001.md", "doc-1/tables/t1.csv"} {
+		store.Put("pages", name, []byte(name), nil)
+	}
+	store.Put("other", "doc-1/00003.md", []byte("x"), nil)
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "doc-1/", want: []string{"doc-1/00001.md", "doc-1/00002.md", "doc-1/tables/t1.csv"}},
+		{prefix: "doc-1", want: []string{"doc-1/00001.md", "doc-1/00002.md", "doc-1/tables/t1.csv", "doc-10/00001.md"}},
+		{prefix: "doc-1/tables/", want: []string{"doc-1/tables/t1.csv"}},
+		{prefix: "doc-2/"},
+	}
+	for _, tt := range tests {
+		objects, err := store.Bucket("pages").List(context.Background(), tt.prefix)
+		if err != nil {
+			t.Fatalf("List(%q) error = %v", tt.prefix, err)
+		}
+		var names []string
+		for _, attrs := range objects {
+			names = append(names, attrs.Name)
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("List(%q) = %v, want %v", tt.prefix, names, tt.want)
+		}
+	}
+}
+
+func TestMemoryWriterPreconditions(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	bucket := store.Bucket("out")
+	write := func(content string, opts WriterOptions) error {
+		writer := bucket.NewWriter(ctx, "doc/master.md", opts)
+		if _, err := io.WriteString(writer, content); err != nil {
+			return err
+		}
+		return writer.Close()
+	}
+
+	if err := write("first", WriterOptions{DoesNotExist: true}); err != nil {
+		t.Fatalf("first write error = %v", err)
+	}
+	if err := write("second", WriterOptions{DoesNotExist: true}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("conditional write over an existing object error = %v, want ErrPreconditionFailed", err)
+	}
+	if data, _ := store.Get("out", "doc/master.md"); string(data) != "first" {
+		t.Errorf("content = %q after a failed precondition, want %q", data, "first")
+	}
+
+	failure := errors.New("injected")
+	store.FailWrites(failure)
+	if err := write("third", WriterOptions{}); !errors.Is(err, failure) {
+		t.Errorf("write error = %v, want the injected failure", err)
+	}
+	if err := write("fourth", WriterOptions{}); err != nil {
+		t.Errorf("write after the injected failure error = %v", err)
+	}
+	if data, _ := store.Get("out", "doc/master.md"); string(data) != "fourth" {
+		t.Errorf("content = %q, want %q", data, "fourth")
+	}
+
+	attrs, err := bucket.Attrs(ctx, "doc/master.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bucket.Delete(ctx, "doc/master.md", attrs.Generation-1); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Delete() of another generation error = %v, want ErrPreconditionFailed", err)
+	}
+	if err := bucket.Delete(ctx, "doc/master.md", attrs.Generation); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+	if _, err := bucket.NewReader(ctx, "doc/master.md"); !errors.Is(err, ErrObjectNotExist) {
+		t.Errorf("NewReader() of a deleted object error = %v, want ErrObjectNotExist", err)
+	}
+}
+
+func TestMemoryWriterRejectsChecksumMismatch(t *testing.T) {
+	store := NewMemory()
+	writer := store.Bucket("pages").NewWriter(context.Background(), "doc/00001.pdf", WriterOptions{SendCRC32C: true, CRC32C: 0xe3069283})
+	if _, err := io.WriteString(writer, "12345"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err == nil {
+		t.Fatal("Close() of a truncated upload = nil, want a checksum error")
+	}
+	if _, ok := store.Get("pages", "doc/00001.pdf"); ok {
+		t.Errorf("truncated upload was stored")
+	}
+}
@@ -0,0 +1,110 @@
+// Package objectstore wraps the Cloud Storage operations the pipeline uses behind small
+// interfaces, with an implementation backed by the real client and an in-memory fake.
+package objectstore
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+)
+
+var (
+	// ErrObjectNotExist is returned when a read or attribute lookup names a missing object.
+	ErrObjectNotExist = storage.ErrObjectNotExist
+	// ErrPreconditionFailed is returned by Writer.Close when WriterOptions.DoesNotExist is
+	// set and the object already exists.
+	ErrPreconditionFailed = errors.New("objectstore: precondition failed")
+)
+
+// Client opens buckets by name.
+type Client interface {
+	Bucket(name string) Bucket
+}
+
+// Bucket is the set of per-bucket operations the services perform.
+type Bucket interface {
+	// Name returns the bucket name.
+	Name() string
+	// NewReader opens an object for reading.
+	NewReader(ctx context.Context, object string) (Reader, error)
+	// NewWriter starts a write to an object. Nothing is visible until Close succeeds, and
+	// cancelling ctx before Close abandons the write.
+	NewWriter(ctx context.Context, object string, opts WriterOptions) Writer
+	// Attrs returns the attributes of an object.
+	Attrs(ctx context.Context, object string) (*storage.ObjectAttrs, error)
+	// List returns the attributes of every object whose name starts with prefix, in
+	// lexical order.
+	List(ctx context.Context, prefix string) ([]*storage.ObjectAttrs, error)
+	// Delete removes an object. A non-zero generation makes the delete fail with
+	// ErrPreconditionFailed if the object has since been replaced.
+	Delete(ctx context.Context, object string, generation int64) error
+	// Copy replaces dst with the content, content type, encoding and metadata of src in
+	// a single step, so readers of dst see either the old object or the new one.
+	Copy(ctx context.Context, src, dst string) error
+}
+
+// Reader reads an object's content.
+type Reader interface {
+	io.ReadCloser
+	// Size returns the size of the object in bytes as stored. Objects stored with gzip
+	// content encoding are decompressed as they are read, so for them Size is the
+	// compressed size, not the number of bytes Read returns.
+	Size() int64
+}
+
+// Writer writes an object's content. The object is committed by Close.
+type Writer interface {
+	io.Writer
+	Close() error
+}
+
+// WriterOptions configures a write.
+type WriterOptions struct {
+	// DoesNotExist makes the write fail with ErrPreconditionFailed if the object exists.
+	DoesNotExist bool
+	ContentType  string
+	// ContentEncoding is "gzip" for content that is already gzip-compressed. Readers
+	// decompress such objects transparently.
+	ContentEncoding string
+	Metadata        map[string]string
+	// SendCRC32C makes the write fail on Close unless the content's CRC32C (Castagnoli)
+	// checksum equals CRC32C, so a truncated or corrupted upload is never committed.
+	SendCRC32C bool
+	CRC32C     uint32
+	// ChunkSize is the number of bytes a Cloud Storage write buffers in memory before
+	// sending them. Zero leaves the client library's default, and a negative value sends
+	// the object in a single request without a buffer, which suits small objects. Other
+	// stores ignore it.
+	ChunkSize int
+}
+
+// gunzipReader decompresses a gzip-encoded object as it is read, as Cloud Storage does by
+// default. Size remains the stored size.
+type gunzipReader struct {
+	*gzip.Reader
+	raw Reader
+}
+
+func (r gunzipReader) Size() int64 { return r.raw.Size() }
+
+func (r gunzipReader) Close() error {
+	r.Reader.Close()
+	return r.raw.Close()
+}
+
+// decodeReader wraps raw so that it is decompressed if encoding is "gzip".
+func decodeReader(raw Reader, encoding string) (Reader, error) {
+	if encoding != "gzip" {
+		return raw, nil
+	}
+	gz, err := gzip.NewReader(raw)
+	if err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("objectstore: invalid gzip content: %w", err)
+	}
+	return gunzipReader{Reader: gz, raw: raw}, nil
+}
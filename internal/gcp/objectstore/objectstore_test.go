@@ -0,0 +1,77 @@
+package objectstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestGzipRoundTrip(t *testing.T) {
+	content := strings.Repeat("| Impeller | 0.35 mm ± 0.01 |\n", 200)
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := io.WriteString(gz, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]Client{"memory": NewMemory(), "local": NewLocal(t.TempDir())}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			bucket := store.Bucket("out")
+			write := func(object string, data []byte, encoding string) {
+				w := bucket.NewWriter(ctx, object, WriterOptions{ContentType: "text/markdown", ContentEncoding: encoding})
+				if _, err := w.Write(data); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			write("doc-1/master.md", compressed.Bytes(), "gzip")
+			if err := bucket.Copy(ctx, "doc-1/master.md", "doc-1/copy.md"); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, object := range []string{"doc-1/master.md", "doc-1/copy.md"} {
+				r, err := bucket.NewReader(ctx, object)
+				if err != nil {
+					t.Fatalf("NewReader(%s) error = %v", object, err)
+				}
+				got, err := io.ReadAll(r)
+				r.Close()
+				if err != nil || string(got) != content {
+					t.Errorf("read %s = %d bytes, %v; want the %d bytes written, decompressed", object, len(got), err, len(content))
+				}
+				if r.Size() != int64(compressed.Len()) {
+					t.Errorf("%s Size() = %d, want the stored size %d", object, r.Size(), compressed.Len())
+				}
+				attrs, err := bucket.Attrs(ctx, object)
+				if err != nil || attrs.ContentEncoding != "gzip" || attrs.Size != int64(compressed.Len()) {
+					t.Errorf("%s attrs = %+v, %v; want gzip encoding and the stored size", object, attrs, err)
+				}
+			}
+
+			// Plain objects are read as stored; content claiming gzip but not gzip fails to open.
+			write("doc-1/plain.md", []byte(content), "")
+			if r, err := bucket.NewReader(ctx, "doc-1/plain.md"); err != nil {
+				t.Errorf("NewReader() of a plain object error = %v", err)
+			} else {
+				got, _ := io.ReadAll(r)
+				r.Close()
+				if string(got) != content {
+					t.Errorf("read plain object = %d bytes, want %d", len(got), len(content))
+				}
+			}
+			write("doc-1/corrupt.md", []byte(content), "gzip")
+			if _, err := bucket.NewReader(ctx, "doc-1/corrupt.md"); err == nil {
+				t.Errorf("NewReader() of corrupt gzip content succeeded")
+			}
+		})
+	}
+}
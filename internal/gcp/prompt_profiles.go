@@ -0,0 +1,70 @@
+package gcp
+
+import (
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Translator prompt profiles, selected per request for specialized document types.
+const (
+	PromptProfileDefault    = "default"
+	PromptProfileDrawing    = "drawing"
+	PromptProfileContract   = "contract"
+	PromptProfileTableHeavy = "table_heavy"
+)
+
+// --- Drawing Profile Prompts ---
+const TranslatorDrawingSystemPrompt = "You are an engineering drawing parser and markdown translator. Your task is to extract the information on a mechanical or technical drawing sheet into markdown. Exact reproduction of dimensions, tolerances, part numbers and notes is of utmost importance."
+const TranslatorDrawingUserPrompt = `You will be provided with a PDF page containing an engineering drawing:
+
+Follow these instructions to extract the drawing's content into markdown format:
+
+Title Block: Parse the title block into a two-column markdown table of field and value (e.g. drawing number, revision, title, scale, sheet, material, drawn/checked/approved by and dates).
+Revision Block: Parse the revision history into a markdown table with one row per revision.
+Bill of Materials: Parse any parts list or bill of materials into a markdown table, keeping item numbers, part numbers, descriptions and quantities exactly as written.
+Dimensions and Tolerances: List the dimensions, tolerances, surface finishes and geometric tolerancing callouts that are legible, grouped by view, copying values and units exactly. Do not convert units or round values.
+Notes: Reproduce the general and flag notes as a numbered list in their original order.
+Views: Describe each view (e.g. section A-A, detail B) briefly, naming the features it shows.
+Headers and Footers: Ignore border zone markers and sheet frame text that carry no information.
+Never guess an illegible value; write [illegible] in its place.`
+
+// --- Contract Profile Prompts ---
+const TranslatorContractSystemPrompt = "You are a legal document parser and markdown translator. Your task is to translate the pages of a contract into markdown. The exact wording, numbering and structure of every clause must be preserved; never summarize or paraphrase."
+const TranslatorContractUserPrompt = `You will be provided with a PDF document containing a contract or other legal agreement:
+
+Follow these instructions to translate the document into markdown format:
+
+Clauses: Reproduce every clause and sub-clause verbatim. Keep the original clause numbering (e.g. 1, 1.1, 1.1(a), (i)) at the start of each clause, and use markdown headers only for article or section titles.
+Definitions: Keep defined terms exactly as written, including their capitalization and any quotation marks or bold formatting.
+Cross-References: Keep references to other clauses, schedules and exhibits exactly as written.
+Tables: Parse schedules of fees, dates or parties into markdown tables.
+Signature Blocks: Reproduce signature blocks as text, noting where signatures, initials, stamps or handwritten dates appear without attempting to transcribe signatures.
+Headers and Footers: Ignore running headers, footers and page numbers, but keep any confidentiality legend once.
+Do not omit, reorder or summarize any text.`
+
+// --- Table-Heavy Profile Prompts ---
+const TranslatorTableHeavySystemPrompt = "You are a document parser and markdown translator specializing in tabular data. Your task is to translate the content of a PDF document, which consists mostly of tables, into markdown. Correct table structure and exact cell values are of utmost importance."
+const TranslatorTableHeavyUserPrompt = `You will be provided with a PDF document whose content is mostly tables:
+
+Follow these instructions to translate the document into markdown format:
+
+Tables: Parse every table into a markdown table with exactly one header row. Every row must have the same number of cells as the header.
+Merged Cells: Normalize merged cells by copying the merged value into every cell it spans, so that each row can be read on its own.
+Multi-Level Headers: Flatten multi-level column headers into a single header row by joining the levels with " / " (e.g. "Pressure / Max / bar").
+Values: Copy numbers, units and symbols exactly. Do not round, convert or reformat values. Leave a cell empty only if it is empty in the document.
+Continued Tables: If a table continues from a previous page or onto the next one, repeat its header row and keep the same columns.
+Text: Parse any text outside tables, such as titles, captions and footnotes, directly into markdown text.
+Headers and Footers: Ignore page numbers and running headers and footers.`
+
+// TranslatorPrompt is the pair of prompts the translator uses for a profile.
+type TranslatorPrompt struct {
+	System string
+	User   string
+}
+
+// TranslatorPromptProfiles is the registry of translator prompts, keyed by profile name.
+var TranslatorPromptProfiles = map[string]TranslatorPrompt{
+	PromptProfileDefault:    {System: TranslatorSystemPrompt, User: TranslatorUserPrompt},
+	PromptProfileDrawing:    {System: TranslatorDrawingSystemPrompt, User: TranslatorDrawingUserPrompt},
+	PromptProfileContract:   {System: TranslatorContractSystemPrompt, User: TranslatorContractUserPrompt},
+	PromptProfileTableHeavy: {System: TranslatorTableHeavySystemPrompt, User: TranslatorTableHeavyUserPrompt},
+}
@@ -0,0 +1,62 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"cloud.google.com/go/pubsub"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// NewPubSubClient creates and returns a new Pub/Sub client for the given project ID.
+func NewPubSubClient(ctx context.Context, projectID string) (*pubsub.Client, error) {
+	if projectID == "" {
+		return nil, fmt.Errorf("projectID must be provided to create a pubsub client")
+	}
+
+	client, err := pubsub.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Pub/Sub client: %w", err)
+	}
+
+	return client, nil
+}
+
+// pubSubEventData is the data of the CloudEvent a Pub/Sub-triggered function receives.
+// encoding/json decodes the message's base64 data into Data.
+type pubSubEventData struct {
+	Message struct {
+		Data       []byte            `json:"data"`
+		Attributes map[string]string `json:"attributes"`
+		MessageID  string            `json:"messageId"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// DecodePubSubMessage decodes the JSON message carried by the data of a Pub/Sub CloudEvent
+// into v, and returns the message's ID.
+func DecodePubSubMessage(data []byte, v any) (string, error) {
+	var event pubSubEventData
+	if err := json.Unmarshal(data, &event); err != nil {
+		return "", fmt.Errorf("failed to decode Pub/Sub event: %w", err)
+	}
+	if err := json.Unmarshal(event.Message.Data, v); err != nil {
+		return event.Message.MessageID, fmt.Errorf("failed to decode Pub/Sub message %s: %w", event.Message.MessageID, err)
+	}
+	return event.Message.MessageID, nil
+}
+
+// PublishJSON publishes v, encoded as JSON, to topic with attributes, and returns the
+// server-assigned message ID once the publish is acknowledged.
+func PublishJSON(ctx context.Context, topic *pubsub.Topic, v any, attributes map[string]string) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode message: %w", err)
+	}
+	messageID, err := topic.Publish(ctx, &pubsub.Message{Data: data, Attributes: attributes}).Get(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to publish to %s: %w", topic.ID(), err)
+	}
+	return messageID, nil
+}
@@ -0,0 +1,48 @@
+package gcp
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// ErrURINotAllowed is wrapped by the error returned for a request URI that names an
+// object the service may not read.
+var ErrURINotAllowed = errors.New("GCS URI not allowed")
+
+// ValidateDocumentURI parses uri, a gs:// URI taken from a request about document docID
+// of tenant tenantID, and checks that it names an object under "tenantID/docID/", or
+// "docID/" for a document without a tenant, in one of allowedBuckets. Object
+// names with empty, "." or ".." segments are refused even though Cloud Storage treats
+// them literally, as other readers may resolve them to another document's objects.
+// Errors are classified INVALID_REQUEST.
+func ValidateDocumentURI(uri, docID, tenantID string, allowedBuckets ...string) (bucket, object string, err error) {
+	bucket, object, err = ParseGCSURI(uri)
+	if err != nil {
+		return "", "", models.WithCode(models.ErrorCodeInvalidRequest, err)
+	}
+	if !slices.Contains(allowedBuckets, bucket) {
+		return "", "", models.WithCode(models.ErrorCodeInvalidRequest, fmt.Errorf("%w: %q is not in a bucket this service reads", ErrURINotAllowed, uri))
+	}
+	if strings.ContainsRune(object, '\\') || strings.IndexFunc(object, unicode.IsControl) >= 0 {
+		return "", "", models.WithCode(models.ErrorCodeInvalidRequest, fmt.Errorf("%w: %q holds a backslash or control character", ErrURINotAllowed, uri))
+	}
+	for _, segment := range strings.Split(object, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", "", models.WithCode(models.ErrorCodeInvalidRequest, fmt.Errorf("%w: %q has an empty, \".\" or \"..\" path segment", ErrURINotAllowed, uri))
+		}
+	}
+	root := docID
+	if tenantID != "" {
+		root = tenantID + "/" + docID
+	}
+	if docID == "" || strings.Contains(docID, "/") || strings.Contains(tenantID, "/") || !strings.HasPrefix(object, root+"/") {
+		return "", "", models.WithCode(models.ErrorCodeInvalidRequest, fmt.Errorf("%w: %q is not an object of document %q", ErrURINotAllowed, uri, root))
+	}
+	return bucket, object, nil
+}
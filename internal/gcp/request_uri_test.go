@@ -0,0 +1,66 @@
+package gcp
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+func TestValidateDocumentURI(t *testing.T) {
+	tests := []struct {
+		name        string
+		uri         string
+		docID       string
+		tenantID    string
+		wantObject  string
+		wantErr     bool
+		wantAllowed bool // The error wraps ErrURINotAllowed rather than being a parse error.
+	}{
+		{name: "object of the document", uri: "gs://allowed/mydoc/00001.pdf", docID: "mydoc", wantObject: "mydoc/00001.pdf"},
+		{name: "object of the tenant's document", uri: "gs://allowed/acme/mydoc/x.md", docID: "mydoc", tenantID: "acme", wantObject: "acme/mydoc/x.md"},
+		{name: "second allowed bucket", uri: "gs://other-allowed/mydoc/x.md", docID: "mydoc", wantObject: "mydoc/x.md"},
+		{name: "traversal out of another document", uri: "gs://allowed/otherdoc/../mydoc/x.md", docID: "mydoc", wantErr: true, wantAllowed: true},
+		{name: "traversal out of the document", uri: "gs://allowed/mydoc/../otherdoc/x.md", docID: "mydoc", wantErr: true, wantAllowed: true},
+		{name: "dot segment", uri: "gs://allowed/mydoc/./x.md", docID: "mydoc", wantErr: true, wantAllowed: true},
+		{name: "empty segment", uri: "gs://allowed/mydoc//x.md", docID: "mydoc", wantErr: true, wantAllowed: true},
+		{name: "trailing slash", uri: "gs://allowed/mydoc/", docID: "mydoc", wantErr: true, wantAllowed: true},
+		{name: "backslash", uri: `gs://allowed/mydoc/..\otherdoc\x.md`, docID: "mydoc", wantErr: true, wantAllowed: true},
+		{name: "control character", uri: "gs://allowed/mydoc/x\n.md", docID: "mydoc", wantErr: true, wantAllowed: true},
+		{name: "NUL", uri: "gs://allowed/mydoc/x\x00.md", docID: "mydoc", wantErr: true, wantAllowed: true},
+		{name: "bucket not allowed", uri: "gs://elsewhere/mydoc/x.md", docID: "mydoc", wantErr: true, wantAllowed: true},
+		{name: "another document", uri: "gs://allowed/otherdoc/x.md", docID: "mydoc", wantErr: true, wantAllowed: true},
+		{name: "document ID prefix of another", uri: "gs://allowed/mydoc-2/x.md", docID: "mydoc", wantErr: true, wantAllowed: true},
+		{name: "another tenant's prefix", uri: "gs://allowed/globex/mydoc/x.md", docID: "mydoc", tenantID: "acme", wantErr: true, wantAllowed: true},
+		{name: "tenant document without its tenant", uri: "gs://allowed/mydoc/x.md", docID: "mydoc", tenantID: "acme", wantErr: true, wantAllowed: true},
+		{name: "bare root", uri: "gs://allowed/mydoc", docID: "mydoc", wantErr: true, wantAllowed: true},
+		{name: "bare tenant root", uri: "gs://allowed/acme/mydoc", docID: "mydoc", tenantID: "acme", wantErr: true, wantAllowed: true},
+		{name: "document ID with a slash", uri: "gs://allowed/acme/mydoc/x.md", docID: "acme/mydoc", wantErr: true, wantAllowed: true},
+		{name: "no document ID", uri: "gs://allowed/mydoc/x.md", wantErr: true, wantAllowed: true},
+		{name: "not a gs URI", uri: "https://storage.googleapis.com/allowed/mydoc/x.md", docID: "mydoc", wantErr: true},
+		{name: "no object", uri: "gs://allowed", docID: "mydoc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket, object, err := ValidateDocumentURI(tt.uri, tt.docID, tt.tenantID, "allowed", "other-allowed")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateDocumentURI(%q) error = %v, wantErr %v", tt.uri, err, tt.wantErr)
+			}
+			if err != nil {
+				if code := models.CodeOf(err); code != models.ErrorCodeInvalidRequest {
+					t.Errorf("ValidateDocumentURI(%q) error code = %q, want %q", tt.uri, code, models.ErrorCodeInvalidRequest)
+				}
+				if errors.Is(err, ErrURINotAllowed) != tt.wantAllowed {
+					t.Errorf("ValidateDocumentURI(%q) error = %v, wraps ErrURINotAllowed %v", tt.uri, err, tt.wantAllowed)
+				}
+				if bucket != "" || object != "" {
+					t.Errorf("ValidateDocumentURI(%q) = %q, %q alongside an error", tt.uri, bucket, object)
+				}
+				return
+			}
+			if object != tt.wantObject || bucket == "" {
+				t.Errorf("ValidateDocumentURI(%q) = %q, %q, want object %q", tt.uri, bucket, object, tt.wantObject)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// RetryOptions set how Retry retries a call.
+type RetryOptions struct {
+	MaxAttempts int           // Calls made at most, including the first. Less than 1 means 1.
+	BaseDelay   time.Duration // Backoff cap after the first failure, doubled after each one.
+	MaxDelay    time.Duration // Largest backoff cap. Zero leaves it unbounded.
+	// IsRetryable reports whether a failed call may succeed if made again. Nil means
+	// IsRetryableError.
+	IsRetryable func(error) bool
+	// OnRetry, if set, is called before each backoff, with the attempt that failed.
+	OnRetry func(attempt int, backoff time.Duration, err error)
+}
+
+// DefaultRetryOptions retry transient errors three times, over about 3.5 seconds at most.
+var DefaultRetryOptions = RetryOptions{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    4 * time.Second,
+}
+
+// Retry calls fn until it succeeds, returns an error that is not retryable, or has been
+// called MaxAttempts times, and returns its last error. Between calls it waits a random
+// time of up to the backoff cap ("full jitter"), so that callers failing together do not
+// retry together. If ctx is done while waiting, Retry gives up with ctx's error.
+func Retry(ctx context.Context, opts RetryOptions, fn func(ctx context.Context) error) error {
+	isRetryable := opts.IsRetryable
+	if isRetryable == nil {
+		isRetryable = IsRetryableError
+	}
+	backoffCap := opts.BaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= opts.MaxAttempts || !isRetryable(err) {
+			return err
+		}
+		backoff := jitter(backoffCap)
+		if opts.OnRetry != nil {
+			opts.OnRetry(attempt, backoff, err)
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("gave up retrying after attempt %d: %w (last error: %v)", attempt, context.Cause(ctx), err)
+		}
+		backoffCap *= 2
+		if opts.MaxDelay > 0 && backoffCap > opts.MaxDelay {
+			backoffCap = opts.MaxDelay
+		}
+	}
+}
+
+// jitter returns a random duration in [0, limit].
+func jitter(limit time.Duration) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+	return rand.N(limit + 1)
+}
+
+// IsRetryableError reports whether err is transient: a Google API error with status 429
+// or 5xx, a gRPC Unavailable, ResourceExhausted or DeadlineExceeded, a network timeout,
+// or a connection that was refused, reset or cut short. Cancellation and the caller's
+// own deadline are not, as a retry could not finish either.
+func IsRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= http.StatusInternalServerError
+	}
+	if s, ok := status.FromError(err); ok && s.Code() != codes.Unknown {
+		switch s.Code() {
+		case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded:
+			return true
+		}
+		return false
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,144 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetry(t *testing.T) {
+	unavailable := &googleapi.Error{Code: http.StatusServiceUnavailable}
+	notFound := &googleapi.Error{Code: http.StatusNotFound}
+	tests := []struct {
+		name         string
+		maxAttempts  int
+		errs         []error // Returned by successive calls; nil once exhausted.
+		wantErr      error
+		wantAttempts int
+	}{
+		{name: "succeeds at once", maxAttempts: 4, wantAttempts: 1},
+		{name: "succeeds after transient errors", maxAttempts: 4, errs: []error{unavailable, unavailable}, wantAttempts: 3},
+		{name: "gives up after max attempts", maxAttempts: 3, errs: []error{unavailable, unavailable, unavailable, unavailable}, wantErr: unavailable, wantAttempts: 3},
+		{name: "does not retry a permanent error", maxAttempts: 4, errs: []error{notFound}, wantErr: notFound, wantAttempts: 1},
+		{name: "zero max attempts calls once", maxAttempts: 0, errs: []error{unavailable}, wantErr: unavailable, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			var retried []int
+			opts := RetryOptions{
+				MaxAttempts: tt.maxAttempts,
+				BaseDelay:   time.Millisecond,
+				MaxDelay:    2 * time.Millisecond,
+				OnRetry: func(attempt int, backoff time.Duration, err error) {
+					retried = append(retried, attempt)
+					if backoff < 0 || backoff > 2*time.Millisecond {
+						t.Errorf("OnRetry() backoff = %v, want at most MaxDelay", backoff)
+					}
+				},
+			}
+			err := Retry(context.Background(), opts, func(ctx context.Context) error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("Retry() error = %v, want %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("Retry() made %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+			if len(retried) != tt.wantAttempts-1 {
+				t.Errorf("OnRetry() called after attempts %v, want one call per retry", retried)
+			}
+		})
+	}
+}
+
+func TestRetryStopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	unavailable := &googleapi.Error{Code: http.StatusServiceUnavailable}
+	attempts := 0
+	opts := RetryOptions{
+		MaxAttempts: 10,
+		BaseDelay:   time.Hour,
+		OnRetry:     func(int, time.Duration, error) { cancel() },
+	}
+	err := Retry(ctx, opts, func(ctx context.Context) error {
+		attempts++
+		return unavailable
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Retry() error = %v, want context.Canceled", err)
+	}
+	if attempts != 1 {
+		t.Errorf("Retry() made %d attempts after cancellation, want 1", attempts)
+	}
+}
+
+func TestRetryUsesIsRetryable(t *testing.T) {
+	errTransient := errors.New("transient")
+	attempts := 0
+	opts := RetryOptions{
+		MaxAttempts: 3,
+		IsRetryable: func(err error) bool { return errors.Is(err, errTransient) },
+	}
+	err := Retry(context.Background(), opts, func(ctx context.Context) error {
+		attempts++
+		return errTransient
+	})
+	if !errors.Is(err, errTransient) || attempts != 3 {
+		t.Errorf("Retry() = %v after %d attempts, want errTransient after 3", err, attempts)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "429", err: &googleapi.Error{Code: http.StatusTooManyRequests}, want: true},
+		{name: "503", err: &googleapi.Error{Code: http.StatusServiceUnavailable}, want: true},
+		{name: "wrapped 500", err: fmt.Errorf("upload: %w", &googleapi.Error{Code: http.StatusInternalServerError}), want: true},
+		{name: "404", err: &googleapi.Error{Code: http.StatusNotFound}, want: false},
+		{name: "412", err: &googleapi.Error{Code: http.StatusPreconditionFailed}, want: false},
+		{name: "grpc unavailable", err: status.Error(codes.Unavailable, "down"), want: true},
+		{name: "grpc resource exhausted", err: status.Error(codes.ResourceExhausted, "quota"), want: true},
+		{name: "grpc deadline exceeded", err: status.Error(codes.DeadlineExceeded, "slow"), want: true},
+		{name: "grpc invalid argument", err: status.Error(codes.InvalidArgument, "bad"), want: false},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "own deadline", err: fmt.Errorf("call: %w", context.DeadlineExceeded), want: false},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, want: true},
+		{name: "connection reset", err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, want: true},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, want: true},
+		{name: "network timeout", err: timeoutError{}, want: true},
+		{name: "plain error", err: errors.New("boom"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryableError(tt.err); got != tt.want {
+				t.Errorf("IsRetryableError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
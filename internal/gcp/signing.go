@@ -0,0 +1,80 @@
+package gcp
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iamcredentials/v1"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// URLSigner mints time-limited links to objects. IAMURLSigner implements it; tests can
+// substitute a fake.
+type URLSigner interface {
+	// SignedURL returns a URL that reads object in bucket with a GET until ttl elapses.
+	SignedURL(ctx context.Context, bucket, object string, ttl time.Duration) (string, error)
+}
+
+// IAMURLSigner signs V4 URLs as a service account through the IAM Credentials SignBlob
+// API, so no key file is needed. The service account needs the Service Account Token
+// Creator role on itself.
+type IAMURLSigner struct {
+	serviceAccount string
+	// signBlob signs payload as serviceAccount. It calls SignBlob, and tests replace it.
+	signBlob func(ctx context.Context, payload []byte) ([]byte, error)
+}
+
+// NewIAMURLSigner creates a signer for serviceAccount, or for the function's own service
+// account, read from the metadata server, when it is "".
+func NewIAMURLSigner(ctx context.Context, serviceAccount string) (*IAMURLSigner, error) {
+	if serviceAccount == "" {
+		email, err := metadata.EmailWithContext(ctx, "default")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the default service account: %w", err)
+		}
+		serviceAccount = email
+	}
+	service, err := iamcredentials.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IAM Credentials client: %w", err)
+	}
+	signBlob := func(ctx context.Context, payload []byte) ([]byte, error) {
+		name := "projects/-/serviceAccounts/" + serviceAccount
+		resp, err := service.Projects.ServiceAccounts.SignBlob(name, &iamcredentials.SignBlobRequest{
+			Payload: base64.StdEncoding.EncodeToString(payload),
+		}).Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(resp.SignedBlob)
+	}
+	return &IAMURLSigner{serviceAccount: serviceAccount, signBlob: signBlob}, nil
+}
+
+// SignedURL implements URLSigner. The storage library reads the clock again after
+// Expires is set and rounds the remaining lifetime down to whole seconds, so half a
+// second is added to keep a ttl of whole seconds from losing one.
+func (s *IAMURLSigner) SignedURL(ctx context.Context, bucket, object string, ttl time.Duration) (string, error) {
+	url, err := storage.SignedURL(bucket, object, &storage.SignedURLOptions{
+		Scheme:         storage.SigningSchemeV4,
+		Method:         http.MethodGet,
+		Expires:        time.Now().Add(ttl + time.Second/2),
+		GoogleAccessID: s.serviceAccount,
+		SignBytes: func(payload []byte) ([]byte, error) {
+			signature, err := s.signBlob(ctx, payload)
+			if err != nil {
+				return nil, fmt.Errorf("failed to sign with %s: %w", s.serviceAccount, err)
+			}
+			return signature, nil
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to sign URL for gs://%s/%s: %w", bucket, object, err)
+	}
+	return url, nil
+}
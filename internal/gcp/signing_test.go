@@ -0,0 +1,78 @@
+package gcp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSignBlob returns a signBlob that signs every payload with signature, or fails with
+// err, and records the payloads it was given.
+func fakeSignBlob(signature []byte, err error, payloads *[]string) func(context.Context, []byte) ([]byte, error) {
+	return func(ctx context.Context, payload []byte) ([]byte, error) {
+		*payloads = append(*payloads, string(payload))
+		return signature, err
+	}
+}
+
+func TestIAMURLSignerSignedURL(t *testing.T) {
+	var payloads []string
+	signer := &IAMURLSigner{serviceAccount: "signer@project.iam.gserviceaccount.com", signBlob: fakeSignBlob([]byte("signed"), nil, &payloads)}
+	before := time.Now().UTC().Truncate(time.Second)
+
+	signed, err := signer.SignedURL(context.Background(), "outputs", "doc-1/master.md", 15*time.Minute)
+	if err != nil {
+		t.Fatalf("SignedURL() error = %v", err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("SignedURL() = %q, not a URL: %v", signed, err)
+	}
+	if u.Scheme != "https" || u.Host != "storage.googleapis.com" || u.Path != "/outputs/doc-1/master.md" {
+		t.Errorf("SignedURL() = %q, want a link to gs://outputs/doc-1/master.md", signed)
+	}
+	query := u.Query()
+	if got := query.Get("X-Goog-Expires"); got != "900" {
+		t.Errorf("X-Goog-Expires = %q, want the ttl of 900 seconds", got)
+	}
+	signedAt, err := time.Parse("20060102T150405Z", query.Get("X-Goog-Date"))
+	if err != nil || signedAt.Before(before) || signedAt.After(time.Now()) {
+		t.Errorf("X-Goog-Date = %q, want the time of signing", query.Get("X-Goog-Date"))
+	}
+	if got := query.Get("X-Goog-Credential"); !strings.HasPrefix(got, "signer@project.iam.gserviceaccount.com/") {
+		t.Errorf("X-Goog-Credential = %q, want the signing service account", got)
+	}
+	if got := query.Get("X-Goog-Signature"); got != hex.EncodeToString([]byte("signed")) {
+		t.Errorf("X-Goog-Signature = %q, want the signer's signature", got)
+	}
+
+	// The method is only in the canonical request whose hash was signed, so it is
+	// checked by hashing the request a GET of the URL makes.
+	if len(payloads) != 1 {
+		t.Fatalf("signBlob called %d times, want once", len(payloads))
+	}
+	unsigned := u.Query()
+	unsigned.Del("X-Goog-Signature")
+	canonicalQuery := unsigned.Encode()
+	for _, method := range []string{"GET", "PUT"} {
+		canonical := method + "\n/outputs/doc-1/master.md\n" + canonicalQuery + "\nhost:storage.googleapis.com\n\nhost\nUNSIGNED-PAYLOAD"
+		sum := sha256.Sum256([]byte(canonical))
+		if signedMethod := strings.HasSuffix(payloads[0], hex.EncodeToString(sum[:])); signedMethod != (method == "GET") {
+			t.Errorf("URL signed for %s = %v, want it signed for GET only", method, signedMethod)
+		}
+	}
+}
+
+func TestIAMURLSignerSignFails(t *testing.T) {
+	var payloads []string
+	signer := &IAMURLSigner{serviceAccount: "signer@project.iam.gserviceaccount.com", signBlob: fakeSignBlob(nil, errors.New("permission denied"), &payloads)}
+	signed, err := signer.SignedURL(context.Background(), "outputs", "doc-1/master.md", time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "permission denied") || signed != "" {
+		t.Errorf("SignedURL() = %q, %v; want the signing error", signed, err)
+	}
+}
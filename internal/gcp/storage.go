@@ -1,14 +1,26 @@
 package gcp
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/api/googleapi"
 		_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
 )
@@ -21,24 +33,212 @@ func GetEnv(key, fallback string) string {
 	return fallback
 }
 
-// SaveToGCSAtomically writes content to a GCS object only if it doesn't already exist.
-// It's a shared utility for all services.
-func SaveToGCSAtomically(ctx context.Context, bucket *storage.BucketHandle, objectName, content string) error {
-	writer := bucket.Object(objectName).If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
-
-	if _, err := io.Copy(writer, strings.NewReader(content)); err != nil {
-		_ = writer.Close()
-		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == 412 {
-			log.Printf("SKIPPING: Object %s already exists.", objectName)
-			return nil // Not a failure in an idempotent workflow.
-		}
-		log.Printf("ERROR: Failed to copy content to GCS object %s: %v", objectName, err)
-		return fmt.Errorf("failed to write to GCS: %w", err)
+// ParseGCSURI splits a "gs://bucket/object" URI into its bucket and object name.
+func ParseGCSURI(uri string) (bucket, object string, err error) {
+	rest, ok := strings.CutPrefix(uri, "gs://")
+	if !ok {
+		return "", "", fmt.Errorf("invalid GCS URI %q: missing gs:// scheme", uri)
+	}
+	bucket, object, ok = strings.Cut(rest, "/")
+	if !ok || bucket == "" || object == "" {
+		return "", "", fmt.Errorf("invalid GCS URI %q: expected gs://bucket/object", uri)
+	}
+	return bucket, object, nil
+}
+
+// Object metadata written by SaveToGCSAtomically. An object without CompleteMetadataKey
+// set to "true" may be the remains of an interrupted write and should not be trusted.
+// UncompressedSizeMetadataKey records the content size of a gzip-compressed object, and
+// SourceGenerationMetadataKey the generation of the object it was produced from.
+const (
+	CompleteMetadataKey         = "complete"
+	PlaceholderMetadataKey      = "placeholder"
+	UncompressedSizeMetadataKey = "uncompressedSize"
+	SourceGenerationMetadataKey = "sourceGeneration"
+)
+
+// MarkdownContentType is the content type of markdown objects.
+const MarkdownContentType = "text/markdown; charset=utf-8"
+
+// compressMarkdown reads COMPRESS_MARKDOWN once. When it is true, markdown objects are
+// stored gzip-compressed with Content-Encoding gzip; Cloud Storage readers decompress
+// them transparently.
+var compressMarkdown = sync.OnceValue(func() bool {
+	value := GetEnv("COMPRESS_MARKDOWN", "false")
+	compress, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Ignoring invalid COMPRESS_MARKDOWN; markdown is stored uncompressed.", "value", value)
+		return false
+	}
+	return compress
+})
+
+// contentTypeFor returns the content type of an object written by SaveToGCSAtomically,
+// from its extension.
+func contentTypeFor(objectName string) string {
+	switch path.Ext(objectName) {
+	case ".md":
+		return MarkdownContentType
+	case ".json":
+		return "application/json"
+	case ".diff":
+		return "text/x-diff; charset=utf-8"
+	case ".csv":
+		return "text/csv; charset=utf-8"
 	}
+	return "text/plain; charset=utf-8"
+}
+
+// ContentSize returns the size of an object's content once decompressed, or -1 if a
+// compressed object does not record it. Cloud Storage reports the stored size, which for
+// a gzip-encoded object is the compressed size, so size checks must use this instead.
+func ContentSize(attrs *storage.ObjectAttrs) int64 {
+	if attrs.ContentEncoding != "gzip" {
+		return attrs.Size
+	}
+	size, err := strconv.ParseInt(attrs.Metadata[UncompressedSizeMetadataKey], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
 
+// gzipContent compresses content for storage with Content-Encoding gzip.
+func gzipContent(content string) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(writer, content); err != nil {
+		return nil, err
+	}
 	if err := writer.Close(); err != nil {
-		log.Printf("ERROR: Failed to close GCS writer for %s: %v", objectName, err)
-		return fmt.Errorf("failed to finalize GCS write: %w", err)
+		return nil, err
 	}
-	return nil
+	return buf.Bytes(), nil
+}
+
+// SaveToGCSAtomically writes content to a GCS object only if it doesn't already exist.
+// It's a shared utility for all services; logCtx should carry the caller's request attributes.
+// The content type follows the object's extension, and markdown is gzip-compressed when
+// COMPRESS_MARKDOWN is set.
+func SaveToGCSAtomically(ctx context.Context, logCtx *slog.Logger, bucket objectstore.Bucket, objectName, content string) error {
+	_, err := SaveToGCS(ctx, logCtx, bucket, objectName, content, SaveOptions{})
+	return err
+}
+
+// SaveToGCSAtomicallyWithMetadata is SaveToGCSAtomically with additional custom metadata.
+func SaveToGCSAtomicallyWithMetadata(ctx context.Context, logCtx *slog.Logger, bucket objectstore.Bucket, objectName, content string, metadata map[string]string) error {
+	_, err := SaveToGCS(ctx, logCtx, bucket, objectName, content, SaveOptions{Metadata: metadata})
+	return err
+}
+
+// SaveResult tells what SaveToGCS did with an object.
+type SaveResult int
+
+const (
+	SaveFailed  SaveResult = iota // Nothing is known to have been written; see the error.
+	SaveWritten                   // The object was written.
+	SaveExisted                   // The object already existed and was left as it was.
+)
+
+// SaveOptions are the optional settings of SaveToGCS.
+type SaveOptions struct {
+	Metadata map[string]string // Custom metadata, added to the completion marker.
+	// Timeout bounds the write, including the client's retries. Zero leaves only ctx's
+	// deadline.
+	Timeout time.Duration
+	// ChunkSize is the number of bytes buffered per upload request. Zero sends content of
+	// up to googleapi.DefaultUploadChunkSize in a single request, without allocating the
+	// client's buffer, and larger content in chunks of that size. The client does not
+	// retry a single request, so a write failing with a transient error is made again,
+	// with DefaultRetryOptions.
+	ChunkSize int
+}
+
+// SaveToGCS is SaveToGCSAtomically with options, reporting whether the object was written
+// or already existed. An existing object is not an error in an idempotent workflow,
+// whether the precondition fails when the content is written or when the write is
+// committed, as it usually is for small content.
+// Every object it writes is marked complete, so readers can tell it apart from one left
+// behind by a crashed writer.
+func SaveToGCS(ctx context.Context, logCtx *slog.Logger, bucket objectstore.Bucket, objectName, content string, options SaveOptions) (result SaveResult, err error) {
+	ctx, span := otel.Tracer(TracerName+"/internal/gcp").Start(ctx, "gcs.Upload", trace.WithAttributes(
+		attribute.String("gcs.uri", fmt.Sprintf("gs://%s/%s", bucket.Name(), objectName)),
+		attribute.Int("gcs.bytes", len(content)),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+	logCtx = logCtx.With("bucket", bucket.Name(), "gcsObject", objectName)
+	opts := objectstore.WriterOptions{
+		DoesNotExist: true,
+		ContentType:  contentTypeFor(objectName),
+		Metadata:     map[string]string{CompleteMetadataKey: "true"},
+	}
+	for k, v := range options.Metadata {
+		opts.Metadata[k] = v
+	}
+	payload := []byte(content)
+	if opts.ContentType == MarkdownContentType && compressMarkdown() {
+		if payload, err = gzipContent(content); err != nil {
+			return SaveFailed, fmt.Errorf("failed to compress %s: %w", objectName, err)
+		}
+		opts.ContentEncoding = "gzip"
+		opts.Metadata[UncompressedSizeMetadataKey] = strconv.Itoa(len(content))
+	}
+	opts.ChunkSize = options.ChunkSize
+	if opts.ChunkSize == 0 && len(payload) <= googleapi.DefaultUploadChunkSize {
+		opts.ChunkSize = -1 // A single request, without the client's buffer.
+	}
+	// A write whose reply was lost may have committed, in which case its retry finds the
+	// object and reports it as existing.
+	retry := DefaultRetryOptions
+	retry.OnRetry = func(attempt int, backoff time.Duration, err error) {
+		RecordGCSUploadRetry(ctx)
+		logCtx.Warn("Write failed, will retry.", "attempt", attempt, "backoff", backoff.String(), "error", err)
+	}
+	result = SaveWritten
+	err = Retry(ctx, retry, func(ctx context.Context) error {
+		writer := bucket.NewWriter(ctx, objectName, opts)
+		if _, err := writer.Write(payload); err != nil {
+			_ = writer.Close()
+			if errors.Is(err, objectstore.ErrPreconditionFailed) {
+				result = SaveExisted
+				return nil
+			}
+			return fmt.Errorf("failed to write to GCS: %w", err)
+		}
+		if err := writer.Close(); err != nil {
+			if errors.Is(err, objectstore.ErrPreconditionFailed) {
+				result = SaveExisted
+				return nil
+			}
+			return fmt.Errorf("failed to finalize GCS write: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		logCtx.Error("Failed to write GCS object", "error", err)
+		return SaveFailed, err
+	}
+	if result == SaveExisted {
+		logCtx.Warn("Object already exists; skipping write.")
+	}
+	return result, nil
+}
+
+// IsCompleteObject reports whether attrs describe an object fully written by
+// SaveToGCSAtomically. Metadata is committed together with the object data, so leftovers
+// of interrupted or legacy writes, including zero-byte ones, never carry the marker, while
+// a legitimately empty output does.
+func IsCompleteObject(attrs *storage.ObjectAttrs) bool {
+	return attrs != nil && attrs.Metadata[CompleteMetadataKey] == "true"
 }
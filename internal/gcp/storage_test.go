@@ -0,0 +1,142 @@
+package gcp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"google.golang.org/api/googleapi"
+)
+
+func TestSaveToGCS(t *testing.T) {
+	unavailable := &googleapi.Error{Code: http.StatusServiceUnavailable}
+	tests := []struct {
+		name        string
+		existing    string
+		writeErrs   []error
+		want        SaveResult
+		wantErr     bool
+		wantContent string
+	}{
+		{name: "new object", want: SaveWritten, wantContent: "# Master"},
+		{name: "existing object is left as is", existing: "# Earlier", want: SaveExisted, wantContent: "# Earlier"},
+		{name: "transient failures are retried", writeErrs: []error{unavailable, unavailable}, want: SaveWritten, wantContent: "# Master"},
+		{
+			name:      "permanent failures are not retried",
+			writeErrs: []error{&googleapi.Error{Code: http.StatusForbidden}},
+			want:      SaveFailed,
+			wantErr:   true,
+		},
+	}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := objectstore.NewMemory()
+			if tt.existing != "" {
+				store.Put("out", "doc/master.md", []byte(tt.existing), nil)
+			}
+			store.FailWrites(tt.writeErrs...)
+
+			got, err := SaveToGCS(context.Background(), logCtx, store.Bucket("out"), "doc/master.md", "# Master", SaveOptions{Metadata: map[string]string{"pageCount": "3"}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SaveToGCS() error = %v, want error = %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("SaveToGCS() = %v, want %v", got, tt.want)
+			}
+			data, ok := store.Get("out", "doc/master.md")
+			if string(data) != tt.wantContent || ok != (tt.wantContent != "") {
+				t.Errorf("content = %q (exists %v), want %q", data, ok, tt.wantContent)
+			}
+			if got != SaveWritten {
+				return
+			}
+			attrs, err := store.Bucket("out").Attrs(context.Background(), "doc/master.md")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsCompleteObject(attrs) || attrs.Metadata["pageCount"] != "3" {
+				t.Errorf("metadata = %v, want the completion marker and pageCount", attrs.Metadata)
+			}
+			if attrs.ContentType != MarkdownContentType {
+				t.Errorf("content type = %q, want %q", attrs.ContentType, MarkdownContentType)
+			}
+		})
+	}
+}
+
+func TestSaveToGCSCompressesMarkdown(t *testing.T) {
+	compress := compressMarkdown
+	compressMarkdown = func() bool { return true }
+	t.Cleanup(func() { compressMarkdown = compress })
+	ctx := context.Background()
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := objectstore.NewMemory()
+	bucket := store.Bucket("out")
+	content := strings.Repeat("| Impeller | 0.35 mm ± 0.01 |\n", 200)
+
+	for _, object := range []string{"doc/master.md", "doc/_index.json"} {
+		if _, err := SaveToGCS(ctx, logCtx, bucket, object, content, SaveOptions{}); err != nil {
+			t.Fatalf("SaveToGCS(%s) error = %v", object, err)
+		}
+	}
+
+	stored, _ := store.Get("out", "doc/master.md")
+	gz, err := gzip.NewReader(bytes.NewReader(stored))
+	if err != nil {
+		t.Fatalf("stored markdown is not gzip: %v", err)
+	}
+	if raw, err := io.ReadAll(gz); err != nil || string(raw) != content {
+		t.Errorf("stored markdown decompresses to %d bytes, %v; want the %d bytes saved", len(raw), err, len(content))
+	}
+	attrs, err := bucket.Attrs(ctx, "doc/master.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ContentEncoding != "gzip" || attrs.Size != int64(len(stored)) || attrs.Size >= int64(len(content)) {
+		t.Errorf("attrs = encoding %q, size %d; want gzip at the compressed size", attrs.ContentEncoding, attrs.Size)
+	}
+	if got := ContentSize(attrs); got != int64(len(content)) {
+		t.Errorf("ContentSize() = %d, want %d", got, len(content))
+	}
+	r, err := bucket.NewReader(ctx, "doc/master.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got, err := io.ReadAll(r); err != nil || string(got) != content {
+		t.Errorf("read back %d bytes, %v; want the content saved", len(got), err)
+	}
+
+	// Only markdown is compressed.
+	if stored, _ := store.Get("out", "doc/_index.json"); string(stored) != content {
+		t.Errorf("stored JSON = %d bytes, want it uncompressed", len(stored))
+	}
+}
+
+func TestContentSize(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs storage.ObjectAttrs
+		want  int64
+	}{
+		{name: "uncompressed", attrs: storage.ObjectAttrs{Size: 120}, want: 120},
+		{name: "compressed", attrs: storage.ObjectAttrs{Size: 40, ContentEncoding: "gzip", Metadata: map[string]string{UncompressedSizeMetadataKey: "120"}}, want: 120},
+		{name: "compressed without its size", attrs: storage.ObjectAttrs{Size: 40, ContentEncoding: "gzip"}, want: -1},
+		{name: "compressed with an invalid size", attrs: storage.ObjectAttrs{Size: 40, ContentEncoding: "gzip", Metadata: map[string]string{UncompressedSizeMetadataKey: "large"}}, want: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ContentSize(&tt.attrs); got != tt.want {
+				t.Errorf("ContentSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
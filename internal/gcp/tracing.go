@@ -0,0 +1,83 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	texporter "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/trace"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// TracerName is the instrumentation name of the pipeline's spans.
+const TracerName = "github.com/Lllllllleong/engineeringdocumentflow"
+
+var (
+	tracingOnce    sync.Once
+	tracingErr     error
+	tracerProvider *sdktrace.TracerProvider // Nil while tracing is disabled.
+	propagator     = propagation.TraceContext{}
+)
+
+// InitTracing installs a tracer provider that exports to Cloud Trace when ENABLE_TRACING
+// is "true". Otherwise spans are no-ops and no credentials are needed. Only the first
+// call has any effect, so every service constructor may call it.
+func InitTracing(ctx context.Context, projectID string) error {
+	tracingOnce.Do(func() {
+		otel.SetTextMapPropagator(propagator)
+		if GetEnv("ENABLE_TRACING", "false") != "true" {
+			return
+		}
+		exporter, err := texporter.New(texporter.WithProjectID(projectID), texporter.WithContext(ctx))
+		if err != nil {
+			tracingErr = fmt.Errorf("failed to create Cloud Trace exporter: %w", err)
+			return
+		}
+		SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter)))
+		slog.Info("Tracing enabled.", "exporter", "cloudtrace")
+	})
+	return tracingErr
+}
+
+// SetTracerProvider installs provider as the global tracer provider flushed by FlushTraces.
+// InitTracing calls it; tests can use it to install an in-memory exporter.
+func SetTracerProvider(provider *sdktrace.TracerProvider) {
+	tracerProvider = provider
+	otel.SetTracerProvider(provider)
+}
+
+// FlushTraces exports any buffered spans. An instance can be frozen as soon as it has
+// responded, so each request flushes before returning.
+func FlushTraces(ctx context.Context) {
+	if tracerProvider == nil {
+		return
+	}
+	if err := tracerProvider.ForceFlush(ctx); err != nil {
+		slog.Warn("Failed to flush traces", "error", err)
+	}
+}
+
+// ContextWithTraceparent returns ctx carrying the remote span context of a W3C
+// traceparent header, so that spans started from it join that trace. An empty or invalid
+// traceparent leaves ctx unchanged.
+func ContextWithTraceparent(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+}
+
+// Traceparent returns the W3C traceparent of the span in ctx, or "" if there is none.
+func Traceparent(ctx context.Context) string {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ""
+	}
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
@@ -4,6 +4,11 @@ import (
 	"cloud.google.com/go/vertexai/genai"
 	"context"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
 		_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
 )
 
@@ -20,6 +25,57 @@ Tables: Parse all tables into markdown tables. If a table contains merged cells,
 Headers and Footers: Ignore any irrelevant content in the header and footer, such as the publishing company's name, logo, address, or page numbers. Focus on preserving the core content of the document.
 Your primary goal is to maintain the integrity and completeness of the document's content in the markdown output. Ensure that all details and information are accurately translated and preserved.`
 
+// TranslatorImagesPromptFormat is appended to the translator prompt when the page's embedded
+// images have been extracted. It is formatted with the number of images on the page.
+const TranslatorImagesPromptFormat = `This page contains %d embedded image(s), numbered from 1 in the order they appear on the page.
+When you describe an image, also reference it by its number using exactly this form: ![<short caption> (see image N)]
+For example: ![Pump assembly cross-section (see image 2)]
+Keep the detailed textual description as well; the reference is added alongside it, not instead of it.`
+
+// TranslatorOCRPromptFormat is appended to the translator prompt when a page that reads as
+// an image is translated again with OCR text. It is formatted with the OCR text.
+const TranslatorOCRPromptFormat = `This page is a scan without a text layer. Its text, as read by OCR, is given below between the markers.
+Transcribe the page into markdown using this text: take the words from the OCR text, and the page itself only for layout, such as headings, lists and tables, and to correct obvious OCR errors.
+Do not describe the page as an image of text.
+--- OCR TEXT ---
+%s
+--- END OCR TEXT ---`
+
+// TranslatorRedactedPromptFormat is appended to the translator prompt in place of the page
+// when personal data must not leave the document. It is formatted with the page's OCR
+// text after redaction.
+const TranslatorRedactedPromptFormat = `The page itself is not provided. Instead, its text as read by OCR is given below between the markers, with personal data replaced by placeholders such as [PERSON_NAME].
+Transcribe this text into markdown, recovering headings, lists and tables from its layout as best you can. Keep every placeholder exactly as written and do not guess what it replaced.
+--- OCR TEXT ---
+%s
+--- END OCR TEXT ---`
+
+// TranslatorMalformedOutputPromptFormat is appended to the translator prompt when the
+// page is translated again because its markdown was rejected. It is formatted with the
+// reason it was rejected.
+const TranslatorMalformedOutputPromptFormat = `Your previous answer for this page was rejected: %s.
+Respond with only the markdown of the page. Do not introduce it or comment on it, do not wrap the whole page in a list, a blockquote or a code block, and close every code block you open.`
+
+// TranslatorTablesPrompt asks the translator model for the tables on a page as JSON, in a
+// second call made after the page is translated when table extraction is on.
+const TranslatorTablesPrompt = `Do not transcribe this page into markdown. Instead, extract every table on the page as data.
+Respond with only a JSON array, one object per table in the order the tables appear, of this form:
+[{"title": "<the table's caption or heading, or empty>", "headers": ["<column>", ...], "rows": [["<cell>", ...], ...]}]
+Every row must have exactly one cell per header. Write merged cells out in full in each row they span, and empty cells as "".
+Keep cell text exactly as written, including units. If the page has no tables, respond with [].`
+
+// Language instructions appended to the translator prompt when the request carries language
+// hints. The formats are formatted with a language.
+const (
+	TranslatorSourceLanguagePromptFormat = `The document is written in %s.`
+	TranslatorTargetLanguagePromptFormat = `Write the markdown in %s, translating any content that is in another language.`
+	TranslatorKeepLanguagePrompt         = `Write the markdown in the document's own language. Do not translate its content.`
+)
+
+// CleanerLanguagePromptFormat is appended to the cleaner prompt when the language of the
+// markdown is known. It is formatted with the language.
+const CleanerLanguagePromptFormat = `The document is written in %s. Keep its text in that language: do not translate it, and do not correct text because it is not English.`
+
 // --- Cleaner Model Prompts ---
 const CleanerSystemPrompt = "You are an expert Markdown editor. Your task is to clean, refine, and consolidate a single Markdown file that was created by merging multiple pages. Your goal is to make it a single, cohesive, and perfectly formatted document."
 const CleanerUserPrompt = `Follow these instructions to clean, refine, and consolidate the Markdown file:
@@ -28,6 +84,7 @@ const CleanerUserPrompt = `Follow these instructions to clean, refine, and conso
 2.  **Smooth Formatting**: Ensure consistent heading levels, list formatting, and code blocks. Remove awkward line breaks in the middle of sentences that were caused by page breaks.
 3.  **Remove Artifacts**: Delete any repeated page numbers, company logo names/address, or page separators (e.g., a line of '---') that are not part of the content's structure.
 4.  **Consolidate Sections**: Ensure a logical flow between sections that were previously on different pages. Do not add new content, but smooth the transition.
+5.  **Keep Page Anchors**: Do NOT remove HTML comments of the form <!-- page: N --> or <!-- page: N-M -->. Keep each one exactly as written, on its own line, before the content of the page it marks. If you merge a table or paragraph that was broken across a page anchor, place that anchor on its own line immediately before the merged element.
 
 Attempt to preserve as much information as possible. Only remove sections if you are absolutely certain it is noise. If you are uncertain, just leave it in.
 
@@ -44,6 +101,7 @@ Follow these rules precisely:
     - "section": A string containing the full header title (e.g., "1.1.2 Background and Motivation").
     - "content": A string containing all the markdown content that belongs under that header, up to the next header of the same or higher level.
 4.  The final output MUST be a single, valid JSON array of these objects. Do not include any text before or after the JSON array.
+5.  Keep any page anchor comments such as <!-- page: 12 --> in the "content" exactly where they appear in the document.
 
 Example output format:
 [
@@ -61,65 +119,349 @@ Example output format:
   }
 ]`
 
-// VertexClient holds all pre-configured generative models for our app.
+// SectionSplitterWindowPromptFormat is appended to the section splitter prompt when a
+// document too large for one call is split in windows. It is formatted with the window's
+// number and the number of windows.
+const SectionSplitterWindowPromptFormat = `The document is too long to be split in one request, so you are given part %d of %d of it. Parts begin at a header where possible and overlap slightly; the overlap is merged afterwards.
+Split only the text of this part. If it begins with content before its first header, return that content first, as a section whose "section" is an empty string.`
+
+// --- Bundle Splitter Model Prompts ---
+const BundleSplitterSystemPrompt = "You are a specialist document analysis tool. Your task is to find where each distinct document begins in a PDF that bundles several documents together. You must output your response as a valid JSON array."
+
+// BundleSplitterUserPromptFormat is formatted with the number of pages provided and the
+// number of pages in the whole PDF.
+const BundleSplitterUserPromptFormat = `You will be provided with the first %d page(s) of a PDF of %d pages. The PDF bundles several distinct documents, such as a datasheet, a manual and a certificate, into a single file.
+
+Follow these rules precisely:
+1.  If the pages contain a table of contents, cover sheet or index listing the documents in the bundle, use it to find every document and the page on which it starts, including documents that start after the pages provided.
+2.  Otherwise, identify each document that starts within the pages provided, for example from a new title page or a change of document number in the header.
+3.  Give every start page as a page number of this PDF, counting its first page as 1. Page numbers printed in a table of contents may count from another page; convert them.
+4.  Create a JSON object for each document, in page order. Each JSON object must have exactly two keys:
+    - "title": A string containing the document's title as printed (e.g., "XR-200 Installation Manual").
+    - "startPage": An integer, the page of this PDF on which the document starts.
+5.  The first document starts on page 1. The final output MUST be a single, valid JSON array of these objects. Do not include any text before or after the JSON array.
+
+Example output format:
+[
+  {
+    "title": "XR-200 Datasheet",
+    "startPage": 1
+  },
+  {
+    "title": "XR-200 Installation Manual",
+    "startPage": 5
+  }
+]`
+
+// DefaultModelName is the Gemini model used by any stage without an explicit model.
+const DefaultModelName = "gemini-1.5-pro"
+
+// VertexModelConfig names the Gemini model used by each pipeline stage.
+type VertexModelConfig struct {
+	TranslatorModelName      string
+	CleanerModelName         string
+	SectionSplitterModelName string
+	BundleSplitterModelName  string
+}
+
+// LoadVertexModelConfig reads per-stage model names from TRANSLATOR_MODEL_NAME,
+// CLEANER_MODEL_NAME, SECTION_SPLITTER_MODEL_NAME and BUNDLE_SPLITTER_MODEL_NAME. Unset
+// stages fall back to GEMINI_MODEL_NAME and then to DefaultModelName.
+func LoadVertexModelConfig() VertexModelConfig {
+	fallback := GetEnv("GEMINI_MODEL_NAME", DefaultModelName)
+	return VertexModelConfig{
+		TranslatorModelName:      strings.TrimSpace(GetEnv("TRANSLATOR_MODEL_NAME", fallback)),
+		CleanerModelName:         strings.TrimSpace(GetEnv("CLEANER_MODEL_NAME", fallback)),
+		SectionSplitterModelName: strings.TrimSpace(GetEnv("SECTION_SPLITTER_MODEL_NAME", fallback)),
+		BundleSplitterModelName:  strings.TrimSpace(GetEnv("BUNDLE_SPLITTER_MODEL_NAME", fallback)),
+	}
+}
+
+// Validate rejects empty model names.
+func (c VertexModelConfig) Validate() error {
+	var missing []string
+	if strings.TrimSpace(c.TranslatorModelName) == "" {
+		missing = append(missing, "translator")
+	}
+	if strings.TrimSpace(c.CleanerModelName) == "" {
+		missing = append(missing, "cleaner")
+	}
+	if strings.TrimSpace(c.SectionSplitterModelName) == "" {
+		missing = append(missing, "section splitter")
+	}
+	if strings.TrimSpace(c.BundleSplitterModelName) == "" {
+		missing = append(missing, "bundle splitter")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("model name must not be empty for: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// ContentGenerator is the part of *genai.GenerativeModel the services use. Depending on
+// it rather than the concrete model lets a service be exercised without Vertex AI.
+type ContentGenerator interface {
+	GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error)
+}
+
+// TokenCounter counts the input tokens of a call to a model without making the call.
+type TokenCounter interface {
+	CountTokens(ctx context.Context, parts ...genai.Part) (*genai.CountTokensResponse, error)
+}
+
+// systemCounter counts the tokens of a model's system instruction along with those of
+// each call, as the model is billed for both but CountTokens only counts the call's.
+type systemCounter struct {
+	model *genai.GenerativeModel
+}
+
+// CountTokens implements TokenCounter.
+func (c systemCounter) CountTokens(ctx context.Context, parts ...genai.Part) (*genai.CountTokensResponse, error) {
+	if c.model.SystemInstruction != nil {
+		parts = append(slices.Clone(c.model.SystemInstruction.Parts), parts...)
+	}
+	return c.model.CountTokens(ctx, parts...)
+}
+
+// VertexClient holds the generative models of every pipeline stage. Each stage's model is
+// configured on first use, so a service only pays for the models it asks for.
 type VertexClient struct {
-	TranslatorModel      *genai.GenerativeModel
-	CleanerModel         *genai.GenerativeModel
-	SectionSplitterModel *genai.GenerativeModel // <-- ADDED
-	baseClient           *genai.Client
+	ModelNames VertexModelConfig
+	baseClient *genai.Client
+	// generativeModel returns a model of baseClient by name. Models are created through
+	// it alone, so tests can count them.
+	generativeModel func(name string) *genai.GenerativeModel
+	// limiter is shared by every ContentGenerator the client hands out, including those
+	// of its regional clients.
+	limiter *VertexLimiter
+	// auditor records the calls of every ContentGenerator the client hands out, and is
+	// shared with its regional clients like limiter. It is nil when auditing is disabled.
+	auditor *Auditor
+
+	// Each stage's model is created once, on first use, by the matching sync.Once.
+	// translatorProfiles holds a translator model per prompt profile, each with the
+	// profile's system prompt.
+	translatorOnce       sync.Once
+	translatorProfiles   map[string]*genai.GenerativeModel
+	cleanerOnce          sync.Once
+	cleanerModel         *genai.GenerativeModel
+	sectionSplitterOnce  sync.Once
+	sectionSplitterModel *genai.GenerativeModel
+	bundleSplitterOnce   sync.Once
+	bundleSplitterModel  *genai.GenerativeModel
+
+	projectID string
+	region    string
+	// regionalMu guards regional, the clients of other regions created by ForRegion.
+	regionalMu sync.Mutex
+	regional   map[string]*VertexClient
 }
 
-// NewVertexClient creates a new client holding all necessary models.
-func NewVertexClient(ctx context.Context, projectID, region string) (*VertexClient, error) {
+// NewVertexClient creates a client of the models named by models. No model is configured
+// until a service asks for it.
+func NewVertexClient(ctx context.Context, projectID, region string, models VertexModelConfig) (*VertexClient, error) {
 	if projectID == "" || region == "" {
 		return nil, fmt.Errorf("NewVertexClient: projectID and region cannot be empty")
 	}
-
-	baseClient, err := genai.NewClient(ctx, projectID, region)
+	if err := models.Validate(); err != nil {
+		return nil, fmt.Errorf("NewVertexClient: %w", err)
+	}
+	limits, err := LoadVertexLimits()
 	if err != nil {
-		return nil, fmt.Errorf("genai.NewClient: %w", err)
+		return nil, fmt.Errorf("NewVertexClient: %w", err)
 	}
-
-	// --- Configure the translator model ---
-	translatorModel := baseClient.GenerativeModel("gemini-1.5-pro")
-	translatorModel.SystemInstruction = &genai.Content{
-		Parts: []genai.Part{genai.Text(TranslatorSystemPrompt)},
+	auditor, err := NewAuditorFromEnv(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("NewVertexClient: %w", err)
 	}
-	// ... (translator model config remains the same) ...
-
-	// --- Configure the cleaner model ---
-	cleanerModel := baseClient.GenerativeModel("gemini-1.5-pro")
-	cleanerModel.SystemInstruction = &genai.Content{
-		Parts: []genai.Part{genai.Text(CleanerSystemPrompt)},
+	client, err := newVertexClient(ctx, projectID, region, models, NewVertexLimiter(limits), auditor)
+	if err != nil && auditor != nil {
+		auditor.Close()
 	}
-	// ... (cleaner model config remains the same) ...
+	return client, err
+}
 
-	// --- Configure the section splitter model ---
-	sectionSplitterModel := baseClient.GenerativeModel("gemini-1.5-pro")
-	sectionSplitterModel.SystemInstruction = &genai.Content{
-		Parts: []genai.Part{genai.Text(SectionSplitterSystemPrompt)},
+// ForRegion returns a client of the same models served from region, creating it on first
+// use and caching it for the life of c. The client's own region returns c.
+func (c *VertexClient) ForRegion(ctx context.Context, region string) (*VertexClient, error) {
+	if region == "" || region == c.region {
+		return c, nil
+	}
+	c.regionalMu.Lock()
+	defer c.regionalMu.Unlock()
+	if client, ok := c.regional[region]; ok {
+		return client, nil
 	}
-	sectionSplitterModel.GenerationConfig = genai.GenerationConfig{
-		// Force JSON output. This is a critical setting for this model.
-		ResponseMIMEType: "application/json",
-		Temperature:      genai.Ptr[float32](0.0), // Low temp for deterministic, structured output
+	client, err := newVertexClient(ctx, c.projectID, region, c.ModelNames, c.limiter, c.auditor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Vertex AI client for region %s: %w", region, err)
 	}
-	sectionSplitterModel.SafetySettings = []*genai.SafetySetting{
-		{Category: genai.HarmCategoryHateSpeech, Threshold: genai.HarmBlockNone},
-		{Category: genai.HarmCategoryDangerousContent, Threshold: genai.HarmBlockNone},
-		{Category: genai.HarmCategorySexuallyExplicit, Threshold: genai.HarmBlockNone},
-		{Category: genai.HarmCategoryHarassment, Threshold: genai.HarmBlockNone},
+	if c.regional == nil {
+		c.regional = make(map[string]*VertexClient)
 	}
+	c.regional[region] = client
+	return client, nil
+}
 
+// Region returns the region the client's models are served from.
+func (c *VertexClient) Region() string {
+	return c.region
+}
+
+// newVertexClient creates a client in region, making calls through limiter and recording
+// them with auditor, which may be nil.
+func newVertexClient(ctx context.Context, projectID, region string, models VertexModelConfig, limiter *VertexLimiter, auditor *Auditor) (*VertexClient, error) {
+	baseClient, err := genai.NewClient(ctx, projectID, region)
+	if err != nil {
+		return nil, fmt.Errorf("genai.NewClient: %w", err)
+	}
 	return &VertexClient{
-		TranslatorModel:      translatorModel,
-		CleanerModel:         cleanerModel,
-		SectionSplitterModel: sectionSplitterModel, // <-- ADDED
-		baseClient:           baseClient,
+		ModelNames:      models,
+		baseClient:      baseClient,
+		generativeModel: baseClient.GenerativeModel,
+		limiter:         limiter,
+		auditor:         auditor,
+		projectID:       projectID,
+		region:          region,
 	}, nil
 }
 
+// translatorModels configures the translator model of each prompt profile on first use.
+func (c *VertexClient) translatorModels() map[string]*genai.GenerativeModel {
+	c.translatorOnce.Do(func() {
+		c.translatorProfiles = make(map[string]*genai.GenerativeModel, len(TranslatorPromptProfiles)+1)
+		system := map[string]string{PromptProfileDefault: TranslatorSystemPrompt}
+		for profile, prompt := range TranslatorPromptProfiles {
+			if profile != PromptProfileDefault {
+				system[profile] = prompt.System
+			}
+		}
+		for profile, prompt := range system {
+			model := c.generativeModel(c.ModelNames.TranslatorModelName)
+			model.SystemInstruction = &genai.Content{
+				Parts: []genai.Part{genai.Text(prompt)},
+			}
+			c.translatorProfiles[profile] = model
+		}
+	})
+	return c.translatorProfiles
+}
+
+// cleaner configures the cleaner model on first use.
+func (c *VertexClient) cleaner() *genai.GenerativeModel {
+	c.cleanerOnce.Do(func() {
+		c.cleanerModel = c.generativeModel(c.ModelNames.CleanerModelName)
+		c.cleanerModel.SystemInstruction = &genai.Content{
+			Parts: []genai.Part{genai.Text(CleanerSystemPrompt)},
+		}
+	})
+	return c.cleanerModel
+}
+
+// sectionSplitter configures the section splitter model on first use.
+func (c *VertexClient) sectionSplitter() *genai.GenerativeModel {
+	c.sectionSplitterOnce.Do(func() {
+		model := c.generativeModel(c.ModelNames.SectionSplitterModelName)
+		model.SystemInstruction = &genai.Content{
+			Parts: []genai.Part{genai.Text(SectionSplitterSystemPrompt)},
+		}
+		model.GenerationConfig = genai.GenerationConfig{
+			// Force JSON output. This is a critical setting for this model.
+			ResponseMIMEType: "application/json",
+			Temperature:      genai.Ptr[float32](0.0), // Low temp for deterministic, structured output
+		}
+		model.SafetySettings = []*genai.SafetySetting{
+			{Category: genai.HarmCategoryHateSpeech, Threshold: genai.HarmBlockNone},
+			{Category: genai.HarmCategoryDangerousContent, Threshold: genai.HarmBlockNone},
+			{Category: genai.HarmCategorySexuallyExplicit, Threshold: genai.HarmBlockNone},
+			{Category: genai.HarmCategoryHarassment, Threshold: genai.HarmBlockNone},
+		}
+		c.sectionSplitterModel = model
+	})
+	return c.sectionSplitterModel
+}
+
+// bundleSplitter configures the bundle splitter model on first use.
+func (c *VertexClient) bundleSplitter() *genai.GenerativeModel {
+	c.bundleSplitterOnce.Do(func() {
+		model := c.generativeModel(c.ModelNames.BundleSplitterModelName)
+		model.SystemInstruction = &genai.Content{
+			Parts: []genai.Part{genai.Text(BundleSplitterSystemPrompt)},
+		}
+		model.GenerationConfig = genai.GenerationConfig{
+			ResponseMIMEType: "application/json",
+			Temperature:      genai.Ptr[float32](0.0),
+		}
+		c.bundleSplitterModel = model
+	})
+	return c.bundleSplitterModel
+}
+
+// Translator returns the translator model as a ContentGenerator.
+func (c *VertexClient) Translator() ContentGenerator {
+	return c.limiter.Wrap(&meteredGenerator{model: c.audited(c.translatorModels()[PromptProfileDefault], models.TokenUsageStageTranslator), stage: models.TokenUsageStageTranslator})
+}
+
+// TranslatorProfiles returns a translator model per prompt profile, keyed by profile name.
+func (c *VertexClient) TranslatorProfiles() map[string]ContentGenerator {
+	translatorModels := c.translatorModels()
+	profiles := make(map[string]ContentGenerator, len(translatorModels))
+	for profile, model := range translatorModels {
+		profiles[profile] = c.limiter.Wrap(&meteredGenerator{model: c.audited(model, models.TokenUsageStageTranslator), stage: models.TokenUsageStageTranslator})
+	}
+	return profiles
+}
+
+// TranslatorCounters returns a token counter of the translator model per prompt profile,
+// keyed by profile name. Counting is neither rate limited nor audited.
+func (c *VertexClient) TranslatorCounters() map[string]TokenCounter {
+	translatorModels := c.translatorModels()
+	counters := make(map[string]TokenCounter, len(translatorModels))
+	for profile, model := range translatorModels {
+		counters[profile] = systemCounter{model: model}
+	}
+	return counters
+}
+
+// CleanerCounter returns a token counter of the cleaner model.
+func (c *VertexClient) CleanerCounter() TokenCounter {
+	return systemCounter{model: c.cleaner()}
+}
+
+// Cleaner returns the cleaner model as a ContentGenerator.
+func (c *VertexClient) Cleaner() ContentGenerator {
+	return c.limiter.Wrap(&meteredGenerator{model: c.audited(c.cleaner(), models.TokenUsageStageCleaner), stage: models.TokenUsageStageCleaner})
+}
+
+// SectionSplitter returns the section splitter model as a ContentGenerator.
+func (c *VertexClient) SectionSplitter() ContentGenerator {
+	return c.limiter.Wrap(&meteredGenerator{model: c.audited(c.sectionSplitter(), models.TokenUsageStageSectionSplitter), stage: models.TokenUsageStageSectionSplitter})
+}
+
+// BundleSplitter returns the bundle splitter model as a ContentGenerator.
+func (c *VertexClient) BundleSplitter() ContentGenerator {
+	return c.limiter.Wrap(&meteredGenerator{model: c.audited(c.bundleSplitter(), models.TokenUsageStageBundleSplitter), stage: models.TokenUsageStageBundleSplitter})
+}
+
+// audited returns model as a ContentGenerator whose calls are recorded as made by stage,
+// or model itself when auditing is disabled.
+func (c *VertexClient) audited(model *genai.GenerativeModel, stage string) ContentGenerator {
+	if c.auditor == nil {
+		return model
+	}
+	return &auditedGenerator{model: model, stage: stage, auditor: c.auditor}
+}
+
+// Close closes the client, the clients of other regions it created and its auditor.
 func (c *VertexClient) Close() error {
+	c.regionalMu.Lock()
+	defer c.regionalMu.Unlock()
+	for _, client := range c.regional {
+		client.Close()
+	}
+	if c.auditor != nil {
+		c.auditor.Close()
+	}
 	if c.baseClient != nil {
 		return c.baseClient.Close()
 	}
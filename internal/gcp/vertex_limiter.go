@@ -0,0 +1,107 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/vertexai/genai"
+	"golang.org/x/time/rate"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// VertexLimits bounds the Gemini calls made by one process. Every stage draws on the
+// same project quota, so the limits apply across all of a VertexClient's models.
+type VertexLimits struct {
+	MaxConcurrent int           // Calls in flight at once; 0 means unlimited.
+	QPM           int           // Calls started per minute; 0 means unlimited.
+	WaitWarnAfter time.Duration // Waits longer than this are logged.
+}
+
+// LoadVertexLimits reads VERTEX_MAX_CONCURRENT, VERTEX_QPM and VERTEX_LIMIT_WAIT_WARN.
+func LoadVertexLimits() (VertexLimits, error) {
+	maxConcurrent, err := strconv.Atoi(GetEnv("VERTEX_MAX_CONCURRENT", "8"))
+	if err != nil || maxConcurrent < 0 {
+		return VertexLimits{}, fmt.Errorf("VERTEX_MAX_CONCURRENT must be a non-negative integer")
+	}
+	qpm, err := strconv.Atoi(GetEnv("VERTEX_QPM", "0"))
+	if err != nil || qpm < 0 {
+		return VertexLimits{}, fmt.Errorf("VERTEX_QPM must be a non-negative integer")
+	}
+	waitWarnAfter, err := time.ParseDuration(GetEnv("VERTEX_LIMIT_WAIT_WARN", "5s"))
+	if err != nil {
+		return VertexLimits{}, fmt.Errorf("VERTEX_LIMIT_WAIT_WARN must be a valid duration: %w", err)
+	}
+	return VertexLimits{MaxConcurrent: maxConcurrent, QPM: qpm, WaitWarnAfter: waitWarnAfter}, nil
+}
+
+// VertexLimiter admits Gemini calls within a concurrency limit and a rate limit.
+type VertexLimiter struct {
+	slots         chan struct{} // nil when concurrency is unlimited.
+	rate          *rate.Limiter // nil when the rate is unlimited.
+	waitWarnAfter time.Duration
+}
+
+// NewVertexLimiter creates a limiter enforcing limits.
+func NewVertexLimiter(limits VertexLimits) *VertexLimiter {
+	l := &VertexLimiter{waitWarnAfter: limits.WaitWarnAfter}
+	if limits.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, limits.MaxConcurrent)
+	}
+	if limits.QPM > 0 {
+		// A burst of one spreads calls evenly across the minute instead of letting a
+		// fan-out spend the whole minute's quota at once.
+		l.rate = rate.NewLimiter(rate.Limit(float64(limits.QPM)/60), 1)
+	}
+	return l
+}
+
+// Acquire blocks until a call may start or ctx is done. On success the returned function
+// must be called once the call has finished.
+func (l *VertexLimiter) Acquire(ctx context.Context) (func(), error) {
+	start := time.Now()
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for a Vertex AI call slot: %w", ctx.Err())
+		}
+	}
+	release := func() {
+		if l.slots != nil {
+			<-l.slots
+		}
+	}
+	if l.rate != nil {
+		if err := l.rate.Wait(ctx); err != nil {
+			release()
+			return nil, fmt.Errorf("waiting for the Vertex AI rate limit: %w", err)
+		}
+	}
+	if waited := time.Since(start); l.waitWarnAfter > 0 && waited > l.waitWarnAfter {
+		slog.Warn("Vertex AI call waited for the process limits.", "waited", waited.String(), "inFlight", len(l.slots), "maxConcurrent", cap(l.slots))
+	}
+	return release, nil
+}
+
+// Wrap returns a ContentGenerator that makes each call through the limiter.
+func (l *VertexLimiter) Wrap(model ContentGenerator) ContentGenerator {
+	return &limitedGenerator{model: model, limiter: l}
+}
+
+type limitedGenerator struct {
+	model   ContentGenerator
+	limiter *VertexLimiter
+}
+
+// GenerateContent implements ContentGenerator.
+func (g *limitedGenerator) GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	release, err := g.limiter.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return g.model.GenerateContent(ctx, parts...)
+}
@@ -0,0 +1,96 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// countingGenerator holds each call for delay, recording how many overlap.
+type countingGenerator struct {
+	delay        time.Duration
+	active, peak atomic.Int32
+	calls        atomic.Int32
+}
+
+func (g *countingGenerator) GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	g.calls.Add(1)
+	active := g.active.Add(1)
+	defer g.active.Add(-1)
+	for peak := g.peak.Load(); active > peak && !g.peak.CompareAndSwap(peak, active); peak = g.peak.Load() {
+	}
+	time.Sleep(g.delay)
+	return &genai.GenerateContentResponse{}, nil
+}
+
+func TestVertexLimiterBoundsCallsInFlight(t *testing.T) {
+	model := &countingGenerator{delay: 10 * time.Millisecond}
+	limited := NewVertexLimiter(VertexLimits{MaxConcurrent: 3}).Wrap(model)
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := limited.GenerateContent(context.Background(), genai.Text("page")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls := model.calls.Load(); calls != 20 {
+		t.Errorf("%d calls made, want 20", calls)
+	}
+	if peak := model.peak.Load(); peak != 3 {
+		t.Errorf("%d calls in flight at most, want 3", peak)
+	}
+}
+
+func TestVertexLimiterSpreadsCallsOverTheMinute(t *testing.T) {
+	model := &countingGenerator{}
+	limited := NewVertexLimiter(VertexLimits{QPM: 1200}).Wrap(model) // One call every 50ms.
+
+	start := time.Now()
+	for range 4 {
+		if _, err := limited.GenerateContent(context.Background(), genai.Text("page")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("4 calls took %v, want about 150ms at 1200 QPM", elapsed)
+	}
+}
+
+func TestVertexLimiterStopsWaitingWhenCancelled(t *testing.T) {
+	limiter := NewVertexLimiter(VertexLimits{MaxConcurrent: 1})
+	release, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	model := &countingGenerator{}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.Wrap(model).GenerateContent(ctx, genai.Text("page")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GenerateContent() error = %v, want context.DeadlineExceeded", err)
+	}
+	if calls := model.calls.Load(); calls != 0 {
+		t.Errorf("%d calls made while no slot was free, want 0", calls)
+	}
+
+	rateLimited := NewVertexLimiter(VertexLimits{QPM: 1})
+	if _, err := rateLimited.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := rateLimited.Acquire(ctx); err == nil {
+		t.Errorf("Acquire() past the rate with a cancelled context = nil, want an error")
+	}
+}
@@ -0,0 +1,190 @@
+package gcp
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// setEnv sets each variable for the test, unsetting those mapped to nil.
+func setEnv(t *testing.T, env map[string]*string) {
+	t.Helper()
+	for key, value := range env {
+		t.Setenv(key, "")
+		if value == nil {
+			os.Unsetenv(key)
+		} else {
+			os.Setenv(key, *value)
+		}
+	}
+}
+
+func TestLoadVertexModelConfig(t *testing.T) {
+	value := func(s string) *string { return &s }
+	tests := []struct {
+		name string
+		env  map[string]*string
+		want VertexModelConfig
+	}{
+		{
+			name: "defaults",
+			want: VertexModelConfig{
+				TranslatorModelName:      DefaultModelName,
+				CleanerModelName:         DefaultModelName,
+				SectionSplitterModelName: DefaultModelName,
+				BundleSplitterModelName:  DefaultModelName,
+			},
+		},
+		{
+			name: "shared fallback",
+			env:  map[string]*string{"GEMINI_MODEL_NAME": value("gemini-1.5-flash")},
+			want: VertexModelConfig{
+				TranslatorModelName:      "gemini-1.5-flash",
+				CleanerModelName:         "gemini-1.5-flash",
+				SectionSplitterModelName: "gemini-1.5-flash",
+				BundleSplitterModelName:  "gemini-1.5-flash",
+			},
+		},
+		{
+			name: "per stage",
+			env: map[string]*string{
+				"GEMINI_MODEL_NAME":     value("gemini-1.5-flash"),
+				"TRANSLATOR_MODEL_NAME": value(" gemini-1.5-pro "),
+				"CLEANER_MODEL_NAME":    value("gemini-1.5-flash-002"),
+			},
+			want: VertexModelConfig{
+				TranslatorModelName:      "gemini-1.5-pro",
+				CleanerModelName:         "gemini-1.5-flash-002",
+				SectionSplitterModelName: "gemini-1.5-flash",
+				BundleSplitterModelName:  "gemini-1.5-flash",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]*string{
+				"GEMINI_MODEL_NAME":           nil,
+				"TRANSLATOR_MODEL_NAME":       nil,
+				"CLEANER_MODEL_NAME":          nil,
+				"SECTION_SPLITTER_MODEL_NAME": nil,
+				"BUNDLE_SPLITTER_MODEL_NAME":  nil,
+			}
+			for key, value := range tt.env {
+				env[key] = value
+			}
+			setEnv(t, env)
+			if got := LoadVertexModelConfig(); got != tt.want {
+				t.Errorf("LoadVertexModelConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVertexModelConfigValidate(t *testing.T) {
+	valid := VertexModelConfig{
+		TranslatorModelName:      "gemini-1.5-pro",
+		CleanerModelName:         "gemini-1.5-flash",
+		SectionSplitterModelName: "gemini-1.5-flash",
+		BundleSplitterModelName:  "gemini-1.5-flash",
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() of a complete config = %v", err)
+	}
+
+	invalid := valid
+	invalid.CleanerModelName = "  "
+	invalid.BundleSplitterModelName = ""
+	err := invalid.Validate()
+	if err == nil {
+		t.Fatal("Validate() of empty names = nil, want an error")
+	}
+	if !strings.Contains(err.Error(), "cleaner, bundle splitter") {
+		t.Errorf("Validate() = %v, want it to name the cleaner and bundle splitter", err)
+	}
+}
+
+// countingVertexClient returns a client whose models are never called, with the number
+// of models it has created of each name.
+func countingVertexClient() (*VertexClient, func() map[string]int) {
+	var mu sync.Mutex
+	created := make(map[string]int)
+	c := &VertexClient{
+		ModelNames: VertexModelConfig{
+			TranslatorModelName:      "translator",
+			CleanerModelName:         "cleaner",
+			SectionSplitterModelName: "section-splitter",
+			BundleSplitterModelName:  "bundle-splitter",
+		},
+		generativeModel: func(name string) *genai.GenerativeModel {
+			mu.Lock()
+			defer mu.Unlock()
+			created[name]++
+			return &genai.GenerativeModel{}
+		},
+	}
+	return c, func() map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		counts := make(map[string]int, len(created))
+		for name, n := range created {
+			counts[name] = n
+		}
+		return counts
+	}
+}
+
+func TestVertexClientCreatesModelsOnFirstUse(t *testing.T) {
+	c, created := countingVertexClient()
+	if got := created(); len(got) != 0 {
+		t.Fatalf("models created before use = %v, want none", got)
+	}
+
+	c.Cleaner()
+	c.Cleaner()
+	c.CleanerCounter()
+	if got := created(); len(got) != 1 || got["cleaner"] != 1 {
+		t.Errorf("models created after using the cleaner = %v, want the cleaner's alone, once", got)
+	}
+	if prompt := c.cleaner().SystemInstruction; prompt == nil || prompt.Parts[0] != genai.Text(CleanerSystemPrompt) {
+		t.Errorf("cleaner system instruction = %+v, want the cleaner prompt", prompt)
+	}
+
+	profiles := c.TranslatorProfiles()
+	c.Translator()
+	c.TranslatorCounters()
+	if _, ok := profiles[PromptProfileDefault]; !ok {
+		t.Errorf("TranslatorProfiles() = %v, want the default profile", profiles)
+	}
+	if got := created()["translator"]; got != len(profiles) {
+		t.Errorf("translator models created = %d, want one per profile (%d)", got, len(profiles))
+	}
+
+	c.SectionSplitter()
+	c.SectionSplitter()
+	if got := created(); got["section-splitter"] != 1 || got["bundle-splitter"] != 0 {
+		t.Errorf("models created = %v, want the section splitter's once and no bundle splitter", got)
+	}
+	if got := c.sectionSplitter().GenerationConfig.ResponseMIMEType; got != "application/json" {
+		t.Errorf("section splitter response type = %q, want JSON", got)
+	}
+}
+
+func TestVertexClientCreatesModelsOnceConcurrently(t *testing.T) {
+	c, created := countingVertexClient()
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.BundleSplitter()
+			c.TranslatorProfiles()
+		}()
+	}
+	wg.Wait()
+	if got := created(); got["bundle-splitter"] != 1 || got["translator"] != len(c.TranslatorProfiles()) {
+		t.Errorf("models created = %v, want each created once", got)
+	}
+}
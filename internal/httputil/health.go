@@ -0,0 +1,84 @@
+package httputil
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// HealthPath is the path that health and readiness checks are routed to.
+const HealthPath = "/healthz"
+
+// healthCheckTimeout bounds the component checks of a single health request.
+const healthCheckTimeout = 5 * time.Second
+
+// Values of HealthResponse.Status and of each component's status.
+const (
+	HealthOK          = "ok"
+	HealthUnavailable = "unavailable"
+)
+
+// HealthResponse is the body of a health check response.
+type HealthResponse struct {
+	Status string `json:"status"`
+	// Error is the initialization error, when the service failed to start.
+	Error string `json:"error,omitempty"`
+	// Components maps each checked dependency to "ok" or the error it reported.
+	Components map[string]string `json:"components,omitempty"`
+}
+
+// deepHealthCheck reads DEEP_HEALTHCHECK once. When it is true, health checks also call
+// the services' dependencies instead of only checking that their clients were created.
+var deepHealthCheck = sync.OnceValue(func() bool {
+	deep, _ := strconv.ParseBool(gcp.GetEnv("DEEP_HEALTHCHECK", "false"))
+	return deep
+})
+
+// IsHealthCheck reports whether r is a health check, GET /healthz, rather than a request
+// for the function itself.
+func IsHealthCheck(r *http.Request) bool {
+	return r.Method == http.MethodGet && r.URL.Path == HealthPath
+}
+
+// ServeHealth answers a health check. It runs the service's one-time initialization, then
+// the component checks returned by checks, and responds 200 if all of them pass or 503
+// with the failures. checks is only called once initialization has succeeded.
+func ServeHealth(w http.ResponseWriter, r *http.Request, initialize func() error, checks func(deep bool) map[string]func(context.Context) error) {
+	res := HealthResponse{Status: HealthOK}
+	if err := initialize(); err != nil {
+		res.Status, res.Error = HealthUnavailable, err.Error()
+		writeHealth(w, res)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+	res.Components = make(map[string]string)
+	for name, check := range checks(deepHealthCheck()) {
+		res.Components[name] = HealthOK
+		if err := check(ctx); err != nil {
+			res.Status, res.Components[name] = HealthUnavailable, err.Error()
+		}
+	}
+	writeHealth(w, res)
+}
+
+func writeHealth(w http.ResponseWriter, res HealthResponse) {
+	code := http.StatusOK
+	if res.Status != HealthOK {
+		slog.Warn("Health check failed", "error", res.Error, "components", res.Components)
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		slog.Error("Failed to write health response", "error", err)
+	}
+}
@@ -0,0 +1,110 @@
+package httputil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestServeHealth(t *testing.T) {
+	pass := func(context.Context) error { return nil }
+	tests := []struct {
+		name       string
+		initErr    error
+		checks     map[string]func(context.Context) error
+		wantCode   int
+		wantChecks bool
+		want       HealthResponse
+	}{
+		{
+			name:     "initialization failed",
+			initErr:  errors.New("PROJECT_ID environment variable must be set"),
+			wantCode: http.StatusServiceUnavailable,
+			want:     HealthResponse{Status: HealthUnavailable, Error: "PROJECT_ID environment variable must be set"},
+		},
+		{
+			name:       "healthy",
+			checks:     map[string]func(context.Context) error{"firestore": pass, "storage": pass},
+			wantCode:   http.StatusOK,
+			wantChecks: true,
+			want:       HealthResponse{Status: HealthOK, Components: map[string]string{"firestore": HealthOK, "storage": HealthOK}},
+		},
+		{
+			name: "component unavailable",
+			checks: map[string]func(context.Context) error{
+				"firestore": pass,
+				"storage":   func(context.Context) error { return errors.New("bucket pages not found") },
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantChecks: true,
+			want:       HealthResponse{Status: HealthUnavailable, Components: map[string]string{"firestore": HealthOK, "storage": "bucket pages not found"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var checked bool
+			checks := func(deep bool) map[string]func(context.Context) error {
+				checked = true
+				return tt.checks
+			}
+			w := httptest.NewRecorder()
+			ServeHealth(w, httptest.NewRequest(http.MethodGet, HealthPath, nil), func() error { return tt.initErr }, checks)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("ServeHealth() status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			var res HealthResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("ServeHealth() wrote %q: %v", w.Body, err)
+			}
+			if !reflect.DeepEqual(res, tt.want) {
+				t.Errorf("ServeHealth() body = %+v, want %+v", res, tt.want)
+			}
+			// The clients the checks use do not exist when initialization failed.
+			if checked != tt.wantChecks {
+				t.Errorf("checks called = %v, want %v", checked, tt.wantChecks)
+			}
+		})
+	}
+}
+
+func TestServeHealthDeep(t *testing.T) {
+	deep := deepHealthCheck
+	t.Cleanup(func() { deepHealthCheck = deep })
+	for _, want := range []bool{false, true} {
+		deepHealthCheck = func() bool { return want }
+		var got bool
+		ServeHealth(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, HealthPath, nil), func() error { return nil }, func(deep bool) map[string]func(context.Context) error {
+			got = deep
+			return nil
+		})
+		if got != want {
+			t.Errorf("checks called with deep = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestIsHealthCheck(t *testing.T) {
+	tests := []struct {
+		method, target string
+		want           bool
+	}{
+		{method: http.MethodGet, target: "/healthz", want: true},
+		{method: http.MethodGet, target: "/healthz?deep=1", want: true},
+		{method: http.MethodPost, target: "/healthz"},
+		{method: http.MethodGet, target: "/"},
+		{method: http.MethodGet, target: "/healthz/extra"},
+	}
+	for _, tt := range tests {
+		if got := IsHealthCheck(httptest.NewRequest(tt.method, tt.target, nil)); got != tt.want {
+			t.Errorf("IsHealthCheck(%s %s) = %v, want %v", tt.method, tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Package httputil holds the request handling shared by the HTTP functions in cmd.
+package httputil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Limits bounds the requests a handler accepts and how long it works on them.
+type Limits struct {
+	MaxBodyBytes int64         // Larger request bodies are rejected with 413.
+	Timeout      time.Duration // Deadline for processing a request.
+}
+
+// Validator is implemented by requests that can check their required fields.
+type Validator interface {
+	Validate() error
+}
+
+// LoadLimits reads MAX_REQUEST_BYTES (default 1 MiB) and REQUEST_TIMEOUT (default 9m).
+// The timeout should stay below the function timeout, so that a slow request gets an
+// error response rather than being killed mid-write.
+func LoadLimits() (Limits, error) {
+	maxBodyBytes, err := strconv.ParseInt(gcp.GetEnv("MAX_REQUEST_BYTES", "1048576"), 10, 64)
+	if err != nil || maxBodyBytes < 1 {
+		return Limits{}, fmt.Errorf("MAX_REQUEST_BYTES must be a positive integer")
+	}
+	timeout, err := time.ParseDuration(gcp.GetEnv("REQUEST_TIMEOUT", "9m"))
+	if err != nil || timeout <= 0 {
+		return Limits{}, fmt.Errorf("REQUEST_TIMEOUT must be a positive duration such as \"9m\"")
+	}
+	return Limits{MaxBodyBytes: maxBodyBytes, Timeout: timeout}, nil
+}
+
+// Context returns the request's context bounded by the processing timeout.
+func (l Limits) Context(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), l.Timeout)
+}
+
+// DecodeJSON decodes the request body into v, rejecting oversized bodies, unknown fields
+// and trailing data, then validates v if it is a Validator. On failure it writes the error
+// response and returns false.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, limits Limits, v any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, limits.MaxBodyBytes))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil && decoder.More() {
+		err = errors.New("unexpected data after the JSON object")
+	}
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		slog.Warn("Request body too large", "limitBytes", maxBytesErr.Limit)
+		writeError(w, http.StatusRequestEntityTooLarge, models.ErrorCodeInvalidRequest, fmt.Sprintf("body exceeds %d bytes", maxBytesErr.Limit))
+		return false
+	case err != nil && strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		slog.Warn("Request has an unknown field", "field", field)
+		writeError(w, http.StatusBadRequest, models.ErrorCodeInvalidRequest, "unknown field "+field)
+		return false
+	case err != nil:
+		slog.Warn("Could not decode request body", "error", err)
+		writeError(w, http.StatusBadRequest, models.ErrorCodeInvalidRequest, "could not parse JSON")
+		return false
+	}
+
+	if validator, ok := v.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			slog.Warn("Invalid request", "error", err)
+			writeError(w, http.StatusBadRequest, models.ErrorCodeInvalidRequest, err.Error())
+			return false
+		}
+	}
+	return true
+}
+
+// WriteProcessError writes the response for a failed Process call: a JSON
+// models.ErrorResponse with the error's code and the HTTP status the code maps to. A
+// request that ran out of time is reported as TIMEOUT whatever the error's code, and one
+// whose body was read past an http.MaxBytesReader limit as 413. The error itself is
+// logged by Process.
+func WriteProcessError(w http.ResponseWriter, ctx context.Context, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeError(w, http.StatusRequestEntityTooLarge, models.ErrorCodeInvalidRequest, fmt.Sprintf("body exceeds %d bytes", maxBytesErr.Limit))
+		return
+	}
+	code := models.CodeOf(err)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		code = models.ErrorCodeTimeout
+	}
+	writeError(w, StatusForCode(code), code, err.Error())
+}
+
+// StatusForCode returns the HTTP status of a response failed with code.
+func StatusForCode(code models.ErrorCode) int {
+	switch code {
+	case models.ErrorCodeInvalidRequest:
+		return http.StatusBadRequest
+	case models.ErrorCodeNotFoundSource, models.ErrorCodeSourceNotReady:
+		return http.StatusNotFound
+	case models.ErrorCodeEmptySource, models.ErrorCodeLLMBlocked, models.ErrorCodeOutputRejected:
+		return http.StatusUnprocessableEntity
+	case models.ErrorCodeLLMQuota:
+		return http.StatusTooManyRequests
+	case models.ErrorCodeLLMUnavailable, models.ErrorCodeLLMMalformed, models.ErrorCodeOutputWriteFailed:
+		return http.StatusBadGateway
+	case models.ErrorCodeTimeout:
+		return http.StatusGatewayTimeout
+	case models.ErrorCodeCancelled:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError writes a JSON error response.
+func writeError(w http.ResponseWriter, status int, code models.ErrorCode, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	res := models.ErrorResponse{Code: code, Message: message, Retryable: code.Retryable()}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		slog.Error("Failed to write error response", "error", err)
+	}
+}
@@ -0,0 +1,223 @@
+package httputil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+type testRequest struct {
+	DocumentID string `json:"documentId"`
+	Pages      []int  `json:"pages,omitempty"`
+}
+
+func (r *testRequest) Validate() error {
+	if r.DocumentID == "" {
+		return errors.New("documentId is required")
+	}
+	return nil
+}
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantOK      bool
+		wantStatus  int
+		wantMessage string
+	}{
+		{name: "valid", body: `{"documentId": "doc-1", "pages": [1, 2]}`, wantOK: true},
+		{name: "trailing whitespace", body: "{\"documentId\": \"doc-1\"}\n", wantOK: true},
+		{name: "oversized", body: `{"documentId": "` + strings.Repeat("x", 64) + `"}`, wantStatus: http.StatusRequestEntityTooLarge, wantMessage: "body exceeds 48 bytes"},
+		{name: "unknown field", body: `{"documentId": "doc-1", "page": 1}`, wantStatus: http.StatusBadRequest, wantMessage: `unknown field "page"`},
+		{name: "trailing garbage", body: `{"documentId": "doc-1"} {"documentId": "doc-2"}`, wantStatus: http.StatusBadRequest, wantMessage: "could not parse JSON"},
+		{name: "malformed", body: `{"documentId": `, wantStatus: http.StatusBadRequest, wantMessage: "could not parse JSON"},
+		{name: "wrong type", body: `{"documentId": 1}`, wantStatus: http.StatusBadRequest, wantMessage: "could not parse JSON"},
+		{name: "empty", body: ``, wantStatus: http.StatusBadRequest, wantMessage: "could not parse JSON"},
+		{name: "invalid", body: `{"pages": [1]}`, wantStatus: http.StatusBadRequest, wantMessage: "documentId is required"},
+	}
+	limits := Limits{MaxBodyBytes: 48, Timeout: time.Minute}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			var req testRequest
+			if ok := DecodeJSON(w, r, limits, &req); ok != tt.wantOK {
+				t.Fatalf("DecodeJSON() = %v, want %v; response %d %s", ok, tt.wantOK, w.Code, w.Body)
+			}
+			if tt.wantOK {
+				if req.DocumentID != "doc-1" {
+					t.Errorf("DecodeJSON() decoded %+v", req)
+				}
+				return
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("DecodeJSON() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			var res models.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("DecodeJSON() wrote %q: %v", w.Body, err)
+			}
+			if res.Code != models.ErrorCodeInvalidRequest || res.Message != tt.wantMessage || res.Retryable {
+				t.Errorf("DecodeJSON() response = %+v, want INVALID_REQUEST %q, not retryable", res, tt.wantMessage)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("DecodeJSON() Content-Type = %q, want application/json", got)
+			}
+		})
+	}
+}
+
+func TestLoadLimits(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxBytes     string
+		timeout      string
+		want         Limits
+		wantErr      bool
+		unsetEnvVars bool
+	}{
+		{name: "defaults", unsetEnvVars: true, want: Limits{MaxBodyBytes: 1 << 20, Timeout: 9 * time.Minute}},
+		{name: "set", maxBytes: "2048", timeout: "30s", want: Limits{MaxBodyBytes: 2048, Timeout: 30 * time.Second}},
+		{name: "zero bytes", maxBytes: "0", timeout: "30s", wantErr: true},
+		{name: "bytes not a number", maxBytes: "1MiB", timeout: "30s", wantErr: true},
+		{name: "timeout without unit", maxBytes: "2048", timeout: "30", wantErr: true},
+		{name: "negative timeout", maxBytes: "2048", timeout: "-1m", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MAX_REQUEST_BYTES", tt.maxBytes)
+			t.Setenv("REQUEST_TIMEOUT", tt.timeout)
+			if tt.unsetEnvVars {
+				os.Unsetenv("MAX_REQUEST_BYTES")
+				os.Unsetenv("REQUEST_TIMEOUT")
+			}
+			got, err := LoadLimits()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("LoadLimits() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// errorCodes returns every ErrorCode constant declared in the models package.
+func errorCodes(t *testing.T) []models.ErrorCode {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "../models/errors.go", nil, 0)
+	if err != nil {
+		t.Fatalf("failed to parse the error codes: %v", err)
+	}
+	var codes []models.ErrorCode
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value := spec.(*ast.ValueSpec)
+			if ident, ok := value.Type.(*ast.Ident); !ok || ident.Name != "ErrorCode" {
+				continue
+			}
+			for _, v := range value.Values {
+				literal := v.(*ast.BasicLit)
+				codes = append(codes, models.ErrorCode(strings.Trim(literal.Value, `"`)))
+			}
+		}
+	}
+	if len(codes) == 0 {
+		t.Fatal("found no error codes in ../models/errors.go")
+	}
+	return codes
+}
+
+func TestWriteProcessErrorMapsEveryCode(t *testing.T) {
+	wantStatus := map[models.ErrorCode]int{
+		models.ErrorCodeInvalidRequest:    http.StatusBadRequest,
+		models.ErrorCodeNotFoundSource:    http.StatusNotFound,
+		models.ErrorCodeSourceNotReady:    http.StatusNotFound,
+		models.ErrorCodeEmptySource:       http.StatusUnprocessableEntity,
+		models.ErrorCodeLLMBlocked:        http.StatusUnprocessableEntity,
+		models.ErrorCodeLLMQuota:          http.StatusTooManyRequests,
+		models.ErrorCodeLLMUnavailable:    http.StatusBadGateway,
+		models.ErrorCodeLLMMalformed:      http.StatusBadGateway,
+		models.ErrorCodeOutputWriteFailed: http.StatusBadGateway,
+		models.ErrorCodeOutputRejected:    http.StatusUnprocessableEntity,
+		models.ErrorCodeTimeout:           http.StatusGatewayTimeout,
+		models.ErrorCodeCancelled:         http.StatusConflict,
+		models.ErrorCodeInternal:          http.StatusInternalServerError,
+	}
+	for _, code := range errorCodes(t) {
+		t.Run(string(code), func(t *testing.T) {
+			status, ok := wantStatus[code]
+			if !ok {
+				t.Fatalf("error code %s has no expected HTTP status; add one here and to StatusForCode", code)
+			}
+			if got := StatusForCode(code); got != status {
+				t.Errorf("StatusForCode(%s) = %d, want %d", code, got, status)
+			}
+			if code != models.ErrorCodeInternal && StatusForCode(code) == http.StatusInternalServerError {
+				t.Errorf("StatusForCode(%s) falls through to 500", code)
+			}
+
+			w := httptest.NewRecorder()
+			err := fmt.Errorf("stage failed: %w", models.WithCode(code, errors.New("boom")))
+			WriteProcessError(w, context.Background(), err)
+			if w.Code != status {
+				t.Errorf("WriteProcessError() status = %d, want %d", w.Code, status)
+			}
+			var res models.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("WriteProcessError() wrote %q: %v", w.Body, err)
+			}
+			want := models.ErrorResponse{Code: code, Message: "stage failed: boom", Retryable: code.Retryable()}
+			if res != want {
+				t.Errorf("WriteProcessError() body = %+v, want %+v", res, want)
+			}
+		})
+	}
+}
+
+func TestWriteProcessErrorOverrides(t *testing.T) {
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		err        error
+		wantStatus int
+		wantCode   models.ErrorCode
+	}{
+		{name: "deadline exceeded", ctx: context.Background(), err: fmt.Errorf("call: %w", context.DeadlineExceeded), wantStatus: http.StatusGatewayTimeout, wantCode: models.ErrorCodeTimeout},
+		{name: "request out of time", ctx: expired, err: models.WithCode(models.ErrorCodeLLMUnavailable, errors.New("boom")), wantStatus: http.StatusGatewayTimeout, wantCode: models.ErrorCodeTimeout},
+		{name: "body too large", ctx: context.Background(), err: &http.MaxBytesError{Limit: 10}, wantStatus: http.StatusRequestEntityTooLarge, wantCode: models.ErrorCodeInvalidRequest},
+		{name: "unclassified", ctx: context.Background(), err: errors.New("boom"), wantStatus: http.StatusInternalServerError, wantCode: models.ErrorCodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			WriteProcessError(w, tt.ctx, tt.err)
+			var res models.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("WriteProcessError() wrote %q: %v", w.Body, err)
+			}
+			if w.Code != tt.wantStatus || res.Code != tt.wantCode {
+				t.Errorf("WriteProcessError() = %d %s, want %d %s", w.Code, res.Code, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package httputil
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// CloseOnShutdown closes client when the instance is sent SIGTERM, which Cloud Functions
+// sends before stopping it, or an interrupt, so that its connections drain rather than
+// being cut. The signal is then raised again with its default handling restored, so the
+// process exits as it would have without the hook.
+func CloseOnShutdown(client io.Closer) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		slog.Info("Shutting down; closing clients.", "signal", sig.String())
+		if err := client.Close(); err != nil {
+			slog.Warn("Failed to close clients on shutdown", "error", err)
+		}
+		signal.Reset(syscall.SIGTERM, os.Interrupt)
+		if process, err := os.FindProcess(os.Getpid()); err == nil {
+			process.Signal(sig)
+		}
+	}()
+}
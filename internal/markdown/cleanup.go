@@ -0,0 +1,323 @@
+package markdown
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// pageAnchorRegex matches a line holding only a page anchor written by the
+	// aggregator, e.g. "<!-- page: 12 -->" or "<!-- page: 11-15 -->".
+	pageAnchorRegex = regexp.MustCompile(`^[ \t]*<!-- page: \d+(?:-\d+)? -->[ \t]*$`)
+	// atxHeadingRegex matches an ATX heading, capturing its marker and its text without
+	// any closing #s.
+	atxHeadingRegex = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	// tableDelimiterRegex matches the delimiter row under a table's header.
+	tableDelimiterRegex = regexp.MustCompile(`^[ \t]*\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+	// hyphenatedEndRegex matches a line ending in a word broken with a hyphen.
+	hyphenatedEndRegex = regexp.MustCompile(`\p{L}-$`)
+	// brokenWordRegex captures the last word of a line ending in a hyphen, including any
+	// hyphens inside it.
+	brokenWordRegex = regexp.MustCompile(`([\p{L}\p{N}]+(?:-[\p{L}\p{N}]+)*)-$`)
+)
+
+// compoundPrefixes are words that, followed by a hyphen at the end of a line, almost
+// always start a hyphenated compound ("well-known", "self-contained") rather than a
+// word broken into syllables.
+var compoundPrefixes = map[string]bool{
+	"all": true, "cross": true, "ever": true, "full": true, "half": true, "high": true,
+	"ill": true, "low": true, "multi": true, "non": true, "self": true, "semi": true,
+	"well": true,
+}
+
+// Clean runs every deterministic cleanup pass over a master file, in an order where
+// each pass sees the output the one before it expects: page separators are removed,
+// tables broken across pages merged, hyphenated words rejoined and heading levels made
+// consistent. Tables merge when their headers are at least headerSimilarityThreshold
+// alike, as in MergeAdjacentTables.
+func Clean(md string, headerSimilarityThreshold float64) string {
+	md = RemovePageSeparators(md)
+	md = MergeAdjacentTables(md, headerSimilarityThreshold)
+	md = JoinHyphenatedLineBreaks(md)
+	return NormalizeHeadings(md)
+}
+
+// splitLines splits md into lines and reports which of them are inside, or open or
+// close, a fenced code block. None of the passes change those lines.
+func splitLines(md string) (lines []string, code []bool) {
+	lines = strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n")
+	code = make([]bool, len(lines))
+	var open *fence
+	for i, line := range lines {
+		if f, ok := parseFence(line); ok {
+			code[i] = true
+			if open == nil {
+				open = &f
+			} else if f.closes(*open) {
+				open = nil
+			}
+			continue
+		}
+		code[i] = open != nil
+	}
+	return lines, code
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// isPageSeparator reports whether line is a "---" thematic break, which is how the
+// aggregator separates pages when it writes no anchors.
+func isPageSeparator(line string) bool {
+	return strings.TrimSpace(line) == "---"
+}
+
+// RemovePageSeparators removes "---" lines standing on their own between blank lines,
+// as the aggregator writes between pages, along with the blank line after each. A "---"
+// directly under text is a setext heading underline and is kept.
+func RemovePageSeparators(md string) string {
+	lines, code := splitLines(md)
+	out := make([]string, 0, len(lines))
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if !code[i] && isPageSeparator(line) &&
+			(i == 0 || isBlank(lines[i-1])) && (i == len(lines)-1 || isBlank(lines[i+1])) {
+			if len(out) > 0 && i+1 < len(lines) {
+				i++ // Drop the blank line after, leaving the one before to separate the pages.
+			}
+			continue
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// JoinHyphenatedLineBreaks rejoins words broken with a hyphen at the end of a line,
+// such as "engi-" followed by "neering", when the next line starts in lower case. The
+// hyphen is dropped for a syllable break but kept when the line break falls in a
+// hyphenated compound: when the broken word already holds a hyphen ("state-of-the-")
+// or is one of compoundPrefixes ("well-" followed by "known"). A
+// word broken at the end of a page is rejoined across the blank lines, separators and
+// page anchors in between; the anchors are moved before the rejoined paragraph so that
+// they still come before content of their page. Headings, table rows and code are left
+// alone.
+func JoinHyphenatedLineBreaks(md string) string {
+	lines, code := splitLines(md)
+	out := make([]string, 0, len(lines))
+	paragraphStart := -1 // Index in out of the first line of the current paragraph.
+	lastProse := -1      // Index in out of its last line, while it may still continue.
+	for i, line := range lines {
+		switch {
+		case !code[i] && isProse(line):
+			if lastProse >= 0 && endsHyphenated(out[lastProse]) && startsLower(line) {
+				var anchors []string
+				for _, gapLine := range out[lastProse+1:] {
+					if pageAnchorRegex.MatchString(gapLine) {
+						anchors = append(anchors, gapLine)
+					}
+				}
+				out = append(out[:lastProse], joinBrokenWord(out[lastProse], line))
+				if len(anchors) > 0 {
+					moved := make([]string, 0, len(out)+2*len(anchors))
+					moved = append(moved, out[:paragraphStart]...)
+					for _, anchor := range anchors {
+						moved = append(moved, anchor, "")
+					}
+					out = append(moved, out[paragraphStart:]...)
+					paragraphStart += 2 * len(anchors)
+				}
+				lastProse = len(out) - 1
+				continue
+			}
+			if lastProse < 0 || lastProse != len(out)-1 {
+				paragraphStart = len(out)
+			}
+			out = append(out, line)
+			lastProse = len(out) - 1
+		case !code[i] && (isBlank(line) || isPageSeparator(line) || pageAnchorRegex.MatchString(line)):
+			// A gap ends the paragraph unless it splits a word broken across pages.
+			out = append(out, line)
+			if lastProse >= 0 && !endsHyphenated(out[lastProse]) {
+				lastProse = -1
+			}
+		default:
+			out = append(out, line)
+			lastProse = -1
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// isProse reports whether line can be part of a paragraph: it is not blank, a heading,
+// a table row, a page separator or an HTML comment.
+func isProse(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed != "" && !atxHeadingRegex.MatchString(line) && !strings.HasPrefix(trimmed, "|") &&
+		!isPageSeparator(line) && !strings.HasPrefix(trimmed, "<!--")
+}
+
+func endsHyphenated(line string) bool {
+	trimmed := strings.TrimRight(line, " \t")
+	return hyphenatedEndRegex.MatchString(trimmed) && !strings.HasSuffix(trimmed, "--")
+}
+
+// joinBrokenWord joins line to prev, which ends in a hyphen, dropping the hyphen unless
+// it belongs to a hyphenated compound.
+func joinBrokenWord(prev, line string) string {
+	prev = strings.TrimRight(prev, " \t")
+	if m := brokenWordRegex.FindStringSubmatch(prev); m == nil || (!strings.Contains(m[1], "-") && !compoundPrefixes[strings.ToLower(m[1])]) {
+		prev = strings.TrimSuffix(prev, "-")
+	}
+	return prev + strings.TrimLeft(line, " \t")
+}
+
+func startsLower(line string) bool {
+	r, _ := utf8.DecodeRuneInString(strings.TrimLeft(line, " \t"))
+	return unicode.IsLower(r)
+}
+
+// MergeAdjacentTables merges a table with the one after it when only blank lines, page
+// separators and page anchors lie between them, both have the same number of columns
+// and their header rows are at least headerSimilarityThreshold alike: the fraction of
+// columns whose header cells match, ignoring case and spacing. The second table's header
+// is dropped, since a table broken across pages repeats it, and the anchors between the
+// tables are moved before the merged table.
+func MergeAdjacentTables(md string, headerSimilarityThreshold float64) string {
+	lines, code := splitLines(md)
+	out := make([]string, 0, len(lines))
+	for i := 0; i < len(lines); {
+		if code[i] || !isTableStart(lines, code, i) {
+			out = append(out, lines[i])
+			i++
+			continue
+		}
+		header := tableCells(lines[i])
+		tableStart := len(out)
+		end := tableEnd(lines, code, i)
+		out = append(out, lines[i:end]...)
+		i = end
+		for {
+			next := i
+			var anchors []string
+			for next < len(lines) && !code[next] && (isBlank(lines[next]) || isPageSeparator(lines[next]) || pageAnchorRegex.MatchString(lines[next])) {
+				if pageAnchorRegex.MatchString(lines[next]) {
+					anchors = append(anchors, lines[next])
+				}
+				next++
+			}
+			if next >= len(lines) || code[next] || !isTableStart(lines, code, next) {
+				break
+			}
+			nextHeader := tableCells(lines[next])
+			if len(nextHeader) != len(header) || headerSimilarity(header, nextHeader) < headerSimilarityThreshold {
+				break
+			}
+			if len(anchors) > 0 {
+				moved := make([]string, 0, len(out)+2*len(anchors))
+				moved = append(moved, out[:tableStart]...)
+				for _, anchor := range anchors {
+					moved = append(moved, anchor, "")
+				}
+				out = append(moved, out[tableStart:]...)
+				tableStart += 2 * len(anchors)
+			}
+			end := tableEnd(lines, code, next)
+			out = append(out, lines[next+2:end]...)
+			i = end
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// isTableStart reports whether a table starts at lines[i]: a row followed by a
+// delimiter row.
+func isTableStart(lines []string, code []bool, i int) bool {
+	return i+1 < len(lines) && !code[i+1] && strings.Contains(lines[i], "|") &&
+		tableDelimiterRegex.MatchString(lines[i+1]) && strings.Contains(lines[i+1], "-")
+}
+
+// tableEnd returns the index of the first line after the table starting at lines[start].
+func tableEnd(lines []string, code []bool, start int) int {
+	end := start + 2
+	for end < len(lines) && !code[end] && !isBlank(lines[end]) && strings.Contains(lines[end], "|") {
+		end++
+	}
+	return end
+}
+
+// tableCells returns the normalized cells of a table row.
+func tableCells(row string) []string {
+	row = strings.TrimSpace(row)
+	row = strings.TrimPrefix(row, "|")
+	if !strings.HasSuffix(row, `\|`) {
+		row = strings.TrimSuffix(row, "|")
+	}
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(row); i++ {
+		switch {
+		case row[i] == '\\' && i+1 < len(row) && row[i+1] == '|':
+			cell.WriteString(`\|`)
+			i++
+		case row[i] == '|':
+			cells = append(cells, cell.String())
+			cell.Reset()
+		default:
+			cell.WriteByte(row[i])
+		}
+	}
+	cells = append(cells, cell.String())
+	for i, c := range cells {
+		cells[i] = strings.ToLower(strings.Join(strings.Fields(c), " "))
+	}
+	return cells
+}
+
+// headerSimilarity returns the fraction of columns whose cells match in two header rows
+// of the same width.
+func headerSimilarity(a, b []string) float64 {
+	if len(a) == 0 {
+		return 1
+	}
+	matches := 0
+	for i := range a {
+		if a[i] == b[i] {
+			matches++
+		}
+	}
+	return float64(matches) / float64(len(a))
+}
+
+// NormalizeHeadings rewrites ATX headings with a single space after the #s and no
+// closing #s, and closes gaps in heading levels: a heading more than one level deeper
+// than the heading it falls under is raised to the next level down, keeping its
+// position relative to its siblings. The first heading of the document keeps its level.
+func NormalizeHeadings(md string) string {
+	lines, code := splitLines(md)
+	type level struct{ original, normalized int }
+	var open []level // The headings enclosing the current line, outermost first.
+	for i, line := range lines {
+		if code[i] {
+			continue
+		}
+		m := atxHeadingRegex.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		original := len(m[1])
+		for len(open) > 0 && open[len(open)-1].original >= original {
+			open = open[:len(open)-1]
+		}
+		normalized := original
+		if len(open) > 0 {
+			normalized = min(original, open[len(open)-1].normalized+1)
+		}
+		open = append(open, level{original, normalized})
+		text := strings.Join(strings.Fields(m[2]), " ")
+		lines[i] = strings.TrimRight(strings.Repeat("#", normalized)+" "+text, " ")
+	}
+	return strings.Join(lines, "\n")
+}
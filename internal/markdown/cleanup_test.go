@@ -0,0 +1,175 @@
+package markdown
+
+import "testing"
+
+func TestJoinHyphenatedLineBreaks(t *testing.T) {
+	tests := []struct {
+		name string
+		md   string
+		want string
+	}{
+		{
+			name: "syllable break",
+			md:   "The engi-\nneering team.",
+			want: "The engineering team.",
+		},
+		{
+			name: "compound word keeps its hyphen",
+			md:   "A well-\nknown fact.",
+			want: "A well-known fact.",
+		},
+		{
+			name: "word already hyphenated keeps its hyphen",
+			md:   "A state-of-the-\nart pump.",
+			want: "A state-of-the-art pump.",
+		},
+		{
+			name: "capitalised continuation is not joined",
+			md:   "Made by Hewlett-\nPackard.",
+			want: "Made by Hewlett-\nPackard.",
+		},
+		{
+			name: "anchors move before the paragraph",
+			md:   "Intro.\n\nThe pres-\n\n<!-- page: 2 -->\n\nsure rises.",
+			want: "Intro.\n\n<!-- page: 2 -->\n\nThe pressure rises.",
+		},
+		{
+			name: "code is left alone",
+			md:   "```\nengi-\nneering\n```",
+			want: "```\nengi-\nneering\n```",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := JoinHyphenatedLineBreaks(tt.md); got != tt.want {
+				t.Errorf("JoinHyphenatedLineBreaks() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeAdjacentTables(t *testing.T) {
+	tests := []struct {
+		name      string
+		md        string
+		threshold float64
+		want      string
+	}{
+		{
+			name:      "header repeated across a separator",
+			md:        "| Pump | Flow |\n|---|---|\n| P-1 | 10 |\n\n---\n\n| Pump | Flow |\n|---|---|\n| P-2 | 20 |",
+			threshold: 1,
+			want:      "| Pump | Flow |\n|---|---|\n| P-1 | 10 |\n| P-2 | 20 |",
+		},
+		{
+			name:      "header repeated across a page anchor",
+			md:        "Intro.\n\n| Pump | Flow |\n|---|---|\n| P-1 | 10 |\n\n<!-- page: 2 -->\n\n| pump |  FLOW |\n| :-- | --: |\n| P-2 | 20 |\n\nAfter.",
+			threshold: 1,
+			want:      "Intro.\n\n<!-- page: 2 -->\n\n| Pump | Flow |\n|---|---|\n| P-1 | 10 |\n| P-2 | 20 |\n\nAfter.",
+		},
+		{
+			name:      "different column count",
+			md:        "| Pump | Flow |\n|---|---|\n| P-1 | 10 |\n\n---\n\n| Pump | Flow | Head |\n|---|---|---|\n| P-2 | 20 | 5 |",
+			threshold: 0.5,
+			want:      "| Pump | Flow |\n|---|---|\n| P-1 | 10 |\n\n---\n\n| Pump | Flow | Head |\n|---|---|---|\n| P-2 | 20 | 5 |",
+		},
+		{
+			name:      "headers alike above the threshold",
+			md:        "| Pump | Flow | Head |\n|---|---|---|\n| P-1 | 10 | 5 |\n\n| Pump | Flow | Notes |\n|---|---|---|\n| P-2 | 20 | 6 |",
+			threshold: 0.6,
+			want:      "| Pump | Flow | Head |\n|---|---|---|\n| P-1 | 10 | 5 |\n| P-2 | 20 | 6 |",
+		},
+		{
+			name:      "headers alike below the threshold",
+			md:        "| Pump | Flow | Head |\n|---|---|---|\n| P-1 | 10 | 5 |\n\n| Valve | Size | Notes |\n|---|---|---|\n| V-1 | 20 | 6 |",
+			threshold: 0.6,
+			want:      "| Pump | Flow | Head |\n|---|---|---|\n| P-1 | 10 | 5 |\n\n| Valve | Size | Notes |\n|---|---|---|\n| V-1 | 20 | 6 |",
+		},
+		{
+			name:      "continuation without a repeated header is not a table",
+			md:        "| Pump | Flow |\n|---|---|\n| P-1 | 10 |\n\n---\n\n| P-2 | 20 |",
+			threshold: 1,
+			want:      "| Pump | Flow |\n|---|---|\n| P-1 | 10 |\n\n---\n\n| P-2 | 20 |",
+		},
+		{
+			name:      "three pages of one table",
+			md:        "| A | B |\n|---|---|\n| 1 | 2 |\n\n<!-- page: 2 -->\n\n| A | B |\n|---|---|\n| 3 | 4 |\n\n<!-- page: 3 -->\n\n| A | B |\n|---|---|\n| 5 | 6 |",
+			threshold: 1,
+			want:      "<!-- page: 2 -->\n\n<!-- page: 3 -->\n\n| A | B |\n|---|---|\n| 1 | 2 |\n| 3 | 4 |\n| 5 | 6 |",
+		},
+		{
+			name:      "text between tables",
+			md:        "| A | B |\n|---|---|\n| 1 | 2 |\n\nNote.\n\n| A | B |\n|---|---|\n| 3 | 4 |",
+			threshold: 1,
+			want:      "| A | B |\n|---|---|\n| 1 | 2 |\n\nNote.\n\n| A | B |\n|---|---|\n| 3 | 4 |",
+		},
+		{
+			name:      "tables in code are left alone",
+			md:        "```\n| A | B |\n|---|---|\n| 1 | 2 |\n\n| A | B |\n|---|---|\n| 3 | 4 |\n```",
+			threshold: 1,
+			want:      "```\n| A | B |\n|---|---|\n| 1 | 2 |\n\n| A | B |\n|---|---|\n| 3 | 4 |\n```",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeAdjacentTables(tt.md, tt.threshold); got != tt.want {
+				t.Errorf("MergeAdjacentTables() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemovePageSeparators(t *testing.T) {
+	tests := []struct {
+		name string
+		md   string
+		want string
+	}{
+		{name: "between pages", md: "Page one.\n\n---\n\nPage two.", want: "Page one.\n\nPage two."},
+		{name: "setext underline is kept", md: "Scope\n---\n\nText.", want: "Scope\n---\n\nText."},
+		{name: "in code", md: "```\n\n---\n\n```", want: "```\n\n---\n\n```"},
+		{name: "at the start", md: "---\n\nText.", want: "\nText."},
+		{name: "several", md: "One.\n\n---\n\nTwo.\n\n---\n\nThree.", want: "One.\n\nTwo.\n\nThree."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RemovePageSeparators(tt.md); got != tt.want {
+				t.Errorf("RemovePageSeparators() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeHeadings(t *testing.T) {
+	tests := []struct {
+		name string
+		md   string
+		want string
+	}{
+		{name: "spacing and closing hashes", md: "##   Scope   ##\nText.", want: "## Scope\nText."},
+		{name: "skipped level is closed", md: "# Manual\n\n### Scope\n\n#### Details", want: "# Manual\n\n## Scope\n\n### Details"},
+		{name: "siblings keep their level", md: "# Manual\n### A\n### B\n## C", want: "# Manual\n## A\n## B\n## C"},
+		{name: "first heading keeps its level", md: "### Scope\n##### Details", want: "### Scope\n#### Details"},
+		{name: "empty heading", md: "#\nText.", want: "#\nText."},
+		{name: "code is left alone", md: "# A\n```\n### not a heading\n```", want: "# A\n```\n### not a heading\n```"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeHeadings(tt.md); got != tt.want {
+				t.Errorf("NormalizeHeadings() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClean(t *testing.T) {
+	md := "# Pump Manual\n\n### Ratings\n\nThe follow-\n\n---\n\ning table lists the pumps.\n\n" +
+		"| Pump | Flow |\n|---|---|\n| P-1 | 10 |\n\n---\n\n| Pump | Flow |\n|---|---|\n| P-2 | 20 |\n\n" +
+		"```\n\n---\n\n```"
+	want := "# Pump Manual\n\n## Ratings\n\nThe following table lists the pumps.\n\n" +
+		"| Pump | Flow |\n|---|---|\n| P-1 | 10 |\n| P-2 | 20 |\n\n" +
+		"```\n\n---\n\n```"
+	if got := Clean(md, 1); got != want {
+		t.Errorf("Clean() =\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,54 @@
+package markdown
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// frontMatterDelimiter opens and closes a front matter block.
+const frontMatterDelimiter = "---"
+
+// FrontMatter describes a section file to tools that read it on its own. StartPage and
+// EndPage are zero when the document has no page anchors.
+type FrontMatter struct {
+	DocumentID       string    `yaml:"documentId"`
+	OriginalFilename string    `yaml:"originalFilename,omitempty"`
+	Title            string    `yaml:"title"`
+	Order            int       `yaml:"order"`
+	StartPage        int       `yaml:"startPage,omitempty"`
+	EndPage          int       `yaml:"endPage,omitempty"`
+	GeneratedAt      time.Time `yaml:"generatedAt"`
+}
+
+// RenderFrontMatter returns content preceded by fm as a YAML front matter block. Values
+// are quoted and escaped as YAML requires, so titles may hold quotes, colons or any other
+// text.
+func RenderFrontMatter(fm FrontMatter, content string) (string, error) {
+	data, err := yaml.Marshal(fm)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode front matter: %w", err)
+	}
+	return frontMatterDelimiter + "\n" + string(data) + frontMatterDelimiter + "\n\n" + content, nil
+}
+
+// ParseFrontMatter splits a file written by RenderFrontMatter into its front matter and
+// content. A file that does not start with a front matter block is returned whole, with
+// a nil FrontMatter.
+func ParseFrontMatter(file string) (*FrontMatter, string, error) {
+	rest, ok := strings.CutPrefix(file, frontMatterDelimiter+"\n")
+	if !ok {
+		return nil, file, nil
+	}
+	block, content, ok := strings.Cut(rest, "\n"+frontMatterDelimiter+"\n")
+	if !ok {
+		return nil, file, fmt.Errorf("front matter is not closed")
+	}
+	var fm FrontMatter
+	if err := yaml.UnmarshalStrict([]byte(block), &fm); err != nil {
+		return nil, file, fmt.Errorf("failed to decode front matter: %w", err)
+	}
+	return &fm, strings.TrimPrefix(content, "\n"), nil
+}
@@ -0,0 +1,94 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFrontMatterRoundTrip(t *testing.T) {
+	generatedAt := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	titles := []string{
+		"1 Scope",
+		`Pumps: "centrifugal" and 'rotary'`,
+		"key: value # not a comment",
+		"- looks like a list",
+		"---",
+		"true",
+		"0123",
+		"Trailing space ",
+		"Tab\tand \\ backslash",
+		"Größe & Maße",
+		"",
+	}
+	for _, title := range titles {
+		t.Run(title, func(t *testing.T) {
+			want := FrontMatter{
+				DocumentID:       "doc-1",
+				OriginalFilename: "Pump: manual.pdf",
+				Title:            title,
+				Order:            3,
+				StartPage:        4,
+				EndPage:          6,
+				GeneratedAt:      generatedAt,
+			}
+			file, err := RenderFrontMatter(want, "# Body\n\nText.")
+			if err != nil {
+				t.Fatalf("RenderFrontMatter() error = %v", err)
+			}
+			got, content, err := ParseFrontMatter(file)
+			if err != nil {
+				t.Fatalf("ParseFrontMatter() error = %v\nfile:\n%s", err, file)
+			}
+			if got == nil || *got != want {
+				t.Errorf("ParseFrontMatter() = %+v, want %+v\nfile:\n%s", got, want, file)
+			}
+			if content != "# Body\n\nText." {
+				t.Errorf("ParseFrontMatter() content = %q, want %q", content, "# Body\n\nText.")
+			}
+		})
+	}
+}
+
+func TestRenderFrontMatterOmitsUnknownPages(t *testing.T) {
+	file, err := RenderFrontMatter(FrontMatter{DocumentID: "doc-1", Title: "Scope", Order: 1}, "Text.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(file, "startPage") || strings.Contains(file, "endPage") || strings.Contains(file, "originalFilename") {
+		t.Errorf("RenderFrontMatter() wrote empty optional fields:\n%s", file)
+	}
+	if !strings.HasPrefix(file, "---\n") || !strings.HasSuffix(file, "---\n\nText.") {
+		t.Errorf("RenderFrontMatter() =\n%s\nwant a delimited block before the content", file)
+	}
+}
+
+func TestParseFrontMatter(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string
+		wantNil     bool
+		wantContent string
+		wantErr     bool
+	}{
+		{name: "no front matter", file: "# Scope\n\nText.", wantNil: true, wantContent: "# Scope\n\nText."},
+		{name: "thematic break later in the file", file: "Text.\n---\nMore.", wantNil: true, wantContent: "Text.\n---\nMore."},
+		{name: "not closed", file: "---\ntitle: Scope\n\nText.", wantNil: true, wantContent: "---\ntitle: Scope\n\nText.", wantErr: true},
+		{name: "unknown field", file: "---\ntitle: Scope\nauthor: someone\n---\n\nText.", wantNil: true, wantContent: "---\ntitle: Scope\nauthor: someone\n---\n\nText.", wantErr: true},
+		{name: "valid", file: "---\ndocumentId: doc-1\ntitle: Scope\norder: 2\n---\n\nText.", wantContent: "Text."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm, content, err := ParseFrontMatter(tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFrontMatter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (fm == nil) != tt.wantNil {
+				t.Errorf("ParseFrontMatter() front matter = %+v, want nil %v", fm, tt.wantNil)
+			}
+			if content != tt.wantContent {
+				t.Errorf("ParseFrontMatter() content = %q, want %q", content, tt.wantContent)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+// Package markdown holds pure transformations of the markdown the translator produces.
+package markdown
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// fenceRegex matches a code fence line: up to three spaces of indent, a run of at
+	// least three backticks or tildes, and an optional info string.
+	fenceRegex = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})[ \t]*([^`]*?)[ \t]*$")
+	// h1Regex matches an ATX level-1 heading, capturing its text without any closing #s.
+	h1Regex = regexp.MustCompile(`^ {0,3}#(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+)
+
+// fence is a parsed code fence line.
+type fence struct {
+	marker string // The run of backticks or tildes.
+	info   string // The info string, e.g. "markdown"; empty on closing fences.
+}
+
+// parseFence returns the fence on line, if it is one.
+func parseFence(line string) (fence, bool) {
+	m := fenceRegex.FindStringSubmatch(line)
+	if m == nil {
+		return fence{}, false
+	}
+	return fence{marker: m[1], info: m[2]}, true
+}
+
+// closes reports whether f closes a block opened by open.
+func (f fence) closes(open fence) bool {
+	return f.info == "" && f.marker[0] == open.marker[0] && len(f.marker) >= len(open.marker)
+}
+
+// isMarkdownInfo reports whether a fence's info string labels its block as markdown.
+func isMarkdownInfo(info string) bool {
+	return strings.EqualFold(info, "markdown") || strings.EqualFold(info, "md")
+}
+
+// NormalizePage tidies one translated page before it is joined with the others:
+//
+//   - a markdown or md code fence wrapping the whole page, or left over at its start or
+//     end after the other half was trimmed, is removed; a page that is a single untagged
+//     or otherwise tagged code block is kept as one;
+//   - a level-1 heading whose text matches previousH1, the last level-1 heading of the
+//     pages before, is demoted to level 2, so a title repeated on every page does not
+//     compete with the document's own;
+//   - runs of three or more blank lines are collapsed to one.
+//
+// Code blocks are left untouched. It returns the page without leading or trailing blank
+// lines, and the last level-1 heading seen so far, to pass as previousH1 for the next
+// page.
+func NormalizePage(page, previousH1 string) (normalized, lastH1 string) {
+	lines := strings.Split(strings.ReplaceAll(page, "\r\n", "\n"), "\n")
+	lines = stripWrappingFences(trimBlankLines(lines))
+
+	lastH1 = previousH1
+	out := make([]string, 0, len(lines))
+	var open *fence
+	blankRun := 0
+	for _, line := range lines {
+		if f, ok := parseFence(line); ok {
+			if open == nil {
+				open = &f
+			} else if f.closes(*open) {
+				open = nil
+			}
+		} else if open == nil {
+			if strings.TrimSpace(line) == "" {
+				blankRun++
+				if blankRun >= 3 {
+					continue
+				}
+				out = append(out, line)
+				continue
+			}
+			if m := h1Regex.FindStringSubmatch(line); m != nil {
+				text := strings.Join(strings.Fields(m[1]), " ")
+				if text != "" && strings.EqualFold(text, previousH1) {
+					line = "#" + strings.TrimLeft(line, " ")
+				}
+				if text != "" {
+					lastH1 = text
+				}
+			}
+		}
+		if blankRun >= 3 {
+			// A long run was cut short after its first two lines; keep only one.
+			out = out[:len(out)-1]
+		}
+		blankRun = 0
+		out = append(out, line)
+	}
+	return strings.Join(trimBlankLines(out), "\n"), lastH1
+}
+
+// stripWrappingFences removes a markdown fence around the whole page, along with a lone
+// opening markdown fence or a lone closing fence at either end. lines has no leading or
+// trailing blank lines.
+func stripWrappingFences(lines []string) []string {
+	if len(lines) == 0 {
+		return lines
+	}
+	first, firstIsFence := parseFence(lines[0])
+	last, lastIsFence := parseFence(lines[len(lines)-1])
+	if len(lines) > 1 && firstIsFence && lastIsFence && last.closes(first) &&
+		isMarkdownInfo(first.info) && countFences(lines[1:len(lines)-1]) == 0 {
+		return trimBlankLines(lines[1 : len(lines)-1])
+	}
+	// An opening markdown fence that is closed later in the page starts a block of its own.
+	if firstIsFence && isMarkdownInfo(first.info) && countFences(lines)%2 == 1 {
+		lines = trimBlankLines(lines[1:])
+	}
+	// A closing fence with no opening fence before it is left over from a trimmed wrapper.
+	if n := len(lines); n > 0 && countFences(lines)%2 == 1 {
+		if f, ok := parseFence(lines[n-1]); ok && f.info == "" {
+			lines = trimBlankLines(lines[:n-1])
+		}
+	}
+	return lines
+}
+
+// countFences returns the number of fence lines in lines.
+func countFences(lines []string) int {
+	count := 0
+	for _, line := range lines {
+		if _, ok := parseFence(line); ok {
+			count++
+		}
+	}
+	return count
+}
+
+// trimBlankLines returns lines without leading or trailing blank lines.
+func trimBlankLines(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,87 @@
+package markdown
+
+import "testing"
+
+func TestNormalizePage(t *testing.T) {
+	tests := []struct {
+		name       string
+		page       string
+		previousH1 string
+		want       string
+		wantH1     string
+	}{
+		{
+			name: "markdown fence around the page",
+			page: "```markdown\n# Scope\n\nText.\n```\n",
+			want: "# Scope\n\nText.", wantH1: "Scope",
+		},
+		{
+			name: "md fence around the page",
+			page: "\n```md\nText.\n```",
+			want: "Text.",
+		},
+		{
+			name: "untagged code block is kept",
+			page: "```\ncode only page\n```",
+			want: "```\ncode only page\n```",
+		},
+		{
+			name: "tagged code block is kept",
+			page: "```go\nfunc main() {}\n```\n",
+			want: "```go\nfunc main() {}\n```",
+		},
+		{
+			name: "fence inside a wrapped page",
+			page: "```markdown\nText.\n```\n\n```\ncode\n```",
+			want: "```markdown\nText.\n```\n\n```\ncode\n```",
+		},
+		{
+			name: "lone opening markdown fence",
+			page: "```markdown\n# Scope\nText.",
+			want: "# Scope\nText.", wantH1: "Scope",
+		},
+		{
+			name: "lone closing fence",
+			page: "Text.\n```\n",
+			want: "Text.",
+		},
+		{
+			name:       "repeated title is demoted",
+			page:       "# Pump Manual\n\n## 2 Scope",
+			previousH1: "pump manual",
+			want:       "## Pump Manual\n\n## 2 Scope", wantH1: "Pump Manual",
+		},
+		{
+			name:       "new title is kept",
+			page:       "# Appendix A #",
+			previousH1: "Pump Manual",
+			want:       "# Appendix A #", wantH1: "Appendix A",
+		},
+		{
+			name: "blank lines are collapsed",
+			page: "One.\n\n\n\n\nTwo.\r\n\r\nThree.",
+			want: "One.\n\nTwo.\n\nThree.",
+		},
+		{
+			name:       "code blocks are left as they are",
+			page:       "```\n# not a heading\n\n\n\nkept\n```\nAfter.",
+			previousH1: "not a heading",
+			want:       "```\n# not a heading\n\n\n\nkept\n```\nAfter.", wantH1: "not a heading",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, lastH1 := NormalizePage(tt.page, tt.previousH1)
+			if got != tt.want {
+				t.Errorf("NormalizePage() =\n%q\nwant\n%q", got, tt.want)
+			}
+			wantH1 := tt.wantH1
+			if wantH1 == "" {
+				wantH1 = tt.previousH1
+			}
+			if lastH1 != wantH1 {
+				t.Errorf("NormalizePage() last H1 = %q, want %q", lastH1, wantH1)
+			}
+		})
+	}
+}
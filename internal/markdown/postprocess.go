@@ -0,0 +1,268 @@
+package markdown
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// DefaultPreambles are the openings, in lower case, of lines models put before the
+// markdown they were asked for, despite being told not to.
+var DefaultPreambles = []string{
+	"here is the markdown",
+	"here's the markdown",
+	"here is the translation",
+	"here's the translation",
+	"here is the translated",
+	"here's the translated",
+	"here is the content",
+	"here's the content",
+	"below is the markdown",
+	"below is the translation",
+	"below is the translated",
+	"the following is the markdown",
+	"the following is the translation",
+	"sure, here",
+	"sure! here",
+	"certainly, here",
+	"certainly! here",
+	"okay, here",
+	"ok, here",
+}
+
+// DefaultEpilogues are the openings, in lower case, of lines models put after the
+// markdown they were asked for.
+var DefaultEpilogues = []string{
+	"let me know if",
+	"please let me know",
+	"i hope this helps",
+	"hope this helps",
+	"if you need any",
+	"if you have any",
+	"feel free to ask",
+}
+
+// maxChatterLength is the longest line taken for a preamble or epilogue. Longer lines are
+// content that happens to begin like one.
+const maxChatterLength = 300
+
+var (
+	// blockquoteRegex matches the marker of a blockquote line, with its optional space.
+	blockquoteRegex = regexp.MustCompile(`^ {0,3}> ?`)
+	// bulletRegex matches a bullet list item at the left margin, capturing its marker.
+	bulletRegex = regexp.MustCompile(`^([-*+])(?:[ \t]|$)`)
+	// thematicBreakRegex matches a thematic break, which models put between their
+	// markdown and an epilogue.
+	thematicBreakRegex = regexp.MustCompile(`^ {0,3}(?:(?:-[ \t]*){3,}|(?:\*[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	// yamlKeyRegex matches a line of a front matter block opening with a key.
+	yamlKeyRegex = regexp.MustCompile(`^[A-Za-z_][\w-]*:(?:\s|$)`)
+)
+
+// ErrMalformed is wrapped by the errors of ValidateOutput.
+var ErrMalformed = errors.New("malformed markdown")
+
+// Chatter lists the phrases, in lower case, that open lines a model writes around its
+// markdown rather than in it.
+type Chatter struct {
+	Preambles []string
+	Epilogues []string
+}
+
+// DefaultChatter returns the default preambles and epilogues.
+func DefaultChatter() Chatter {
+	return Chatter{Preambles: DefaultPreambles, Epilogues: DefaultEpilogues}
+}
+
+// OutputFixes records what PostprocessOutput changed.
+type OutputFixes struct {
+	Preambles []string // Lines removed from the start.
+	Epilogues []string // Lines removed from the end.
+	Unwrapped string   // "blockquote" or "list" if the whole output was one, or empty.
+}
+
+// Changed reports whether anything was fixed.
+func (f OutputFixes) Changed() bool {
+	return len(f.Preambles) > 0 || len(f.Epilogues) > 0 || f.Unwrapped != ""
+}
+
+// PostprocessOutput undoes what models add to a page's markdown despite the prompt:
+//
+//   - lines opening with a preamble phrase are removed from the start, and lines opening
+//     with an epilogue phrase from the end, along with a thematic break left between
+//     them and the content, but not a front matter block, and the code fence the
+//     content was then wrapped in;
+//   - a blockquote wrapping the whole output is unwrapped;
+//   - a bullet list wrapping the whole output is unwrapped when it has a single item, or
+//     when an item opens with a heading, which no real list of a page does.
+//
+// It returns md without leading or trailing blank lines.
+func PostprocessOutput(md string, chatter Chatter) (string, OutputFixes) {
+	var fixes OutputFixes
+	lines := trimBlankLines(strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n"))
+
+	for len(lines) > 0 && isChatter(lines[0], chatter.Preambles) {
+		fixes.Preambles = append(fixes.Preambles, strings.TrimSpace(lines[0]))
+		lines = trimBlankLines(lines[1:])
+		if len(lines) > 0 && thematicBreakRegex.MatchString(lines[0]) && !opensFrontMatter(lines) {
+			lines = trimBlankLines(lines[1:])
+		}
+	}
+	for len(lines) > 0 && isChatter(lines[len(lines)-1], chatter.Epilogues) {
+		fixes.Epilogues = append(fixes.Epilogues, strings.TrimSpace(lines[len(lines)-1]))
+		lines = trimBlankLines(lines[:len(lines)-1])
+		if n := len(lines); n > 0 && thematicBreakRegex.MatchString(lines[n-1]) {
+			lines = trimBlankLines(lines[:n-1])
+		}
+	}
+	if len(fixes.Preambles) > 0 || len(fixes.Epilogues) > 0 {
+		// The chatter was outside the fence the markdown came in.
+		lines = stripWrappingFences(lines)
+	}
+
+	if unwrapped, ok := unwrapBlockquote(lines); ok {
+		lines, fixes.Unwrapped = trimBlankLines(unwrapped), "blockquote"
+	} else if unwrapped, ok := unwrapList(lines); ok {
+		lines, fixes.Unwrapped = trimBlankLines(unwrapped), "list"
+	}
+	return strings.Join(lines, "\n"), fixes
+}
+
+// opensFrontMatter reports whether lines start with a YAML front matter block: a ---
+// line, then lines of keys and their indented values, then a closing --- line. Its
+// opening line is not taken for a thematic break after a preamble.
+func opensFrontMatter(lines []string) bool {
+	if len(lines) < 3 || strings.TrimSpace(lines[0]) != frontMatterDelimiter {
+		return false
+	}
+	for i, line := range lines[1:] {
+		switch {
+		case strings.TrimSpace(line) == frontMatterDelimiter:
+			return i > 0
+		case !yamlKeyRegex.MatchString(line) && !strings.HasPrefix(line, " "):
+			return false
+		}
+	}
+	return false
+}
+
+// isChatter reports whether line opens with one of phrases, ignoring case, emphasis
+// markers and the kind of apostrophe.
+func isChatter(line string, phrases []string) bool {
+	text := strings.TrimSpace(line)
+	if text == "" || len(text) > maxChatterLength {
+		return false
+	}
+	text = strings.ToLower(strings.TrimLeft(text, "*_"))
+	text = strings.ReplaceAll(text, "’", "'")
+	for _, phrase := range phrases {
+		if phrase != "" && strings.HasPrefix(text, strings.ToLower(phrase)) {
+			return true
+		}
+	}
+	return false
+}
+
+// unwrapBlockquote removes one level of blockquote from lines if every non-blank line is
+// quoted.
+func unwrapBlockquote(lines []string) ([]string, bool) {
+	if len(lines) == 0 {
+		return nil, false
+	}
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		if isBlank(line) {
+			continue
+		}
+		marker := blockquoteRegex.FindString(line)
+		if marker == "" {
+			return nil, false
+		}
+		out[i] = line[len(marker):]
+	}
+	return out, true
+}
+
+// unwrapList removes the bullets of a list wrapping the whole of lines, and the indent
+// of its continuation lines, if the list has a single item or an item opening with a
+// heading. The items are left as paragraphs of their own.
+func unwrapList(lines []string) ([]string, bool) {
+	if len(lines) == 0 {
+		return nil, false
+	}
+	m := bulletRegex.FindStringSubmatch(lines[0])
+	if m == nil {
+		return nil, false
+	}
+	marker := m[1]
+	items, headed := 0, false
+	for _, line := range lines {
+		switch {
+		case isBlank(line):
+		case strings.HasPrefix(line, marker+" ") || strings.HasPrefix(line, marker+"\t") || line == marker:
+			items++
+			if atxHeadingRegex.MatchString(strings.TrimSpace(line[len(marker):])) {
+				headed = true
+			}
+		case strings.HasPrefix(line, "  ") || strings.HasPrefix(line, "\t"):
+		default:
+			return nil, false
+		}
+	}
+	if items != 1 && !headed {
+		return nil, false
+	}
+
+	out := make([]string, 0, len(lines)+items)
+	for _, line := range lines {
+		switch {
+		case isBlank(line):
+			out = append(out, "")
+		case strings.HasPrefix(line, "\t"):
+			out = append(out, line[1:])
+		case strings.HasPrefix(line, "  "):
+			out = append(out, line[2:])
+		default:
+			if len(out) > 0 && out[len(out)-1] != "" {
+				out = append(out, "")
+			}
+			out = append(out, strings.TrimLeft(line[len(marker):], " \t"))
+		}
+	}
+	return out, true
+}
+
+// ValidateOutput checks that md, a page's markdown after PostprocessOutput, is what a
+// page translates to: every code fence is closed, and some line holds a letter or digit.
+// Tables are not checked, as a row with too many or too few cells still renders.
+// Empty markdown is valid, as a blank page translates to nothing. The error wraps
+// ErrMalformed.
+func ValidateOutput(md string) error {
+	if strings.TrimSpace(md) == "" {
+		return nil
+	}
+	lines := strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n")
+	var open *fence
+	openedAt, hasText := 0, false
+	for i, line := range lines {
+		if f, ok := parseFence(line); ok {
+			if open == nil {
+				open, openedAt = &f, i+1
+			} else if f.closes(*open) {
+				open = nil
+			}
+			continue
+		}
+		if !hasText && strings.IndexFunc(line, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+			hasText = true
+		}
+	}
+	if open != nil {
+		return fmt.Errorf("%w: code fence opened on line %d is never closed", ErrMalformed, openedAt)
+	}
+	if !hasText {
+		return fmt.Errorf("%w: no line holds any text", ErrMalformed)
+	}
+	return nil
+}
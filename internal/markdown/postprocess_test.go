@@ -0,0 +1,198 @@
+package markdown
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPostprocessOutput(t *testing.T) {
+	longLine := "Here is the content of the maintenance schedule " + strings.Repeat("for every pump in the plant, ", 12)
+	tests := []struct {
+		name          string
+		md            string
+		chatter       Chatter
+		want          string
+		wantPreambles []string
+		wantEpilogues []string
+		wantUnwrapped string
+	}{
+		{
+			name: "clean page",
+			md:   "# Pump\n\nText.\n",
+			want: "# Pump\n\nText.",
+		},
+		{
+			name:          "preamble",
+			md:            "Here is the markdown translation of the document:\n\n# Pump\n\nText.",
+			want:          "# Pump\n\nText.",
+			wantPreambles: []string{"Here is the markdown translation of the document:"},
+		},
+		{
+			name:          "emphasized preamble with a curly apostrophe",
+			md:            "**Here’s the translated page:**\n\n# Pump",
+			want:          "# Pump",
+			wantPreambles: []string{"**Here’s the translated page:**"},
+		},
+		{
+			name:          "preamble, fence and epilogue",
+			md:            "Sure, here is the markdown:\n\n```markdown\n# Pump\n\nText.\n```\n\nLet me know if you need anything else.",
+			want:          "# Pump\n\nText.",
+			wantPreambles: []string{"Sure, here is the markdown:"},
+			wantEpilogues: []string{"Let me know if you need anything else."},
+		},
+		{
+			name: "fence without chatter is left to NormalizePage",
+			md:   "```markdown\n# Pump\n```",
+			want: "```markdown\n# Pump\n```",
+		},
+		{
+			name:          "preamble before fenced blocks of the page",
+			md:            "Here is the markdown:\n\n```markdown\n# Pump\n```\n\n```\ncode\n```",
+			want:          "```markdown\n# Pump\n```\n\n```\ncode\n```",
+			wantPreambles: []string{"Here is the markdown:"},
+		},
+		{
+			name:          "preamble and thematic break",
+			md:            "Here is the translation:\n\n---\n\n# Pump\n\n---\n\nText.",
+			want:          "# Pump\n\n---\n\nText.",
+			wantPreambles: []string{"Here is the translation:"},
+		},
+		{
+			name:          "thematic break and epilogue",
+			md:            "# Pump\n\nText.\n\n***\n\nI hope this helps!",
+			want:          "# Pump\n\nText.",
+			wantEpilogues: []string{"I hope this helps!"},
+		},
+		{
+			name: "front matter",
+			md:   "---\ntitle: Pump\nrevision: 3\n---\n\n# Pump",
+			want: "---\ntitle: Pump\nrevision: 3\n---\n\n# Pump",
+		},
+		{
+			name:          "preamble and front matter",
+			md:            "Here is the markdown:\n\n---\ntitle: Pump\nparts:\n  - impeller\n---\n\n# Pump",
+			want:          "---\ntitle: Pump\nparts:\n  - impeller\n---\n\n# Pump",
+			wantPreambles: []string{"Here is the markdown:"},
+		},
+		{
+			name:          "preamble and a thematic break before a key",
+			md:            "Here is the markdown:\n\n---\nNote: pumps only.",
+			want:          "Note: pumps only.",
+			wantPreambles: []string{"Here is the markdown:"},
+		},
+		{
+			name:          "CRLF line endings",
+			md:            "Here is the markdown:\r\n\r\n# Pump\r\n",
+			want:          "# Pump",
+			wantPreambles: []string{"Here is the markdown:"},
+		},
+		{
+			name:          "nothing but chatter",
+			md:            "Here is the translation:",
+			want:          "",
+			wantPreambles: []string{"Here is the translation:"},
+		},
+		{
+			name: "long line opening like a preamble",
+			md:   longLine + "\n\nText.",
+			want: longLine + "\n\nText.",
+		},
+		{
+			name:          "custom preamble",
+			md:            "Voici la traduction :\n\n# Pompe",
+			chatter:       Chatter{Preambles: []string{"Voici"}},
+			want:          "# Pompe",
+			wantPreambles: []string{"Voici la traduction :"},
+		},
+		{
+			name:          "blockquote around the page",
+			md:            "> # Pump\n>\n> Text.",
+			want:          "# Pump\n\nText.",
+			wantUnwrapped: "blockquote",
+		},
+		{
+			name: "blockquote in the page",
+			md:   "# Pump\n\n> Warning.",
+			want: "# Pump\n\n> Warning.",
+		},
+		{
+			name:          "single item list around the page",
+			md:            "- # Pump\n\n  Text.",
+			want:          "# Pump\n\nText.",
+			wantUnwrapped: "list",
+		},
+		{
+			name:          "list of headed items",
+			md:            "- # Pump\n  Text.\n- # Valve\n  More.",
+			want:          "# Pump\nText.\n\n# Valve\nMore.",
+			wantUnwrapped: "list",
+		},
+		{
+			name: "list of the page",
+			md:   "- Valve\n- Pump",
+			want: "- Valve\n- Pump",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chatter := tt.chatter
+			if chatter.Preambles == nil && chatter.Epilogues == nil {
+				chatter = DefaultChatter()
+			}
+			got, fixes := PostprocessOutput(tt.md, chatter)
+			if got != tt.want {
+				t.Errorf("PostprocessOutput() = %q, want %q", got, tt.want)
+			}
+			if !slices.Equal(fixes.Preambles, tt.wantPreambles) || !slices.Equal(fixes.Epilogues, tt.wantEpilogues) || fixes.Unwrapped != tt.wantUnwrapped {
+				t.Errorf("PostprocessOutput() fixes = %+v, want preambles %q, epilogues %q, unwrapped %q", fixes, tt.wantPreambles, tt.wantEpilogues, tt.wantUnwrapped)
+			}
+			wantChanged := len(tt.wantPreambles) > 0 || len(tt.wantEpilogues) > 0 || tt.wantUnwrapped != ""
+			if fixes.Changed() != wantChanged {
+				t.Errorf("OutputFixes.Changed() = %v, want %v", fixes.Changed(), wantChanged)
+			}
+		})
+	}
+}
+
+func TestValidateOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		md      string
+		wantErr string
+	}{
+		{name: "empty", md: ""},
+		{name: "blank", md: "  \n\n"},
+		{name: "text", md: "# Pump\n\nText."},
+		{name: "closed fence", md: "# Pump\n\n```go\nfunc main() {}\n```"},
+		{name: "closed tilde fence", md: "~~~\ncode\n~~~"},
+		{name: "front matter", md: "---\ntitle: Pump\n---\n\n# Pump"},
+		{name: "unbalanced table", md: "| A | B |\n|---|---|\n| 1 | 2 | 3 |\n| 4 |"},
+		{name: "table without a separator", md: "| A | B |\n| 1 | 2 |"},
+		{name: "digits only", md: "| 42 |"},
+		{name: "unclosed fence", md: "# Pump\n\n```go\nfunc main() {}", wantErr: "code fence opened on line 3 is never closed"},
+		{name: "fence closed by a shorter fence", md: "````\ncode\n```", wantErr: "code fence opened on line 1 is never closed"},
+		{name: "tilde fence closed by backticks", md: "~~~\ncode\n```", wantErr: "code fence opened on line 1 is never closed"},
+		{name: "fence left open after a closed one", md: "```\na\n```\n\nText.\n\n```", wantErr: "code fence opened on line 7 is never closed"},
+		{name: "no text", md: "---\n\n***\n|---|---|", wantErr: "no line holds any text"},
+		{name: "text only inside a fence", md: "```\n```", wantErr: "no line holds any text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateOutput(tt.md)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateOutput() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateOutput() error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if !errors.Is(err, ErrMalformed) {
+				t.Errorf("ValidateOutput() error = %v, want it to wrap ErrMalformed", err)
+			}
+		})
+	}
+}
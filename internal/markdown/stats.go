@@ -0,0 +1,37 @@
+package markdown
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Stats counts what a cleanup pass can lose from a document.
+type Stats struct {
+	Characters int
+	Lines      int
+	Tables     int
+	Headings   int
+}
+
+// Measure returns md's stats. A table is counted by the delimiter row under its header,
+// and lines in fenced code blocks count as neither tables nor headings.
+func Measure(md string) Stats {
+	stats := Stats{Characters: utf8.RuneCountInString(md)}
+	if md == "" {
+		return stats
+	}
+	lines, code := splitLines(strings.TrimSuffix(md, "\n"))
+	stats.Lines = len(lines)
+	for i, line := range lines {
+		if code[i] {
+			continue
+		}
+		switch {
+		case atxHeadingRegex.MatchString(line):
+			stats.Headings++
+		case i > 0 && !code[i-1] && strings.Contains(lines[i-1], "|") && tableDelimiterRegex.MatchString(line):
+			stats.Tables++
+		}
+	}
+	return stats
+}
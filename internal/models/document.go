@@ -8,11 +8,229 @@ import (
 // Document represents the main record for a PDF processing job in Firestore.
 // It tracks the overall status and metadata of the file.
 type Document struct {
-	FileHash          string    `firestore:"fileHash,omitempty"`
-	OriginalFilename  string    `firestore:"originalFilename,omitempty"`
-	Status            string    `firestore:"status,omitempty"`
-	ErrorDetails      string    `firestore:"errorDetails,omitempty"`
-	PageCount         int       `firestore:"pageCount,omitempty"`
-	WorkflowExecutionID string  `firestore:"workflowExecutionId,omitempty"` // For traceability
-	CreatedAt         time.Time `firestore:"createdAt,omitempty"`
+	FileHash            string               `firestore:"fileHash,omitempty"`
+	OriginalFilename    string               `firestore:"originalFilename,omitempty"`
+	DocumentType        string               `firestore:"documentType,omitempty"`   // From the upload's "documentType" metadata
+	SourceLanguage      string               `firestore:"sourceLanguage,omitempty"` // From the upload's "source-language" metadata
+	TargetLanguage      string               `firestore:"targetLanguage,omitempty"` // From the upload's "target-language" metadata
+	Status              string               `firestore:"status,omitempty"`
+	ErrorDetails        string               `firestore:"errorDetails,omitempty"`
+	PageCount           int                  `firestore:"pageCount,omitempty"`
+	PagesUploaded       int                  `firestore:"pagesUploaded,omitempty"`       // Split pages uploaded so far, counted in batches
+	ChunkSize           int                  `firestore:"chunkSize,omitempty"`           // Pages per split file; 0 means 1
+	PeakFileSizeBytes   int64                `firestore:"peakFileSizeBytes,omitempty"`   // Largest of the upload and its optimized copy
+	WorkflowExecutionID string               `firestore:"workflowExecutionId,omitempty"` // For traceability
+	CreatedAt           time.Time            `firestore:"createdAt,omitempty"`
+	RevisionDiff        *RevisionDiffSummary `firestore:"revisionDiff,omitempty"`
+	// ProcessingRegion and RedactionMode come from the upload's "processing-region" and
+	// "redaction-mode" metadata, and are passed to the translator with each page.
+	ProcessingRegion string `firestore:"processingRegion,omitempty"`
+	RedactionMode    string `firestore:"redactionMode,omitempty"`
+	// SourceBucket, SourceObject and SourceGeneration identify the upload the document
+	// was split from, and SourceSizeBytes and SourceContentType are its attributes when
+	// it was processed. UploadedBy comes from the upload's "uploaded-by" metadata.
+	SourceBucket      string `firestore:"sourceBucket,omitempty"`
+	SourceObject      string `firestore:"sourceObject,omitempty"`
+	SourceGeneration  int64  `firestore:"sourceGeneration,omitempty"`
+	SourceSizeBytes   int64  `firestore:"sourceSizeBytes,omitempty"`
+	SourceContentType string `firestore:"sourceContentType,omitempty"`
+	UploadedBy        string `firestore:"uploadedBy,omitempty"`
+	// OriginalFilename is the display-safe base name of the upload's file name, which
+	// OriginalObjectPath holds as it was given, and FilenameSlug its slug for output
+	// names. See NormalizeFilename.
+	OriginalObjectPath string `firestore:"originalObjectPath,omitempty"`
+	FilenameSlug       string `firestore:"filenameSlug,omitempty"`
+	// SplitMode is SplitModeBookmark for a document split into a file per chapter of its
+	// outline, which Chapters lists in page order, and empty for one split into pages.
+	SplitMode string    `firestore:"splitMode,omitempty"`
+	Chapters  []Chapter `firestore:"chapters,omitempty"`
+	// TenantID is the tenant the upload belongs to, from its source bucket or its
+	// "tenant-id" metadata. Every object derived from the document is stored under
+	// "{tenantId}/{docId}/" rather than "{docId}/", and stage requests must name it.
+	TenantID string `firestore:"tenantId,omitempty"`
+	// TokenUsage accumulates Gemini token counts per pipeline stage, keyed by stage name.
+	TokenUsage map[string]TokenUsage `firestore:"tokenUsage,omitempty"`
+	// NotificationStatus records the outcome of the completion notification, if one is sent.
+	NotificationStatus string `firestore:"notificationStatus,omitempty"`
+	NotificationError  string `firestore:"notificationError,omitempty"`
+	// DeletedObjectCounts counts the intermediate objects deleted after completion, keyed
+	// by intermediate bucket.
+	DeletedObjectCounts map[string]int `firestore:"deletedObjectCounts,omitempty"`
+	// Timings records how long each pipeline stage took, keyed by stage name.
+	Timings map[string]StageTiming `firestore:"timings,omitempty"`
+	// StatusHistory records every change of Status, oldest first.
+	StatusHistory []StatusTransition `firestore:"statusHistory,omitempty"`
+	// ParentBundleID is set on a document split from a bundle upload and names the
+	// bundle's document. SubDocumentIndex numbers the bundle's documents from 1, so
+	// together with FileHash, which is the bundle's, it identifies the upload it came
+	// from; it is 0 on every other document. BundleStartPage and BundleEndPage are the
+	// document's pages within the bundle.
+	ParentBundleID   string `firestore:"parentBundleId,omitempty"`
+	SubDocumentIndex int    `firestore:"subDocumentIndex,omitempty"`
+	BundleTitle      string `firestore:"bundleTitle,omitempty"`
+	BundleStartPage  int    `firestore:"bundleStartPage,omitempty"`
+	BundleEndPage    int    `firestore:"bundleEndPage,omitempty"`
+	// SubDocumentIDs lists, on a bundle's document, the documents split from it in page
+	// order, and BundleSplitMethod how their boundaries were found.
+	SubDocumentIDs    []string `firestore:"subDocumentIds,omitempty"`
+	BundleSplitMethod string   `firestore:"bundleSplitMethod,omitempty"`
+	// ProcessingProfile holds the document's per-stage settings, from the upload's
+	// "processing-profile" metadata. Documents split before it was recorded have none.
+	ProcessingProfile *ProcessingProfile `firestore:"processingProfile,omitempty"`
+	// UploadedPages and FailedPages list the pages whose split files the splitter uploaded
+	// and failed to upload. Failed pages have no split file until the retranslator
+	// backfills them from the upload, which moves them to UploadedPages.
+	UploadedPages []int `firestore:"uploadedPages,omitempty"`
+	FailedPages   []int `firestore:"failedPages,omitempty"`
+	// DedupeScope and DedupeKey are the scope the document's file hash is unique in, one
+	// of the DedupeScope* values other than global, and the source bucket or tenant ID it
+	// was unique for. Both are empty on documents deduplicated globally.
+	DedupeScope string `firestore:"dedupeScope,omitempty"`
+	DedupeKey   string `firestore:"dedupeKey,omitempty"`
+	// SupersedesDocumentID names the earlier document of the same file, in the same
+	// scope, that this one re-ingests once DEDUPE_TTL_DAYS had passed.
+	SupersedesDocumentID string `firestore:"supersedesDocumentId,omitempty"`
+	// ReprocessOf names the document of the prior revision of this one's file, from the
+	// upload's x-goog-meta-reprocess-of. The revision-differ diffs against it by default.
+	ReprocessOf string `firestore:"reprocessOf,omitempty"`
+	// CancelRequested is set by the document-admin cancel endpoint. Each stage checks it
+	// before starting work, stops with a CANCELLED error and moves the document to
+	// CANCELLED. CancelRequestedAt is when it was set.
+	CancelRequested   bool      `firestore:"cancelRequested,omitempty"`
+	CancelRequestedAt time.Time `firestore:"cancelRequestedAt,omitempty"`
+}
+
+// Duplicate check scopes, as set by DEDUPE_SCOPE. A file is processed once globally, once
+// per source bucket, or once per tenant, as recorded in Document.TenantID. Uploads without
+// a tenant are checked globally.
+const (
+	DedupeScopeGlobal = "global"
+	DedupeScopeBucket = "bucket"
+	DedupeScopeTenant = "tenant"
+)
+
+// ProcessingProfile is the per-document configuration each stage reads in place of its
+// own defaults. Zero values leave the stage's configured default in effect.
+type ProcessingProfile struct {
+	ChunkSize     int    `firestore:"chunkSize,omitempty" json:"chunkSize,omitempty"`         // Pages per split file.
+	PromptProfile string `firestore:"promptProfile,omitempty" json:"promptProfile,omitempty"` // Translator prompt profile.
+	CleanerMode   string `firestore:"cleanerMode,omitempty" json:"cleanerMode,omitempty"`     // One of the cleaner modes.
+	// SkipSections completes the document after cleanup without splitting it into
+	// sections.
+	SkipSections bool `firestore:"skipSections,omitempty" json:"skipSections,omitempty"`
+}
+
+// StatusTransition is one change of a document's status, made by the named stage. Detail
+// holds the error or rejection reason, if any.
+type StatusTransition struct {
+	Status    string    `firestore:"status" json:"status"`
+	Timestamp time.Time `firestore:"timestamp" json:"timestamp"`
+	Stage     string    `firestore:"stage" json:"stage"`
+	Detail    string    `firestore:"detail,omitempty" json:"detail,omitempty"`
+}
+
+// Values of Document.NotificationStatus.
+const (
+	NotificationStatusPublished = "PUBLISHED"
+	NotificationStatusFailed    = "FAILED"
+)
+
+// Stage names used as keys of Document.TokenUsage.
+const (
+	TokenUsageStageTranslator      = "translator"
+	TokenUsageStageCleaner         = "cleaner"
+	TokenUsageStageSectionSplitter = "sectionSplitter"
+	TokenUsageStageBundleSplitter  = "bundleSplitter"
+)
+
+// Stage names used as keys of Document.Timings, in addition to the TokenUsage stage names.
+const (
+	TimingStageSplitter   = "splitter"
+	TimingStageAggregator = "aggregator"
+)
+
+// Stages recorded on status transitions made by the reprocessor, the workflow retrigger,
+// the page retranslator, the document uploader and the stuck-document sweeper. Other
+// transitions use the Timings stage names.
+const (
+	StatusStageReprocessor  = "reprocessor"
+	StatusStageRetrigger    = "retrigger"
+	StatusStageRetranslator = "retranslator"
+	StatusStageUploader     = "uploader"
+	StatusStageSweeper      = "sweeper"
+)
+
+// StageTiming is how long a pipeline stage took to run, and with what outcome. The
+// translator runs once per page, so its entry instead aggregates every page run: Count,
+// FailedCount, TotalMs, MinMs and MaxMs accumulate, and CompletedAt and Status are those
+// of the most recent run.
+type StageTiming struct {
+	StartedAt   time.Time `firestore:"startedAt,omitempty" json:"startedAt,omitempty"`
+	CompletedAt time.Time `firestore:"completedAt,omitempty" json:"completedAt,omitempty"`
+	DurationMs  int64     `firestore:"durationMs,omitempty" json:"durationMs,omitempty"`
+	Status      string    `firestore:"status,omitempty" json:"status,omitempty"`
+	Count       int64     `firestore:"count,omitempty" json:"count,omitempty"`
+	FailedCount int64     `firestore:"failedCount,omitempty" json:"failedCount,omitempty"`
+	TotalMs     int64     `firestore:"totalMs,omitempty" json:"totalMs,omitempty"`
+	MinMs       int64     `firestore:"minMs,omitempty" json:"minMs,omitempty"`
+	MaxMs       int64     `firestore:"maxMs,omitempty" json:"maxMs,omitempty"`
+	// AvgMs is TotalMs / Count. It is derived when read and not stored.
+	AvgMs int64 `firestore:"-" json:"avgMs,omitempty"`
+}
+
+// TokenUsage is the Gemini token count of one call, or a running total of many.
+type TokenUsage struct {
+	PromptTokens     int64 `firestore:"promptTokens" json:"promptTokens"`
+	CandidatesTokens int64 `firestore:"candidatesTokens" json:"candidatesTokens"`
+	TotalTokens      int64 `firestore:"totalTokens" json:"totalTokens"`
+}
+
+// CostEstimate is what Gemini calls are expected to cost, from the input tokens counted
+// for them and the output expected for that input. Costs are in US dollars, and are only
+// set when the model's pricing is configured.
+type CostEstimate struct {
+	Model            string   `json:"model"`
+	InputTokens      int64    `json:"inputTokens"`
+	OutputTokensLow  int64    `json:"outputTokensLow"`
+	OutputTokensHigh int64    `json:"outputTokensHigh"`
+	CostLow          *float64 `json:"costLow,omitempty"`
+	CostHigh         *float64 `json:"costHigh,omitempty"`
+}
+
+// RevisionDiffSummary records how a document's pages differ from a prior revision.
+// ReportURI is the operator report listing each page that differs.
+type RevisionDiffSummary struct {
+	BaseDocumentID    string `firestore:"baseDocumentId" json:"baseDocumentId"`
+	PagesChanged      int    `firestore:"pagesChanged" json:"pagesChanged"`
+	PagesAdded        int    `firestore:"pagesAdded" json:"pagesAdded"`
+	PagesRemoved      int    `firestore:"pagesRemoved" json:"pagesRemoved"`
+	TotalLinesChanged int    `firestore:"totalLinesChanged" json:"totalLinesChanged"`
+	ReportURI         string `firestore:"reportUri,omitempty" json:"reportUri,omitempty"`
+}
+
+// Kinds of RevisionDiffPage.
+const (
+	RevisionPageChanged = "changed"
+	RevisionPageAdded   = "added"
+	RevisionPageRemoved = "removed"
+)
+
+// RevisionDiffPage is a page that differs between two revisions. BasePage is zero for an
+// added page and NewPage for a removed one. DiffURI is empty when the diff had no lines.
+type RevisionDiffPage struct {
+	Change       string `json:"change"`
+	BasePage     int    `json:"basePage,omitempty"`
+	NewPage      int    `json:"newPage,omitempty"`
+	LinesChanged int    `json:"linesChanged"`
+	DiffURI      string `json:"diffUri,omitempty"`
+	Truncated    bool   `json:"truncated,omitempty"`
+}
+
+// RevisionDiffReport is the operator report the revision-differ writes next to a
+// document's diffs.
+type RevisionDiffReport struct {
+	DocumentID string              `json:"documentId"`
+	Summary    RevisionDiffSummary `json:"summary"`
+	Pages      []RevisionDiffPage  `json:"pages"`
+	CreatedAt  time.Time           `json:"createdAt"`
 }
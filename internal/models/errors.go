@@ -0,0 +1,78 @@
+package models
+
+import (
+	"errors"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// ErrorCode classifies why a request to a worker function failed, so that the workflow
+// can tell permanent failures from those worth retrying.
+type ErrorCode string
+
+const (
+	ErrorCodeInvalidRequest    ErrorCode = "INVALID_REQUEST"      // The request itself is malformed.
+	ErrorCodeNotFoundSource    ErrorCode = "NOT_FOUND_SOURCE"     // An input object or document does not exist.
+	ErrorCodeSourceNotReady    ErrorCode = "SOURCE_NOT_READY"     // An input object does not exist yet but is being uploaded.
+	ErrorCodeEmptySource       ErrorCode = "EMPTY_SOURCE"         // An input exists but has no content.
+	ErrorCodeLLMBlocked        ErrorCode = "LLM_BLOCKED"          // Gemini blocked the prompt or response, or refused.
+	ErrorCodeLLMQuota          ErrorCode = "LLM_QUOTA"            // The Vertex AI quota is exhausted for now.
+	ErrorCodeLLMUnavailable    ErrorCode = "LLM_UNAVAILABLE"      // Any other failed Gemini call.
+	ErrorCodeLLMMalformed      ErrorCode = "LLM_MALFORMED_OUTPUT" // Gemini's output was not usable markdown, even when asked again.
+	ErrorCodeOutputWriteFailed ErrorCode = "OUTPUT_WRITE_FAILED"  // An output object could not be written.
+	ErrorCodeOutputRejected    ErrorCode = "OUTPUT_REJECTED"      // An output failed a sanity check and was not saved.
+	ErrorCodeTimeout           ErrorCode = "TIMEOUT"              // The request did not finish in time.
+	ErrorCodeCancelled         ErrorCode = "CANCELLED"            // The document was cancelled by an admin.
+	ErrorCodeInternal          ErrorCode = "INTERNAL"             // Anything unclassified.
+)
+
+// Retryable reports whether a request that failed with the code may succeed if sent
+// again unchanged.
+func (c ErrorCode) Retryable() bool {
+	switch c {
+	case ErrorCodeInvalidRequest, ErrorCodeNotFoundSource, ErrorCodeEmptySource, ErrorCodeLLMBlocked, ErrorCodeOutputRejected, ErrorCodeCancelled:
+		return false
+	default:
+		return true
+	}
+}
+
+// CodedError is an error classified by an ErrorCode. Its message is that of the error it
+// wraps.
+type CodedError struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *CodedError) Error() string { return e.Err.Error() }
+
+func (e *CodedError) Unwrap() error { return e.Err }
+
+// WithCode classifies err by code. A nil err stays nil, and an error that is already
+// classified keeps the code it was given closest to the failure.
+func WithCode(code ErrorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	var coded *CodedError
+	if errors.As(err, &coded) {
+		return err
+	}
+	return &CodedError{Code: code, Err: err}
+}
+
+// CodeOf returns the code err was classified by, or ErrorCodeInternal if it was not.
+func CodeOf(err error) ErrorCode {
+	var coded *CodedError
+	if errors.As(err, &coded) {
+		return coded.Code
+	}
+	return ErrorCodeInternal
+}
+
+// ErrorResponse is the JSON body of a failed request to a worker function.
+type ErrorResponse struct {
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	Retryable bool      `json:"retryable"`
+}
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// MaxFilenameLength bounds a display filename, in bytes, as most file systems do.
+const MaxFilenameLength = 255
+
+// maxSlugLength bounds a filename slug, in bytes, leaving room for the rest of an
+// output name.
+const maxSlugLength = 80
+
+// untitledSlug is the slug of a filename with nothing usable in it.
+const untitledSlug = "untitled"
+
+// Filename is an upload's file name in the forms a document records.
+type Filename struct {
+	// ObjectPath is the name as it was given: an object path, a client path or a
+	// percent-encoded name.
+	ObjectPath string
+	// Display is its base name, percent-decoded, NFC-normalized and free of control and
+	// formatting characters. It is empty if the name has no base name.
+	Display string
+	// Slug is Display without its extensions, as Slug makes it, for output name
+	// templates. It is never empty.
+	Slug string
+}
+
+// NormalizeFilename returns the forms of the file name raw. A name is percent-decoded
+// only if it decodes to valid UTF-8, so a literal "%" in a name survives. Both "/" and
+// "\" separate path components, as a name may come from a Windows client.
+func NormalizeFilename(raw string) Filename {
+	name := strings.TrimSpace(raw)
+	if strings.Contains(name, "%") {
+		if decoded, err := url.PathUnescape(name); err == nil && utf8.ValidString(decoded) {
+			name = decoded
+		}
+	}
+	name = strings.TrimRight(strings.ReplaceAll(name, `\`, "/"), "/")
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = norm.NFC.String(strings.ToValidUTF8(name, "\uFFFD"))
+	name = strings.Join(strings.Fields(strings.Map(displayRune, name)), " ")
+	if name == "." || name == ".." {
+		name = ""
+	}
+	display := truncateFilename(name)
+	return Filename{ObjectPath: raw, Display: display, Slug: filenameSlug(display)}
+}
+
+// displayRune maps whitespace to a space and drops control and formatting characters,
+// such as bidirectional overrides that would make a name display as another.
+func displayRune(r rune) rune {
+	switch {
+	case unicode.IsSpace(r):
+		return ' '
+	case unicode.IsControl(r) || unicode.Is(unicode.Cf, r):
+		return -1
+	}
+	return r
+}
+
+// truncateFilename shortens name to MaxFilenameLength bytes, on a rune boundary,
+// keeping its extension.
+func truncateFilename(name string) string {
+	if len(name) <= MaxFilenameLength {
+		return name
+	}
+	ext := path.Ext(name)
+	if len(ext) >= MaxFilenameLength/2 {
+		ext = ""
+	}
+	stem := name[:MaxFilenameLength-len(ext)]
+	for !utf8.ValidString(stem) {
+		stem = stem[:len(stem)-1]
+	}
+	return strings.TrimSpace(stem) + ext
+}
+
+// filenameSlug returns the slug of a display filename. Up to two extensions are
+// dropped, so "report.pdf" and "report.pdf.pdf" both give "report". Letters and digits
+// with no ASCII form, such as CJK characters, are kept by Slug, so distinct names keep
+// distinct slugs.
+func filenameSlug(display string) string {
+	stem := display
+	for range 2 {
+		ext := path.Ext(stem)
+		if ext == stem || !isFileExtension(ext) {
+			break
+		}
+		stem = strings.TrimSuffix(stem, ext)
+	}
+	return Slug(stem)
+}
+
+// Slug returns s lowercased, with Latin letters folded to ASCII and each run of anything
+// but letters, digits and their marks replaced by "-", for object names. Letters with no
+// ASCII form, such as Cyrillic or CJK, are kept as they are, so "Насос" gives "насос".
+// It is never empty.
+func Slug(s string) string {
+	words := strings.FieldsFunc(FoldToASCII(strings.ToLower(s)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r)
+	})
+	result := strings.Join(words, "-")
+	if len(result) > maxSlugLength {
+		result = result[:maxSlugLength]
+		for !utf8.ValidString(result) {
+			result = result[:len(result)-1]
+		}
+		result = strings.TrimRight(result, "-")
+	}
+	if result == "" {
+		return untitledSlug
+	}
+	return result
+}
+
+// isFileExtension reports whether ext, with its leading dot, looks like a file extension:
+// one to five ASCII letters and digits, at least one of them a letter, so the ".2" of
+// "v1.2" is not one.
+func isFileExtension(ext string) bool {
+	ext = strings.TrimPrefix(ext, ".")
+	if ext == "" || len(ext) > 5 {
+		return false
+	}
+	hasLetter := false
+	for _, r := range ext {
+		switch {
+		case r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z':
+			hasLetter = true
+		case r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return hasLetter
+}
+
+// asciiTransliterations covers Latin letters that do not decompose into a base letter
+// plus combining marks.
+var asciiTransliterations = strings.NewReplacer(
+	"ß", "ss", "æ", "ae", "œ", "oe", "ø", "o", "đ", "d", "ð", "d", "þ", "th", "ł", "l", "ı", "i",
+)
+
+// stripMarks decomposes accented letters and drops the combining marks, so "é" becomes "e".
+var stripMarks = transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// FoldToASCII transliterates the accented and special Latin letters of lowercase text
+// s to ASCII. Other characters are left as they are.
+func FoldToASCII(s string) string {
+	s = asciiTransliterations.Replace(s)
+	if folded, _, err := transform.String(stripMarks, s); err == nil {
+		return folded
+	}
+	return s
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNormalizeFilename(t *testing.T) {
+	tests := []struct {
+		name        string
+		raw         string
+		wantDisplay string
+		wantSlug    string
+	}{
+		{name: "plain", raw: "Pump Manual.pdf", wantDisplay: "Pump Manual.pdf", wantSlug: "pump-manual"},
+		{name: "object path", raw: "uploads/2024/Pump Manual.pdf", wantDisplay: "Pump Manual.pdf", wantSlug: "pump-manual"},
+		{name: "windows path", raw: `C:\Users\eng\Pump Manual.pdf`, wantDisplay: "Pump Manual.pdf", wantSlug: "pump-manual"},
+		{name: "double extension", raw: "report.pdf.pdf", wantDisplay: "report.pdf.pdf", wantSlug: "report"},
+		{name: "version is not an extension", raw: "spec v1.2", wantDisplay: "spec v1.2", wantSlug: "spec-v1-2"},
+		{name: "accents fold to ASCII", raw: "Größe Übersicht.pdf", wantDisplay: "Größe Übersicht.pdf", wantSlug: "grosse-ubersicht"},
+		{name: "cyrillic", raw: "Руководство по насосу.pdf", wantDisplay: "Руководство по насосу.pdf", wantSlug: "руководство-по-насосу"},
+		{name: "cjk", raw: "泵手册.pdf", wantDisplay: "泵手册.pdf", wantSlug: "泵手册"},
+		{name: "percent-encoded", raw: "Pump%20Manual%20%C3%A9.pdf", wantDisplay: "Pump Manual é.pdf", wantSlug: "pump-manual-e"},
+		{name: "percent-encoded cyrillic", raw: "%D0%9D%D0%B0%D1%81%D0%BE%D1%81.pdf", wantDisplay: "Насос.pdf", wantSlug: "насос"},
+		{name: "literal percent", raw: "100% Load.pdf", wantDisplay: "100% Load.pdf", wantSlug: "100-load"},
+		{name: "dot dot", raw: "..", wantDisplay: "", wantSlug: "untitled"},
+		{name: "path ending in dot dot", raw: "uploads/..", wantDisplay: "", wantSlug: "untitled"},
+		{name: "encoded traversal", raw: "%2E%2E%2F%2E%2E%2Fetc%2Fpasswd", wantDisplay: "passwd", wantSlug: "passwd"},
+		{name: "control characters", raw: "Pump\x00\u202eManual\t.pdf", wantDisplay: "PumpManual .pdf", wantSlug: "pumpmanual"},
+		{name: "only punctuation", raw: "---.pdf", wantDisplay: "---.pdf", wantSlug: "untitled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NormalizeFilename(tt.raw)
+			if got.ObjectPath != tt.raw {
+				t.Errorf("NormalizeFilename(%q).ObjectPath = %q, want the raw name", tt.raw, got.ObjectPath)
+			}
+			if got.Display != tt.wantDisplay {
+				t.Errorf("NormalizeFilename(%q).Display = %q, want %q", tt.raw, got.Display, tt.wantDisplay)
+			}
+			if got.Slug != tt.wantSlug {
+				t.Errorf("NormalizeFilename(%q).Slug = %q, want %q", tt.raw, got.Slug, tt.wantSlug)
+			}
+		})
+	}
+}
+
+func TestSlugTruncatesOnARuneBoundary(t *testing.T) {
+	slug := Slug(strings.Repeat("насос ", 20))
+	if len(slug) > maxSlugLength || !utf8.ValidString(slug) || strings.HasSuffix(slug, "-") {
+		t.Errorf("Slug() = %q (%d bytes), want valid UTF-8 of at most %d bytes not ending in -", slug, len(slug), maxSlugLength)
+	}
+}
+
+func TestNormalizeFilenameTruncatesLongNames(t *testing.T) {
+	got := NormalizeFilename(strings.Repeat("é", 200) + ".pdf")
+	if len(got.Display) > MaxFilenameLength || !utf8.ValidString(got.Display) || !strings.HasSuffix(got.Display, ".pdf") {
+		t.Errorf("NormalizeFilename().Display = %q (%d bytes), want valid UTF-8 of at most %d bytes keeping .pdf", got.Display, len(got.Display), MaxFilenameLength)
+	}
+}
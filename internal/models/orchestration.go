@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Orchestration modes, as set by ORCHESTRATION_MODE. In the Cloud Workflows mode the
+// splitter starts a processing workflow execution, which calls each stage over HTTP. In
+// the Pub/Sub mode the splitter publishes a request per page, each stage is triggered by
+// its topic, and the pipeline coordinator starts the next stage as each one completes.
+const (
+	OrchestrationModeWorkflows = "workflows"
+	OrchestrationModePubSub    = "pubsub"
+)
+
+// StageCompletedEvent is published to the coordinator topic by a stage triggered through
+// Pub/Sub once it has finished with a request, whether it succeeded or failed for good.
+type StageCompletedEvent struct {
+	DocumentID string `json:"documentId"`
+	// RunID identifies the pipeline run, and is the ExecutionID of the stage's request.
+	RunID string `json:"runId"`
+	// Stage is the PipelineRunStage* name of the stage that completed.
+	Stage string `json:"stage"`
+	// Page is the first page of the page or chunk a translator event is for.
+	Page int `json:"page,omitempty"`
+	// Status is the stage's response status, or "failed" when it failed for good.
+	Status string `json:"status"`
+	// OutputGCSUri is the stage's output: the master file of the aggregator and the
+	// cleaned markdown of the cleaner.
+	OutputGCSUri string `json:"outputGcsUri,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// StageCompletedStatusFailed is the Status of a StageCompletedEvent for a request that
+// failed with an error a retry cannot fix.
+const StageCompletedStatusFailed = "failed"
+
+// PipelineRun is the coordinator's record of a run of the pipeline over a document in the
+// Pub/Sub mode. It is stored in the document's runs subcollection, keyed by run ID.
+type PipelineRun struct {
+	// Stage is the PipelineRunStage* stage the run is waiting on, or its outcome.
+	Stage string `firestore:"stage" json:"stage"`
+	// PendingPages lists the first page of every page or chunk whose translation has not
+	// completed, and Remaining counts them. A completion for a page not listed is a
+	// duplicate delivery and is ignored.
+	PendingPages []int `firestore:"pendingPages" json:"pendingPages"`
+	Remaining    int   `firestore:"remaining" json:"remaining"`
+	// Dispatched lists the stages whose requests have been published. A stage the run
+	// has reached but not dispatched had its publish fail, and is published again when
+	// the event that reached it is redelivered.
+	Dispatched    []string `firestore:"dispatched" json:"dispatched"`
+	MasterGCSUri  string   `firestore:"masterGcsUri,omitempty" json:"masterGcsUri,omitempty"`
+	CleanedGCSUri string   `firestore:"cleanedGcsUri,omitempty" json:"cleanedGcsUri,omitempty"`
+	// SourceLanguage and TargetLanguage are passed along to the cleaner, and TenantID and
+	// Traceparent to every stage.
+	TenantID       string    `firestore:"tenantId,omitempty" json:"tenantId,omitempty"`
+	SourceLanguage string    `firestore:"sourceLanguage,omitempty" json:"sourceLanguage,omitempty"`
+	TargetLanguage string    `firestore:"targetLanguage,omitempty" json:"targetLanguage,omitempty"`
+	Traceparent    string    `firestore:"traceparent,omitempty" json:"traceparent,omitempty"`
+	ErrorDetails   string    `firestore:"errorDetails,omitempty" json:"errorDetails,omitempty"`
+	CreatedAt      time.Time `firestore:"createdAt" json:"createdAt"`
+	UpdatedAt      time.Time `firestore:"updatedAt" json:"updatedAt"`
+}
+
+// Stages a PipelineRun waits on, in order. They are the stages' TokenUsage and Timings
+// names, so that a run's stages read as the document's.
+const (
+	PipelineRunStageTranslator      = TokenUsageStageTranslator
+	PipelineRunStageAggregator      = TimingStageAggregator
+	PipelineRunStageCleaner         = TokenUsageStageCleaner
+	PipelineRunStageSectionSplitter = TokenUsageStageSectionSplitter
+)
+
+// Outcomes recorded as PipelineRun.Stage once a run has no stage left to wait on.
+const (
+	PipelineRunStageComplete = "complete"
+	PipelineRunStageFailed   = "failed"
+)
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Page statuses recorded in the pages subcollection.
+const (
+	PageStatusPending     = "PENDING"
+	PageStatusTranslating = "TRANSLATING"
+	PageStatusTranslated  = "TRANSLATED"
+	PageStatusSkipped     = "SKIPPED"
+	PageStatusFailed      = "FAILED"
+	// PageStatusFailedSoft marks a page that failed but was allowed to, and has a failure
+	// placeholder in place of its markdown. It does not block aggregation.
+	PageStatusFailedSoft = "FAILED_SOFT"
+	// PageStatusUploadFailed marks a page whose split file the splitter could not upload.
+	// The workflow does not dispatch it, and the retranslator backfills it.
+	PageStatusUploadFailed = "UPLOAD_FAILED"
+)
+
+// Page tracks the processing state of a single split page. Page records live in the
+// `pages` subcollection of their Document, keyed by zero-padded page number.
+type Page struct {
+	PageNumber   int       `firestore:"pageNumber" json:"pageNumber"`
+	Status       string    `firestore:"status" json:"status"`
+	GCSUri       string    `firestore:"gcsUri,omitempty" json:"gcsUri,omitempty"`
+	MarkdownURI  string    `firestore:"markdownUri,omitempty" json:"markdownUri,omitempty"`
+	AttemptCount int       `firestore:"attemptCount" json:"attemptCount"`
+	ErrorDetails string    `firestore:"errorDetails,omitempty" json:"errorDetails,omitempty"`
+	Confidence   *float64  `firestore:"confidence,omitempty" json:"confidence,omitempty"`
+	QualityFlags []string  `firestore:"qualityFlags,omitempty" json:"qualityFlags,omitempty"`
+	UpdatedAt    time.Time `firestore:"updatedAt" json:"updatedAt"`
+	// Redactions counts the findings Cloud DLP replaced before the page was translated,
+	// when it was translated with a redactionMode of "dlp".
+	Redactions *int `firestore:"redactions,omitempty" json:"redactions,omitempty"`
+	// BlockReason and BlockCategory record why Gemini's safety filters blocked the page's
+	// translation, and the harm category that tripped them, if one is known.
+	BlockReason   string `firestore:"blockReason,omitempty" json:"blockReason,omitempty"`
+	BlockCategory string `firestore:"blockCategory,omitempty" json:"blockCategory,omitempty"`
+	// ImageURIs are the gs:// URIs of the images extracted from the page, in the order
+	// the translator is told to reference them by.
+	ImageURIs []string `firestore:"imageUris,omitempty" json:"imageUris,omitempty"`
+	// DegradedInput marks a page over the model's input limit that was translated from a
+	// re-rendering at RenderedDPI, of RenderedSizeBytes, instead of its PDF.
+	DegradedInput     bool  `firestore:"degradedInput,omitempty" json:"degradedInput,omitempty"`
+	RenderedDPI       int   `firestore:"renderedDpi,omitempty" json:"renderedDpi,omitempty"`
+	RenderedSizeBytes int64 `firestore:"renderedSizeBytes,omitempty" json:"renderedSizeBytes,omitempty"`
+}
+
+// PageDocID returns the document ID of a page record within the pages subcollection.
+func PageDocID(pageNumber int) string {
+	return fmt.Sprintf("%05d", pageNumber)
+}
@@ -1,57 +1,611 @@
 package models
 
-import 	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+import (
+	"time"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
 // These structs define the JSON payloads for HTTP requests and responses
 // between the Cloud Workflow and the worker Cloud Functions.
 
 // PageTranslatorRequest is the input for the page-translator function.
 type PageTranslatorRequest struct {
-	DocumentID  string `json:"documentId"`
+	DocumentID string `json:"documentId"`
+	// TenantID must be the tenant recorded on the document, or empty for a document
+	// without one. The same holds for the other stage requests.
+	TenantID    string `json:"tenantId,omitempty"`
 	PageNumber  int    `json:"pageNumber"`
 	GCSUri      string `json:"gcsUri"`
 	ExecutionID string `json:"executionId"`
+	// IncludeImages asks the translator to reference the page's extracted images by index
+	// and rewrite those references into markdown image links. EXTRACT_IMAGES does so for
+	// every page.
+	IncludeImages bool `json:"includeImages,omitempty"`
+	// PageRange is set when GCSUri points at a multi-page chunk rather than a single
+	// page. PageNumber is ignored when it is present.
+	PageRange *PageRange `json:"pageRange,omitempty"`
+	// AllowFailure turns a failed translation into a "failed_soft" response with a
+	// placeholder markdown file, so that one bad page does not fail the whole document.
+	AllowFailure bool `json:"allowFailure,omitempty"`
+	// PromptProfile selects the translator prompts for a specialized document type: one
+	// of "default", "drawing", "contract" or "table_heavy". Unknown profiles fall back to
+	// the default, and the document's processing profile, if it names one, overrides it.
+	PromptProfile string `json:"promptProfile,omitempty"`
+	// CustomUserPrompt, when set, replaces the profile's user prompt. The profile's
+	// system prompt still applies.
+	CustomUserPrompt string `json:"customUserPrompt,omitempty"`
+	// SourceLanguage is the language the document is written in, such as "Vietnamese",
+	// and TargetLanguage the language to write the markdown in. TargetLanguage equal to
+	// SourceLanguage keeps the source language. Both are optional; the workflow passes
+	// along the values recorded on the document.
+	SourceLanguage string `json:"sourceLanguage,omitempty"`
+	TargetLanguage string `json:"targetLanguage,omitempty"`
+	// ProcessingRegion is the Vertex AI region the page must be translated in, such as
+	// "europe-west4", and RedactionMode one of the RedactionMode constants. Both are
+	// optional; the workflow passes along the values recorded on the document.
+	ProcessingRegion string `json:"processingRegion,omitempty"`
+	RedactionMode    string `json:"redactionMode,omitempty"`
+	// ExtractTables asks the translator to also write the page's tables as CSV files,
+	// whether or not EXTRACT_TABLES is set.
+	ExtractTables bool `json:"extractTables,omitempty"`
+	// SkipCache has the page translated by the model even when the translation cache
+	// holds an identical page. The translation still replaces the cached one.
+	SkipCache bool `json:"skipCache,omitempty"`
+	// DryRun estimates what translating the pages would cost instead of translating
+	// them: the model is not called, nothing is written and the document is left as is.
+	DryRun bool `json:"dryRun,omitempty"`
+	// Traceparent is the W3C trace context forwarded by the workflow, if tracing is on.
+	Traceparent string `json:"traceparent,omitempty"`
+}
+
+// How a document's pages are protected before they are sent to Vertex AI.
+const (
+	RedactionModeNone = "none" // The page is sent as is. The same as no mode.
+	RedactionModeDLP  = "dlp"  // Only the page's OCR text is sent, after Cloud DLP redacts it.
+)
+
+// PageRange is an inclusive, 1-based range of pages.
+type PageRange struct {
+	StartPage int `json:"startPage"`
+	EndPage   int `json:"endPage"`
+}
+
+// Pages returns the inclusive range of pages covered by the request.
+func (r *PageTranslatorRequest) Pages() (int, int) {
+	if r.PageRange != nil {
+		return r.PageRange.StartPage, r.PageRange.EndPage
+	}
+	return r.PageNumber, r.PageNumber
 }
 
 // PageTranslatorResponse is the output of the page-translator function.
 type PageTranslatorResponse struct {
 	Status       string `json:"status"`
 	OutputGCSUri string `json:"outputGcsUri"`
+	// Attempts is the number of Gemini calls made, including retries. It is zero when
+	// the model was not called.
+	Attempts int `json:"attempts,omitempty"`
+	// Usage is the token count of the successful Gemini call, if one was made.
+	Usage *TokenUsage `json:"usage,omitempty"`
+	// Error is the failure reason of a "failed_soft" response.
+	Error string `json:"error,omitempty"`
+	// Model is the Gemini model that produced the markdown, if one was called.
+	Model string `json:"model,omitempty"`
+	// PromptProfile is the prompt profile the page was translated with, after any
+	// fallback, and CustomPrompt reports whether the request's custom user prompt was used.
+	PromptProfile string `json:"promptProfile,omitempty"`
+	CustomPrompt  bool   `json:"customPrompt,omitempty"`
+	// SourceLanguage and TargetLanguage are the language hints the page was translated
+	// with, if any.
+	SourceLanguage string `json:"sourceLanguage,omitempty"`
+	TargetLanguage string `json:"targetLanguage,omitempty"`
+	// Confidence scores the translated markdown from 0 to 1 using simple heuristics, and
+	// Flags names the heuristics that lowered it. Confidence is nil when the model was
+	// not called.
+	Confidence *float64 `json:"confidence,omitempty"`
+	Flags      []string `json:"flags,omitempty"`
+	// Timing is how long this page run took.
+	Timing *StageTiming `json:"timing,omitempty"`
+	// Output is one of the TranslationOutput constants for a "success" response.
+	Output string `json:"output,omitempty"`
+	// Method is one of the TranslationMethod constants for a page the model was called on.
+	Method string `json:"method,omitempty"`
+	// Region is the Vertex AI region the model was called in, and Redactions the number
+	// of findings Cloud DLP replaced with a redactionMode of "dlp".
+	Region     string `json:"region,omitempty"`
+	Redactions int    `json:"redactions,omitempty"`
+	// Tables are the gs:// URIs of the CSV files written for the page's tables, and
+	// TablesSkipped the number of tables left out because the model described them
+	// malformed.
+	Tables        []string `json:"tables,omitempty"`
+	TablesSkipped int      `json:"tablesSkipped,omitempty"`
+	// Images are the gs:// URIs of the page's extracted images that its markdown was
+	// asked to reference.
+	Images []string `json:"images,omitempty"`
+	// Degraded is set when the page was over the model's input limit and was translated
+	// from a re-rendering of it.
+	Degraded *DegradedInput `json:"degraded,omitempty"`
+	// CacheHit reports that the markdown was copied from the translation cache, made for
+	// an identical page of another document, instead of calling the model.
+	CacheHit bool `json:"cacheHit,omitempty"`
+	// Estimate is what translating the pages would cost, for a "dry_run" response.
+	Estimate *CostEstimate `json:"estimate,omitempty"`
 }
 
+// DegradedInput is the re-rendering an oversize page was translated from.
+type DegradedInput struct {
+	DPI       int   `json:"dpi"`
+	SizeBytes int64 `json:"sizeBytes"`
+}
+
+// How a translation's markdown was produced.
+const (
+	TranslationMethodGemini         = "gemini"         // Gemini read the page on its own.
+	TranslationMethodGeminiOCR      = "gemini+ocr"     // Gemini structured Document AI OCR text of the page.
+	TranslationMethodGeminiRedacted = "gemini+ocr+dlp" // Gemini structured redacted OCR text, without the page.
+)
+
+// What a successful translation did with the page's output object. Regenerated
+// replaces an output whose source page has changed since, or that is incomplete or a
+// placeholder.
+const (
+	TranslationOutputFresh       = "fresh"       // There was no earlier output.
+	TranslationOutputReused      = "reused"      // A complete output of the same source page was kept.
+	TranslationOutputRegenerated = "regenerated" // An earlier output was replaced.
+)
+
 // MarkdownAggregatorRequest is the input for the markdown-aggregator function.
 type MarkdownAggregatorRequest struct {
 	DocumentID  string `json:"documentId"`
+	TenantID    string `json:"tenantId,omitempty"`
 	ExecutionID string `json:"executionId"`
+	Traceparent string `json:"traceparent,omitempty"`
+	// Incremental joins the pages translated so far into a preview file beside the master
+	// file, with a placeholder for each page still pending, instead of the master file.
+	// It may be requested any number of times while pages are translated.
+	Incremental bool `json:"incremental,omitempty"`
 }
 
 // MarkdownAggregatorResponse is the output of the markdown-aggregator function.
 type MarkdownAggregatorResponse struct {
 	Status       string `json:"status"`
 	MasterGCSUri string `json:"masterGcsUri"`
+	// FailedPages lists pages whose markdown is a failure placeholder, meaning the master
+	// file is incomplete.
+	FailedPages []int        `json:"failedPages,omitempty"`
+	Timing      *StageTiming `json:"timing,omitempty"`
+	// Outputs maps each AggregationFormat written to the gs:// URI of its file. The
+	// markdown format's file is the master file.
+	Outputs map[string]string `json:"outputs,omitempty"`
+	// PreviewGCSUri and PendingPages answer an incremental request: the preview file
+	// written, and the pages it holds placeholders for. MasterGCSUri is then empty.
+	PreviewGCSUri string `json:"previewGcsUri,omitempty"`
+	PendingPages  []int  `json:"pendingPages,omitempty"`
 }
 
+// Formats the aggregator can write the translated pages in, as listed in
+// AGGREGATION_FORMATS.
+const (
+	AggregationFormatMarkdown = "markdown" // master.md, the pages joined for the cleaner.
+	AggregationFormatJSONL    = "jsonl"    // master.jsonl, one {"page": N, "markdown": "..."} line per page file.
+)
+
 // MarkdownCleanerRequest is the input for the markdown-cleaner function.
 type MarkdownCleanerRequest struct {
 	DocumentID   string `json:"documentId"`
+	TenantID     string `json:"tenantId,omitempty"`
 	MasterGCSUri string `json:"masterGcsUri"`
 	ExecutionID  string `json:"executionId"`
+	Traceparent  string `json:"traceparent,omitempty"`
+	// SourceLanguage and TargetLanguage are the document's language hints, as passed to
+	// the translator, so that the cleaner keeps the markdown's language.
+	SourceLanguage string `json:"sourceLanguage,omitempty"`
+	TargetLanguage string `json:"targetLanguage,omitempty"`
+	// DryRun estimates what cleaning the master file would cost instead of cleaning it,
+	// as PageTranslatorRequest.DryRun does.
+	DryRun bool `json:"dryRun,omitempty"`
 }
 
 // MarkdownCleanerResponse is the output of the markdown-cleaner function.
 type MarkdownCleanerResponse struct {
 	Status        string `json:"status"`
 	CleanedGCSUri string `json:"cleanedGcsUri"`
+	// ChunkCount is the number of model calls the document was cleaned in; it is 1 unless
+	// the master file exceeded the chunking threshold, and 0 in deterministic mode.
+	ChunkCount int          `json:"chunkCount"`
+	Usage      *TokenUsage  `json:"usage,omitempty"`
+	Model      string       `json:"model,omitempty"`
+	Timing     *StageTiming `json:"timing,omitempty"`
+	// Mode is the cleaner mode used: "llm", "deterministic" or "hybrid".
+	Mode string `json:"mode"`
+	// Language is the language the cleaner was told the markdown is in, if any.
+	Language string `json:"language,omitempty"`
+	// Report compares the cleaned markdown with the master file.
+	Report *CleanReport `json:"report,omitempty"`
+	// ResumedFromChunk is the first chunk, counted from 1, cleaned by this attempt when
+	// ReusedChunks chunks were checkpointed by an earlier attempt and not cleaned again.
+	ResumedFromChunk int `json:"resumedFromChunk,omitempty"`
+	ReusedChunks     int `json:"reusedChunks,omitempty"`
+	// Estimate is what cleaning the master file would cost, for a "dry_run" response.
+	Estimate *CostEstimate `json:"estimate,omitempty"`
+}
+
+// MarkdownStats counts what cleanup can lose from a markdown file.
+type MarkdownStats struct {
+	Characters int `json:"characters"`
+	Lines      int `json:"lines"`
+	Tables     int `json:"tables"`
+	Headings   int `json:"headings"`
+}
+
+// CleanReport compares the cleaner's output with its input. RetainedRatio is the
+// fraction of the input's characters the output kept; the output is rejected, and not
+// saved, when it falls below MinRetainedRatio.
+type CleanReport struct {
+	DocumentID       string        `json:"documentId"`
+	Input            MarkdownStats `json:"input"`
+	Output           MarkdownStats `json:"output"`
+	RetainedRatio    float64       `json:"retainedRatio"`
+	MinRetainedRatio float64       `json:"minRetainedRatio"`
+	Rejected         bool          `json:"rejected"`
 }
 
 
 type SectionSplitterRequest struct {
 	DocumentID    string `json:"documentId"`
+	TenantID      string `json:"tenantId,omitempty"`
 	CleanedGCSUri string `json:"cleanedGcsUri"`
 	ExecutionID   string `json:"executionId"`
+	Traceparent   string `json:"traceparent,omitempty"`
 }
 
 
 type SectionSplitterResponse struct {
-	Status       string `json:"status"`
-	SectionCount int    `json:"sectionCount"`
-}
\ No newline at end of file
+	// Status is "success", "success_fallback", or "skipped" when the document's processing
+	// profile skips section splitting and the cleaned markdown is its final output.
+	Status       string      `json:"status"`
+	SectionCount int         `json:"sectionCount"`
+	Usage        *TokenUsage `json:"usage,omitempty"`
+	Model        string      `json:"model,omitempty"`
+	// Sections lists the saved sections in document order.
+	Sections []SectionSummary `json:"sections,omitempty"`
+	// ManifestURI is the location of the document's SectionManifest.
+	ManifestURI string `json:"manifestUri,omitempty"`
+	// Coverage is the share of the source's letters and digits found in the saved
+	// sections' titles and content. A low value means the model dropped text.
+	Coverage float64 `json:"coverage"`
+	// WindowCount is the number of windows a document too large for one model call was
+	// split in, and MergeConflicts reports that windows disagreed on a section they
+	// overlap, of which the longer version was kept.
+	WindowCount    int          `json:"windowCount,omitempty"`
+	MergeConflicts bool         `json:"mergeConflicts,omitempty"`
+	Timing         *StageTiming `json:"timing,omitempty"`
+}
+
+// SectionManifest is written as "{docId}/_index.json" next to a document's sections.
+type SectionManifest struct {
+	DocumentID string                 `json:"documentId"`
+	Sections   []SectionManifestEntry `json:"sections"`
+}
+
+// SectionManifestEntry describes one saved section. Order is the section's 1-based
+// position in the document, so gaps mark sections that failed to save. Parent and
+// Children refer to other sections by Order; Parent is zero for top-level sections.
+type SectionManifestEntry struct {
+	Order         int    `json:"order"`
+	OriginalTitle string `json:"originalTitle"`
+	ObjectName    string `json:"objectName"`
+	ContentLength int    `json:"contentLength"`
+	Level         int    `json:"level"`
+	StartPage     int    `json:"startPage,omitempty"`
+	EndPage       int    `json:"endPage,omitempty"`
+	Parent        int    `json:"parent,omitempty"`
+	Children      []int  `json:"children,omitempty"`
+}
+
+// SectionSummary describes one saved section. StartPage and EndPage are derived from the
+// aggregator's page anchors and are zero when the document has none. SignedURL is a
+// time-limited link to GCSUri, set when SIGN_OUTPUT_URLS is enabled.
+type SectionSummary struct {
+	Title     string `json:"title"`
+	StartPage int    `json:"startPage,omitempty"`
+	EndPage   int    `json:"endPage,omitempty"`
+	GCSUri    string `json:"gcsUri,omitempty"`
+	SignedURL string `json:"signedUrl,omitempty"`
+}
+// RevisionDiffRequest is the input for the revision-differ function. Both revisions must
+// belong to TenantID. BaseDocumentID defaults to the document's reprocessOf link.
+type RevisionDiffRequest struct {
+	DocumentID     string `json:"documentId"`
+	BaseDocumentID string `json:"baseDocumentId,omitempty"`
+	TenantID       string `json:"tenantId,omitempty"`
+	ExecutionID    string `json:"executionId"`
+}
+
+// RevisionDiffResponse is the output of the revision-differ function.
+type RevisionDiffResponse struct {
+	Status  string              `json:"status"`
+	Summary RevisionDiffSummary `json:"summary"`
+}
+
+// ArtifactListRequest is the input for the document-status artifacts endpoint. When
+// TenantID is set, only the tenant's documents are found.
+type ArtifactListRequest struct {
+	DocumentID string `json:"documentId"`
+	TenantID   string `json:"tenantId,omitempty"`
+	Class      string `json:"class,omitempty"`
+	PageSize   int    `json:"pageSize,omitempty"`
+	PageToken  string `json:"pageToken,omitempty"`
+	Signed     bool   `json:"signed,omitempty"`
+}
+
+// ArtifactListResponse is the output of the document-status artifacts endpoint.
+type ArtifactListResponse struct {
+	DocumentID string          `json:"documentId"`
+	Groups     []ArtifactGroup `json:"groups"`
+}
+
+// ArtifactGroup is one page of objects of a single artifact class.
+type ArtifactGroup struct {
+	Class         string     `json:"class"`
+	Bucket        string     `json:"bucket"`
+	Artifacts     []Artifact `json:"artifacts"`
+	NextPageToken string     `json:"nextPageToken,omitempty"`
+	Error         string     `json:"error,omitempty"`
+}
+
+// Artifact describes a single object produced by the pipeline.
+type Artifact struct {
+	URI         string    `json:"uri"`
+	Class       string    `json:"class"`
+	Size        int64     `json:"size"`
+	Updated     time.Time `json:"updated"`
+	ContentType string    `json:"contentType,omitempty"`
+	SignedURL   string    `json:"signedUrl,omitempty"`
+}
+
+// ReprocessRequest is the input for the document-reprocessor function. Either DocumentID
+// or FileHash must be set; looking a document up by FileHash also requires Force.
+type ReprocessRequest struct {
+	DocumentID string `json:"documentId,omitempty"`
+	FileHash   string `json:"fileHash,omitempty"`
+	Force      bool   `json:"force,omitempty"`
+	// Purge deletes the document's derived markdown, master, diff, cleaned and section
+	// objects before the workflow is re-triggered, so every stage runs again.
+	Purge bool `json:"purge,omitempty"`
+}
+
+// ReprocessResponse is the output of the document-reprocessor function.
+type ReprocessResponse struct {
+	Status        string `json:"status"`
+	DocumentID    string `json:"documentId"`
+	ExecutionID   string `json:"executionId"`
+	PurgedObjects int    `json:"purgedObjects"`
+}
+
+// WorkflowRetriggerRequest is the input for the workflow-retrigger function. Either
+// DocumentID or Sweep must be set: Sweep retriggers every document whose workflow could
+// not be started, up to the configured limit.
+type WorkflowRetriggerRequest struct {
+	DocumentID string `json:"documentId,omitempty"`
+	Sweep      bool   `json:"sweep,omitempty"`
+}
+
+// WorkflowRetriggerResponse is the output of the workflow-retrigger function.
+type WorkflowRetriggerResponse struct {
+	Status    string             `json:"status"`
+	Triggered int                `json:"triggered"`
+	Failed    int                `json:"failed"`
+	Documents []RetriggerOutcome `json:"documents"`
+}
+
+// RetriggerOutcome is the result of retriggering one document. ExecutionID is set when a
+// workflow execution is running for it, and Error when none could be started.
+type RetriggerOutcome struct {
+	DocumentID  string `json:"documentId"`
+	ExecutionID string `json:"executionId,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// StuckSweepResponse is the outcome of one run of the stuck-document sweeper. Scanned
+// counts the in-progress documents read, and the other counts those acted on.
+type StuckSweepResponse struct {
+	Scanned     int                 `json:"scanned"`
+	Retriggered int                 `json:"retriggered"`
+	Stalled     int                 `json:"stalled"`
+	Cancelled   int                 `json:"cancelled"`
+	Failed      int                 `json:"failed"`
+	Documents   []StuckSweepOutcome `json:"documents"`
+}
+
+// StuckSweepOutcome is what the sweeper did with one stuck document, and why. Action is
+// "retrigger", "stall" or "cancel". ExecutionID is set when a workflow execution was
+// started or adopted for it, and Error when the action failed.
+type StuckSweepOutcome struct {
+	DocumentID  string `json:"documentId"`
+	Action      string `json:"action"`
+	Reason      string `json:"reason"`
+	ExecutionID string `json:"executionId,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// PageRetranslateRequest is the input for the page-retranslator function. Pages are
+// page numbers; in a document split into multi-page chunks, each selects its whole
+// chunk. Force translates pages again even when they have a complete translation;
+// without it only missing, incomplete and placeholder translations are redone.
+type PageRetranslateRequest struct {
+	DocumentID string `json:"documentId"`
+	Pages      []int  `json:"pages"`
+	Force      bool   `json:"force,omitempty"`
+}
+
+// PageRetranslateResponse is the output of the page-retranslator function. Status is
+// "success" when every page was translated and "partial" otherwise.
+type PageRetranslateResponse struct {
+	Status     string                  `json:"status"`
+	DocumentID string                  `json:"documentId"`
+	Pages      []PageRetranslateResult `json:"pages"`
+	// PurgedObjects counts the aggregated, cleaned and section objects deleted so that
+	// the later stages can be run again over the new translations.
+	PurgedObjects int `json:"purgedObjects"`
+}
+
+// PageRetranslateResult is the outcome of translating one page or chunk again.
+// Translation is set when the translator returned a result, and Error when it failed.
+type PageRetranslateResult struct {
+	StartPage   int                     `json:"startPage"`
+	EndPage     int                     `json:"endPage"`
+	Translation *PageTranslatorResponse `json:"translation,omitempty"`
+	Error       string                  `json:"error,omitempty"`
+}
+
+// DocumentStatusRequest is the input for the document-status progress endpoint. Either
+// DocumentID or FileHash must be set. When TenantID is set, only the tenant's documents
+// are found.
+type DocumentStatusRequest struct {
+	DocumentID   string `json:"documentId,omitempty"`
+	FileHash     string `json:"fileHash,omitempty"`
+	TenantID     string `json:"tenantId,omitempty"`
+	IncludePages bool   `json:"includePages,omitempty"`
+}
+
+// DocumentStatusResponse summarizes a document's progress through the pipeline.
+type DocumentStatusResponse struct {
+	DocumentID          string    `json:"documentId"`
+	TenantID            string    `json:"tenantId,omitempty"`
+	FileHash            string    `json:"fileHash,omitempty"`
+	OriginalFilename    string    `json:"originalFilename,omitempty"`
+	OriginalObjectPath  string    `json:"originalObjectPath,omitempty"`
+	FilenameSlug        string    `json:"filenameSlug,omitempty"`
+	Status              string    `json:"status"`
+	ErrorDetails        string    `json:"errorDetails,omitempty"`
+	PageCount           int       `json:"pageCount"`
+	ChunkSize           int       `json:"chunkSize,omitempty"`
+	WorkflowExecutionID string    `json:"workflowExecutionId,omitempty"`
+	CreatedAt           time.Time `json:"createdAt"`
+	UpdatedAt           time.Time `json:"updatedAt"`
+	// PageStatusCounts counts page records by page status. It is empty for documents split
+	// before page records were introduced.
+	PageStatusCounts map[string]int `json:"pageStatusCounts"`
+	// PagesCompleted is the number of pages that no longer need translating: translated,
+	// skipped, or soft-failed.
+	PagesCompleted int             `json:"pagesCompleted"`
+	Outputs        DocumentOutputs `json:"outputs"`
+	Pages          []Page          `json:"pages,omitempty"`
+	// Timings is how long each pipeline stage took, keyed by stage name.
+	Timings map[string]StageTiming `json:"timings,omitempty"`
+	// StatusHistory is every status the document has passed through, oldest first.
+	StatusHistory []StatusTransition `json:"statusHistory,omitempty"`
+}
+
+// DocumentOutputs are the locations the later pipeline stages write a document to. They
+// are derived from configuration; an output is only present once its stage has run.
+// With SIGN_OUTPUT_URLS enabled the signed URLs are time-limited links to them, and
+// SectionFiles lists every object under SectionsPrefix.
+type DocumentOutputs struct {
+	MasterGCSUri     string       `json:"masterGcsUri,omitempty"`
+	MasterSignedURL  string       `json:"masterSignedUrl,omitempty"`
+	CleanedGCSUri    string       `json:"cleanedGcsUri,omitempty"`
+	CleanedSignedURL string       `json:"cleanedSignedUrl,omitempty"`
+	SectionsPrefix   string       `json:"sectionsPrefix,omitempty"`
+	SectionFiles     []OutputLink `json:"sectionFiles,omitempty"`
+}
+
+// OutputLink is an output object's gs:// URI and, unless signing it failed, a
+// time-limited signed URL to it.
+type OutputLink struct {
+	GCSUri    string `json:"gcsUri"`
+	SignedURL string `json:"signedUrl,omitempty"`
+}
+
+// CompletionNotification is the JSON message published to the completion topic once a
+// document's sections have been saved.
+type CompletionNotification struct {
+	DocumentID       string    `json:"documentId"`
+	TenantID         string    `json:"tenantId,omitempty"`
+	FileHash         string    `json:"fileHash"`
+	OriginalFilename string    `json:"originalFilename"`
+	SectionCount     int       `json:"sectionCount"`
+	SectionsPrefix   string    `json:"sectionsPrefix"`
+	Status           string    `json:"status"`
+	CompletedAt      time.Time `json:"completedAt"`
+}
+
+// DocumentUploadResponse is the output of the document-uploader function. DocumentID is
+// the ID of the document the upload is processed as. Duplicate is set when the file was
+// uploaded before, in which case nothing was stored and DocumentID and Status are those
+// of the earlier upload's document.
+type DocumentUploadResponse struct {
+	DocumentID string `json:"documentId"`
+	Status     string `json:"status"`
+	Duplicate  bool   `json:"duplicate"`
+	GCSUri     string `json:"gcsUri,omitempty"`
+}
+
+// DocumentListRequest is the input for the document-admin listing endpoint. Every filter
+// is optional.
+type DocumentListRequest struct {
+	Status        string    `json:"status,omitempty"`
+	CreatedAfter  time.Time `json:"createdAfter,omitempty"`  // Exclusive.
+	CreatedBefore time.Time `json:"createdBefore,omitempty"` // Exclusive.
+	// FilenameContains matches original filenames case-insensitively. Firestore cannot
+	// search within a field, so documents are scanned and a page may hold fewer results
+	// than PageSize while NextPageToken is still set.
+	FilenameContains string `json:"filenameContains,omitempty"`
+	PageSize         int    `json:"pageSize,omitempty"`
+	PageToken        string `json:"pageToken,omitempty"`
+}
+
+// DocumentListResponse is one page of the document-admin listing endpoint.
+type DocumentListResponse struct {
+	Documents     []DocumentSummary `json:"documents"`
+	NextPageToken string            `json:"nextPageToken,omitempty"`
+}
+
+// DocumentCancelResponse is the response of the document-admin cancel endpoint.
+// CancelRequested is always true; Status is the document's status when it was cancelled,
+// which the stage that notices the request moves to CANCELLED. ExecutionStopped reports
+// whether the document's workflow execution was cancelled by this request.
+type DocumentCancelResponse struct {
+	DocumentID        string    `json:"documentId"`
+	Status            string    `json:"status"`
+	CancelRequested   bool      `json:"cancelRequested"`
+	CancelRequestedAt time.Time `json:"cancelRequestedAt"`
+	ExecutionStopped  bool      `json:"executionStopped"`
+}
+
+// DocumentSummary is a document's entry in a DocumentListResponse.
+type DocumentSummary struct {
+	DocumentID       string    `json:"documentId"`
+	Status           string    `json:"status"`
+	PageCount        int       `json:"pageCount,omitempty"`
+	CreatedAt        time.Time `json:"createdAt"`
+	OriginalFilename string    `json:"originalFilename,omitempty"`
+	ErrorDetails     string    `json:"errorDetails,omitempty"`
+}
+
+// DocumentEstimateRequest is the input for the document-status estimate endpoint, which
+// estimates what translating every page of a document would cost. When TenantID is set,
+// only the tenant's documents are found.
+type DocumentEstimateRequest struct {
+	DocumentID string `json:"documentId"`
+	TenantID   string `json:"tenantId,omitempty"`
+}
+
+// DocumentEstimateResponse is the estimate of translating a document, page by page and
+// in total.
+type DocumentEstimateResponse struct {
+	DocumentID string         `json:"documentId"`
+	PageCount  int            `json:"pageCount"`
+	Total      CostEstimate   `json:"total"`
+	Pages      []PageEstimate `json:"pages"`
+}
+
+// PageEstimate is the estimate of translating one split file of a document.
+type PageEstimate struct {
+	StartPage int          `json:"startPage"`
+	EndPage   int          `json:"endPage"`
+	GCSUri    string       `json:"gcsUri"`
+	Estimate  CostEstimate `json:"estimate"`
+}
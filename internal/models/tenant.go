@@ -0,0 +1,121 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// TenantConfig is the per-tenant configuration stored in the `tenants` Firestore collection.
+// The document ID is the tenant ID.
+type TenantConfig struct {
+	TenantID                 string    `firestore:"-" json:"tenantId"`
+	DisplayName              string    `firestore:"displayName,omitempty" json:"displayName,omitempty"`
+	UploadsBucket            string    `firestore:"uploadsBucket" json:"uploadsBucket"`
+	SplitPagesBucket         string    `firestore:"splitPagesBucket" json:"splitPagesBucket"`
+	TranslatedMarkdownBucket string    `firestore:"translatedMarkdownBucket" json:"translatedMarkdownBucket"`
+	AggregatedMarkdownBucket string    `firestore:"aggregatedMarkdownBucket" json:"aggregatedMarkdownBucket"`
+	CleanedMarkdownBucket    string    `firestore:"cleanedMarkdownBucket" json:"cleanedMarkdownBucket"`
+	FinalSectionsBucket      string    `firestore:"finalSectionsBucket" json:"finalSectionsBucket"`
+	CollectionName           string    `firestore:"collectionName" json:"collectionName"`
+	MonthlyTokenBudget       int64     `firestore:"monthlyTokenBudget,omitempty" json:"monthlyTokenBudget,omitempty"`
+	Priority                 int       `firestore:"priority,omitempty" json:"priority,omitempty"`
+	UpdatedAt                time.Time `firestore:"updatedAt,omitempty" json:"updatedAt,omitempty"`
+}
+
+// Limits enforced by TenantConfig.Validate.
+const (
+	MaxTenantTokenBudget = 10_000_000_000
+	MinTenantPriority    = 0
+	MaxTenantPriority    = 10
+)
+
+var (
+	tenantIDRegex       = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+	bucketNameRegex     = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*[a-z0-9]$`)
+	collectionNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,100}$`)
+	ipAddressRegex      = regexp.MustCompile(`^\d{1,3}(\.\d{1,3}){3}$`)
+)
+
+// ValidTenantID reports whether id is a well-formed tenant ID: 2-63 lowercase letters,
+// digits or hyphens, starting with a letter or digit. Such an ID is safe as the first
+// segment of an object name.
+func ValidTenantID(id string) bool {
+	return tenantIDRegex.MatchString(id)
+}
+
+// Buckets returns every bucket referenced by the config, keyed by its field name.
+func (t *TenantConfig) Buckets() map[string]string {
+	return map[string]string{
+		"uploadsBucket":            t.UploadsBucket,
+		"splitPagesBucket":         t.SplitPagesBucket,
+		"translatedMarkdownBucket": t.TranslatedMarkdownBucket,
+		"aggregatedMarkdownBucket": t.AggregatedMarkdownBucket,
+		"cleanedMarkdownBucket":    t.CleanedMarkdownBucket,
+		"finalSectionsBucket":      t.FinalSectionsBucket,
+	}
+}
+
+// Validate checks the config for structural problems. It returns every problem found,
+// joined into a single error, so an admin can fix them in one pass.
+func (t *TenantConfig) Validate() error {
+	var problems []string
+
+	if !ValidTenantID(t.TenantID) {
+		problems = append(problems, fmt.Sprintf("tenantId %q must be 2-63 lowercase letters, digits or hyphens", t.TenantID))
+	}
+	for field, bucket := range t.Buckets() {
+		if err := validateBucketName(bucket); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", field, err))
+		}
+	}
+	if !collectionNameRegex.MatchString(t.CollectionName) {
+		problems = append(problems, fmt.Sprintf("collectionName %q must be 1-100 letters, digits, underscores or hyphens", t.CollectionName))
+	}
+	if t.MonthlyTokenBudget < 0 || t.MonthlyTokenBudget > MaxTenantTokenBudget {
+		problems = append(problems, fmt.Sprintf("monthlyTokenBudget %d must be between 0 and %d", t.MonthlyTokenBudget, int64(MaxTenantTokenBudget)))
+	}
+	if t.Priority < MinTenantPriority || t.Priority > MaxTenantPriority {
+		problems = append(problems, fmt.Sprintf("priority %d must be between %d and %d", t.Priority, MinTenantPriority, MaxTenantPriority))
+	}
+
+	if len(problems) > 0 {
+		// Sort for a stable message; bucket problems come from map iteration.
+		sort.Strings(problems)
+		return fmt.Errorf("invalid tenant config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validateBucketName applies the Cloud Storage bucket naming rules.
+func validateBucketName(name string) error {
+	if name == "" {
+		return fmt.Errorf("bucket name must be set")
+	}
+	maxLen := 63
+	if strings.Contains(name, ".") {
+		maxLen = 222
+	}
+	if len(name) < 3 || len(name) > maxLen {
+		return fmt.Errorf("bucket name %q must be between 3 and %d characters", name, maxLen)
+	}
+	if !bucketNameRegex.MatchString(name) {
+		return fmt.Errorf("bucket name %q may only contain lowercase letters, digits, '-', '_' and '.', and must start and end with a letter or digit", name)
+	}
+	for _, component := range strings.Split(name, ".") {
+		if component == "" || len(component) > 63 {
+			return fmt.Errorf("bucket name %q has an empty or over-long dot-separated component", name)
+		}
+	}
+	if strings.HasPrefix(name, "goog") || strings.Contains(name, "google") {
+		return fmt.Errorf("bucket name %q may not begin with 'goog' or contain 'google'", name)
+	}
+	if ipAddressRegex.MatchString(name) {
+		return fmt.Errorf("bucket name %q may not be an IP address", name)
+	}
+	return nil
+}
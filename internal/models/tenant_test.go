@@ -0,0 +1,75 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func validTenantConfig() TenantConfig {
+	return TenantConfig{
+		TenantID:                 "unit-a",
+		UploadsBucket:            "unit-a-uploads",
+		SplitPagesBucket:         "unit-a-split-pages",
+		TranslatedMarkdownBucket: "unit-a-translated",
+		AggregatedMarkdownBucket: "unit-a-aggregated",
+		CleanedMarkdownBucket:    "unit-a-cleaned",
+		FinalSectionsBucket:      "unit-a-sections",
+		CollectionName:           "documents",
+		MonthlyTokenBudget:       1_000_000,
+		Priority:                 5,
+	}
+}
+
+func TestTenantConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*TenantConfig)
+		wantErr []string
+	}{
+		{name: "valid", mutate: func(*TenantConfig) {}},
+		{
+			name:    "bad tenant ID",
+			mutate:  func(c *TenantConfig) { c.TenantID = "Unit_A" },
+			wantErr: []string{"tenantId"},
+		},
+		{
+			name:    "bad bucket",
+			mutate:  func(c *TenantConfig) { c.CleanedMarkdownBucket = "-cleaned" },
+			wantErr: []string{"cleanedMarkdownBucket"},
+		},
+		{
+			name:    "missing collection",
+			mutate:  func(c *TenantConfig) { c.CollectionName = "" },
+			wantErr: []string{"collectionName"},
+		},
+		{
+			name: "every problem is reported",
+			mutate: func(c *TenantConfig) {
+				c.MonthlyTokenBudget = -1
+				c.Priority = MaxTenantPriority + 1
+			},
+			wantErr: []string{"monthlyTokenBudget", "priority"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := validTenantConfig()
+			tt.mutate(&config)
+			err := config.Validate()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Validate() = nil, want an error")
+			}
+			for _, field := range tt.wantErr {
+				if !strings.Contains(err.Error(), field) {
+					t.Errorf("Validate() = %v, want it to mention %s", err, field)
+				}
+			}
+		})
+	}
+}
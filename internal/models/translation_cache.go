@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// TranslationCacheEntry records a translation of a page PDF that the translator can reuse
+// for identical pages of other documents. An entry is keyed by the SHA-256 of the page
+// bytes together with everything else that shapes the translation: the model, the prompt
+// profile and the language hints. PromptVersion is a hash of the prompts the page was
+// translated with. Entries are only reused within the tenant of the document they were
+// made for, whose objects the markdown is stored among.
+type TranslationCacheEntry struct {
+	PageHash       string `firestore:"pageHash"`
+	Model          string `firestore:"model"`
+	PromptProfile  string `firestore:"promptProfile"`
+	PromptVersion  string `firestore:"promptVersion,omitempty"`
+	SourceLanguage string `firestore:"sourceLanguage,omitempty"`
+	TargetLanguage string `firestore:"targetLanguage,omitempty"`
+	TenantID       string `firestore:"tenantId,omitempty"`
+	// MarkdownGCSUri is the translated markdown of the document the entry was made for,
+	// which is copied to the output of each document that reuses it.
+	MarkdownGCSUri string `firestore:"markdownGcsUri"`
+	DocumentID     string `firestore:"documentId"`
+	// Usage is the token count the translation cost, which each reuse saves.
+	Usage     *TokenUsage `firestore:"usage,omitempty"`
+	CreatedAt time.Time   `firestore:"createdAt"`
+}
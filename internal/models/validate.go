@@ -0,0 +1,104 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// requireFields returns an error naming the required JSON fields that are empty.
+func requireFields(fields ...[2]string) error {
+	var missing []string
+	for _, field := range fields {
+		if strings.TrimSpace(field[1]) == "" {
+			missing = append(missing, field[0])
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required field(s): %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// MaxLanguageLength bounds a language hint, which is written into model prompts.
+const MaxLanguageLength = 64
+
+// ValidateLanguage checks a language hint such as "Vietnamese" or "de". An empty hint is
+// valid; a hint must otherwise fit on one line within MaxLanguageLength bytes.
+func ValidateLanguage(field, value string) error {
+	if len(value) > MaxLanguageLength {
+		return fmt.Errorf("%s must be at most %d bytes", field, MaxLanguageLength)
+	}
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return fmt.Errorf("%s must not contain control characters", field)
+	}
+	return nil
+}
+
+// Validate checks the request's required fields.
+func (r *PageTranslatorRequest) Validate() error {
+	if err := requireFields([2]string{"documentId", r.DocumentID}, [2]string{"gcsUri", r.GCSUri}); err != nil {
+		return err
+	}
+	if r.PageRange == nil && r.PageNumber < 1 {
+		return errors.New("pageNumber must be at least 1 when pageRange is not set")
+	}
+	if err := ValidateProcessingRegion(r.ProcessingRegion); err != nil {
+		return err
+	}
+	if err := ValidateRedactionMode(r.RedactionMode); err != nil {
+		return err
+	}
+	return validateLanguages(r.SourceLanguage, r.TargetLanguage)
+}
+
+// processingRegionRegex matches a Google Cloud region name, such as "europe-west4".
+var processingRegionRegex = regexp.MustCompile(`^[a-z]+(?:-[a-z]+)+[0-9]+$`)
+
+// ValidateProcessingRegion checks a processingRegion. An empty region is valid.
+func ValidateProcessingRegion(region string) error {
+	if region != "" && !processingRegionRegex.MatchString(region) {
+		return fmt.Errorf("processingRegion %q is not a region name such as \"europe-west4\"", region)
+	}
+	return nil
+}
+
+// ValidateRedactionMode checks a redactionMode. An empty mode is valid.
+func ValidateRedactionMode(mode string) error {
+	switch mode {
+	case "", RedactionModeNone, RedactionModeDLP:
+		return nil
+	default:
+		return fmt.Errorf("redactionMode must be %q or %q, got %q", RedactionModeNone, RedactionModeDLP, mode)
+	}
+}
+
+// validateLanguages checks a request's sourceLanguage and targetLanguage hints.
+func validateLanguages(source, target string) error {
+	if err := ValidateLanguage("sourceLanguage", source); err != nil {
+		return err
+	}
+	return ValidateLanguage("targetLanguage", target)
+}
+
+// Validate checks the request's required fields.
+func (r *MarkdownAggregatorRequest) Validate() error {
+	return requireFields([2]string{"documentId", r.DocumentID})
+}
+
+// Validate checks the request's required fields.
+func (r *MarkdownCleanerRequest) Validate() error {
+	if err := requireFields([2]string{"documentId", r.DocumentID}, [2]string{"masterGcsUri", r.MasterGCSUri}); err != nil {
+		return err
+	}
+	return validateLanguages(r.SourceLanguage, r.TargetLanguage)
+}
+
+// Validate checks the request's required fields.
+func (r *SectionSplitterRequest) Validate() error {
+	return requireFields([2]string{"documentId", r.DocumentID}, [2]string{"cleanedGcsUri", r.CleanedGCSUri})
+}
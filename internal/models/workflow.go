@@ -0,0 +1,94 @@
+package models
+
+import (
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// WorkflowPayload is the argument of a processing workflow execution. It names the split
+// file of every page, so that the workflow never derives object names itself.
+type WorkflowPayload struct {
+	DocumentID string `json:"documentId"`
+	PageCount  int    `json:"pageCount"`
+	// TenantID is the document's tenant, which the workflow passes on each stage request.
+	TenantID string `json:"tenantId,omitempty"`
+	// ProcessingOrder is the suggested dispatch order, over 1-based page numbers, or over
+	// 1-based indices into Chunks when the document is chunked. Pages or chunks whose split
+	// file failed to upload are left out, so they are never dispatched.
+	ProcessingOrder []int `json:"processingOrder"`
+	// SourceGeneration is the generation of the upload the pages were split from, for the
+	// workflow's logs. It is 0 for documents split before it was recorded.
+	SourceGeneration int64 `json:"sourceGeneration,omitempty"`
+	// Traceparent is forwarded by the workflow on each stage request to continue the
+	// splitter's trace.
+	Traceparent string `json:"traceparent,omitempty"`
+	// ChunkSize and Chunks are set when pages are split in multi-page chunks, which the
+	// workflow dispatches instead of pages.
+	ChunkSize int             `json:"chunkSize,omitempty"`
+	Chunks    []WorkflowChunk `json:"chunks,omitempty"`
+	// Pages lists the split file of every page. A document with more pages than the
+	// workflow argument can carry has ManifestGCSUri instead, the URI of its PageManifest.
+	Pages          []PageURI `json:"pages,omitempty"`
+	ManifestGCSUri string    `json:"manifestGcsUri,omitempty"`
+	// SplitMode and Chapters are set when the document is split by bookmark: the
+	// workflow then dispatches chapters, as it does chunks, and passes each translated
+	// chapter to the section splitter. ProcessingOrder is over 1-based indices into
+	// Chapters.
+	SplitMode string    `json:"splitMode,omitempty"`
+	Chapters  []Chapter `json:"chapters,omitempty"`
+}
+
+// Split modes, as set by SPLIT_MODE. The page mode splits a document into files of
+// CHUNK_SIZE pages; the bookmark mode splits it into a file per top-level entry of its
+// outline.
+const (
+	SplitModePage     = "page"
+	SplitModeBookmark = "bookmark"
+)
+
+// Chapter is a top-level outline entry of a document split by bookmark and its split
+// file. Pages before the first entry, such as a cover, belong to the first chapter.
+type Chapter struct {
+	Index     int    `firestore:"index" json:"index"` // 1-based, in page order
+	Title     string `firestore:"title" json:"title"`
+	StartPage int    `firestore:"pageStart" json:"pageStart"`
+	EndPage   int    `firestore:"pageEnd" json:"pageEnd"`
+	GCSUri    string `firestore:"gcsUri" json:"gcsUri"`
+}
+
+// WorkflowChunk is a multi-page chunk of a WorkflowPayload and its split file.
+type WorkflowChunk struct {
+	PageRange PageRange `json:"pageRange"`
+	GCSUri    string    `json:"gcsUri"`
+}
+
+// PageURI names the split file holding a page: its own file, or that of its chunk.
+type PageURI struct {
+	PageNumber int    `json:"pageNumber"`
+	GCSUri     string `json:"gcsUri"`
+}
+
+// PageManifest lists the split file of every page of a document, in page order. Documents
+// too long to list in the workflow argument have it stored as {docId}/manifest.json in the
+// split pages bucket.
+type PageManifest struct {
+	DocumentID string    `json:"documentId"`
+	TenantID   string    `json:"tenantId,omitempty"`
+	PageCount  int       `json:"pageCount"`
+	ChunkSize  int       `json:"chunkSize,omitempty"`
+	Pages      []PageURI `json:"pages"`
+}
+
+// GCSUriFor returns the URI of the split file holding pageNumber, or "" if the manifest
+// does not list the page.
+func (m *PageManifest) GCSUriFor(pageNumber int) string {
+	// Pages are listed in order from 1, so a page is normally found at its own index.
+	if i := pageNumber - 1; i >= 0 && i < len(m.Pages) && m.Pages[i].PageNumber == pageNumber {
+		return m.Pages[i].GCSUri
+	}
+	for _, page := range m.Pages {
+		if page.PageNumber == pageNumber {
+			return page.GCSUri
+		}
+	}
+	return ""
+}
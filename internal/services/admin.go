@@ -0,0 +1,302 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	executions "cloud.google.com/go/workflows/executions/apiv1"
+	"cloud.google.com/go/workflows/executions/apiv1/executionspb"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+var (
+	// ErrInvalidDocumentQuery is returned when a document listing has invalid parameters,
+	// or combines filters that Firestore cannot serve without a missing index.
+	ErrInvalidDocumentQuery = errors.New("invalid document query")
+	// ErrDocumentNotCancellable is returned when cancelling a document that is COMPLETE.
+	ErrDocumentNotCancellable = errors.New("document cannot be cancelled")
+)
+
+const (
+	defaultDocumentPageSize = 50
+	maxDocumentPageSize     = 500
+	// maxFilenameScan caps the documents read for one page of a filename search.
+	maxFilenameScan = 2000
+)
+
+// DocumentAdminConfig holds configuration for the document-admin service.
+type DocumentAdminConfig struct {
+	ProjectID      string
+	CollectionName string
+	// StatusCreatedAtIndex is set once the composite index on status and createdAt
+	// exists, which filtering by status and creation time together needs.
+	StatusCreatedAtIndex bool
+}
+
+// DocumentAdminFunction holds dependencies for the document listing endpoint.
+type DocumentAdminFunction struct {
+	serviceClients
+	firestoreClient  *firestore.Client
+	executionsClient *executions.Client
+	config           DocumentAdminConfig
+}
+
+// NewDocumentAdmin creates a new DocumentAdminFunction instance.
+func NewDocumentAdmin(ctx context.Context) (*DocumentAdminFunction, error) {
+	projectID := gcp.GetEnv("PROJECT_ID", "")
+	if projectID == "" {
+		return nil, fmt.Errorf("GCP_PROJECT environment variable must be set")
+	}
+	statusCreatedAtIndex, err := strconv.ParseBool(gcp.GetEnv("DOCUMENT_STATUS_CREATED_AT_INDEX", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("DOCUMENT_STATUS_CREATED_AT_INDEX must be a boolean")
+	}
+
+	config := DocumentAdminConfig{
+		ProjectID:            projectID,
+		CollectionName:       gcp.GetEnv("FIRESTORE_COLLECTION", "documents"),
+		StatusCreatedAtIndex: statusCreatedAtIndex,
+	}
+
+	firestoreClient, err := gcp.NewFirestoreClient(ctx, config.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firestore client: %w", err)
+	}
+	executionsClient, err := executions.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Workflows Executions client: %w", err)
+	}
+
+	return &DocumentAdminFunction{
+		serviceClients:   serviceClients{firestoreClient, executionsClient},
+		firestoreClient:  firestoreClient,
+		executionsClient: executionsClient,
+		config:           config,
+	}, nil
+}
+
+// documentCursor is the position a page token resumes after: the last document returned
+// or scanned, and its creation time for listings ordered by it.
+type documentCursor struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+}
+
+// token encodes the cursor as a page token.
+func (c documentCursor) token() string {
+	data, _ := json.Marshal(c) // A string and a time always encode.
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeDocumentCursor(token string) (*documentCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed pageToken", ErrInvalidDocumentQuery)
+	}
+	var cursor documentCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" {
+		return nil, fmt.Errorf("%w: malformed pageToken", ErrInvalidDocumentQuery)
+	}
+	return &cursor, nil
+}
+
+// documentQuery builds the Firestore query for a listing, and reports whether it is
+// ordered by creation time, newest first, rather than by document ID. Every query it
+// builds is served by Firestore's automatic single-field indexes, except a status filter
+// combined with a creation time range, which is refused unless StatusCreatedAtIndex says
+// its composite index exists.
+func (f *DocumentAdminFunction) documentQuery(req *models.DocumentListRequest) (firestore.Query, bool, error) {
+	query := f.firestoreClient.Collection(f.config.CollectionName).Query
+	if !req.CreatedAfter.IsZero() && !req.CreatedBefore.IsZero() && !req.CreatedAfter.Before(req.CreatedBefore) {
+		return query, false, fmt.Errorf("%w: createdAfter must be before createdBefore", ErrInvalidDocumentQuery)
+	}
+	dateRange := !req.CreatedAfter.IsZero() || !req.CreatedBefore.IsZero()
+	if req.Status != "" && dateRange && !f.config.StatusCreatedAtIndex {
+		return query, false, fmt.Errorf("%w: filtering by status and creation time together needs a composite index on collection %s with fields status (ascending) and createdAt (descending); create it with `gcloud firestore indexes composite create --collection-group=%s --field-config=field-path=status,order=ascending --field-config=field-path=createdAt,order=descending` and set DOCUMENT_STATUS_CREATED_AT_INDEX=true, or drop one of the filters",
+			ErrInvalidDocumentQuery, f.config.CollectionName, f.config.CollectionName)
+	}
+
+	if req.Status != "" {
+		query = query.Where("status", "==", req.Status)
+	}
+	if !req.CreatedAfter.IsZero() {
+		query = query.Where("createdAt", ">", req.CreatedAfter)
+	}
+	if !req.CreatedBefore.IsZero() {
+		query = query.Where("createdAt", "<", req.CreatedBefore)
+	}
+	// A status filter alone is ordered by document ID, as ordering it by creation time
+	// would need the composite index too.
+	byCreatedAt := req.Status == "" || dateRange
+	if byCreatedAt {
+		query = query.OrderBy("createdAt", firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc)
+	} else {
+		query = query.OrderBy(firestore.DocumentID, firestore.Asc)
+	}
+
+	if req.PageToken != "" {
+		cursor, err := decodeDocumentCursor(req.PageToken)
+		if err != nil {
+			return query, false, err
+		}
+		if byCreatedAt {
+			query = query.StartAfter(cursor.CreatedAt, cursor.ID)
+		} else {
+			query = query.StartAfter(cursor.ID)
+		}
+	}
+	return query, byCreatedAt, nil
+}
+
+// ListDocuments returns one page of the documents matching the request's filters.
+func (f *DocumentAdminFunction) ListDocuments(ctx context.Context, req *models.DocumentListRequest) (*models.DocumentListResponse, error) {
+	logCtx := slog.With("status", req.Status, "filenameContains", req.FilenameContains)
+	query, byCreatedAt, err := f.documentQuery(req)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := req.PageSize
+	if pageSize <= 0 || pageSize > maxDocumentPageSize {
+		pageSize = defaultDocumentPageSize
+	}
+	filename := strings.ToLower(req.FilenameContains)
+	// One document past the page tells whether there is a next page. A filename search
+	// reads up to maxFilenameScan documents and resumes after the last of them.
+	scanLimit := pageSize + 1
+	if filename != "" {
+		scanLimit = maxFilenameScan
+	}
+
+	res := &models.DocumentListResponse{Documents: []models.DocumentSummary{}}
+	iter := query.Limit(scanLimit).Documents(ctx)
+	defer iter.Stop()
+	var scanned int
+	// Page tokens resuming after the last document scanned and the last one returned.
+	var afterScanned, afterReturned string
+	for {
+		snap, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			if scanned == scanLimit {
+				res.NextPageToken = afterScanned
+			}
+			break
+		}
+		if err != nil {
+			logCtx.Error("Failed to list documents", "error", err)
+			return nil, fmt.Errorf("failed to list documents: %w", err)
+		}
+		var doc models.Document
+		if err := snap.DataTo(&doc); err != nil {
+			logCtx.Error("Failed to decode document", "error", err, "documentId", snap.Ref.ID)
+			return nil, fmt.Errorf("failed to decode document %s: %w", snap.Ref.ID, err)
+		}
+		scanned++
+		cursor := documentCursor{ID: snap.Ref.ID}
+		if byCreatedAt {
+			cursor.CreatedAt = doc.CreatedAt
+		}
+		afterScanned = cursor.token()
+		if filename != "" && !strings.Contains(strings.ToLower(doc.OriginalFilename), filename) {
+			continue
+		}
+		if len(res.Documents) == pageSize {
+			res.NextPageToken = afterReturned
+			break
+		}
+		res.Documents = append(res.Documents, models.DocumentSummary{
+			DocumentID:       snap.Ref.ID,
+			Status:           doc.Status,
+			PageCount:        doc.PageCount,
+			CreatedAt:        doc.CreatedAt,
+			OriginalFilename: doc.OriginalFilename,
+			ErrorDetails:     doc.ErrorDetails,
+		})
+		afterReturned = afterScanned
+	}
+	logCtx.Info("Listed documents.", "count", len(res.Documents), "scanned", scanned, "morePages", res.NextPageToken != "")
+	return res, nil
+}
+
+// CancelDocument requests the cancellation of a document being processed. It sets
+// cancelRequested, which each stage checks before starting work, and the first to notice
+// it stops and moves the document to CANCELLED, so that no further Gemini calls are made.
+// It then cancels the document's workflow execution, so that the workflow does not call
+// the next stage once the current one returns. Cancelling a document again is not an
+// error, and tries the execution again, while a COMPLETE document gives
+// ErrDocumentNotCancellable.
+func (f *DocumentAdminFunction) CancelDocument(ctx context.Context, docID string) (*models.DocumentCancelResponse, error) {
+	logCtx := slog.With("documentId", docID)
+	docRef := f.firestoreClient.Collection(f.config.CollectionName).Doc(docID)
+	var res *models.DocumentCancelResponse
+	var executionID string
+	err := f.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+		var doc models.Document
+		if err := snap.DataTo(&doc); err != nil {
+			return fmt.Errorf("failed to decode document: %w", err)
+		}
+		res = &models.DocumentCancelResponse{DocumentID: docID, Status: doc.Status, CancelRequested: true, CancelRequestedAt: doc.CancelRequestedAt}
+		executionID = doc.WorkflowExecutionID
+		switch {
+		case doc.CancelRequested:
+			return nil
+		case doc.Status == StatusComplete:
+			return fmt.Errorf("%w: %s is %s", ErrDocumentNotCancellable, docID, doc.Status)
+		}
+		res.CancelRequestedAt = time.Now()
+		return tx.Update(docRef, []firestore.Update{
+			{Path: "cancelRequested", Value: true},
+			{Path: "cancelRequestedAt", Value: res.CancelRequestedAt},
+		})
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, docID)
+	}
+	if errors.Is(err, ErrDocumentNotCancellable) {
+		return nil, err
+	}
+	if err != nil {
+		logCtx.Error("Failed to request cancellation", "error", err)
+		return nil, fmt.Errorf("failed to request cancellation: %w", err)
+	}
+	res.ExecutionStopped = f.stopExecution(ctx, logCtx, executionID)
+	logCtx.Info("Cancellation requested.", "status", res.Status, "cancelRequestedAt", res.CancelRequestedAt, "executionStopped", res.ExecutionStopped)
+	return res, nil
+}
+
+// stopExecution cancels the workflow execution recorded on a cancelled document and
+// reports whether it did. Pub/Sub runs record a run ID in place of an execution name and
+// have no execution to cancel; the coordinator ignores their later completions instead.
+// An execution that has already finished is left alone, and a failure is only logged, as
+// the stages stop on their own once they notice the cancellation.
+func (f *DocumentAdminFunction) stopExecution(ctx context.Context, logCtx *slog.Logger, executionID string) bool {
+	if f.executionsClient == nil || !strings.HasPrefix(executionID, "projects/") {
+		return false
+	}
+	_, err := f.executionsClient.CancelExecution(ctx, &executionspb.CancelExecutionRequest{Name: executionID})
+	switch status.Code(err) {
+	case codes.OK:
+		return true
+	case codes.NotFound, codes.FailedPrecondition:
+		logCtx.Info("Workflow execution already finished; nothing to stop.", "executionId", executionID)
+	default:
+		logCtx.Warn("Failed to cancel the workflow execution; the stages stop on their own", "error", err, "executionId", executionID)
+	}
+	return false
+}
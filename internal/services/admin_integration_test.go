@@ -0,0 +1,97 @@
+//go:build integration
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+// listAll follows the page tokens of a listing to its end, returning the IDs of every
+// page in turn.
+func listAll(ctx context.Context, t *testing.T, f *DocumentAdminFunction, req models.DocumentListRequest) [][]string {
+	t.Helper()
+	var pages [][]string
+	for {
+		res, err := f.ListDocuments(ctx, &req)
+		if err != nil {
+			t.Fatalf("ListDocuments(%+v) error = %v", req, err)
+		}
+		var ids []string
+		for _, doc := range res.Documents {
+			ids = append(ids, doc.DocumentID)
+		}
+		pages = append(pages, ids)
+		if res.NextPageToken == "" {
+			return pages
+		}
+		if len(pages) > 10 {
+			t.Fatalf("ListDocuments() pages = %v, want the listing to end", pages)
+		}
+		req.PageToken = res.NextPageToken
+	}
+}
+
+func TestListDocumentsPagination(t *testing.T) {
+	ctx := context.Background()
+	firestoreClient, _ := testsupport.RequireEmulators(t).Clients(ctx, t)
+	collection := fmt.Sprintf("documents-%d", time.Now().UnixNano())
+	created := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	docs := []struct {
+		id, status, filename string
+		createdAt            time.Time
+	}{
+		{"doc-a", StatusComplete, "Pump manual.pdf", created},
+		{"doc-b", "TRANSLATING", "valve.pdf", created.Add(time.Hour)},
+		{"doc-c", StatusComplete, "pump-spares.pdf", created.Add(2 * time.Hour)},
+		// Two documents created at the same instant are told apart by their ID.
+		{"doc-d", StatusComplete, "gearbox.pdf", created.Add(3 * time.Hour)},
+		{"doc-e", "TRANSLATING", "PUMP curve.pdf", created.Add(3 * time.Hour)},
+	}
+	for _, doc := range docs {
+		if _, err := firestoreClient.Collection(collection).Doc(doc.id).Set(ctx, map[string]interface{}{
+			"status": doc.status, "originalFilename": doc.filename, "createdAt": doc.createdAt,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := &DocumentAdminFunction{firestoreClient: firestoreClient, config: DocumentAdminConfig{CollectionName: collection}}
+
+	tests := []struct {
+		name string
+		req  models.DocumentListRequest
+		want [][]string
+	}{
+		{name: "newest first", req: models.DocumentListRequest{PageSize: 2}, want: [][]string{{"doc-e", "doc-d"}, {"doc-c", "doc-b"}, {"doc-a"}}},
+		{name: "exact pages", req: models.DocumentListRequest{PageSize: 5}, want: [][]string{{"doc-e", "doc-d", "doc-c", "doc-b", "doc-a"}}},
+		{name: "status by ID", req: models.DocumentListRequest{Status: StatusComplete, PageSize: 2}, want: [][]string{{"doc-a", "doc-c"}, {"doc-d"}}},
+		{
+			name: "created range",
+			req:  models.DocumentListRequest{CreatedAfter: created, CreatedBefore: created.Add(3 * time.Hour), PageSize: 1},
+			want: [][]string{{"doc-c"}, {"doc-b"}},
+		},
+		{name: "filename", req: models.DocumentListRequest{FilenameContains: "pump", PageSize: 2}, want: [][]string{{"doc-e", "doc-c"}, {"doc-a"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := listAll(ctx, t, f, tt.req)
+			if !slices.EqualFunc(got, tt.want, slices.Equal[[]string]) {
+				t.Errorf("ListDocuments() pages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := f.ListDocuments(ctx, &models.DocumentListRequest{PageToken: "not a token"}); !errors.Is(err, ErrInvalidDocumentQuery) {
+		t.Errorf("ListDocuments() of a malformed token = %v, want ErrInvalidDocumentQuery", err)
+	}
+	if _, err := f.ListDocuments(ctx, &models.DocumentListRequest{Status: StatusComplete, CreatedAfter: created}); !errors.Is(err, ErrInvalidDocumentQuery) {
+		t.Errorf("ListDocuments() by status and creation time without the index = %v, want ErrInvalidDocumentQuery", err)
+	}
+}
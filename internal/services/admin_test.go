@@ -0,0 +1,27 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDocumentCursorToken(t *testing.T) {
+	for _, cursor := range []documentCursor{
+		{ID: "doc-1"},
+		{ID: "doc-2", CreatedAt: time.Date(2026, 3, 1, 12, 30, 0, 123456789, time.UTC)},
+	} {
+		got, err := decodeDocumentCursor(cursor.token())
+		if err != nil {
+			t.Fatalf("decodeDocumentCursor(%q) error = %v", cursor.token(), err)
+		}
+		if got.ID != cursor.ID || !got.CreatedAt.Equal(cursor.CreatedAt) {
+			t.Errorf("decodeDocumentCursor() = %+v, want %+v", *got, cursor)
+		}
+	}
+	for _, token := range []string{"not base64!", "bm90IGpzb24", "e30", documentCursor{}.token()} {
+		if _, err := decodeDocumentCursor(token); !errors.Is(err, ErrInvalidDocumentQuery) {
+			t.Errorf("decodeDocumentCursor(%q) error = %v, want ErrInvalidDocumentQuery", token, err)
+		}
+	}
+}
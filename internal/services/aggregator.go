@@ -1,35 +1,107 @@
 package services
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	"sort"
+	"slices"
+	"strconv"
 	"strings"
 
+	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/storage"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/markdown"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
-	"google.golang.org/api/iterator"
+	"golang.org/x/sync/errgroup"
 		_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
 )
 
+// pageSeparator is written between consecutive page files in master.md when page
+// anchors are disabled.
+const pageSeparator = "\n\n---\n\n"
+
 // AggregatorConfig holds configuration for the aggregator service.
 type AggregatorConfig struct {
 	ProjectID                string
 	TranslatedMarkdownBucket string
 	AggregatedMarkdownBucket string
+	CollectionName           string
+	Prefetch                 int   // Number of page objects read ahead of the writer.
+	PrefetchMaxBytes         int64 // Larger pages are streamed instead of buffered.
+	// PageAnchors precedes each page with a "<!-- page: N -->" anchor instead of
+	// separating pages with pageSeparator.
+	PageAnchors bool
+	// NormalizePages tidies each page with markdown.NormalizePage before it is written.
+	// Pages too large to prefetch are always written as they are.
+	NormalizePages bool
+	// OutputNames names the master file and finds the translated pages.
+	OutputNames *OutputNames
+	// Formats are the AggregationFormat values written, always including markdown.
+	Formats []string
 }
 
 // AggregatorFunction holds dependencies for the aggregation logic.
 type AggregatorFunction struct {
-	storageClient *storage.Client
-	config        AggregatorConfig
+	serviceClients
+	store           objectstore.Client
+	firestoreClient *firestore.Client
+	cancellation    *CancellationChecker
+	tenants         *DocumentTenants
+	reporter        *stageReporter // Nil unless ORCHESTRATION_MODE is pubsub.
+	config          AggregatorConfig
 }
 
 // NewAggregator creates a new AggregatorFunction instance.
 func NewAggregator(ctx context.Context) (*AggregatorFunction, error) {
+	config, err := loadAggregatorConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %w", err)
+	}
+
+	if err := gcp.InitTracing(ctx, config.ProjectID); err != nil {
+		return nil, err
+	}
+	if err := gcp.InitMetrics(ctx, config.ProjectID); err != nil {
+		return nil, err
+	}
+
+	firestoreClient, err := gcp.NewFirestoreClient(ctx, config.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firestore client: %w", err)
+	}
+	reporter, err := newStageReporter(ctx, config.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	cancelCheckTTL, err := loadCancelCheckTTL()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AggregatorFunction{
+		serviceClients:  serviceClients{storageClient, firestoreClient}.withReporter(reporter),
+		store:           objectstore.NewGCS(storageClient),
+		firestoreClient: firestoreClient,
+		cancellation:    NewCancellationChecker(firestoreClient, config.CollectionName, cancelCheckTTL),
+		tenants:         NewDocumentTenants(firestoreClient, config.CollectionName),
+		reporter:        reporter,
+		config:          *config,
+	}, nil
+}
+
+// loadAggregatorConfig loads and validates the aggregator's environment variables.
+func loadAggregatorConfig() (*AggregatorConfig, error) {
 	projectID := gcp.GetEnv("PROJECT_ID", "")
 	if projectID == "" {
 		return nil, fmt.Errorf("GCP_PROJECT environment variable must be set")
@@ -39,98 +111,523 @@ func NewAggregator(ctx context.Context) (*AggregatorFunction, error) {
 		ProjectID:                projectID,
 		TranslatedMarkdownBucket: gcp.GetEnv("TRANSLATED_MARKDOWN_BUCKET", ""), // Source bucket
 		AggregatedMarkdownBucket: gcp.GetEnv("AGGREGATED_MARKDOWN_BUCKET", ""), // Destination bucket
+		CollectionName:           gcp.GetEnv("FIRESTORE_COLLECTION", "documents"),
 	}
 	if config.TranslatedMarkdownBucket == "" || config.AggregatedMarkdownBucket == "" {
 		return nil, fmt.Errorf("TRANSLATED_MARKDOWN_BUCKET and AGGREGATED_MARKDOWN_BUCKET must be set")
 	}
-
-	storageClient, err := storage.NewClient(ctx)
+	prefetch, err := strconv.Atoi(gcp.GetEnv("AGGREGATOR_PREFETCH", "8"))
+	if err != nil || prefetch < 1 {
+		return nil, fmt.Errorf("AGGREGATOR_PREFETCH must be a positive integer")
+	}
+	config.Prefetch = prefetch
+	prefetchMaxBytes, err := strconv.ParseInt(gcp.GetEnv("AGGREGATOR_PREFETCH_MAX_BYTES", "4194304"), 10, 64)
+	if err != nil || prefetchMaxBytes < 0 {
+		return nil, fmt.Errorf("AGGREGATOR_PREFETCH_MAX_BYTES must be a non-negative integer")
+	}
+	config.PrefetchMaxBytes = prefetchMaxBytes
+	pageAnchors, err := strconv.ParseBool(gcp.GetEnv("AGGREGATOR_PAGE_ANCHORS", "true"))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create storage client: %w", err)
+		return nil, fmt.Errorf("AGGREGATOR_PAGE_ANCHORS must be a boolean")
 	}
-
-	return &AggregatorFunction{
-		storageClient: storageClient,
-		config:        config,
-	}, nil
+	config.PageAnchors = pageAnchors
+	normalizePages, err := loadNormalizePages()
+	if err != nil {
+		return nil, err
+	}
+	config.NormalizePages = normalizePages
+	outputNames, err := loadOutputNames()
+	if err != nil {
+		return nil, err
+	}
+	config.OutputNames = outputNames
+	formats, err := loadAggregationFormats()
+	if err != nil {
+		return nil, err
+	}
+	config.Formats = formats
+	return &config, nil
 }
 
 // Process handles the core logic of aggregating Markdown files.
-func (f *AggregatorFunction) Process(ctx context.Context, req *models.MarkdownAggregatorRequest) (*models.MarkdownAggregatorResponse, error) {
+func (f *AggregatorFunction) Process(ctx context.Context, req *models.MarkdownAggregatorRequest) (res *models.MarkdownAggregatorResponse, err error) {
 	logCtx := slog.With("documentId", req.DocumentID, "executionId", req.ExecutionID)
+	ctx, span := startProcessSpan(ctx, "aggregator.Process", req.Traceparent, attrDocumentID.String(req.DocumentID))
+	defer func() { endProcessSpan(ctx, span, err) }()
+	// Checked first, so that a request naming the wrong tenant leaves the document as is.
+	if err := f.tenants.Check(ctx, req.DocumentID, req.TenantID); err != nil {
+		logCtx.Error("Invalid aggregation request", "error", err, "tenantId", req.TenantID)
+		return nil, err
+	}
+	if req.Incremental {
+		return f.preview(ctx, logCtx, req)
+	}
+	if err := checkExecution(ctx, logCtx, f.firestoreClient, f.config.CollectionName, req.DocumentID, req.ExecutionID); err != nil {
+		logCtx.Error("Refused aggregation request", "error", err)
+		return nil, err
+	}
+	timer := startStage(models.TimingStageAggregator)
+	defer func() {
+		status := ""
+		if res != nil {
+			status = res.Status
+		}
+		timing := timer.record(ctx, logCtx, f.firestoreClient, f.config.CollectionName, req.DocumentID, status, err)
+		if res != nil {
+			res.Timing = timing
+		}
+		if err == nil {
+			gcp.RecordAggregationDuration(ctx, timing.CompletedAt.Sub(timing.StartedAt))
+		}
+		if err != nil {
+			recordStageFailure(ctx, logCtx, f.firestoreClient, f.config.CollectionName, req.DocumentID, models.TimingStageAggregator, err)
+		}
+	}()
 	logCtx.Info("Starting aggregation.")
+	if err := f.cancellation.Check(ctx, logCtx, req.DocumentID); err != nil {
+		return nil, err
+	}
+	recordStatus(ctx, logCtx, f.firestoreClient, f.config.CollectionName, req.DocumentID, StatusAggregating, models.TimingStageAggregator)
 
-	// --- 1. List all .md files for the documentId ---
-	query := &storage.Query{Prefix: req.DocumentID + "/"}
-	it := f.storageClient.Bucket(f.config.TranslatedMarkdownBucket).Objects(ctx, query)
+	outputDoc, err := f.config.OutputNames.Document(ctx, f.firestoreClient, f.config.CollectionName, req.DocumentID, req.TenantID)
+	if err != nil {
+		logCtx.Error("Failed to resolve output names", "error", err)
+		return nil, sourceError(err)
+	}
+	outputObjectName, err := f.config.OutputNames.Master(outputDoc)
+	if err != nil {
+		logCtx.Error("Failed to name master file", "error", err)
+		return nil, err
+	}
+	pageMatcher, err := f.config.OutputNames.PageMatcher(outputDoc)
+	if err != nil {
+		logCtx.Error("Failed to match translated page names", "error", err)
+		return nil, err
+	}
+	outputGCSUri := fmt.Sprintf("gs://%s/%s", f.config.AggregatedMarkdownBucket, outputObjectName)
+	outputs := map[string]string{models.AggregationFormatMarkdown: outputGCSUri}
+	var jsonlName string
+	if slices.Contains(f.config.Formats, models.AggregationFormatJSONL) {
+		jsonlName = jsonlObjectName(outputObjectName)
+		outputs[models.AggregationFormatJSONL] = fmt.Sprintf("gs://%s/%s", f.config.AggregatedMarkdownBucket, jsonlName)
+	}
+	skipped := &models.MarkdownAggregatorResponse{Status: "success_skipped", MasterGCSUri: outputGCSUri, Outputs: outputs}
 
-	var objectNames []string
-	for {
-		attrs, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
+	// --- Reuse complete outputs from an earlier attempt, writing only the missing ones ---
+	reused, err := f.reuseExistingOutput(ctx, logCtx, outputObjectName)
+	if err != nil {
+		return nil, err
+	}
+	writeJSONL := false
+	if jsonlName != "" {
+		jsonlReused, err := f.reuseExistingOutput(ctx, logCtx, jsonlName)
 		if err != nil {
-			logCtx.Error("Failed to list objects in source bucket", "error", err, "bucket", f.config.TranslatedMarkdownBucket)
-			return nil, fmt.Errorf("failed to list markdown files: %w", err)
+			return nil, err
 		}
-		if strings.HasSuffix(attrs.Name, ".md") {
-			objectNames = append(objectNames, attrs.Name)
+		writeJSONL = !jsonlReused
+	}
+	if reused && !writeJSONL {
+		logCtx.Info("Complete master file already exists; skipping aggregation.", "masterGcsUri", outputGCSUri)
+		return skipped, nil
+	}
+
+	// --- 0. Refuse to aggregate until every page has been translated ---
+	if err := f.verifyPagesTranslated(ctx, logCtx, req.DocumentID); err != nil {
+		logCtx.Error("Document is not ready for aggregation", "error", err)
+		return nil, sourceError(err)
+	}
+
+	// --- 1. List all per-page and per-chunk markdown files for the documentId ---
+	prefix := pageMatcher.Prefix
+	objects, err := f.store.Bucket(f.config.TranslatedMarkdownBucket).List(ctx, prefix)
+	if err != nil {
+		logCtx.Error("Failed to list objects in source bucket", "error", err, "bucket", f.config.TranslatedMarkdownBucket, "gcsPrefix", prefix)
+		return nil, fmt.Errorf("failed to list markdown files: %w", err)
+	}
+
+	var pages []pageObject
+	for _, attrs := range objects {
+		startPage, endPage, ok := pageMatcher.Match(attrs.Name)
+		if !ok {
+			if strings.HasSuffix(attrs.Name, ".md") {
+				logCtx.Warn("Skipping markdown object that is not a translated page.", "gcsObject", attrs.Name)
+			}
+			continue
 		}
+		pages = append(pages, pageObject{StartPage: startPage, EndPage: endPage, Name: attrs.Name})
 	}
 
-	if len(objectNames) == 0 {
+	if len(pages) == 0 {
 		logCtx.Warn("No markdown files found to aggregate. This might be an error or an empty document.")
 		// We proceed to create an empty master file for consistency downstream.
 	}
 
-	// --- 2. Sort the filenames to ensure correct page order ---
-	sort.Strings(objectNames)
-	logCtx.Info("Found and sorted files for aggregation.", "fileCount", len(objectNames))
+	// --- 2. Order pages numerically and make sure none are missing ---
+	if err := orderPageObjects(pages); err != nil {
+		logCtx.Error("Translated pages are incomplete", "error", err)
+		return nil, err
+	}
+	logCtx.Info("Found and sorted files for aggregation.", "fileCount", len(pages))
 
-	// --- 3. Stream-concatenate files with centralized error handling ---
-	outputObjectName := fmt.Sprintf("%s/master.md", req.DocumentID)
-	destWriter := f.storageClient.Bucket(f.config.AggregatedMarkdownBucket).Object(outputObjectName).NewWriter(ctx)
+	// --- 3. Write the pages in order, in every format, while the next ones are prefetched ---
+	// Each write is conditional on its object not existing, so of two concurrent attempts
+	// only one commits and the other skips. Outputs are marked complete like every atomic
+	// save. A reused master file is not written again, but its pages are still read for
+	// the other formats.
+	writeCtx, cancelWrite := context.WithCancel(ctx)
+	defer cancelWrite()
+	destBucket := f.store.Bucket(f.config.AggregatedMarkdownBucket)
+	var destWriter, jsonlWriter objectstore.Writer
+	var masterOut io.Writer = io.Discard
+	if !reused {
+		destWriter = destBucket.NewWriter(writeCtx, outputObjectName, objectstore.WriterOptions{
+			DoesNotExist: true,
+			ContentType:  gcp.MarkdownContentType,
+			Metadata:     map[string]string{gcp.CompleteMetadataKey: "true"},
+		})
+		masterOut = destWriter
+	}
+	if writeJSONL {
+		jsonlWriter = destBucket.NewWriter(writeCtx, jsonlName, objectstore.WriterOptions{
+			DoesNotExist: true,
+			ContentType:  jsonlContentType,
+			Metadata:     map[string]string{gcp.CompleteMetadataKey: "true"},
+		})
+	}
+
+	prefetched, release, stopPrefetch := f.startPrefetch(ctx, pages)
 	var aggregationErr error
+	var aggregationObject string
+	var failedPages []int
+	var previousH1 string // The last level-1 heading of the pages written so far.
 
-	for _, objName := range objectNames {
-		logCtx.Info("Appending page.", "gcsObject", objName)
-		sourceReader, err := f.storageClient.Bucket(f.config.TranslatedMarkdownBucket).Object(objName).NewReader(ctx)
-		if err != nil {
-			aggregationErr = fmt.Errorf("failed to read %s: %w", objName, err)
+	for i, page := range pages {
+		objName := page.Name
+		fetched := prefetched[i]
+		<-fetched.done
+		if fetched.err != nil {
+			aggregationErr = sourceError(fetched.err)
+			aggregationObject = objName
 			break // Exit the loop on error
 		}
+		logCtx.Info("Appending page.", "gcsObject", objName, "buffered", fetched.buffered)
 
-		if _, err := io.Copy(destWriter, sourceReader); err != nil {
-			sourceReader.Close()
-			aggregationErr = fmt.Errorf("failed to copy content from %s: %w", objName, err)
-			break // Exit the loop on error
+		// Mark where each page starts, or separate it from the one before.
+		marker := pageSeparator
+		if f.config.PageAnchors {
+			marker = pageAnchor(page.StartPage, page.EndPage) + "\n\n"
+			if i > 0 {
+				marker = "\n\n" + marker
+			}
+		}
+		if i > 0 || f.config.PageAnchors {
+			if _, err := io.WriteString(masterOut, marker); err != nil {
+				release(i)
+				aggregationErr = outputError(fmt.Errorf("failed to write page marker: %w", err))
+				aggregationObject = outputObjectName
+				break // Exit the loop on error
+			}
+		}
+
+		var source io.Reader
+		var sourceCloser io.Closer
+		var content []byte
+		var streamed bytes.Buffer // The content of a streamed page, kept for the JSON Lines output.
+		if fetched.buffered {
+			content = fetched.content
+			if f.config.NormalizePages {
+				var normalized string
+				normalized, previousH1 = markdown.NormalizePage(string(content), previousH1)
+				content = []byte(normalized)
+			}
+			source = bytes.NewReader(content)
+		} else {
+			sourceReader, err := f.openPage(ctx, objName)
+			if err != nil {
+				aggregationErr = sourceError(fmt.Errorf("failed to read %s: %w", objName, err))
+				aggregationObject = objName
+				break // Exit the loop on error
+			}
+			source, sourceCloser = sourceReader, sourceReader
+			if jsonlWriter != nil {
+				source = io.TeeReader(sourceReader, &streamed)
+			}
+			if f.config.NormalizePages {
+				logCtx.Warn("Page is too large to normalize; appending it as is.", "gcsObject", objName)
+			}
+		}
+
+		// Placeholders for soft-failed pages are short, so the head is enough to spot them.
+		bufferedReader := bufio.NewReader(source)
+		if head, _ := bufferedReader.Peek(64); isFailedPagePlaceholder(head) {
+			for pageNumber := page.StartPage; pageNumber <= page.EndPage; pageNumber++ {
+				failedPages = append(failedPages, pageNumber)
+			}
 		}
-		sourceReader.Close() // Close successful reader
 
-		// Add a separator between files.
-		if _, err := destWriter.Write([]byte("\n\n---\n\n")); err != nil {
-			aggregationErr = fmt.Errorf("failed to write separator: %w", err)
+		_, err := io.Copy(masterOut, bufferedReader)
+		if sourceCloser != nil {
+			sourceCloser.Close()
+		}
+		release(i)
+		if err != nil {
+			aggregationErr = fmt.Errorf("failed to copy content from %s: %w", objName, err)
+			aggregationObject = objName
 			break // Exit the loop on error
 		}
-	}
 
-	if err := destWriter.Close(); err != nil {
-		logCtx.Error("Critical: Failed to finalize master.md write", "error", err, "object", outputObjectName)
-		return nil, fmt.Errorf("failed to finalize master.md: %w", err)
+		if jsonlWriter != nil {
+			if !fetched.buffered {
+				content = streamed.Bytes()
+			}
+			line, err := jsonlLine(page, content)
+			if err == nil {
+				_, err = jsonlWriter.Write(line)
+			}
+			if err != nil {
+				aggregationErr = outputError(fmt.Errorf("failed to write page to %s: %w", jsonlName, err))
+				aggregationObject = jsonlName
+				break // Exit the loop on error
+			}
+		}
 	}
+	stopPrefetch()
 
 	if aggregationErr != nil {
-		logCtx.Error("Error during aggregation loop", "error", aggregationErr)
+		// Abandon the uploads so that partial outputs are never finalized.
+		cancelWrite()
+		closeWriters(destWriter, jsonlWriter)
+		if errors.Is(aggregationErr, objectstore.ErrPreconditionFailed) {
+			logCtx.Warn("Master file was written by a concurrent attempt; skipping.", "masterGcsUri", outputGCSUri)
+			return skipped, nil
+		}
+		logCtx.Error("Error during aggregation loop", "error", aggregationErr, "gcsObject", aggregationObject)
 		return nil, aggregationErr
 	}
 
+	// The master file is committed last, so that it never exists without the other formats.
+	if jsonlWriter != nil {
+		if err := jsonlWriter.Close(); errors.Is(err, objectstore.ErrPreconditionFailed) {
+			logCtx.Warn("JSON Lines file was written by a concurrent attempt.", "gcsObject", jsonlName)
+		} else if err != nil {
+			cancelWrite()
+			closeWriters(destWriter)
+			logCtx.Error("Critical: Failed to finalize master.jsonl write", "error", err, "gcsObject", jsonlName)
+			return nil, outputError(fmt.Errorf("failed to finalize %s: %w", jsonlName, err))
+		}
+	}
+	if destWriter != nil {
+		if err := destWriter.Close(); err != nil {
+			if errors.Is(err, objectstore.ErrPreconditionFailed) {
+				logCtx.Warn("Master file was written by a concurrent attempt; skipping.", "masterGcsUri", outputGCSUri)
+				return skipped, nil
+			}
+			logCtx.Error("Critical: Failed to finalize master.md write", "error", err, "gcsObject", outputObjectName)
+			return nil, outputError(fmt.Errorf("failed to finalize master.md: %w", err))
+		}
+	}
+
+	if len(failedPages) > 0 {
+		logCtx.Warn("Master file contains failure placeholders.", "failedPageCount", len(failedPages), "failedPages", failedPages)
+	}
 	logCtx.Info("Aggregation complete.")
 
-	// --- 4. Return the URI of the new master file ---
-	outputGCSUri := fmt.Sprintf("gs://%s/%s", f.config.AggregatedMarkdownBucket, outputObjectName)
+	// --- 4. Return the URIs of the new master file and the other formats ---
 	return &models.MarkdownAggregatorResponse{
 		Status:       "success",
 		MasterGCSUri: outputGCSUri,
+		FailedPages:  failedPages,
+		Outputs:      outputs,
 	}, nil
 }
+
+// closeWriters closes every writer that was started, after the writes were abandoned.
+func closeWriters(writers ...objectstore.Writer) {
+	for _, writer := range writers {
+		if writer != nil {
+			_ = writer.Close()
+		}
+	}
+}
+
+// loadNormalizePages reads AGGREGATOR_NORMALIZE_PAGES.
+func loadNormalizePages() (bool, error) {
+	normalizePages, err := strconv.ParseBool(gcp.GetEnv("AGGREGATOR_NORMALIZE_PAGES", "true"))
+	if err != nil {
+		return false, fmt.Errorf("AGGREGATOR_NORMALIZE_PAGES must be a boolean")
+	}
+	return normalizePages, nil
+}
+
+// reuseExistingOutput reports whether objectName already holds a complete, non-empty
+// output, such as the master file. An incomplete one (left by a crashed or legacy writer)
+// or an empty one is deleted so that the conditional write can replace it.
+func (f *AggregatorFunction) reuseExistingOutput(ctx context.Context, logCtx *slog.Logger, objectName string) (bool, error) {
+	bucket := f.store.Bucket(f.config.AggregatedMarkdownBucket)
+	attrs, err := bucket.Attrs(ctx, objectName)
+	if errors.Is(err, objectstore.ErrObjectNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		logCtx.Error("Failed to inspect existing output", "error", err, "gcsObject", objectName)
+		return false, fmt.Errorf("failed to inspect existing output %s: %w", objectName, err)
+	}
+	if gcp.IsCompleteObject(attrs) && gcp.ContentSize(attrs) != 0 {
+		return true, nil
+	}
+
+	logCtx.Warn("Existing output is incomplete or empty; aggregating again.",
+		"gcsObject", objectName,
+		"size", attrs.Size,
+		"complete", attrs.Metadata[gcp.CompleteMetadataKey],
+	)
+	if err := bucket.Delete(ctx, objectName, attrs.Generation); err != nil && !errors.Is(err, objectstore.ErrObjectNotExist) {
+		logCtx.Error("Failed to delete stale output", "error", err, "gcsObject", objectName)
+		return false, fmt.Errorf("failed to delete stale output %s: %w", objectName, err)
+	}
+	return false, nil
+}
+
+// verifyPagesTranslated checks the document's page records and returns an error naming
+// every page that is missing or not yet translated. Documents split before page records
+// existed have none and are aggregated without the check.
+func (f *AggregatorFunction) verifyPagesTranslated(ctx context.Context, logCtx *slog.Logger, docID string) error {
+	docRef := f.firestoreClient.Collection(f.config.CollectionName).Doc(docID)
+	docSnap, err := docRef.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read document %s: %w", docID, err)
+	}
+	var doc models.Document
+	if err := docSnap.DataTo(&doc); err != nil {
+		return fmt.Errorf("failed to decode document %s: %w", docID, err)
+	}
+
+	pageSnaps, err := docRef.Collection(pagesSubcollection).Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to read page records: %w", err)
+	}
+	if len(pageSnaps) == 0 {
+		logCtx.Warn("Document has no page records; skipping page verification.")
+		return nil
+	}
+
+	statusByPage := make(map[int]string, len(pageSnaps))
+	for _, snap := range pageSnaps {
+		var page models.Page
+		if err := snap.DataTo(&page); err != nil {
+			return fmt.Errorf("failed to decode page record %s: %w", snap.Ref.ID, err)
+		}
+		statusByPage[page.PageNumber] = page.Status
+	}
+
+	var notReady []string
+	for pageNumber := 1; pageNumber <= doc.PageCount; pageNumber++ {
+		status, ok := statusByPage[pageNumber]
+		switch {
+		case !ok:
+			notReady = append(notReady, fmt.Sprintf("%d (missing)", pageNumber))
+		case status != models.PageStatusTranslated && status != models.PageStatusSkipped && status != models.PageStatusFailedSoft:
+			notReady = append(notReady, fmt.Sprintf("%d (%s)", pageNumber, status))
+		}
+	}
+	if len(notReady) == 0 {
+		return nil
+	}
+
+	const maxListed = 20
+	listed := notReady
+	if len(listed) > maxListed {
+		listed = listed[:maxListed]
+	}
+	return fmt.Errorf("%d of %d pages are not translated: %s", len(notReady), doc.PageCount, strings.Join(listed, ", "))
+}
+
+// prefetchedPage is the result of reading one page object ahead of the writer.
+type prefetchedPage struct {
+	done     chan struct{} // Closed once the fields below are set.
+	buffered bool          // False when the object exceeds the size cap and must be streamed.
+	content  []byte
+	err      error
+}
+
+// startPrefetch reads pages into memory ahead of the writer, at most Prefetch at a time.
+// A slot is only reused once the writer calls release for the page occupying it, which
+// bounds memory to Prefetch objects of at most PrefetchMaxBytes each. stop cancels any
+// outstanding reads and waits for them to exit.
+func (f *AggregatorFunction) startPrefetch(ctx context.Context, pages []pageObject) (results []*prefetchedPage, release func(int), stop func()) {
+	results = make([]*prefetchedPage, len(pages))
+	for i := range results {
+		results[i] = &prefetchedPage{done: make(chan struct{})}
+	}
+
+	prefetchCtx, cancel := context.WithCancel(ctx)
+	slots := make(chan struct{}, f.config.Prefetch)
+	eg, gctx := errgroup.WithContext(prefetchCtx)
+	eg.SetLimit(f.config.Prefetch)
+
+	go func() {
+		for i, page := range pages {
+			select {
+			case slots <- struct{}{}:
+			case <-gctx.Done():
+				// Unblock the writer for every page that will now never be fetched.
+				for j := i; j < len(pages); j++ {
+					results[j].err = fmt.Errorf("prefetch of %s abandoned: %w", pages[j].Name, context.Cause(gctx))
+					close(results[j].done)
+				}
+				return
+			}
+			i, page := i, page
+			eg.Go(func() error {
+				err := f.prefetchPage(gctx, page.Name, results[i])
+				close(results[i].done)
+				return err
+			})
+		}
+	}()
+
+	release = func(int) { <-slots }
+	stop = func() {
+		cancel()
+		_ = eg.Wait()
+	}
+	return results, release, stop
+}
+
+// prefetchPage reads a single page object into result, leaving it unbuffered when the
+// content is larger than the configured cap. Pages may be stored compressed, so the cap is
+// applied to the bytes read rather than to the stored size. Transient failures are
+// retried with gcp.DefaultRetryOptions.
+func (f *AggregatorFunction) prefetchPage(ctx context.Context, objectName string, result *prefetchedPage) error {
+	var content []byte
+	err := gcp.Retry(ctx, gcp.DefaultRetryOptions, func(ctx context.Context) error {
+		reader, err := f.store.Bucket(f.config.TranslatedMarkdownBucket).NewReader(ctx, objectName)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		content, err = io.ReadAll(io.LimitReader(reader, f.config.PrefetchMaxBytes+1))
+		return err
+	})
+	if err != nil {
+		result.err = fmt.Errorf("failed to read %s: %w", objectName, err)
+		return result.err
+	}
+	if int64(len(content)) > f.config.PrefetchMaxBytes {
+		return nil
+	}
+	result.content, result.buffered = content, true
+	return nil
+}
+
+// openPage opens a page object to stream it, retrying transient failures to open it with
+// gcp.DefaultRetryOptions. Failures once reading has begun are not retried, as part of
+// the page may already have been written.
+func (f *AggregatorFunction) openPage(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	var reader io.ReadCloser
+	err := gcp.Retry(ctx, gcp.DefaultRetryOptions, func(ctx context.Context) (err error) {
+		reader, err = f.store.Bucket(f.config.TranslatedMarkdownBucket).NewReader(ctx, objectName)
+		return err
+	})
+	return reader, err
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// jsonlContentType is the content type of the JSON Lines output.
+const jsonlContentType = "application/x-ndjson"
+
+// loadAggregationFormats reads AGGREGATION_FORMATS, a comma-separated list of
+// AggregationFormat values. The markdown format cannot be left out, as the cleaner reads
+// the master file.
+func loadAggregationFormats() ([]string, error) {
+	var formats []string
+	for _, format := range strings.Split(gcp.GetEnv("AGGREGATION_FORMATS", models.AggregationFormatMarkdown), ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		switch format {
+		case "":
+		case models.AggregationFormatMarkdown, models.AggregationFormatJSONL:
+			if !slices.Contains(formats, format) {
+				formats = append(formats, format)
+			}
+		default:
+			return nil, fmt.Errorf("AGGREGATION_FORMATS must list %q or %q, got %q", models.AggregationFormatMarkdown, models.AggregationFormatJSONL, format)
+		}
+	}
+	if len(formats) == 0 {
+		return []string{models.AggregationFormatMarkdown}, nil
+	}
+	if !slices.Contains(formats, models.AggregationFormatMarkdown) {
+		return nil, fmt.Errorf("AGGREGATION_FORMATS must include %q, which the cleaner reads", models.AggregationFormatMarkdown)
+	}
+	return formats, nil
+}
+
+// jsonlObjectName returns the name of the JSON Lines output written alongside the master
+// file masterObjectName: master.md becomes master.jsonl.
+func jsonlObjectName(masterObjectName string) string {
+	return strings.TrimSuffix(masterObjectName, path.Ext(masterObjectName)) + ".jsonl"
+}
+
+// jsonlPage is a line of the JSON Lines output.
+type jsonlPage struct {
+	Page     int    `json:"page"`
+	EndPage  int    `json:"endPage,omitempty"` // Set for a multi-page chunk.
+	Markdown string `json:"markdown"`
+}
+
+// jsonlLine returns the newline-terminated JSON Lines record of a page file's markdown.
+func jsonlLine(page pageObject, markdown []byte) ([]byte, error) {
+	record := jsonlPage{Page: page.StartPage, Markdown: string(markdown)}
+	if page.EndPage != page.StartPage {
+		record.EndPage = page.EndPage
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode page %d: %w", page.StartPage, err)
+	}
+	return append(line, '\n'), nil
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+func TestLoadAggregationFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		unset   bool
+		want    []string
+		wantErr string
+	}{
+		{name: "default", unset: true, want: []string{models.AggregationFormatMarkdown}},
+		{name: "empty", env: "", want: []string{models.AggregationFormatMarkdown}},
+		{name: "markdown", env: "markdown", want: []string{models.AggregationFormatMarkdown}},
+		{name: "both", env: "markdown,jsonl", want: []string{models.AggregationFormatMarkdown, models.AggregationFormatJSONL}},
+		{name: "spaces, case and repeats", env: " JSONL , markdown,jsonl,", want: []string{models.AggregationFormatJSONL, models.AggregationFormatMarkdown}},
+		{name: "without markdown", env: "jsonl", wantErr: "must include"},
+		{name: "unknown format", env: "markdown,html", wantErr: `got "html"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AGGREGATION_FORMATS", tt.env)
+			if tt.unset {
+				os.Unsetenv("AGGREGATION_FORMATS")
+			}
+			got, err := loadAggregationFormats()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadAggregationFormats() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadAggregationFormats() error = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("loadAggregationFormats() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONLObjectName(t *testing.T) {
+	tests := map[string]string{
+		"doc-1/master.md":         "doc-1/master.jsonl",
+		"tenant/doc-1/pump.v2.md": "tenant/doc-1/pump.v2.jsonl",
+		"doc-1/master":            "doc-1/master.jsonl",
+	}
+	for master, want := range tests {
+		if got := jsonlObjectName(master); got != want {
+			t.Errorf("jsonlObjectName(%q) = %q, want %q", master, got, want)
+		}
+	}
+}
+
+func TestJSONLLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		page     pageObject
+		markdown string
+		want     jsonlPage
+	}{
+		{name: "page", page: pageObject{StartPage: 3, EndPage: 3}, markdown: "# Pump", want: jsonlPage{Page: 3, Markdown: "# Pump"}},
+		{name: "chunk", page: pageObject{StartPage: 3, EndPage: 5}, markdown: "# Pump", want: jsonlPage{Page: 3, EndPage: 5, Markdown: "# Pump"}},
+		{
+			name:     "newlines, quotes and unicode",
+			page:     pageObject{StartPage: 1, EndPage: 1},
+			markdown: "| Part | \"Clearance\" |\n|---|---|\n| Impeller | 0.35 mm ± 0.01 |\n\n<!-- page -->\n",
+			want:     jsonlPage{Page: 1, Markdown: "| Part | \"Clearance\" |\n|---|---|\n| Impeller | 0.35 mm ± 0.01 |\n\n<!-- page -->\n"},
+		},
+		{name: "empty page", page: pageObject{StartPage: 2, EndPage: 2}, want: jsonlPage{Page: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, err := jsonlLine(tt.page, []byte(tt.markdown))
+			if err != nil {
+				t.Fatalf("jsonlLine() error = %v", err)
+			}
+			if !bytes.HasSuffix(line, []byte("\n")) || bytes.Count(line, []byte("\n")) != 1 {
+				t.Fatalf("jsonlLine() = %q, want a single newline-terminated line", line)
+			}
+			var got jsonlPage
+			if err := json.Unmarshal(line, &got); err != nil {
+				t.Fatalf("jsonlLine() = %q, not JSON: %v", line, err)
+			}
+			if got != tt.want {
+				t.Errorf("jsonlLine() decoded = %+v, want %+v", got, tt.want)
+			}
+			if tt.page.EndPage == tt.page.StartPage && bytes.Contains(line, []byte("endPage")) {
+				t.Errorf("jsonlLine() = %q, want no endPage for a single page", line)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+//go:build integration
+
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+// racingStore commits a master file of its own just before the aggregator starts writing
+// one, as a concurrent attempt finishing first would.
+type racingStore struct {
+	*objectstore.Memory
+	object string
+}
+
+func (s racingStore) Bucket(name string) objectstore.Bucket {
+	return racingBucket{Bucket: s.Memory.Bucket(name), store: s}
+}
+
+type racingBucket struct {
+	objectstore.Bucket
+	store racingStore
+}
+
+func (b racingBucket) NewWriter(ctx context.Context, object string, opts objectstore.WriterOptions) objectstore.Writer {
+	if object == b.store.object {
+		b.store.Put(b.Name(), object, []byte("# Concurrent"), map[string]string{gcp.CompleteMetadataKey: "true"})
+	}
+	return b.Bucket.NewWriter(ctx, object, opts)
+}
+
+func newTestAggregator(ctx context.Context, t *testing.T, store objectstore.Client) *AggregatorFunction {
+	t.Helper()
+	firestoreClient, _ := testsupport.RequireEmulators(t).Clients(ctx, t)
+	collection := fmt.Sprintf("documents-%d", time.Now().UnixNano())
+	if _, err := firestoreClient.Collection(collection).Doc("doc-1").Set(ctx, map[string]interface{}{
+		"status":              "TRANSLATING",
+		"workflowExecutionId": testsupport.ExecutionID,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return &AggregatorFunction{
+		store:           store,
+		firestoreClient: firestoreClient,
+		config: AggregatorConfig{
+			TranslatedMarkdownBucket: "translated",
+			AggregatedMarkdownBucket: "master",
+			CollectionName:           collection,
+			Prefetch:                 2,
+			PrefetchMaxBytes:         1024,
+		},
+	}
+}
+
+func TestAggregatorSkipsCompleteMaster(t *testing.T) {
+	ctx := context.Background()
+	store := objectstore.NewMemory()
+	store.Put("master", "doc-1/master.md", []byte("# Earlier"), map[string]string{gcp.CompleteMetadataKey: "true"})
+	f := newTestAggregator(ctx, t, store)
+
+	res, err := f.Process(ctx, testsupport.AggregatorRequest("doc-1"))
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if res.Status != "success_skipped" || res.MasterGCSUri != "gs://master/doc-1/master.md" {
+		t.Errorf("Process() = %+v, want success_skipped with the existing master file", res)
+	}
+	if data, _ := store.Get("master", "doc-1/master.md"); string(data) != "# Earlier" {
+		t.Errorf("master file = %q, want it left as it was", data)
+	}
+}
+
+func TestAggregatorRewritesIncompleteMaster(t *testing.T) {
+	ctx := context.Background()
+	store := objectstore.NewMemory()
+	store.Put("master", "doc-1/master.md", []byte("# Par"), nil)
+	store.Put("translated", "doc-1/00001.md", []byte("# One"), nil)
+	store.Put("translated", "doc-1/00002.md", []byte("# Two"), nil)
+	f := newTestAggregator(ctx, t, store)
+
+	res, err := f.Process(ctx, testsupport.AggregatorRequest("doc-1"))
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if res.Status != "success" {
+		t.Errorf("Process() status = %q, want success", res.Status)
+	}
+	if data, _ := store.Get("master", "doc-1/master.md"); string(data) != "# One"+pageSeparator+"# Two" {
+		t.Errorf("master file = %q", data)
+	}
+}
+
+func TestAggregatorYieldsToConcurrentAttempt(t *testing.T) {
+	ctx := context.Background()
+	store := racingStore{Memory: objectstore.NewMemory(), object: "doc-1/master.md"}
+	store.Put("translated", "doc-1/00001.md", []byte("# One"), nil)
+	f := newTestAggregator(ctx, t, store)
+
+	res, err := f.Process(ctx, testsupport.AggregatorRequest("doc-1"))
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if res.Status != "success_skipped" {
+		t.Errorf("Process() status = %q, want success_skipped", res.Status)
+	}
+	if data, _ := store.Get("master", "doc-1/master.md"); string(data) != "# Concurrent" {
+		t.Errorf("master file = %q, want the concurrent attempt's", data)
+	}
+}
+
+// failingWriteStore fails every write to the object named object.
+type failingWriteStore struct {
+	*objectstore.Memory
+	object string
+	err    error
+}
+
+func (s failingWriteStore) Bucket(name string) objectstore.Bucket {
+	return failingWriteBucket{Bucket: s.Memory.Bucket(name), store: s}
+}
+
+type failingWriteBucket struct {
+	objectstore.Bucket
+	store failingWriteStore
+}
+
+func (b failingWriteBucket) NewWriter(ctx context.Context, object string, opts objectstore.WriterOptions) objectstore.Writer {
+	writer := b.Bucket.NewWriter(ctx, object, opts)
+	if object == b.store.object {
+		return failingWriter{Writer: writer, err: b.store.err}
+	}
+	return writer
+}
+
+type failingWriter struct {
+	objectstore.Writer
+	err error
+}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+func TestAggregatorWritesEveryFormat(t *testing.T) {
+	pages := []string{"# One\n\nThe impeller clearance is 0.35 mm.", "| Part | \"Clearance\" |\n|---|---|\n| Seal | ± 0.10 mm |"}
+	tests := []struct {
+		name    string
+		formats []string
+	}{
+		{name: "markdown", formats: []string{models.AggregationFormatMarkdown}},
+		{name: "markdown and jsonl", formats: []string{models.AggregationFormatMarkdown, models.AggregationFormatJSONL}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := objectstore.NewMemory()
+			store.Put("translated", "doc-1/00001.md", []byte(pages[0]), nil)
+			store.Put("translated", "doc-1/00002.md", []byte(pages[1]), nil)
+			f := newTestAggregator(ctx, t, store)
+			f.config.Formats = tt.formats
+
+			res, err := f.Process(ctx, testsupport.AggregatorRequest("doc-1"))
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if data, _ := store.Get("master", "doc-1/master.md"); string(data) != pages[0]+pageSeparator+pages[1] {
+				t.Errorf("master file = %q", data)
+			}
+			if res.Outputs[models.AggregationFormatMarkdown] != "gs://master/doc-1/master.md" {
+				t.Errorf("Outputs = %v, want the master file", res.Outputs)
+			}
+
+			data, ok := store.Get("master", "doc-1/master.jsonl")
+			if !slices.Contains(tt.formats, models.AggregationFormatJSONL) {
+				if ok || len(res.Outputs) != 1 {
+					t.Errorf("JSON Lines file written without the format; outputs %v", res.Outputs)
+				}
+				return
+			}
+			if !ok || res.Outputs[models.AggregationFormatJSONL] != "gs://master/doc-1/master.jsonl" {
+				t.Fatalf("JSON Lines file not written; outputs %v", res.Outputs)
+			}
+			var got []jsonlPage
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			for decoder.More() {
+				var line jsonlPage
+				if err := decoder.Decode(&line); err != nil {
+					t.Fatalf("master.jsonl = %q, not JSON Lines: %v", data, err)
+				}
+				got = append(got, line)
+			}
+			want := []jsonlPage{{Page: 1, Markdown: pages[0]}, {Page: 2, Markdown: pages[1]}}
+			if !slices.Equal(got, want) {
+				t.Errorf("master.jsonl pages = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestAggregatorFormatFailureWritesNoMaster(t *testing.T) {
+	tests := []struct {
+		name  string
+		store func(*objectstore.Memory) objectstore.Client
+	}{
+		{
+			name: "page write fails",
+			store: func(memory *objectstore.Memory) objectstore.Client {
+				return failingWriteStore{Memory: memory, object: "doc-1/master.jsonl", err: errors.New("connection reset")}
+			},
+		},
+		{
+			name: "finalize fails",
+			store: func(memory *objectstore.Memory) objectstore.Client {
+				memory.FailWrites(errors.New("connection reset"))
+				return memory
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			memory := objectstore.NewMemory()
+			memory.Put("translated", "doc-1/00001.md", []byte("# One"), nil)
+			f := newTestAggregator(ctx, t, tt.store(memory))
+			f.config.Formats = []string{models.AggregationFormatMarkdown, models.AggregationFormatJSONL}
+
+			_, err := f.Process(ctx, testsupport.AggregatorRequest("doc-1"))
+			if models.CodeOf(err) != models.ErrorCodeOutputWriteFailed {
+				t.Fatalf("Process() error = %v (code %s), want code %s", err, models.CodeOf(err), models.ErrorCodeOutputWriteFailed)
+			}
+			for _, object := range []string{"doc-1/master.md", "doc-1/master.jsonl"} {
+				if _, ok := memory.Get("master", object); ok {
+					t.Errorf("%s written despite the failed JSON Lines output", object)
+				}
+			}
+		})
+	}
+}
+
+func TestAggregatorRejectsAnotherTenant(t *testing.T) {
+	ctx := context.Background()
+	store := objectstore.NewMemory()
+	f := newTestAggregator(ctx, t, store)
+	f.tenants = NewDocumentTenants(f.firestoreClient, f.config.CollectionName)
+
+	req := testsupport.AggregatorRequest("doc-1")
+	req.TenantID = "acme"
+	_, err := f.Process(ctx, req)
+	if !errors.Is(err, ErrTenantMismatch) || models.CodeOf(err) != models.ErrorCodeInvalidRequest {
+		t.Fatalf("Process() error = %v, want an INVALID_REQUEST tenant mismatch", err)
+	}
+	// The request is refused before the document is touched.
+	doc := readPipelineDocument(ctx, t, f.firestoreClient, f.config.CollectionName, "doc-1")
+	if doc.Status != "TRANSLATING" || len(doc.StatusHistory) != 0 {
+		t.Errorf("document = status %q, history %+v; want it unchanged", doc.Status, doc.StatusHistory)
+	}
+
+	_, err = f.Process(ctx, testsupport.AggregatorRequest("doc-missing"))
+	if code := models.CodeOf(err); code != models.ErrorCodeNotFoundSource {
+		t.Errorf("Process() of a missing document error = %v, want %s", err, models.ErrorCodeNotFoundSource)
+	}
+}
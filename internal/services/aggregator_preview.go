@@ -0,0 +1,246 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// statusPreview is the status of a response to an incremental aggregation request.
+const statusPreview = "preview"
+
+// previewObjectBase is the base name of the preview file, which is written beside the
+// master file.
+const previewObjectBase = "preview.md"
+
+// previewObjectName returns the name of the preview written beside the master file
+// masterObjectName.
+func previewObjectName(masterObjectName string) string {
+	return path.Join(path.Dir(masterObjectName), previewObjectBase)
+}
+
+// pendingPagePlaceholder stands in the preview for a page not translated yet.
+func pendingPagePlaceholder(page int) string {
+	return "<!-- page " + strconv.Itoa(page) + " pending -->"
+}
+
+// preview answers an incremental request: it joins the translated pages that exist so far
+// into preview.md, in page order and with a placeholder for each page still pending, and
+// returns the pending pages. Pages are written as they are, without normalization. The
+// preview is written to a temporary object and copied over the previous one, so readers
+// never see it half written. The document's status and timings are left as they are.
+func (f *AggregatorFunction) preview(ctx context.Context, logCtx *slog.Logger, req *models.MarkdownAggregatorRequest) (*models.MarkdownAggregatorResponse, error) {
+	logCtx = logCtx.With("incremental", true)
+	if err := f.cancellation.Check(ctx, logCtx, req.DocumentID); err != nil {
+		return nil, err
+	}
+	snap, err := f.firestoreClient.Collection(f.config.CollectionName).Doc(req.DocumentID).Get(ctx)
+	if err != nil {
+		logCtx.Error("Failed to read document", "error", err)
+		return nil, fmt.Errorf("failed to read document %s: %w", req.DocumentID, err)
+	}
+	var doc models.Document
+	if err := snap.DataTo(&doc); err != nil {
+		logCtx.Error("Failed to decode document", "error", err)
+		return nil, fmt.Errorf("failed to decode document %s: %w", req.DocumentID, err)
+	}
+	if doc.PageCount == 0 {
+		err := fmt.Errorf("document %s has not been split into pages", req.DocumentID)
+		logCtx.Error("Document is not ready for a preview", "error", err)
+		return nil, models.WithCode(models.ErrorCodeInvalidRequest, err)
+	}
+
+	outputDoc, err := f.config.OutputNames.Document(ctx, f.firestoreClient, f.config.CollectionName, req.DocumentID, req.TenantID)
+	if err != nil {
+		logCtx.Error("Failed to resolve output names", "error", err)
+		return nil, sourceError(err)
+	}
+	masterName, err := f.config.OutputNames.Master(outputDoc)
+	if err != nil {
+		logCtx.Error("Failed to name master file", "error", err)
+		return nil, err
+	}
+	previewName := previewObjectName(masterName)
+	if previewName == masterName {
+		err := fmt.Errorf("the master file %s is named as its preview", masterName)
+		logCtx.Error("Cannot write preview", "error", err)
+		return nil, err
+	}
+	pageMatcher, err := f.config.OutputNames.PageMatcher(outputDoc)
+	if err != nil {
+		logCtx.Error("Failed to match translated page names", "error", err)
+		return nil, err
+	}
+	objects, err := f.store.Bucket(f.config.TranslatedMarkdownBucket).List(ctx, pageMatcher.Prefix)
+	if err != nil {
+		logCtx.Error("Failed to list objects in source bucket", "error", err, "bucket", f.config.TranslatedMarkdownBucket, "gcsPrefix", pageMatcher.Prefix)
+		return nil, fmt.Errorf("failed to list markdown files: %w", err)
+	}
+	var pages []pageObject
+	for _, attrs := range objects {
+		if startPage, endPage, ok := pageMatcher.Match(attrs.Name); ok {
+			pages = append(pages, pageObject{StartPage: startPage, EndPage: endPage, Name: attrs.Name})
+		}
+	}
+	entries, pending := previewEntries(pages, doc.PageCount)
+
+	tempName, err := previewTempName(previewName)
+	if err != nil {
+		return nil, err
+	}
+	failedPages, err := f.writePreview(ctx, tempName, entries)
+	if err != nil {
+		logCtx.Error("Failed to write preview", "error", err, "gcsObject", tempName)
+		return nil, err
+	}
+	if err := f.replacePreview(ctx, logCtx, tempName, previewName); err != nil {
+		return nil, err
+	}
+
+	previewGCSUri := fmt.Sprintf("gs://%s/%s", f.config.AggregatedMarkdownBucket, previewName)
+	logCtx.Info("Preview written.", "previewGcsUri", previewGCSUri, "pageCount", doc.PageCount, "pendingPageCount", len(pending))
+	return &models.MarkdownAggregatorResponse{
+		Status:        statusPreview,
+		PreviewGCSUri: previewGCSUri,
+		PendingPages:  pending,
+		FailedPages:   failedPages,
+	}, nil
+}
+
+// previewEntries orders the translated page objects of a document of pageCount pages and
+// fills each gap with a pending entry, which has no Name, for every page in it. It
+// returns the entries and the pending pages. An object overlapping pages already covered
+// is dropped, as are objects beyond pageCount.
+func previewEntries(pages []pageObject, pageCount int) ([]pageObject, []int) {
+	sort.Slice(pages, func(i, j int) bool {
+		if pages[i].StartPage != pages[j].StartPage {
+			return pages[i].StartPage < pages[j].StartPage
+		}
+		return pages[i].EndPage < pages[j].EndPage
+	})
+	var entries []pageObject
+	var pending []int
+	expected := 1
+	for _, page := range pages {
+		if page.StartPage < expected || page.EndPage > pageCount {
+			continue
+		}
+		for ; expected < page.StartPage; expected++ {
+			entries = append(entries, pageObject{StartPage: expected, EndPage: expected})
+			pending = append(pending, expected)
+		}
+		entries = append(entries, page)
+		expected = page.EndPage + 1
+	}
+	for ; expected <= pageCount; expected++ {
+		entries = append(entries, pageObject{StartPage: expected, EndPage: expected})
+		pending = append(pending, expected)
+	}
+	return entries, pending
+}
+
+// previewTempName returns a new name for the temporary object a preview is written to,
+// unique to the attempt so that concurrent previews do not write over each other.
+func previewTempName(previewName string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to name temporary preview: %w", err)
+	}
+	return previewName + ".tmp-" + hex.EncodeToString(b), nil
+}
+
+// writePreview writes the preview's entries to objectName, marking pages and separating
+// them as in the master file, and returns the pages that are failure placeholders.
+func (f *AggregatorFunction) writePreview(ctx context.Context, objectName string, entries []pageObject) ([]int, error) {
+	writeCtx, cancelWrite := context.WithCancel(ctx)
+	defer cancelWrite()
+	writer := f.store.Bucket(f.config.AggregatedMarkdownBucket).NewWriter(writeCtx, objectName, objectstore.WriterOptions{
+		ContentType: gcp.MarkdownContentType,
+	})
+	var failedPages []int
+	for i, entry := range entries {
+		var sb strings.Builder
+		switch {
+		case i > 0 && f.config.PageAnchors:
+			sb.WriteString("\n\n")
+		case i > 0:
+			sb.WriteString(pageSeparator)
+		}
+		if entry.Name == "" {
+			sb.WriteString(pendingPagePlaceholder(entry.StartPage))
+		} else if f.config.PageAnchors {
+			sb.WriteString(pageAnchor(entry.StartPage, entry.EndPage) + "\n\n")
+		}
+		if _, err := io.WriteString(writer, sb.String()); err != nil {
+			cancelWrite()
+			closeWriters(writer)
+			return nil, outputError(fmt.Errorf("failed to write %s: %w", objectName, err))
+		}
+		if entry.Name == "" {
+			continue
+		}
+		failed, err := f.copyPreviewPage(ctx, writer, entry.Name)
+		if err != nil {
+			cancelWrite()
+			closeWriters(writer)
+			return nil, err
+		}
+		if failed {
+			for pageNumber := entry.StartPage; pageNumber <= entry.EndPage; pageNumber++ {
+				failedPages = append(failedPages, pageNumber)
+			}
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, outputError(fmt.Errorf("failed to finalize %s: %w", objectName, err))
+	}
+	return failedPages, nil
+}
+
+// replacePreview copies the preview written to tempName over previewName, then deletes
+// tempName. The previous preview is left as it was if the copy fails.
+func (f *AggregatorFunction) replacePreview(ctx context.Context, logCtx *slog.Logger, tempName, previewName string) error {
+	bucket := f.store.Bucket(f.config.AggregatedMarkdownBucket)
+	err := gcp.Retry(ctx, gcp.DefaultRetryOptions, func(ctx context.Context) error {
+		return bucket.Copy(ctx, tempName, previewName)
+	})
+	if deleteErr := bucket.Delete(context.WithoutCancel(ctx), tempName, 0); deleteErr != nil && !errors.Is(deleteErr, objectstore.ErrObjectNotExist) {
+		logCtx.Warn("Failed to delete temporary preview", "error", deleteErr, "gcsObject", tempName)
+	}
+	if err != nil {
+		logCtx.Error("Failed to replace preview", "error", err, "gcsObject", previewName)
+		return outputError(fmt.Errorf("failed to replace %s: %w", previewName, err))
+	}
+	return nil
+}
+
+// copyPreviewPage copies a translated page object to the preview and reports whether it
+// is a failure placeholder.
+func (f *AggregatorFunction) copyPreviewPage(ctx context.Context, writer io.Writer, objectName string) (bool, error) {
+	reader, err := f.openPage(ctx, objectName)
+	if err != nil {
+		return false, sourceError(fmt.Errorf("failed to read %s: %w", objectName, err))
+	}
+	defer reader.Close()
+	bufferedReader := bufio.NewReader(reader)
+	head, _ := bufferedReader.Peek(64)
+	failed := isFailedPagePlaceholder(head)
+	if _, err := io.Copy(writer, bufferedReader); err != nil {
+		return false, fmt.Errorf("failed to copy content from %s: %w", objectName, err)
+	}
+	return failed, nil
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+// failingCopyStore fails every Copy with err.
+type failingCopyStore struct {
+	objectstore.Client
+	err error
+}
+
+func (s failingCopyStore) Bucket(name string) objectstore.Bucket {
+	return failingCopyBucket{Bucket: s.Client.Bucket(name), err: s.err}
+}
+
+type failingCopyBucket struct {
+	objectstore.Bucket
+	err error
+}
+
+func (b failingCopyBucket) Copy(ctx context.Context, src, dst string) error {
+	return b.err
+}
+
+func TestPreviewEntries(t *testing.T) {
+	page := func(start, end int) pageObject {
+		return pageObject{StartPage: start, EndPage: end, Name: fmt.Sprintf("doc-1/%05d-%05d.md", start, end)}
+	}
+	pending := func(p int) pageObject { return pageObject{StartPage: p, EndPage: p} }
+	tests := []struct {
+		name        string
+		pages       []pageObject
+		pageCount   int
+		want        []pageObject
+		wantPending []int
+	}{
+		{name: "nothing translated", pageCount: 3, want: []pageObject{pending(1), pending(2), pending(3)}, wantPending: []int{1, 2, 3}},
+		{name: "everything translated, out of order", pages: []pageObject{page(3, 3), page(1, 2)}, pageCount: 3, want: []pageObject{page(1, 2), page(3, 3)}},
+		{name: "gaps", pages: []pageObject{page(3, 3), page(1, 1)}, pageCount: 4, want: []pageObject{page(1, 1), pending(2), page(3, 3), pending(4)}, wantPending: []int{2, 4}},
+		{name: "page inside a chunk", pages: []pageObject{page(2, 2), page(1, 3)}, pageCount: 3, want: []pageObject{page(1, 3)}},
+		{name: "chunks sharing a start", pages: []pageObject{page(1, 2), page(1, 1)}, pageCount: 2, want: []pageObject{page(1, 1), pending(2)}, wantPending: []int{2}},
+		{name: "page beyond the page count", pages: []pageObject{page(1, 1), page(3, 3)}, pageCount: 2, want: []pageObject{page(1, 1), pending(2)}, wantPending: []int{2}},
+		{name: "chunk running past the page count", pages: []pageObject{page(1, 1), page(2, 4)}, pageCount: 3, want: []pageObject{page(1, 1), pending(2), pending(3)}, wantPending: []int{2, 3}},
+		{name: "no pages", pages: []pageObject{page(1, 1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotPending := previewEntries(tt.pages, tt.pageCount)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("previewEntries() entries = %+v, want %+v", got, tt.want)
+			}
+			if !slices.Equal(gotPending, tt.wantPending) {
+				t.Errorf("previewEntries() pending = %v, want %v", gotPending, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestWritePreview(t *testing.T) {
+	tests := []struct {
+		name        string
+		pageAnchors bool
+		want        string
+	}{
+		{
+			name: "separators",
+			want: "# Pump\n\n---\n\n<!-- page 2 pending -->\n\n---\n\n<!-- PAGE 3 FAILED: blocked -->",
+		},
+		{
+			name:        "page anchors",
+			pageAnchors: true,
+			want:        "<!-- page: 1 -->\n\n# Pump\n\n<!-- page 2 pending -->\n\n<!-- page: 3 -->\n\n<!-- PAGE 3 FAILED: blocked -->",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := objectstore.NewMemory()
+			store.Put("translated", "doc-1/00001.md", []byte("# Pump"), nil)
+			store.Put("translated", "doc-1/00003.md", []byte(failedPagePlaceholder(3, 3, "blocked")), nil)
+			f := &AggregatorFunction{store: store, config: AggregatorConfig{TranslatedMarkdownBucket: "translated", AggregatedMarkdownBucket: "master", PageAnchors: tt.pageAnchors}}
+			entries, _ := previewEntries([]pageObject{{StartPage: 1, EndPage: 1, Name: "doc-1/00001.md"}, {StartPage: 3, EndPage: 3, Name: "doc-1/00003.md"}}, 3)
+
+			failed, err := f.writePreview(context.Background(), "doc-1/preview.md.tmp", entries)
+			if err != nil {
+				t.Fatalf("writePreview() error = %v", err)
+			}
+			if !slices.Equal(failed, []int{3}) {
+				t.Errorf("writePreview() failed pages = %v, want [3]", failed)
+			}
+			if got, _ := store.Get("master", "doc-1/preview.md.tmp"); string(got) != tt.want {
+				t.Errorf("preview = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWritePreviewFailureLeavesNothing(t *testing.T) {
+	store := objectstore.NewMemory()
+	f := &AggregatorFunction{store: store, config: AggregatorConfig{TranslatedMarkdownBucket: "translated", AggregatedMarkdownBucket: "master"}}
+	entries := []pageObject{{StartPage: 1, EndPage: 1, Name: "doc-1/00001.md"}}
+
+	// The page was deleted after it was listed.
+	_, err := f.writePreview(context.Background(), "doc-1/preview.md.tmp", entries)
+	if err == nil {
+		t.Fatal("writePreview() succeeded without its page")
+	}
+	if _, ok := store.Get("master", "doc-1/preview.md.tmp"); ok {
+		t.Errorf("a truncated temporary preview was written")
+	}
+}
+
+func TestReplacePreview(t *testing.T) {
+	tests := []struct {
+		name        string
+		copyErr     error
+		wantErr     bool
+		wantPreview string
+	}{
+		{name: "replaced", wantPreview: "new preview"},
+		{name: "copy fails", copyErr: errors.New("permission denied"), wantErr: true, wantPreview: "old preview"},
+	}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory := objectstore.NewMemory()
+			memory.Put("master", "doc-1/preview.md", []byte("old preview"), nil)
+			memory.Put("master", "doc-1/preview.md.tmp-1", []byte("new preview"), nil)
+			var store objectstore.Client = memory
+			if tt.copyErr != nil {
+				store = failingCopyStore{Client: memory, err: tt.copyErr}
+			}
+			f := &AggregatorFunction{store: store, config: AggregatorConfig{AggregatedMarkdownBucket: "master"}}
+
+			err := f.replacePreview(context.Background(), logCtx, "doc-1/preview.md.tmp-1", "doc-1/preview.md")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("replacePreview() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && models.CodeOf(err) != models.ErrorCodeOutputWriteFailed {
+				t.Errorf("replacePreview() error code = %s, want %s", models.CodeOf(err), models.ErrorCodeOutputWriteFailed)
+			}
+			if got, _ := memory.Get("master", "doc-1/preview.md"); string(got) != tt.wantPreview {
+				t.Errorf("preview = %q, want %q", got, tt.wantPreview)
+			}
+			if _, ok := memory.Get("master", "doc-1/preview.md.tmp-1"); ok {
+				t.Errorf("temporary preview was not deleted")
+			}
+		})
+	}
+}
+
+func TestPreviewTempName(t *testing.T) {
+	first, err := previewTempName("doc-1/preview.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := previewTempName("doc-1/preview.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(first, "doc-1/preview.md.tmp-") || first == second {
+		t.Errorf("previewTempName() = %q, %q; want distinct names beside the preview", first, second)
+	}
+}
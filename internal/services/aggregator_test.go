@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+)
+
+// slowStore delays every read by delay(object), recording how many reads overlap.
+type slowStore struct {
+	objectstore.Client
+	delay        func(object string) time.Duration
+	active, peak atomic.Int32
+}
+
+func (s *slowStore) Bucket(name string) objectstore.Bucket {
+	return slowBucket{Bucket: s.Client.Bucket(name), store: s}
+}
+
+type slowBucket struct {
+	objectstore.Bucket
+	store *slowStore
+}
+
+func (b slowBucket) NewReader(ctx context.Context, object string) (objectstore.Reader, error) {
+	active := b.store.active.Add(1)
+	defer b.store.active.Add(-1)
+	for peak := b.store.peak.Load(); active > peak && !b.store.peak.CompareAndSwap(peak, active); peak = b.store.peak.Load() {
+	}
+	select {
+	case <-time.After(b.store.delay(object)):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return b.Bucket.NewReader(ctx, object)
+}
+
+func TestStartPrefetchKeepsPageOrder(t *testing.T) {
+	memory := objectstore.NewMemory()
+	var pages []pageObject
+	for page := 1; page <= 24; page++ {
+		name := fmt.Sprintf("doc/%05d.md", page)
+		content := fmt.Sprintf("page %d", page)
+		if page%5 == 0 {
+			content = strings.Repeat("x", 64) // Over the cap, so streamed rather than buffered.
+		}
+		memory.Put("translated", name, []byte(content), nil)
+		pages = append(pages, pageObject{StartPage: page, EndPage: page, Name: name})
+	}
+	// Later pages are read faster, so they finish before the ones the writer needs first.
+	store := &slowStore{Client: memory, delay: func(object string) time.Duration {
+		page, _ := pageNumberFromObjectName(object)
+		return time.Duration(25-page) * time.Millisecond / 2
+	}}
+	f := &AggregatorFunction{store: store, config: AggregatorConfig{
+		TranslatedMarkdownBucket: "translated",
+		Prefetch:                 4,
+		PrefetchMaxBytes:         32,
+	}}
+
+	results, release, stop := f.startPrefetch(context.Background(), pages)
+	defer stop()
+	for i, page := range pages {
+		<-results[i].done
+		if results[i].err != nil {
+			t.Fatalf("page %d: %v", page.StartPage, results[i].err)
+		}
+		oversize := page.StartPage%5 == 0
+		if results[i].buffered == oversize {
+			t.Errorf("page %d buffered = %v, want %v", page.StartPage, results[i].buffered, !oversize)
+		}
+		if want := fmt.Sprintf("page %d", page.StartPage); !oversize && string(results[i].content) != want {
+			t.Errorf("page %d content = %q, want %q", page.StartPage, results[i].content, want)
+		}
+		release(i)
+	}
+	if peak := store.peak.Load(); peak > 4 {
+		t.Errorf("%d reads overlapped, want at most Prefetch = 4", peak)
+	}
+}
+
+func TestStartPrefetchWaitsForTheWriter(t *testing.T) {
+	memory := objectstore.NewMemory()
+	var pages []pageObject
+	for page := 1; page <= 6; page++ {
+		name := fmt.Sprintf("doc/%05d.md", page)
+		memory.Put("translated", name, []byte("page"), nil)
+		pages = append(pages, pageObject{StartPage: page, EndPage: page, Name: name})
+	}
+	f := &AggregatorFunction{store: memory, config: AggregatorConfig{
+		TranslatedMarkdownBucket: "translated",
+		Prefetch:                 2,
+		PrefetchMaxBytes:         1024,
+	}}
+
+	results, release, stop := f.startPrefetch(context.Background(), pages)
+	<-results[0].done
+	<-results[1].done
+	// Nothing is released, so no third page may be read into memory.
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-results[2].done:
+		t.Errorf("page 3 was prefetched before a slot was released")
+	default:
+	}
+	release(0)
+	<-results[2].done
+
+	stop()
+	for i := 3; i < len(results); i++ {
+		<-results[i].done
+		if results[i].err == nil && !results[i].buffered {
+			t.Errorf("page %d neither fetched nor abandoned", i+1)
+		}
+	}
+}
+
+func TestReuseExistingOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		metadata map[string]string
+		missing  bool
+		want     bool
+		wantKept bool
+	}{
+		{name: "complete", content: "# Master", metadata: map[string]string{gcp.CompleteMetadataKey: "true"}, want: true, wantKept: true},
+		{name: "missing", missing: true},
+		{name: "left by a crashed writer", content: "# Mas"},
+		{name: "complete but empty", metadata: map[string]string{gcp.CompleteMetadataKey: "true"}},
+	}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := objectstore.NewMemory()
+			if !tt.missing {
+				store.Put("master", "doc-1/master.md", []byte(tt.content), tt.metadata)
+			}
+			f := &AggregatorFunction{store: store, config: AggregatorConfig{AggregatedMarkdownBucket: "master"}}
+
+			got, err := f.reuseExistingOutput(context.Background(), logCtx, "doc-1/master.md")
+			if err != nil {
+				t.Fatalf("reuseExistingOutput() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("reuseExistingOutput() = %v, want %v", got, tt.want)
+			}
+			if _, kept := store.Get("master", "doc-1/master.md"); kept != tt.wantKept {
+				t.Errorf("output kept = %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	store := objectstore.NewMemory()
+	for _, name := range []string{"doc-1/00001.md", "doc-1/00002.md", "doc-1/tables/t1.csv", "doc-10/00001.md"} {
+		store.Put("translated", name, []byte("x"), nil)
+	}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	deleted, err := deletePrefix(context.Background(), logCtx, store.Bucket("translated"), "doc-1/")
+	if err != nil {
+		t.Fatalf("deletePrefix() error = %v", err)
+	}
+	if deleted != 3 {
+		t.Errorf("deletePrefix() deleted %d objects, want 3", deleted)
+	}
+	if _, ok := store.Get("translated", "doc-10/00001.md"); !ok {
+		t.Errorf("object of another document sharing the prefix was deleted")
+	}
+}
@@ -0,0 +1,292 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/api/iterator"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Artifact classes produced by the pipeline for a document.
+const (
+	ArtifactClassSource       = "source"
+	ArtifactClassPagePDF      = "page_pdf"
+	ArtifactClassPageManifest = "page_manifest"
+	ArtifactClassPageImage    = "page_image"
+	ArtifactClassPageMarkdown = "page_markdown"
+	ArtifactClassTable        = "table"
+	ArtifactClassAudit        = "audit"
+	ArtifactClassMaster       = "master"
+	ArtifactClassMasterJSONL  = "master_jsonl"
+	ArtifactClassPreview      = "preview"
+	ArtifactClassDiff         = "diff"
+	ArtifactClassCleanedChunk = "cleaned_chunk"
+	ArtifactClassCleaned      = "cleaned"
+	ArtifactClassCleanReport  = "clean_report"
+	ArtifactClassSection      = "section"
+	ArtifactClassSectionIndex = "section_index"
+)
+
+// ArtifactBuckets names every bucket the pipeline writes to. Empty entries are buckets
+// that are not configured in the current environment.
+type ArtifactBuckets struct {
+	Uploads            string
+	SplitPages         string
+	Images             string
+	TranslatedMarkdown string
+	Audit              string
+	AggregatedMarkdown string
+	Diffs              string
+	CleanedMarkdown    string
+	FinalSections      string
+}
+
+// LoadArtifactBuckets reads the bucket names from the same environment variables the
+// individual stages use.
+func LoadArtifactBuckets() ArtifactBuckets {
+	aggregated := gcp.GetEnv("AGGREGATED_MARKDOWN_BUCKET", "")
+	return ArtifactBuckets{
+		Uploads:            gcp.GetEnv("UPLOADS_BUCKET", ""),
+		SplitPages:         gcp.GetEnv("SPLIT_PAGES_BUCKET", ""),
+		Images:             gcp.GetEnv("IMAGES_BUCKET", ""),
+		TranslatedMarkdown: gcp.GetEnv("TRANSLATED_MARKDOWN_BUCKET", ""),
+		Audit:              gcp.GetEnv("AUDIT_BUCKET", ""),
+		AggregatedMarkdown: aggregated,
+		Diffs:              gcp.GetEnv("DIFFS_BUCKET", aggregated),
+		CleanedMarkdown:    gcp.GetEnv("CLEANED_MARKDOWN_BUCKET", ""),
+		FinalSections:      gcp.GetEnv("FINAL_SECTIONS_BUCKET", ""),
+	}
+}
+
+// ArtifactLocation is where one class of artifact lives for a document.
+// When Exact is set, Prefix is a full object name rather than a prefix.
+type ArtifactLocation struct {
+	Class  string
+	Bucket string
+	Prefix string
+	Exact  bool
+	// match, when set, picks the objects of the class out of those under Prefix, which
+	// may hold other classes too.
+	match func(objectName string) bool
+}
+
+// Contains reports whether the object named objectName is an artifact of the location.
+func (l ArtifactLocation) Contains(objectName string) bool {
+	if l.Exact {
+		return objectName == l.Prefix
+	}
+	return strings.HasPrefix(objectName, l.Prefix) && (l.match == nil || l.match(objectName))
+}
+
+// Locations returns the location of every derived artifact class of doc, in pipeline
+// order. Translated pages, master files and sections are found where names puts them;
+// the other classes live under the document's root, as documentRoot gives it. The source
+// upload is excluded because its name comes from the document record; see
+// SourceLocation. Unconfigured buckets are omitted.
+func (b ArtifactBuckets) Locations(names *OutputNames, doc OutputDocument) ([]ArtifactLocation, error) {
+	pages, err := names.PageMatcher(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to match translated page names: %w", err)
+	}
+	master, err := names.Master(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to name master file: %w", err)
+	}
+	sections, err := names.SectionMatcher(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to match section names: %w", err)
+	}
+
+	root := documentRoot(doc.TenantID, doc.DocID)
+	all := []ArtifactLocation{
+		{Class: ArtifactClassPagePDF, Bucket: b.SplitPages, Prefix: root + "/", match: func(name string) bool { return path.Ext(name) == ".pdf" }},
+		{Class: ArtifactClassPageManifest, Bucket: b.SplitPages, Prefix: pageManifestObjectName(root), Exact: true},
+		{Class: ArtifactClassPageImage, Bucket: b.Images, Prefix: root + "/pages/"},
+		{Class: ArtifactClassPageMarkdown, Bucket: b.TranslatedMarkdown, Prefix: pages.Prefix, match: func(name string) bool {
+			_, _, ok := pages.Match(name)
+			return ok
+		}},
+		{Class: ArtifactClassTable, Bucket: b.TranslatedMarkdown, Prefix: tablesPrefix(root)},
+		// Audit records are named by document ID alone; see gcp.AuditRecord.
+		{Class: ArtifactClassAudit, Bucket: b.Audit, Prefix: doc.DocID + "/audit/"},
+		{Class: ArtifactClassMaster, Bucket: b.AggregatedMarkdown, Prefix: master, Exact: true},
+		{Class: ArtifactClassMasterJSONL, Bucket: b.AggregatedMarkdown, Prefix: jsonlObjectName(master), Exact: true},
+		{Class: ArtifactClassPreview, Bucket: b.AggregatedMarkdown, Prefix: previewObjectName(master), Exact: true},
+		{Class: ArtifactClassDiff, Bucket: b.Diffs, Prefix: root + "/diffs/"},
+		{Class: ArtifactClassCleanedChunk, Bucket: b.CleanedMarkdown, Prefix: cleanedChunksPrefix(root)},
+		{Class: ArtifactClassCleaned, Bucket: b.CleanedMarkdown, Prefix: master, Exact: true},
+		{Class: ArtifactClassCleanReport, Bucket: b.CleanedMarkdown, Prefix: root + "/" + cleanReportName, Exact: true},
+		{Class: ArtifactClassSection, Bucket: b.FinalSections, Prefix: sections.Prefix, match: sections.Match},
+		{Class: ArtifactClassSectionIndex, Bucket: b.FinalSections, Prefix: root + "/" + sectionManifestName, Exact: true},
+	}
+	locations := make([]ArtifactLocation, 0, len(all))
+	for _, loc := range all {
+		if loc.Bucket != "" {
+			locations = append(locations, loc)
+		}
+	}
+	return locations, nil
+}
+
+// locationObjects returns the attributes of every object of loc in bucket. An exact
+// location whose object does not exist has none.
+func locationObjects(ctx context.Context, bucket objectstore.Bucket, loc ArtifactLocation) ([]*storage.ObjectAttrs, error) {
+	if loc.Exact {
+		attrs, err := bucket.Attrs(ctx, loc.Prefix)
+		if errors.Is(err, objectstore.ErrObjectNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gs://%s/%s: %w", bucket.Name(), loc.Prefix, err)
+		}
+		return []*storage.ObjectAttrs{attrs}, nil
+	}
+	listed, err := bucket.List(ctx, loc.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list gs://%s/%s: %w", bucket.Name(), loc.Prefix, err)
+	}
+	objects := listed[:0]
+	for _, attrs := range listed {
+		if loc.Contains(attrs.Name) {
+			objects = append(objects, attrs)
+		}
+	}
+	return objects, nil
+}
+
+// SourceLocation returns the location of the original upload for a document, stored as
+// sourceObject.
+func (b ArtifactBuckets) SourceLocation(sourceObject string) (ArtifactLocation, bool) {
+	if b.Uploads == "" || sourceObject == "" {
+		return ArtifactLocation{}, false
+	}
+	return ArtifactLocation{Class: ArtifactClassSource, Bucket: b.Uploads, Prefix: sourceObject, Exact: true}, true
+}
+
+// ListArtifacts enumerates every object the pipeline produced for a document, one page
+// of results per artifact class. When class is set only that class is listed, which is
+// how a caller follows a group's nextPageToken.
+func (f *StatusFunction) ListArtifacts(ctx context.Context, req *models.ArtifactListRequest) (*models.ArtifactListResponse, error) {
+	logCtx := slog.With("documentId", req.DocumentID)
+
+	doc, err := f.getDocument(ctx, req.DocumentID, req.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	locations, err := f.documentLocations(req.DocumentID, doc)
+	if err != nil {
+		logCtx.Error("Failed to locate document artifacts", "error", err)
+		return nil, err
+	}
+	sourceObject := doc.SourceObject
+	if sourceObject == "" {
+		// Older documents do not record their source object, which was named as the file.
+		sourceObject = doc.OriginalFilename
+	}
+	if source, ok := f.config.Buckets.SourceLocation(sourceObject); ok {
+		locations = append([]ArtifactLocation{source}, locations...)
+	}
+
+	pageSize := req.PageSize
+	if pageSize <= 0 || pageSize > maxArtifactPageSize {
+		pageSize = defaultArtifactPageSize
+	}
+
+	// A page token belongs to a single group, so it is only honoured with a class filter.
+	pageToken := ""
+	if req.Class != "" {
+		pageToken = req.PageToken
+	}
+
+	res := &models.ArtifactListResponse{DocumentID: req.DocumentID}
+	for _, loc := range locations {
+		if req.Class != "" && loc.Class != req.Class {
+			continue
+		}
+		group := f.listLocation(ctx, logCtx, loc, pageSize, pageToken, req.Signed)
+		res.Groups = append(res.Groups, group)
+	}
+	logCtx.Info("Listed document artifacts.", "groupCount", len(res.Groups), "class", req.Class)
+	return res, nil
+}
+
+const (
+	defaultArtifactPageSize = 200
+	maxArtifactPageSize     = 1000
+)
+
+// listLocation lists one page of a single artifact location. A location sharing its
+// prefix with other classes filters their objects out of the page, which may then hold
+// fewer than pageSize artifacts. Missing or inaccessible buckets are reported on the
+// group rather than failing the whole listing.
+func (f *StatusFunction) listLocation(ctx context.Context, logCtx *slog.Logger, loc ArtifactLocation, pageSize int, pageToken string, signed bool) models.ArtifactGroup {
+	group := models.ArtifactGroup{Class: loc.Class, Bucket: loc.Bucket, Artifacts: []models.Artifact{}}
+	bucket := f.storageClient.Bucket(loc.Bucket)
+
+	var attrsList []*storage.ObjectAttrs
+	if loc.Exact {
+		attrs, err := bucket.Object(loc.Prefix).Attrs(ctx)
+		switch {
+		case err == nil:
+			attrsList = append(attrsList, attrs)
+		case errors.Is(err, storage.ErrObjectNotExist):
+		default:
+			group.Error = f.describeListError(logCtx, loc, err)
+			return group
+		}
+	} else {
+		pager := iterator.NewPager(bucket.Objects(ctx, &storage.Query{Prefix: loc.Prefix}), pageSize, pageToken)
+		nextToken, err := pager.NextPage(&attrsList)
+		if err != nil {
+			group.Error = f.describeListError(logCtx, loc, err)
+			return group
+		}
+		group.NextPageToken = nextToken
+	}
+
+	for _, attrs := range attrsList {
+		if !loc.Contains(attrs.Name) {
+			continue
+		}
+		artifact := models.Artifact{
+			URI:         fmt.Sprintf("gs://%s/%s", attrs.Bucket, attrs.Name),
+			Class:       loc.Class,
+			Size:        attrs.Size,
+			Updated:     attrs.Updated,
+			ContentType: attrs.ContentType,
+		}
+		if signed {
+			url, err := bucket.SignedURL(attrs.Name, &storage.SignedURLOptions{
+				Method:  "GET",
+				Expires: time.Now().Add(f.config.SignedURLTTL),
+			})
+			if err != nil {
+				logCtx.Warn("Failed to sign artifact URL; returning gs:// URI only.", "error", err, "gcsObject", attrs.Name)
+			} else {
+				artifact.SignedURL = url
+			}
+		}
+		group.Artifacts = append(group.Artifacts, artifact)
+	}
+	return group
+}
+
+func (f *StatusFunction) describeListError(logCtx *slog.Logger, loc ArtifactLocation, err error) string {
+	if errors.Is(err, storage.ErrBucketNotExist) {
+		logCtx.Warn("Artifact bucket does not exist in this environment.", "bucket", loc.Bucket, "class", loc.Class)
+		return "bucket does not exist"
+	}
+	logCtx.Error("Failed to list artifacts", "error", err, "bucket", loc.Bucket, "prefix", loc.Prefix, "class", loc.Class)
+	return err.Error()
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+var testArtifactBuckets = ArtifactBuckets{
+	SplitPages:         "split",
+	Images:             "images",
+	TranslatedMarkdown: "translated",
+	Audit:              "audit",
+	AggregatedMarkdown: "aggregated",
+	Diffs:              "aggregated",
+	CleanedMarkdown:    "cleaned",
+	FinalSections:      "sections",
+}
+
+// seedArtifacts writes one or two objects of every artifact class of doc, named as the
+// stages name them, and returns the names written per class.
+func seedArtifacts(t *testing.T, store *objectstore.Memory, buckets ArtifactBuckets, names *OutputNames, doc OutputDocument) map[string][]string {
+	t.Helper()
+	root := documentRoot(doc.TenantID, doc.DocID)
+	must := func(name string, err error) string {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	master := must(names.Master(doc))
+	seeded := map[string][]string{
+		ArtifactClassPagePDF:      {pageRangeObjectName(root, 1, 1, "pdf"), chapterObjectName(root, 1, "Scope")},
+		ArtifactClassPageManifest: {pageManifestObjectName(root)},
+		ArtifactClassPageImage:    {pageImageObjectName(root, 1, 1, "png")},
+		ArtifactClassPageMarkdown: {must(names.Page(doc, 1, 1)), must(names.Page(doc, 2, 3))},
+		ArtifactClassTable:        {pageTablesPrefix(root, 2, 3) + "1.csv"},
+		ArtifactClassAudit:        {doc.DocID + "/audit/translator/00001-20260101T000000.000000000Z.json"},
+		ArtifactClassMaster:       {master},
+		ArtifactClassMasterJSONL:  {jsonlObjectName(master)},
+		ArtifactClassPreview:      {previewObjectName(master)},
+		ArtifactClassDiff:         {root + "/diffs/00001.diff", root + "/diffs/" + revisionDiffReportName},
+		ArtifactClassCleanedChunk: {cleanedChunkObjectName(root, 0)},
+		ArtifactClassCleaned:      {master},
+		ArtifactClassCleanReport:  {root + "/" + cleanReportName},
+		ArtifactClassSection:      {must(names.Section(doc, "1_scope", "1_scope", 1)), must(names.Section(doc, "2_design/2.1_loads", "2.1_loads", 2))},
+		ArtifactClassSectionIndex: {root + "/" + sectionManifestName},
+	}
+	bucketOf := map[string]string{
+		ArtifactClassPagePDF:      buckets.SplitPages,
+		ArtifactClassPageManifest: buckets.SplitPages,
+		ArtifactClassPageImage:    buckets.Images,
+		ArtifactClassPageMarkdown: buckets.TranslatedMarkdown,
+		ArtifactClassTable:        buckets.TranslatedMarkdown,
+		ArtifactClassAudit:        buckets.Audit,
+		ArtifactClassMaster:       buckets.AggregatedMarkdown,
+		ArtifactClassMasterJSONL:  buckets.AggregatedMarkdown,
+		ArtifactClassPreview:      buckets.AggregatedMarkdown,
+		ArtifactClassDiff:         buckets.Diffs,
+		ArtifactClassCleanedChunk: buckets.CleanedMarkdown,
+		ArtifactClassCleaned:      buckets.CleanedMarkdown,
+		ArtifactClassCleanReport:  buckets.CleanedMarkdown,
+		ArtifactClassSection:      buckets.FinalSections,
+		ArtifactClassSectionIndex: buckets.FinalSections,
+	}
+	for class, objects := range seeded {
+		for _, object := range objects {
+			store.Put(bucketOf[class], object, []byte(class), nil)
+		}
+		sort.Strings(objects)
+	}
+	return seeded
+}
+
+// listArtifacts returns the names of the objects of every location in store, per class.
+func listArtifacts(t *testing.T, store objectstore.Client, locations []ArtifactLocation) map[string][]string {
+	t.Helper()
+	found := make(map[string][]string)
+	for _, loc := range locations {
+		objects, err := locationObjects(context.Background(), store.Bucket(loc.Bucket), loc)
+		if err != nil {
+			t.Fatalf("locationObjects(%s) error = %v", loc.Class, err)
+		}
+		for _, attrs := range objects {
+			found[loc.Class] = append(found[loc.Class], attrs.Name)
+		}
+	}
+	for _, objects := range found {
+		sort.Strings(objects)
+	}
+	return found
+}
+
+// artifactTemplates are the output name layouts the artifact locations are tested with.
+var artifactTemplates = []struct {
+	name                  string
+	page, master, section string
+}{
+	{name: "default", page: defaultPageTemplate, master: defaultMasterTemplate, section: defaultSectionTemplate},
+	{
+		name:    "named after the upload",
+		page:    `{{.FilenameSlug}}/pages/{{pad .StartPage 4}}{{if ne .StartPage .EndPage}}_{{pad .EndPage 4}}{{end}}.md`,
+		master:  `{{.DocID}}/{{.FilenameSlug}}.md`,
+		section: `{{.FilenameSlug}}/{{pad .Order 3}}-{{.Title}}.md`,
+	},
+}
+
+func TestArtifactLocationsFindEveryArtifact(t *testing.T) {
+	for _, tt := range artifactTemplates {
+		for _, tenantID := range []string{"", "unit-a"} {
+			t.Run(tt.name+"/tenant="+tenantID, func(t *testing.T) {
+				names, err := newOutputNames(tt.page, tt.master, tt.section)
+				if err != nil {
+					t.Fatal(err)
+				}
+				doc := OutputDocument{DocID: "doc-1", TenantID: tenantID, OriginalFilename: "Pump Manual", FilenameSlug: "pump-manual"}
+				other := OutputDocument{DocID: "doc-2", TenantID: tenantID, OriginalFilename: "Valve Manual", FilenameSlug: "valve-manual"}
+				store := objectstore.NewMemory()
+				want := seedArtifacts(t, store, testArtifactBuckets, names, doc)
+				seedArtifacts(t, store, testArtifactBuckets, names, other)
+
+				locations, err := testArtifactBuckets.Locations(names, doc)
+				if err != nil {
+					t.Fatalf("Locations() error = %v", err)
+				}
+				if got := listArtifacts(t, store, locations); !reflect.DeepEqual(got, want) {
+					t.Errorf("artifacts =\n%v\nwant\n%v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestArtifactLocationsOmitUnconfiguredBuckets(t *testing.T) {
+	buckets := ArtifactBuckets{AggregatedMarkdown: "aggregated"}
+	locations, err := buckets.Locations(nil, OutputDocument{DocID: "doc-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var classes []string
+	for _, loc := range locations {
+		classes = append(classes, loc.Class)
+	}
+	want := []string{ArtifactClassMaster, ArtifactClassMasterJSONL, ArtifactClassPreview}
+	if !reflect.DeepEqual(classes, want) {
+		t.Errorf("classes = %v, want %v", classes, want)
+	}
+}
+
+func TestPurgeArtifacts(t *testing.T) {
+	names, err := newOutputNames(artifactTemplates[1].page, artifactTemplates[1].master, artifactTemplates[1].section)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &models.Document{TenantID: "unit-a", OriginalFilename: "Pump Manual.pdf", FilenameSlug: "pump-manual"}
+	doc, err := names.DocumentFor("doc-1", record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := objectstore.NewMemory()
+	seeded := seedArtifacts(t, store, testArtifactBuckets, names, doc)
+
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	purged, err := purgeArtifacts(context.Background(), logCtx, store, testArtifactBuckets, names, "doc-1", record, purgedArtifactClasses)
+	if err != nil {
+		t.Fatalf("purgeArtifacts() error = %v", err)
+	}
+
+	locations, err := testArtifactBuckets.Locations(names, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	left := listArtifacts(t, store, locations)
+	wantPurged := 0
+	for class, objects := range seeded {
+		if purgedArtifactClasses[class] {
+			wantPurged += len(objects)
+			if len(left[class]) > 0 {
+				t.Errorf("%s objects left after the purge: %v", class, left[class])
+			}
+		} else if !reflect.DeepEqual(left[class], objects) {
+			t.Errorf("%s objects after the purge = %v, want %v", class, left[class], objects)
+		}
+	}
+	if purged != wantPurged {
+		t.Errorf("purgeArtifacts() = %d, want %d", purged, wantPurged)
+	}
+}
+
+func TestIntermediateLocations(t *testing.T) {
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	shared := testArtifactBuckets
+	shared.CleanedMarkdown = shared.AggregatedMarkdown
+	tests := []struct {
+		name    string
+		buckets ArtifactBuckets
+		want    []string
+	}{
+		{
+			name:    "separate buckets",
+			buckets: testArtifactBuckets,
+			want:    []string{ArtifactClassPagePDF, ArtifactClassPageManifest, ArtifactClassPageMarkdown, ArtifactClassMaster, ArtifactClassMasterJSONL, ArtifactClassPreview},
+		},
+		{
+			name:    "aggregated markdown shares the cleaned markdown bucket",
+			buckets: shared,
+			want:    []string{ArtifactClassPagePDF, ArtifactClassPageManifest, ArtifactClassPageMarkdown},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			locations, err := intermediateLocations(logCtx, tt.buckets, nil, OutputDocument{DocID: "doc-1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var classes []string
+			for _, loc := range locations {
+				classes = append(classes, loc.Class)
+			}
+			if !reflect.DeepEqual(classes, tt.want) {
+				t.Errorf("classes = %v, want %v", classes, tt.want)
+			}
+		})
+	}
+}
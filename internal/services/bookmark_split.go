@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"golang.org/x/sync/errgroup"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// loadSplitMode reads SPLIT_MODE. The bookmark mode hands chapters to a workflow, so it
+// is not available in the Pub/Sub orchestration mode.
+func loadSplitMode(orchestration OrchestrationConfig) (string, error) {
+	mode := gcp.GetEnv("SPLIT_MODE", models.SplitModePage)
+	switch mode {
+	case models.SplitModePage:
+	case models.SplitModeBookmark:
+		if orchestration.PubSub() {
+			return "", fmt.Errorf("SPLIT_MODE %q requires ORCHESTRATION_MODE %q", models.SplitModeBookmark, models.OrchestrationModeWorkflows)
+		}
+	default:
+		return "", fmt.Errorf("SPLIT_MODE must be %q or %q, got %q", models.SplitModePage, models.SplitModeBookmark, mode)
+	}
+	return mode, nil
+}
+
+// chapterObjectName returns the name of the split file of the index-th chapter of the
+// document under root: "{root}/chapters/003_installation.pdf".
+func chapterObjectName(root string, index int, title string) string {
+	return fmt.Sprintf("%s/chapters/%03d_%s.pdf", root, index, models.Slug(title))
+}
+
+// chaptersFor returns the chapters the PDF at path is split into in the bookmark mode, or
+// nil when it is split into pages: in the page mode, or when its outline is not usable,
+// which is logged. The outline is read as a bundle's is, so one whose only top-level
+// entry is the document itself gives its chapters one level down.
+func (f *PDFSplitterFunction) chaptersFor(logCtx *slog.Logger, path string, pageCount int) []models.Chapter {
+	if f.config.SplitMode != models.SplitModeBookmark {
+		return nil
+	}
+	parts, err := bookmarkBundleParts(path, pageCount)
+	reason := ""
+	switch {
+	case err != nil:
+		reason = err.Error()
+	case len(parts) == 0:
+		reason = "the PDF has no outline entries pointing at its pages"
+	case len(parts) == 1:
+		reason = "the PDF's outline has a single top-level entry"
+	}
+	if reason != "" {
+		logCtx.Info("Falling back to splitting into pages.", "splitMode", models.SplitModeBookmark, "reason", reason)
+		return nil
+	}
+	chapters := make([]models.Chapter, len(parts))
+	for i, part := range parts {
+		title := part.Title
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", i+1)
+		}
+		chapters[i] = models.Chapter{
+			Index:     i + 1,
+			Title:     title,
+			StartPage: part.StartPage,
+			EndPage:   part.EndPage,
+		}
+	}
+	return chapters
+}
+
+// splitByBookmarks splits the PDF at path into a file per chapter, uploads them, records
+// the chapters on the document and starts its workflow over them. It returns what handOff
+// does. Chapters are few and large, so a chapter that fails to upload fails the document
+// rather than being left for the retranslator.
+func (f *PDFSplitterFunction) splitByBookmarks(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, path string, pageCount int, peak int64, chapters []models.Chapter, fileHash string, sourceGeneration int64, chunkBytes int) error {
+	for i := range chapters {
+		chapters[i].GCSUri = fmt.Sprintf("gs://%s/%s", f.config.SplitPagesBucket, chapterObjectName(f.root(docRef.ID), chapters[i].Index, chapters[i].Title))
+	}
+	logCtx.Info("Splitting PDF by bookmark.", "chapterCount", len(chapters))
+
+	eg, gctx := errgroup.WithContext(ctx)
+	eg.SetLimit(4)
+	for _, chapter := range chapters {
+		localPath := filepath.Join(filepath.Dir(path), fmt.Sprintf("chapter-%03d.pdf", chapter.Index))
+		eg.Go(func() error {
+			defer removeScratchFile(logCtx, localPath)
+			if err := api.TrimFile(path, localPath, []string{fmt.Sprintf("%d-%d", chapter.StartPage, chapter.EndPage)}, nil); err != nil {
+				return fmt.Errorf("failed to extract chapter %d (%s): %w", chapter.Index, describePages(chapter.StartPage, chapter.EndPage), err)
+			}
+			_, objectName, err := gcp.ParseGCSURI(chapter.GCSUri)
+			if err != nil {
+				return err
+			}
+			if err := f.uploadFile(gctx, localPath, objectName, chunkBytes); err != nil {
+				return fmt.Errorf("chapter %d: %w", chapter.Index, err)
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return f.handleError(ctx, logCtx, docRef, "failed to split PDF by bookmark", err)
+	}
+	removeScratchFile(logCtx, path)
+
+	updates := []firestore.Update{
+		{Path: "pageCount", Value: pageCount},
+		{Path: "splitMode", Value: models.SplitModeBookmark},
+		{Path: "chapters", Value: chapters},
+	}
+	if peak > 0 {
+		updates = append(updates, firestore.Update{Path: "peakFileSizeBytes", Value: peak})
+	}
+	if err := gcp.AppendStatusTransition(ctx, docRef, "SPLITTING", models.TimingStageSplitter, "", updates...); err != nil {
+		return f.handleError(ctx, logCtx, docRef, "failed to update status to SPLITTING", err)
+	}
+	logCtx.Info("PDF split by bookmark.", "pageCount", pageCount, "chapterCount", len(chapters))
+
+	if err := f.cancellation.Check(ctx, logCtx, docRef.ID); err != nil {
+		recordCancelled(ctx, logCtx, f.firestoreClient, f.config.CollectionName, docRef.ID, models.TimingStageSplitter)
+		return err
+	}
+	manifest := chapterPageManifest(f.tenantID, docRef.ID, pageCount, chapters)
+	if err := createPageRecords(ctx, f.firestoreClient, f.config.CollectionName, docRef.ID, pageCount, manifest.GCSUriFor, nil); err != nil {
+		return f.handleError(ctx, logCtx, docRef, "failed to create page records", err)
+	}
+	order, err := f.config.ProcessingOrder.Order(len(chapters), fileHash)
+	if err != nil {
+		return f.handleError(ctx, logCtx, docRef, "failed to compute processing order", err)
+	}
+	logCtx.Info("Triggering workflow.", "processingOrder", f.config.ProcessingOrder.Strategy, "chapterCount", len(order))
+	payload, err := chapterWorkflowPayload(ctx, f.store.Bucket(f.config.SplitPagesBucket), manifest, chapters, sourceGeneration, order, f.config.InlinePagesMax)
+	if err != nil {
+		return f.workflowNotStarted(ctx, logCtx, docRef, err)
+	}
+	parent := workflowParent(f.config.ProjectID, f.config.WorkflowLocation, f.config.WorkflowID)
+	execution, err := startWorkflowExecutionWithRetry(ctx, logCtx, f.executionsClient, parent, payload, f.config.WorkflowRetry)
+	if err != nil {
+		return f.workflowNotStarted(ctx, logCtx, docRef, err)
+	}
+	update := firestore.Update{Path: "workflowExecutionId", Value: execution.GetName()}
+	if err := gcp.AppendStatusTransition(ctx, docRef, "TRANSLATING", models.TimingStageSplitter, "", update); err != nil {
+		// The workflow is already running, so failing here would only trigger a duplicate.
+		logCtx.Error("Workflow triggered but the execution ID could not be recorded", "error", err, "executionName", execution.GetName())
+		return nil
+	}
+	logCtx.Info("Hand-off to workflow complete.", "executionName", execution.GetName())
+	return nil
+}
+
+// chapterPageManifest returns the manifest of a document split by bookmark, which names
+// each page's chapter file.
+func chapterPageManifest(tenantID, docID string, pageCount int, chapters []models.Chapter) *models.PageManifest {
+	manifest := &models.PageManifest{DocumentID: docID, TenantID: tenantID, PageCount: pageCount, Pages: make([]models.PageURI, 0, pageCount)}
+	for _, chapter := range chapters {
+		for pageNumber := chapter.StartPage; pageNumber <= chapter.EndPage; pageNumber++ {
+			manifest.Pages = append(manifest.Pages, models.PageURI{PageNumber: pageNumber, GCSUri: chapter.GCSUri})
+		}
+	}
+	return manifest
+}
+
+// chapterWorkflowPayload builds the argument of a processing workflow execution over the
+// chapters of a document split by bookmark, with order over 1-based indices into them.
+// Pages are listed, or passed by manifest, as workflowPayload does.
+func chapterWorkflowPayload(ctx context.Context, splitPages objectstore.Bucket, manifest *models.PageManifest, chapters []models.Chapter, sourceGeneration int64, order []int, inlinePagesMax int) (*models.WorkflowPayload, error) {
+	payload, err := workflowPayload(ctx, splitPages, manifest, sourceGeneration, order, inlinePagesMax)
+	if err != nil {
+		return nil, err
+	}
+	payload.SplitMode = models.SplitModeBookmark
+	payload.Chapters = chapters
+	return payload, nil
+}
+
+// documentSplitFiles returns the manifest of the files a document was split into and how
+// many units its workflow dispatches: its chapters when it was split by bookmark,
+// otherwise its chunks.
+func documentSplitFiles(splitPagesBucket, docID string, doc *models.Document) (*models.PageManifest, int) {
+	if doc.SplitMode == models.SplitModeBookmark {
+		return chapterPageManifest(doc.TenantID, docID, doc.PageCount, doc.Chapters), len(doc.Chapters)
+	}
+	chunkSize := max(doc.ChunkSize, 1)
+	return splitPageManifest(splitPagesBucket, doc.TenantID, docID, doc.PageCount, chunkSize), len(pageChunks(doc.PageCount, chunkSize))
+}
+
+// documentUnits returns the pages of each split file of doc, in the order the workflow
+// payload lists them.
+func documentUnits(doc *models.Document) []pageChunk {
+	if doc.SplitMode == models.SplitModeBookmark {
+		units := make([]pageChunk, len(doc.Chapters))
+		for i, chapter := range doc.Chapters {
+			units[i] = pageChunk{StartPage: chapter.StartPage, EndPage: chapter.EndPage}
+		}
+		return units
+	}
+	return pageChunks(doc.PageCount, max(doc.ChunkSize, 1))
+}
+
+// documentWorkflowPayload builds the argument of a workflow execution over the split
+// files of doc, whose manifest documentSplitFiles returned.
+func documentWorkflowPayload(ctx context.Context, splitPages objectstore.Bucket, manifest *models.PageManifest, doc *models.Document, order []int, inlinePagesMax int) (*models.WorkflowPayload, error) {
+	if doc.SplitMode == models.SplitModeBookmark {
+		return chapterWorkflowPayload(ctx, splitPages, manifest, doc.Chapters, doc.SourceGeneration, order, inlinePagesMax)
+	}
+	return workflowPayload(ctx, splitPages, manifest, doc.SourceGeneration, order, inlinePagesMax)
+}
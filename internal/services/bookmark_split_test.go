@@ -0,0 +1,150 @@
+package services
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// bookmarkedPDF writes a fixture PDF of pageCount pages with outline bookmarks and
+// returns its path. No bookmarks leaves the PDF without an outline.
+func bookmarkedPDF(t *testing.T, pageCount int, bookmarks []pdfcpu.Bookmark) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "upload.pdf")
+	if err := os.WriteFile(path, testsupport.FixturePDF(pageCount), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if len(bookmarks) == 0 {
+		return path
+	}
+	outPath := filepath.Join(dir, "bookmarked.pdf")
+	if err := api.AddBookmarksFile(path, outPath, bookmarks, true, nil); err != nil {
+		t.Fatalf("failed to add bookmarks: %v", err)
+	}
+	return outPath
+}
+
+func TestChaptersFor(t *testing.T) {
+	tests := []struct {
+		name      string
+		pageCount int
+		bookmarks []pdfcpu.Bookmark
+		want      []models.Chapter
+	}{
+		{
+			name:      "last chapter runs to the end of the file",
+			pageCount: 8,
+			bookmarks: []pdfcpu.Bookmark{{Title: "Introduction", PageFrom: 1}, {Title: "Installation", PageFrom: 3}, {Title: "Maintenance", PageFrom: 6}},
+			want: []models.Chapter{
+				{Index: 1, Title: "Introduction", StartPage: 1, EndPage: 2},
+				{Index: 2, Title: "Installation", StartPage: 3, EndPage: 5},
+				{Index: 3, Title: "Maintenance", StartPage: 6, EndPage: 8},
+			},
+		},
+		{
+			name:      "pages before the first bookmark belong to the first chapter",
+			pageCount: 6,
+			bookmarks: []pdfcpu.Bookmark{{Title: "Scope", PageFrom: 3}, {Title: "Loads", PageFrom: 5}},
+			want: []models.Chapter{
+				{Index: 1, Title: "Scope", StartPage: 1, EndPage: 4},
+				{Index: 2, Title: "Loads", StartPage: 5, EndPage: 6},
+			},
+		},
+		{
+			name:      "nested bookmarks split at the top level only",
+			pageCount: 6,
+			bookmarks: []pdfcpu.Bookmark{
+				{Title: "Scope", PageFrom: 1, Kids: []pdfcpu.Bookmark{{Title: "Exclusions", PageFrom: 2}}},
+				{Title: "Loads", PageFrom: 4, Kids: []pdfcpu.Bookmark{{Title: "Dead loads", PageFrom: 4}, {Title: "Live loads", PageFrom: 5}}},
+			},
+			want: []models.Chapter{
+				{Index: 1, Title: "Scope", StartPage: 1, EndPage: 3},
+				{Index: 2, Title: "Loads", StartPage: 4, EndPage: 6},
+			},
+		},
+		{
+			name:      "a single top-level entry gives its children",
+			pageCount: 6,
+			bookmarks: []pdfcpu.Bookmark{
+				{Title: "Pump Manual", PageFrom: 1, Kids: []pdfcpu.Bookmark{{Title: "Safety", PageFrom: 1}, {Title: "Operation", PageFrom: 3}}},
+			},
+			want: []models.Chapter{
+				{Index: 1, Title: "Safety", StartPage: 1, EndPage: 2},
+				{Index: 2, Title: "Operation", StartPage: 3, EndPage: 6},
+			},
+		},
+		{
+			name:      "bookmarks on the same page overlap and the first is kept",
+			pageCount: 5,
+			bookmarks: []pdfcpu.Bookmark{{Title: "Scope", PageFrom: 1}, {Title: "Loads", PageFrom: 3}, {Title: "Load table", PageFrom: 3}},
+			want: []models.Chapter{
+				{Index: 1, Title: "Scope", StartPage: 1, EndPage: 2},
+				{Index: 2, Title: "Loads", StartPage: 3, EndPage: 5},
+			},
+		},
+		{
+			name:      "untitled bookmarks are numbered",
+			pageCount: 4,
+			bookmarks: []pdfcpu.Bookmark{{Title: " ", PageFrom: 1}, {Title: "Annex", PageFrom: 3}},
+			want: []models.Chapter{
+				{Index: 1, Title: "Chapter 1", StartPage: 1, EndPage: 2},
+				{Index: 2, Title: "Annex", StartPage: 3, EndPage: 4},
+			},
+		},
+		{
+			name:      "no bookmarks falls back to pages",
+			pageCount: 3,
+		},
+		{
+			name:      "a single chapter falls back to pages",
+			pageCount: 3,
+			bookmarks: []pdfcpu.Bookmark{{Title: "Pump Manual", PageFrom: 1}},
+		},
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	f := &PDFSplitterFunction{config: PDFSplitterConfig{SplitMode: models.SplitModeBookmark}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := bookmarkedPDF(t, tt.pageCount, tt.bookmarks)
+			if got := f.chaptersFor(logger, path, tt.pageCount); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chaptersFor() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChaptersForPageMode(t *testing.T) {
+	path := bookmarkedPDF(t, 4, []pdfcpu.Bookmark{{Title: "Scope", PageFrom: 1}, {Title: "Loads", PageFrom: 3}})
+	f := &PDFSplitterFunction{config: PDFSplitterConfig{SplitMode: models.SplitModePage}}
+	if got := f.chaptersFor(slog.New(slog.NewTextHandler(io.Discard, nil)), path, 4); got != nil {
+		t.Errorf("chaptersFor() in the page mode = %+v, want nil", got)
+	}
+}
+
+func TestChapterObjectName(t *testing.T) {
+	tests := []struct {
+		index int
+		title string
+		want  string
+	}{
+		{index: 3, title: "Installation & Setup", want: "doc-1/chapters/003_installation-setup.pdf"},
+		{index: 4, title: "Installation & Setup", want: "doc-1/chapters/004_installation-setup.pdf"},
+		{index: 5, title: "Installation: setup", want: "doc-1/chapters/005_installation-setup.pdf"},
+		{index: 6, title: "Руководство", want: "doc-1/chapters/006_руководство.pdf"},
+		{index: 7, title: "***", want: "doc-1/chapters/007_untitled.pdf"},
+	}
+	for _, tt := range tests {
+		if got := chapterObjectName("doc-1", tt.index, tt.title); got != tt.want {
+			t.Errorf("chapterObjectName(%d, %q) = %q, want %q", tt.index, tt.title, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// bundleMetadataKey is the custom metadata key uploaders set to "true" on a PDF that
+// bundles several distinct documents, such as a datasheet, a manual and a certificate.
+const bundleMetadataKey = "bundle"
+
+// StatusBundleSplit is recorded on a bundle's document once it has been split into one
+// document per bundled document, each processed by its own workflow.
+const StatusBundleSplit = "BUNDLE_SPLIT"
+
+// Values of Document.BundleSplitMethod.
+const (
+	BundleSplitBookmarks = "bookmarks"
+	BundleSplitLLM       = "llm"
+)
+
+// BundleConfig controls how the documents in a bundle upload are found.
+type BundleConfig struct {
+	DetectionPages int // Leading pages sent to Gemini when the bundle has no usable bookmarks.
+	Retry          GeminiRetryConfig
+}
+
+// loadBundleConfig reads BUNDLE_DETECTION_PAGES and the Gemini retry settings.
+func loadBundleConfig() (BundleConfig, error) {
+	detectionPages, err := strconv.Atoi(gcp.GetEnv("BUNDLE_DETECTION_PAGES", "10"))
+	if err != nil || detectionPages < 1 {
+		return BundleConfig{}, fmt.Errorf("BUNDLE_DETECTION_PAGES must be a positive integer")
+	}
+	retry, err := loadGeminiRetryConfig()
+	if err != nil {
+		return BundleConfig{}, err
+	}
+	return BundleConfig{DetectionPages: detectionPages, Retry: retry}, nil
+}
+
+// isBundleUpload reports whether the upload's metadata marks it as a bundle.
+func isBundleUpload(metadata map[string]string) bool {
+	bundle, _ := strconv.ParseBool(strings.TrimSpace(metadata[bundleMetadataKey]))
+	return bundle
+}
+
+// bundleBoundary is where one bundled document starts, as a bookmark or Gemini gives it.
+type bundleBoundary struct {
+	Title     string `json:"title"`
+	StartPage int    `json:"startPage"`
+}
+
+// bundlePart is the inclusive range of a bundle's pages that holds one document.
+type bundlePart struct {
+	Title     string
+	StartPage int
+	EndPage   int
+}
+
+// subDocumentID returns the ID of the index-th document split from the bundle with
+// fileHash.
+func subDocumentID(fileHash string, index int) string {
+	return fmt.Sprintf("%s-%d", fileHash, index)
+}
+
+// bundleParts turns boundaries into consecutive parts covering all pageCount pages.
+// Boundaries outside the document and repeated start pages are dropped. Pages before the
+// first boundary, such as a cover sheet, belong to the first part.
+func bundleParts(boundaries []bundleBoundary, pageCount int) []bundlePart {
+	sort.SliceStable(boundaries, func(i, j int) bool { return boundaries[i].StartPage < boundaries[j].StartPage })
+	var parts []bundlePart
+	for _, boundary := range boundaries {
+		if boundary.StartPage < 1 || boundary.StartPage > pageCount {
+			continue
+		}
+		if len(parts) > 0 && parts[len(parts)-1].StartPage == boundary.StartPage {
+			continue
+		}
+		parts = append(parts, bundlePart{Title: strings.TrimSpace(boundary.Title), StartPage: boundary.StartPage})
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	parts[0].StartPage = 1
+	for i := range parts {
+		if i+1 < len(parts) {
+			parts[i].EndPage = parts[i+1].StartPage - 1
+		} else {
+			parts[i].EndPage = pageCount
+		}
+	}
+	return parts
+}
+
+// bookmarkBundleParts returns the parts given by the top-level bookmarks of the PDF at
+// path. An outline whose only top-level entry is the bundle itself lists the documents
+// one level down.
+func bookmarkBundleParts(path string, pageCount int) ([]bundlePart, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	bookmarks, err := api.Bookmarks(file, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bookmarks: %w", err)
+	}
+	if len(bookmarks) == 1 {
+		bookmarks = bookmarks[0].Kids
+	}
+	boundaries := make([]bundleBoundary, len(bookmarks))
+	for i, bookmark := range bookmarks {
+		boundaries[i] = bundleBoundary{Title: bookmark.Title, StartPage: bookmark.PageFrom}
+	}
+	return bundleParts(boundaries, pageCount), nil
+}
+
+// findBundleParts finds the documents in the bundle at path. Bookmarks are preferred;
+// without at least two usable ones Gemini is asked to read the leading pages instead,
+// unless the upload's processing is restricted. Fewer than two parts are returned when no
+// split was found, and the bundle is then processed as a single document.
+func (f *PDFSplitterFunction) findBundleParts(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, path string, pageCount int, restricted bool) ([]bundlePart, string) {
+	parts, err := bookmarkBundleParts(path, pageCount)
+	if err != nil {
+		logCtx.Warn("Could not read the bundle's bookmarks.", "error", err)
+	}
+	if len(parts) > 1 {
+		logCtx.Info("Found the bundle's documents from its bookmarks.", "documentCount", len(parts))
+		return parts, BundleSplitBookmarks
+	}
+	if restricted {
+		logCtx.Warn("Bundle has no usable bookmarks and its processing is restricted, so it is not sent to Gemini.", "bookmarkDocuments", len(parts))
+		return nil, BundleSplitBookmarks
+	}
+
+	detectionPages := min(f.config.Bundle.DetectionPages, pageCount)
+	logCtx.Warn("Bundle has no usable bookmarks; falling back to Gemini to find its documents.", "bookmarkDocuments", len(parts), "detectionPages", detectionPages)
+	parts, err = f.llmBundleParts(ctx, logCtx, docRef, path, detectionPages, pageCount)
+	if err != nil {
+		logCtx.Error("Gemini could not find the bundle's documents.", "error", err)
+		return nil, BundleSplitLLM
+	}
+	logCtx.Info("Found the bundle's documents with Gemini.", "documentCount", len(parts))
+	return parts, BundleSplitLLM
+}
+
+// llmBundleParts sends the first detectionPages pages of the bundle at path to Gemini
+// and returns the parts it names. The call's token usage is recorded on the bundle's
+// document.
+func (f *PDFSplitterFunction) llmBundleParts(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, path string, detectionPages, pageCount int) ([]bundlePart, error) {
+	headPath := filepath.Join(filepath.Dir(path), "bundle-head.pdf")
+	if err := api.TrimFile(path, headPath, []string{fmt.Sprintf("1-%d", detectionPages)}, nil); err != nil {
+		return nil, fmt.Errorf("failed to extract the leading pages: %w", err)
+	}
+	data, err := os.ReadFile(headPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the leading pages: %w", err)
+	}
+	input := genai.Blob{MIMEType: "application/pdf", Data: data}
+	prompt := genai.Text(fmt.Sprintf(gcp.BundleSplitterUserPromptFormat, detectionPages, pageCount))
+	resp, _, err := generateWithRetry(gcp.WithAuditScope(ctx, docRef.ID, "bundle"), logCtx, f.bundleModel, f.config.Bundle.Retry, input, prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate bundle boundaries from gemini: %w", err)
+	}
+	if err := recordTokenUsage(ctx, f.firestoreClient, f.config.CollectionName, docRef.ID, models.TokenUsageStageBundleSplitter, tokenUsageFrom(resp)); err != nil {
+		logCtx.Warn("Failed to record token usage", "error", err)
+	}
+
+	text := strings.TrimSpace(responseText(resp))
+	text = strings.TrimPrefix(text, "```json")
+	text = strings.TrimSuffix(text, "```")
+	var boundaries []bundleBoundary
+	if err := json.Unmarshal([]byte(strings.TrimSpace(text)), &boundaries); err != nil {
+		return nil, fmt.Errorf("failed to parse bundle boundaries: %w", err)
+	}
+	return bundleParts(boundaries, pageCount), nil
+}
+
+// splitBundle creates a document for each part of the bundle at optimized, then splits,
+// uploads and starts the workflow of each in turn, and records StatusBundleSplit on the
+// bundle's document with peak, the size of the largest file it was worked on from. A part
+// that fails is marked FAILED on its own document without stopping the others; the
+// failures are returned together.
+func (f *PDFSplitterFunction) splitBundle(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, upload *storage.ObjectAttrs, optimized string, peak int64, parts []bundlePart, method string, chunkBytes int) error {
+	logCtx.Info("Splitting bundle into documents.", "documentCount", len(parts), "method", method)
+	var subDocumentIDs []string
+	var errs []error
+	for i, part := range parts {
+		index := i + 1
+		partLogCtx := logCtx.With("subDocumentIndex", index, "bundlePages", describePages(part.StartPage, part.EndPage))
+		docID, err := f.splitSubDocument(ctx, partLogCtx, docRef.ID, upload, optimized, index, part, chunkBytes)
+		if docID != "" {
+			subDocumentIDs = append(subDocumentIDs, docID)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("document %d (%s): %w", index, describePages(part.StartPage, part.EndPage), err))
+		}
+	}
+	removeScratchFile(logCtx, optimized)
+
+	updates := []firestore.Update{
+		{Path: "pageCount", Value: parts[len(parts)-1].EndPage},
+		{Path: "subDocumentIds", Value: subDocumentIDs},
+		{Path: "bundleSplitMethod", Value: method},
+	}
+	if peak > 0 {
+		updates = append(updates, firestore.Update{Path: "peakFileSizeBytes", Value: peak})
+	}
+	if err := gcp.AppendStatusTransition(ctx, docRef, StatusBundleSplit, models.TimingStageSplitter, "", updates...); err != nil {
+		return f.handleError(ctx, logCtx, docRef, "failed to update status to "+StatusBundleSplit, err)
+	}
+	if len(errs) > 0 {
+		err := errors.Join(errs...)
+		logCtx.Error("One or more of the bundle's documents failed.", "error", err, "failedCount", len(errs))
+		return fmt.Errorf("failed to split %d of %d bundled documents: %w", len(errs), len(parts), err)
+	}
+	logCtx.Info("Bundle split; each document has its own workflow.", "subDocumentIds", subDocumentIDs)
+	return nil
+}
+
+// splitSubDocument creates the document for one part of a bundle, extracts the part's
+// pages into a PDF of their own and hands it off like any other upload. It returns the
+// part's document ID, which is that of an existing document when the part was already
+// processed.
+func (f *PDFSplitterFunction) splitSubDocument(ctx context.Context, logCtx *slog.Logger, bundleID string, upload *storage.ObjectAttrs, optimized string, index int, part bundlePart, chunkBytes int) (docID string, err error) {
+	existingID, existing, err := f.isDuplicate(ctx, bundleID, dedupeKey{}, index)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		logCtx.Info("Bundled document already exists. Skipping.", "existingDocId", existingID, "existingFilename", existing.OriginalFilename)
+		return existingID, nil
+	}
+
+	doc := initialDocument(logCtx, bundleID, upload, f.profile)
+	doc.TenantID = f.tenantID
+	doc.ParentBundleID = bundleID
+	doc.SubDocumentIndex = index
+	doc.BundleTitle = part.Title
+	doc.BundleStartPage = part.StartPage
+	doc.BundleEndPage = part.EndPage
+	docRef, err := f.createDocument(ctx, subDocumentID(bundleID, index), doc)
+	if errors.Is(err, errDuplicateUpload) {
+		logCtx.Info("Bundled document was created by another upload. Skipping.", "existingDocId", subDocumentID(bundleID, index), "existingFilename", f.existingFilename(ctx, subDocumentID(bundleID, index)))
+		return subDocumentID(bundleID, index), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	logCtx = logCtx.With("documentId", docRef.ID)
+	logCtx.Info("Created document for bundled document.", "title", part.Title)
+	timer := startStage(models.TimingStageSplitter)
+	splitStatus := "success"
+	defer func() {
+		timer.record(ctx, logCtx, f.firestoreClient, f.config.CollectionName, docRef.ID, splitStatus, err)
+	}()
+
+	dir := filepath.Join(filepath.Dir(optimized), fmt.Sprintf("part-%d", index))
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return docRef.ID, f.handleError(ctx, logCtx, docRef, "failed to create work directory", err)
+	}
+	path := filepath.Join(dir, "document.pdf")
+	if err := api.TrimFile(optimized, path, []string{fmt.Sprintf("%d-%d", part.StartPage, part.EndPage)}, nil); err != nil {
+		return docRef.ID, f.handleError(ctx, logCtx, docRef, "failed to extract the document's pages from the bundle", err)
+	}
+	pageCount := part.EndPage - part.StartPage + 1
+	if rejection := f.config.Scratch.checkSplitSpace(logCtx, path); rejection != nil {
+		splitStatus = rejection.Status
+		return docRef.ID, f.reject(ctx, logCtx, docRef, rejection)
+	}
+	if err := f.splitLocally(ctx, logCtx, docRef, path, pageCount, peakFileSize(optimized, path)); err != nil {
+		return docRef.ID, err
+	}
+	if err := f.handOff(ctx, logCtx, docRef, path, pageCount, bundleID, upload.Generation, chunkBytes); err != nil {
+		if errors.Is(err, errWorkflowNotStarted) {
+			// The pages are ready and the document is marked for the retrigger service.
+			splitStatus = StatusPagesReadyWorkflowFailed
+			return docRef.ID, nil
+		}
+		if errors.Is(err, ErrDocumentCancelled) {
+			splitStatus = StatusCancelled
+			return docRef.ID, nil
+		}
+		return docRef.ID, err
+	}
+	return docRef.ID, nil
+}
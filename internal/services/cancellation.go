@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// StatusCancelled is recorded on a document whose cancellation a stage has acted on.
+const StatusCancelled = "CANCELLED"
+
+// ErrDocumentCancelled is wrapped by the CANCELLED error a stage stops with when its
+// document's cancellation was requested.
+var ErrDocumentCancelled = errors.New("document cancelled")
+
+// cancelledError returns the CANCELLED error for docID. The code is not retryable, so the
+// workflow and the coordinator treat it as terminal.
+func cancelledError(docID string) error {
+	return models.WithCode(models.ErrorCodeCancelled, fmt.Errorf("%w: %s", ErrDocumentCancelled, docID))
+}
+
+// loadCancelCheckTTL reads CANCEL_CHECK_TTL, how stale a stage instance's view of a
+// document's cancellation may be.
+func loadCancelCheckTTL() (time.Duration, error) {
+	ttl, err := time.ParseDuration(gcp.GetEnv("CANCEL_CHECK_TTL", "30s"))
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("CANCEL_CHECK_TTL must be a non-negative duration")
+	}
+	return ttl, nil
+}
+
+type cachedCancellation struct {
+	cancelled bool
+	checkedAt time.Time
+}
+
+// CancellationChecker reads whether documents were cancelled and caches the answer for a
+// short TTL, so that a stage called for every page, or checking between model calls,
+// reads each document at most once per TTL. A cancellation is cached for the TTL too, as
+// reprocessing the document withdraws it.
+type CancellationChecker struct {
+	firestoreClient *firestore.Client
+	collection      string
+	ttl             time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedCancellation
+}
+
+// NewCancellationChecker creates a CancellationChecker over the documents collection.
+func NewCancellationChecker(firestoreClient *firestore.Client, collection string, ttl time.Duration) *CancellationChecker {
+	return &CancellationChecker{
+		firestoreClient: firestoreClient,
+		collection:      collection,
+		ttl:             ttl,
+		cache:           make(map[string]cachedCancellation),
+	}
+}
+
+// Check returns the CANCELLED error if the document's cancellation was requested, or nil.
+// A document that cannot be read is taken as not cancelled: the stage goes on, and fails
+// on its own if the document is really gone. A nil checker, as the local flow runs the
+// stages with, never finds a cancellation.
+func (c *CancellationChecker) Check(ctx context.Context, logCtx *slog.Logger, docID string) error {
+	if c == nil || docID == "" {
+		return nil
+	}
+	c.mu.Lock()
+	entry, ok := c.cache[docID]
+	c.mu.Unlock()
+	if ok && time.Since(entry.checkedAt) < c.ttl {
+		return c.result(docID, entry.cancelled)
+	}
+
+	snap, err := c.firestoreClient.Collection(c.collection).Doc(docID).Get(ctx)
+	if err != nil {
+		if status.Code(err) != codes.NotFound {
+			logCtx.Warn("Failed to check for a cancellation; carrying on.", "error", err)
+		}
+		return nil
+	}
+	cancelled, _ := snap.DataAt("cancelRequested")
+	entry = cachedCancellation{cancelled: cancelled == true, checkedAt: time.Now()}
+	c.mu.Lock()
+	c.cache[docID] = entry
+	c.mu.Unlock()
+	return c.result(docID, entry.cancelled)
+}
+
+func (c *CancellationChecker) result(docID string, cancelled bool) error {
+	if cancelled {
+		return cancelledError(docID)
+	}
+	return nil
+}
+
+type cancellationCheckKey struct{}
+
+// cancellationCheck is the check a context carries for work done on one document.
+type cancellationCheck struct {
+	checker *CancellationChecker
+	logCtx  *slog.Logger
+	docID   string
+}
+
+// WithCheck returns ctx carrying a check for docID's cancellation, which work that spans
+// several model calls makes between them through checkCancelled.
+func (c *CancellationChecker) WithCheck(ctx context.Context, logCtx *slog.Logger, docID string) context.Context {
+	if c == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, cancellationCheckKey{}, cancellationCheck{checker: c, logCtx: logCtx, docID: docID})
+}
+
+// checkCancelled returns the CANCELLED error if the document ctx carries a check for was
+// cancelled, or nil, as it does for a context carrying no check.
+func checkCancelled(ctx context.Context) error {
+	check, ok := ctx.Value(cancellationCheckKey{}).(cancellationCheck)
+	if !ok {
+		return nil
+	}
+	return check.checker.Check(ctx, check.logCtx, check.docID)
+}
+
+// recordCancelled moves the document to CANCELLED, unless it already is, so that every
+// page of a cancelled document stopping does not append a transition of its own. Like
+// recordStatus, it only logs a failure, and like recordStageFailure it does not depend on
+// the request's context.
+func recordCancelled(ctx context.Context, logCtx *slog.Logger, client *firestore.Client, collection, docID, stage string) {
+	if docID == "" {
+		return
+	}
+	docRef := client.Collection(collection).Doc(docID)
+	err := client.RunTransaction(context.WithoutCancel(ctx), func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+		if current, _ := snap.DataAt("status"); current == StatusCancelled {
+			return nil
+		}
+		now := time.Now()
+		return tx.Update(docRef, []firestore.Update{
+			{Path: "status", Value: StatusCancelled},
+			{Path: "statusHistory", Value: firestore.ArrayUnion(models.StatusTransition{Status: StatusCancelled, Timestamp: now, Stage: stage})},
+		})
+	})
+	if err != nil {
+		logCtx.Warn("Failed to record document cancellation", "error", err, "stage", stage)
+		return
+	}
+	logCtx.Info("Document cancelled; stopping.", "stage", stage)
+}
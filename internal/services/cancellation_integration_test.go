@@ -0,0 +1,108 @@
+//go:build integration
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestCancelDocumentStopsTheExecution(t *testing.T) {
+	ctx := context.Background()
+	firestoreClient, _ := testsupport.RequireEmulators(t).Clients(ctx, t)
+	workflows := testsupport.NewFakeWorkflows(t)
+	collection := fmt.Sprintf("documents-%d", time.Now().UnixNano())
+	docs := map[string]map[string]interface{}{
+		"doc-workflow": {"status": "TRANSLATING", "workflowExecutionId": testsupport.ExecutionID},
+		"doc-pubsub":   {"status": "TRANSLATING", "workflowExecutionId": "run-1"},
+		"doc-complete": {"status": StatusComplete, "workflowExecutionId": testsupport.ExecutionID},
+	}
+	for docID, data := range docs {
+		if _, err := firestoreClient.Collection(collection).Doc(docID).Set(ctx, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := &DocumentAdminFunction{
+		firestoreClient:  firestoreClient,
+		executionsClient: workflows.Client(ctx, t),
+		config:           DocumentAdminConfig{CollectionName: collection},
+	}
+
+	res, err := f.CancelDocument(ctx, "doc-workflow")
+	if err != nil {
+		t.Fatalf("CancelDocument() error = %v", err)
+	}
+	if !res.CancelRequested || !res.ExecutionStopped || res.Status != "TRANSLATING" {
+		t.Errorf("CancelDocument() = %+v, want the cancellation requested and the execution stopped", res)
+	}
+	if got := workflows.Cancelled(); !slices.Equal(got, []string{testsupport.ExecutionID}) {
+		t.Errorf("cancelled executions = %v, want the document's", got)
+	}
+	doc := readPipelineDocument(ctx, t, firestoreClient, collection, "doc-workflow")
+	if !doc.CancelRequested || !doc.CancelRequestedAt.Equal(res.CancelRequestedAt) {
+		t.Errorf("document cancelRequested = %v at %v, want it requested at %v", doc.CancelRequested, doc.CancelRequestedAt, res.CancelRequestedAt)
+	}
+
+	// Cancelling again is not an error, and the finished execution is left alone.
+	again, err := f.CancelDocument(ctx, "doc-workflow")
+	if err != nil || again.ExecutionStopped || !again.CancelRequestedAt.Equal(res.CancelRequestedAt) {
+		t.Errorf("CancelDocument() again = %+v, %v; want the first request, execution not stopped again", again, err)
+	}
+
+	// A Pub/Sub run has no execution to cancel.
+	if res, err := f.CancelDocument(ctx, "doc-pubsub"); err != nil || res.ExecutionStopped {
+		t.Errorf("CancelDocument() of a Pub/Sub run = %+v, %v; want no execution stopped", res, err)
+	}
+	if _, err := f.CancelDocument(ctx, "doc-complete"); !errors.Is(err, ErrDocumentNotCancellable) {
+		t.Errorf("CancelDocument() of a complete document = %v, want ErrDocumentNotCancellable", err)
+	}
+	if _, err := f.CancelDocument(ctx, "doc-missing"); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("CancelDocument() of a missing document = %v, want ErrDocumentNotFound", err)
+	}
+	if got := workflows.Cancelled(); len(got) != 1 {
+		t.Errorf("cancelled executions = %v, want only doc-workflow's", got)
+	}
+}
+
+func TestCancelledStageRecordsTheTransitionOnce(t *testing.T) {
+	ctx := context.Background()
+	store := objectstore.NewMemory()
+	f := newTestAggregator(ctx, t, store)
+	f.cancellation = NewCancellationChecker(f.firestoreClient, f.config.CollectionName, time.Minute)
+	admin := &DocumentAdminFunction{firestoreClient: f.firestoreClient, config: DocumentAdminConfig{CollectionName: f.config.CollectionName}}
+	if _, err := admin.CancelDocument(ctx, "doc-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A redelivered request stops again without a second transition.
+	for range 2 {
+		_, err := f.Process(ctx, testsupport.AggregatorRequest("doc-1"))
+		if code := models.CodeOf(err); code != models.ErrorCodeCancelled {
+			t.Fatalf("Process() error = %v, want CANCELLED", err)
+		}
+	}
+	doc := readPipelineDocument(ctx, t, f.firestoreClient, f.config.CollectionName, "doc-1")
+	if doc.Status != StatusCancelled {
+		t.Errorf("document status = %q, want %q", doc.Status, StatusCancelled)
+	}
+	var cancelled []models.StatusTransition
+	for _, transition := range doc.StatusHistory {
+		if transition.Status == StatusCancelled {
+			cancelled = append(cancelled, transition)
+		}
+	}
+	if len(cancelled) != 1 || cancelled[0].Stage != models.TimingStageAggregator {
+		t.Errorf("CANCELLED transitions = %+v, want one by the aggregator", cancelled)
+	}
+	if _, ok := store.Get("master", "doc-1/master.md"); ok {
+		t.Errorf("master file written for a cancelled document")
+	}
+}
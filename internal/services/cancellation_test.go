@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+func TestCancelledError(t *testing.T) {
+	err := cancelledError("doc-1")
+	if !errors.Is(err, ErrDocumentCancelled) {
+		t.Errorf("cancelledError() = %v, want it to wrap ErrDocumentCancelled", err)
+	}
+	// The workflow and the coordinator stop on an error that is not retryable.
+	if code := models.CodeOf(err); code != models.ErrorCodeCancelled || code.Retryable() {
+		t.Errorf("cancelledError() code = %s, retryable %v; want CANCELLED, not retryable", code, code.Retryable())
+	}
+}
+
+func TestCancellationCheckerCache(t *testing.T) {
+	c := NewCancellationChecker(nil, "documents", time.Hour)
+	c.cache["doc-cancelled"] = cachedCancellation{cancelled: true, checkedAt: time.Now()}
+	c.cache["doc-running"] = cachedCancellation{checkedAt: time.Now()}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	if err := c.Check(ctx, logCtx, "doc-cancelled"); models.CodeOf(err) != models.ErrorCodeCancelled {
+		t.Errorf("Check() of a cancelled document = %v, want CANCELLED", err)
+	}
+	if err := c.Check(ctx, logCtx, "doc-running"); err != nil {
+		t.Errorf("Check() of a running document = %v, want nil", err)
+	}
+	if err := c.Check(ctx, logCtx, ""); err != nil {
+		t.Errorf("Check() without a document = %v, want nil", err)
+	}
+	var none *CancellationChecker
+	if err := none.Check(ctx, logCtx, "doc-cancelled"); err != nil {
+		t.Errorf("Check() of a nil checker = %v, want nil", err)
+	}
+
+	if err := checkCancelled(c.WithCheck(ctx, logCtx, "doc-cancelled")); models.CodeOf(err) != models.ErrorCodeCancelled {
+		t.Errorf("checkCancelled() = %v, want CANCELLED", err)
+	}
+	if err := checkCancelled(ctx); err != nil {
+		t.Errorf("checkCancelled() without a check = %v, want nil", err)
+	}
+	if got := none.WithCheck(ctx, logCtx, "doc-cancelled"); got != ctx {
+		t.Errorf("WithCheck() of a nil checker changed the context")
+	}
+}
+
+func TestLoadCancelCheckTTL(t *testing.T) {
+	t.Setenv("CANCEL_CHECK_TTL", "")
+	os.Unsetenv("CANCEL_CHECK_TTL")
+	if got, err := loadCancelCheckTTL(); err != nil || got != 30*time.Second {
+		t.Errorf("loadCancelCheckTTL() = %v, %v; want the default of 30s", got, err)
+	}
+	for _, value := range []string{"-1s", "soon"} {
+		t.Setenv("CANCEL_CHECK_TTL", value)
+		if _, err := loadCancelCheckTTL(); err == nil {
+			t.Errorf("loadCancelCheckTTL() accepted %q", value)
+		}
+	}
+}
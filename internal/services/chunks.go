@@ -0,0 +1,70 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// pageChunk is an inclusive range of pages that is split, uploaded and translated as a
+// single unit. With the default chunk size of 1 every chunk is a single page.
+type pageChunk struct {
+	StartPage int `json:"startPage"`
+	EndPage   int `json:"endPage"`
+}
+
+// pageChunks divides pageCount pages into consecutive chunks of chunkSize pages, matching
+// the spans produced by api.SplitFile. The last chunk may be shorter.
+func pageChunks(pageCount, chunkSize int) []pageChunk {
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+	chunks := make([]pageChunk, 0, (pageCount+chunkSize-1)/chunkSize)
+	for start := 1; start <= pageCount; start += chunkSize {
+		chunks = append(chunks, pageChunk{StartPage: start, EndPage: min(start+chunkSize-1, pageCount)})
+	}
+	return chunks
+}
+
+// pageRangeObjectName returns the object name for the pages [startPage, endPage] of the
+// document under root, as documentRoot gives it: "root/00012.ext" for a single page and
+// "root/00011-00015.ext" for a chunk.
+func pageRangeObjectName(root string, startPage, endPage int, ext string) string {
+	return fmt.Sprintf("%s/%s.%s", root, pageRangeName(startPage, endPage), ext)
+}
+
+// pageRangeName names the pages [startPage, endPage]: "00012" for a single page and
+// "00011-00015" for a chunk.
+func pageRangeName(startPage, endPage int) string {
+	if startPage == endPage {
+		return fmt.Sprintf("%05d", startPage)
+	}
+	return fmt.Sprintf("%05d-%05d", startPage, endPage)
+}
+
+// localSplitPath returns the path api.SplitFile writes a chunk to, given the split
+// file's base path without extension.
+func localSplitPath(splitFileBase string, chunk pageChunk) string {
+	if chunk.StartPage == chunk.EndPage {
+		return fmt.Sprintf("%s_%d.pdf", splitFileBase, chunk.StartPage)
+	}
+	return fmt.Sprintf("%s_%d-%d.pdf", splitFileBase, chunk.StartPage, chunk.EndPage)
+}
+
+// sortChunks sorts chunks in page order.
+func sortChunks(chunks []pageChunk) {
+	slices.SortFunc(chunks, func(a, b pageChunk) int { return a.StartPage - b.StartPage })
+}
+
+// chunkPageNumbers lists the pages of chunks, in the chunks' order. It never returns nil,
+// so an empty list is stored as an empty array.
+func chunkPageNumbers(chunks []pageChunk) []int {
+	pages := []int{}
+	for _, chunk := range chunks {
+		for pageNumber := chunk.StartPage; pageNumber <= chunk.EndPage; pageNumber++ {
+			pages = append(pages, pageNumber)
+		}
+	}
+	return pages
+}
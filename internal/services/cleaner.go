@@ -4,11 +4,15 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"strconv"
 	"strings"
+	"time"
 
+	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/storage"
 	"cloud.google.com/go/vertexai/genai"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
 		_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
 )
@@ -18,17 +22,99 @@ type CleanerConfig struct {
 	ProjectID             string
 	VertexAIRegion        string
 	CleanedMarkdownBucket string
+	MasterBucket          string
+	CollectionName        string
+	TenantCollection      string // Tenants whose config fails validation are refused.
+	Models                gcp.VertexModelConfig
+	ChunkThresholdBytes   int64 // Larger master files are cleaned in chunks.
+	ChunkTargetBytes      int   // Approximate size of each chunk.
+	ChunkConcurrency      int   // Maximum concurrent chunk cleanups.
+	InputMode             string // MarkdownInputInline or MarkdownInputFileData.
+	// Mode is one of the CleanerMode constants, and TableHeaderSimilarity how alike two
+	// tables' headers must be for the deterministic pass to merge them.
+	Mode                  string
+	TableHeaderSimilarity float64
+	// MinRetainedRatio is the fraction of the master file's characters the cleaned
+	// markdown must keep to be saved, and WriteReport whether a clean report is saved
+	// next to it.
+	MinRetainedRatio float64
+	WriteReport      bool
+	OutputNames      *OutputNames
+	// Estimate sets how dry runs estimate the cost of a cleanup.
+	Estimate CostEstimateConfig
 }
 
 // CleanerFunction holds dependencies for the cleaning logic.
 type CleanerFunction struct {
-	storageClient *storage.Client
-	vertexClient  *gcp.VertexClient
-	config        CleanerConfig
+	serviceClients
+	storageClient   *storage.Client
+	firestoreClient *firestore.Client
+	model           gcp.ContentGenerator
+	tokenCounter    gcp.TokenCounter // Counts the input of model, for dry runs.
+	profiles        *ProfileResolver
+	cancellation    *CancellationChecker
+	tenants         *DocumentTenants
+	tenantConfigs   *TenantStore
+	reporter        *stageReporter // Nil unless ORCHESTRATION_MODE is pubsub.
+	config          CleanerConfig
 }
 
 // NewCleaner creates a new CleanerFunction instance.
 func NewCleaner(ctx context.Context) (*CleanerFunction, error) {
+	config, err := loadCleanerConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %w", err)
+	}
+
+	if err := gcp.InitTracing(ctx, config.ProjectID); err != nil {
+		return nil, err
+	}
+	if err := gcp.InitMetrics(ctx, config.ProjectID); err != nil {
+		return nil, err
+	}
+
+	firestoreClient, err := gcp.NewFirestoreClient(ctx, config.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firestore client: %w", err)
+	}
+
+	// Re-use the centralized Vertex AI client constructor
+	vertexClient, err := gcp.NewVertexClient(ctx, config.ProjectID, config.VertexAIRegion, config.Models)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vertex client: %w", err)
+	}
+	reporter, err := newStageReporter(ctx, config.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	cancelCheckTTL, err := loadCancelCheckTTL()
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Markdown cleaner initialized.", "model", config.Models.CleanerModelName, "region", config.VertexAIRegion, "inputMode", config.InputMode, "mode", config.Mode)
+
+	return &CleanerFunction{
+		serviceClients:  serviceClients{storageClient, firestoreClient, vertexClient}.withReporter(reporter),
+		storageClient:   storageClient,
+		firestoreClient: firestoreClient,
+		model:           vertexClient.Cleaner(),
+		tokenCounter:    vertexClient.CleanerCounter(),
+		profiles:        NewProfileResolver(firestoreClient, config.CollectionName, time.Minute),
+		cancellation:    NewCancellationChecker(firestoreClient, config.CollectionName, cancelCheckTTL),
+		tenants:         NewDocumentTenants(firestoreClient, config.CollectionName),
+		tenantConfigs:   NewTenantStore(firestoreClient, config.TenantCollection, tenantConfigTTL),
+		reporter:        reporter,
+		config:          *config,
+	}, nil
+}
+
+// loadCleanerConfig loads and validates the cleaner's environment variables.
+func loadCleanerConfig() (*CleanerConfig, error) {
 	projectID := gcp.GetEnv("PROJECT_ID", "")
 	if projectID == "" {
 		return nil, fmt.Errorf("GCP_PROJECT environment variable must be set")
@@ -38,49 +124,234 @@ func NewCleaner(ctx context.Context) (*CleanerFunction, error) {
 		ProjectID:             projectID,
 		VertexAIRegion:        gcp.GetEnv("VERTEX_AI_REGION", "us-central1"),
 		CleanedMarkdownBucket: gcp.GetEnv("CLEANED_MARKDOWN_BUCKET", ""), // Destination bucket
+		MasterBucket:          gcp.GetEnv("AGGREGATED_MARKDOWN_BUCKET", ""),
+		CollectionName:        gcp.GetEnv("FIRESTORE_COLLECTION", "documents"),
+		TenantCollection:      gcp.GetEnv("TENANT_COLLECTION", "tenants"),
+		Models:                gcp.LoadVertexModelConfig(),
 	}
-	if config.CleanedMarkdownBucket == "" {
-		return nil, fmt.Errorf("CLEANED_MARKDOWN_BUCKET must be set")
+	if config.CleanedMarkdownBucket == "" || config.MasterBucket == "" {
+		return nil, fmt.Errorf("CLEANED_MARKDOWN_BUCKET and AGGREGATED_MARKDOWN_BUCKET must be set")
 	}
-
-	storageClient, err := storage.NewClient(ctx)
+	if err := config.loadChunking(); err != nil {
+		return nil, err
+	}
+	if err := config.loadMode(); err != nil {
+		return nil, err
+	}
+	if err := config.loadReport(); err != nil {
+		return nil, err
+	}
+	outputNames, err := loadOutputNames()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create storage client: %w", err)
+		return nil, err
 	}
-
-	// Re-use the centralized Vertex AI client constructor
-	vertexClient, err := gcp.NewVertexClient(ctx, config.ProjectID, config.VertexAIRegion)
+	config.OutputNames = outputNames
+	inputMode, err := loadMarkdownInputMode()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create vertex client: %w", err)
+		return nil, err
+	}
+	config.InputMode = inputMode
+	if config.Estimate, err = loadCostEstimateConfig("CLEANER_OUTPUT_TOKEN_RATIO", "0.7,1.1"); err != nil {
+		return nil, err
 	}
+	return &config, nil
+}
 
-	return &CleanerFunction{
-		storageClient: storageClient,
-		vertexClient:  vertexClient,
-		config:        config,
-	}, nil
+// loadChunking reads CLEANER_CHUNK_THRESHOLD_BYTES, CLEANER_CHUNK_TARGET_BYTES and
+// CLEANER_CHUNK_CONCURRENCY into c.
+func (c *CleanerConfig) loadChunking() error {
+	chunkThreshold, err := strconv.ParseInt(gcp.GetEnv("CLEANER_CHUNK_THRESHOLD_BYTES", "32768"), 10, 64)
+	if err != nil || chunkThreshold <= 0 {
+		return fmt.Errorf("CLEANER_CHUNK_THRESHOLD_BYTES must be a positive integer")
+	}
+	c.ChunkThresholdBytes = chunkThreshold
+	chunkTarget, err := strconv.Atoi(gcp.GetEnv("CLEANER_CHUNK_TARGET_BYTES", "32768"))
+	if err != nil || chunkTarget <= 0 {
+		return fmt.Errorf("CLEANER_CHUNK_TARGET_BYTES must be a positive integer")
+	}
+	c.ChunkTargetBytes = chunkTarget
+	chunkConcurrency, err := strconv.Atoi(gcp.GetEnv("CLEANER_CHUNK_CONCURRENCY", "4"))
+	if err != nil || chunkConcurrency < 1 {
+		return fmt.Errorf("CLEANER_CHUNK_CONCURRENCY must be a positive integer")
+	}
+	c.ChunkConcurrency = chunkConcurrency
+	return nil
 }
 
 // Process handles the core logic of cleaning the aggregated Markdown file.
-func (f *CleanerFunction) Process(ctx context.Context, req *models.MarkdownCleanerRequest) (*models.MarkdownCleanerResponse, error) {
+func (f *CleanerFunction) Process(ctx context.Context, req *models.MarkdownCleanerRequest) (res *models.MarkdownCleanerResponse, err error) {
 	logCtx := slog.With("documentId", req.DocumentID, "executionId", req.ExecutionID)
+	ctx, span := startProcessSpan(ctx, "cleaner.Process", req.Traceparent, attrDocumentID.String(req.DocumentID))
+	defer func() { endProcessSpan(ctx, span, err) }()
+	// Checked first, so that a request naming the wrong tenant leaves the document as is.
+	if err := f.tenants.Check(ctx, req.DocumentID, req.TenantID); err != nil {
+		logCtx.Error("Invalid cleanup request", "error", err, "tenantId", req.TenantID)
+		return nil, err
+	}
+	if _, err := f.tenantConfigs.Require(ctx, req.TenantID); err != nil {
+		logCtx.Error("Cannot clean for the document's tenant", "error", err, "tenantId", req.TenantID)
+		return nil, err
+	}
+	if req.DryRun {
+		return f.estimate(ctx, logCtx, req)
+	}
+	if err := checkExecution(ctx, logCtx, f.firestoreClient, f.config.CollectionName, req.DocumentID, req.ExecutionID); err != nil {
+		logCtx.Error("Refused cleanup request", "error", err)
+		return nil, err
+	}
+	timer := startStage(models.TokenUsageStageCleaner)
+	defer func() {
+		status := ""
+		if res != nil {
+			status = res.Status
+		}
+		timing := timer.record(ctx, logCtx, f.firestoreClient, f.config.CollectionName, req.DocumentID, status, err)
+		if res != nil {
+			res.Timing = timing
+		}
+		if err != nil {
+			recordStageFailure(ctx, logCtx, f.firestoreClient, f.config.CollectionName, req.DocumentID, models.TokenUsageStageCleaner, err)
+		}
+	}()
 	logCtx.Info("Starting markdown cleanup.")
+	if _, _, err := gcp.ValidateDocumentURI(req.MasterGCSUri, req.DocumentID, req.TenantID, f.config.MasterBucket); err != nil {
+		logCtx.Error("Invalid cleanup request", "error", err, "gcsUri", req.MasterGCSUri)
+		return nil, err
+	}
+	if err := f.cancellation.Check(ctx, logCtx, req.DocumentID); err != nil {
+		return nil, err
+	}
+	ctx = f.cancellation.WithCheck(ctx, logCtx, req.DocumentID)
+	recordStatus(ctx, logCtx, f.firestoreClient, f.config.CollectionName, req.DocumentID, StatusCleaning, models.TokenUsageStageCleaner)
 
-	// --- 1. Call the pre-configured cleaner model ---
-	model := f.vertexClient.CleanerModel
-	prompt := genai.Text(gcp.CleanerUserPrompt)
-	filePart := genai.FileData{
-		MIMEType: "text/markdown",
-		FileURI:  req.MasterGCSUri,
+	// --- 1. Read the master file, rejecting an empty one before any model call ---
+	// It is downloaded even when the model reads it by reference, to compare the cleaned
+	// markdown against.
+	master, err := readMarkdown(ctx, f.storageClient, req.MasterGCSUri)
+	if err != nil {
+		logCtx.Error("Failed to read master file", "error", err, "gcsUri", req.MasterGCSUri)
+		return nil, sourceError(err)
+	}
+	markdown := master
+	sourceSize := int64(len(markdown))
+	if strings.TrimSpace(markdown) == "" {
+		err := fmt.Errorf("%w: %s", ErrEmptyMarkdown, req.MasterGCSUri)
+		logCtx.Error("Master file has no content to clean", "error", err)
+		return nil, sourceError(err)
+	}
+	// The document's processing profile may choose another mode than the configured one.
+	mode := f.modeFor(ctx, logCtx, req.DocumentID)
+	logCtx.Info("Cleaning master file.", "sizeBytes", sourceSize, "inputMode", f.config.InputMode, "mode", mode)
+	if mode != CleanerModeLLM {
+		markdown = f.cleanDeterministically(markdown)
+		logCtx.Info("Deterministic cleanup complete.", "sizeBytes", len(markdown), "reductionBytes", sourceSize-int64(len(markdown)))
+		sourceSize = int64(len(markdown))
 	}
 
-	geminiResp, err := model.GenerateContent(ctx, filePart, prompt)
+	// --- 2. Call the pre-configured cleaner model, in chunks for large documents ---
+	language := markdownLanguage(req.SourceLanguage, req.TargetLanguage)
+	prompt := cleanerPrompt(language)
+	var cleanedContent string
+	var usage *models.TokenUsage
+	var resume chunkResume
+	chunkCount := 1
+	bucket := objectstore.WrapBucket(f.storageClient.Bucket(f.config.CleanedMarkdownBucket))
+	root := documentRoot(req.TenantID, req.DocumentID)
+	switch {
+	case mode == CleanerModeDeterministic:
+		cleanedContent, chunkCount = markdown, 0
+	case sourceSize > f.config.ChunkThresholdBytes:
+		logCtx.Info("Master file exceeds the chunking threshold; cleaning in chunks.", "sizeBytes", sourceSize, "thresholdBytes", f.config.ChunkThresholdBytes)
+		cleanedContent, usage, resume, err = f.cleanInChunks(ctx, logCtx, bucket, req.DocumentID, root, markdown, prompt)
+		chunkCount = resume.Count
+	case f.config.InputMode == MarkdownInputFileData && mode == CleanerModeLLM:
+		filePart := genai.FileData{
+			MIMEType: "text/markdown",
+			FileURI:  req.MasterGCSUri,
+		}
+		cleanedContent, usage, err = f.cleanPart(gcp.WithAuditScope(ctx, req.DocumentID, "document"), logCtx, filePart, prompt)
+	default:
+		cleanedContent, usage, err = f.cleanPart(gcp.WithAuditScope(ctx, req.DocumentID, "document"), logCtx, genai.Text(markdown), prompt)
+	}
 	if err != nil {
-		logCtx.Error("Call to Vertex AI for cleanup failed", "error", err)
-		return nil, fmt.Errorf("failed to generate cleaned content from gemini: %w", err)
+		return nil, modelError(err)
+	}
+
+	if err := recordTokenUsage(ctx, f.firestoreClient, f.config.CollectionName, req.DocumentID, models.TokenUsageStageCleaner, usage); err != nil {
+		logCtx.Warn("Failed to record token usage", "error", err)
+	}
+
+	// --- 3. Validate the response, refusing one that lost too much of the master file ---
+	if cleanedContent == "" {
+		logCtx.Warn("No markdown content extracted from cleanup response.")
+	}
+	report, err := f.compareCleaned(req.DocumentID, master, cleanedContent)
+	if f.config.WriteReport {
+		saveCleanReport(ctx, logCtx, bucket, root, report)
+	}
+	if err != nil {
+		logCtx.Error("Cleaned markdown rejected", "error", err, "input", report.Input, "output", report.Output)
+		return nil, err
+	}
+	logCtx.Info("Cleaned markdown compared with master file.", "retainedRatio", report.RetainedRatio, "input", report.Input, "output", report.Output)
+
+	// --- 4. Save the cleaned content to the destination bucket ---
+	outputDoc, err := f.config.OutputNames.Document(ctx, f.firestoreClient, f.config.CollectionName, req.DocumentID, req.TenantID)
+	if err != nil {
+		logCtx.Error("Failed to resolve output names", "error", err)
+		return nil, sourceError(err)
+	}
+	objectName, err := f.config.OutputNames.Master(outputDoc)
+	if err != nil {
+		logCtx.Error("Failed to name cleaned markdown", "error", err)
+		return nil, err
+	}
+	if err := gcp.SaveToGCSAtomically(ctx, logCtx, bucket, objectName, cleanedContent); err != nil {
+		logCtx.Error("Failed to save cleaned markdown to GCS", "error", err, "bucket", f.config.CleanedMarkdownBucket, "gcsObject", objectName)
+		return nil, outputError(err)
 	}
+	if resume.Count > 0 {
+		deleteCleanedChunks(ctx, logCtx, bucket, root)
+	}
+
+	// --- 5. Return the success response with the new URI ---
+	outputGCSUri := fmt.Sprintf("gs://%s/%s", f.config.CleanedMarkdownBucket, objectName)
+	logCtx.Info("Markdown cleanup complete.", "outputGcsUri", outputGCSUri, "chunkCount", chunkCount, "reusedChunks", resume.Reused)
 
-	// --- 2. Extract and validate the response ---
+	res = &models.MarkdownCleanerResponse{
+		Status:        "success",
+		CleanedGCSUri: outputGCSUri,
+		ChunkCount:    chunkCount,
+		Usage:         usage,
+		Mode:          mode,
+		Language:      language,
+		Report:        report,
+	}
+	if mode != CleanerModeDeterministic {
+		res.Model = f.config.Models.CleanerModelName
+	}
+	if resume.Reused > 0 {
+		res.ResumedFromChunk = resume.ResumedFrom
+		res.ReusedChunks = resume.Reused
+	}
+	return res, nil
+}
+
+// cleanPart runs the cleaner model over a single piece of markdown, supplied either as a
+// file reference or inline text, with the given user prompt, and rejects responses that
+// read as a refusal.
+func (f *CleanerFunction) cleanPart(ctx context.Context, logCtx *slog.Logger, content genai.Part, prompt string) (string, *models.TokenUsage, error) {
+	geminiResp, err := f.model.GenerateContent(ctx, content, genai.Text(prompt))
+	if filtered := asFilteredResponseError(geminiResp, err); filtered != nil {
+		// Retrying would be blocked the same way, so this is reported as its own error.
+		logCtx.Warn("Gemini blocked the cleanup", "error", filtered)
+		return "", tokenUsageFrom(geminiResp), fmt.Errorf("failed to clean document: %w", filtered)
+	}
+	if err != nil {
+		logCtx.Error("Call to Vertex AI for cleanup failed", "error", err)
+		return "", nil, fmt.Errorf("failed to generate cleaned content from gemini: %w", err)
+	}
+	usage := tokenUsageFrom(geminiResp)
 	cleanedContent := f.extractCleanedMarkdown(geminiResp)
 
 	// Sanity check for LLM refusal.
@@ -93,33 +364,12 @@ func (f *CleanerFunction) Process(ctx context.Context, req *models.MarkdownClean
 	lowerCleanedContent := strings.ToLower(cleanedContent)
 	for _, phrase := range refusalPhrases {
 		if strings.Contains(lowerCleanedContent, phrase) {
-			err := fmt.Errorf("gemini response indicates refusal to clean document")
+			err := fmt.Errorf("%w to clean document", errModelRefusal)
 			logCtx.Error("LLM refusal detected", "error", err, "response", cleanedContent)
-			return nil, err
+			return "", usage, err
 		}
 	}
-
-	if cleanedContent == "" {
-		logCtx.Warn("No markdown content extracted from cleanup response. Saving empty file.")
-	}
-
-	// --- 3. Save the cleaned content to the destination bucket ---
-	objectName := fmt.Sprintf("%s/master.md", req.DocumentID)
-	bucketHandle := f.storageClient.Bucket(f.config.CleanedMarkdownBucket)
-
-	if err := gcp.SaveToGCSAtomically(ctx, bucketHandle, objectName, cleanedContent); err != nil {
-		logCtx.Error("Failed to save cleaned markdown to GCS", "error", err, "bucket", f.config.CleanedMarkdownBucket, "object", objectName)
-		return nil, err
-	}
-
-	// --- 4. Return the success response with the new URI ---
-	outputGCSUri := fmt.Sprintf("gs://%s/%s", f.config.CleanedMarkdownBucket, objectName)
-	logCtx.Info("Markdown cleanup complete.", "outputGcsUri", outputGCSUri)
-
-	return &models.MarkdownCleanerResponse{
-		Status:        "success",
-		CleanedGCSUri: outputGCSUri,
-	}, nil
+	return cleanedContent, usage, nil
 }
 
 // extractCleanedMarkdown robustly parses the model's response to get the text content.
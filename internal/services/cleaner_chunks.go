@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"golang.org/x/sync/errgroup"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// splitCleanerChunks groups the pages of a master file into chunks of roughly targetBytes,
+// splitting only on the page anchors or separators written by the aggregator. Page
+// boundaries inside a chunk are kept so that the model can still merge content broken
+// across those pages. A single page larger than targetBytes becomes a chunk of its own.
+func splitCleanerChunks(markdown string, targetBytes int) []string {
+	var chunks []string
+	var current strings.Builder
+	pages, joiner := splitMasterPages(markdown)
+	for _, page := range pages {
+		if current.Len() > 0 && current.Len()+len(joiner)+len(page) > targetBytes {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+		if current.Len() > 0 {
+			current.WriteString(joiner)
+		}
+		current.WriteString(page)
+	}
+	if current.Len() > 0 || len(chunks) == 0 {
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
+
+// cleanedChunkSourceMetadataKey records, on a checkpointed chunk, a hash of the source
+// chunk and prompt it was cleaned from, so that a retry only reuses a checkpoint whose
+// input has not changed since.
+const cleanedChunkSourceMetadataKey = "sourceSha256"
+
+// cleanedChunksPrefix returns the prefix under which the cleaned chunks of the document
+// under root are checkpointed.
+func cleanedChunksPrefix(root string) string {
+	return root + "/cleaned_chunks/"
+}
+
+// cleanedChunkObjectName returns the checkpoint of chunk i, counted from 0.
+func cleanedChunkObjectName(root string, i int) string {
+	return fmt.Sprintf("%s%04d.md", cleanedChunksPrefix(root), i)
+}
+
+// cleanedChunkSourceHash identifies the input a chunk is cleaned from.
+func cleanedChunkSourceHash(chunk, prompt string) string {
+	hash := sha256.New()
+	io.WriteString(hash, prompt)
+	hash.Write([]byte{0})
+	io.WriteString(hash, chunk)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// chunkResume describes how an attempt to clean in chunks used the checkpoints of
+// earlier attempts.
+type chunkResume struct {
+	Count       int // Number of chunks.
+	Reused      int // Chunks read from a checkpoint instead of cleaned again.
+	ResumedFrom int // First chunk cleaned, counted from 1, when some were reused.
+}
+
+// cleanInChunks cleans a large master file with one model call per chunk, running at most
+// ChunkConcurrency calls at once, and stitches the results back together in page order.
+// Each cleaned chunk is checkpointed in bucket as soon as it is ready, and chunks already
+// checkpointed by an earlier attempt are not cleaned again, so a retry after a failure
+// only pays for the chunks that were not finished. Checkpoints are stored under root, the
+// document's prefix. It returns the cleaned markdown, the combined token usage of this
+// attempt and how it resumed.
+func (f *CleanerFunction) cleanInChunks(ctx context.Context, logCtx *slog.Logger, bucket objectstore.Bucket, docID, root, markdown, prompt string) (string, *models.TokenUsage, chunkResume, error) {
+	chunks := splitCleanerChunks(markdown, f.config.ChunkTargetBytes)
+	hashes := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		hashes[i] = cleanedChunkSourceHash(chunk, prompt)
+	}
+	cleaned := make([]string, len(chunks))
+	reused := loadCleanedChunks(ctx, logCtx, bucket, root, hashes, cleaned)
+	resume := chunkResume{Count: len(chunks), Reused: len(reused)}
+	for i := range chunks {
+		if len(reused) > 0 && !reused[i] {
+			resume.ResumedFrom = i + 1
+			break
+		}
+	}
+	logCtx.Info("Cleaning master file in chunks.", "chunkCount", len(chunks), "reusedChunks", resume.Reused, "concurrency", f.config.ChunkConcurrency)
+
+	usages := make([]*models.TokenUsage, len(chunks))
+	eg, gctx := errgroup.WithContext(ctx)
+	eg.SetLimit(f.config.ChunkConcurrency)
+	for i, chunk := range chunks {
+		if reused[i] {
+			continue
+		}
+		eg.Go(func() error {
+			// A document cancelled while its chunks are cleaned stops before the next one.
+			if err := checkCancelled(gctx); err != nil {
+				return err
+			}
+			chunkLog := logCtx.With("chunk", i+1, "chunkCount", len(chunks))
+			auditCtx := gcp.WithAuditScope(gctx, docID, fmt.Sprintf("chunk-%04d", i))
+			text, usage, err := f.cleanPart(auditCtx, chunkLog, genai.Text(chunk), prompt)
+			if err != nil {
+				return fmt.Errorf("failed to clean chunk %d of %d: %w", i+1, len(chunks), err)
+			}
+			if text == "" {
+				chunkLog.Warn("No markdown content extracted for chunk.")
+			}
+			cleaned[i] = text
+			usages[i] = usage
+			// A chunk that fails to checkpoint is still used; a retry cleans it again.
+			metadata := map[string]string{cleanedChunkSourceMetadataKey: hashes[i]}
+			if err := gcp.SaveToGCSAtomicallyWithMetadata(gctx, chunkLog, bucket, cleanedChunkObjectName(root, i), text, metadata); err != nil {
+				chunkLog.Warn("Failed to checkpoint cleaned chunk", "error", err)
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return "", nil, resume, err
+	}
+
+	var total *models.TokenUsage
+	for _, usage := range usages {
+		total = addTokenUsage(total, usage)
+	}
+
+	var stitched []string
+	for _, text := range cleaned {
+		if text != "" {
+			stitched = append(stitched, text)
+		}
+	}
+	return strings.Join(stitched, "\n\n"), total, resume, nil
+}
+
+// loadCleanedChunks reads the checkpointed chunks of the document under root into cleaned
+// and returns the chunks it read. A checkpoint that is incomplete, or was cleaned from a source that no
+// longer matches hashes, is deleted so that the chunk can be checkpointed again. Failing
+// to read checkpoints is not an error: the chunks are cleaned again instead.
+func loadCleanedChunks(ctx context.Context, logCtx *slog.Logger, bucket objectstore.Bucket, root string, hashes, cleaned []string) map[int]bool {
+	reused := make(map[int]bool)
+	prefix := cleanedChunksPrefix(root)
+	objects, err := bucket.List(ctx, prefix)
+	if err != nil {
+		logCtx.Warn("Failed to list cleaned chunk checkpoints; cleaning every chunk.", "error", err, "prefix", prefix)
+		return reused
+	}
+	for _, attrs := range objects {
+		i, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(attrs.Name, prefix), ".md"))
+		valid := err == nil && i >= 0 && i < len(hashes) &&
+			gcp.IsCompleteObject(attrs) && attrs.Metadata[cleanedChunkSourceMetadataKey] == hashes[i]
+		if valid {
+			text, err := readObject(ctx, bucket, attrs.Name)
+			if err == nil {
+				cleaned[i] = text
+				reused[i] = true
+				continue
+			}
+			logCtx.Warn("Failed to read cleaned chunk checkpoint; cleaning the chunk again.", "error", err, "gcsObject", attrs.Name)
+		} else {
+			logCtx.Warn("Discarding stale cleaned chunk checkpoint.", "gcsObject", attrs.Name)
+		}
+		if err := bucket.Delete(ctx, attrs.Name, attrs.Generation); err != nil && !errors.Is(err, objectstore.ErrObjectNotExist) {
+			logCtx.Warn("Failed to delete stale cleaned chunk checkpoint", "error", err, "gcsObject", attrs.Name)
+		}
+	}
+	return reused
+}
+
+// readObject reads an object's content, decompressed.
+func readObject(ctx context.Context, bucket objectstore.Bucket, object string) (string, error) {
+	reader, err := bucket.NewReader(ctx, object)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", object, err)
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", object, err)
+	}
+	return string(content), nil
+}
+
+// deleteCleanedChunks removes the chunk checkpoints of the document under root once its
+// cleaned markdown is saved. Failing to is only logged; leftover checkpoints are replaced
+// by a later run.
+func deleteCleanedChunks(ctx context.Context, logCtx *slog.Logger, bucket objectstore.Bucket, root string) {
+	deleted, err := deletePrefix(ctx, logCtx, bucket, cleanedChunksPrefix(root))
+	if err != nil {
+		logCtx.Warn("Failed to delete cleaned chunk checkpoints", "error", err, "deleted", deleted)
+	}
+}
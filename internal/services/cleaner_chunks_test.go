@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/vertexai/genai"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestSplitCleanerChunks(t *testing.T) {
+	tests := []struct {
+		name        string
+		markdown    string
+		targetBytes int
+		want        []string
+	}{
+		{
+			name:        "page separators",
+			markdown:    "page one" + pageSeparator + "page two" + pageSeparator + "page three",
+			targetBytes: 30,
+			want:        []string{"page one" + pageSeparator + "page two", "page three"},
+		},
+		{
+			name:        "page anchors",
+			markdown:    "<!-- page: 1 -->\nfirst\n<!-- page: 2 -->\nsecond\n<!-- page: 3-4 -->\nthird\n",
+			targetBytes: 50,
+			want:        []string{"<!-- page: 1 -->\nfirst\n<!-- page: 2 -->\nsecond\n", "<!-- page: 3-4 -->\nthird\n"},
+		},
+		{
+			name:        "an oversized page is a chunk of its own",
+			markdown:    "short" + pageSeparator + strings.Repeat("x", 50) + pageSeparator + "short",
+			targetBytes: 20,
+			want:        []string{"short", strings.Repeat("x", 50), "short"},
+		},
+		{
+			name:        "small document",
+			markdown:    "only page",
+			targetBytes: 100,
+			want:        []string{"only page"},
+		},
+		{
+			name:        "empty document",
+			targetBytes: 100,
+			want:        []string{""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitCleanerChunks(tt.markdown, tt.targetBytes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitCleanerChunks() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+// echoCleaner cleans a chunk by upper-casing it, answering later pages faster so that
+// chunks finish out of order.
+type echoCleaner struct{}
+
+func (echoCleaner) GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	chunk := string(parts[0].(genai.Text))
+	time.Sleep(time.Duration(10-len(chunk)%10) * time.Millisecond)
+	return testsupport.TextResponse(strings.ToUpper(chunk)), nil
+}
+
+func TestCleanInChunksStitchesInPageOrder(t *testing.T) {
+	var pages []string
+	for page := 1; page <= 8; page++ {
+		pages = append(pages, fmt.Sprintf("page %d%s", page, strings.Repeat(".", page)))
+	}
+	markdown := strings.Join(pages, pageSeparator)
+	store := objectstore.NewMemory()
+	bucket := store.Bucket("cleaned")
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	f := &CleanerFunction{model: echoCleaner{}, config: CleanerConfig{ChunkTargetBytes: 1, ChunkConcurrency: 3}}
+
+	cleaned, _, resume, err := f.cleanInChunks(context.Background(), logCtx, bucket, "doc-1", "doc-1", markdown, "clean")
+	if err != nil {
+		t.Fatalf("cleanInChunks() error = %v", err)
+	}
+	if want := strings.ToUpper(strings.Join(pages, "\n\n")); cleaned != want {
+		t.Errorf("cleanInChunks() =\n%s\nwant\n%s", cleaned, want)
+	}
+	if resume.Count != 8 || resume.Reused != 0 {
+		t.Errorf("resume = %+v, want 8 chunks, none reused", resume)
+	}
+	for i := range pages {
+		if _, ok := store.Get("cleaned", cleanedChunkObjectName("doc-1", i)); !ok {
+			t.Errorf("chunk %d was not checkpointed", i)
+		}
+	}
+}
+
+func TestCleanInChunksResumesFromCheckpoints(t *testing.T) {
+	markdown := strings.Join([]string{"alpha", "beta", "gamma"}, pageSeparator)
+	store := objectstore.NewMemory()
+	bucket := store.Bucket("cleaned")
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	f := &CleanerFunction{model: echoCleaner{}, config: CleanerConfig{ChunkTargetBytes: 1, ChunkConcurrency: 1}}
+	if _, _, _, err := f.cleanInChunks(context.Background(), logCtx, bucket, "doc-1", "doc-1", markdown, "clean"); err != nil {
+		t.Fatal(err)
+	}
+	// The last page changed since, so only its checkpoint is stale.
+	markdown = strings.Join([]string{"alpha", "beta", "delta"}, pageSeparator)
+
+	model := testsupport.NewFakeContentGenerator(
+		testsupport.FakeResult{Response: testsupport.TextResponse("DELTA")},
+		testsupport.FakeResult{Err: errors.New("cleaned a checkpointed chunk again")},
+	)
+	f.model = model
+	cleaned, _, resume, err := f.cleanInChunks(context.Background(), logCtx, bucket, "doc-1", "doc-1", markdown, "clean")
+	if err != nil {
+		t.Fatalf("cleanInChunks() error = %v", err)
+	}
+	if want := "ALPHA\n\nBETA\n\nDELTA"; cleaned != want {
+		t.Errorf("cleanInChunks() = %q, want %q", cleaned, want)
+	}
+	if want := (chunkResume{Count: 3, Reused: 2, ResumedFrom: 3}); resume != want {
+		t.Errorf("resume = %+v, want %+v", resume, want)
+	}
+	if calls := len(model.Calls()); calls != 1 {
+		t.Errorf("%d model calls, want 1", calls)
+	}
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// estimate answers a dry-run request: it reads the master file and prepares it as a
+// cleanup would, counts the input tokens of each model call that would clean it, and
+// returns their estimated cost. The model is not called, nothing is written and the
+// document's status is left as it is. Chunks checkpointed by an earlier attempt are
+// estimated as if cleaned again.
+func (f *CleanerFunction) estimate(ctx context.Context, logCtx *slog.Logger, req *models.MarkdownCleanerRequest) (*models.MarkdownCleanerResponse, error) {
+	logCtx = logCtx.With("dryRun", true)
+	if _, _, err := gcp.ValidateDocumentURI(req.MasterGCSUri, req.DocumentID, req.TenantID, f.config.MasterBucket); err != nil {
+		logCtx.Error("Invalid cleanup request", "error", err, "gcsUri", req.MasterGCSUri)
+		return nil, err
+	}
+	markdown, err := readMarkdown(ctx, f.storageClient, req.MasterGCSUri)
+	if err != nil {
+		logCtx.Error("Failed to read master file", "error", err, "gcsUri", req.MasterGCSUri)
+		return nil, sourceError(err)
+	}
+	if strings.TrimSpace(markdown) == "" {
+		err := fmt.Errorf("%w: %s", ErrEmptyMarkdown, req.MasterGCSUri)
+		logCtx.Error("Master file has no content to clean", "error", err)
+		return nil, sourceError(err)
+	}
+	mode := f.modeFor(ctx, logCtx, req.DocumentID)
+	language := markdownLanguage(req.SourceLanguage, req.TargetLanguage)
+	modelName := f.config.Models.CleanerModelName
+	estimate := f.config.Estimate.estimate(modelName, 0)
+	res := &models.MarkdownCleanerResponse{
+		Status:   statusDryRun,
+		Mode:     mode,
+		Language: language,
+		Estimate: &estimate,
+	}
+	if mode == CleanerModeDeterministic {
+		logCtx.Info("Deterministic cleanup calls no model; estimating no cost.")
+		return res, nil
+	}
+	if mode != CleanerModeLLM {
+		markdown = f.cleanDeterministically(markdown)
+	}
+	if f.tokenCounter == nil {
+		logCtx.Error("Cannot estimate the cleanup", "error", errDryRunUnavailable)
+		return nil, models.WithCode(models.ErrorCodeInvalidRequest, errDryRunUnavailable)
+	}
+
+	chunks := []string{markdown}
+	if int64(len(markdown)) > f.config.ChunkThresholdBytes {
+		chunks = splitCleanerChunks(markdown, f.config.ChunkTargetBytes)
+	}
+	prompt := cleanerPrompt(language)
+	for i, chunk := range chunks {
+		inputTokens, err := countTokens(ctx, f.tokenCounter, genai.Text(chunk), genai.Text(prompt))
+		if err != nil {
+			logCtx.Error("Failed to count the tokens of the master file", "error", err, "chunk", i+1)
+			return nil, err
+		}
+		addCostEstimate(&estimate, f.config.Estimate.estimate(modelName, inputTokens))
+	}
+	res.ChunkCount, res.Model = len(chunks), modelName
+	logCtx.Info("Estimated cleanup cost.", "chunkCount", len(chunks), "inputTokens", estimate.InputTokens, "outputTokensLow", estimate.OutputTokensLow, "outputTokensHigh", estimate.OutputTokensHigh)
+	return res, nil
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/markdown"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Cleaner modes. LLM sends the master file to the cleaner model as it is; deterministic
+// only runs markdown.Clean over it, without calling the model; hybrid runs markdown.Clean
+// first and sends the result to the model, so the model has less mechanical work left.
+const (
+	CleanerModeLLM           = "llm"
+	CleanerModeDeterministic = "deterministic"
+	CleanerModeHybrid        = "hybrid"
+)
+
+// loadMode reads CLEANER_MODE and CLEANER_TABLE_HEADER_SIMILARITY into c.
+func (c *CleanerConfig) loadMode() error {
+	mode := gcp.GetEnv("CLEANER_MODE", CleanerModeLLM)
+	if mode != CleanerModeLLM && mode != CleanerModeDeterministic && mode != CleanerModeHybrid {
+		return fmt.Errorf("CLEANER_MODE must be %q, %q or %q, got %q", CleanerModeLLM, CleanerModeDeterministic, CleanerModeHybrid, mode)
+	}
+	c.Mode = mode
+	similarity, err := strconv.ParseFloat(gcp.GetEnv("CLEANER_TABLE_HEADER_SIMILARITY", "0.8"), 64)
+	if err != nil || similarity < 0 || similarity > 1 {
+		return fmt.Errorf("CLEANER_TABLE_HEADER_SIMILARITY must be a number between 0 and 1")
+	}
+	c.TableHeaderSimilarity = similarity
+	return nil
+}
+
+// modeFor returns the cleaner mode of a document: its processing profile's, if set,
+// otherwise the configured one.
+func (f *CleanerFunction) modeFor(ctx context.Context, logCtx *slog.Logger, docID string) string {
+	if profile := f.profiles.ResolveOrDefault(ctx, logCtx, docID); profile.CleanerMode != "" {
+		return profile.CleanerMode
+	}
+	return f.config.Mode
+}
+
+// cleanDeterministically runs the cleanup passes that need no model over content.
+func (f *CleanerFunction) cleanDeterministically(content string) string {
+	return markdown.Clean(content, f.config.TableHeaderSimilarity)
+}
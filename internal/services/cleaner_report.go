@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/markdown"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// cleanReportName is the object name, under the document prefix, of the clean report.
+const cleanReportName = "clean_report.json"
+
+// ErrExcessiveShrinkage is returned, and the cleaned markdown not saved, when it keeps
+// less of the master file than CLEANER_MIN_RETAINED_RATIO allows. A model that drops
+// tables or whole pages still answers successfully, so this is the only sign of it.
+var ErrExcessiveShrinkage = errors.New("cleaned markdown is much shorter than its input")
+
+// loadReport reads CLEANER_MIN_RETAINED_RATIO and CLEANER_REPORT into c.
+func (c *CleanerConfig) loadReport() error {
+	minRetained, err := strconv.ParseFloat(gcp.GetEnv("CLEANER_MIN_RETAINED_RATIO", "0.7"), 64)
+	if err != nil || minRetained < 0 || minRetained > 1 {
+		return fmt.Errorf("CLEANER_MIN_RETAINED_RATIO must be a number between 0 and 1")
+	}
+	c.MinRetainedRatio = minRetained
+	writeReport, err := strconv.ParseBool(gcp.GetEnv("CLEANER_REPORT", "false"))
+	if err != nil {
+		return fmt.Errorf("CLEANER_REPORT must be a boolean")
+	}
+	c.WriteReport = writeReport
+	return nil
+}
+
+// compareCleaned measures the master file and the cleaned markdown and reports whether
+// the cleaned markdown kept enough of it. The error wraps ErrExcessiveShrinkage when it
+// did not.
+func (f *CleanerFunction) compareCleaned(docID, master, cleaned string) (*models.CleanReport, error) {
+	report := &models.CleanReport{
+		DocumentID:       docID,
+		Input:            models.MarkdownStats(markdown.Measure(master)),
+		Output:           models.MarkdownStats(markdown.Measure(cleaned)),
+		RetainedRatio:    1,
+		MinRetainedRatio: f.config.MinRetainedRatio,
+	}
+	if report.Input.Characters > 0 {
+		report.RetainedRatio = float64(report.Output.Characters) / float64(report.Input.Characters)
+	}
+	if report.RetainedRatio >= f.config.MinRetainedRatio {
+		return report, nil
+	}
+	report.Rejected = true
+	err := fmt.Errorf("%w: kept %d of %d characters (%.2f), below the minimum of %.2f",
+		ErrExcessiveShrinkage, report.Output.Characters, report.Input.Characters, report.RetainedRatio, f.config.MinRetainedRatio)
+	return report, models.WithCode(models.ErrorCodeOutputRejected, err)
+}
+
+// saveCleanReport writes report under root, the document's prefix, next to the default
+// name of the cleaned markdown. The report is diagnostic, so a failure is only logged.
+func saveCleanReport(ctx context.Context, logCtx *slog.Logger, bucket objectstore.Bucket, root string, report *models.CleanReport) {
+	objectName := fmt.Sprintf("%s/%s", root, cleanReportName)
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		logCtx.Warn("Failed to encode clean report", "error", err)
+		return
+	}
+	if err := gcp.SaveToGCSAtomically(ctx, logCtx, bucket, objectName, string(data)); err != nil {
+		logCtx.Warn("Failed to save clean report", "error", err, "gcsObject", objectName)
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+func TestCompareCleaned(t *testing.T) {
+	master := strings.Repeat("x", 100)
+	tests := []struct {
+		name         string
+		minRetained  float64
+		master       string
+		cleaned      string
+		wantRatio    float64
+		wantRejected bool
+	}{
+		{name: "unchanged", minRetained: 0.7, master: master, cleaned: master, wantRatio: 1},
+		{name: "at the minimum", minRetained: 0.7, master: master, cleaned: master[:70], wantRatio: 0.7},
+		{name: "just under the minimum", minRetained: 0.7, master: master, cleaned: master[:69], wantRatio: 0.69, wantRejected: true},
+		{name: "emptied", minRetained: 0.7, master: master, cleaned: "", wantRatio: 0, wantRejected: true},
+		{name: "grew", minRetained: 0.7, master: master[:50], cleaned: master, wantRatio: 2},
+		{name: "empty master", minRetained: 0.7, master: "", cleaned: "", wantRatio: 1},
+		{name: "no minimum", minRetained: 0, master: master, cleaned: "", wantRatio: 0},
+		{name: "whole master required", minRetained: 1, master: master, cleaned: master[:99], wantRatio: 0.99, wantRejected: true},
+		// Characters are counted as runes: 7 of 10 kept, though 14 of 20 bytes.
+		{name: "multibyte characters", minRetained: 0.7, master: strings.Repeat("é", 10), cleaned: strings.Repeat("é", 7), wantRatio: 0.7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &CleanerFunction{config: CleanerConfig{MinRetainedRatio: tt.minRetained}}
+			report, err := f.compareCleaned("doc-1", tt.master, tt.cleaned)
+			if tt.wantRejected {
+				if !errors.Is(err, ErrExcessiveShrinkage) || models.CodeOf(err) != models.ErrorCodeOutputRejected {
+					t.Errorf("compareCleaned() error = %v (code %s), want ErrExcessiveShrinkage with code %s", err, models.CodeOf(err), models.ErrorCodeOutputRejected)
+				}
+			} else if err != nil {
+				t.Errorf("compareCleaned() error = %v", err)
+			}
+			if report == nil {
+				t.Fatal("compareCleaned() returned no report")
+			}
+			if diff := report.RetainedRatio - tt.wantRatio; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("RetainedRatio = %v, want %v", report.RetainedRatio, tt.wantRatio)
+			}
+			if report.Rejected != tt.wantRejected || report.MinRetainedRatio != tt.minRetained || report.DocumentID != "doc-1" {
+				t.Errorf("report = %+v, want rejected %v", report, tt.wantRejected)
+			}
+		})
+	}
+}
+
+func TestCompareCleanedStats(t *testing.T) {
+	const (
+		master  = "# Pump\n\n| Part | Clearance |\n|---|---|\n| Impeller | 0.35 mm |\n\n## Seal\n\nText.\n"
+		cleaned = "# Pump\n\nText.\n"
+	)
+	f := &CleanerFunction{config: CleanerConfig{MinRetainedRatio: 0}}
+	report, err := f.compareCleaned("doc-1", master, cleaned)
+	if err != nil {
+		t.Fatalf("compareCleaned() error = %v", err)
+	}
+	if want := (models.MarkdownStats{Characters: len(master), Lines: 9, Tables: 1, Headings: 2}); report.Input != want {
+		t.Errorf("Input = %+v, want %+v", report.Input, want)
+	}
+	if want := (models.MarkdownStats{Characters: len(cleaned), Lines: 3, Headings: 1}); report.Output != want {
+		t.Errorf("Output = %+v, want %+v", report.Output, want)
+	}
+}
+
+func TestSaveCleanReport(t *testing.T) {
+	store := objectstore.NewMemory()
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	report := &models.CleanReport{DocumentID: "doc-1", RetainedRatio: 0.5, MinRetainedRatio: 0.7, Rejected: true}
+
+	saveCleanReport(context.Background(), logCtx, store.Bucket("cleaned"), "doc-1", report)
+	data, ok := store.Get("cleaned", "doc-1/"+cleanReportName)
+	if !ok {
+		t.Fatal("clean report not saved")
+	}
+	var got models.CleanReport
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("clean report is not JSON: %v", err)
+	}
+	if got != *report {
+		t.Errorf("saved report = %+v, want %+v", got, *report)
+	}
+
+	// A failed write is only logged.
+	store.FailWrites(errors.New("bucket unavailable"))
+	saveCleanReport(context.Background(), logCtx, store.Bucket("cleaned"), "doc-2", report)
+	if _, ok := store.Get("cleaned", "doc-2/"+cleanReportName); ok {
+		t.Error("clean report saved despite the failing bucket")
+	}
+}
+
+func TestCleanerConfigLoadReport(t *testing.T) {
+	tests := []struct {
+		name            string
+		env             map[string]string
+		wantMinRetained float64
+		wantReport      bool
+		wantErr         bool
+	}{
+		{name: "defaults", wantMinRetained: 0.7},
+		{name: "set", env: map[string]string{"CLEANER_MIN_RETAINED_RATIO": "0.5", "CLEANER_REPORT": "true"}, wantMinRetained: 0.5, wantReport: true},
+		{name: "ratio over 1", env: map[string]string{"CLEANER_MIN_RETAINED_RATIO": "1.1"}, wantErr: true},
+		{name: "negative ratio", env: map[string]string{"CLEANER_MIN_RETAINED_RATIO": "-0.1"}, wantErr: true},
+		{name: "report not a boolean", env: map[string]string{"CLEANER_REPORT": "sometimes"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"CLEANER_MIN_RETAINED_RATIO", "CLEANER_REPORT"} {
+				t.Setenv(key, "")
+				os.Unsetenv(key)
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			var c CleanerConfig
+			err := c.loadReport()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (c.MinRetainedRatio != tt.wantMinRetained || c.WriteReport != tt.wantReport) {
+				t.Errorf("loadReport() = min retained %v, report %v; want %v, %v", c.MinRetainedRatio, c.WriteReport, tt.wantMinRetained, tt.wantReport)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"cloud.google.com/go/vertexai/genai"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestCleanPart(t *testing.T) {
+	tests := []struct {
+		name         string
+		result       testsupport.FakeResult
+		want         string
+		wantRefusal  bool
+		wantFiltered bool
+		wantErr      bool
+	}{
+		{name: "cleaned", result: testsupport.FakeResult{Response: testsupport.TextResponse("# Clean\n\nText.")}, want: "# Clean\n\nText."},
+		{name: "fenced", result: testsupport.FakeResult{Response: testsupport.TextResponse("```markdown\n# Clean\n```")}, want: "# Clean"},
+		{name: "multiple parts", result: testsupport.FakeResult{Response: testsupport.TextResponse("# Clean\n", "Text.")}, want: "# Clean\nText."},
+		{name: "empty candidate", result: testsupport.FakeResult{Response: &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{}}}}},
+		{
+			name:        "refusal",
+			result:      testsupport.FakeResult{Response: testsupport.TextResponse("I am unable to process this document.")},
+			wantRefusal: true,
+		},
+		{
+			name:        "refusal in another case",
+			result:      testsupport.FakeResult{Response: testsupport.TextResponse("As a Large Language Model, I cannot fulfill this.")},
+			wantRefusal: true,
+		},
+		{
+			name:         "blocked prompt",
+			result:       testsupport.FakeResult{Response: testsupport.BlockedResponse(genai.BlockedReasonOther)},
+			wantFiltered: true,
+		},
+		{
+			name:         "blocked candidate",
+			result:       testsupport.FakeResult{Err: testsupport.BlockedCandidateError(genai.HarmCategoryHateSpeech)},
+			wantFiltered: true,
+		},
+		{name: "failed call", result: testsupport.FakeResult{Err: errors.New("connection reset")}, wantErr: true},
+	}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := testsupport.NewFakeContentGenerator(tt.result)
+			f := &CleanerFunction{model: model}
+			got, _, err := f.cleanPart(context.Background(), logCtx, genai.Text("# master"), "clean")
+			var filtered *FilteredResponseError
+			switch {
+			case tt.wantRefusal:
+				if !errors.Is(err, errModelRefusal) {
+					t.Fatalf("cleanPart() error = %v, want a refusal", err)
+				}
+			case tt.wantFiltered:
+				if !errors.As(err, &filtered) {
+					t.Fatalf("cleanPart() error = %v, want a FilteredResponseError", err)
+				}
+			case tt.wantErr:
+				if err == nil {
+					t.Fatal("cleanPart() error = nil, want one")
+				}
+			default:
+				if err != nil {
+					t.Fatalf("cleanPart() error = %v", err)
+				}
+			}
+			if got != tt.want {
+				t.Errorf("cleanPart() = %q, want %q", got, tt.want)
+			}
+			if calls := model.Calls(); len(calls) != 1 || calls[0][1] != genai.Text("clean") {
+				t.Errorf("model calls = %v, want one with the prompt after the content", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"errors"
+	"io"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// serviceClients are the clients a service created. Embedding it gives the service a
+// Close method, which the function's entry point calls when the instance shuts down so
+// that connections drain rather than being cut.
+type serviceClients []io.Closer
+
+// Close closes every client in order and returns their errors joined.
+func (c serviceClients) Close() error {
+	var errs []error
+	for _, client := range c {
+		if err := client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// closerFunc adapts a function to io.Closer.
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
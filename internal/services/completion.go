@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// StatusComplete is recorded on a document once its sections have been saved.
+const StatusComplete = "COMPLETE"
+
+// complete marks the document COMPLETE, publishes a CompletionNotification when a
+// completion topic is configured, and deletes the document's intermediates when
+// CleanupIntermediates is set. None of these steps fails the stage: the sections are
+// already saved, so problems are logged and outcomes are recorded on the document instead.
+func (f *SectionSplitterFunction) complete(ctx context.Context, logCtx *slog.Logger, docID string, sectionCount int, splitStatus string) {
+	docRef := f.firestoreClient.Collection(f.config.CollectionName).Doc(docID)
+	if err := gcp.AppendStatusTransition(ctx, docRef, StatusComplete, models.TokenUsageStageSectionSplitter, ""); err != nil {
+		logCtx.Error("Failed to mark document complete", "error", err)
+	} else {
+		gcp.RecordDocumentCompleted(ctx)
+	}
+	f.notifyCompletion(ctx, logCtx, docRef, sectionCount, splitStatus)
+	if f.config.CleanupIntermediates {
+		counts, err := cleanupIntermediates(ctx, logCtx, f.firestoreClient, f.config.CollectionName, f.store, f.config.Buckets, f.config.OutputNames, docID)
+		if err != nil {
+			logCtx.Error("Failed to clean up intermediates", "error", err, "deletedObjectCounts", counts)
+		} else {
+			logCtx.Info("Cleaned up intermediates.", "deletedObjectCounts", counts)
+		}
+	}
+}
+
+// notifyCompletion publishes the CompletionNotification, if a completion topic is
+// configured, and records the outcome on the document.
+func (f *SectionSplitterFunction) notifyCompletion(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, sectionCount int, splitStatus string) {
+	if f.completionTopic == nil {
+		return
+	}
+
+	updates := []firestore.Update{
+		{Path: "notificationStatus", Value: models.NotificationStatusPublished},
+		{Path: "notificationError", Value: firestore.Delete},
+	}
+	messageID, err := f.publishCompletion(ctx, docRef, sectionCount, splitStatus)
+	if err != nil {
+		logCtx.Error("Failed to publish completion notification", "error", err, "topic", f.config.CompletionTopic)
+		updates = []firestore.Update{
+			{Path: "notificationStatus", Value: models.NotificationStatusFailed},
+			{Path: "notificationError", Value: err.Error()},
+		}
+	} else {
+		logCtx.Info("Published completion notification.", "topic", f.config.CompletionTopic, "messageId", messageID)
+	}
+	if err := gcp.UpdateWithRetry(ctx, docRef, updates); err != nil {
+		logCtx.Error("Failed to record notification status", "error", err)
+	}
+}
+
+// publishCompletion builds the notification from the document record and publishes it,
+// returning the server-assigned message ID.
+func (f *SectionSplitterFunction) publishCompletion(ctx context.Context, docRef *firestore.DocumentRef, sectionCount int, splitStatus string) (string, error) {
+	snap, err := docRef.Get(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to read document %s: %w", docRef.ID, err)
+	}
+	var doc models.Document
+	if err := snap.DataTo(&doc); err != nil {
+		return "", fmt.Errorf("failed to decode document %s: %w", docRef.ID, err)
+	}
+	outputDoc, err := f.config.OutputNames.DocumentFor(docRef.ID, &doc)
+	if err != nil {
+		return "", err
+	}
+	sections, err := f.config.OutputNames.SectionMatcher(outputDoc)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(models.CompletionNotification{
+		DocumentID:       docRef.ID,
+		TenantID:         doc.TenantID,
+		FileHash:         doc.FileHash,
+		OriginalFilename: doc.OriginalFilename,
+		SectionCount:     sectionCount,
+		SectionsPrefix:   fmt.Sprintf("gs://%s/%s", f.config.FinalSectionsBucket, sections.Prefix),
+		Status:           splitStatus,
+		CompletedAt:      time.Now().UTC(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode completion notification: %w", err)
+	}
+
+	result := f.completionTopic.Publish(ctx, &pubsub.Message{
+		Data:       data,
+		Attributes: map[string]string{"documentId": docRef.ID},
+	})
+	messageID, err := result.Get(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to publish to %s: %w", f.config.CompletionTopic, err)
+	}
+	return messageID, nil
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// nextStage is the stage a run moves on to once each stage has completed.
+var nextStage = map[string]string{
+	models.PipelineRunStageTranslator:      models.PipelineRunStageAggregator,
+	models.PipelineRunStageAggregator:      models.PipelineRunStageCleaner,
+	models.PipelineRunStageCleaner:         models.PipelineRunStageSectionSplitter,
+	models.PipelineRunStageSectionSplitter: models.PipelineRunStageComplete,
+}
+
+// CoordinatorConfig holds configuration for the pipeline coordinator.
+type CoordinatorConfig struct {
+	ProjectID      string
+	CollectionName string
+	Orchestration  OrchestrationConfig
+}
+
+// CoordinatorFunction chains the stages in the Pub/Sub orchestration mode, in place of the
+// processing workflow. It counts down a run's pages as their translations complete, and
+// publishes the request of the next stage once the last page, or a later stage, is done.
+//
+// Pub/Sub delivers each completion at least once. A run's record is only changed in a
+// transaction that first checks the completion is for the stage, and page, the run is
+// waiting on, so a redelivered completion is counted once. The next stage's request can
+// still be published twice, when duplicates of the completion that ends a stage arrive
+// together; the stages are safe to run again, as they are when a workflow retries them.
+type CoordinatorFunction struct {
+	serviceClients
+	firestoreClient *firestore.Client
+	// topics holds the request topic of each stage the coordinator dispatches, keyed by
+	// stage name. The splitter dispatches the translator itself.
+	topics map[string]*pubsub.Topic
+	config CoordinatorConfig
+}
+
+// NewCoordinator creates a new CoordinatorFunction instance.
+func NewCoordinator(ctx context.Context) (*CoordinatorFunction, error) {
+	projectID := gcp.GetEnv("PROJECT_ID", "")
+	if projectID == "" {
+		return nil, fmt.Errorf("PROJECT_ID environment variable must be set")
+	}
+	orchestration, err := loadOrchestrationConfig()
+	if err != nil {
+		return nil, err
+	}
+	if orchestration.AggregateTopic == "" || orchestration.CleanTopic == "" || orchestration.SectionSplitTopic == "" {
+		return nil, fmt.Errorf("AGGREGATE_TOPIC, CLEAN_TOPIC and SECTION_SPLIT_TOPIC must be set")
+	}
+	config := CoordinatorConfig{
+		ProjectID:      projectID,
+		CollectionName: gcp.GetEnv("FIRESTORE_COLLECTION", "documents"),
+		Orchestration:  orchestration,
+	}
+
+	if err := gcp.InitTracing(ctx, config.ProjectID); err != nil {
+		return nil, err
+	}
+	firestoreClient, err := gcp.NewFirestoreClient(ctx, config.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firestore client: %w", err)
+	}
+	topics, closeTopics, err := pubSubTopics(ctx, config.ProjectID, orchestration.AggregateTopic, orchestration.CleanTopic, orchestration.SectionSplitTopic)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Pipeline coordinator initialized.", "aggregateTopic", orchestration.AggregateTopic, "cleanTopic", orchestration.CleanTopic, "sectionSplitTopic", orchestration.SectionSplitTopic)
+
+	return &CoordinatorFunction{
+		serviceClients:  serviceClients{firestoreClient, closeTopics},
+		firestoreClient: firestoreClient,
+		topics: map[string]*pubsub.Topic{
+			models.PipelineRunStageAggregator:      topics[0],
+			models.PipelineRunStageCleaner:         topics[1],
+			models.PipelineRunStageSectionSplitter: topics[2],
+		},
+		config: config,
+	}, nil
+}
+
+// ProcessMessage handles a StageCompletedEvent delivered by Pub/Sub. A nil result
+// acknowledges the message and an error has Pub/Sub redeliver it.
+func (f *CoordinatorFunction) ProcessMessage(ctx context.Context, data []byte) error {
+	var event models.StageCompletedEvent
+	messageID, err := gcp.DecodePubSubMessage(data, &event)
+	if err == nil && (event.DocumentID == "" || event.RunID == "" || event.Stage == "") {
+		err = fmt.Errorf("documentId, runId and stage are required")
+	}
+	if err != nil {
+		slog.Error("Dropping a stage completion that cannot be processed", "error", err, "messageId", messageID)
+		return nil
+	}
+	return f.Process(ctx, &event)
+}
+
+// Process records a stage completion on its run, then publishes the request of the stage
+// the run is waiting on if it has not been published yet. It returns an error, for the
+// completion to be redelivered, only when either could not be done. A completion of a
+// document whose cancellation was requested is ignored, so that a stage finishing after
+// the cancellation does not start the next one.
+func (f *CoordinatorFunction) Process(ctx context.Context, event *models.StageCompletedEvent) error {
+	logCtx := slog.With("documentId", event.DocumentID, "executionId", event.RunID, "stage", event.Stage, "pageNumber", event.Page)
+	docRef := f.firestoreClient.Collection(f.config.CollectionName).Doc(event.DocumentID)
+	ref := runRef(f.firestoreClient, f.config.CollectionName, event.DocumentID, event.RunID)
+
+	var run models.PipelineRun
+	var counted, cancelled bool
+	err := f.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		snap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		run, counted = models.PipelineRun{}, false
+		requested, _ := docSnap.DataAt("cancelRequested")
+		if cancelled = requested == true; cancelled {
+			return nil
+		}
+		if err := snap.DataTo(&run); err != nil {
+			return fmt.Errorf("failed to decode pipeline run: %w", err)
+		}
+		updates, ok := recordCompletion(&run, event, time.Now())
+		if !ok {
+			return nil
+		}
+		counted = true
+		return tx.Update(ref, updates)
+	})
+	if status.Code(err) == codes.NotFound {
+		logCtx.Error("Dropping a completion for an unknown pipeline run")
+		return nil
+	}
+	if err != nil {
+		logCtx.Error("Failed to record stage completion", "error", err)
+		return fmt.Errorf("failed to record stage completion: %w", err)
+	}
+
+	if cancelled {
+		logCtx.Info("Ignoring a stage completion of a cancelled document.")
+		return nil
+	}
+	switch {
+	case !counted:
+		logCtx.Info("Ignoring a duplicate or out-of-date stage completion.", "runStage", run.Stage)
+	case run.Stage == models.PipelineRunStageFailed:
+		logCtx.Error("Pipeline run failed.", "error", event.Error)
+	case run.Stage == models.PipelineRunStageComplete:
+		logCtx.Info("Pipeline run complete.")
+	case run.Stage == models.PipelineRunStageTranslator:
+		logCtx.Info("Page translated.", "remaining", run.Remaining)
+	}
+	return f.dispatch(ctx, logCtx, ref, event.DocumentID, event.RunID, &run)
+}
+
+// recordCompletion applies a stage completion to run and returns the updates that store
+// it, or false when the completion is a duplicate or out of date: for a stage the run is
+// not waiting on, or for a page already counted. The run moves on to the next stage once
+// its last page, or any later stage, completes, and fails when a stage failed.
+func recordCompletion(run *models.PipelineRun, event *models.StageCompletedEvent, now time.Time) ([]firestore.Update, bool) {
+	if run.Stage != event.Stage {
+		return nil, false
+	}
+	var updates []firestore.Update
+	if event.Stage == models.PipelineRunStageTranslator {
+		i := slices.Index(run.PendingPages, event.Page)
+		if i < 0 {
+			return nil, false
+		}
+		run.PendingPages = slices.Delete(run.PendingPages, i, i+1)
+		run.Remaining--
+		updates = append(updates,
+			firestore.Update{Path: "pendingPages", Value: run.PendingPages},
+			firestore.Update{Path: "remaining", Value: run.Remaining},
+		)
+	}
+
+	switch {
+	case event.Status == models.StageCompletedStatusFailed:
+		run.Stage = models.PipelineRunStageFailed
+		run.ErrorDetails = fmt.Sprintf("%s failed: %s", event.Stage, event.Error)
+		updates = append(updates, firestore.Update{Path: "errorDetails", Value: run.ErrorDetails})
+	case run.Remaining > 0 && event.Stage == models.PipelineRunStageTranslator:
+		return append(updates, firestore.Update{Path: "updatedAt", Value: now}), true
+	default:
+		switch event.Stage {
+		case models.PipelineRunStageAggregator:
+			run.MasterGCSUri = event.OutputGCSUri
+			updates = append(updates, firestore.Update{Path: "masterGcsUri", Value: run.MasterGCSUri})
+		case models.PipelineRunStageCleaner:
+			run.CleanedGCSUri = event.OutputGCSUri
+			updates = append(updates, firestore.Update{Path: "cleanedGcsUri", Value: run.CleanedGCSUri})
+		}
+		run.Stage = nextStage[event.Stage]
+	}
+	run.UpdatedAt = now
+	return append(updates,
+		firestore.Update{Path: "stage", Value: run.Stage},
+		firestore.Update{Path: "updatedAt", Value: now},
+	), true
+}
+
+// dispatch publishes the request of the stage run is waiting on, unless the coordinator
+// does not dispatch that stage or has already done so, and records that it did. The
+// completion is redelivered if the publish fails, and publishes the request again.
+func (f *CoordinatorFunction) dispatch(ctx context.Context, logCtx *slog.Logger, ref *firestore.DocumentRef, docID, runID string, run *models.PipelineRun) error {
+	topic, ok := f.topics[run.Stage]
+	if !ok || slices.Contains(run.Dispatched, run.Stage) {
+		return nil
+	}
+	messageID, err := gcp.PublishJSON(ctx, topic, stageRequest(docID, runID, run), map[string]string{"documentId": docID, "stage": run.Stage})
+	if err != nil {
+		logCtx.Error("Failed to dispatch the next stage; the completion will be redelivered.", "error", err, "nextStage", run.Stage)
+		return err
+	}
+	logCtx.Info("Dispatched the next stage.", "nextStage", run.Stage, "messageId", messageID)
+	if _, err := ref.Update(ctx, []firestore.Update{{Path: "dispatched", Value: firestore.ArrayUnion(run.Stage)}}); err != nil {
+		// The request is published; a redelivery would only publish it again.
+		logCtx.Warn("Dispatched the next stage but could not record it", "error", err, "nextStage", run.Stage)
+	}
+	return nil
+}
+
+// stageRequest builds the request of the stage run is waiting on.
+func stageRequest(docID, runID string, run *models.PipelineRun) any {
+	switch run.Stage {
+	case models.PipelineRunStageAggregator:
+		return &models.MarkdownAggregatorRequest{DocumentID: docID, TenantID: run.TenantID, ExecutionID: runID, Traceparent: run.Traceparent}
+	case models.PipelineRunStageCleaner:
+		return &models.MarkdownCleanerRequest{
+			DocumentID:     docID,
+			TenantID:       run.TenantID,
+			MasterGCSUri:   run.MasterGCSUri,
+			ExecutionID:    runID,
+			Traceparent:    run.Traceparent,
+			SourceLanguage: run.SourceLanguage,
+			TargetLanguage: run.TargetLanguage,
+		}
+	default:
+		return &models.SectionSplitterRequest{DocumentID: docID, TenantID: run.TenantID, CleanedGCSUri: run.CleanedGCSUri, ExecutionID: runID, Traceparent: run.Traceparent}
+	}
+}
@@ -0,0 +1,327 @@
+//go:build integration
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+// coordinatorFixture is a coordinator over the Firestore emulator whose stage topics are
+// on a fake Pub/Sub server.
+type coordinatorFixture struct {
+	f      *CoordinatorFunction
+	pubsub *testsupport.FakePubSub
+	client *pubsub.Client
+}
+
+func newCoordinatorFixture(ctx context.Context, t *testing.T) *coordinatorFixture {
+	t.Helper()
+	firestoreClient, _ := testsupport.RequireEmulators(t).Clients(ctx, t)
+	fake := testsupport.NewFakePubSub(t)
+	client := fake.Client(ctx, t)
+	topics := make(map[string]*pubsub.Topic)
+	for _, stage := range []string{models.PipelineRunStageAggregator, models.PipelineRunStageCleaner, models.PipelineRunStageSectionSplitter} {
+		topic, err := client.CreateTopic(ctx, stage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(topic.Stop)
+		topics[stage] = topic
+	}
+	return &coordinatorFixture{
+		f: &CoordinatorFunction{
+			firestoreClient: firestoreClient,
+			topics:          topics,
+			config:          CoordinatorConfig{CollectionName: fmt.Sprintf("documents-%d", time.Now().UnixNano())},
+		},
+		pubsub: fake,
+		client: client,
+	}
+}
+
+// startRun records a run of doc-1 waiting on the translations of pages.
+func (c *coordinatorFixture) startRun(ctx context.Context, t *testing.T, pages ...int) *firestore.DocumentRef {
+	t.Helper()
+	ref := runRef(c.f.firestoreClient, c.f.config.CollectionName, "doc-1", "run-1")
+	now := time.Now()
+	run := models.PipelineRun{
+		Stage:        models.PipelineRunStageTranslator,
+		PendingPages: pages,
+		Remaining:    len(pages),
+		Dispatched:   []string{models.PipelineRunStageTranslator},
+		TenantID:     "acme",
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if _, err := ref.Create(ctx, run); err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func (c *coordinatorFixture) run(ctx context.Context, t *testing.T, ref *firestore.DocumentRef) models.PipelineRun {
+	t.Helper()
+	snap, err := ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var run models.PipelineRun
+	if err := snap.DataTo(&run); err != nil {
+		t.Fatal(err)
+	}
+	return run
+}
+
+func pageCompleted(page int) *models.StageCompletedEvent {
+	return &models.StageCompletedEvent{DocumentID: "doc-1", RunID: "run-1", Stage: models.PipelineRunStageTranslator, Page: page, Status: "success"}
+}
+
+func TestCoordinatorCountsRedeliveredCompletionsOnce(t *testing.T) {
+	ctx := context.Background()
+	c := newCoordinatorFixture(ctx, t)
+	ref := c.startRun(ctx, t, 1, 2, 3)
+
+	for _, page := range []int{1, 1, 2, 1} {
+		if err := c.f.Process(ctx, pageCompleted(page)); err != nil {
+			t.Fatalf("Process(page %d) error = %v", page, err)
+		}
+	}
+	run := c.run(ctx, t, ref)
+	if run.Stage != models.PipelineRunStageTranslator || run.Remaining != 1 || !slices.Equal(run.PendingPages, []int{3}) {
+		t.Fatalf("run after redelivered completions = stage %s, remaining %d, pending %v; want translator, 1, [3]", run.Stage, run.Remaining, run.PendingPages)
+	}
+	if messages := c.pubsub.Messages(models.PipelineRunStageAggregator); len(messages) != 0 {
+		t.Fatalf("aggregator dispatched %d times before the last page", len(messages))
+	}
+
+	// The last page, delivered as a Pub/Sub message and then redelivered.
+	event, err := json.Marshal(pageCompleted(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(map[string]any{"message": map[string]any{"data": event, "messageId": "m-3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := c.f.ProcessMessage(ctx, data); err != nil {
+			t.Fatalf("ProcessMessage(page 3) error = %v", err)
+		}
+	}
+	run = c.run(ctx, t, ref)
+	if run.Stage != models.PipelineRunStageAggregator || run.Remaining != 0 || len(run.PendingPages) != 0 {
+		t.Errorf("run after the last page = stage %s, remaining %d, pending %v; want aggregator, 0, []", run.Stage, run.Remaining, run.PendingPages)
+	}
+	if !slices.Contains(run.Dispatched, models.PipelineRunStageAggregator) {
+		t.Errorf("run.Dispatched = %v, want the aggregator recorded", run.Dispatched)
+	}
+	messages := c.pubsub.Messages(models.PipelineRunStageAggregator)
+	if len(messages) != 1 {
+		t.Fatalf("aggregator dispatched %d times, want once", len(messages))
+	}
+	var req models.MarkdownAggregatorRequest
+	if err := json.Unmarshal(messages[0].Data, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.DocumentID != "doc-1" || req.ExecutionID != "run-1" || req.TenantID != "acme" {
+		t.Errorf("aggregator request = %+v", req)
+	}
+}
+
+func TestCoordinatorRacingCompletions(t *testing.T) {
+	ctx := context.Background()
+	c := newCoordinatorFixture(ctx, t)
+	const pages = 8
+	pageNumbers := make([]int, pages)
+	for i := range pageNumbers {
+		pageNumbers[i] = i + 1
+	}
+	ref := c.startRun(ctx, t, pageNumbers...)
+
+	var wg sync.WaitGroup
+	errs := make([]error, pages)
+	for i, page := range pageNumbers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = c.f.Process(ctx, pageCompleted(page))
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Process(page %d) error = %v", i+1, err)
+		}
+	}
+
+	run := c.run(ctx, t, ref)
+	if run.Stage != models.PipelineRunStageAggregator || run.Remaining != 0 || len(run.PendingPages) != 0 {
+		t.Errorf("run after racing completions = stage %s, remaining %d, pending %v; want aggregator, 0, []", run.Stage, run.Remaining, run.PendingPages)
+	}
+	// Only the completion that counted the last page sees the run reach the aggregator.
+	if messages := c.pubsub.Messages(models.PipelineRunStageAggregator); len(messages) != 1 {
+		t.Errorf("aggregator dispatched %d times, want once", len(messages))
+	}
+}
+
+func TestCoordinatorRacingDuplicates(t *testing.T) {
+	ctx := context.Background()
+	c := newCoordinatorFixture(ctx, t)
+	ref := c.startRun(ctx, t, 1, 2)
+	if err := c.f.Process(ctx, pageCompleted(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.f.Process(ctx, pageCompleted(2)); err != nil {
+				t.Errorf("Process(page 2) error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	run := c.run(ctx, t, ref)
+	if run.Stage != models.PipelineRunStageAggregator || run.Remaining != 0 {
+		t.Errorf("run after racing duplicates = stage %s, remaining %d; want aggregator, 0", run.Stage, run.Remaining)
+	}
+	// Duplicates of the completion that ends a stage may each publish the next request,
+	// as the coordinator allows, but never before the stage ended.
+	messages := c.pubsub.Messages(models.PipelineRunStageAggregator)
+	if len(messages) < 1 || len(messages) > 6 {
+		t.Errorf("aggregator dispatched %d times, want between 1 and 6", len(messages))
+	}
+}
+
+func TestCoordinatorChainsStages(t *testing.T) {
+	ctx := context.Background()
+	c := newCoordinatorFixture(ctx, t)
+	ref := c.startRun(ctx, t, 1)
+
+	events := []*models.StageCompletedEvent{
+		pageCompleted(1),
+		{DocumentID: "doc-1", RunID: "run-1", Stage: models.PipelineRunStageAggregator, Status: "success", OutputGCSUri: "gs://aggregated/doc-1/master.md"},
+		// Out of date: the run has moved past the translator.
+		pageCompleted(1),
+		{DocumentID: "doc-1", RunID: "run-1", Stage: models.PipelineRunStageCleaner, Status: "success", OutputGCSUri: "gs://cleaned/doc-1/cleaned.md"},
+		{DocumentID: "doc-1", RunID: "run-1", Stage: models.PipelineRunStageCleaner, Status: "success", OutputGCSUri: "gs://cleaned/doc-1/cleaned.md"},
+		{DocumentID: "doc-1", RunID: "run-1", Stage: models.PipelineRunStageSectionSplitter, Status: "success"},
+		// For a run that does not exist: dropped.
+		{DocumentID: "doc-1", RunID: "run-missing", Stage: models.PipelineRunStageSectionSplitter, Status: "success"},
+	}
+	for _, event := range events {
+		if err := c.f.Process(ctx, event); err != nil {
+			t.Fatalf("Process(%s) error = %v", event.Stage, err)
+		}
+	}
+
+	run := c.run(ctx, t, ref)
+	if run.Stage != models.PipelineRunStageComplete || run.MasterGCSUri != "gs://aggregated/doc-1/master.md" || run.CleanedGCSUri != "gs://cleaned/doc-1/cleaned.md" {
+		t.Errorf("run = %+v, want complete with both outputs", run)
+	}
+	for _, stage := range []string{models.PipelineRunStageAggregator, models.PipelineRunStageCleaner, models.PipelineRunStageSectionSplitter} {
+		if messages := c.pubsub.Messages(stage); len(messages) != 1 {
+			t.Errorf("%s dispatched %d times, want once", stage, len(messages))
+		}
+	}
+	var cleanerReq models.MarkdownCleanerRequest
+	if err := json.Unmarshal(c.pubsub.Messages(models.PipelineRunStageCleaner)[0].Data, &cleanerReq); err != nil {
+		t.Fatal(err)
+	}
+	if cleanerReq.MasterGCSUri != "gs://aggregated/doc-1/master.md" {
+		t.Errorf("cleaner request = %+v, want the aggregator's master file", cleanerReq)
+	}
+}
+
+func TestCoordinatorStopsAFailedRun(t *testing.T) {
+	ctx := context.Background()
+	c := newCoordinatorFixture(ctx, t)
+	ref := c.startRun(ctx, t, 1, 2)
+
+	failed := pageCompleted(1)
+	failed.Status, failed.Error = models.StageCompletedStatusFailed, "page is blank"
+	for _, event := range []*models.StageCompletedEvent{failed, pageCompleted(2)} {
+		if err := c.f.Process(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := c.run(ctx, t, ref)
+	if run.Stage != models.PipelineRunStageFailed || run.ErrorDetails != "translator failed: page is blank" {
+		t.Errorf("run = stage %s, error %q; want failed with the page's error", run.Stage, run.ErrorDetails)
+	}
+	if messages := c.pubsub.Messages(models.PipelineRunStageAggregator); len(messages) != 0 {
+		t.Errorf("aggregator dispatched %d times for a failed run", len(messages))
+	}
+}
+
+func TestCoordinatorIgnoresCompletionsOfACancelledDocument(t *testing.T) {
+	ctx := context.Background()
+	c := newCoordinatorFixture(ctx, t)
+	ref := c.startRun(ctx, t, 1)
+	docRef := c.f.firestoreClient.Collection(c.f.config.CollectionName).Doc("doc-1")
+	if _, err := docRef.Set(ctx, map[string]interface{}{"status": StatusCancelled, "cancelRequested": true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The last page finishing after the cancellation neither counts nor starts the aggregator.
+	if err := c.f.Process(ctx, pageCompleted(1)); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	run := c.run(ctx, t, ref)
+	if run.Stage != models.PipelineRunStageTranslator || run.Remaining != 1 || !slices.Equal(run.PendingPages, []int{1}) {
+		t.Errorf("run = stage %s, remaining %d, pending %v; want it unchanged", run.Stage, run.Remaining, run.PendingPages)
+	}
+	if messages := c.pubsub.Messages(models.PipelineRunStageAggregator); len(messages) != 0 {
+		t.Errorf("aggregator dispatched %d times for a cancelled document", len(messages))
+	}
+	if doc := readPipelineDocument(ctx, t, c.f.firestoreClient, c.f.config.CollectionName, "doc-1"); doc.Status != StatusCancelled {
+		t.Errorf("document status = %q, want it left %q", doc.Status, StatusCancelled)
+	}
+}
+
+func TestCoordinatorRedispatchesAfterAFailedPublish(t *testing.T) {
+	ctx := context.Background()
+	c := newCoordinatorFixture(ctx, t)
+	ref := c.startRun(ctx, t, 1)
+	if err := c.f.topics[models.PipelineRunStageAggregator].Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.f.Process(ctx, pageCompleted(1)); err == nil {
+		t.Fatal("Process() error = nil, want the failed publish, for the completion to be redelivered")
+	}
+	run := c.run(ctx, t, ref)
+	if run.Stage != models.PipelineRunStageAggregator || slices.Contains(run.Dispatched, models.PipelineRunStageAggregator) {
+		t.Fatalf("run = stage %s, dispatched %v; want aggregator, not dispatched", run.Stage, run.Dispatched)
+	}
+
+	topic, err := c.client.CreateTopic(ctx, models.PipelineRunStageAggregator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(topic.Stop)
+	c.f.topics[models.PipelineRunStageAggregator] = topic
+	if err := c.f.Process(ctx, pageCompleted(1)); err != nil {
+		t.Fatalf("Process() of the redelivered completion error = %v", err)
+	}
+	if messages := c.pubsub.Messages(models.PipelineRunStageAggregator); len(messages) != 1 {
+		t.Errorf("aggregator dispatched %d times after the redelivery, want once", len(messages))
+	}
+	if run := c.run(ctx, t, ref); !slices.Contains(run.Dispatched, models.PipelineRunStageAggregator) || run.Remaining != 0 {
+		t.Errorf("run = dispatched %v, remaining %d; want the aggregator recorded and the page counted once", run.Dispatched, run.Remaining)
+	}
+}
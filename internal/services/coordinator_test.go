@@ -0,0 +1,118 @@
+package services
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+func TestRecordCompletion(t *testing.T) {
+	now := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	translating := func(pages ...int) models.PipelineRun {
+		return models.PipelineRun{Stage: models.PipelineRunStageTranslator, PendingPages: pages, Remaining: len(pages)}
+	}
+	tests := []struct {
+		name          string
+		run           models.PipelineRun
+		event         models.StageCompletedEvent
+		wantCounted   bool
+		wantStage     string
+		wantRemaining int
+		wantPending   []int
+	}{
+		{
+			name:          "page counted",
+			run:           translating(1, 2, 3),
+			event:         models.StageCompletedEvent{Stage: models.PipelineRunStageTranslator, Page: 2, Status: "success"},
+			wantCounted:   true,
+			wantStage:     models.PipelineRunStageTranslator,
+			wantRemaining: 2,
+			wantPending:   []int{1, 3},
+		},
+		{
+			name:          "page already counted",
+			run:           translating(1, 3),
+			event:         models.StageCompletedEvent{Stage: models.PipelineRunStageTranslator, Page: 2, Status: "success"},
+			wantStage:     models.PipelineRunStageTranslator,
+			wantRemaining: 2,
+			wantPending:   []int{1, 3},
+		},
+		{
+			name:        "last page moves on to the aggregator",
+			run:         translating(3),
+			event:       models.StageCompletedEvent{Stage: models.PipelineRunStageTranslator, Page: 3, Status: "success"},
+			wantCounted: true,
+			wantStage:   models.PipelineRunStageAggregator,
+			wantPending: []int{},
+		},
+		{
+			name:          "failed page fails the run",
+			run:           translating(1, 2),
+			event:         models.StageCompletedEvent{Stage: models.PipelineRunStageTranslator, Page: 1, Status: models.StageCompletedStatusFailed, Error: "blocked"},
+			wantCounted:   true,
+			wantStage:     models.PipelineRunStageFailed,
+			wantRemaining: 1,
+			wantPending:   []int{2},
+		},
+		{
+			name:        "cleaner moves on to the section splitter",
+			run:         models.PipelineRun{Stage: models.PipelineRunStageCleaner},
+			event:       models.StageCompletedEvent{Stage: models.PipelineRunStageCleaner, Status: "success", OutputGCSUri: "gs://cleaned/doc-1.md"},
+			wantCounted: true,
+			wantStage:   models.PipelineRunStageSectionSplitter,
+		},
+		{
+			name:      "completion of an earlier stage",
+			run:       models.PipelineRun{Stage: models.PipelineRunStageCleaner},
+			event:     models.StageCompletedEvent{Stage: models.PipelineRunStageAggregator, Status: "success"},
+			wantStage: models.PipelineRunStageCleaner,
+		},
+		{
+			name:        "section splitter completes the run",
+			run:         models.PipelineRun{Stage: models.PipelineRunStageSectionSplitter},
+			event:       models.StageCompletedEvent{Stage: models.PipelineRunStageSectionSplitter, Status: "success"},
+			wantCounted: true,
+			wantStage:   models.PipelineRunStageComplete,
+		},
+		{
+			name:      "completion after the run completed",
+			run:       models.PipelineRun{Stage: models.PipelineRunStageComplete},
+			event:     models.StageCompletedEvent{Stage: models.PipelineRunStageSectionSplitter, Status: "success"},
+			wantStage: models.PipelineRunStageComplete,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := tt.run
+			updates, counted := recordCompletion(&run, &tt.event, now)
+			if counted != tt.wantCounted || (len(updates) > 0) != tt.wantCounted {
+				t.Fatalf("recordCompletion() = %d updates, %v; want counted %v", len(updates), counted, tt.wantCounted)
+			}
+			if run.Stage != tt.wantStage || run.Remaining != tt.wantRemaining {
+				t.Errorf("run = stage %s, remaining %d; want %s, %d", run.Stage, run.Remaining, tt.wantStage, tt.wantRemaining)
+			}
+			if tt.wantPending != nil && !slices.Equal(run.PendingPages, tt.wantPending) {
+				t.Errorf("run.PendingPages = %v, want %v", run.PendingPages, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestNextStageCoversEveryStage(t *testing.T) {
+	stage := models.PipelineRunStageTranslator
+	var visited []string
+	for stage != models.PipelineRunStageComplete {
+		visited = append(visited, stage)
+		next, ok := nextStage[stage]
+		if !ok || len(visited) > len(nextStage) {
+			t.Fatalf("nextStage does not lead from %v to complete", visited)
+		}
+		stage = next
+	}
+	want := []string{models.PipelineRunStageTranslator, models.PipelineRunStageAggregator, models.PipelineRunStageCleaner, models.PipelineRunStageSectionSplitter}
+	if !slices.Equal(visited, want) {
+		t.Errorf("stages = %v, want %v", visited, want)
+	}
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// statusDryRun is the status of a response that estimates the cost of a request instead
+// of carrying it out.
+const statusDryRun = "dry_run"
+
+// ModelPrice is what a model charges, in US dollars per million tokens.
+type ModelPrice struct {
+	InputPerMillion  float64 `json:"inputPerMillion"`
+	OutputPerMillion float64 `json:"outputPerMillion"`
+}
+
+// CostEstimateConfig sets how dry runs estimate the cost of a stage's model calls.
+type CostEstimateConfig struct {
+	// Pricing holds the price of each model, keyed by model name. A model without one
+	// is estimated in tokens only.
+	Pricing map[string]ModelPrice
+	// OutputRatioLow and OutputRatioHigh bound the output tokens expected of a call per
+	// input token.
+	OutputRatioLow  float64
+	OutputRatioHigh float64
+}
+
+// loadCostEstimateConfig reads MODEL_PRICING, a JSON object of ModelPrice keyed by model
+// name, and the stage's output token ratio from ratioKey, as "low,high" or a single ratio.
+func loadCostEstimateConfig(ratioKey, defaultRatio string) (CostEstimateConfig, error) {
+	var config CostEstimateConfig
+	if pricing := strings.TrimSpace(gcp.GetEnv("MODEL_PRICING", "")); pricing != "" {
+		if err := json.Unmarshal([]byte(pricing), &config.Pricing); err != nil {
+			return config, fmt.Errorf("MODEL_PRICING must be a JSON object of model prices: %w", err)
+		}
+		for model, price := range config.Pricing {
+			if price.InputPerMillion < 0 || price.OutputPerMillion < 0 {
+				return config, fmt.Errorf("MODEL_PRICING must not hold negative prices, got %+v for %s", price, model)
+			}
+		}
+	}
+	low, high, ok := strings.Cut(gcp.GetEnv(ratioKey, defaultRatio), ",")
+	if !ok {
+		high = low
+	}
+	var errLow, errHigh error
+	config.OutputRatioLow, errLow = strconv.ParseFloat(strings.TrimSpace(low), 64)
+	config.OutputRatioHigh, errHigh = strconv.ParseFloat(strings.TrimSpace(high), 64)
+	if errLow != nil || errHigh != nil || config.OutputRatioLow < 0 || config.OutputRatioHigh < config.OutputRatioLow {
+		return config, fmt.Errorf("%s must be a non-negative ratio or a \"low,high\" range of them", ratioKey)
+	}
+	return config, nil
+}
+
+// estimate returns the estimated cost of calls to model with inputTokens input tokens.
+func (c CostEstimateConfig) estimate(model string, inputTokens int64) models.CostEstimate {
+	estimate := models.CostEstimate{
+		Model:            model,
+		InputTokens:      inputTokens,
+		OutputTokensLow:  int64(math.Round(float64(inputTokens) * c.OutputRatioLow)),
+		OutputTokensHigh: int64(math.Round(float64(inputTokens) * c.OutputRatioHigh)),
+	}
+	if price, ok := c.Pricing[model]; ok {
+		low := price.cost(estimate.InputTokens, estimate.OutputTokensLow)
+		high := price.cost(estimate.InputTokens, estimate.OutputTokensHigh)
+		estimate.CostLow, estimate.CostHigh = &low, &high
+	}
+	return estimate
+}
+
+// cost returns the price of a call with the given token counts.
+func (p ModelPrice) cost(inputTokens, outputTokens int64) float64 {
+	return (float64(inputTokens)*p.InputPerMillion + float64(outputTokens)*p.OutputPerMillion) / 1e6
+}
+
+// addCostEstimate adds estimate to total, which starts as the estimate of no tokens. The
+// total's costs are only kept while every estimate added has them.
+func addCostEstimate(total *models.CostEstimate, estimate models.CostEstimate) {
+	total.InputTokens += estimate.InputTokens
+	total.OutputTokensLow += estimate.OutputTokensLow
+	total.OutputTokensHigh += estimate.OutputTokensHigh
+	if total.CostLow == nil || estimate.CostLow == nil {
+		total.CostLow, total.CostHigh = nil, nil
+		return
+	}
+	*total.CostLow += *estimate.CostLow
+	*total.CostHigh += *estimate.CostHigh
+}
+
+// countTokens returns the input tokens counter counts for a call with parts. Failures are
+// classified as a model call's are.
+func countTokens(ctx context.Context, counter gcp.TokenCounter, parts ...genai.Part) (int64, error) {
+	resp, err := counter.CountTokens(ctx, parts...)
+	if err != nil {
+		return 0, modelError(fmt.Errorf("failed to count tokens: %w", err))
+	}
+	return int64(resp.TotalTokens), nil
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func float64Ptr(v float64) *float64 { return &v }
+
+func TestCountTokens(t *testing.T) {
+	counter := &testsupport.FakeTokenCounter{Tokens: 1234}
+	parts := []genai.Part{genai.Text("Translate this page."), genai.FileData{MIMEType: "application/pdf", FileURI: "gs://pages/doc-1/00001.pdf"}}
+
+	got, err := countTokens(context.Background(), counter, parts...)
+	if err != nil || got != 1234 {
+		t.Fatalf("countTokens() = %d, %v; want 1234", got, err)
+	}
+	if calls := counter.Calls(); len(calls) != 1 || !reflect.DeepEqual(calls[0], parts) {
+		t.Errorf("counter calls = %+v, want one with the call's parts", calls)
+	}
+}
+
+func TestCountTokensFails(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode models.ErrorCode
+	}{
+		{name: "quota", err: status.Error(codes.ResourceExhausted, "quota exceeded"), wantCode: models.ErrorCodeLLMQuota},
+		{name: "missing file", err: status.Error(codes.NotFound, "no such object"), wantCode: models.ErrorCodeNotFoundSource},
+		{name: "unavailable", err: errors.New("connection reset"), wantCode: models.ErrorCodeLLMUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := &testsupport.FakeTokenCounter{Err: tt.err}
+			_, err := countTokens(context.Background(), counter, genai.Text("page"))
+			if !errors.Is(err, tt.err) || models.CodeOf(err) != tt.wantCode {
+				t.Errorf("countTokens() error = %v (%s), want %v as %s", err, models.CodeOf(err), tt.err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestCostEstimate(t *testing.T) {
+	config := CostEstimateConfig{
+		Pricing:         map[string]ModelPrice{"gemini-pro": {InputPerMillion: 1.25, OutputPerMillion: 5}},
+		OutputRatioLow:  0.5,
+		OutputRatioHigh: 1.5,
+	}
+	counter := &testsupport.FakeTokenCounter{Tokens: 2000}
+	tokens, err := countTokens(context.Background(), counter, genai.Text("page"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := config.estimate("gemini-pro", tokens)
+	want := models.CostEstimate{
+		Model:            "gemini-pro",
+		InputTokens:      2000,
+		OutputTokensLow:  1000,
+		OutputTokensHigh: 3000,
+		CostLow:          float64Ptr((2000*1.25 + 1000*5) / 1e6),
+		CostHigh:         float64Ptr((2000*1.25 + 3000*5) / 1e6),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("estimate() = %+v, want %+v", got, want)
+	}
+
+	// A model without a price is estimated in tokens only.
+	if got := config.estimate("gemini-flash", tokens); got.CostLow != nil || got.CostHigh != nil || got.OutputTokensHigh != 3000 {
+		t.Errorf("estimate() of an unpriced model = %+v, want tokens without costs", got)
+	}
+	// Output tokens are rounded to the nearest token.
+	if got := config.estimate("gemini-pro", 3); got.OutputTokensLow != 2 || got.OutputTokensHigh != 5 {
+		t.Errorf("estimate() of 3 tokens = %d-%d output tokens, want 2-5", got.OutputTokensLow, got.OutputTokensHigh)
+	}
+}
+
+func TestAddCostEstimate(t *testing.T) {
+	priced := models.CostEstimate{InputTokens: 100, OutputTokensLow: 50, OutputTokensHigh: 150, CostLow: float64Ptr(0.25), CostHigh: float64Ptr(0.75)}
+	unpriced := models.CostEstimate{InputTokens: 10, OutputTokensLow: 5, OutputTokensHigh: 15}
+
+	total := models.CostEstimate{CostLow: float64Ptr(0), CostHigh: float64Ptr(0)}
+	addCostEstimate(&total, priced)
+	addCostEstimate(&total, priced)
+	if total.InputTokens != 200 || total.OutputTokensHigh != 300 || total.CostLow == nil || *total.CostLow != 0.5 || *total.CostHigh != 1.5 {
+		t.Errorf("total of two priced estimates = %+v", total)
+	}
+	// Once an unpriced estimate is added, the total's costs are unknown for good.
+	addCostEstimate(&total, unpriced)
+	addCostEstimate(&total, priced)
+	if total.InputTokens != 310 || total.OutputTokensLow != 155 || total.CostLow != nil || total.CostHigh != nil {
+		t.Errorf("total with an unpriced estimate = %+v, want tokens without costs", total)
+	}
+}
+
+func TestLoadCostEstimateConfig(t *testing.T) {
+	const ratioKey = "TEST_OUTPUT_TOKEN_RATIO"
+	tests := []struct {
+		name    string
+		pricing string
+		ratio   string
+		want    CostEstimateConfig
+		wantErr bool
+	}{
+		{name: "defaults", want: CostEstimateConfig{OutputRatioLow: 0.8, OutputRatioHigh: 1.2}},
+		{name: "single ratio", ratio: "1", want: CostEstimateConfig{OutputRatioLow: 1, OutputRatioHigh: 1}},
+		{name: "range with spaces", ratio: " 0.5 , 2 ", want: CostEstimateConfig{OutputRatioLow: 0.5, OutputRatioHigh: 2}},
+		{
+			name:    "pricing",
+			pricing: `{"gemini-pro": {"inputPerMillion": 1.25, "outputPerMillion": 5}}`,
+			want:    CostEstimateConfig{Pricing: map[string]ModelPrice{"gemini-pro": {InputPerMillion: 1.25, OutputPerMillion: 5}}, OutputRatioLow: 0.8, OutputRatioHigh: 1.2},
+		},
+		{name: "inverted range", ratio: "2,1", wantErr: true},
+		{name: "negative ratio", ratio: "-1", wantErr: true},
+		{name: "not a ratio", ratio: "half", wantErr: true},
+		{name: "pricing not JSON", pricing: "gemini-pro=1.25", wantErr: true},
+		{name: "negative price", pricing: `{"gemini-pro": {"inputPerMillion": -1}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range map[string]string{"MODEL_PRICING": tt.pricing, ratioKey: tt.ratio} {
+				t.Setenv(key, value)
+				if value == "" {
+					os.Unsetenv(key)
+				}
+			}
+			got, err := loadCostEstimateConfig(ratioKey, "0.8,1.2")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadCostEstimateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadCostEstimateConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Custom metadata keys uploaders set to restrict where a document is processed, sent as
+// the x-goog-meta-processing-region and x-goog-meta-redaction-mode headers.
+const (
+	processingRegionMetadataKey = "processing-region"
+	redactionModeMetadataKey    = "redaction-mode"
+)
+
+// residencyFromMetadata returns the processing region and redaction mode of an upload.
+// Unlike language hints, invalid values are kept, and logged: the translator then
+// refuses the document's pages rather than sending them somewhere they may not go.
+func residencyFromMetadata(logCtx *slog.Logger, metadata map[string]string) (string, string) {
+	region := strings.ToLower(strings.TrimSpace(metadata[processingRegionMetadataKey]))
+	if err := models.ValidateProcessingRegion(region); err != nil {
+		logCtx.Warn("Upload has an invalid processing region; its pages will not be translated.", "error", err)
+	}
+	mode := strings.ToLower(strings.TrimSpace(metadata[redactionModeMetadataKey]))
+	if err := models.ValidateRedactionMode(mode); err != nil {
+		logCtx.Warn("Upload has an invalid redaction mode; its pages will not be translated.", "error", err)
+	}
+	return region, mode
+}
+
+// isRestrictedUpload reports whether an upload's metadata restricts where it may be
+// processed. The splitter's own model calls are skipped for such uploads, as they are
+// made in the splitter's region without redaction.
+func isRestrictedUpload(metadata map[string]string) bool {
+	region := strings.TrimSpace(metadata[processingRegionMetadataKey])
+	mode := strings.ToLower(strings.TrimSpace(metadata[redactionModeMetadataKey]))
+	return region != "" || (mode != "" && mode != models.RedactionModeNone)
+}
+
+// loadDLPInfoTypes reads DLP_INFO_TYPES, a comma-separated list of Cloud DLP info types
+// such as "PERSON_NAME,EMAIL_ADDRESS".
+func loadDLPInfoTypes() []string {
+	var infoTypes []string
+	for _, infoType := range strings.Split(gcp.GetEnv("DLP_INFO_TYPES", ""), ",") {
+		if infoType = strings.TrimSpace(infoType); infoType != "" {
+			infoTypes = append(infoTypes, infoType)
+		}
+	}
+	return infoTypes
+}
+
+// RegionUnavailableError is returned when the Vertex AI client of a page's processing
+// region cannot be created. The page is never sent to another region instead.
+type RegionUnavailableError struct {
+	Region string
+	Err    error
+}
+
+func (e *RegionUnavailableError) Error() string {
+	return fmt.Sprintf("processing region %s is unavailable: %v", e.Region, e.Err)
+}
+
+func (e *RegionUnavailableError) Unwrap() error { return e.Err }
+
+// errRedactionUnavailable is wrapped by the error returned for a page that must be
+// redacted by a translator without OCR or DLP configured.
+var errRedactionUnavailable = fmt.Errorf("redactionMode %q requires DOC_AI_PROCESSOR and DLP_INFO_TYPES", models.RedactionModeDLP)
+
+// profileModelsFor returns the translator models of the request's processing region,
+// failing with a RegionUnavailableError if its client cannot be created.
+func (f *TranslatorFunction) profileModelsFor(ctx context.Context, logCtx *slog.Logger, req *models.PageTranslatorRequest) (map[string]gcp.ContentGenerator, string, error) {
+	if req.ProcessingRegion == "" || req.ProcessingRegion == f.config.VertexAIRegion {
+		return f.profileModels, f.config.VertexAIRegion, nil
+	}
+	if f.vertexClient == nil {
+		err := &RegionUnavailableError{Region: req.ProcessingRegion, Err: errors.New("no Vertex AI client to create it from")}
+		logCtx.Error("Cannot translate in the page's processing region", "error", err)
+		return nil, "", models.WithCode(models.ErrorCodeLLMUnavailable, err)
+	}
+	client, err := f.vertexClient.ForRegion(ctx, req.ProcessingRegion)
+	if err != nil {
+		err := &RegionUnavailableError{Region: req.ProcessingRegion, Err: err}
+		logCtx.Error("Cannot translate in the page's processing region", "error", err)
+		return nil, "", models.WithCode(models.ErrorCodeLLMUnavailable, err)
+	}
+	logCtx.Info("Translating in the page's processing region.", "processingRegion", req.ProcessingRegion)
+	return client.TranslatorProfiles(), req.ProcessingRegion, nil
+}
+
+// generateRedacted translates a page without sending it to the model: the page is read
+// by Document AI OCR, Cloud DLP redacts the text in the page's processing region, and
+// only the redacted text goes to the model. It returns the response, the number of
+// model attempts made, whether the output ended truncated and the number of findings
+// redacted. Failures are returned classified, and are never worked around by sending the
+// page itself.
+func (f *TranslatorFunction) generateRedacted(ctx context.Context, logCtx *slog.Logger, model gcp.ContentGenerator, req *models.PageTranslatorRequest, promptText, pages string) (*genai.GenerateContentResponse, int, bool, int, error) {
+	if f.ocr == nil || f.redactor == nil {
+		logCtx.Error("Page must be redacted but redaction is not configured", "error", errRedactionUnavailable)
+		return nil, 0, false, 0, models.WithCode(models.ErrorCodeInvalidRequest, errRedactionUnavailable)
+	}
+	data, err := f.downloadPage(ctx, req.GCSUri)
+	if err != nil {
+		return nil, 0, false, 0, sourceError(err)
+	}
+	text, err := f.ocr.OCR(ctx, data)
+	if err != nil {
+		logCtx.Error("OCR of page to redact failed", "error", err)
+		return nil, 0, false, 0, fmt.Errorf("failed to OCR %s for redaction: %w", pages, err)
+	}
+	var redacted string
+	var findings int
+	if strings.TrimSpace(text) != "" {
+		redacted, findings, err = f.redactor.Redact(ctx, text, req.ProcessingRegion)
+		if err != nil {
+			logCtx.Error("Redaction failed", "error", err)
+			return nil, 0, false, 0, fmt.Errorf("failed to redact %s: %w", pages, err)
+		}
+	}
+	logCtx.Info("Redacted page text.", "ocrChars", len(text), "redactions", findings)
+
+	input := genai.Text(fmt.Sprintf(gcp.TranslatorRedactedPromptFormat, redacted))
+	resp, attempts, truncated, err := f.generateTranslation(ctx, logCtx, model, input, genai.Text(promptText), pages)
+	if err != nil {
+		return nil, attempts, false, findings, modelError(err)
+	}
+	return resp, attempts, truncated, findings, nil
+}
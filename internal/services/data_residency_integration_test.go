@@ -0,0 +1,89 @@
+//go:build integration
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/vertexai/genai"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestGenerateRedactedFailsClosed(t *testing.T) {
+	ctx := context.Background()
+	emulators := testsupport.RequireEmulators(t)
+	_, storageClient := emulators.Clients(ctx, t)
+	bucket := emulators.CreateBuckets(ctx, t, storageClient, "pages")[0]
+	w := storageClient.Bucket(bucket).Object("doc-1/00001.pdf").NewWriter(ctx)
+	if _, err := w.Write(testsupport.FixturePDF(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := &models.PageTranslatorRequest{
+		DocumentID:       "doc-1",
+		GCSUri:           fmt.Sprintf("gs://%s/doc-1/00001.pdf", bucket),
+		PageNumber:       1,
+		ProcessingRegion: "europe-west4",
+		RedactionMode:    models.RedactionModeDLP,
+	}
+	tests := []struct {
+		name     string
+		ocr      fakeOCR
+		redactor *testsupport.FakeRedactor
+		wantErr  error
+	}{
+		{name: "redacted", ocr: fakeOCR{text: "Contact jane@example.com."}, redactor: &testsupport.FakeRedactor{Findings: map[string]string{"jane@example.com": "EMAIL_ADDRESS"}}},
+		{name: "DLP fails", ocr: fakeOCR{text: "Contact jane@example.com."}, redactor: &testsupport.FakeRedactor{Err: errors.New("dlp: permission denied")}, wantErr: errors.New("dlp: permission denied")},
+		{name: "OCR fails", ocr: fakeOCR{err: errors.New("documentai: quota exceeded")}, redactor: &testsupport.FakeRedactor{}, wantErr: errors.New("documentai: quota exceeded")},
+	}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := testsupport.NewFakeContentGenerator(testsupport.FakeResult{Response: testsupport.TextResponse("# Page")})
+			f := &TranslatorFunction{storageClient: storageClient, ocr: tt.ocr, redactor: tt.redactor, config: TranslatorConfig{InlineMaxBytes: 1 << 20}}
+
+			_, _, _, findings, err := f.generateRedacted(ctx, logCtx, model, req, "translate", "page 1")
+			if tt.wantErr != nil {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr.Error()) {
+					t.Fatalf("generateRedacted() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				// Neither the page nor its unredacted text is sent anywhere instead.
+				if calls := model.Calls(); len(calls) != 0 {
+					t.Errorf("model called %d times after redaction failed, want never", len(calls))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("generateRedacted() error = %v", err)
+			}
+			if findings != 1 {
+				t.Errorf("findings = %d, want 1", findings)
+			}
+			if calls := tt.redactor.Calls(); len(calls) != 1 || calls[0].Location != "europe-west4" {
+				t.Errorf("redactor calls = %+v, want one in the page's region", calls)
+			}
+			calls := model.Calls()
+			if len(calls) != 1 {
+				t.Fatalf("model called %d times, want once", len(calls))
+			}
+			for _, part := range calls[0] {
+				text, ok := part.(genai.Text)
+				if !ok {
+					t.Errorf("model sent a %T, want only redacted text", part)
+				} else if strings.Contains(string(text), "jane@example.com") {
+					t.Errorf("model sent %q, want the finding redacted", text)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestResidencyFromMetadata(t *testing.T) {
+	tests := []struct {
+		name        string
+		metadata    map[string]string
+		wantRegion  string
+		wantMode    string
+		wantInvalid bool
+	}{
+		{name: "none"},
+		{name: "normalized", metadata: map[string]string{processingRegionMetadataKey: " Europe-West4 ", redactionModeMetadataKey: "DLP"}, wantRegion: "europe-west4", wantMode: models.RedactionModeDLP},
+		// Invalid values are kept, so the translator refuses the pages rather than
+		// translating them without the restriction.
+		{name: "invalid region kept", metadata: map[string]string{processingRegionMetadataKey: "europe"}, wantRegion: "europe", wantInvalid: true},
+		{name: "invalid mode kept", metadata: map[string]string{redactionModeMetadataKey: "mask"}, wantMode: "mask", wantInvalid: true},
+	}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			region, mode := residencyFromMetadata(logCtx, tt.metadata)
+			if region != tt.wantRegion || mode != tt.wantMode {
+				t.Errorf("residencyFromMetadata() = %q, %q; want %q, %q", region, mode, tt.wantRegion, tt.wantMode)
+			}
+			req := &models.PageTranslatorRequest{DocumentID: "doc-1", GCSUri: "gs://pages/doc-1/00001.pdf", PageNumber: 1, ProcessingRegion: region, RedactionMode: mode}
+			if err := req.Validate(); (err != nil) != tt.wantInvalid {
+				t.Errorf("Validate() of the page request = %v, want invalid %v", err, tt.wantInvalid)
+			}
+		})
+	}
+}
+
+func TestIsRestrictedUpload(t *testing.T) {
+	tests := []struct {
+		metadata map[string]string
+		want     bool
+	}{
+		{metadata: nil},
+		{metadata: map[string]string{redactionModeMetadataKey: "None"}},
+		{metadata: map[string]string{processingRegionMetadataKey: "europe-west4"}, want: true},
+		{metadata: map[string]string{redactionModeMetadataKey: "dlp"}, want: true},
+		// An invalid mode may still have been meant as a restriction.
+		{metadata: map[string]string{redactionModeMetadataKey: "mask"}, want: true},
+	}
+	for _, tt := range tests {
+		if got := isRestrictedUpload(tt.metadata); got != tt.want {
+			t.Errorf("isRestrictedUpload(%v) = %v, want %v", tt.metadata, got, tt.want)
+		}
+	}
+}
+
+func TestProfileModelsForFailsClosed(t *testing.T) {
+	home := map[string]gcp.ContentGenerator{gcp.PromptProfileDefault: testsupport.NewFakeContentGenerator()}
+	f := &TranslatorFunction{profileModels: home, config: TranslatorConfig{VertexAIRegion: "us-central1"}}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	for _, region := range []string{"", "us-central1"} {
+		got, gotRegion, err := f.profileModelsFor(context.Background(), logCtx, &models.PageTranslatorRequest{ProcessingRegion: region})
+		if err != nil || gotRegion != "us-central1" || got[gcp.PromptProfileDefault] != home[gcp.PromptProfileDefault] {
+			t.Errorf("profileModelsFor(%q) = %v, %q, %v; want the models of the home region", region, got, gotRegion, err)
+		}
+	}
+
+	// Without a client for the page's region the page is refused, not translated at home.
+	got, gotRegion, err := f.profileModelsFor(context.Background(), logCtx, &models.PageTranslatorRequest{ProcessingRegion: "europe-west4"})
+	var unavailable *RegionUnavailableError
+	if !errors.As(err, &unavailable) || unavailable.Region != "europe-west4" {
+		t.Fatalf("profileModelsFor() error = %v, want a RegionUnavailableError for europe-west4", err)
+	}
+	if code := models.CodeOf(err); code != models.ErrorCodeLLMUnavailable {
+		t.Errorf("profileModelsFor() code = %s, want %s", code, models.ErrorCodeLLMUnavailable)
+	}
+	if got != nil || gotRegion != "" {
+		t.Errorf("profileModelsFor() = %v, %q; want no models", got, gotRegion)
+	}
+}
+
+func TestGenerateRedactedWithoutRedaction(t *testing.T) {
+	tests := []struct {
+		name     string
+		ocr      gcp.OCRClient
+		redactor gcp.Redactor
+	}{
+		{name: "neither configured"},
+		{name: "no OCR", redactor: &testsupport.FakeRedactor{}},
+		{name: "no DLP", ocr: fakeOCR{text: "Jane Doe"}},
+	}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := testsupport.NewFakeContentGenerator(testsupport.FakeResult{Response: testsupport.TextResponse("# Page")})
+			f := &TranslatorFunction{ocr: tt.ocr, redactor: tt.redactor}
+			req := &models.PageTranslatorRequest{DocumentID: "doc-1", GCSUri: "gs://pages/doc-1/00001.pdf", PageNumber: 1, RedactionMode: models.RedactionModeDLP}
+			_, _, _, _, err := f.generateRedacted(context.Background(), logCtx, model, req, "translate", "page 1")
+			if !errors.Is(err, errRedactionUnavailable) || models.CodeOf(err) != models.ErrorCodeInvalidRequest {
+				t.Errorf("generateRedacted() error = %v, want an INVALID_REQUEST errRedactionUnavailable", err)
+			}
+			if calls := model.Calls(); len(calls) != 0 {
+				t.Errorf("model called %d times, want never", len(calls))
+			}
+		})
+	}
+}
+
+// fakeOCR is a gcp.OCRClient that reads every page as text, or fails with err.
+type fakeOCR struct {
+	text string
+	err  error
+}
+
+func (o fakeOCR) OCR(ctx context.Context, pdf []byte) (string, error) {
+	return o.text, o.err
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// tenantMetadataKey is the custom metadata key uploaders set to name the tenant an upload
+// belongs to, when its bucket has none; see TenantRouting.
+const tenantMetadataKey = "tenant-id"
+
+// DedupeConfig sets how uploads of a file already processed are told apart from new work.
+type DedupeConfig struct {
+	Scope string // One of the models.DedupeScope* values.
+	// TTL is how long after its creation a completed document blocks uploads of the same
+	// file. Zero blocks them for good.
+	TTL time.Duration
+}
+
+// loadDedupeConfig reads DEDUPE_SCOPE and DEDUPE_TTL_DAYS.
+func loadDedupeConfig() (DedupeConfig, error) {
+	config := DedupeConfig{Scope: gcp.GetEnv("DEDUPE_SCOPE", models.DedupeScopeGlobal)}
+	switch config.Scope {
+	case models.DedupeScopeGlobal, models.DedupeScopeBucket, models.DedupeScopeTenant:
+	default:
+		return config, fmt.Errorf("DEDUPE_SCOPE must be %q, %q or %q", models.DedupeScopeGlobal, models.DedupeScopeBucket, models.DedupeScopeTenant)
+	}
+	days, err := strconv.Atoi(gcp.GetEnv("DEDUPE_TTL_DAYS", "0"))
+	if err != nil || days < 0 {
+		return config, fmt.Errorf("DEDUPE_TTL_DAYS must be a non-negative integer")
+	}
+	config.TTL = time.Duration(days) * 24 * time.Hour
+	return config, nil
+}
+
+// dedupeKey is the scope an upload's file hash must be unique in. The zero key is the
+// global scope.
+type dedupeKey struct {
+	Scope string
+	Value string // The source bucket or tenant ID.
+}
+
+// keyFor returns the key of an upload to bucket belonging to tenantID, as
+// TenantRouting.tenantFor derives it.
+func (c DedupeConfig) keyFor(bucket, tenantID string) dedupeKey {
+	switch c.Scope {
+	case models.DedupeScopeBucket:
+		return dedupeKey{Scope: models.DedupeScopeBucket, Value: bucket}
+	case models.DedupeScopeTenant:
+		if tenantID != "" {
+			return dedupeKey{Scope: models.DedupeScopeTenant, Value: tenantID}
+		}
+	}
+	return dedupeKey{}
+}
+
+// matches reports whether doc was deduplicated with the key.
+func (k dedupeKey) matches(doc *models.Document) bool {
+	return doc.DedupeScope == k.Scope && doc.DedupeKey == k.Value
+}
+
+// documentID returns the ID of the first document of fileHash in the key's scope. Globally
+// it is the file hash, as it always was; in a narrower scope the hash of the scope is
+// appended, as bucket names and tenant IDs may hold characters a document ID cannot.
+func (k dedupeKey) documentID(fileHash string) string {
+	if k == (dedupeKey{}) {
+		return fileHash
+	}
+	sum := sha256.Sum256([]byte(k.Scope + "\x00" + k.Value))
+	return fileHash + "-" + hex.EncodeToString(sum[:8])
+}
+
+// supersedingDocumentID returns the ID of the document that re-ingests the file of
+// supersededID, the newest document of the file in its scope. It depends only on the
+// document superseded, so that of two concurrent re-ingests exactly one creates it.
+func supersedingDocumentID(k dedupeKey, fileHash, supersededID string) string {
+	sum := sha256.Sum256([]byte(supersededID))
+	return k.documentID(fileHash) + "-r" + hex.EncodeToString(sum[:6])
+}
+
+// expired reports whether doc, the newest document of a file in its scope, no longer
+// blocks uploads of the file: it completed, and was created more than TTL before now.
+func (c DedupeConfig) expired(doc *models.Document, now time.Time) bool {
+	return c.TTL > 0 && doc.Status == StatusComplete && now.Sub(doc.CreatedAt) > c.TTL
+}
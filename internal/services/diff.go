@@ -0,0 +1,232 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// revisionPage is one translated page of a document revision.
+type revisionPage struct {
+	Number  int
+	Hash    string
+	Content string
+}
+
+// pagePair links a page of the base revision to a page of the new revision.
+// A nil Base means the page was added; a nil New means it was removed.
+type pagePair struct {
+	Base *revisionPage
+	New  *revisionPage
+}
+
+// pairRevisionPages aligns the pages of two revisions. Pages with identical content
+// hashes are used as anchors (via a longest common subsequence over the hashes), so an
+// inserted or removed page does not shift every following page out of alignment.
+// Unanchored pages between two anchors are paired positionally; any surplus on either
+// side becomes an added or removed page.
+func pairRevisionPages(base, revised []revisionPage) []pagePair {
+	m, n := len(base), len(revised)
+	lcs := make([][]int, m+1)
+	for i := range lcs {
+		lcs[i] = make([]int, n+1)
+	}
+	for i := m - 1; i >= 0; i-- {
+		for j := n - 1; j >= 0; j-- {
+			if base[i].Hash == revised[j].Hash {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var pairs []pagePair
+	var baseGap, newGap []int
+	flushGap := func() {
+		k := 0
+		for ; k < len(baseGap) && k < len(newGap); k++ {
+			pairs = append(pairs, pagePair{Base: &base[baseGap[k]], New: &revised[newGap[k]]})
+		}
+		for _, bi := range baseGap[k:] {
+			pairs = append(pairs, pagePair{Base: &base[bi]})
+		}
+		for _, ni := range newGap[k:] {
+			pairs = append(pairs, pagePair{New: &revised[ni]})
+		}
+		baseGap, newGap = baseGap[:0], newGap[:0]
+	}
+
+	i, j := 0, 0
+	for i < m && j < n {
+		switch {
+		case base[i].Hash == revised[j].Hash:
+			flushGap()
+			pairs = append(pairs, pagePair{Base: &base[i], New: &revised[j]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			baseGap = append(baseGap, i)
+			i++
+		default:
+			newGap = append(newGap, j)
+			j++
+		}
+	}
+	for ; i < m; i++ {
+		baseGap = append(baseGap, i)
+	}
+	for ; j < n; j++ {
+		newGap = append(newGap, j)
+	}
+	flushGap()
+	return pairs
+}
+
+// diffOp is a single line of a line-level edit script.
+type diffOp struct {
+	Kind byte // ' ', '-' or '+'
+	Line string
+}
+
+// maxDiffCells bounds the LCS table size so a pathological page cannot exhaust memory.
+const maxDiffCells = 4_000_000
+
+// diffLines computes a line-level edit script turning a into b. Inputs too large for the
+// LCS table are reported as a full replacement.
+func diffLines(a, b []string) []diffOp {
+	m, n := len(a), len(b)
+	if m*n > maxDiffCells {
+		ops := make([]diffOp, 0, m+n)
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return ops
+	}
+	lcs := make([][]int, m+1)
+	for i := range lcs {
+		lcs[i] = make([]int, n+1)
+	}
+	for i := m - 1; i >= 0; i-- {
+		for j := n - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, m+n)
+	i, j := 0, 0
+	for i < m && j < n {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < m; i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < n; j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// splitLines splits text into lines, treating empty text as having no lines.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// unifiedDiff renders a unified diff with the given number of context lines. It returns
+// an empty string when the inputs are identical, along with the count of changed lines.
+func unifiedDiff(fromName, toName, from, to string, context int) (string, int) {
+	ops := diffLines(splitLines(from), splitLines(to))
+
+	changed := 0
+	for _, op := range ops {
+		if op.Kind != ' ' {
+			changed++
+		}
+	}
+	if changed == 0 {
+		return "", 0
+	}
+
+	// aPos/bPos hold the 1-based line number each op starts at in the old/new text.
+	aPos, bPos := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	aPos[0], bPos[0] = 1, 1
+	var changes []int
+	for k, op := range ops {
+		aPos[k+1], bPos[k+1] = aPos[k], bPos[k]
+		if op.Kind != '+' {
+			aPos[k+1]++
+		}
+		if op.Kind != '-' {
+			bPos[k+1]++
+		}
+		if op.Kind != ' ' {
+			changes = append(changes, k)
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Group changes separated by no more than 2*context unchanged lines into one hunk.
+	for g := 0; g < len(changes); {
+		last := g
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*context+1 {
+			last++
+		}
+		lo := max(changes[g]-context, 0)
+		hi := min(changes[last]+context+1, len(ops))
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(aPos[lo], aPos[hi]-aPos[lo]),
+			hunkRange(bPos[lo], bPos[hi]-bPos[lo]))
+		for _, op := range ops[lo:hi] {
+			out.WriteByte(op.Kind)
+			out.WriteString(op.Line)
+			out.WriteByte('\n')
+		}
+		g = last + 1
+	}
+	return out.String(), changed
+}
+
+// hunkRange formats a unified diff range, where an empty range points at the line before.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// truncateDiff caps a diff at maxBytes, cutting on a line boundary and appending a marker.
+func truncateDiff(diff string, maxBytes int) string {
+	if maxBytes <= 0 || len(diff) <= maxBytes {
+		return diff
+	}
+	cut := strings.LastIndexByte(diff[:maxBytes], '\n') + 1
+	return diff[:cut] + fmt.Sprintf("... diff truncated: %d of %d bytes shown ...\n", cut, len(diff))
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// revision builds the pages of a synthetic revision, numbered from 1, from their content.
+func revision(contents ...string) []revisionPage {
+	pages := make([]revisionPage, len(contents))
+	for i, content := range contents {
+		sum := sha256.Sum256([]byte(content))
+		pages[i] = revisionPage{Number: i + 1, Hash: hex.EncodeToString(sum[:]), Content: content}
+	}
+	return pages
+}
+
+// describePairs renders pairs as "base>new" page numbers, with 0 for a missing side.
+func describePairs(pairs []pagePair) string {
+	var parts []string
+	for _, pair := range pairs {
+		baseNumber, newNumber := 0, 0
+		if pair.Base != nil {
+			baseNumber = pair.Base.Number
+		}
+		if pair.New != nil {
+			newNumber = pair.New.Number
+		}
+		parts = append(parts, fmt.Sprintf("%d>%d", baseNumber, newNumber))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestPairRevisionPages(t *testing.T) {
+	tests := []struct {
+		name string
+		base []revisionPage
+		new  []revisionPage
+		want string
+	}{
+		{
+			name: "identical",
+			base: revision("a", "b", "c"),
+			new:  revision("a", "b", "c"),
+			want: "1>1 2>2 3>3",
+		},
+		{
+			name: "page inserted does not shift the rest",
+			base: revision("a", "b", "c"),
+			new:  revision("a", "x", "b", "c"),
+			want: "1>1 0>2 2>3 3>4",
+		},
+		{
+			name: "page removed does not shift the rest",
+			base: revision("a", "b", "c", "d"),
+			new:  revision("a", "c", "d"),
+			want: "1>1 2>0 3>2 4>3",
+		},
+		{
+			name: "edited page between anchors is paired in place",
+			base: revision("a", "b", "c"),
+			new:  revision("a", "b2", "c"),
+			want: "1>1 2>2 3>3",
+		},
+		{
+			name: "edit and insert between the same anchors",
+			base: revision("a", "b", "d"),
+			new:  revision("a", "b2", "c", "d"),
+			want: "1>1 2>2 0>3 3>4",
+		},
+		{
+			name: "new document",
+			base: nil,
+			new:  revision("a", "b"),
+			want: "0>1 0>2",
+		},
+		{
+			name: "everything removed",
+			base: revision("a", "b"),
+			new:  nil,
+			want: "1>0 2>0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := describePairs(pairRevisionPages(tt.base, tt.new)); got != tt.want {
+				t.Errorf("pairRevisionPages() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name        string
+		from, to    string
+		want        string
+		wantChanged int
+	}{
+		{
+			name: "identical",
+			from: "one\ntwo\n",
+			to:   "one\ntwo\n",
+		},
+		{
+			name:        "line changed",
+			from:        "one\ntwo\nthree\n",
+			to:          "one\n2\nthree\n",
+			want:        "--- a\n+++ b\n@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n",
+			wantChanged: 2,
+		},
+		{
+			name:        "added page",
+			from:        "",
+			to:          "new\n",
+			want:        "--- a\n+++ b\n@@ -0,0 +1 @@\n+new\n",
+			wantChanged: 1,
+		},
+		{
+			name:        "distant changes get separate hunks",
+			from:        "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			to:          "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			want:        "--- a\n+++ b\n@@ -1,2 +1,2 @@\n-1\n+one\n 2\n@@ -9,2 +9,2 @@\n 9\n-10\n+ten\n",
+			wantChanged: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := unifiedDiff("a", "b", tt.from, tt.to, 1)
+			if got != tt.want {
+				t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("unifiedDiff() changed = %d, want %d", changed, tt.wantChanged)
+			}
+		})
+	}
+}
+
+func TestTruncateDiff(t *testing.T) {
+	diff := "--- a\n+++ b\n-one\n+two\n"
+	tests := []struct {
+		name     string
+		maxBytes int
+		want     string
+	}{
+		{name: "unlimited", maxBytes: 0, want: diff},
+		{name: "fits", maxBytes: len(diff), want: diff},
+		{name: "cut on a line boundary", maxBytes: 15, want: "--- a\n+++ b\n... diff truncated: 12 of 22 bytes shown ...\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateDiff(diff, tt.maxBytes); got != tt.want {
+				t.Errorf("truncateDiff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
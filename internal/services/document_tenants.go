@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// ErrTenantMismatch is wrapped by the error a stage returns for a request whose tenant is
+// not the one recorded on its document.
+var ErrTenantMismatch = errors.New("request tenant does not match the document")
+
+// documentRoot returns the prefix, without its trailing slash, under which every object
+// derived from a document is stored: "{tenantId}/{docId}", or "{docId}" for a document
+// without a tenant. Buckets shared by tenants can then be split between them with IAM
+// conditions on the object name prefix.
+func documentRoot(tenantID, docID string) string {
+	if tenantID == "" {
+		return docID
+	}
+	return tenantID + "/" + docID
+}
+
+// TenantRouting derives the tenant of an upload.
+type TenantRouting struct {
+	// Buckets maps upload buckets to the tenant whose uploads they hold. An upload to a
+	// bucket listed here belongs to its tenant whatever its metadata says.
+	Buckets map[string]string
+}
+
+// loadTenantRouting reads TENANT_BUCKETS, a comma-separated list of bucket=tenant pairs.
+func loadTenantRouting() (TenantRouting, error) {
+	routing := TenantRouting{Buckets: make(map[string]string)}
+	for _, pair := range strings.Split(gcp.GetEnv("TENANT_BUCKETS", ""), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		bucket, tenant, ok := strings.Cut(pair, "=")
+		bucket, tenant = strings.TrimSpace(bucket), strings.TrimSpace(tenant)
+		if !ok || bucket == "" || !models.ValidTenantID(tenant) {
+			return routing, fmt.Errorf("TENANT_BUCKETS must list bucket=tenant pairs with valid tenant IDs, got %q", pair)
+		}
+		routing.Buckets[bucket] = tenant
+	}
+	return routing, nil
+}
+
+// tenantFor returns the tenant of an upload to bucket with the given metadata: the
+// bucket's tenant if it has one, or else the upload's "tenant-id" metadata. Uploads with
+// neither have no tenant. A tenant ID in the metadata that is not a valid one is an
+// error, as it could otherwise name another document's objects.
+func (r TenantRouting) tenantFor(bucket string, metadata map[string]string) (string, error) {
+	if tenant, ok := r.Buckets[bucket]; ok {
+		return tenant, nil
+	}
+	tenant := strings.TrimSpace(metadata[tenantMetadataKey])
+	if tenant != "" && !models.ValidTenantID(tenant) {
+		return "", fmt.Errorf("upload metadata %q is not a valid tenant ID: %q", tenantMetadataKey, tenant)
+	}
+	return tenant, nil
+}
+
+// DocumentTenants checks that the tenant a stage request names is the one recorded on
+// its document, so that a request cannot have a stage read or write another tenant's
+// objects. A document's tenant never changes, so it is cached for the life of the
+// instance.
+type DocumentTenants struct {
+	firestoreClient *firestore.Client
+	collection      string
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+// NewDocumentTenants creates a DocumentTenants over the documents collection.
+func NewDocumentTenants(firestoreClient *firestore.Client, collection string) *DocumentTenants {
+	return &DocumentTenants{
+		firestoreClient: firestoreClient,
+		collection:      collection,
+		cache:           make(map[string]string),
+	}
+}
+
+// Check returns an INVALID_REQUEST error wrapping ErrTenantMismatch if tenantID is not the
+// tenant of document docID, and a NOT_FOUND_SOURCE error if there is no such document.
+// Unlike a cancellation check, a document that cannot be read fails the check, as the
+// stage would not know where its objects are. A nil DocumentTenants, as the local flow
+// runs the stages with, accepts any tenant.
+func (t *DocumentTenants) Check(ctx context.Context, docID, tenantID string) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	recorded, ok := t.cache[docID]
+	t.mu.Unlock()
+	if !ok {
+		snap, err := t.firestoreClient.Collection(t.collection).Doc(docID).Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			return models.WithCode(models.ErrorCodeNotFoundSource, fmt.Errorf("document %s does not exist", docID))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read document %s for its tenant: %w", docID, err)
+		}
+		value, _ := snap.DataAt("tenantId")
+		recorded, _ = value.(string)
+		t.mu.Lock()
+		t.cache[docID] = recorded
+		t.mu.Unlock()
+	}
+	if tenantID != recorded {
+		return models.WithCode(models.ErrorCodeInvalidRequest, fmt.Errorf("%w: %s belongs to tenant %q, not %q", ErrTenantMismatch, docID, recorded, tenantID))
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+func TestDocumentTenantsCheck(t *testing.T) {
+	// Seeded as if each document had been read, so Firestore is never reached.
+	tenants := NewDocumentTenants(nil, "documents")
+	tenants.cache["doc-acme"] = "acme"
+	tenants.cache["doc-shared"] = ""
+	tests := []struct {
+		name         string
+		tenants      *DocumentTenants
+		docID        string
+		tenantID     string
+		wantMismatch bool
+	}{
+		{name: "same tenant", tenants: tenants, docID: "doc-acme", tenantID: "acme"},
+		{name: "no tenant on either", tenants: tenants, docID: "doc-shared"},
+		{name: "another tenant", tenants: tenants, docID: "doc-acme", tenantID: "globex", wantMismatch: true},
+		{name: "tenant omitted", tenants: tenants, docID: "doc-acme", wantMismatch: true},
+		{name: "tenant named for a shared document", tenants: tenants, docID: "doc-shared", tenantID: "acme", wantMismatch: true},
+		{name: "tenant differs only in case", tenants: tenants, docID: "doc-acme", tenantID: "ACME", wantMismatch: true},
+		{name: "nil checker accepts any tenant", docID: "doc-acme", tenantID: "globex"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.tenants.Check(context.Background(), tt.docID, tt.tenantID)
+			if !tt.wantMismatch {
+				if err != nil {
+					t.Errorf("Check() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrTenantMismatch) {
+				t.Fatalf("Check() error = %v, want ErrTenantMismatch", err)
+			}
+			// Not retryable: a redelivered request names the same tenant.
+			if code := models.CodeOf(err); code != models.ErrorCodeInvalidRequest {
+				t.Errorf("Check() code = %s, want %s", code, models.ErrorCodeInvalidRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// StatusReceived is the status of a document the uploader created for an upload that the
+// splitter has not processed yet. The splitter claims the document when it does.
+const StatusReceived = "RECEIVED"
+
+// DocumentUploaderConfig holds configuration for the document uploader.
+type DocumentUploaderConfig struct {
+	ProjectID      string
+	CollectionName string
+	UploadsBucket  string
+	UploadFilter   UploadFilter  // Uploads are named so that the splitter accepts them.
+	MaxUploadBytes int64         // Larger uploads are rejected with 413.
+	Dedupe         DedupeConfig  // As the splitter's, so that both agree on document IDs.
+	Tenants        TenantRouting // As the splitter's, so that both agree on the tenant.
+}
+
+// DocumentUploaderFunction accepts PDFs over HTTP for producers that cannot write to
+// Cloud Storage, storing them in the uploads bucket for the splitter.
+type DocumentUploaderFunction struct {
+	serviceClients
+	firestoreClient *firestore.Client
+	store           objectstore.Client
+	config          DocumentUploaderConfig
+}
+
+// NewDocumentUploader creates a new DocumentUploaderFunction instance.
+func NewDocumentUploader(ctx context.Context) (*DocumentUploaderFunction, error) {
+	projectID := gcp.GetEnv("PROJECT_ID", "")
+	if projectID == "" {
+		return nil, fmt.Errorf("GCP_PROJECT environment variable must be set")
+	}
+	maxUploadBytes, err := strconv.ParseInt(gcp.GetEnv("MAX_UPLOAD_BYTES", "33554432"), 10, 64)
+	if err != nil || maxUploadBytes < 1 {
+		return nil, fmt.Errorf("MAX_UPLOAD_BYTES must be a positive integer")
+	}
+
+	config := DocumentUploaderConfig{
+		ProjectID:      projectID,
+		CollectionName: gcp.GetEnv("FIRESTORE_COLLECTION", "documents"),
+		UploadsBucket:  gcp.GetEnv("UPLOADS_BUCKET", ""),
+		UploadFilter:   loadUploadFilter(),
+		MaxUploadBytes: maxUploadBytes,
+	}
+	if config.UploadsBucket == "" {
+		return nil, fmt.Errorf("UPLOADS_BUCKET environment variable must be set")
+	}
+	dedupe, err := loadDedupeConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Dedupe = dedupe
+	tenants, err := loadTenantRouting()
+	if err != nil {
+		return nil, err
+	}
+	config.Tenants = tenants
+	if skip := config.UploadFilter.skipName(uploadObjectName(config.UploadFilter, "sample")); skip != "" {
+		return nil, fmt.Errorf("the splitter would skip uploaded objects (%s); INPUT_SUFFIX must accept names ending in .pdf", skip)
+	}
+
+	firestoreClient, err := gcp.NewFirestoreClient(ctx, config.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firestore client: %w", err)
+	}
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %w", err)
+	}
+
+	slog.Info("Document uploader initialized.", "bucket", config.UploadsBucket, "prefix", config.UploadFilter.Prefix, "maxUploadBytes", config.MaxUploadBytes)
+	return &DocumentUploaderFunction{
+		serviceClients:  serviceClients{storageClient, firestoreClient},
+		firestoreClient: firestoreClient,
+		store:           objectstore.NewGCS(storageClient),
+		config:          config,
+	}, nil
+}
+
+// MaxUploadBytes returns the size of the largest upload accepted.
+func (f *DocumentUploaderFunction) MaxUploadBytes() int64 {
+	return f.config.MaxUploadBytes
+}
+
+// uploadObjectName returns the name of the object an upload with the given file hash is
+// stored as.
+func uploadObjectName(filter UploadFilter, fileHash string) string {
+	return filter.Prefix + fileHash + ".pdf"
+}
+
+// Upload stores the PDF read from body in the uploads bucket, named by its SHA-256 hash,
+// and returns the ID of the document it is processed as. The splitter keys documents by
+// file hash, so the uploader creates that document as RECEIVED before storing the file and
+// the ID is stable from the start. A file that already has a document is not stored
+// again, unless that document expired under DEDUPE_TTL_DAYS; the earlier document is
+// returned as a duplicate. filename is recorded as the document's original filename, or
+// the object's name if it is empty.
+func (f *DocumentUploaderFunction) Upload(ctx context.Context, filename string, body io.Reader) (*models.DocumentUploadResponse, error) {
+	logCtx := slog.With("filename", filename)
+	logCtx.Info("Receiving upload.")
+
+	upload, err := receiveUpload(body)
+	if err != nil {
+		logCtx.Warn("Failed to receive upload", "error", err)
+		return nil, err
+	}
+	defer os.Remove(upload.path)
+	objectName := uploadObjectName(f.config.UploadFilter, upload.fileHash)
+	if filename == "" {
+		filename = path.Base(objectName)
+	}
+	logCtx = logCtx.With("fileHash", upload.fileHash, "sizeBytes", upload.size, "gcsObject", objectName)
+
+	// Uploads carry no tenant metadata, so their tenant, if any, is the uploads bucket's.
+	tenantID, _ := f.config.Tenants.tenantFor(f.config.UploadsBucket, nil)
+	key := f.config.Dedupe.keyFor(f.config.UploadsBucket, tenantID)
+	docID, existing, err := findDocumentByHash(ctx, f.firestoreClient, f.config.CollectionName, upload.fileHash, key, 0)
+	if err != nil {
+		logCtx.Error("Failed to check for duplicate", "error", err)
+		return nil, err
+	}
+	supersedes := ""
+	if existing != nil && f.config.Dedupe.expired(existing, time.Now()) {
+		logCtx.Info("Re-ingesting a file whose document has expired.", "supersedesDocumentId", docID, "existingCreatedAt", existing.CreatedAt)
+		supersedes, existing = docID, nil
+	}
+	created := false
+	if existing == nil {
+		docID = key.documentID(upload.fileHash)
+		if supersedes != "" {
+			docID = supersedingDocumentID(key, upload.fileHash, supersedes)
+		}
+		err = f.createReceivedDocument(ctx, docID, upload.fileHash, tenantID, key, supersedes, filename, objectName, upload.size)
+		if errors.Is(err, errDuplicateUpload) {
+			existing, err = f.getDocument(ctx, docID)
+		}
+		if err != nil {
+			logCtx.Error("Failed to create document", "error", err)
+			return nil, err
+		}
+		created = existing == nil
+	}
+	logCtx = logCtx.With("documentId", docID)
+	if existing != nil && !f.awaitsStore(ctx, existing) {
+		logCtx.Info("Duplicate upload. Returning the existing document.", "existingDocId", docID, "status", existing.Status)
+		return &models.DocumentUploadResponse{DocumentID: docID, Status: existing.Status, Duplicate: true}, nil
+	}
+
+	if err := f.storeUpload(ctx, upload, objectName, filename, supersedes != ""); err != nil {
+		logCtx.Error("Failed to store upload", "error", err)
+		if created {
+			// Without its object the document would never be processed.
+			if _, err := f.firestoreClient.Collection(f.config.CollectionName).Doc(docID).Delete(ctx); err != nil {
+				logCtx.Warn("Failed to delete the document of an upload that was not stored", "error", err)
+			}
+		}
+		return nil, models.WithCode(models.ErrorCodeOutputWriteFailed, fmt.Errorf("failed to store upload: %w", err))
+	}
+	logCtx.Info("Upload stored for the splitter.")
+	return &models.DocumentUploadResponse{
+		DocumentID: docID,
+		Status:     StatusReceived,
+		GCSUri:     fmt.Sprintf("gs://%s/%s", f.config.UploadsBucket, objectName),
+	}, nil
+}
+
+// receivedUpload is an upload body spooled to a local file, with its checksums.
+type receivedUpload struct {
+	path     string
+	fileHash string
+	crc32c   uint32
+	size     int64
+}
+
+// receiveUpload copies body to a temporary file, hashing it on the way, so that the
+// object can be named by its hash. Bodies that are empty or have no PDF header are
+// rejected as INVALID_REQUEST.
+func receiveUpload(body io.Reader) (*receivedUpload, error) {
+	file, err := os.CreateTemp("", "document-upload-*.pdf")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer file.Close()
+	upload := &receivedUpload{path: file.Name()}
+	fail := func(err error) (*receivedUpload, error) {
+		os.Remove(upload.path)
+		return nil, err
+	}
+
+	fileHash := sha256.New()
+	checksum := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	upload.size, err = io.Copy(io.MultiWriter(file, fileHash, checksum), body)
+	if err != nil {
+		return fail(fmt.Errorf("failed to read upload: %w", err))
+	}
+	if upload.size == 0 {
+		return fail(models.WithCode(models.ErrorCodeInvalidRequest, errors.New("upload is empty")))
+	}
+	header := make([]byte, pdfMagicSearchLimit)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fail(fmt.Errorf("failed to read header of upload: %w", err))
+	}
+	if !bytes.Contains(header[:n], pdfMagic) {
+		return fail(models.WithCode(models.ErrorCodeInvalidRequest, errors.New("upload is not a PDF (no %PDF- header found)")))
+	}
+	upload.fileHash = hex.EncodeToString(fileHash.Sum(nil))
+	upload.crc32c = checksum.Sum32()
+	return upload, nil
+}
+
+// createReceivedDocument creates the RECEIVED document docID for an upload of fileHash,
+// belonging to tenantID, about to be stored as objectName. It returns errDuplicateUpload
+// if the document already exists.
+func (f *DocumentUploaderFunction) createReceivedDocument(ctx context.Context, docID, fileHash, tenantID string, key dedupeKey, supersedes, filename, objectName string, size int64) error {
+	now := time.Now()
+	name := models.NormalizeFilename(filename)
+	doc := models.Document{
+		FileHash:             fileHash,
+		OriginalFilename:     name.Display,
+		OriginalObjectPath:   name.ObjectPath,
+		FilenameSlug:         name.Slug,
+		SourceBucket:         f.config.UploadsBucket,
+		SourceObject:         objectName,
+		SourceSizeBytes:      size,
+		SourceContentType:    "application/pdf",
+		TenantID:             tenantID,
+		Status:               StatusReceived,
+		CreatedAt:            now,
+		DedupeScope:          key.Scope,
+		DedupeKey:            key.Value,
+		SupersedesDocumentID: supersedes,
+		StatusHistory: []models.StatusTransition{
+			{Status: StatusReceived, Timestamp: now, Stage: models.StatusStageUploader},
+		},
+	}
+	if _, err := f.firestoreClient.Collection(f.config.CollectionName).Doc(docID).Create(ctx, doc); err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			return errDuplicateUpload
+		}
+		return fmt.Errorf("failed to create document: %w", err)
+	}
+	return nil
+}
+
+// getDocument reads the document docID.
+func (f *DocumentUploaderFunction) getDocument(ctx context.Context, docID string) (*models.Document, error) {
+	snap, err := f.firestoreClient.Collection(f.config.CollectionName).Doc(docID).Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document %s: %w", docID, err)
+	}
+	var doc models.Document
+	if err := snap.DataTo(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode document %s: %w", docID, err)
+	}
+	return &doc, nil
+}
+
+// awaitsStore reports whether doc is a RECEIVED document whose upload was never stored,
+// because an earlier request failed after creating it. Storing the upload again lets the
+// splitter pick it up.
+func (f *DocumentUploaderFunction) awaitsStore(ctx context.Context, doc *models.Document) bool {
+	if !awaitsUpload(doc, f.config.UploadsBucket, doc.SourceObject) {
+		return false
+	}
+	_, err := f.store.Bucket(f.config.UploadsBucket).Attrs(ctx, doc.SourceObject)
+	return errors.Is(err, objectstore.ErrObjectNotExist)
+}
+
+// storeUpload writes the spooled upload to objectName, recording filename in its
+// metadata. An object that already exists holds the same file, as it is named by its
+// hash, so it is left in place unless replace is set: a file re-ingested once its document
+// expired is written again, for the splitter to be notified of it.
+func (f *DocumentUploaderFunction) storeUpload(ctx context.Context, upload *receivedUpload, objectName, filename string, replace bool) error {
+	file, err := os.Open(upload.path)
+	if err != nil {
+		return fmt.Errorf("could not open local file %s: %w", upload.path, err)
+	}
+	defer file.Close()
+	opts := objectstore.WriterOptions{
+		DoesNotExist: !replace,
+		ContentType:  "application/pdf",
+		Metadata:     map[string]string{originalFilenameMetadataKey: filename},
+		SendCRC32C:   true,
+		CRC32C:       upload.crc32c,
+	}
+	writer := f.store.Bucket(f.config.UploadsBucket).NewWriter(ctx, objectName, opts)
+	if _, err := io.Copy(writer, file); err != nil {
+		_ = writer.Close()
+		if errors.Is(err, objectstore.ErrPreconditionFailed) {
+			return nil
+		}
+		return fmt.Errorf("io.Copy to GCS failed: %w", err)
+	}
+	if err := writer.Close(); err != nil && !errors.Is(err, objectstore.ErrPreconditionFailed) {
+		return fmt.Errorf("failed to close GCS writer (finalize upload): %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// errModelRefusal is wrapped by the error returned when Gemini answers with a refusal
+// instead of the requested content.
+var errModelRefusal = errors.New("gemini response indicates refusal")
+
+// sourceError classifies a failure to read a stage's input: NOT_FOUND_SOURCE when the
+// object or document does not exist and EMPTY_SOURCE when it has no content. Other
+// failures are returned unclassified.
+func sourceError(err error) error {
+	switch {
+	case errors.Is(err, objectstore.ErrObjectNotExist), status.Code(err) == codes.NotFound:
+		return models.WithCode(models.ErrorCodeNotFoundSource, err)
+	case errors.Is(err, ErrEmptyMarkdown):
+		return models.WithCode(models.ErrorCodeEmptySource, err)
+	default:
+		return err
+	}
+}
+
+// modelError classifies a failed Gemini call. A blocked prompt and a filtered or refused
+// response are LLM_BLOCKED and an exhausted quota LLM_QUOTA. Vertex AI rejects a file input that does
+// not exist as NOT_FOUND and one it cannot read as INVALID_ARGUMENT, neither of which a
+// retry fixes. Anything else is LLM_UNAVAILABLE.
+func modelError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case asFilteredResponseError(nil, err) != nil, errors.Is(err, errModelRefusal):
+		return models.WithCode(models.ErrorCodeLLMBlocked, err)
+	}
+	switch status.Code(err) {
+	case codes.ResourceExhausted:
+		return models.WithCode(models.ErrorCodeLLMQuota, err)
+	case codes.NotFound:
+		return models.WithCode(models.ErrorCodeNotFoundSource, err)
+	case codes.InvalidArgument:
+		return models.WithCode(models.ErrorCodeInvalidRequest, err)
+	default:
+		return models.WithCode(models.ErrorCodeLLMUnavailable, err)
+	}
+}
+
+// outputError classifies a failure to write a stage's output.
+func outputError(err error) error {
+	return models.WithCode(models.ErrorCodeOutputWriteFailed, err)
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// GCSEvent is the uploaded object a storage event is about, normalized from whichever
+// payload shape delivered it. Generation, Size and ContentType are zero when the payload
+// did not carry them.
+type GCSEvent struct {
+	Bucket      string
+	Name        string
+	Generation  int64
+	Size        int64
+	ContentType string
+}
+
+// gcsEventPayload decodes the storage event payloads the splitter is delivered:
+//   - Eventarc's StorageObjectData, whose proto JSON writes generation and size as strings;
+//   - the plain {"bucket", "name"} object;
+//   - the legacy background function envelope, {"context": {...}, "data": {...}}, whose
+//     data is the object resource.
+type gcsEventPayload struct {
+	Bucket      string          `json:"bucket"`
+	Name        string          `json:"name"`
+	Generation  jsonInt64       `json:"generation"`
+	Size        jsonInt64       `json:"size"`
+	ContentType string          `json:"contentType"`
+	Context     json.RawMessage `json:"context"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// jsonInt64 is an int64 written as a JSON number or as a string, as proto JSON writes
+// 64-bit integers. null and "" are zero.
+type jsonInt64 int64
+
+func (n *jsonInt64) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		var value *int64
+		if err := json.Unmarshal(data, &value); err != nil {
+			return fmt.Errorf("must be an integer or a string, got %s", data)
+		}
+		if value != nil {
+			*n = jsonInt64(*value)
+		}
+		return nil
+	}
+	if text == "" {
+		*n = 0
+		return nil
+	}
+	value, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return fmt.Errorf("must be an integer, got %q", text)
+	}
+	*n = jsonInt64(value)
+	return nil
+}
+
+// ParseGCSEvent decodes the data of a storage event in any of the shapes gcsEventPayload
+// describes, and validates that it names an object.
+func ParseGCSEvent(data []byte) (GCSEvent, error) {
+	var payload gcsEventPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return GCSEvent{}, fmt.Errorf("failed to decode storage event: %w", err)
+	}
+	if payload.Bucket == "" && payload.Name == "" && len(payload.Context) > 0 && len(payload.Data) > 0 {
+		var object gcsEventPayload
+		if err := json.Unmarshal(payload.Data, &object); err != nil {
+			return GCSEvent{}, fmt.Errorf("failed to decode the data of a background function event: %w", err)
+		}
+		payload = object
+	}
+	e := GCSEvent{
+		Bucket:      payload.Bucket,
+		Name:        payload.Name,
+		Generation:  int64(payload.Generation),
+		Size:        int64(payload.Size),
+		ContentType: payload.ContentType,
+	}
+	if e.Bucket == "" || e.Name == "" {
+		return GCSEvent{}, fmt.Errorf("storage event must name a bucket and an object")
+	}
+	if e.Generation < 0 || e.Size < 0 {
+		return GCSEvent{}, fmt.Errorf("storage event generation and size must not be negative")
+	}
+	return e, nil
+}
+
+// ProcessEvent splits the upload a storage event is about. data is the data of the
+// CloudEvent. A payload that cannot be parsed can never be processed, so it is logged and
+// acknowledged rather than retried.
+func (f *PDFSplitterFunction) ProcessEvent(ctx context.Context, data []byte) error {
+	e, err := ParseGCSEvent(data)
+	if err != nil {
+		slog.Error("Dropping a storage event that cannot be processed", "error", err, "data", string(bytes.TrimSpace(data)))
+		return nil
+	}
+	return f.Process(ctx, e)
+}
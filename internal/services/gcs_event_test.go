@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+)
+
+func TestParseGCSEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    GCSEvent
+		wantErr string
+	}{
+		{
+			name: "cloud event with proto JSON strings",
+			data: `{"bucket": "uploads", "name": "manuals/pump.pdf", "generation": "1714981234567890", "size": "52311", "contentType": "application/pdf", "metageneration": "1"}`,
+			want: GCSEvent{Bucket: "uploads", Name: "manuals/pump.pdf", Generation: 1714981234567890, Size: 52311, ContentType: "application/pdf"},
+		},
+		{
+			name: "cloud event with numbers",
+			data: `{"bucket": "uploads", "name": "pump.pdf", "generation": 1714981234567890, "size": 52311}`,
+			want: GCSEvent{Bucket: "uploads", Name: "pump.pdf", Generation: 1714981234567890, Size: 52311},
+		},
+		{
+			name: "plain bucket and name",
+			data: `{"bucket": "uploads", "name": "pump.pdf"}`,
+			want: GCSEvent{Bucket: "uploads", Name: "pump.pdf"},
+		},
+		{
+			name: "null and empty numbers",
+			data: `{"bucket": "uploads", "name": "pump.pdf", "generation": null, "size": ""}`,
+			want: GCSEvent{Bucket: "uploads", Name: "pump.pdf"},
+		},
+		{
+			name: "legacy background function envelope",
+			data: `{"context": {"eventId": "1", "eventType": "google.storage.object.finalize"}, "data": {"bucket": "uploads", "name": "pump.pdf", "generation": "7", "size": "52311", "contentType": "application/pdf"}}`,
+			want: GCSEvent{Bucket: "uploads", Name: "pump.pdf", Generation: 7, Size: 52311, ContentType: "application/pdf"},
+		},
+		{
+			name: "legacy envelope with numbers",
+			data: `{"context": {"eventId": "1"}, "data": {"bucket": "uploads", "name": "pump.pdf", "generation": 7, "size": 52311}}`,
+			want: GCSEvent{Bucket: "uploads", Name: "pump.pdf", Generation: 7, Size: 52311},
+		},
+		{
+			name: "folder placeholder",
+			data: `{"bucket": "uploads", "name": "manuals/", "generation": "3", "size": "0"}`,
+			want: GCSEvent{Bucket: "uploads", Name: "manuals/", Generation: 3},
+		},
+		{name: "missing bucket", data: `{"name": "pump.pdf"}`, wantErr: "must name a bucket and an object"},
+		{name: "missing name", data: `{"bucket": "uploads"}`, wantErr: "must name a bucket and an object"},
+		{name: "empty object", data: `{}`, wantErr: "must name a bucket and an object"},
+		{name: "legacy envelope missing name", data: `{"context": {"eventId": "1"}, "data": {"bucket": "uploads"}}`, wantErr: "must name a bucket and an object"},
+		{name: "legacy envelope with bad data", data: `{"context": {"eventId": "1"}, "data": "pump.pdf"}`, wantErr: "data of a background function event"},
+		{name: "context without data", data: `{"context": {"eventId": "1"}}`, wantErr: "must name a bucket and an object"},
+		{name: "negative size", data: `{"bucket": "uploads", "name": "pump.pdf", "size": -1}`, wantErr: "must not be negative"},
+		{name: "negative generation", data: `{"bucket": "uploads", "name": "pump.pdf", "generation": "-7"}`, wantErr: "must not be negative"},
+		{name: "size not a number", data: `{"bucket": "uploads", "name": "pump.pdf", "size": "52 KB"}`, wantErr: "must be an integer"},
+		{name: "generation a float", data: `{"bucket": "uploads", "name": "pump.pdf", "generation": 1.5}`, wantErr: "must be an integer"},
+		{name: "not JSON", data: `bucket=uploads`, wantErr: "failed to decode storage event"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseGCSEvent([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseGCSEvent() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				if got != (GCSEvent{}) {
+					t.Errorf("ParseGCSEvent() = %+v alongside an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseGCSEvent() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseGCSEvent() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONInt64(t *testing.T) {
+	tests := []struct {
+		data    string
+		want    jsonInt64
+		wantErr bool
+	}{
+		{data: `42`, want: 42},
+		{data: `"42"`, want: 42},
+		{data: `"9223372036854775807"`, want: 9223372036854775807},
+		{data: `9223372036854775807`, want: 9223372036854775807},
+		{data: `"-3"`, want: -3},
+		{data: `null`, want: 0},
+		{data: `""`, want: 0},
+		{data: `"9223372036854775808"`, wantErr: true},
+		{data: `" 42"`, wantErr: true},
+		{data: `"0x2a"`, wantErr: true},
+		{data: `4.2`, wantErr: true},
+		{data: `true`, wantErr: true},
+		{data: `[42]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			n := jsonInt64(99)
+			err := json.Unmarshal([]byte(tt.data), &n)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v, wantErr %v", tt.data, err, tt.wantErr)
+			}
+			if !tt.wantErr && n != tt.want {
+				t.Errorf("Unmarshal(%s) = %d, want %d", tt.data, n, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessEventDropsWithoutReadingTheUpload(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "folder placeholder", data: `{"bucket": "uploads", "name": "manuals/", "size": "0"}`},
+		{name: "legacy folder placeholder", data: `{"context": {"eventId": "1"}, "data": {"bucket": "uploads", "name": "manuals/2024/"}}`},
+		{name: "missing name", data: `{"bucket": "uploads"}`},
+		{name: "not JSON", data: `not json`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// No store is configured, so reading the upload would panic.
+			f := &PDFSplitterFunction{}
+			if err := f.ProcessEvent(context.Background(), []byte(tt.data)); err != nil {
+				t.Errorf("ProcessEvent() error = %v, want the event dropped", err)
+			}
+		})
+	}
+}
+
+func TestCheckUpload(t *testing.T) {
+	store := objectstore.NewMemory()
+	store.Put("uploads", "manuals/pump.pdf", []byte("%PDF-1.7 first"), nil)
+	store.Put("uploads", "manuals/pump.pdf", []byte("%PDF-1.7"), nil)
+	attrs, err := store.Bucket("uploads").Attrs(context.Background(), "manuals/pump.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		event    GCSEvent
+		wantSkip string
+	}{
+		{name: "accepted", event: GCSEvent{Bucket: "uploads", Name: "manuals/pump.pdf", Generation: attrs.Generation}},
+		{name: "accepted without a generation", event: GCSEvent{Bucket: "uploads", Name: "manuals/pump.pdf"}},
+		{name: "folder placeholder", event: GCSEvent{Bucket: "uploads", Name: "manuals/"}, wantSkip: "folder placeholder"},
+		{name: "deleted since", event: GCSEvent{Bucket: "uploads", Name: "manuals/gone.pdf"}, wantSkip: "object no longer exists"},
+		{name: "replaced since", event: GCSEvent{Bucket: "uploads", Name: "manuals/pump.pdf", Generation: attrs.Generation - 1}, wantSkip: "object replaced by generation"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &PDFSplitterFunction{store: store}
+			got, skip, err := f.checkUpload(context.Background(), tt.event)
+			if err != nil {
+				t.Fatalf("checkUpload() error = %v", err)
+			}
+			if tt.wantSkip == "" {
+				if skip != "" || got == nil || got.Generation != attrs.Generation {
+					t.Errorf("checkUpload() = %+v, %q; want the upload accepted", got, skip)
+				}
+				return
+			}
+			if !strings.HasPrefix(skip, tt.wantSkip) {
+				t.Errorf("checkUpload() skip = %q, want %q", skip, tt.wantSkip)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// continuationPromptFormat asks the model to carry on from output that was cut off at the
+// output token limit. The generated text so far is substituted in.
+const continuationPromptFormat = `Your previous response was cut off because it reached the output length limit. The response so far is between the markers below.
+
+<<<PARTIAL RESPONSE>>>
+%s
+<<<END PARTIAL RESPONSE>>>
+
+Continue the response from exactly where it stops. Output only the continuation: do not repeat any of the text above, do not restart the document, and do not add commentary.`
+
+// FilteredResponseError reports that Gemini blocked the prompt, or stopped generating
+// because the output tripped its safety or recitation filters. The outcome is
+// deterministic for a given input, so it is never retried.
+type FilteredResponseError struct {
+	FinishReason genai.FinishReason
+	// BlockReason is set when the prompt itself was blocked, and no output was generated.
+	BlockReason genai.BlockedReason
+	// SafetyRatings are the ratings of the blocked prompt or of the filtered output.
+	SafetyRatings []*genai.SafetyRating
+}
+
+func (e *FilteredResponseError) Error() string {
+	msg := fmt.Sprintf("gemini stopped generating: %s", e.FinishReason)
+	if e.BlockReason != genai.BlockedReasonUnspecified {
+		msg = fmt.Sprintf("gemini blocked the prompt: %s", e.BlockReason)
+	}
+	if category := e.Category(); category != "" {
+		msg += " (" + category + ")"
+	}
+	return msg
+}
+
+// Reason returns why the response was blocked: the prompt's block reason, or else the
+// output's finish reason.
+func (e *FilteredResponseError) Reason() string {
+	if e.BlockReason != genai.BlockedReasonUnspecified {
+		return e.BlockReason.String()
+	}
+	return e.FinishReason.String()
+}
+
+// Category returns the harm category that blocked the response: that of a rating marked
+// as blocking it, or else of the most probable harm. It is empty if no rating is above
+// negligible.
+func (e *FilteredResponseError) Category() string {
+	var worst *genai.SafetyRating
+	for _, rating := range e.SafetyRatings {
+		if rating == nil {
+			continue
+		}
+		if rating.Blocked {
+			return rating.Category.String()
+		}
+		if rating.Probability > genai.HarmProbabilityNegligible && (worst == nil || rating.Probability > worst.Probability) {
+			worst = rating
+		}
+	}
+	if worst == nil {
+		return ""
+	}
+	return worst.Category.String()
+}
+
+// loadMaxContinuations reads TRANSLATOR_MAX_CONTINUATIONS.
+func loadMaxContinuations() (int, error) {
+	maxContinuations, err := strconv.Atoi(gcp.GetEnv("TRANSLATOR_MAX_CONTINUATIONS", "2"))
+	if err != nil || maxContinuations < 0 {
+		return 0, fmt.Errorf("TRANSLATOR_MAX_CONTINUATIONS must be a non-negative integer")
+	}
+	return maxContinuations, nil
+}
+
+// asFilteredResponseError returns a FilteredResponseError if err or resp show that the
+// prompt was blocked or generation was stopped by a filter, and nil otherwise. The genai
+// client reports blocked prompts and safety stops as a BlockedError but returns
+// recitation stops as ordinary responses.
+func asFilteredResponseError(resp *genai.GenerateContentResponse, err error) error {
+	var filtered *FilteredResponseError
+	if errors.As(err, &filtered) {
+		return filtered
+	}
+	var blocked *genai.BlockedError
+	if errors.As(err, &blocked) {
+		switch {
+		case blocked.Candidate != nil:
+			return &FilteredResponseError{FinishReason: blocked.Candidate.FinishReason, SafetyRatings: blocked.Candidate.SafetyRatings}
+		case blocked.PromptFeedback != nil:
+			return &FilteredResponseError{BlockReason: blocked.PromptFeedback.BlockReason, SafetyRatings: blocked.PromptFeedback.SafetyRatings}
+		}
+	}
+	if resp != nil && resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != genai.BlockedReasonUnspecified {
+		return &FilteredResponseError{BlockReason: resp.PromptFeedback.BlockReason, SafetyRatings: resp.PromptFeedback.SafetyRatings}
+	}
+	switch reason := finishReason(resp); reason {
+	case genai.FinishReasonSafety, genai.FinishReasonRecitation:
+		return &FilteredResponseError{FinishReason: reason, SafetyRatings: resp.Candidates[0].SafetyRatings}
+	}
+	return nil
+}
+
+// finishReason returns why generation of the response's first candidate stopped.
+func finishReason(resp *genai.GenerateContentResponse) genai.FinishReason {
+	if resp == nil || len(resp.Candidates) == 0 {
+		return genai.FinishReasonUnspecified
+	}
+	return resp.Candidates[0].FinishReason
+}
+
+// responseText concatenates the text parts of the response's first candidate.
+func responseText(resp *genai.GenerateContentResponse) string {
+	if resp == nil || len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return ""
+	}
+	var text strings.Builder
+	for _, part := range resp.Candidates[0].Content.Parts {
+		if txt, ok := part.(genai.Text); ok {
+			text.WriteString(string(txt))
+		}
+	}
+	return text.String()
+}
+
+// Bounds of the overlap stitchContinuation removes, in bytes. Shorter overlaps, such as a
+// repeated space or newline, are as likely to be meant as repeated.
+const (
+	minContinuationOverlap = 16
+	maxContinuationOverlap = 2000
+)
+
+// stitchContinuation appends a continuation to the text generated so far. Despite the
+// prompt, models sometimes open a continuation by repeating the end of the text they were
+// shown; the longest such overlap is dropped from the continuation.
+func stitchContinuation(text, continuation string) string {
+	for k := min(len(text), len(continuation), maxContinuationOverlap); k >= minContinuationOverlap; k-- {
+		if strings.HasSuffix(text, continuation[:k]) {
+			return text + continuation[k:]
+		}
+	}
+	return text + continuation
+}
+
+// continueTruncated asks the model to continue a response that stopped at the output token
+// limit, up to maxRounds times. It returns a single-candidate response holding the text
+// stitched together by stitchContinuation, with the last round's finish reason and the
+// usage of every round, the number of model attempts made, and whether the output still
+// ends truncated.
+func continueTruncated(ctx context.Context, logCtx *slog.Logger, model gcp.ContentGenerator, cfg GeminiRetryConfig, maxRounds int, resp *genai.GenerateContentResponse, parts ...genai.Part) (*genai.GenerateContentResponse, int, bool, error) {
+	if finishReason(resp) != genai.FinishReasonMaxTokens {
+		return resp, 0, false, nil
+	}
+
+	text := responseText(resp)
+	usage := resp.UsageMetadata
+	reason := genai.FinishReasonMaxTokens
+	attempts := 0
+	for round := 1; round <= maxRounds && reason == genai.FinishReasonMaxTokens; round++ {
+		logCtx.Warn("Gemini response hit the output token limit; requesting a continuation.", "round", round, "generatedChars", len(text))
+		prompt := genai.Text(fmt.Sprintf(continuationPromptFormat, text))
+		next, n, err := generateWithRetry(ctx, logCtx, model, cfg, append(append([]genai.Part(nil), parts...), prompt)...)
+		attempts += n
+		if filtered := asFilteredResponseError(next, err); filtered != nil {
+			return nil, attempts, false, filtered
+		}
+		if err != nil {
+			return nil, attempts, false, fmt.Errorf("continuation round %d failed: %w", round, err)
+		}
+		text = stitchContinuation(text, responseText(next))
+		usage = addUsageMetadata(usage, next.UsageMetadata)
+		reason = finishReason(next)
+	}
+
+	truncated := reason == genai.FinishReasonMaxTokens
+	if truncated {
+		logCtx.Warn("Gemini response is still truncated after the maximum number of continuations.", "maxContinuations", maxRounds)
+	}
+	return &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{
+			Content:      &genai.Content{Role: "model", Parts: []genai.Part{genai.Text(text)}},
+			FinishReason: reason,
+		}},
+		UsageMetadata: usage,
+	}, attempts, truncated, nil
+}
+
+// addUsageMetadata returns the sum of two responses' usage metadata, either of which may
+// be nil.
+func addUsageMetadata(total, usage *genai.UsageMetadata) *genai.UsageMetadata {
+	if usage == nil {
+		return total
+	}
+	if total == nil {
+		sum := *usage
+		return &sum
+	}
+	return &genai.UsageMetadata{
+		PromptTokenCount:     total.PromptTokenCount + usage.PromptTokenCount,
+		CandidatesTokenCount: total.CandidatesTokenCount + usage.CandidatesTokenCount,
+		ThoughtsTokenCount:   total.ThoughtsTokenCount + usage.ThoughtsTokenCount,
+		TotalTokenCount:      total.TotalTokenCount + usage.TotalTokenCount,
+	}
+}
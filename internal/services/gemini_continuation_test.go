@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/vertexai/genai"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+// finishedResponse builds a single-candidate response of text that stopped for reason,
+// having generated tokens output tokens from a 100 token prompt.
+func finishedResponse(text string, reason genai.FinishReason, tokens int32) *genai.GenerateContentResponse {
+	resp := testsupport.TextResponse(text)
+	resp.Candidates[0].FinishReason = reason
+	resp.UsageMetadata = &genai.UsageMetadata{PromptTokenCount: 100, CandidatesTokenCount: tokens, TotalTokenCount: 100 + tokens}
+	return resp
+}
+
+func TestContinueTruncated(t *testing.T) {
+	const (
+		first  = "| Part | Clearance |\n|---|---|\n| Impeller | 0.35 mm |\n"
+		second = "| Casing | 0.50 mm |\n"
+		third  = "| Seal | 0.10 mm |"
+	)
+	tests := []struct {
+		name          string
+		resp          *genai.GenerateContentResponse
+		results       []testsupport.FakeResult
+		maxRounds     int
+		wantText      string
+		wantReason    genai.FinishReason
+		wantAttempts  int
+		wantTruncated bool
+		wantTokens    int32 // Candidate tokens of every round.
+		wantErr       string
+	}{
+		{
+			name:       "not truncated",
+			resp:       finishedResponse(first, genai.FinishReasonStop, 10),
+			maxRounds:  2,
+			wantText:   first,
+			wantReason: genai.FinishReasonStop,
+			wantTokens: 10,
+		},
+		{
+			name:         "one continuation",
+			resp:         finishedResponse(first, genai.FinishReasonMaxTokens, 10),
+			results:      []testsupport.FakeResult{{Response: finishedResponse(second, genai.FinishReasonStop, 5)}},
+			maxRounds:    2,
+			wantText:     first + second,
+			wantReason:   genai.FinishReasonStop,
+			wantAttempts: 1,
+			wantTokens:   15,
+		},
+		{
+			name: "two continuations",
+			resp: finishedResponse(first, genai.FinishReasonMaxTokens, 10),
+			results: []testsupport.FakeResult{
+				{Response: finishedResponse(second, genai.FinishReasonMaxTokens, 5)},
+				{Response: finishedResponse(third, genai.FinishReasonStop, 5)},
+			},
+			maxRounds:    2,
+			wantText:     first + second + third,
+			wantReason:   genai.FinishReasonStop,
+			wantAttempts: 2,
+			wantTokens:   20,
+		},
+		{
+			name: "continuation cap",
+			resp: finishedResponse(first, genai.FinishReasonMaxTokens, 10),
+			results: []testsupport.FakeResult{
+				{Response: finishedResponse(second, genai.FinishReasonMaxTokens, 5)},
+				{Response: finishedResponse(third, genai.FinishReasonMaxTokens, 5)},
+			},
+			maxRounds:     2,
+			wantText:      first + second + third,
+			wantReason:    genai.FinishReasonMaxTokens,
+			wantAttempts:  2,
+			wantTruncated: true,
+			wantTokens:    20,
+		},
+		{
+			name:          "continuations disabled",
+			resp:          finishedResponse(first, genai.FinishReasonMaxTokens, 10),
+			wantText:      first,
+			wantReason:    genai.FinishReasonMaxTokens,
+			wantTruncated: true,
+			wantTokens:    10,
+		},
+		{
+			name:         "repeated end of the partial response",
+			resp:         finishedResponse(first, genai.FinishReasonMaxTokens, 10),
+			results:      []testsupport.FakeResult{{Response: finishedResponse("| Impeller | 0.35 mm |\n"+second, genai.FinishReasonStop, 5)}},
+			maxRounds:    2,
+			wantText:     first + second,
+			wantReason:   genai.FinishReasonStop,
+			wantAttempts: 1,
+			wantTokens:   15,
+		},
+		{
+			name:      "filtered continuation",
+			resp:      finishedResponse(first, genai.FinishReasonMaxTokens, 10),
+			results:   []testsupport.FakeResult{{Response: finishedResponse("", genai.FinishReasonRecitation, 0)}},
+			maxRounds: 2,
+			wantErr:   "gemini stopped generating",
+		},
+		{
+			name:      "failed continuation",
+			resp:      finishedResponse(first, genai.FinishReasonMaxTokens, 10),
+			results:   []testsupport.FakeResult{{Err: status.Error(codes.InvalidArgument, "bad request")}},
+			maxRounds: 2,
+			wantErr:   "continuation round 1 failed",
+		},
+	}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := GeminiRetryConfig{MaxRetries: 0, BaseDelay: time.Millisecond}
+	page := genai.FileData{MIMEType: "application/pdf", FileURI: "gs://pages/doc-1/00001.pdf"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := testsupport.NewFakeContentGenerator(tt.results...)
+			got, attempts, truncated, err := continueTruncated(context.Background(), logCtx, model, cfg, tt.maxRounds, tt.resp, page, genai.Text("Translate the page."))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("continueTruncated() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("continueTruncated() error = %v", err)
+			}
+			if text := responseText(got); text != tt.wantText {
+				t.Errorf("continueTruncated() text = %q, want %q", text, tt.wantText)
+			}
+			if reason := finishReason(got); reason != tt.wantReason {
+				t.Errorf("continueTruncated() finish reason = %v, want %v", reason, tt.wantReason)
+			}
+			if attempts != tt.wantAttempts || truncated != tt.wantTruncated {
+				t.Errorf("continueTruncated() = %d attempts, truncated %v; want %d, %v", attempts, truncated, tt.wantAttempts, tt.wantTruncated)
+			}
+			if got.UsageMetadata == nil || got.UsageMetadata.CandidatesTokenCount != tt.wantTokens {
+				t.Errorf("continueTruncated() usage = %+v, want %d candidate tokens", got.UsageMetadata, tt.wantTokens)
+			}
+			if calls := model.Calls(); len(calls) != tt.wantAttempts {
+				t.Errorf("model called %d times, want %d", len(calls), tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestContinueTruncatedPrompt(t *testing.T) {
+	const partial = "# Pump\n\nThe impeller clearance is"
+	model := testsupport.NewFakeContentGenerator(testsupport.FakeResult{Response: finishedResponse(" 0.35 mm.", genai.FinishReasonStop, 5)})
+	page := genai.FileData{MIMEType: "application/pdf", FileURI: "gs://pages/doc-1/00001.pdf"}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	_, _, _, err := continueTruncated(context.Background(), logCtx, model, GeminiRetryConfig{}, 1, finishedResponse(partial, genai.FinishReasonMaxTokens, 10), page, genai.Text("Translate the page."))
+	if err != nil {
+		t.Fatalf("continueTruncated() error = %v", err)
+	}
+	calls := model.Calls()
+	if len(calls) != 1 || len(calls[0]) != 3 {
+		t.Fatalf("continuation calls = %v, want one of the page, its prompt and the continuation prompt", calls)
+	}
+	if calls[0][0] != page || calls[0][1] != genai.Text("Translate the page.") {
+		t.Errorf("continuation call = %v, want the original parts first", calls[0])
+	}
+	if prompt, _ := calls[0][2].(genai.Text); !strings.Contains(string(prompt), "<<<PARTIAL RESPONSE>>>\n"+partial+"\n<<<END PARTIAL RESPONSE>>>") {
+		t.Errorf("continuation prompt = %q, want the partial response", prompt)
+	}
+}
+
+func TestStitchContinuation(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		continuation string
+		want         string
+	}{
+		{name: "no overlap", text: "The impeller clearance is", continuation: " 0.35 mm.", want: "The impeller clearance is 0.35 mm."},
+		{name: "repeated line", text: "# Pump\n\nThe impeller clearance is", continuation: "The impeller clearance is 0.35 mm.", want: "# Pump\n\nThe impeller clearance is 0.35 mm."},
+		{name: "repeated whole text", text: "The impeller clearance is", continuation: "The impeller clearance is 0.35 mm.", want: "The impeller clearance is 0.35 mm."},
+		{name: "short overlap kept", text: "| A |\n| B |", continuation: "| B |\n| C |", want: "| A |\n| B || B |\n| C |"},
+		{name: "overlap at the minimum", text: "xx0123456789abcdef", continuation: "0123456789abcdef!", want: "xx0123456789abcdef!"},
+		{name: "overlap under the minimum", text: "xx0123456789abcde", continuation: "0123456789abcde!", want: "xx0123456789abcde0123456789abcde!"},
+		{name: "continuation within the text", text: "The impeller clearance is 0.35 mm.", continuation: "impeller clearance", want: "The impeller clearance is 0.35 mm.impeller clearance"},
+		{name: "empty continuation", text: "The impeller clearance is", continuation: "", want: "The impeller clearance is"},
+		{name: "empty text", text: "", continuation: "The impeller clearance is", want: "The impeller clearance is"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stitchContinuation(tt.text, tt.continuation); got != tt.want {
+				t.Errorf("stitchContinuation() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAsFilteredResponseError(t *testing.T) {
+	tests := []struct {
+		name       string
+		resp       *genai.GenerateContentResponse
+		err        error
+		wantReason string
+	}{
+		{name: "finished", resp: finishedResponse("x", genai.FinishReasonStop, 1)},
+		{name: "truncated", resp: finishedResponse("x", genai.FinishReasonMaxTokens, 1)},
+		{name: "recitation", resp: finishedResponse("", genai.FinishReasonRecitation, 0), wantReason: genai.FinishReasonRecitation.String()},
+		{name: "blocked prompt", resp: testsupport.BlockedResponse(genai.BlockedReasonSafety), wantReason: genai.BlockedReasonSafety.String()},
+		{name: "blocked output", err: &genai.BlockedError{Candidate: &genai.Candidate{FinishReason: genai.FinishReasonSafety}}, wantReason: genai.FinishReasonSafety.String()},
+		{name: "other error", err: errors.New("boom")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := asFilteredResponseError(tt.resp, tt.err)
+			var filtered *FilteredResponseError
+			if (tt.wantReason != "") != errors.As(err, &filtered) {
+				t.Fatalf("asFilteredResponseError() = %v, want filtered %v", err, tt.wantReason != "")
+			}
+			if filtered != nil && filtered.Reason() != tt.wantReason {
+				t.Errorf("Reason() = %q, want %q", filtered.Reason(), tt.wantReason)
+			}
+		})
+	}
+}
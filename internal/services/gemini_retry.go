@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// maxGeminiBackoff caps a single wait between Gemini attempts.
+const maxGeminiBackoff = 30 * time.Second
+
+// GeminiRetryConfig controls how transient Vertex AI errors are retried in-process.
+type GeminiRetryConfig struct {
+	MaxRetries int           // Retries after the first attempt; 0 disables retrying.
+	BaseDelay  time.Duration // Backoff before the first retry, doubled on each one after.
+}
+
+// loadGeminiRetryConfig reads GEMINI_MAX_RETRIES and GEMINI_RETRY_BASE_MS.
+func loadGeminiRetryConfig() (GeminiRetryConfig, error) {
+	maxRetries, err := strconv.Atoi(gcp.GetEnv("GEMINI_MAX_RETRIES", "3"))
+	if err != nil || maxRetries < 0 {
+		return GeminiRetryConfig{}, fmt.Errorf("GEMINI_MAX_RETRIES must be a non-negative integer")
+	}
+	baseMS, err := strconv.Atoi(gcp.GetEnv("GEMINI_RETRY_BASE_MS", "500"))
+	if err != nil || baseMS <= 0 {
+		return GeminiRetryConfig{}, fmt.Errorf("GEMINI_RETRY_BASE_MS must be a positive integer")
+	}
+	return GeminiRetryConfig{MaxRetries: maxRetries, BaseDelay: time.Duration(baseMS) * time.Millisecond}, nil
+}
+
+// generateWithRetry calls GenerateContent, retrying transient failures with jittered
+// exponential backoff. It returns the number of attempts made alongside the result.
+// Before each attempt it checks the cancellation ctx may carry, and stops with the
+// CANCELLED error once the document was cancelled.
+func generateWithRetry(ctx context.Context, logCtx *slog.Logger, model gcp.ContentGenerator, cfg GeminiRetryConfig, parts ...genai.Part) (resp *genai.GenerateContentResponse, attempts int, err error) {
+	ctx, span := startSpan(ctx, "gemini.GenerateContent")
+	defer func() {
+		span.SetAttributes(attrAttempts.Int(attempts))
+		endSpan(span, err)
+	}()
+	for attempt := 1; ; attempt++ {
+		if err := checkCancelled(ctx); err != nil {
+			return nil, attempt - 1, err
+		}
+		resp, err := model.GenerateContent(ctx, parts...)
+		if err == nil {
+			return resp, attempt, nil
+		}
+		if attempt > cfg.MaxRetries || !isRetryableGeminiError(err) || ctx.Err() != nil {
+			return nil, attempt, err
+		}
+
+		delay := geminiBackoff(cfg.BaseDelay, attempt)
+		logCtx.Warn("Transient Vertex AI error; retrying.", "error", err, "attempt", attempt, "backoff", delay.String())
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, attempt, fmt.Errorf("gave up retrying after %d attempts: %w", attempt, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// isRetryableGeminiError reports whether err is a transient Vertex AI failure. Blocked
+// prompts and responses are deterministic and are never retried.
+func isRetryableGeminiError(err error) bool {
+	var blocked *genai.BlockedError
+	if errors.As(err, &blocked) {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.ResourceExhausted, codes.Internal, codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// geminiBackoff returns the wait before retry number attempt: base doubled per attempt,
+// capped, with full jitter so a fanned-out workflow does not retry in lockstep.
+func geminiBackoff(base time.Duration, attempt int) time.Duration {
+	delay := maxGeminiBackoff
+	if shift := attempt - 1; shift < 16 {
+		if d := base << shift; d > 0 && d < maxGeminiBackoff {
+			delay = d
+		}
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
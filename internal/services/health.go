@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// healthProbeObject is looked up by deep health checks. It need not exist: a not-found
+// answer shows that the bucket is reachable with the service's credentials.
+const healthProbeObject = ".healthz"
+
+// Names of the components reported by health checks.
+const (
+	healthComponentStorage   = "storage"
+	healthComponentFirestore = "firestore"
+	healthComponentVertex    = "vertex"
+)
+
+// HealthCheck reports whether one dependency of a service is usable.
+type HealthCheck = func(ctx context.Context) error
+
+// clientCheck returns a check that fails if a client was never created.
+func clientCheck(created bool, name string) HealthCheck {
+	return func(context.Context) error {
+		if !created {
+			return fmt.Errorf("%s client was not created", name)
+		}
+		return nil
+	}
+}
+
+// bucketCheck returns a check that looks up healthProbeObject in a bucket.
+func bucketCheck(bucket objectstore.Bucket) HealthCheck {
+	return func(ctx context.Context) error {
+		_, err := bucket.Attrs(ctx, healthProbeObject)
+		if err != nil && !errors.Is(err, objectstore.ErrObjectNotExist) {
+			return fmt.Errorf("bucket %s is not reachable: %w", bucket.Name(), err)
+		}
+		return nil
+	}
+}
+
+// storageChecks returns the storage check shared by every service: with deep set, a
+// lookup in the bucket the service writes to, otherwise that the client was created.
+func storageChecks(checks map[string]HealthCheck, client *storage.Client, bucket string, deep bool) map[string]HealthCheck {
+	checks[healthComponentStorage] = clientCheck(client != nil, healthComponentStorage)
+	if deep && client != nil {
+		checks[healthComponentStorage] = bucketCheck(objectstore.WrapBucket(client.Bucket(bucket)))
+	}
+	return checks
+}
+
+// HealthChecks returns the translator's component checks, keyed by component.
+func (f *TranslatorFunction) HealthChecks(deep bool) map[string]HealthCheck {
+	return storageChecks(map[string]HealthCheck{
+		healthComponentFirestore: clientCheck(f.firestoreClient != nil, healthComponentFirestore),
+		healthComponentVertex:    clientCheck(len(f.profileModels) > 0, healthComponentVertex),
+	}, f.storageClient, f.config.MarkdownBucket, deep)
+}
+
+// HealthChecks returns the aggregator's component checks, keyed by component.
+func (f *AggregatorFunction) HealthChecks(deep bool) map[string]HealthCheck {
+	checks := map[string]HealthCheck{
+		healthComponentStorage:   clientCheck(f.store != nil, healthComponentStorage),
+		healthComponentFirestore: clientCheck(f.firestoreClient != nil, healthComponentFirestore),
+	}
+	if deep && f.store != nil {
+		checks[healthComponentStorage] = bucketCheck(f.store.Bucket(f.config.AggregatedMarkdownBucket))
+	}
+	return checks
+}
+
+// HealthChecks returns the cleaner's component checks, keyed by component.
+func (f *CleanerFunction) HealthChecks(deep bool) map[string]HealthCheck {
+	return storageChecks(map[string]HealthCheck{
+		healthComponentFirestore: clientCheck(f.firestoreClient != nil, healthComponentFirestore),
+		healthComponentVertex:    clientCheck(f.model != nil, healthComponentVertex),
+	}, f.storageClient, f.config.CleanedMarkdownBucket, deep)
+}
+
+// HealthChecks returns the section splitter's component checks, keyed by component.
+func (f *SectionSplitterFunction) HealthChecks(deep bool) map[string]HealthCheck {
+	return storageChecks(map[string]HealthCheck{
+		healthComponentFirestore: clientCheck(f.firestoreClient != nil, healthComponentFirestore),
+		healthComponentVertex:    clientCheck(f.model != nil, healthComponentVertex),
+	}, f.storageClient, f.config.FinalSectionsBucket, deep)
+}
+
+// HealthChecks returns the document uploader's component checks, keyed by component.
+func (f *DocumentUploaderFunction) HealthChecks(deep bool) map[string]HealthCheck {
+	checks := map[string]HealthCheck{
+		healthComponentStorage:   clientCheck(f.store != nil, healthComponentStorage),
+		healthComponentFirestore: clientCheck(f.firestoreClient != nil, healthComponentFirestore),
+	}
+	if deep && f.store != nil {
+		checks[healthComponentStorage] = bucketCheck(f.store.Bucket(f.config.UploadsBucket))
+	}
+	return checks
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Image link modes supported when rewriting figure references in translated markdown.
+const (
+	ImageLinkModeRelative = "relative"
+	ImageLinkModeSigned   = "signed"
+)
+
+// extractedImage is a single embedded image pulled out of a page PDF.
+type extractedImage struct {
+	objNr    int
+	fileType string
+	data     []byte
+}
+
+// pageImagesPrefix returns the object prefix under which the images of a page of the
+// document under root are stored.
+func pageImagesPrefix(root string, pageNumber int) string {
+	return fmt.Sprintf("%s/pages/%d/", root, pageNumber)
+}
+
+// pageImageObjectName returns the object name for the k-th (1-based) image of a page.
+func pageImageObjectName(root string, pageNumber, index int, fileType string) string {
+	return fmt.Sprintf("%simg_%d.%s", pageImagesPrefix(root, pageNumber), index, fileType)
+}
+
+// extractPageImages reads every embedded image from a single-page PDF. Images are
+// returned in object-number order so their indices are stable across runs. An image that
+// cannot be decoded, or whose encoding pdfcpu does not support, is skipped and counted
+// rather than failing the whole page.
+func extractPageImages(rs io.ReadSeeker) ([]extractedImage, int, error) {
+	cfg := model.NewDefaultConfiguration()
+	cfg.ValidationMode = model.ValidationRelaxed
+	cfg.Cmd = model.EXTRACTIMAGES
+	pdfCtx, err := api.ReadValidateAndOptimize(rs, cfg)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read page: %w", err)
+	}
+
+	var images []extractedImage
+	var skipped int
+	objNrs := pdfcpu.ImageObjNrs(pdfCtx, 1)
+	sort.Ints(objNrs)
+	for _, objNr := range objNrs {
+		imageObj := pdfCtx.Optimize.ImageObjects[objNr]
+		if imageObj == nil {
+			continue
+		}
+		var resourceName string
+		if len(imageObj.ResourceNames) > 0 {
+			resourceName = imageObj.ResourceNames[0]
+		}
+		img, err := pdfcpu.ExtractImage(pdfCtx, imageObj.ImageDict, false, resourceName, objNr, false)
+		if err != nil || img == nil {
+			skipped++
+			continue
+		}
+		data, err := io.ReadAll(img)
+		if err != nil {
+			skipped++
+			continue
+		}
+		images = append(images, extractedImage{objNr: objNr, fileType: img.FileType, data: data})
+	}
+	return images, skipped, nil
+}
+
+// storePageImages writes the extracted images of a page of the document under root to
+// bucket, numbered from 1, and returns their object names.
+func storePageImages(ctx context.Context, bucket objectstore.Bucket, root string, pageNumber int, images []extractedImage) ([]string, error) {
+	objectNames := make([]string, 0, len(images))
+	for i, img := range images {
+		objectName := pageImageObjectName(root, pageNumber, i+1, img.fileType)
+		writer := bucket.NewWriter(ctx, objectName, objectstore.WriterOptions{ContentType: mime.TypeByExtension("." + img.fileType)})
+
+		if _, err := io.Copy(writer, bytes.NewReader(img.data)); err != nil {
+			_ = writer.Close()
+			return nil, fmt.Errorf("failed to upload page image %s: %w", objectName, err)
+		}
+		if err := writer.Close(); err != nil {
+			return nil, fmt.Errorf("failed to finalize page image %s: %w", objectName, err)
+		}
+		objectNames = append(objectNames, objectName)
+	}
+	return objectNames, nil
+}
+
+// uploadPageImages extracts the embedded images of one split page, stores them in the
+// images bucket under root and returns their URIs, for the page record. Extraction is
+// best effort: a failure is logged and the page simply continues through the pipeline as
+// text only.
+func (f *PDFSplitterFunction) uploadPageImages(ctx context.Context, logCtx *slog.Logger, root string, pageNumber int, localPath string) []string {
+	file, err := os.Open(localPath)
+	if err != nil {
+		logCtx.Warn("Image extraction failed; page will be translated as text only.", "pageNumber", pageNumber, "error", err)
+		return nil
+	}
+	defer file.Close()
+	images, skipped, err := extractPageImages(file)
+	if err != nil {
+		logCtx.Warn("Image extraction failed; page will be translated as text only.", "pageNumber", pageNumber, "error", err)
+		return nil
+	}
+	if skipped > 0 {
+		logCtx.Warn("Skipped page images that could not be decoded.", "pageNumber", pageNumber, "skippedImages", skipped)
+	}
+
+	objects, err := storePageImages(ctx, f.store.Bucket(f.config.ImagesBucket), root, pageNumber, images)
+	if err != nil {
+		logCtx.Warn("Failed to upload page images; page will be translated as text only.", "pageNumber", pageNumber, "error", err)
+		return nil
+	}
+
+	if len(images) > 0 {
+		logCtx.Info("Extracted page images.", "pageNumber", pageNumber, "imageCount", len(images))
+	}
+	return imageObjectURIs(f.config.ImagesBucket, objects)
+}
+
+// imageObjectURIs returns the gs:// URIs of image objects in bucket.
+func imageObjectURIs(bucket string, objects []string) []string {
+	if len(objects) == 0 {
+		return nil
+	}
+	uris := make([]string, len(objects))
+	for i, object := range objects {
+		uris[i] = fmt.Sprintf("gs://%s/%s", bucket, object)
+	}
+	return uris
+}
+
+// imageObjectNames returns the names of the objects of image URIs recorded on a page
+// record. URIs outside bucket, which links cannot be built for, are dropped.
+func imageObjectNames(bucket string, uris []string) []string {
+	var objects []string
+	for _, uri := range uris {
+		uriBucket, object, err := gcp.ParseGCSURI(uri)
+		if err != nil || uriBucket != bucket {
+			continue
+		}
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+// imageLinks builds the link targets for the images of a page of the document under root.
+// Relative links are resolved from the page markdown object's directory; signed links are
+// short-lived HTTPS URLs.
+func imageLinks(bucket *storage.BucketHandle, root string, objectNames []string, mode string, ttl time.Duration) ([]string, error) {
+	links := make([]string, len(objectNames))
+	for i, name := range objectNames {
+		switch mode {
+		case ImageLinkModeSigned:
+			url, err := bucket.SignedURL(name, &storage.SignedURLOptions{
+				Method:  "GET",
+				Expires: time.Now().Add(ttl),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to sign URL for %s: %w", name, err)
+			}
+			links[i] = url
+		default:
+			links[i] = strings.TrimPrefix(name, path.Clean(root)+"/")
+		}
+	}
+	return links, nil
+}
+
+// imageReferenceRegex matches the placeholders the translator is instructed to emit,
+// e.g. "![Pump assembly cross-section (see image 2)]".
+var imageReferenceRegex = regexp.MustCompile(`!\[([^\]]*?)\s*\(see image (\d+)\)\]`)
+
+// rewriteImageReferences turns "(see image N)" placeholders into markdown image links.
+// References to indices that do not exist are left untouched.
+func rewriteImageReferences(markdown string, links []string) string {
+	return imageReferenceRegex.ReplaceAllStringFunc(markdown, func(ref string) string {
+		match := imageReferenceRegex.FindStringSubmatch(ref)
+		index, err := strconv.Atoi(match[2])
+		if err != nil || index < 1 || index > len(links) {
+			return ref
+		}
+		return fmt.Sprintf("![%s](%s)", strings.TrimSpace(match[1]), links[index-1])
+	})
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestRewriteImageReferences(t *testing.T) {
+	links := []string{"pages/3/img_1.png", "pages/3/img_2.jpg"}
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{
+			name:     "single reference",
+			markdown: "Intro\n\n![Pump cross-section (see image 2)]\n",
+			want:     "Intro\n\n![Pump cross-section](pages/3/img_2.jpg)\n",
+		},
+		{
+			name:     "several references",
+			markdown: "![A (see image 1)] and ![B (see image 2)]",
+			want:     "![A](pages/3/img_1.png) and ![B](pages/3/img_2.jpg)",
+		},
+		{
+			name:     "index out of range is left alone",
+			markdown: "![Missing (see image 3)]",
+			want:     "![Missing (see image 3)]",
+		},
+		{
+			name:     "zero index is left alone",
+			markdown: "![Zero (see image 0)]",
+			want:     "![Zero (see image 0)]",
+		},
+		{
+			name:     "plain image links are untouched",
+			markdown: "![Logo](logo.png)",
+			want:     "![Logo](logo.png)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriteImageReferences(tt.markdown, links); got != tt.want {
+				t.Errorf("rewriteImageReferences() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageImageObjectName(t *testing.T) {
+	if got, want := pageImageObjectName("tenant/doc", 7, 2, "png"), "tenant/doc/pages/7/img_2.png"; got != want {
+		t.Errorf("pageImageObjectName() = %q, want %q", got, want)
+	}
+}
+
+func TestImageURIBookkeeping(t *testing.T) {
+	objects := []string{"doc/pages/1/img_1.png", "doc/pages/1/img_2.jpg"}
+	uris := imageObjectURIs("images", objects)
+	want := []string{"gs://images/doc/pages/1/img_1.png", "gs://images/doc/pages/1/img_2.jpg"}
+	if !reflect.DeepEqual(uris, want) {
+		t.Fatalf("imageObjectURIs() = %v, want %v", uris, want)
+	}
+	if got := imageObjectNames("images", uris); !reflect.DeepEqual(got, objects) {
+		t.Errorf("imageObjectNames() = %v, want %v", got, objects)
+	}
+	if got := imageObjectURIs("images", nil); got != nil {
+		t.Errorf("imageObjectURIs(nil) = %v, want nil", got)
+	}
+
+	recorded := []string{"gs://other/doc/pages/1/img_1.png", "not a uri", "gs://images/doc/pages/1/img_3.gif"}
+	if got, want := imageObjectNames("images", recorded), []string{"doc/pages/1/img_3.gif"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("imageObjectNames() = %v, want %v", got, want)
+	}
+}
+
+func TestImageLinksRelative(t *testing.T) {
+	links, err := imageLinks(nil, "tenant/doc", []string{"tenant/doc/pages/4/img_1.png"}, ImageLinkModeRelative, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"pages/4/img_1.png"}; !reflect.DeepEqual(links, want) {
+		t.Errorf("imageLinks() = %v, want %v", links, want)
+	}
+}
+
+func TestExtractPageImagesWithoutImages(t *testing.T) {
+	images, skipped, err := extractPageImages(bytes.NewReader(testsupport.FixturePDF(1)))
+	if err != nil {
+		t.Fatalf("extractPageImages() error = %v", err)
+	}
+	if len(images) != 0 || skipped != 0 {
+		t.Errorf("extractPageImages() = %d images, %d skipped, want none", len(images), skipped)
+	}
+}
+
+func TestExtractPageImagesInvalidPDF(t *testing.T) {
+	if _, _, err := extractPageImages(bytes.NewReader([]byte("not a pdf"))); err == nil {
+		t.Error("extractPageImages() of an invalid PDF succeeded, want an error")
+	}
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"golang.org/x/sync/errgroup"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// ErrDocumentNotComplete is returned when intermediates are cleaned up for a document
+// that has not reached StatusComplete.
+var ErrDocumentNotComplete = errors.New("document is not complete")
+
+// Keys of Document.DeletedObjectCounts, one per intermediate bucket.
+const (
+	intermediateSplitPages         = "splitPages"
+	intermediateTranslatedMarkdown = "translatedMarkdown"
+	intermediateAggregatedMarkdown = "aggregatedMarkdown"
+)
+
+// intermediateClasses are the artifact classes deleted once a document completes, keyed
+// by class to the intermediate bucket they are counted under. Page tables and images, the
+// diffs and the audit records are outputs, not intermediates.
+var intermediateClasses = map[string]string{
+	ArtifactClassPagePDF:      intermediateSplitPages,
+	ArtifactClassPageManifest: intermediateSplitPages,
+	ArtifactClassPageMarkdown: intermediateTranslatedMarkdown,
+	ArtifactClassMaster:       intermediateAggregatedMarkdown,
+	ArtifactClassMasterJSONL:  intermediateAggregatedMarkdown,
+	ArtifactClassPreview:      intermediateAggregatedMarkdown,
+}
+
+// intermediateLocations returns the locations of the intermediates of doc deleted once
+// it completes. A location in a bucket that also holds the cleaned markdown or final
+// sections is left out, so those outputs are never touched whatever the bucket
+// configuration.
+func intermediateLocations(logCtx *slog.Logger, buckets ArtifactBuckets, names *OutputNames, doc OutputDocument) ([]ArtifactLocation, error) {
+	all, err := buckets.Locations(names, doc)
+	if err != nil {
+		return nil, err
+	}
+	var locations []ArtifactLocation
+	for _, loc := range all {
+		if _, ok := intermediateClasses[loc.Class]; !ok {
+			continue
+		}
+		if loc.Bucket == buckets.CleanedMarkdown || loc.Bucket == buckets.FinalSections {
+			logCtx.Warn("Not cleaning up intermediates in a bucket that also holds final outputs.", "class", loc.Class, "bucket", loc.Bucket)
+			continue
+		}
+		locations = append(locations, loc)
+	}
+	return locations, nil
+}
+
+// cleanupIntermediates deletes the document's split pages, translated markdown and
+// aggregated markdown, as named by names, and adds the number of objects deleted from
+// each bucket to the document's deletedObjectCounts. It refuses to run unless the
+// document is COMPLETE. Objects already deleted by an earlier attempt are skipped, so a
+// retry finishes the job.
+func cleanupIntermediates(ctx context.Context, logCtx *slog.Logger, client *firestore.Client, collection string, store objectstore.Client, buckets ArtifactBuckets, names *OutputNames, docID string) (map[string]int, error) {
+	docRef := client.Collection(collection).Doc(docID)
+	snap, err := docRef.Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document %s: %w", docID, err)
+	}
+	var doc models.Document
+	if err := snap.DataTo(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode document %s: %w", docID, err)
+	}
+	if doc.Status != StatusComplete {
+		return nil, fmt.Errorf("%w: %s has status %q", ErrDocumentNotComplete, docID, doc.Status)
+	}
+	outputDoc, err := names.DocumentFor(docID, &doc)
+	if err != nil {
+		return nil, err
+	}
+	locations, err := intermediateLocations(logCtx, buckets, names, outputDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	var errs []error
+	for _, loc := range locations {
+		key := intermediateClasses[loc.Class]
+		deleted, err := deleteLocation(ctx, logCtx, store.Bucket(loc.Bucket), loc)
+		counts[key] += deleted
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", loc.Class, err))
+		}
+	}
+
+	var updates []firestore.Update
+	for key, deleted := range counts {
+		updates = append(updates, firestore.Update{Path: "deletedObjectCounts." + key, Value: firestore.Increment(deleted)})
+	}
+	if len(updates) > 0 {
+		if _, err := docRef.Update(ctx, updates); err != nil {
+			errs = append(errs, fmt.Errorf("failed to record deleted object counts: %w", err))
+		}
+	}
+	return counts, errors.Join(errs...)
+}
+
+// deletePrefix deletes every object under prefix and returns how many it deleted.
+func deletePrefix(ctx context.Context, logCtx *slog.Logger, bucket objectstore.Bucket, prefix string) (int, error) {
+	return deleteLocation(ctx, logCtx, bucket, ArtifactLocation{Bucket: bucket.Name(), Prefix: prefix})
+}
+
+// deleteLocation deletes every object of loc from bucket and returns how many it deleted.
+// Objects that disappear before they are deleted are not counted and are not an error.
+func deleteLocation(ctx context.Context, logCtx *slog.Logger, bucket objectstore.Bucket, loc ArtifactLocation) (int, error) {
+	objects, err := locationObjects(ctx, bucket, loc)
+	if err != nil {
+		return 0, err
+	}
+	var deleted atomic.Int64
+	eg, gctx := errgroup.WithContext(ctx)
+	eg.SetLimit(16)
+	for _, attrs := range objects {
+		name := attrs.Name
+		eg.Go(func() error {
+			err := bucket.Delete(gctx, name, 0)
+			if errors.Is(err, objectstore.ErrObjectNotExist) {
+				return nil
+			}
+			if err != nil {
+				logCtx.Error("Failed to delete derived object", "error", err, "bucket", bucket.Name(), "gcsObject", name)
+				return fmt.Errorf("failed to delete %s: %w", name, err)
+			}
+			deleted.Add(1)
+			return nil
+		})
+	}
+	err = eg.Wait()
+	return int(deleted.Load()), err
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Custom metadata keys uploaders set to give the document's languages, sent as the
+// x-goog-meta-source-language and x-goog-meta-target-language headers.
+const (
+	sourceLanguageMetadataKey = "source-language"
+	targetLanguageMetadataKey = "target-language"
+)
+
+// languagesFromMetadata returns the source and target language hints of an upload. An
+// invalid hint is logged and dropped rather than failing the upload.
+func languagesFromMetadata(logCtx *slog.Logger, metadata map[string]string) (string, string) {
+	hint := func(key string) string {
+		value := strings.TrimSpace(metadata[key])
+		if err := models.ValidateLanguage(key, value); err != nil {
+			logCtx.Warn("Ignoring invalid language metadata.", "error", err)
+			return ""
+		}
+		return value
+	}
+	return hint(sourceLanguageMetadataKey), hint(targetLanguageMetadataKey)
+}
+
+// translatorLanguagePrompt returns the language instructions for the translator prompt,
+// or "" when there are no hints. A target equal to the source keeps the source language.
+func translatorLanguagePrompt(source, target string) string {
+	var lines []string
+	if source != "" {
+		lines = append(lines, fmt.Sprintf(gcp.TranslatorSourceLanguagePromptFormat, source))
+	}
+	switch {
+	case target == "":
+	case strings.EqualFold(target, source):
+		lines = append(lines, gcp.TranslatorKeepLanguagePrompt)
+	default:
+		lines = append(lines, fmt.Sprintf(gcp.TranslatorTargetLanguagePromptFormat, target))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// markdownLanguage returns the language the translated markdown is in: the target
+// language if one was given, otherwise the source language, or "" if neither is known.
+func markdownLanguage(source, target string) string {
+	if target != "" {
+		return target
+	}
+	return source
+}
+
+// cleanerPrompt returns the cleaner's user prompt, telling it the markdown's language
+// when it is known.
+func cleanerPrompt(language string) string {
+	if language == "" {
+		return gcp.CleanerUserPrompt
+	}
+	return gcp.CleanerUserPrompt + "\n\n" + fmt.Sprintf(gcp.CleanerLanguagePromptFormat, language)
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/markdown"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Objects written by a local run under the document's prefix, next to its sections.
+// Sanitized section names never start with an underscore, so these cannot collide.
+const (
+	localPagesDir    = "_pages"
+	localMasterName  = "_master.md"
+	localCleanedName = "_cleaned.md"
+)
+
+// LocalFlowOptions configures RunLocalFlow.
+type LocalFlowOptions struct {
+	PDFPath string
+	// Store and Bucket receive every output, under a prefix named by the PDF's hash.
+	Store  objectstore.Client
+	Bucket string
+	// StartPage and EndPage limit translation to a range of pages; zero means the first
+	// and last page respectively.
+	StartPage int
+	EndPage   int
+	// The models each stage calls. Their system prompts must already be set, as the
+	// models of a gcp.VertexClient are.
+	Translator      gcp.ContentGenerator
+	Cleaner         gcp.ContentGenerator
+	SectionSplitter gcp.ContentGenerator
+}
+
+// LocalFlowResult describes the outputs of a local run.
+type LocalFlowResult struct {
+	DocumentID   string             `json:"documentId"`
+	PageCount    int                `json:"pageCount"` // Pages in the PDF.
+	StartPage    int                `json:"startPage"`
+	EndPage      int                `json:"endPage"`
+	Status       string             `json:"status"` // The section splitter's status.
+	SectionCount int                `json:"sectionCount"`
+	Coverage     float64            `json:"coverage"`
+	Usage        *models.TokenUsage `json:"usage,omitempty"`
+}
+
+// RunLocalFlow runs the whole pipeline over a PDF on the local filesystem, in process and
+// without Firestore: it splits the PDF as the splitter does, translates each page in
+// range, joins the pages with page anchors, cleans the result and splits it into
+// sections. Configuration that the functions read from the environment, such as retry,
+// chunking and section layout settings, is read the same way. Outputs of an earlier run
+// of the same PDF are removed first, since every write skips existing objects.
+func RunLocalFlow(ctx context.Context, opts LocalFlowOptions) (*LocalFlowResult, error) {
+	rejection, err := precheckPDF(opts.PDFPath)
+	if err != nil {
+		return nil, err
+	}
+	if rejection != nil {
+		return nil, fmt.Errorf("%s: %s", rejection.Status, rejection.Details)
+	}
+	docID, err := calculateFileHash(opts.PDFPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash %s: %w", opts.PDFPath, err)
+	}
+	logCtx := slog.With("documentId", docID, "pdfPath", opts.PDFPath)
+	bucket := opts.Store.Bucket(opts.Bucket)
+	if _, err := deletePrefix(ctx, logCtx, bucket, docID+"/"); err != nil {
+		return nil, fmt.Errorf("failed to remove earlier outputs: %w", err)
+	}
+
+	// --- 1. Optimize and split the PDF into single pages ---
+	workDir, err := os.MkdirTemp("", "localflow-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+	optimized := filepath.Join(workDir, "optimized.pdf")
+	if err := optimizePDF(opts.PDFPath, optimized); err != nil {
+		return nil, fmt.Errorf("failed to validate/optimize PDF: %w", err)
+	}
+	pageCount, err := api.PageCountFile(optimized)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get page count: %w", err)
+	}
+	startPage, endPage := opts.StartPage, opts.EndPage
+	if startPage == 0 {
+		startPage = 1
+	}
+	if endPage == 0 {
+		endPage = pageCount
+	}
+	if startPage < 1 || endPage < startPage || endPage > pageCount {
+		return nil, fmt.Errorf("invalid page range %d-%d for a %d page document", startPage, endPage, pageCount)
+	}
+	if err := api.SplitFile(optimized, workDir, 1, nil); err != nil {
+		return nil, fmt.Errorf("failed to split PDF: %w", err)
+	}
+	logCtx.Info("PDF optimized and split locally.", "pageCount", pageCount, "startPage", startPage, "endPage", endPage)
+
+	// --- 2. Translate each page in range ---
+	translator, err := newLocalTranslator(opts.Translator)
+	if err != nil {
+		return nil, err
+	}
+	normalizePages, err := loadNormalizePages()
+	if err != nil {
+		return nil, err
+	}
+	splitFileBase := strings.TrimSuffix(optimized, filepath.Ext(optimized))
+	var master strings.Builder
+	var previousH1 string
+	var usage *models.TokenUsage
+	for page := startPage; page <= endPage; page++ {
+		pageMarkdown, pageUsage, err := translator.translateLocalPage(ctx, logCtx.With("pageNumber", page), localSplitPath(splitFileBase, pageChunk{StartPage: page, EndPage: page}), page)
+		if err != nil {
+			return nil, err
+		}
+		usage = addTokenUsage(usage, pageUsage)
+		if err := gcp.SaveToGCSAtomically(ctx, logCtx, bucket, fmt.Sprintf("%s/%s/%05d.md", docID, localPagesDir, page), pageMarkdown); err != nil {
+			return nil, err
+		}
+		if normalizePages {
+			pageMarkdown, previousH1 = markdown.NormalizePage(pageMarkdown, previousH1)
+		}
+		if page > startPage {
+			master.WriteString("\n\n")
+		}
+		master.WriteString(pageAnchor(page, page) + "\n\n" + pageMarkdown)
+	}
+	if err := gcp.SaveToGCSAtomically(ctx, logCtx, bucket, docID+"/"+localMasterName, master.String()); err != nil {
+		return nil, err
+	}
+
+	// --- 3. Clean the joined pages as CLEANER_MODE says, in chunks for large documents ---
+	cleaner := &CleanerFunction{model: opts.Cleaner}
+	if err := cleaner.config.loadChunking(); err != nil {
+		return nil, err
+	}
+	if err := cleaner.config.loadMode(); err != nil {
+		return nil, err
+	}
+	if err := cleaner.config.loadReport(); err != nil {
+		return nil, err
+	}
+	toClean := master.String()
+	if cleaner.config.Mode != CleanerModeLLM {
+		toClean = cleaner.cleanDeterministically(toClean)
+	}
+	var cleaned string
+	var cleanUsage *models.TokenUsage
+	switch {
+	case cleaner.config.Mode == CleanerModeDeterministic:
+		cleaned = toClean
+	case int64(len(toClean)) > cleaner.config.ChunkThresholdBytes:
+		cleaned, cleanUsage, _, err = cleaner.cleanInChunks(ctx, logCtx, bucket, docID, docID, toClean, gcp.CleanerUserPrompt)
+	default:
+		cleaned, cleanUsage, err = cleaner.cleanPart(ctx, logCtx, genai.Text(toClean), gcp.CleanerUserPrompt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	usage = addTokenUsage(usage, cleanUsage)
+	if strings.TrimSpace(cleaned) == "" {
+		return nil, fmt.Errorf("%w: cleaner returned no content", ErrEmptyMarkdown)
+	}
+	report, err := cleaner.compareCleaned(docID, master.String(), cleaned)
+	if cleaner.config.WriteReport {
+		saveCleanReport(ctx, logCtx, bucket, docID, report)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := gcp.SaveToGCSAtomically(ctx, logCtx, bucket, docID+"/"+localCleanedName, cleaned); err != nil {
+		return nil, err
+	}
+
+	// --- 4. Split the cleaned markdown into sections ---
+	splitter, err := newLocalSectionSplitter(opts.SectionSplitter)
+	if err != nil {
+		return nil, err
+	}
+	split, err := splitter.generateSections(ctx, logCtx, docID, genai.Text(cleaned), cleaned)
+	if err != nil {
+		return nil, err
+	}
+	usage = addTokenUsage(usage, split.Usage)
+	status, coverage := split.Status, split.Coverage
+	savedCount, _, _, err := splitter.saveSections(ctx, logCtx, bucket, docID, "", filepath.Base(opts.PDFPath), split.Sections)
+	if err != nil {
+		return nil, err
+	}
+	logCtx.Info("Local flow complete.", "sectionCount", savedCount, "status", status, "coverage", coverage)
+
+	return &LocalFlowResult{
+		DocumentID:   docID,
+		PageCount:    pageCount,
+		StartPage:    startPage,
+		EndPage:      endPage,
+		Status:       status,
+		SectionCount: savedCount,
+		Coverage:     coverage,
+		Usage:        usage,
+	}, nil
+}
+
+// newLocalTranslator returns a translator that calls model, configured from the
+// environment like the page-translator function.
+func newLocalTranslator(model gcp.ContentGenerator) (*TranslatorFunction, error) {
+	retry, err := loadGeminiRetryConfig()
+	if err != nil {
+		return nil, err
+	}
+	maxContinuations, err := loadMaxContinuations()
+	if err != nil {
+		return nil, err
+	}
+	quality, err := loadTranslationQualityConfig()
+	if err != nil {
+		return nil, err
+	}
+	output, err := loadTranslationOutputConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &TranslatorFunction{
+		profileModels: map[string]gcp.ContentGenerator{gcp.PromptProfileDefault: model},
+		config:        TranslatorConfig{Retry: retry, MaxContinuations: maxContinuations, Quality: quality, Output: output},
+	}, nil
+}
+
+// translateLocalPage translates the single-page PDF at path, sending it inline. A page
+// whose output is filtered gets the failure placeholder, as with allowFailure.
+func (f *TranslatorFunction) translateLocalPage(ctx context.Context, logCtx *slog.Logger, path string, page int) (string, *models.TokenUsage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read split page %d: %w", page, err)
+	}
+	model := f.profileModels[gcp.PromptProfileDefault]
+	prompt := genai.Text(gcp.TranslatorPromptProfiles[gcp.PromptProfileDefault].User)
+	input := genai.Blob{MIMEType: "application/pdf", Data: data}
+	resp, attempts, truncated, err := f.generateTranslation(ctx, logCtx, model, input, prompt, describePages(page, page))
+	var filtered *FilteredResponseError
+	if errors.As(err, &filtered) {
+		return failedPagePlaceholder(page, page, err.Error()), nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	markdown, err := extractMarkdown(logCtx, resp)
+	if errors.As(err, &filtered) {
+		return failedPagePlaceholder(page, page, err.Error()), nil, nil
+	}
+	markdown = f.tidyOutput(logCtx, markdown)
+	confidence, flags := assessTranslation(markdown, int64(len(data)), truncated, f.config.Quality)
+	logCtx.Info("Page translated.", "attempts", attempts, "confidence", confidence, "flags", flags)
+	return markdown, tokenUsageFrom(resp), nil
+}
+
+// newLocalSectionSplitter returns a section splitter that calls model, configured from
+// the environment like the section-splitter function.
+func newLocalSectionSplitter(model gcp.ContentGenerator) (*SectionSplitterFunction, error) {
+	minCoverage, err := loadSectionMinCoverage()
+	if err != nil {
+		return nil, err
+	}
+	layout, err := loadSectionLayout()
+	if err != nil {
+		return nil, err
+	}
+	frontMatter, err := loadFrontMatter()
+	if err != nil {
+		return nil, err
+	}
+	windows, err := loadSectionWindowConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &SectionSplitterFunction{
+		model:  model,
+		config: SectionSplitterConfig{MinCoverage: minCoverage, Layout: layout, FrontMatter: frontMatter, Windows: windows},
+	}, nil
+}
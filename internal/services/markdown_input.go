@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// ErrEmptyMarkdown is returned when a stage is handed a markdown file with no content.
+// It is raised before the model is called, since there is nothing for it to work on.
+var ErrEmptyMarkdown = errors.New("markdown file is empty")
+
+// Markdown input modes. Inline downloads the file and sends its text; file data passes
+// the GCS URI to Vertex AI, which has rejected or mis-read text/markdown files before.
+const (
+	MarkdownInputInline   = "inline"
+	MarkdownInputFileData = "file_data"
+)
+
+// loadMarkdownInputMode reads MARKDOWN_INPUT_MODE, shared by the cleaner and the section
+// splitter.
+func loadMarkdownInputMode() (string, error) {
+	mode := gcp.GetEnv("MARKDOWN_INPUT_MODE", MarkdownInputInline)
+	if mode != MarkdownInputInline && mode != MarkdownInputFileData {
+		return "", fmt.Errorf("MARKDOWN_INPUT_MODE must be %q or %q, got %q", MarkdownInputInline, MarkdownInputFileData, mode)
+	}
+	return mode, nil
+}
+
+// readMarkdown downloads the markdown object referenced by gcsURI.
+func readMarkdown(ctx context.Context, storageClient *storage.Client, gcsURI string) (markdown string, err error) {
+	ctx, span := startSpan(ctx, "gcs.Download", attrGCSURI.String(gcsURI))
+	defer func() {
+		span.SetAttributes(attrBytes.Int(len(markdown)))
+		endSpan(span, err)
+	}()
+	bucket, object, err := gcp.ParseGCSURI(gcsURI)
+	if err != nil {
+		return "", err
+	}
+	reader, err := storageClient.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", gcsURI, err)
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", gcsURI, err)
+	}
+	return string(content), nil
+}
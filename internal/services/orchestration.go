@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// runsSubcollection is the name of the subcollection of a document's PipelineRun records.
+const runsSubcollection = "runs"
+
+// errNotPubSubMode is returned for a Pub/Sub message delivered to a service that is not
+// configured for the Pub/Sub orchestration mode. The message is redelivered, so that it
+// is processed once the service is configured.
+var errNotPubSubMode = errors.New("ORCHESTRATION_MODE is not pubsub")
+
+// OrchestrationConfig is how the pipeline's stages are chained. The topics are those of
+// the Pub/Sub mode; each service requires only the ones it publishes to.
+type OrchestrationConfig struct {
+	Mode              string // One of the models.OrchestrationMode* values.
+	TranslateTopic    string // Carries a PageTranslatorRequest per page or chunk.
+	AggregateTopic    string // Carries MarkdownAggregatorRequests.
+	CleanTopic        string // Carries MarkdownCleanerRequests.
+	SectionSplitTopic string // Carries SectionSplitterRequests.
+	CoordinatorTopic  string // Carries the stages' StageCompletedEvents.
+	// AllowPageFailure sets AllowFailure on the splitter's translator requests, so that a
+	// page that fails for good is replaced by a placeholder rather than stalling the run.
+	AllowPageFailure bool
+}
+
+// loadOrchestrationConfig reads ORCHESTRATION_MODE, the topics of the Pub/Sub mode and
+// PUBSUB_ALLOW_PAGE_FAILURE.
+func loadOrchestrationConfig() (OrchestrationConfig, error) {
+	config := OrchestrationConfig{
+		Mode:              gcp.GetEnv("ORCHESTRATION_MODE", models.OrchestrationModeWorkflows),
+		TranslateTopic:    gcp.GetEnv("TRANSLATE_TOPIC", ""),
+		AggregateTopic:    gcp.GetEnv("AGGREGATE_TOPIC", ""),
+		CleanTopic:        gcp.GetEnv("CLEAN_TOPIC", ""),
+		SectionSplitTopic: gcp.GetEnv("SECTION_SPLIT_TOPIC", ""),
+		CoordinatorTopic:  gcp.GetEnv("COORDINATOR_TOPIC", ""),
+	}
+	switch config.Mode {
+	case models.OrchestrationModeWorkflows, models.OrchestrationModePubSub:
+	default:
+		return config, fmt.Errorf("ORCHESTRATION_MODE must be %q or %q", models.OrchestrationModeWorkflows, models.OrchestrationModePubSub)
+	}
+	allowPageFailure, err := strconv.ParseBool(gcp.GetEnv("PUBSUB_ALLOW_PAGE_FAILURE", "true"))
+	if err != nil {
+		return config, fmt.Errorf("PUBSUB_ALLOW_PAGE_FAILURE must be a boolean")
+	}
+	config.AllowPageFailure = allowPageFailure
+	return config, nil
+}
+
+// PubSub reports whether the stages are chained through Pub/Sub.
+func (c OrchestrationConfig) PubSub() bool {
+	return c.Mode == models.OrchestrationModePubSub
+}
+
+// runRef returns the PipelineRun record of runID for a document.
+func runRef(client *firestore.Client, collection, docID, runID string) *firestore.DocumentRef {
+	return client.Collection(collection).Doc(docID).Collection(runsSubcollection).Doc(runID)
+}
+
+// pubSubTopics opens the named topics of a new Pub/Sub client. The returned closer stops
+// them and closes the client.
+func pubSubTopics(ctx context.Context, projectID string, names ...string) ([]*pubsub.Topic, closerFunc, error) {
+	client, err := gcp.NewPubSubClient(ctx, projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+	topics := make([]*pubsub.Topic, len(names))
+	for i, name := range names {
+		topics[i] = client.Topic(name)
+	}
+	return topics, func() error {
+		for _, topic := range topics {
+			topic.Stop()
+		}
+		return client.Close()
+	}, nil
+}
+
+// stageReporter reports the outcome of each request a stage receives through Pub/Sub to
+// the pipeline coordinator.
+type stageReporter struct {
+	topic *pubsub.Topic
+	closerFunc
+}
+
+// newStageReporter returns the reporter of a stage in the Pub/Sub mode, or nil in the
+// Cloud Workflows mode, where the workflow learns each stage's outcome from its response.
+func newStageReporter(ctx context.Context, projectID string) (*stageReporter, error) {
+	config, err := loadOrchestrationConfig()
+	if err != nil || !config.PubSub() {
+		return nil, err
+	}
+	if config.CoordinatorTopic == "" {
+		return nil, fmt.Errorf("COORDINATOR_TOPIC must be set when ORCHESTRATION_MODE is pubsub")
+	}
+	topics, closeTopics, err := pubSubTopics(ctx, projectID, config.CoordinatorTopic)
+	if err != nil {
+		return nil, err
+	}
+	return &stageReporter{topic: topics[0], closerFunc: closeTopics}, nil
+}
+
+// withReporter returns clients with reporter added, if there is one.
+func (c serviceClients) withReporter(reporter *stageReporter) serviceClients {
+	if reporter == nil {
+		return c
+	}
+	return append(c, reporter)
+}
+
+// decodeStageMessage decodes the request carried by a Pub/Sub event into req and
+// validates it. A message that fails either can never be processed; it is logged, and the
+// caller acknowledges it.
+func decodeStageMessage(data []byte, req interface{ Validate() error }) (string, error) {
+	messageID, err := gcp.DecodePubSubMessage(data, req)
+	if err == nil {
+		err = req.Validate()
+	}
+	if err != nil {
+		slog.Error("Dropping a stage request that cannot be processed", "error", err, "messageId", messageID)
+	}
+	return messageID, err
+}
+
+// finish reports the outcome of a request delivered by Pub/Sub and returns the function's
+// result: nil acknowledges the message, and an error has Pub/Sub redeliver it. A request
+// that failed with a retryable error is redelivered unreported. Any other outcome is
+// reported, and the message redelivered only if the report cannot be published. Stages
+// are safe to run again, as they are when a workflow retries them.
+func (r *stageReporter) finish(ctx context.Context, logCtx *slog.Logger, event models.StageCompletedEvent, processErr error) error {
+	if processErr != nil {
+		if models.CodeOf(processErr).Retryable() {
+			return processErr
+		}
+		logCtx.Error("Stage failed with an error a retry cannot fix; reporting it to the coordinator.", "error", processErr)
+		event.Status, event.Error = models.StageCompletedStatusFailed, processErr.Error()
+	}
+	messageID, err := gcp.PublishJSON(ctx, r.topic, event, map[string]string{"documentId": event.DocumentID, "stage": event.Stage})
+	if err != nil {
+		logCtx.Error("Failed to report stage completion; the request will be redelivered.", "error", err)
+		return err
+	}
+	logCtx.Info("Reported stage completion to the coordinator.", "messageId", messageID, "status", event.Status)
+	return nil
+}
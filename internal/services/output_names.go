@@ -0,0 +1,466 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Default output name templates, which give the names the pipeline has always used:
+// "{docId}/00012.md" or "{docId}/00011-00015.md" for translated pages,
+// "{docId}/master.md" for master files and "{docId}/{path}.md" for sections. Whatever the
+// template, the names of a document with a tenant are put under "{tenantId}/".
+const (
+	defaultPageTemplate    = `{{.DocID}}/{{pad .StartPage 5}}{{if ne .StartPage .EndPage}}-{{pad .EndPage 5}}{{end}}.md`
+	defaultMasterTemplate  = `{{.DocID}}/master.md`
+	defaultSectionTemplate = `{{.DocID}}/{{.Path}}.md`
+)
+
+// errInvalidObjectName is returned when a template resolves to a name that is empty,
+// absolute or steps outside its directory.
+var errInvalidObjectName = errors.New("invalid object name")
+
+// outputNameFuncs are the only functions output name templates may call besides the
+// text/template builtins.
+var outputNameFuncs = template.FuncMap{
+	"pad":   func(n, width int) string { return fmt.Sprintf("%0*d", width, n) },
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// OutputDocument is what output name templates know about a document. OriginalFilename
+// is the base name of the upload without its extension and FilenameSlug its slug, such
+// as "quarterly-report"; they are only looked up when a template uses them. TenantID is
+// not for templates: it prefixes the names they render.
+type OutputDocument struct {
+	DocID            string
+	OriginalFilename string
+	FilenameSlug     string
+	TenantID         string
+}
+
+// pageNameData is the data of OUTPUT_PAGE_TEMPLATE. Page is StartPage, for templates of
+// single pages.
+type pageNameData struct {
+	OutputDocument
+	Page      int
+	StartPage int
+	EndPage   int
+}
+
+// masterNameData is the data of OUTPUT_MASTER_TEMPLATE.
+type masterNameData struct {
+	OutputDocument
+}
+
+// sectionNameData is the data of OUTPUT_SECTION_TEMPLATE. Path is the section's
+// sanitized title, under its ancestors' in the nested layout and made unique within the
+// document, Title its sanitized title alone and Order its position from 1.
+type sectionNameData struct {
+	OutputDocument
+	Path  string
+	Title string
+	Order int
+}
+
+// OutputNames renders the object names of translated pages, master files and sections.
+// A nil *OutputNames renders the default names.
+type OutputNames struct {
+	page    *template.Template
+	master  *template.Template
+	section *template.Template
+	// usesFilename is set when a template needs the document's original filename.
+	usesFilename bool
+}
+
+// defaultOutputNames renders the names of a nil *OutputNames.
+var defaultOutputNames *OutputNames
+
+func init() {
+	defaultOutputNames = mustOutputNames(defaultPageTemplate, defaultMasterTemplate, defaultSectionTemplate)
+}
+
+// loadOutputNames reads OUTPUT_PAGE_TEMPLATE, OUTPUT_MASTER_TEMPLATE and
+// OUTPUT_SECTION_TEMPLATE.
+func loadOutputNames() (*OutputNames, error) {
+	return newOutputNames(
+		gcp.GetEnv("OUTPUT_PAGE_TEMPLATE", defaultPageTemplate),
+		gcp.GetEnv("OUTPUT_MASTER_TEMPLATE", defaultMasterTemplate),
+		gcp.GetEnv("OUTPUT_SECTION_TEMPLATE", defaultSectionTemplate),
+	)
+}
+
+// mustOutputNames is newOutputNames for templates known to be valid.
+func mustOutputNames(page, master, section string) *OutputNames {
+	names, err := newOutputNames(page, master, section)
+	if err != nil {
+		panic(err)
+	}
+	return names
+}
+
+// newOutputNames parses the templates and renders each with sample data, so that a
+// template that does not parse, uses a field or function that does not exist, resolves
+// to an invalid name, or gives two pages, sections or documents the same name fails here
+// rather than mid-document.
+func newOutputNames(page, master, section string) (*OutputNames, error) {
+	names := &OutputNames{}
+	var err error
+	if names.page, err = parseOutputTemplate("OUTPUT_PAGE_TEMPLATE", page); err != nil {
+		return nil, err
+	}
+	if names.master, err = parseOutputTemplate("OUTPUT_MASTER_TEMPLATE", master); err != nil {
+		return nil, err
+	}
+	if names.section, err = parseOutputTemplate("OUTPUT_SECTION_TEMPLATE", section); err != nil {
+		return nil, err
+	}
+	names.usesFilename = strings.Contains(page+master+section, "OriginalFilename") ||
+		strings.Contains(page+master+section, "FilenameSlug")
+
+	sample := OutputDocument{DocID: "sample-document", OriginalFilename: "sample-file", FilenameSlug: "sample-file"}
+	first, err := names.Page(sample, 1, 1)
+	if err != nil {
+		return nil, fmt.Errorf("OUTPUT_PAGE_TEMPLATE: %w", err)
+	}
+	second, err := names.Page(sample, 2, 2)
+	if err != nil {
+		return nil, fmt.Errorf("OUTPUT_PAGE_TEMPLATE: %w", err)
+	}
+	chunk, err := names.Page(sample, 1, 2)
+	if err != nil {
+		return nil, fmt.Errorf("OUTPUT_PAGE_TEMPLATE: %w", err)
+	}
+	if first == second || first == chunk || second == chunk {
+		return nil, fmt.Errorf("OUTPUT_PAGE_TEMPLATE must give each page and chunk its own name")
+	}
+	if _, _, err := names.pagePatterns(sample); err != nil {
+		return nil, fmt.Errorf("OUTPUT_PAGE_TEMPLATE: %w", err)
+	}
+	other := OutputDocument{DocID: "other-document", OriginalFilename: "other-file", FilenameSlug: "other-file"}
+	if otherFirst, err := names.Page(other, 1, 1); err != nil || otherFirst == first {
+		return nil, fmt.Errorf("OUTPUT_PAGE_TEMPLATE must give each document its own names")
+	}
+	masterName, err := names.Master(sample)
+	if err != nil {
+		return nil, fmt.Errorf("OUTPUT_MASTER_TEMPLATE: %w", err)
+	}
+	if otherMaster, err := names.Master(other); err != nil || otherMaster == masterName {
+		return nil, fmt.Errorf("OUTPUT_MASTER_TEMPLATE must give each document its own name")
+	}
+	// The cleaner and section splitter only read master files under the document's
+	// prefix; see gcp.ValidateDocumentURI.
+	if !strings.HasPrefix(masterName, sample.DocID+"/") {
+		return nil, fmt.Errorf("OUTPUT_MASTER_TEMPLATE must name master files under {{.DocID}}/")
+	}
+	first, err = names.Section(sample, "1_scope", "1_scope", 1)
+	if err != nil {
+		return nil, fmt.Errorf("OUTPUT_SECTION_TEMPLATE: %w", err)
+	}
+	second, err = names.Section(sample, "2_references", "2_references", 2)
+	if err != nil {
+		return nil, fmt.Errorf("OUTPUT_SECTION_TEMPLATE: %w", err)
+	}
+	if first == second {
+		return nil, fmt.Errorf("OUTPUT_SECTION_TEMPLATE must give each section its own name")
+	}
+	if otherFirst, err := names.Section(other, "1_scope", "1_scope", 1); err != nil || otherFirst == first {
+		return nil, fmt.Errorf("OUTPUT_SECTION_TEMPLATE must give each document its own names")
+	}
+	if _, err := names.SectionMatcher(sample); err != nil {
+		return nil, fmt.Errorf("OUTPUT_SECTION_TEMPLATE: %w", err)
+	}
+	return names, nil
+}
+
+// parseOutputTemplate parses the template in the environment variable name.
+func parseOutputTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(outputNameFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// Page returns the object name of the translation of pages startPage to endPage.
+func (n *OutputNames) Page(doc OutputDocument, startPage, endPage int) (string, error) {
+	return doc.underTenant(renderObjectName(n.or().page, pageNameData{OutputDocument: doc, Page: startPage, StartPage: startPage, EndPage: endPage}))
+}
+
+// Master returns the object name of a document's master file, before and after cleaning.
+func (n *OutputNames) Master(doc OutputDocument) (string, error) {
+	return doc.underTenant(renderObjectName(n.or().master, masterNameData{OutputDocument: doc}))
+}
+
+// Section returns the object name of a section.
+func (n *OutputNames) Section(doc OutputDocument, sectionPath, title string, order int) (string, error) {
+	return doc.underTenant(renderObjectName(n.or().section, sectionNameData{OutputDocument: doc, Path: sectionPath, Title: title, Order: order}))
+}
+
+// underTenant puts a rendered name under the document's tenant, if it has one.
+func (d OutputDocument) underTenant(name string, err error) (string, error) {
+	if err != nil || d.TenantID == "" {
+		return name, err
+	}
+	return d.TenantID + "/" + name, nil
+}
+
+// Document returns what the templates need to know about docID, of tenant tenantID,
+// reading its original filename and slug from Firestore only if a template uses them.
+func (n *OutputNames) Document(ctx context.Context, client *firestore.Client, collection, docID, tenantID string) (OutputDocument, error) {
+	doc := OutputDocument{DocID: docID, TenantID: tenantID}
+	if !n.or().usesFilename {
+		return doc, nil
+	}
+	snap, err := client.Collection(collection).Doc(docID).Get(ctx)
+	if err != nil {
+		return doc, fmt.Errorf("failed to read document %s for its output names: %w", docID, err)
+	}
+	var record models.Document
+	if err := snap.DataTo(&record); err != nil {
+		return doc, fmt.Errorf("failed to decode document %s for its output names: %w", docID, err)
+	}
+	return n.DocumentFor(docID, &record)
+}
+
+// DocumentFor is Document for a caller that has already read the document record.
+// Documents created before slugs were recorded get the slug of their original filename.
+func (n *OutputNames) DocumentFor(docID string, record *models.Document) (OutputDocument, error) {
+	doc := OutputDocument{DocID: docID, TenantID: record.TenantID}
+	if !n.or().usesFilename {
+		return doc, nil
+	}
+	base := path.Base(record.OriginalFilename)
+	doc.OriginalFilename = strings.TrimSuffix(base, path.Ext(base))
+	if record.OriginalFilename == "" || doc.OriginalFilename == "" {
+		return doc, fmt.Errorf("document %s has no original filename for its output names", docID)
+	}
+	doc.FilenameSlug = record.FilenameSlug
+	if doc.FilenameSlug == "" {
+		doc.FilenameSlug = models.NormalizeFilename(record.OriginalFilename).Slug
+	}
+	return doc, nil
+}
+
+// Sentinel values rendered into the templates to find where page numbers and section
+// paths, titles and positions go in their names.
+const (
+	startPageSentinel    = 917364281
+	endPageSentinel      = 917364282
+	sectionPathSentinel  = "917364283"
+	sectionTitleSentinel = "917364284"
+	sectionOrderSentinel = 917364285
+)
+
+// PageMatcher finds translated pages among listed objects. Prefix is the longest fixed
+// start of every page name of the document, to list under.
+type PageMatcher struct {
+	Prefix string
+	single *regexp.Regexp
+	chunk  *regexp.Regexp
+}
+
+// PageMatcher returns the matcher of doc's translated page names.
+func (n *OutputNames) PageMatcher(doc OutputDocument) (*PageMatcher, error) {
+	single, chunk, err := n.or().pagePatterns(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &PageMatcher{Prefix: single.prefix, single: single.re, chunk: chunk.re}, nil
+}
+
+// Match returns the pages covered by objectName, if it is a page name.
+func (m *PageMatcher) Match(objectName string) (int, int, bool) {
+	if startPage, _, ok := matchPages(m.single, objectName); ok {
+		return startPage, startPage, true
+	}
+	if startPage, endPage, ok := matchPages(m.chunk, objectName); ok && endPage > startPage {
+		return startPage, endPage, true
+	}
+	return 0, 0, false
+}
+
+// matchPages returns the start and end page re captures from objectName. A page number
+// may appear more than once in a name, but must be the same each time.
+func matchPages(re *regexp.Regexp, objectName string) (startPage, endPage int, ok bool) {
+	match := re.FindStringSubmatch(objectName)
+	if match == nil {
+		return 0, 0, false
+	}
+	for i, group := range re.SubexpNames()[1:] {
+		page, err := strconv.Atoi(match[i+1])
+		if err != nil || page < 1 {
+			return 0, 0, false
+		}
+		captured := &startPage
+		if group == "end" {
+			captured = &endPage
+		}
+		if *captured != 0 && *captured != page {
+			return 0, 0, false
+		}
+		*captured = page
+	}
+	return startPage, endPage, startPage > 0
+}
+
+// SectionMatcher finds sections among listed objects. Prefix is the longest fixed start
+// of every section name of the document, to list under.
+type SectionMatcher struct {
+	Prefix string
+	re     *regexp.Regexp
+}
+
+// SectionMatcher returns the matcher of doc's section names.
+func (n *OutputNames) SectionMatcher(doc OutputDocument) (*SectionMatcher, error) {
+	name, err := n.Section(doc, sectionPathSentinel, sectionTitleSentinel, sectionOrderSentinel)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := namePattern(name, []nameSentinel{
+		{value: sectionPathSentinel, group: "path", expr: `.+`},
+		{value: sectionTitleSentinel, group: "title", expr: `[^/]+`},
+		{value: strconv.Itoa(sectionOrderSentinel), group: "order", expr: `\d+`},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("section names must include the section's path, title or position")
+	}
+	return &SectionMatcher{Prefix: pattern.prefix, re: pattern.re}, nil
+}
+
+// Match reports whether objectName is a section name.
+func (m *SectionMatcher) Match(objectName string) bool {
+	return m.re.MatchString(objectName)
+}
+
+// pagePattern is a name template as a regular expression, with the fixed text before the
+// first value rendered into it.
+type pagePattern struct {
+	re     *regexp.Regexp
+	prefix string
+}
+
+// nameSentinel is a sentinel value rendered into a template, and the named group that
+// stands for it in the template's pattern.
+type nameSentinel struct {
+	value string
+	group string
+	expr  string
+}
+
+// pagePatterns renders the page template with sentinel page numbers and turns the names
+// of a single page and of a chunk into patterns matching any page numbers.
+func (n *OutputNames) pagePatterns(doc OutputDocument) (single, chunk pagePattern, err error) {
+	singleName, err := n.Page(doc, startPageSentinel, startPageSentinel)
+	if err != nil {
+		return single, chunk, err
+	}
+	chunkName, err := n.Page(doc, startPageSentinel, endPageSentinel)
+	if err != nil {
+		return single, chunk, err
+	}
+	if single, err = pageNamePattern(singleName); err != nil {
+		return single, chunk, err
+	}
+	if chunk, err = pageNamePattern(chunkName); err != nil {
+		return single, chunk, err
+	}
+	if !strings.Contains(chunk.re.String(), "?P<start>") || !strings.Contains(chunk.re.String(), "?P<end>") {
+		return single, chunk, fmt.Errorf("chunk names must include the start and end page")
+	}
+	return single, chunk, nil
+}
+
+// pageNamePattern replaces the sentinel page numbers of name with capturing groups.
+func pageNamePattern(name string) (pagePattern, error) {
+	pattern, err := namePattern(name, []nameSentinel{
+		{value: strconv.Itoa(startPageSentinel), group: "start", expr: `\d+`},
+		{value: strconv.Itoa(endPageSentinel), group: "end", expr: `\d+`},
+	})
+	if err != nil {
+		return pagePattern{}, fmt.Errorf("page names must include the page number")
+	}
+	return pattern, nil
+}
+
+// namePattern replaces the sentinels in name with capturing groups. It fails if name
+// holds none of them.
+func namePattern(name string, sentinels []nameSentinel) (pagePattern, error) {
+	values := make([]string, len(sentinels))
+	for i, sentinel := range sentinels {
+		values[i] = regexp.QuoteMeta(sentinel.value)
+	}
+	locs := regexp.MustCompile(strings.Join(values, "|")).FindAllStringIndex(name, -1)
+	if len(locs) == 0 {
+		return pagePattern{}, fmt.Errorf("no sentinel in %q", name)
+	}
+	var expr strings.Builder
+	expr.WriteString("^")
+	last := 0
+	for _, loc := range locs {
+		expr.WriteString(regexp.QuoteMeta(name[last:loc[0]]))
+		for _, sentinel := range sentinels {
+			if name[loc[0]:loc[1]] == sentinel.value {
+				expr.WriteString("(?P<" + sentinel.group + ">" + sentinel.expr + ")")
+			}
+		}
+		last = loc[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(name[last:]) + "$")
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return pagePattern{}, err
+	}
+	return pagePattern{re: re, prefix: name[:locs[0][0]]}, nil
+}
+
+// or returns n, or the default names if n is nil.
+func (n *OutputNames) or() *OutputNames {
+	if n == nil {
+		return defaultOutputNames
+	}
+	return n
+}
+
+// renderObjectName executes tmpl with data and validates the resulting name.
+func renderObjectName(tmpl *template.Template, data any) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render object name: %w", err)
+	}
+	name := buf.String()
+	if err := validateObjectName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// validateObjectName rejects names that are empty, absolute, have empty, "." or ".."
+// segments, or hold backslashes or control characters, any of which could write outside
+// the directory a template means.
+func validateObjectName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: name is empty", errInvalidObjectName)
+	}
+	if strings.ContainsRune(name, '\\') || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return fmt.Errorf("%w %q: backslash or control character", errInvalidObjectName, name)
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("%w %q: empty, \".\" or \"..\" path segment", errInvalidObjectName, name)
+		}
+	}
+	return nil
+}
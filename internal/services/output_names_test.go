@@ -0,0 +1,92 @@
+package services
+
+import "testing"
+
+func TestSectionMatcher(t *testing.T) {
+	tests := []struct {
+		name    string
+		section string
+		doc     OutputDocument
+		prefix  string
+		objects map[string]bool
+	}{
+		{
+			name:    "default",
+			section: defaultSectionTemplate,
+			doc:     OutputDocument{DocID: "doc-1", TenantID: "unit-a"},
+			prefix:  "unit-a/doc-1/",
+			objects: map[string]bool{
+				"unit-a/doc-1/1_scope.md":               true,
+				"unit-a/doc-1/2_design/2.1_loads.md":    true,
+				"unit-a/doc-1/_index.json":              false,
+				"unit-a/doc-2/1_scope.md":               false,
+				"doc-1/1_scope.md":                      false,
+				"unit-a/doc-1/cleaned_chunks/0000.json": false,
+			},
+		},
+		{
+			name:    "numbered titles",
+			section: `{{.FilenameSlug}}/{{pad .Order 3}}-{{.Title}}.md`,
+			doc:     OutputDocument{DocID: "doc-1", FilenameSlug: "pump-manual"},
+			prefix:  "pump-manual/",
+			objects: map[string]bool{
+				"pump-manual/001-scope.md":        true,
+				"pump-manual/012-2.1_loads.md":    true,
+				"pump-manual/scope.md":            false,
+				"pump-manual/001-design/loads.md": false,
+				"valve-manual/001-scope.md":       false,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, err := newOutputNames(defaultPageTemplate, defaultMasterTemplate, tt.section)
+			if err != nil {
+				t.Fatal(err)
+			}
+			matcher, err := names.SectionMatcher(tt.doc)
+			if err != nil {
+				t.Fatalf("SectionMatcher() error = %v", err)
+			}
+			if matcher.Prefix != tt.prefix {
+				t.Errorf("Prefix = %q, want %q", matcher.Prefix, tt.prefix)
+			}
+			for object, want := range tt.objects {
+				if got := matcher.Match(object); got != want {
+					t.Errorf("Match(%q) = %v, want %v", object, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPageMatcher(t *testing.T) {
+	names, err := newOutputNames(`{{.FilenameSlug}}/pages/{{pad .StartPage 4}}{{if ne .StartPage .EndPage}}_{{pad .EndPage 4}}{{end}}.md`, defaultMasterTemplate, defaultSectionTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher, err := names.PageMatcher(OutputDocument{DocID: "doc-1", TenantID: "unit-a", FilenameSlug: "pump-manual"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "unit-a/pump-manual/pages/"; matcher.Prefix != want {
+		t.Errorf("Prefix = %q, want %q", matcher.Prefix, want)
+	}
+	tests := []struct {
+		object             string
+		startPage, endPage int
+		ok                 bool
+	}{
+		{object: "unit-a/pump-manual/pages/0007.md", startPage: 7, endPage: 7, ok: true},
+		{object: "unit-a/pump-manual/pages/0011_0015.md", startPage: 11, endPage: 15, ok: true},
+		{object: "unit-a/pump-manual/pages/0015_0011.md"},
+		{object: "unit-a/pump-manual/pages/notes.md"},
+		{object: "unit-a/valve-manual/pages/0007.md"},
+	}
+	for _, tt := range tests {
+		startPage, endPage, ok := matcher.Match(tt.object)
+		if startPage != tt.startPage || endPage != tt.endPage || ok != tt.ok {
+			t.Errorf("Match(%q) = %d, %d, %v, want %d, %d, %v", tt.object, startPage, endPage, ok, tt.startPage, tt.endPage, tt.ok)
+		}
+	}
+}
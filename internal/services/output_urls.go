@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// OutputSigningConfig sets whether responses carry signed URLs to the final outputs
+// alongside their gs:// URIs, for consumers without access to the buckets.
+type OutputSigningConfig struct {
+	Enabled bool
+	TTL     time.Duration
+	// ServiceAccount signs the URLs. Empty uses the function's own service account.
+	ServiceAccount string
+}
+
+// loadOutputSigningConfig reads SIGN_OUTPUT_URLS, SIGNED_URL_TTL and
+// SIGNING_SERVICE_ACCOUNT.
+func loadOutputSigningConfig() (OutputSigningConfig, error) {
+	enabled, err := strconv.ParseBool(gcp.GetEnv("SIGN_OUTPUT_URLS", "false"))
+	if err != nil {
+		return OutputSigningConfig{}, fmt.Errorf("SIGN_OUTPUT_URLS must be a boolean")
+	}
+	ttl, err := time.ParseDuration(gcp.GetEnv("SIGNED_URL_TTL", "15m"))
+	if err != nil || ttl <= 0 {
+		return OutputSigningConfig{}, fmt.Errorf("SIGNED_URL_TTL must be a positive duration")
+	}
+	return OutputSigningConfig{
+		Enabled:        enabled,
+		TTL:            ttl,
+		ServiceAccount: gcp.GetEnv("SIGNING_SERVICE_ACCOUNT", ""),
+	}, nil
+}
+
+// outputSigner signs links to output objects. A nil outputSigner signs nothing.
+type outputSigner struct {
+	signer gcp.URLSigner
+	ttl    time.Duration
+}
+
+// newOutputSigner returns the signer described by config, or nil when signing is disabled.
+func newOutputSigner(ctx context.Context, config OutputSigningConfig) (*outputSigner, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	signer, err := gcp.NewIAMURLSigner(ctx, config.ServiceAccount)
+	if err != nil {
+		return nil, err
+	}
+	return &outputSigner{signer: signer, ttl: config.TTL}, nil
+}
+
+// sign returns a signed URL for object in bucket, or "" when s is nil or signing fails.
+// A failure is logged and leaves the caller with the gs:// URI only.
+func (s *outputSigner) sign(ctx context.Context, logCtx *slog.Logger, bucket, object string) string {
+	if s == nil {
+		return ""
+	}
+	url, err := s.signer.SignedURL(ctx, bucket, object, s.ttl)
+	if err != nil {
+		logCtx.Warn("Failed to sign output URL; returning gs:// URI only.", "error", err, "bucket", bucket, "gcsObject", object)
+		return ""
+	}
+	return url
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// fakeURLSigner is a gcp.URLSigner that records the ttl of every URL it signs.
+type fakeURLSigner struct {
+	err  error
+	ttls []time.Duration
+}
+
+func (s *fakeURLSigner) SignedURL(ctx context.Context, bucket, object string, ttl time.Duration) (string, error) {
+	s.ttls = append(s.ttls, ttl)
+	if s.err != nil {
+		return "", s.err
+	}
+	return "https://storage.googleapis.com/" + bucket + "/" + object + "?X-Goog-Signature=fake", nil
+}
+
+func TestOutputSignerSign(t *testing.T) {
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	fake := &fakeURLSigner{}
+	s := &outputSigner{signer: fake, ttl: 15 * time.Minute}
+	if got := s.sign(ctx, logCtx, "outputs", "doc-1/master.md"); got != "https://storage.googleapis.com/outputs/doc-1/master.md?X-Goog-Signature=fake" {
+		t.Errorf("sign() = %q, want the signed URL", got)
+	}
+	if len(fake.ttls) != 1 || fake.ttls[0] != 15*time.Minute {
+		t.Errorf("signed with ttls %v, want the configured 15m", fake.ttls)
+	}
+
+	// A failure leaves the caller with the gs:// URI only.
+	failing := &outputSigner{signer: &fakeURLSigner{err: errors.New("permission denied")}, ttl: time.Minute}
+	if got := failing.sign(ctx, logCtx, "outputs", "doc-1/master.md"); got != "" {
+		t.Errorf("sign() after a failure = %q, want none", got)
+	}
+	var disabled *outputSigner
+	if got := disabled.sign(ctx, logCtx, "outputs", "doc-1/master.md"); got != "" {
+		t.Errorf("sign() when disabled = %q, want none", got)
+	}
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// pageAnchorRegex matches the page anchors written by the aggregator, e.g.
+// "<!-- page: 12 -->" or, for a multi-page chunk, "<!-- page: 11-15 -->".
+var pageAnchorRegex = regexp.MustCompile(`<!-- page: (\d+)(?:-(\d+))? -->`)
+
+// pageAnchorLineRegex matches a page anchor together with the rest of its line, for
+// stripping anchors from final output.
+var pageAnchorLineRegex = regexp.MustCompile(`[ \t]*<!-- page: \d+(?:-\d+)? -->[ \t]*(?:\r?\n)?`)
+
+// pageAnchor returns the anchor written before the content of pages [startPage, endPage].
+func pageAnchor(startPage, endPage int) string {
+	if startPage == endPage {
+		return "<!-- page: " + strconv.Itoa(startPage) + " -->"
+	}
+	return "<!-- page: " + strconv.Itoa(startPage) + "-" + strconv.Itoa(endPage) + " -->"
+}
+
+// splitMasterPages splits a master file into its pages along with the string that joins
+// them back together. Files with page anchors are split before each anchor and joined
+// with nothing; files written with the plain separator are split on it.
+func splitMasterPages(markdown string) ([]string, string) {
+	locs := pageAnchorRegex.FindAllStringIndex(markdown, -1)
+	if len(locs) == 0 {
+		return strings.Split(markdown, pageSeparator), pageSeparator
+	}
+	pages := make([]string, 0, len(locs)+1)
+	start := 0
+	for _, loc := range locs {
+		if loc[0] > start {
+			pages = append(pages, markdown[start:loc[0]])
+		}
+		start = loc[0]
+	}
+	return append(pages, markdown[start:]), ""
+}
+
+// sectionPageRanges works out which pages each section spans by scanning the page anchors
+// in section order. A section starts on the page current when it begins, or on the page
+// of an anchor it opens with, and ends on the last page it has content from. Ranges are
+// zero when the document carries no anchors.
+func sectionPageRanges(sections []parsedSection) [][2]int {
+	ranges := make([][2]int, len(sections))
+	currentStart, currentEnd := 0, 0
+	for i, section := range sections {
+		startPage := currentStart
+		endPage := currentEnd
+		content := section.Content
+		matches := pageAnchorRegex.FindAllStringSubmatchIndex(content, -1)
+		if len(matches) > 0 && strings.TrimSpace(content[:matches[0][0]]) == "" {
+			startPage = 0
+		}
+		for j, m := range matches {
+			anchorStart, _ := strconv.Atoi(content[m[2]:m[3]])
+			anchorEnd := anchorStart
+			if m[4] >= 0 {
+				anchorEnd, _ = strconv.Atoi(content[m[4]:m[5]])
+			}
+			if startPage == 0 {
+				startPage = anchorStart
+			}
+			next := len(content)
+			if j+1 < len(matches) {
+				next = matches[j+1][0]
+			}
+			// A trailing anchor with no content after it belongs to the next section.
+			if strings.TrimSpace(content[m[1]:next]) != "" {
+				endPage = anchorEnd
+			}
+			currentStart, currentEnd = anchorStart, anchorEnd
+		}
+		if endPage < startPage {
+			endPage = startPage
+		}
+		ranges[i] = [2]int{startPage, endPage}
+	}
+	return ranges
+}
+
+// stripPageAnchors removes page anchors from section content.
+func stripPageAnchors(content string) string {
+	if !pageAnchorRegex.MatchString(content) {
+		return content
+	}
+	return strings.TrimSpace(pageAnchorLineRegex.ReplaceAllString(content, ""))
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// loadWorkflowInlinePagesMax reads WORKFLOW_INLINE_PAGES_MAX, the most pages listed in a
+// workflow argument. Longer documents pass the URI of their page manifest instead.
+func loadWorkflowInlinePagesMax() (int, error) {
+	inlinePagesMax, err := strconv.Atoi(gcp.GetEnv("WORKFLOW_INLINE_PAGES_MAX", "100"))
+	if err != nil || inlinePagesMax < 0 {
+		return 0, fmt.Errorf("WORKFLOW_INLINE_PAGES_MAX must be a non-negative integer")
+	}
+	return inlinePagesMax, nil
+}
+
+// pageManifestObjectName returns the name of the page manifest of the document under
+// root in the split pages bucket.
+func pageManifestObjectName(root string) string {
+	return root + "/manifest.json"
+}
+
+// splitPageManifest returns the manifest of the files a document of pageCount pages is
+// split into in splitPagesBucket, in chunks of chunkSize pages, under its tenant's prefix.
+// Everything that needs the URI of a page's split file takes it from here.
+func splitPageManifest(splitPagesBucket, tenantID, docID string, pageCount, chunkSize int) *models.PageManifest {
+	manifest := &models.PageManifest{DocumentID: docID, TenantID: tenantID, PageCount: pageCount, Pages: make([]models.PageURI, 0, pageCount)}
+	if chunkSize > 1 {
+		manifest.ChunkSize = chunkSize
+	}
+	for _, chunk := range pageChunks(pageCount, chunkSize) {
+		uri := fmt.Sprintf("gs://%s/%s", splitPagesBucket, pageRangeObjectName(documentRoot(tenantID, docID), chunk.StartPage, chunk.EndPage, "pdf"))
+		for pageNumber := chunk.StartPage; pageNumber <= chunk.EndPage; pageNumber++ {
+			manifest.Pages = append(manifest.Pages, models.PageURI{PageNumber: pageNumber, GCSUri: uri})
+		}
+	}
+	return manifest
+}
+
+// writePageManifest stores manifest as the document's manifest.json in bucket and returns
+// its URI. An earlier manifest is replaced, as a reprocessed document may be chunked
+// differently.
+func writePageManifest(ctx context.Context, bucket objectstore.Bucket, manifest *models.PageManifest) (string, error) {
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode page manifest: %w", err)
+	}
+	objectName := pageManifestObjectName(documentRoot(manifest.TenantID, manifest.DocumentID))
+	writer := bucket.NewWriter(ctx, objectName, objectstore.WriterOptions{
+		ContentType: "application/json",
+		Metadata:    map[string]string{gcp.CompleteMetadataKey: "true"},
+	})
+	if _, err := writer.Write(content); err != nil {
+		_ = writer.Close()
+		return "", fmt.Errorf("failed to write page manifest %s: %w", objectName, err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to finalize page manifest %s: %w", objectName, err)
+	}
+	return fmt.Sprintf("gs://%s/%s", bucket.Name(), objectName), nil
+}
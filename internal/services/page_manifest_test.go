@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+func TestWorkflowPayloadInlineCutoff(t *testing.T) {
+	const cutoff = 3
+	tests := []struct {
+		name         string
+		tenantID     string
+		pageCount    int
+		chunkSize    int
+		wantManifest string // The manifest URI passed instead of the pages, if any.
+	}{
+		{name: "no pages", pageCount: 0},
+		{name: "at the cutoff", pageCount: cutoff},
+		{name: "one past the cutoff", pageCount: cutoff + 1, wantManifest: "gs://pages/doc-1/manifest.json"},
+		{name: "one past the cutoff for a tenant", tenantID: "acme", pageCount: cutoff + 1, wantManifest: "gs://pages/acme/doc-1/manifest.json"},
+		// The cutoff counts pages, not the chunks they are split into.
+		{name: "chunked, at the cutoff", pageCount: cutoff, chunkSize: 2},
+		{name: "chunked, one past the cutoff", pageCount: cutoff + 1, chunkSize: 2, wantManifest: "gs://pages/doc-1/manifest.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := objectstore.NewMemory()
+			manifest := splitPageManifest("pages", tt.tenantID, "doc-1", tt.pageCount, tt.chunkSize)
+			order := []int{1}
+
+			payload, err := workflowPayload(context.Background(), store.Bucket("pages"), manifest, 7, order, cutoff)
+			if err != nil {
+				t.Fatalf("workflowPayload() error = %v", err)
+			}
+			if payload.DocumentID != "doc-1" || payload.TenantID != tt.tenantID || payload.PageCount != tt.pageCount ||
+				payload.SourceGeneration != 7 || !slices.Equal(payload.ProcessingOrder, order) {
+				t.Errorf("workflowPayload() = %+v", payload)
+			}
+			if payload.ManifestGCSUri != tt.wantManifest {
+				t.Errorf("ManifestGCSUri = %q, want %q", payload.ManifestGCSUri, tt.wantManifest)
+			}
+
+			// The argument carries either the pages or the manifest URI, never both.
+			var argument map[string]json.RawMessage
+			data, _ := json.Marshal(payload)
+			if err := json.Unmarshal(data, &argument); err != nil {
+				t.Fatal(err)
+			}
+			_, hasPages := argument["pages"]
+			_, hasManifest := argument["manifestGcsUri"]
+			if wantPages := tt.wantManifest == "" && tt.pageCount > 0; hasPages != wantPages || hasManifest != (tt.wantManifest != "") {
+				t.Errorf("workflow argument = %s, want pages %v, manifest %v", data, wantPages, tt.wantManifest != "")
+			}
+			if _, hasChunks := argument["chunks"]; hasChunks != (tt.chunkSize > 1 && tt.pageCount > 0) {
+				t.Errorf("workflow argument = %s, want chunks only when chunked", data)
+			}
+
+			objectName := pageManifestObjectName(documentRoot(tt.tenantID, "doc-1"))
+			stored, ok := store.Get("pages", objectName)
+			if tt.wantManifest == "" {
+				if !slices.Equal(payload.Pages, manifest.Pages) {
+					t.Errorf("Pages = %+v, want %+v", payload.Pages, manifest.Pages)
+				}
+				if ok {
+					t.Errorf("manifest written for an inline payload")
+				}
+				return
+			}
+			if payload.Pages != nil {
+				t.Errorf("Pages = %+v, want none beside the manifest", payload.Pages)
+			}
+			var got models.PageManifest
+			if !ok {
+				t.Fatalf("manifest %s not written", objectName)
+			}
+			if err := json.Unmarshal(stored, &got); err != nil {
+				t.Fatalf("manifest = %q, not JSON: %v", stored, err)
+			}
+			if !reflect.DeepEqual(&got, manifest) {
+				t.Errorf("stored manifest = %+v, want %+v", got, *manifest)
+			}
+			if attrs, _ := store.Bucket("pages").Attrs(context.Background(), objectName); attrs == nil || attrs.Metadata[gcp.CompleteMetadataKey] != "true" {
+				t.Errorf("manifest attrs = %+v, want it marked complete", attrs)
+			}
+		})
+	}
+}
+
+func TestWorkflowPayloadManifestWriteFails(t *testing.T) {
+	store := objectstore.NewMemory()
+	store.FailWrites(errors.New("bucket unavailable"))
+	manifest := splitPageManifest("pages", "", "doc-1", 4, 1)
+
+	if _, err := workflowPayload(context.Background(), store.Bucket("pages"), manifest, 0, nil, 3); err == nil {
+		t.Fatal("workflowPayload() succeeded without writing the manifest")
+	}
+}
+
+func TestSplitPageManifest(t *testing.T) {
+	manifest := splitPageManifest("pages", "acme", "doc-1", 5, 2)
+	want := &models.PageManifest{
+		DocumentID: "doc-1",
+		TenantID:   "acme",
+		PageCount:  5,
+		ChunkSize:  2,
+		Pages: []models.PageURI{
+			{PageNumber: 1, GCSUri: "gs://pages/acme/doc-1/00001-00002.pdf"},
+			{PageNumber: 2, GCSUri: "gs://pages/acme/doc-1/00001-00002.pdf"},
+			{PageNumber: 3, GCSUri: "gs://pages/acme/doc-1/00003-00004.pdf"},
+			{PageNumber: 4, GCSUri: "gs://pages/acme/doc-1/00003-00004.pdf"},
+			{PageNumber: 5, GCSUri: "gs://pages/acme/doc-1/00005.pdf"},
+		},
+	}
+	if !reflect.DeepEqual(manifest, want) {
+		t.Errorf("splitPageManifest() = %+v, want %+v", manifest, want)
+	}
+	if manifest := splitPageManifest("pages", "", "doc-1", 1, 1); manifest.ChunkSize != 0 || manifest.Pages[0].GCSUri != "gs://pages/doc-1/00001.pdf" {
+		t.Errorf("splitPageManifest() without chunking = %+v", manifest)
+	}
+}
+
+func TestLoadWorkflowInlinePagesMax(t *testing.T) {
+	t.Setenv("WORKFLOW_INLINE_PAGES_MAX", "")
+	os.Unsetenv("WORKFLOW_INLINE_PAGES_MAX")
+	if got, err := loadWorkflowInlinePagesMax(); err != nil || got != 100 {
+		t.Errorf("loadWorkflowInlinePagesMax() = %d, %v; want the default of 100", got, err)
+	}
+	t.Setenv("WORKFLOW_INLINE_PAGES_MAX", "0")
+	if got, err := loadWorkflowInlinePagesMax(); err != nil || got != 0 {
+		t.Errorf("loadWorkflowInlinePagesMax() = %d, %v; want 0", got, err)
+	}
+	for _, value := range []string{"-1", "many"} {
+		t.Setenv("WORKFLOW_INLINE_PAGES_MAX", value)
+		if _, err := loadWorkflowInlinePagesMax(); err == nil {
+			t.Errorf("loadWorkflowInlinePagesMax() accepted %q", value)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// pagesSubcollection is the name of the per-page subcollection under each document.
+const pagesSubcollection = "pages"
+
+// pagesCollection returns the pages subcollection of a document.
+func pagesCollection(client *firestore.Client, collection, docID string) *firestore.CollectionRef {
+	return client.Collection(collection).Doc(docID).Collection(pagesSubcollection)
+}
+
+// createPageRecords writes a PENDING page record for every split page of a document,
+// with the URIs of the images extracted from it, keyed by page number in imageURIs.
+func createPageRecords(ctx context.Context, client *firestore.Client, collection, docID string, pageCount int, gcsURIForPage func(int) string, imageURIs map[int][]string) error {
+	pages := pagesCollection(client, collection, docID)
+	bulkWriter := client.BulkWriter(ctx)
+
+	jobs := make([]*firestore.BulkWriterJob, 0, pageCount)
+	now := time.Now()
+	for pageNumber := 1; pageNumber <= pageCount; pageNumber++ {
+		page := models.Page{
+			PageNumber: pageNumber,
+			Status:     models.PageStatusPending,
+			GCSUri:     gcsURIForPage(pageNumber),
+			ImageURIs:  imageURIs[pageNumber],
+			UpdatedAt:  now,
+		}
+		job, err := bulkWriter.Set(pages.Doc(models.PageDocID(pageNumber)), page)
+		if err != nil {
+			bulkWriter.End()
+			return fmt.Errorf("failed to enqueue page record %d: %w", pageNumber, err)
+		}
+		jobs = append(jobs, job)
+	}
+	bulkWriter.End()
+
+	for i, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return fmt.Errorf("failed to write page record %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// getPageRecord reads a page record. It returns nil if the page has none, as for
+// documents split before page records were introduced.
+func getPageRecord(ctx context.Context, client *firestore.Client, collection, docID string, pageNumber int) (*models.Page, error) {
+	snap, err := pagesCollection(client, collection, docID).Doc(models.PageDocID(pageNumber)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page record %d: %w", pageNumber, err)
+	}
+	var page models.Page
+	if err := snap.DataTo(&page); err != nil {
+		return nil, fmt.Errorf("failed to decode page record %d: %w", pageNumber, err)
+	}
+	return &page, nil
+}
+
+// pageImageURIs returns the URIs of the extracted images recorded on a document's page
+// records, keyed by page number.
+func pageImageURIs(ctx context.Context, client *firestore.Client, collection, docID string) (map[int][]string, error) {
+	snaps, err := pagesCollection(client, collection, docID).Select("pageNumber", "imageUris").Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page records: %w", err)
+	}
+	images := make(map[int][]string)
+	for _, snap := range snaps {
+		var page models.Page
+		if err := snap.DataTo(&page); err != nil {
+			return nil, fmt.Errorf("failed to decode page record %s: %w", snap.Ref.ID, err)
+		}
+		if len(page.ImageURIs) > 0 {
+			images[page.PageNumber] = page.ImageURIs
+		}
+	}
+	return images, nil
+}
+
+// pageAttemptCounts returns the number of times each page of a document has been sent to
+// the translator, keyed by page number. Pages never attempted are left out.
+func pageAttemptCounts(ctx context.Context, client *firestore.Client, collection, docID string) (map[int]int, error) {
+	snaps, err := pagesCollection(client, collection, docID).Select("pageNumber", "attemptCount").Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page records: %w", err)
+	}
+	attempts := make(map[int]int)
+	for _, snap := range snaps {
+		var page models.Page
+		if err := snap.DataTo(&page); err != nil {
+			return nil, fmt.Errorf("failed to decode page record %s: %w", snap.Ref.ID, err)
+		}
+		if page.AttemptCount > 0 {
+			attempts[page.PageNumber] = page.AttemptCount
+		}
+	}
+	return attempts, nil
+}
+
+// updatePageRecord merges fields into a page record, creating it if it does not exist
+// (e.g. for documents split before page records were introduced).
+func updatePageRecord(ctx context.Context, client *firestore.Client, collection, docID string, pageNumber int, fields map[string]interface{}) error {
+	fields["pageNumber"] = pageNumber
+	fields["updatedAt"] = time.Now()
+	ref := pagesCollection(client, collection, docID).Doc(models.PageDocID(pageNumber))
+	if _, err := ref.Set(ctx, fields, firestore.MergeAll); err != nil {
+		return fmt.Errorf("failed to update page record %d: %w", pageNumber, err)
+	}
+	return nil
+}
+
+// updatePageRangeRecords applies the same field updates to every page record in
+// [startPage, endPage], as happens when a multi-page chunk is translated in one call.
+func updatePageRangeRecords(ctx context.Context, client *firestore.Client, collection, docID string, startPage, endPage int, fields map[string]interface{}) error {
+	for pageNumber := startPage; pageNumber <= endPage; pageNumber++ {
+		pageFields := make(map[string]interface{}, len(fields)+2)
+		for k, v := range fields {
+			pageFields[k] = v
+		}
+		if err := updatePageRecord(ctx, client, collection, docID, pageNumber, pageFields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// failedPlaceholderRegex matches the opening of a failure placeholder.
+var failedPlaceholderRegex = regexp.MustCompile(`^<!-- PAGES? \d+(?:-\d+)? FAILED:`)
+
+// failedPagePlaceholder returns the markdown written for pages that failed translation
+// with allowFailure set, e.g. "<!-- PAGE 7 FAILED: reason -->".
+func failedPagePlaceholder(startPage, endPage int, reason string) string {
+	reason = strings.Join(strings.Fields(reason), " ")
+	reason = strings.ReplaceAll(reason, "-->", "-- >")
+	return fmt.Sprintf("<!-- %s FAILED: %s -->", strings.ToUpper(describePages(startPage, endPage)), reason)
+}
+
+// isFailedPagePlaceholder reports whether content begins with a failure placeholder.
+func isFailedPagePlaceholder(content []byte) bool {
+	return failedPlaceholderRegex.Match(content)
+}
+
+// pageObject is a per-page or per-chunk object in a bucket, identified by the pages it
+// covers. Single-page objects have StartPage == EndPage.
+type pageObject struct {
+	StartPage int
+	EndPage   int
+	Name      string
+}
+
+// pageRangeFromObjectName parses the pages covered by names such as "docID/00012.md",
+// "docID/12.pdf" or, for multi-page chunks, "docID/00011-00015.md".
+func pageRangeFromObjectName(objectName string) (int, int, bool) {
+	base := path.Base(objectName)
+	base = strings.TrimSuffix(base, path.Ext(base))
+	startText, endText, isRange := strings.Cut(base, "-")
+	startPage, err := strconv.Atoi(startText)
+	if err != nil || startPage < 1 {
+		return 0, 0, false
+	}
+	if !isRange {
+		return startPage, startPage, true
+	}
+	endPage, err := strconv.Atoi(endText)
+	if err != nil || endPage < startPage {
+		return 0, 0, false
+	}
+	return startPage, endPage, true
+}
+
+// pageNumberFromObjectName parses the page number from single-page names such as
+// "docID/00012.md" or "docID/12.pdf". Chunk names are rejected.
+func pageNumberFromObjectName(objectName string) (int, bool) {
+	startPage, endPage, ok := pageRangeFromObjectName(objectName)
+	if !ok || startPage != endPage {
+		return 0, false
+	}
+	return startPage, true
+}
+
+// orderPageObjects sorts pages numerically in place and verifies that they form a
+// contiguous run starting at page 1 with no page covered twice.
+func orderPageObjects(pages []pageObject) error {
+	sort.Slice(pages, func(i, j int) bool {
+		if pages[i].StartPage != pages[j].StartPage {
+			return pages[i].StartPage < pages[j].StartPage
+		}
+		return pages[i].EndPage < pages[j].EndPage
+	})
+
+	var missing, duplicated []string
+	expected := 1
+	for _, page := range pages {
+		if page.StartPage < expected {
+			duplicated = append(duplicated, path.Base(page.Name))
+			if page.EndPage >= expected {
+				expected = page.EndPage + 1
+			}
+			continue
+		}
+		for ; expected < page.StartPage; expected++ {
+			missing = append(missing, strconv.Itoa(expected))
+		}
+		expected = page.EndPage + 1
+	}
+
+	switch {
+	case len(duplicated) > 0:
+		return fmt.Errorf("objects overlap pages already covered: %s", strings.Join(duplicated, ", "))
+	case len(missing) > 0:
+		return models.WithCode(models.ErrorCodeNotFoundSource, fmt.Errorf("missing pages %s (found %d objects up to page %d)", strings.Join(missing, ", "), len(pages), expected-1))
+	}
+	return nil
+}
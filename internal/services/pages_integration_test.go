@@ -0,0 +1,59 @@
+//go:build integration
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestRepairOrderFromPageAttempts(t *testing.T) {
+	ctx := context.Background()
+	firestoreClient, _ := testsupport.RequireEmulators(t).Clients(ctx, t)
+	collection := fmt.Sprintf("documents-%d", time.Now().UnixNano())
+	doc := &models.Document{PageCount: 6, ChunkSize: 2, FileHash: "0123456789abcdef0123"}
+
+	if err := createPageRecords(ctx, firestoreClient, collection, "doc-1", doc.PageCount, func(int) string { return "" }, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The first chunk timed out twice and the second once; the last was never reached.
+	for page, attempts := range map[int]int{1: 2, 2: 2, 3: 1, 4: 1} {
+		if err := updatePageRecord(ctx, firestoreClient, collection, "doc-1", page, map[string]interface{}{"attemptCount": attempts}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	attempts, err := pageAttemptCounts(ctx, firestoreClient, collection, "doc-1")
+	if err != nil {
+		t.Fatalf("pageAttemptCounts() error = %v", err)
+	}
+	if want := map[int]int{1: 2, 2: 2, 3: 1, 4: 1}; !reflect.DeepEqual(attempts, want) {
+		t.Errorf("pageAttemptCounts() = %v, want %v", attempts, want)
+	}
+
+	tests := []struct {
+		strategy string
+		want     []int
+	}{
+		{strategy: ProcessingOrderSequential, want: []int{3, 2, 1}},
+		{strategy: ProcessingOrderStripe, want: []int{3, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			config := ProcessingOrderConfig{Strategy: tt.strategy, Stripes: 2}
+			got, err := config.RepairOrder(doc, attempts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RepairOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+func TestPageRangeFromObjectName(t *testing.T) {
+	tests := []struct {
+		name               string
+		startPage, endPage int
+		ok                 bool
+	}{
+		{name: "doc/00012.md", startPage: 12, endPage: 12, ok: true},
+		{name: "unit-a/doc/12.pdf", startPage: 12, endPage: 12, ok: true},
+		{name: "doc/00011-00015.md", startPage: 11, endPage: 15, ok: true},
+		{name: "doc/00000.md"},
+		{name: "doc/00015-00011.md"},
+		{name: "doc/master.md"},
+		{name: "doc/00011-end.md"},
+	}
+	for _, tt := range tests {
+		startPage, endPage, ok := pageRangeFromObjectName(tt.name)
+		if startPage != tt.startPage || endPage != tt.endPage || ok != tt.ok {
+			t.Errorf("pageRangeFromObjectName(%q) = %d, %d, %v, want %d, %d, %v", tt.name, startPage, endPage, ok, tt.startPage, tt.endPage, tt.ok)
+		}
+	}
+	if _, ok := pageNumberFromObjectName("doc/00011-00015.md"); ok {
+		t.Errorf("pageNumberFromObjectName() of a chunk is ok, want not")
+	}
+}
+
+func TestOrderPageObjects(t *testing.T) {
+	page := func(name string) pageObject {
+		startPage, endPage, _ := pageRangeFromObjectName(name)
+		return pageObject{StartPage: startPage, EndPage: endPage, Name: name}
+	}
+	tests := []struct {
+		name      string
+		pages     []pageObject
+		wantOrder []string
+		wantErr   string
+		wantCode  models.ErrorCode
+	}{
+		{
+			name:      "numeric rather than lexical order",
+			pages:     []pageObject{page("doc/10.md"), page("doc/2.md"), page("doc/1.md"), page("doc/3-9.md")},
+			wantOrder: []string{"doc/1.md", "doc/2.md", "doc/3-9.md", "doc/10.md"},
+		},
+		{
+			name:     "gaps are missing pages",
+			pages:    []pageObject{page("doc/00001.md"), page("doc/00004.md")},
+			wantErr:  "missing pages 2, 3",
+			wantCode: models.ErrorCodeNotFoundSource,
+		},
+		{
+			name:     "a run must start at page 1",
+			pages:    []pageObject{page("doc/00002.md")},
+			wantErr:  "missing pages 1",
+			wantCode: models.ErrorCodeNotFoundSource,
+		},
+		{
+			name:     "the same page twice",
+			pages:    []pageObject{page("doc/00007.md"), page("doc/7.md"), page("doc/1-6.md")},
+			wantErr:  "overlap pages already covered: 7.md",
+			wantCode: models.ErrorCodeInternal,
+		},
+		{
+			name:     "a chunk overlapping a page",
+			pages:    []pageObject{page("doc/00001-00003.md"), page("doc/00003.md")},
+			wantErr:  "overlap pages already covered: 00003.md",
+			wantCode: models.ErrorCodeInternal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := orderPageObjects(tt.pages)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("orderPageObjects() error = %v", err)
+				}
+				var names []string
+				for _, page := range tt.pages {
+					names = append(names, page.Name)
+				}
+				if strings.Join(names, " ") != strings.Join(tt.wantOrder, " ") {
+					t.Errorf("order = %v, want %v", names, tt.wantOrder)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("orderPageObjects() error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if code := models.CodeOf(err); code != tt.wantCode {
+				t.Errorf("error code = %q, want %q", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestFailedPagePlaceholder(t *testing.T) {
+	tests := []struct {
+		startPage, endPage int
+		reason             string
+		want               string
+	}{
+		{startPage: 7, endPage: 7, reason: "quota exhausted", want: "<!-- PAGE 7 FAILED: quota exhausted -->"},
+		{startPage: 3, endPage: 5, reason: "bad\n  gateway --> upstream", want: "<!-- PAGES 3-5 FAILED: bad gateway -- > upstream -->"},
+	}
+	for _, tt := range tests {
+		got := failedPagePlaceholder(tt.startPage, tt.endPage, tt.reason)
+		if got != tt.want {
+			t.Errorf("failedPagePlaceholder() = %q, want %q", got, tt.want)
+		}
+		if !isFailedPagePlaceholder([]byte(got + "\n")) {
+			t.Errorf("isFailedPagePlaceholder(%q) = false, want true", got)
+		}
+	}
+	if isFailedPagePlaceholder([]byte("# Page 7 FAILED inspection\n")) {
+		t.Errorf("isFailedPagePlaceholder() of ordinary markdown = true, want false")
+	}
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Statuses recorded on a document whose upload is rejected before splitting. A rejected
+// upload is a terminal outcome, not a failure, so the splitter does not return an error
+// for it and Eventarc does not retry.
+const (
+	StatusRejectedEncrypted     = "REJECTED_ENCRYPTED"
+	StatusRejectedInvalidFormat = "REJECTED_INVALID_FORMAT"
+	StatusRejectedEmpty         = "REJECTED_EMPTY"
+	StatusRejectedTooLarge      = "REJECTED_TOO_LARGE"
+)
+
+// pdfMagicSearchLimit is how far into a file the "%PDF-" header may appear. Readers are
+// expected to tolerate leading junk before the header, so it is not required at offset 0.
+const pdfMagicSearchLimit = 1024
+
+var pdfMagic = []byte("%PDF-")
+
+// pdfRejection explains why an upload will not be processed.
+type pdfRejection struct {
+	Status  string
+	Details string
+}
+
+// checkPageCount rejects documents with no pages, or with more than maxPages pages. Very
+// large documents exhaust function memory while splitting and exceed the workflow's
+// fan-out limits.
+func checkPageCount(pageCount, maxPages int) *pdfRejection {
+	switch {
+	case pageCount == 0:
+		return &pdfRejection{
+			Status:  StatusRejectedEmpty,
+			Details: "The uploaded PDF has no pages.",
+		}
+	case pageCount > maxPages:
+		return &pdfRejection{
+			Status:  StatusRejectedTooLarge,
+			Details: fmt.Sprintf("The uploaded PDF has %d pages, more than the limit of %d. Please split it into smaller documents.", pageCount, maxPages),
+		}
+	}
+	return nil
+}
+
+// precheckPDF inspects a downloaded upload before any expensive work is done. It returns
+// a non-nil rejection for files that are not PDFs or that are encrypted. A PDF that merely
+// fails to parse is not rejected here; it is left to fail during optimization as before.
+func precheckPDF(path string) (*pdfRejection, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	header := make([]byte, pdfMagicSearchLimit)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read header of %s: %w", path, err)
+	}
+	if !bytes.Contains(header[:n], pdfMagic) {
+		return &pdfRejection{
+			Status:  StatusRejectedInvalidFormat,
+			Details: "The uploaded file is not a PDF (no %PDF- header found). Please upload a PDF document.",
+		}, nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind %s: %w", path, err)
+	}
+	cfg := model.NewDefaultConfiguration()
+	cfg.ValidationMode = model.ValidationRelaxed
+	var ctx *model.Context
+	err = recoverPDFPanic(func() (err error) {
+		ctx, err = api.ReadContext(file, cfg)
+		return err
+	})
+	if errors.Is(err, pdfcpu.ErrWrongPassword) || (err == nil && ctx.Encrypt != nil) {
+		return &pdfRejection{
+			Status:  StatusRejectedEncrypted,
+			Details: "The uploaded PDF is encrypted or password-protected. Please remove the password and upload it again.",
+		}, nil
+	}
+	return nil, nil
+}
+
+// recoverPDFPanic runs fn, a call into pdfcpu, and returns a panic in it as an error.
+// pdfcpu panics on some malformed files rather than returning an error, and a panic
+// would crash the instance, so the upload would be retried forever.
+func recoverPDFPanic(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed PDF: %v", r)
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+// writeTempPDF writes data to a PDF file in a temporary directory and returns its path.
+func writeTempPDF(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "upload.pdf")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPrecheckPDF(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus string // Empty when the upload passes.
+	}{
+		{name: "valid", path: writeTempPDF(t, testsupport.FixturePDF(2))},
+		{name: "leading junk before the header", path: writeTempPDF(t, append([]byte("\xef\xbb\xbf junk\n"), testsupport.FixturePDF(1)...))},
+		{name: "encrypted", path: "testdata/encrypted.pdf", wantStatus: StatusRejectedEncrypted},
+		{name: "not a PDF", path: "testdata/not_a_pdf.pdf", wantStatus: StatusRejectedInvalidFormat},
+		{name: "empty file", path: writeTempPDF(t, nil), wantStatus: StatusRejectedInvalidFormat},
+		{name: "header past the search limit", path: writeTempPDF(t, append(make([]byte, pdfMagicSearchLimit), testsupport.FixturePDF(1)...)), wantStatus: StatusRejectedInvalidFormat},
+		// Corrupt PDFs and PDFs without pages are left to optimization and the page count.
+		{name: "corrupt", path: "testdata/corrupt.pdf"},
+		{name: "zero pages", path: "testdata/zero_pages.pdf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejection, err := precheckPDF(tt.path)
+			if err != nil {
+				t.Fatalf("precheckPDF() error = %v", err)
+			}
+			switch {
+			case tt.wantStatus == "" && rejection != nil:
+				t.Errorf("precheckPDF() = %+v, want the upload passed", rejection)
+			case tt.wantStatus != "" && (rejection == nil || rejection.Status != tt.wantStatus):
+				t.Errorf("precheckPDF() = %+v, want %s", rejection, tt.wantStatus)
+			case rejection != nil && rejection.Details == "":
+				t.Errorf("precheckPDF() rejection has no details")
+			}
+		})
+	}
+}
+
+func TestPrecheckPDFMissingFile(t *testing.T) {
+	if _, err := precheckPDF(filepath.Join(t.TempDir(), "missing.pdf")); err == nil {
+		t.Error("precheckPDF() of a missing file succeeded")
+	}
+}
+
+func TestOptimizeAndPrepare(t *testing.T) {
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	f := &PDFSplitterFunction{config: PDFSplitterConfig{MaxPageCount: 10}}
+	tests := []struct {
+		name          string
+		path          string
+		wantPageCount int
+		wantStatus    string
+	}{
+		{name: "valid", path: writeTempPDF(t, testsupport.FixturePDF(3)), wantPageCount: 3},
+		{name: "zero pages", path: "testdata/zero_pages.pdf", wantStatus: StatusRejectedEmpty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			optimized := filepath.Join(t.TempDir(), "optimized.pdf")
+			pageCount, rejection, err := f.optimizeAndPrepare(context.Background(), logCtx, nil, tt.path, optimized)
+			if err != nil {
+				t.Fatalf("optimizeAndPrepare() error = %v", err)
+			}
+			if pageCount != tt.wantPageCount {
+				t.Errorf("optimizeAndPrepare() page count = %d, want %d", pageCount, tt.wantPageCount)
+			}
+			if (rejection == nil) != (tt.wantStatus == "") || (rejection != nil && rejection.Status != tt.wantStatus) {
+				t.Errorf("optimizeAndPrepare() rejection = %+v, want %q", rejection, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestOptimizePDFFailsOnCorruptPDF(t *testing.T) {
+	if err := optimizePDF("testdata/corrupt.pdf", filepath.Join(t.TempDir(), "optimized.pdf")); err == nil {
+		t.Error("optimizePDF() of a corrupt PDF succeeded")
+	}
+}
+
+func TestCheckPageCount(t *testing.T) {
+	tests := []struct {
+		pageCount  int
+		maxPages   int
+		wantStatus string // Empty when the document is accepted.
+	}{
+		{pageCount: 0, maxPages: 2000, wantStatus: StatusRejectedEmpty},
+		{pageCount: 1, maxPages: 2000},
+		{pageCount: 1999, maxPages: 2000},
+		{pageCount: 2000, maxPages: 2000},
+		{pageCount: 2001, maxPages: 2000, wantStatus: StatusRejectedTooLarge},
+		{pageCount: 1, maxPages: 1},
+		{pageCount: 2, maxPages: 1, wantStatus: StatusRejectedTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d of %d", tt.pageCount, tt.maxPages), func(t *testing.T) {
+			rejection := checkPageCount(tt.pageCount, tt.maxPages)
+			if tt.wantStatus == "" {
+				if rejection != nil {
+					t.Errorf("checkPageCount() = %+v, want the document accepted", rejection)
+				}
+				return
+			}
+			if rejection == nil || rejection.Status != tt.wantStatus {
+				t.Fatalf("checkPageCount() = %+v, want %s", rejection, tt.wantStatus)
+			}
+			if tt.wantStatus == StatusRejectedTooLarge && !strings.Contains(rejection.Details, fmt.Sprintf("has %d pages, more than the limit of %d", tt.pageCount, tt.maxPages)) {
+				t.Errorf("checkPageCount() details = %q, want the page count and limit", rejection.Details)
+			}
+		})
+	}
+}
@@ -4,62 +4,104 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
 	"cloud.google.com/go/storage"
 	executions "cloud.google.com/go/workflows/executions/apiv1"
-	"cloud.google.com/go/workflows/executions/apiv1/executionspb"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
 	"github.com/pdfcpu/pdfcpu/pkg/api"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 		_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
 )
 
 type PDFSplitterConfig struct {
 	ProjectID        string
 	SplitPagesBucket string
+	ImagesBucket     string // Optional. When set, embedded page images are extracted here.
 	CollectionName   string
+	TenantCollection string // Uploads of a tenant whose config fails validation are rejected.
 	WorkflowID       string
 	WorkflowLocation string
+	ProcessingOrder  ProcessingOrderConfig
+	WorkflowRetry    WorkflowRetryConfig
+	InlinePagesMax   int // Longer documents pass the workflow a page manifest.
+	ChunkSize        int // Pages per split file; 1 splits into single pages.
+	MaxPageCount     int // Documents with more pages are rejected before splitting.
+	UploadFilter     UploadFilter
+	Scratch          ScratchConfig
+	VertexAIRegion   string
+	Models           gcp.VertexModelConfig
+	Bundle           BundleConfig
+	UploadLease      UploadLeaseConfig
+	UploadProgress   UploadProgressConfig
+	Orchestration    OrchestrationConfig
+	Dedupe           DedupeConfig
+	Tenants          TenantRouting
+	SplitMode        string // One of the models.SplitMode* values.
 }
 
 type PDFSplitterFunction struct {
-	storageClient    *storage.Client
+	serviceClients
+	store            objectstore.Client
 	firestoreClient  *firestore.Client
 	executionsClient *executions.Client
+	bundleModel      gcp.ContentGenerator
+	translateTopic   *pubsub.Topic        // Nil unless ORCHESTRATION_MODE is pubsub.
+	cancellation     *CancellationChecker // Uncached: each document is handed off once.
+	tenantConfigs    *TenantStore
 	config           PDFSplitterConfig
+	// profile and tenantID are the processing profile and tenant of the upload being
+	// split. They are only set on the copy made by forUpload, whose ChunkSize is the
+	// profile's.
+	profile  *models.ProcessingProfile
+	tenantID string
 }
 
-type GCSEvent struct {
-	Bucket string `json:"bucket"`
-	Name   string `json:"name"`
+// forUpload returns a copy of f that splits an upload with the given processing profile,
+// belonging to tenantID.
+func (f *PDFSplitterFunction) forUpload(profile *models.ProcessingProfile, tenantID string) *PDFSplitterFunction {
+	upload := *f
+	upload.profile = profile
+	upload.tenantID = tenantID
+	upload.config.ChunkSize = profile.ChunkSize
+	return &upload
+}
+
+// root returns the prefix the objects derived from the upload's document docID are
+// stored under.
+func (f *PDFSplitterFunction) root(docID string) string {
+	return documentRoot(f.tenantID, docID)
 }
 
 func NewPDFSplitter(ctx context.Context) (*PDFSplitterFunction, error) {
-	projectID := gcp.GetEnv("PROJECT_ID", "")
-	if projectID == "" {
-		return nil, fmt.Errorf("GCP_PROJECT environment variable must be set")
+	config, err := loadPDFSplitterConfig()
+	if err != nil {
+		return nil, err
 	}
 
-	config := PDFSplitterConfig{
-		ProjectID:        projectID,
-		SplitPagesBucket: gcp.GetEnv("SPLIT_PAGES_BUCKET", ""),
-		CollectionName:   gcp.GetEnv("FIRESTORE_COLLECTION", "documents"),
-		WorkflowLocation: gcp.GetEnv("WORKFLOW_LOCATION", "us-central1"),
-		WorkflowID:       gcp.GetEnv("WORKFLOW_ID", "document-processing-orchestrator"),
+	if err := gcp.InitTracing(ctx, config.ProjectID); err != nil {
+		return nil, err
 	}
-	if config.SplitPagesBucket == "" {
-		return nil, fmt.Errorf("SPLIT_PAGES_BUCKET environment variable must be set")
+	if err := gcp.InitMetrics(ctx, config.ProjectID); err != nil {
+		return nil, err
 	}
 
 	firestoreClient, err := gcp.NewFirestoreClient(ctx, config.ProjectID)
@@ -74,27 +116,176 @@ func NewPDFSplitter(ctx context.Context) (*PDFSplitterFunction, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Workflows Executions client: %w", err)
 	}
+	vertexClient, err := gcp.NewVertexClient(ctx, config.ProjectID, config.VertexAIRegion, config.Models)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vertex client: %w", err)
+	}
+
+	clients := serviceClients{firestoreClient, storageClient, executionsClient, vertexClient}
+	var translateTopic *pubsub.Topic
+	if config.Orchestration.PubSub() {
+		topics, closeTopics, err := pubSubTopics(ctx, config.ProjectID, config.Orchestration.TranslateTopic)
+		if err != nil {
+			return nil, err
+		}
+		translateTopic = topics[0]
+		clients = append(clients, closeTopics)
+	}
 
 	f := &PDFSplitterFunction{
+		serviceClients:   clients,
 		firestoreClient:  firestoreClient,
-		storageClient:    storageClient,
+		store:            objectstore.NewGCS(storageClient),
 		executionsClient: executionsClient,
-		config:           config,
+		bundleModel:      vertexClient.BundleSplitter(),
+		translateTopic:   translateTopic,
+		cancellation:     NewCancellationChecker(firestoreClient, config.CollectionName, 0),
+		tenantConfigs:    NewTenantStore(firestoreClient, config.TenantCollection, tenantConfigTTL),
+		config:           *config,
 	}
-	slog.Info("PDF Splitter logic initialized.", "workflowId", config.WorkflowID)
+	if config.ImagesBucket != "" && config.ChunkSize > 1 {
+		slog.Warn("Image extraction only runs for single-page chunks; multi-page chunks are translated as text only.", "chunkSize", config.ChunkSize)
+	}
+	slog.Info("PDF Splitter logic initialized.", "workflowId", config.WorkflowID, "imageExtraction", config.ImagesBucket != "", "chunkSize", config.ChunkSize, "earlyWorkflowStart", config.UploadProgress.EarlyStart, "orchestrationMode", config.Orchestration.Mode, "dedupeScope", config.Dedupe.Scope)
 	return f, nil
 }
 
-func (f *PDFSplitterFunction) Process(ctx context.Context, e GCSEvent) error {
-	logCtx := slog.With("gcsBucket", e.Bucket, "gcsObject", e.Name)
+// loadPDFSplitterConfig loads and validates the splitter's environment variables.
+func loadPDFSplitterConfig() (*PDFSplitterConfig, error) {
+	projectID := gcp.GetEnv("PROJECT_ID", "")
+	if projectID == "" {
+		return nil, fmt.Errorf("GCP_PROJECT environment variable must be set")
+	}
+
+	config := PDFSplitterConfig{
+		ProjectID:        projectID,
+		SplitPagesBucket: gcp.GetEnv("SPLIT_PAGES_BUCKET", ""),
+		ImagesBucket:     gcp.GetEnv("IMAGES_BUCKET", ""),
+		CollectionName:   gcp.GetEnv("FIRESTORE_COLLECTION", "documents"),
+		TenantCollection: gcp.GetEnv("TENANT_COLLECTION", "tenants"),
+		WorkflowLocation: gcp.GetEnv("WORKFLOW_LOCATION", "us-central1"),
+		WorkflowID:       gcp.GetEnv("WORKFLOW_ID", "document-processing-orchestrator"),
+		UploadFilter:     loadUploadFilter(),
+		VertexAIRegion:   gcp.GetEnv("VERTEX_AI_REGION", "us-central1"),
+		Models:           gcp.LoadVertexModelConfig(),
+	}
+	if config.SplitPagesBucket == "" {
+		return nil, fmt.Errorf("SPLIT_PAGES_BUCKET environment variable must be set")
+	}
+	order, err := loadProcessingOrderConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.ProcessingOrder = order
+	workflowRetry, err := loadWorkflowRetryConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.WorkflowRetry = workflowRetry
+	inlinePagesMax, err := loadWorkflowInlinePagesMax()
+	if err != nil {
+		return nil, err
+	}
+	config.InlinePagesMax = inlinePagesMax
+	chunkSize, err := strconv.Atoi(gcp.GetEnv("CHUNK_SIZE", "1"))
+	if err != nil || chunkSize < 1 {
+		return nil, fmt.Errorf("CHUNK_SIZE must be a positive integer")
+	}
+	config.ChunkSize = chunkSize
+	maxPageCount, err := strconv.Atoi(gcp.GetEnv("MAX_PAGE_COUNT", "2000"))
+	if err != nil || maxPageCount < 1 {
+		return nil, fmt.Errorf("MAX_PAGE_COUNT must be a positive integer")
+	}
+	config.MaxPageCount = maxPageCount
+	scratch, err := loadScratchConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Scratch = scratch
+	bundle, err := loadBundleConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Bundle = bundle
+	uploadLease, err := loadUploadLeaseConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.UploadLease = uploadLease
+	uploadProgress, err := loadUploadProgressConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.UploadProgress = uploadProgress
+	orchestration, err := loadOrchestrationConfig()
+	if err != nil {
+		return nil, err
+	}
+	if orchestration.PubSub() && orchestration.TranslateTopic == "" {
+		return nil, fmt.Errorf("TRANSLATE_TOPIC must be set when ORCHESTRATION_MODE is pubsub")
+	}
+	config.Orchestration = orchestration
+	splitMode, err := loadSplitMode(orchestration)
+	if err != nil {
+		return nil, err
+	}
+	config.SplitMode = splitMode
+	dedupe, err := loadDedupeConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Dedupe = dedupe
+	tenants, err := loadTenantRouting()
+	if err != nil {
+		return nil, err
+	}
+	config.Tenants = tenants
+	return &config, nil
+}
+
+func (f *PDFSplitterFunction) Process(ctx context.Context, e GCSEvent) (err error) {
+	logCtx := slog.With("gcsBucket", e.Bucket, "gcsObject", e.Name, "eventGeneration", e.Generation, "eventSizeBytes", e.Size, "eventContentType", e.ContentType)
+	ctx, span := startProcessSpan(ctx, "splitter.Process", "", attrGCSURI.String(fmt.Sprintf("gs://%s/%s", e.Bucket, e.Name)))
+	defer func() { endProcessSpan(ctx, span, err) }()
+	timer := startStage(models.TimingStageSplitter)
 	logCtx.Info("Processing new GCS object.")
 
-	tempDir, err := os.MkdirTemp("", "pdf-splitter-*")
+	attrs, skip, err := f.checkUpload(ctx, e)
+	if err != nil {
+		logCtx.Error("Failed to read uploaded object attributes", "error", err)
+		return err
+	}
+	if skip != "" {
+		logCtx.Info("Skipping object that is not an accepted PDF upload.", "reason", skip)
+		return nil
+	}
+	lease, err := f.acquireUploadLease(ctx, e.Bucket, e.Name, attrs.Generation)
+	if errors.Is(err, gcp.ErrLeaseHeld) {
+		logCtx.Info("Another instance is processing this upload. Skipping.", "generation", attrs.Generation, "reason", err)
+		return nil
+	}
+	if err != nil {
+		logCtx.Error("Failed to acquire upload lease", "error", err)
+		return err
+	}
+	defer func() {
+		if err := lease.Release(ctx); err != nil {
+			logCtx.Warn("Failed to release upload lease; it will expire.", "error", err, "expiresAt", lease.ExpiresAt)
+		}
+	}()
+
+	// Large uploads are worked on from the scratch disk, if there is one, and their pages
+	// uploaded with smaller buffers.
+	scratch := f.config.Scratch.plan(attrs.Size)
+	if scratch.Large && scratch.Dir == "" {
+		logCtx.Warn("Large upload will be processed in the default temporary directory; set SCRATCH_DIR to a disk mount.", "sizeBytes", attrs.Size)
+	}
+	tempDir, err := os.MkdirTemp(scratch.Dir, "pdf-splitter-*")
 	if err != nil {
 		return fmt.Errorf("failed to create temp dir: %w", err)
 	}
 	defer os.RemoveAll(tempDir)
-	logCtx.Info("Created temp directory.", "path", tempDir)
+	logCtx.Info("Created temp directory.", "path", tempDir, "sizeBytes", attrs.Size, "largeFile", scratch.Large)
 
 	sourcePdfPath := filepath.Join(tempDir, "source.pdf")
 	if err := f.streamGCSObject(ctx, e.Bucket, e.Name, sourcePdfPath); err != nil {
@@ -109,37 +300,152 @@ func (f *PDFSplitterFunction) Process(ctx context.Context, e GCSEvent) error {
 	}
 	logCtx = logCtx.With("fileHash", fileHash)
 
-	isDuplicate, docID, err := f.isDuplicate(ctx, fileHash)
+	tenantID, err := f.config.Tenants.tenantFor(e.Bucket, attrs.Metadata)
+	if err != nil {
+		// Retrying cannot fix the metadata, and without a valid tenant there is nowhere
+		// the document's objects may be stored.
+		logCtx.Error("Rejecting upload with an invalid tenant.", "error", err)
+		return nil
+	}
+	if tenantID != "" {
+		logCtx = logCtx.With("tenantId", tenantID)
+	}
+	// A rejected tenant config is recorded on the document, once it is created, rather
+	// than discovered halfway through the pipeline.
+	_, tenantConfigErr := f.tenantConfigs.Require(ctx, tenantID)
+	if tenantConfigErr != nil && !errors.Is(tenantConfigErr, ErrTenantConfigRejected) {
+		logCtx.Error("Failed to load tenant config", "error", tenantConfigErr)
+		return tenantConfigErr
+	}
+	key := f.config.Dedupe.keyFor(e.Bucket, tenantID)
+	docID, existing, err := f.isDuplicate(ctx, fileHash, key, 0)
 	if err != nil {
 		logCtx.Error("Failed to check for duplicate", "error", err)
 		return err
 	}
-	if isDuplicate {
-		logCtx.Info("Duplicate file detected. Skipping.", "existingDocId", docID)
+	targetID, supersedes := key.documentID(fileHash), ""
+	switch {
+	case existing == nil:
+	case awaitsUpload(existing, e.Bucket, e.Name):
+		targetID = docID
+	case f.config.Dedupe.expired(existing, time.Now()):
+		targetID, supersedes = supersedingDocumentID(key, fileHash, docID), docID
+		logCtx.Info("Re-ingesting a file whose document has expired.", "supersedesDocumentId", docID, "existingCreatedAt", existing.CreatedAt, "dedupeScope", key.Scope)
+	default:
+		logCtx.Info("Duplicate file detected. Skipping.", "existingDocId", docID, "existingFilename", existing.OriginalFilename, "dedupeScope", key.Scope)
 		return nil // Clean exit for a duplicate
 	}
 
-	docRef, err := f.createInitialDocument(ctx, fileHash, e.Name)
+	f = f.forUpload(profileFromMetadata(logCtx, attrs.Metadata, f.config.ChunkSize), tenantID)
+	docRef, err := f.createInitialDocument(ctx, logCtx, targetID, fileHash, key, supersedes, attrs)
+	if errors.Is(err, errDuplicateUpload) {
+		logCtx.Info("Duplicate file detected while creating its document. Skipping.", "existingDocId", targetID, "existingFilename", f.existingFilename(ctx, targetID))
+		return nil // Another upload of the same file created the document first
+	}
 	if err != nil {
 		logCtx.Error("Failed to create initial Firestore document", "error", err)
 		return err
 	}
 	logCtx = logCtx.With("documentId", docRef.ID)
+	span.SetAttributes(attrDocumentID.String(docRef.ID))
 	logCtx.Info("Created master document in Firestore.")
+	splitStatus := "success"
+	defer func() {
+		timer.record(ctx, logCtx, f.firestoreClient, f.config.CollectionName, docRef.ID, splitStatus, err)
+	}()
+
+	if tenantConfigErr != nil {
+		splitStatus = StatusRejectedTenantConfig
+		return f.reject(ctx, logCtx, docRef, &pdfRejection{Status: StatusRejectedTenantConfig, Details: tenantConfigErr.Error()})
+	}
+
+	rejection, err := precheckPDF(sourcePdfPath)
+	if err != nil {
+		return f.handleError(ctx, logCtx, docRef, "failed to inspect uploaded file", err)
+	}
+	if rejection != nil {
+		splitStatus = rejection.Status
+		return f.reject(ctx, logCtx, docRef, rejection)
+	}
 
 	optimizedPdfPath := filepath.Join(tempDir, "optimized.pdf")
-	pageCount, err := f.optimizeAndPrepare(ctx, logCtx, docRef, sourcePdfPath, optimizedPdfPath)
+	pageCount, rejection, err := f.optimizeAndPrepare(ctx, logCtx, docRef, sourcePdfPath, optimizedPdfPath)
 	if err != nil {
 		// Error is already logged and handled in optimizeAndPrepare
 		return err
 	}
+	if rejection != nil {
+		splitStatus = rejection.Status
+		return f.reject(ctx, logCtx, docRef, rejection)
+	}
+	// Only the optimized copy is split, so the source's space is freed for the pages.
+	peak := peakFileSize(sourcePdfPath, optimizedPdfPath)
+	removeScratchFile(logCtx, sourcePdfPath)
 
-	if err := f.uploadSplitPages(ctx, logCtx, docRef, optimizedPdfPath, pageCount); err != nil {
-		// Error is already logged and handled in uploadSplitPages
+	if isBundleUpload(attrs.Metadata) {
+		parts, method := f.findBundleParts(ctx, logCtx, docRef, optimizedPdfPath, pageCount, isRestrictedUpload(attrs.Metadata))
+		if len(parts) > 1 {
+			splitStatus = StatusBundleSplit
+			return f.splitBundle(ctx, logCtx, docRef, attrs, optimizedPdfPath, peak, parts, method, scratch.UploadChunkBytes)
+		}
+		logCtx.Info("Bundle holds a single document; processing it as one.", "method", method)
+	}
+
+	if rejection := f.config.Scratch.checkSplitSpace(logCtx, optimizedPdfPath); rejection != nil {
+		splitStatus = rejection.Status
+		return f.reject(ctx, logCtx, docRef, rejection)
+	}
+	var handOffErr error
+	if chapters := f.chaptersFor(logCtx, optimizedPdfPath, pageCount); chapters != nil {
+		handOffErr = f.splitByBookmarks(ctx, logCtx, docRef, optimizedPdfPath, pageCount, peak, chapters, fileHash, attrs.Generation, scratch.UploadChunkBytes)
+	} else {
+		if err := f.splitLocally(ctx, logCtx, docRef, optimizedPdfPath, pageCount, peak); err != nil {
+			// Error is already logged and handled in splitLocally
+			return err
+		}
+		handOffErr = f.handOff(ctx, logCtx, docRef, optimizedPdfPath, pageCount, fileHash, attrs.Generation, scratch.UploadChunkBytes)
+	}
+	if err := handOffErr; err != nil {
+		if errors.Is(err, errWorkflowNotStarted) {
+			// The pages are ready and the document is marked for the retrigger service.
+			splitStatus = StatusPagesReadyWorkflowFailed
+			return nil
+		}
+		if errors.Is(err, ErrDocumentCancelled) {
+			splitStatus = StatusCancelled
+			return nil
+		}
+		// Error is already logged and handled in handOff or splitByBookmarks
 		return err
 	}
+	return nil
+}
 
-	if err := f.triggerWorkflow(ctx, logCtx, docRef, pageCount); err != nil {
+// handOff uploads a document's split files, creates its page records and starts its
+// workflow, before the upload when EarlyStart is set. In the Pub/Sub mode, pages are
+// published only once uploaded, whatever EarlyStart. Pages that failed to upload, when
+// few enough to hand on the rest, are marked for the retranslator and left out of the
+// workflow. It returns errWorkflowNotStarted when the pages are ready but the workflow
+// could not be started, and the CANCELLED error, having moved the document to CANCELLED,
+// when it was cancelled while it was split.
+func (f *PDFSplitterFunction) handOff(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, splitPdfPath string, pageCount int, fileHash string, sourceGeneration int64, chunkBytes int) error {
+	if err := f.cancellation.Check(ctx, logCtx, docRef.ID); err != nil {
+		recordCancelled(ctx, logCtx, f.firestoreClient, f.config.CollectionName, docRef.ID, models.TimingStageSplitter)
+		return err
+	}
+	if f.config.UploadProgress.EarlyStart && !f.config.Orchestration.PubSub() {
+		return f.handOffEarly(ctx, logCtx, docRef, splitPdfPath, pageCount, fileHash, sourceGeneration, chunkBytes)
+	}
+	failed, images, err := f.uploadSplitPages(ctx, logCtx, docRef, splitPdfPath, pageCount, chunkBytes)
+	if err != nil {
+		// Error is already logged and handled in uploadSplitPages
+		return err
+	}
+	if err := f.createPageRecords(ctx, logCtx, docRef, pageCount, images); err != nil {
+		return err
+	}
+	f.markUploadFailedPages(ctx, logCtx, docRef.ID, failed)
+	if err := f.triggerWorkflow(ctx, logCtx, docRef, pageCount, fileHash, sourceGeneration, failed); err != nil {
 		// Error is already logged and handled in triggerWorkflow
 		return err
 	}
@@ -148,124 +454,561 @@ func (f *PDFSplitterFunction) Process(ctx context.Context, e GCSEvent) error {
 	return nil
 }
 
-func (f *PDFSplitterFunction) isDuplicate(ctx context.Context, fileHash string) (bool, string, error) {
-	docs, err := f.firestoreClient.Collection(f.config.CollectionName).Where("fileHash", "==", fileHash).Limit(1).Documents(ctx).GetAll()
+// handOffEarly starts the document's workflow before uploading its split files, so that
+// the first pages are translated while the rest upload. The document stays SPLITTING
+// until every file is uploaded and verified, which tells the translator that a missing
+// page is still to come. A workflow that could not be started is reported only once the
+// pages are ready for the retrigger service. The workflow is already running when pages
+// fail to upload, so their translation fails once the document moves to TRANSLATING.
+func (f *PDFSplitterFunction) handOffEarly(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, splitPdfPath string, pageCount int, fileHash string, sourceGeneration int64, chunkBytes int) error {
+	if err := f.createPageRecords(ctx, logCtx, docRef, pageCount, nil); err != nil {
+		return err
+	}
+	order, err := f.chunkOrder(pageCount, fileHash)
+	if err != nil {
+		return f.handleError(ctx, logCtx, docRef, "failed to compute processing order", err)
+	}
+	executionName, startErr := f.startWorkflow(ctx, logCtx, docRef.ID, pageCount, sourceGeneration, order)
+	if startErr != nil {
+		logCtx.Warn("Failed to trigger workflow execution; uploading pages for a retrigger.", "error", startErr)
+	} else {
+		logCtx.Info("Workflow triggered before page upload.", "executionName", executionName)
+		if _, err := docRef.Update(ctx, []firestore.Update{{Path: "workflowExecutionId", Value: executionName}}); err != nil {
+			logCtx.Error("Workflow triggered but the execution ID could not be recorded", "error", err, "executionName", executionName)
+		}
+	}
+
+	failed, images, err := f.uploadSplitPages(ctx, logCtx, docRef, splitPdfPath, pageCount, chunkBytes)
+	if err != nil {
+		// Error is already logged and handled in uploadSplitPages. The document is FAILED,
+		// so the translator fails pages that never arrived rather than waiting for them.
+		return err
+	}
+	f.recordPageImages(ctx, logCtx, docRef.ID, images)
+	f.markUploadFailedPages(ctx, logCtx, docRef.ID, failed)
+	if startErr != nil {
+		return f.workflowNotStarted(ctx, logCtx, docRef, startErr)
+	}
+	if err := gcp.AppendStatusTransition(ctx, docRef, "TRANSLATING", models.TimingStageSplitter, ""); err != nil {
+		// The workflow is running and every page exists, so SPLITTING no longer matters.
+		logCtx.Error("Pages uploaded but the document could not be moved to TRANSLATING", "error", err)
+		return nil
+	}
+	logCtx.Info("Hand-off to workflow complete.")
+	return nil
+}
+
+// createPageRecords creates a pending record for each of the document's pages, with the
+// URIs of its extracted images, if any.
+func (f *PDFSplitterFunction) createPageRecords(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, pageCount int, images map[int][]string) error {
+	manifest := splitPageManifest(f.config.SplitPagesBucket, f.tenantID, docRef.ID, pageCount, f.config.ChunkSize)
+	if err := createPageRecords(ctx, f.firestoreClient, f.config.CollectionName, docRef.ID, pageCount, manifest.GCSUriFor, images); err != nil {
+		return f.handleError(ctx, logCtx, docRef, "failed to create page records", err)
+	}
+	logCtx.Info("Created page records.", "pageCount", pageCount)
+	return nil
+}
+
+// isDuplicate returns the ID and record of the newest document that already records
+// fileHash and subDocumentIndex in the scope of key, or a nil record if there is none.
+// Documents are keyed by their file hash, but documents created before that carry random
+// IDs, so the check queries the fileHash field rather than looking the ID up. Documents
+// split from a bundle share the bundle's ID as their file hash and are told apart by their
+// index; every other document has index 0. It is only a fast path: concurrent uploads of
+// the same file both pass it, and createDocument settles which of them proceeds.
+func (f *PDFSplitterFunction) isDuplicate(ctx context.Context, fileHash string, key dedupeKey, subDocumentIndex int) (string, *models.Document, error) {
+	return findDocumentByHash(ctx, f.firestoreClient, f.config.CollectionName, fileHash, key, subDocumentIndex)
+}
+
+// findDocumentByHash returns the ID and record of the newest document in collection that
+// records fileHash and subDocumentIndex in the scope of key, or a nil record if there is
+// none. Documents re-ingesting a file once DEDUPE_TTL_DAYS has passed share its hash, so
+// the newest of them is the one that decides whether an upload is a duplicate.
+func findDocumentByHash(ctx context.Context, client *firestore.Client, collection, fileHash string, key dedupeKey, subDocumentIndex int) (string, *models.Document, error) {
+	query := client.Collection(collection).Where("fileHash", "==", fileHash)
+	if key != (dedupeKey{}) {
+		query = query.Where("dedupeScope", "==", key.Scope).Where("dedupeKey", "==", key.Value)
+	}
+	docs, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to query for duplicates: %w", err)
+	}
+	var newestID string
+	var newest *models.Document
+	for _, snap := range docs {
+		var doc models.Document
+		if err := snap.DataTo(&doc); err != nil {
+			return "", nil, fmt.Errorf("failed to decode document %s: %w", snap.Ref.ID, err)
+		}
+		if doc.SubDocumentIndex != subDocumentIndex || !key.matches(&doc) {
+			continue
+		}
+		if newest == nil || doc.CreatedAt.After(newest.CreatedAt) {
+			newestID, newest = snap.Ref.ID, &doc
+		}
+	}
+	return newestID, newest, nil
+}
+
+// existingFilename returns the original filename of the existing document docID, for
+// the log of an upload skipped as its duplicate, or "" if it cannot be read.
+func (f *PDFSplitterFunction) existingFilename(ctx context.Context, docID string) string {
+	snap, err := f.firestoreClient.Collection(f.config.CollectionName).Doc(docID).Get(ctx)
 	if err != nil {
-		return false, "", fmt.Errorf("failed to query for duplicates: %w", err)
+		return ""
 	}
-	if len(docs) > 0 {
-		return true, docs[0].Ref.ID, nil
+	var doc models.Document
+	if err := snap.DataTo(&doc); err != nil {
+		return ""
 	}
-	return false, "", nil
+	return doc.OriginalFilename
 }
 
-func (f *PDFSplitterFunction) createInitialDocument(ctx context.Context, fileHash, filename string) (*firestore.DocumentRef, error) {
-	newDoc := models.Document{
-		FileHash:         fileHash,
-		OriginalFilename: filename,
-		Status:           "VALIDATING",
-		CreatedAt:        time.Now(),
+// documentTypeMetadataKey is the custom metadata key uploaders set to describe the kind
+// of document, which the workflow maps to a translator prompt profile.
+const documentTypeMetadataKey = "documentType"
+
+// checkUpload reads the uploaded object's attributes and returns why it should be
+// skipped, or "" if it is a PDF to process. An object deleted or replaced since the event
+// is skipped; the event of the replacement processes it.
+func (f *PDFSplitterFunction) checkUpload(ctx context.Context, e GCSEvent) (*storage.ObjectAttrs, string, error) {
+	if skip := f.config.UploadFilter.skipName(e.Name); skip != "" {
+		return nil, skip, nil
+	}
+	attrs, err := f.store.Bucket(e.Bucket).Attrs(ctx, e.Name)
+	if errors.Is(err, objectstore.ErrObjectNotExist) {
+		return nil, "object no longer exists", nil
 	}
-	docRef, _, err := f.firestoreClient.Collection(f.config.CollectionName).Add(ctx, newDoc)
 	if err != nil {
+		return nil, "", fmt.Errorf("failed to read attributes of gs://%s/%s: %w", e.Bucket, e.Name, err)
+	}
+	if e.Generation != 0 && attrs.Generation != e.Generation {
+		return nil, fmt.Sprintf("object replaced by generation %d", attrs.Generation), nil
+	}
+	return attrs, f.config.UploadFilter.skipAttrs(attrs), nil
+}
+
+// errDuplicateUpload is returned by createInitialDocument when a document for the same
+// file hash already exists.
+var errDuplicateUpload = errors.New("a document for this file already exists")
+
+// uploadedByMetadataKey is the custom metadata key uploaders set to record who uploaded a
+// document, sent as the x-goog-meta-uploaded-by header.
+const uploadedByMetadataKey = "uploaded-by"
+
+// originalFilenameMetadataKey is the custom metadata key recording the file name of an
+// upload whose object is named otherwise, as the document uploader's objects are.
+const originalFilenameMetadataKey = "original-filename"
+
+// reprocessOfMetadataKey is the custom metadata key uploaders set to the ID of the
+// document of the prior revision of the file, sent as the x-goog-meta-reprocess-of header.
+const reprocessOfMetadataKey = "reprocess-of"
+
+// createInitialDocument creates the master document docID, recording the upload's
+// attributes, document type and language hints, the scope of its duplicate check and the
+// document it supersedes, if any. A document the uploader created for this upload is
+// claimed instead.
+func (f *PDFSplitterFunction) createInitialDocument(ctx context.Context, logCtx *slog.Logger, docID, fileHash string, key dedupeKey, supersedes string, upload *storage.ObjectAttrs) (*firestore.DocumentRef, error) {
+	doc := initialDocument(logCtx, fileHash, upload, f.profile)
+	doc.TenantID = f.tenantID
+	doc.DedupeScope = key.Scope
+	doc.DedupeKey = key.Value
+	doc.SupersedesDocumentID = supersedes
+	docRef, err := f.createDocument(ctx, docID, doc)
+	if !errors.Is(err, errDuplicateUpload) {
+		return docRef, err
+	}
+	docRef, err = f.claimReceivedDocument(ctx, docID, doc)
+	if err == nil {
+		logCtx.Info("Claimed the document created by the uploader.")
+	}
+	return docRef, err
+}
+
+// initialDocument returns a new document for an upload, recording where it came from, its
+// processing profile and the document type, language hints and processing restrictions
+// from its metadata.
+func initialDocument(logCtx *slog.Logger, fileHash string, upload *storage.ObjectAttrs, profile *models.ProcessingProfile) models.Document {
+	now := time.Now()
+	metadata := upload.Metadata
+	sourceLanguage, targetLanguage := languagesFromMetadata(logCtx, metadata)
+	processingRegion, redactionMode := residencyFromMetadata(logCtx, metadata)
+	filename := models.NormalizeFilename(uploadFilename(upload))
+	return models.Document{
+		FileHash:           fileHash,
+		OriginalFilename:   filename.Display,
+		OriginalObjectPath: filename.ObjectPath,
+		FilenameSlug:       filename.Slug,
+		DocumentType:       metadata[documentTypeMetadataKey],
+		SourceLanguage:     sourceLanguage,
+		TargetLanguage:     targetLanguage,
+		ProcessingRegion:   processingRegion,
+		RedactionMode:      redactionMode,
+		SourceBucket:       upload.Bucket,
+		SourceObject:       upload.Name,
+		SourceGeneration:   upload.Generation,
+		SourceSizeBytes:    upload.Size,
+		SourceContentType:  upload.ContentType,
+		UploadedBy:         strings.TrimSpace(metadata[uploadedByMetadataKey]),
+		ReprocessOf:        strings.TrimSpace(metadata[reprocessOfMetadataKey]),
+		ProcessingProfile:  profile,
+		Status:             "VALIDATING",
+		CreatedAt:          now,
+		StatusHistory: []models.StatusTransition{
+			{Status: "VALIDATING", Timestamp: now, Stage: models.TimingStageSplitter},
+		},
+	}
+}
+
+// uploadFilename returns the file name recorded in the upload's metadata, or else the
+// name of its object.
+func uploadFilename(upload *storage.ObjectAttrs) string {
+	if name := strings.TrimSpace(upload.Metadata[originalFilenameMetadataKey]); name != "" {
+		return name
+	}
+	return upload.Name
+}
+
+// createDocument creates doc with the given ID. The create fails if the document exists,
+// so of two concurrent uploads of the same file exactly one proceeds; the other gets
+// errDuplicateUpload.
+func (f *PDFSplitterFunction) createDocument(ctx context.Context, docID string, doc models.Document) (*firestore.DocumentRef, error) {
+	docRef := f.firestoreClient.Collection(f.config.CollectionName).Doc(docID)
+	if _, err := docRef.Create(ctx, doc); err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			return nil, errDuplicateUpload
+		}
 		return nil, fmt.Errorf("failed to create master document: %w", err)
 	}
 	return docRef, nil
 }
 
-func (f *PDFSplitterFunction) optimizeAndPrepare(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, source, optimized string) (int, error) {
+// claimReceivedDocument replaces the RECEIVED document docID, which the uploader created
+// for the upload doc records, with doc, keeping its creation time and status history.
+// Any other existing document, including one another instance has claimed, gives
+// errDuplicateUpload.
+func (f *PDFSplitterFunction) claimReceivedDocument(ctx context.Context, docID string, doc models.Document) (*firestore.DocumentRef, error) {
+	docRef := f.firestoreClient.Collection(f.config.CollectionName).Doc(docID)
+	err := f.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+		var existing models.Document
+		if err := snap.DataTo(&existing); err != nil {
+			return err
+		}
+		if !awaitsUpload(&existing, doc.SourceBucket, doc.SourceObject) {
+			return errDuplicateUpload
+		}
+		doc.CreatedAt = existing.CreatedAt
+		doc.CancelRequested, doc.CancelRequestedAt = existing.CancelRequested, existing.CancelRequestedAt
+		if doc.SupersedesDocumentID == "" {
+			doc.SupersedesDocumentID = existing.SupersedesDocumentID
+		}
+		doc.StatusHistory = append(existing.StatusHistory, doc.StatusHistory...)
+		return tx.Set(docRef, doc)
+	})
+	if errors.Is(err, errDuplicateUpload) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim received document: %w", err)
+	}
+	return docRef, nil
+}
+
+// awaitsUpload reports whether doc is a document the uploader created for the object
+// named by bucket and object, which the splitter has not claimed yet.
+func awaitsUpload(doc *models.Document, bucket, object string) bool {
+	return doc.Status == StatusReceived && doc.SourceBucket == bucket && doc.SourceObject == object
+}
+
+// optimizeAndPrepare optimizes the PDF and counts its pages. It returns a rejection when
+// the page count is outside the accepted range.
+func (f *PDFSplitterFunction) optimizeAndPrepare(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, source, optimized string) (int, *pdfRejection, error) {
 	if err := optimizePDF(source, optimized); err != nil {
-		return 0, f.handleError(ctx, logCtx, docRef, "failed to validate/optimize PDF", err)
+		return 0, nil, f.handleError(ctx, logCtx, docRef, "failed to validate/optimize PDF", err)
 	}
 	pageCount, err := api.PageCountFile(optimized)
 	if err != nil {
-		return 0, f.handleError(ctx, logCtx, docRef, "failed to get page count", err)
+		return 0, nil, f.handleError(ctx, logCtx, docRef, "failed to get page count", err)
+	}
+	if rejection := checkPageCount(pageCount, f.config.MaxPageCount); rejection != nil {
+		return pageCount, rejection, nil
 	}
-	if err := api.SplitFile(optimized, filepath.Dir(optimized), 1, nil); err != nil {
-		return 0, f.handleError(ctx, logCtx, docRef, "failed to split PDF", err)
+	logCtx.Info("PDF optimized.", "pageCount", pageCount)
+	return pageCount, nil, nil
+}
+
+// splitLocally splits the PDF at path into chunks next to it, deletes it, and records the
+// page count and peakFileSize, the size of the largest file the document was worked on
+// from, on the document.
+func (f *PDFSplitterFunction) splitLocally(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, path string, pageCount int, peak int64) error {
+	if err := api.SplitFile(path, filepath.Dir(path), f.config.ChunkSize, nil); err != nil {
+		return f.handleError(ctx, logCtx, docRef, "failed to split PDF", err)
 	}
+	// Uploads only need the chunks; the path is kept as the base of their names.
+	removeScratchFile(logCtx, path)
 	updates := []firestore.Update{
-		{Path: "status", Value: "SPLITTING"},
 		{Path: "pageCount", Value: pageCount},
+		{Path: "chunkSize", Value: f.config.ChunkSize},
 	}
-	if _, err := docRef.Update(ctx, updates); err != nil {
-		return 0, f.handleError(ctx, logCtx, docRef, "failed to update status to SPLITTING", err)
+	if peak > 0 {
+		updates = append(updates, firestore.Update{Path: "peakFileSizeBytes", Value: peak})
+	}
+	if err := gcp.AppendStatusTransition(ctx, docRef, "SPLITTING", models.TimingStageSplitter, "", updates...); err != nil {
+		return f.handleError(ctx, logCtx, docRef, "failed to update status to SPLITTING", err)
+	}
+	logCtx.Info("PDF split locally.", "pageCount", pageCount, "chunkSize", f.config.ChunkSize)
+	return nil
+}
+
+// reject records a rejected upload on its document. A rejection is not retryable, so nil
+// is returned unless the status itself cannot be recorded.
+func (f *PDFSplitterFunction) reject(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, rejection *pdfRejection) error {
+	logCtx.Warn("Rejecting upload.", "status", rejection.Status, "reason", rejection.Details)
+	if err := f.updateStatus(ctx, docRef, rejection.Status, rejection.Details); err != nil {
+		logCtx.Error("Failed to record rejection status", "error", err)
+		return fmt.Errorf("failed to record rejection status: %w", err)
 	}
-	logCtx.Info("PDF optimized and split locally.", "pageCount", pageCount)
-	return pageCount, nil
+	return nil
 }
 
-func (f *PDFSplitterFunction) uploadSplitPages(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, optimizedPdfPath string, pageCount int) error {
+// uploadSplitPages uploads the split files concurrently and records the pages uploaded
+// and failed on the document. A failed upload does not stop the others, so that every
+// failure is reported. When at most MaxFailedPages pages failed, their chunks are returned
+// and the rest are handed on; more fail the document. A non-zero chunkBytes caps the
+// buffer each upload holds in memory. The URIs of the images extracted from each page are
+// returned by page number, for its page record.
+func (f *PDFSplitterFunction) uploadSplitPages(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, optimizedPdfPath string, pageCount, chunkBytes int) (failed []pageChunk, images map[int][]string, err error) {
+	ctx, span := startSpan(ctx, "gcs.UploadPages", attrDocumentID.String(docRef.ID), attrPageNumber.Int(pageCount))
+	defer func() { endSpan(span, err) }()
 	logCtx.Info("Starting concurrent upload of pages.", "pageCount", pageCount)
-	eg, gctx := errgroup.WithContext(ctx)
+	var eg errgroup.Group
 	eg.SetLimit(10)
+	progress := newUploadProgress(docRef, f.config.UploadProgress.BatchPages)
 
 	splitFileBase := strings.TrimSuffix(optimizedPdfPath, filepath.Ext(optimizedPdfPath))
 
-	for i := 1; i <= pageCount; i++ {
-		pageNumber := i
-		localSplitFilePath := fmt.Sprintf("%s_%d.pdf", splitFileBase, pageNumber)
-		gcsDestObject := fmt.Sprintf("%s/%05d.pdf", docRef.ID, pageNumber)
+	var mu sync.Mutex
+	var uploaded []pageChunk
+	var failures []error
+	images = make(map[int][]string)
+	chunks := pageChunks(pageCount, f.config.ChunkSize)
+	for _, chunk := range chunks {
+		chunk := chunk
+		localSplitFilePath := localSplitPath(splitFileBase, chunk)
+		gcsDestObject := pageRangeObjectName(f.root(docRef.ID), chunk.StartPage, chunk.EndPage, "pdf")
 
 		eg.Go(func() error {
-			if err := f.uploadFile(gctx, localSplitFilePath, gcsDestObject); err != nil {
-				return fmt.Errorf("page %d: %w", pageNumber, err)
+			defer removeScratchFile(logCtx, localSplitFilePath)
+			if err := f.uploadFile(ctx, localSplitFilePath, gcsDestObject, chunkBytes); err != nil {
+				mu.Lock()
+				failed = append(failed, chunk)
+				failures = append(failures, fmt.Errorf("%s: %w", describePages(chunk.StartPage, chunk.EndPage), err))
+				mu.Unlock()
+				return nil
+			}
+			var imageURIs []string
+			if f.config.ImagesBucket != "" && chunk.StartPage == chunk.EndPage {
+				imageURIs = f.uploadPageImages(ctx, logCtx, f.root(docRef.ID), chunk.StartPage, localSplitFilePath)
+			}
+			progress.add(ctx, logCtx, chunk.EndPage-chunk.StartPage+1)
+			mu.Lock()
+			uploaded = append(uploaded, chunk)
+			if len(imageURIs) > 0 {
+				images[chunk.StartPage] = imageURIs
 			}
+			mu.Unlock()
 			return nil
 		})
 	}
-	if err := eg.Wait(); err != nil {
-		return f.handleError(ctx, logCtx, docRef, "one or more pages failed to upload", err)
+	_ = eg.Wait()
+	progress.flush(ctx, logCtx)
+	sortChunks(uploaded)
+	sortChunks(failed)
+
+	uploadedPages, failedPages := chunkPageNumbers(uploaded), chunkPageNumbers(failed)
+	summary := []firestore.Update{{Path: "uploadedPages", Value: uploadedPages}, {Path: "failedPages", Value: failedPages}}
+	if _, err := docRef.Update(ctx, summary); err != nil {
+		// The summary is only needed to backfill failed pages, which fail the document
+		// below when it cannot be recorded.
+		logCtx.Warn("Failed to record the upload summary", "error", err)
+		if len(failed) > 0 {
+			return nil, nil, f.handleError(ctx, logCtx, docRef, "failed to record the pages that failed to upload", err)
+		}
+	}
+	if len(failed) > 0 {
+		uploadErr := fmt.Errorf("%d of %d split files failed to upload: %w", len(failed), len(chunks), errors.Join(failures...))
+		if len(failedPages) > f.config.UploadProgress.MaxFailedPages || len(uploaded) == 0 {
+			return nil, nil, f.handleError(ctx, logCtx, docRef, "one or more pages failed to upload", uploadErr)
+		}
+		logCtx.Error("Some pages failed to upload; handing on the rest and leaving them for the retranslator to backfill.", "error", uploadErr, "failedPages", failedPages, "maxFailedPages", f.config.UploadProgress.MaxFailedPages)
+	}
+	if err := f.verifySplitPages(ctx, docRef.ID, uploaded); err != nil {
+		return nil, nil, f.handleError(ctx, logCtx, docRef, "uploaded pages failed verification", err)
+	}
+	if len(failed) > 0 {
+		return failed, images, nil
 	}
 	logCtx.Info("All pages uploaded successfully.")
-	return nil
+	return nil, images, nil
 }
 
-func (f *PDFSplitterFunction) triggerWorkflow(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, pageCount int) error {
-	logCtx.Info("Triggering workflow.")
-	workflowPayload := map[string]interface{}{
-		"documentId": docRef.ID,
-		"pageCount":  pageCount,
+// recordPageImages records the URIs of the images extracted from each page on page
+// records created before the pages were uploaded. A record that cannot be updated leaves
+// its page to be translated as text only, so it is only logged.
+func (f *PDFSplitterFunction) recordPageImages(ctx context.Context, logCtx *slog.Logger, docID string, images map[int][]string) {
+	for pageNumber, imageURIs := range images {
+		if err := updatePageRecord(ctx, f.firestoreClient, f.config.CollectionName, docID, pageNumber, map[string]interface{}{"imageUris": imageURIs}); err != nil {
+			logCtx.Warn("Failed to record page images; the page will be translated as text only.", "error", err, "pageNumber", pageNumber)
+		}
 	}
-	payloadBytes, err := json.Marshal(workflowPayload)
+}
+
+// verifySplitPages lists the document's split files and checks that the file of every
+// chunk is present and non-empty, so a partial upload is never handed on as complete.
+func (f *PDFSplitterFunction) verifySplitPages(ctx context.Context, docID string, chunks []pageChunk) error {
+	objects, err := f.store.Bucket(f.config.SplitPagesBucket).List(ctx, f.root(docID)+"/")
 	if err != nil {
-		return f.handleError(ctx, logCtx, docRef, "failed to marshal workflow payload", err)
+		return fmt.Errorf("failed to list split pages: %w", err)
 	}
-	req := &executionspb.CreateExecutionRequest{
-		Parent: fmt.Sprintf("projects/%s/locations/%s/workflows/%s", f.config.ProjectID, f.config.WorkflowLocation, f.config.WorkflowID),
-		Execution: &executionspb.Execution{
-			Argument: string(payloadBytes),
-		},
+	sizes := make(map[string]int64, len(objects))
+	for _, attrs := range objects {
+		sizes[attrs.Name] = attrs.Size
+	}
+
+	var missing, empty []string
+	for _, chunk := range chunks {
+		size, ok := sizes[pageRangeObjectName(f.root(docID), chunk.StartPage, chunk.EndPage, "pdf")]
+		switch {
+		case !ok:
+			missing = append(missing, describePages(chunk.StartPage, chunk.EndPage))
+		case size == 0:
+			empty = append(empty, describePages(chunk.StartPage, chunk.EndPage))
+		}
+	}
+	if len(missing) == 0 && len(empty) == 0 {
+		return nil
+	}
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, "missing "+strings.Join(missing, ", "))
+	}
+	if len(empty) > 0 {
+		problems = append(problems, "empty "+strings.Join(empty, ", "))
 	}
-	_, err = f.executionsClient.CreateExecution(ctx, req)
+	return fmt.Errorf("found %d of %d split files: %s", len(chunks)-len(missing), len(chunks), strings.Join(problems, "; "))
+}
+
+// markUploadFailedPages marks the page records of chunks that failed to upload
+// PageStatusUploadFailed, for the retranslator to backfill. The document's failedPages
+// field already lists them, so a record that cannot be updated is only logged.
+func (f *PDFSplitterFunction) markUploadFailedPages(ctx context.Context, logCtx *slog.Logger, docID string, failed []pageChunk) {
+	fields := map[string]interface{}{
+		"status":       models.PageStatusUploadFailed,
+		"errorDetails": "split file failed to upload",
+	}
+	for _, chunk := range failed {
+		if err := updatePageRangeRecords(ctx, f.firestoreClient, f.config.CollectionName, docID, chunk.StartPage, chunk.EndPage, fields); err != nil {
+			logCtx.Warn("Failed to mark pages that failed to upload", "error", err, "startPage", chunk.StartPage, "endPage", chunk.EndPage)
+		}
+	}
+}
+
+// errWorkflowNotStarted is returned by triggerWorkflow when the workflow could not be
+// started and the document was marked StatusPagesReadyWorkflowFailed instead of FAILED.
+var errWorkflowNotStarted = errors.New("workflow execution not started")
+
+// triggerWorkflow starts the processing workflow over the uploaded pages, retrying
+// failures. Once the retries are exhausted the pages are still usable, so the document
+// is marked StatusPagesReadyWorkflowFailed for the retrigger service rather than FAILED.
+// The failed chunks, which have no split file, are left out of the processing order. In
+// the Pub/Sub mode the pages are published by dispatchPages instead.
+func (f *PDFSplitterFunction) triggerWorkflow(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, pageCount int, fileHash string, sourceGeneration int64, failed []pageChunk) error {
+	order, err := f.chunkOrder(pageCount, fileHash)
 	if err != nil {
-		return f.handleError(ctx, logCtx, docRef, "failed to trigger workflow execution", err)
+		return f.handleError(ctx, logCtx, docRef, "failed to compute processing order", err)
 	}
+	if len(failed) > 0 {
+		skipped := make(map[int]bool, len(failed))
+		for _, chunk := range failed {
+			skipped[(chunk.StartPage-1)/max(f.config.ChunkSize, 1)+1] = true
+		}
+		order = slices.DeleteFunc(order, func(index int) bool { return skipped[index] })
+	}
+	if f.config.Orchestration.PubSub() {
+		return f.dispatchPages(ctx, logCtx, docRef, pageCount, order)
+	}
+	executionName, err := f.startWorkflow(ctx, logCtx, docRef.ID, pageCount, sourceGeneration, order)
+	if err != nil {
+		return f.workflowNotStarted(ctx, logCtx, docRef, err)
+	}
+	update := firestore.Update{Path: "workflowExecutionId", Value: executionName}
+	if err := gcp.AppendStatusTransition(ctx, docRef, "TRANSLATING", models.TimingStageSplitter, "", update); err != nil {
+		// The workflow is already running, so failing here would only trigger a duplicate.
+		logCtx.Error("Workflow triggered but the execution ID could not be recorded", "error", err, "executionName", executionName)
+		return nil
+	}
+	logCtx.Info("Workflow triggered.", "executionName", executionName)
 	return nil
 }
 
+// chunkOrder returns the order the workflow dispatches the document's chunks in.
+func (f *PDFSplitterFunction) chunkOrder(pageCount int, fileHash string) ([]int, error) {
+	return f.config.ProcessingOrder.Order(len(pageChunks(pageCount, f.config.ChunkSize)), fileHash)
+}
+
+// startWorkflow starts a processing workflow execution over the document's pages,
+// retrying failures, and returns the execution's name.
+func (f *PDFSplitterFunction) startWorkflow(ctx context.Context, logCtx *slog.Logger, docID string, pageCount int, sourceGeneration int64, order []int) (string, error) {
+	logCtx.Info("Triggering workflow.", "processingOrder", f.config.ProcessingOrder.Strategy, "chunkCount", len(order))
+	manifest := splitPageManifest(f.config.SplitPagesBucket, f.tenantID, docID, pageCount, f.config.ChunkSize)
+	payload, err := workflowPayload(ctx, f.store.Bucket(f.config.SplitPagesBucket), manifest, sourceGeneration, order, f.config.InlinePagesMax)
+	if err != nil {
+		return "", err
+	}
+	parent := workflowParent(f.config.ProjectID, f.config.WorkflowLocation, f.config.WorkflowID)
+	execution, err := startWorkflowExecutionWithRetry(ctx, logCtx, f.executionsClient, parent, payload, f.config.WorkflowRetry)
+	if err != nil {
+		return "", err
+	}
+	return execution.GetName(), nil
+}
+
+// workflowNotStarted marks a document whose pages are ready but whose workflow could not
+// be started StatusPagesReadyWorkflowFailed, and returns errWorkflowNotStarted.
+func (f *PDFSplitterFunction) workflowNotStarted(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, startErr error) error {
+	logCtx.Error("Failed to trigger workflow execution; pages are ready for a retrigger.", "error", startErr)
+	if err := f.updateStatus(ctx, docRef, StatusPagesReadyWorkflowFailed, fmt.Sprintf("failed to trigger workflow execution: %v", startErr)); err != nil {
+		logCtx.Error("CRITICAL: Failed to record that the workflow was not started.", "updateError", err)
+		return fmt.Errorf("failed to record workflow trigger failure: %w", err)
+	}
+	return errWorkflowNotStarted
+}
+
 func (f *PDFSplitterFunction) handleError(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, message string, originalErr error) error {
 	fullError := fmt.Sprintf("%s: %v", message, originalErr)
 	logCtx.Error(message, "error", originalErr)
-	if err := f.updateStatus(ctx, docRef, "FAILED", fullError); err != nil {
+	if err := f.updateStatus(ctx, docRef, StatusFailed, fullError); err != nil {
 		logCtx.Error("CRITICAL: Failed to update Firestore status to FAILED after a processing error.", "updateError", err)
 	}
 	return fmt.Errorf("%s", fullError)
 }
 
 func (f *PDFSplitterFunction) updateStatus(ctx context.Context, docRef *firestore.DocumentRef, status, errDetails string) error {
-	updates := []firestore.Update{
-		{Path: "status", Value: status},
-	}
+	var updates []firestore.Update
 	if errDetails != "" {
 		updates = append(updates, firestore.Update{Path: "errorDetails", Value: errDetails})
 	}
-	_, err := docRef.Update(ctx, updates)
-	return err
+	return gcp.AppendStatusTransition(ctx, docRef, status, models.TimingStageSplitter, errDetails, updates...)
 }
 
-func (f *PDFSplitterFunction) streamGCSObject(ctx context.Context, bucket, object, destPath string) error {
-	gcsReader, err := f.storageClient.Bucket(bucket).Object(object).NewReader(ctx)
+func (f *PDFSplitterFunction) streamGCSObject(ctx context.Context, bucket, object, destPath string) (err error) {
+	ctx, span := startSpan(ctx, "gcs.Download", attrGCSURI.String(fmt.Sprintf("gs://%s/%s", bucket, object)))
+	defer func() { endSpan(span, err) }()
+	gcsReader, err := f.store.Bucket(bucket).NewReader(ctx, object)
 	if err != nil {
 		return fmt.Errorf("failed to get GCS object reader for gs://%s/%s: %w", bucket, object, err)
 	}
@@ -284,62 +1027,61 @@ func (f *PDFSplitterFunction) streamGCSObject(ctx context.Context, bucket, objec
 func optimizePDF(inPath, outPath string) error {
 	cfg := model.NewDefaultConfiguration()
 	cfg.ValidationMode = model.ValidationRelaxed
-	return api.OptimizeFile(inPath, outPath, cfg)
+	return recoverPDFPanic(func() error { return api.OptimizeFile(inPath, outPath, cfg) })
 }
 
-func (f *PDFSplitterFunction) uploadFile(ctx context.Context, localPath, destObject string) error {
-	const maxRetries = 4
-	var backoff = 1 * time.Second
-	var lastErr error
-
-	for i := 0; i < maxRetries; i++ {
-		err := func() error {
-			localFileReader, err := os.Open(localPath)
-			if err != nil {
-				return fmt.Errorf("could not open local file %s: %w", localPath, err)
-			}
-			defer localFileReader.Close()
-
-			writeCtx, cancel := context.WithTimeout(ctx, time.Second*50)
-			defer cancel()
-
-			gcsWriter := f.storageClient.Bucket(f.config.SplitPagesBucket).Object(destObject).NewWriter(writeCtx)
-
-			if _, err := io.Copy(gcsWriter, localFileReader); err != nil {
-				_ = gcsWriter.Close()
-				return fmt.Errorf("io.Copy to GCS failed: %w", err)
-			}
-
-			if err := gcsWriter.Close(); err != nil {
-				return fmt.Errorf("failed to close GCS writer (finalize upload): %w", err)
-			}
-			return nil
-		}()
-
-		if err == nil {
-			return nil // Success!
-		}
+// uploadFile uploads the file at localPath to destObject in the split pages bucket,
+// retrying transient failures with gcp.DefaultRetryOptions. Each attempt reopens the file
+// and has its own deadline, and one that runs out of time is retried too.
+func (f *PDFSplitterFunction) uploadFile(ctx context.Context, localPath, destObject string, chunkBytes int) error {
+	checksum, err := fileCRC32C(localPath)
+	if err != nil {
+		return err
+	}
+	opts := objectstore.WriterOptions{SendCRC32C: true, CRC32C: checksum, ChunkSize: chunkBytes}
 
-		lastErr = err
+	retry := gcp.DefaultRetryOptions
+	retry.IsRetryable = func(err error) bool {
+		return ctx.Err() == nil && (errors.Is(err, context.DeadlineExceeded) || gcp.IsRetryableError(err))
+	}
+	retry.OnRetry = func(attempt int, backoff time.Duration, err error) {
+		gcp.RecordGCSUploadRetry(ctx)
 		slog.Warn(
 			"Upload failed, will retry.",
 			"gcsObject", destObject,
-			"attempt", i+1,
-			"maxRetries", maxRetries,
+			"attempt", attempt,
+			"maxAttempts", retry.MaxAttempts,
 			"backoff", backoff.String(),
 			"error", err,
 		)
+	}
+	err = gcp.Retry(ctx, retry, func(ctx context.Context) error {
+		localFileReader, err := os.Open(localPath)
+		if err != nil {
+			return fmt.Errorf("could not open local file %s: %w", localPath, err)
+		}
+		defer localFileReader.Close()
+
+		writeCtx, cancel := context.WithTimeout(ctx, time.Second*50)
+		defer cancel()
+
+		gcsWriter := f.store.Bucket(f.config.SplitPagesBucket).NewWriter(writeCtx, destObject, opts)
 
-		select {
-		case <-time.After(backoff):
-			backoff *= 2
-		case <-ctx.Done():
-			slog.Error("Context cancelled during backoff. Aborting retries.", "gcsObject", destObject, "error", ctx.Err())
-			return ctx.Err()
+		if _, err := io.Copy(gcsWriter, localFileReader); err != nil {
+			_ = gcsWriter.Close()
+			return fmt.Errorf("io.Copy to GCS failed: %w", err)
 		}
+
+		if err := gcsWriter.Close(); err != nil {
+			return fmt.Errorf("failed to close GCS writer (finalize upload): %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Upload failed after all retries.", "gcsObject", destObject, "error", err)
+		return fmt.Errorf("upload for %s failed: %w", destObject, err)
 	}
-	slog.Error("Upload failed after all retries.", "gcsObject", destObject, "error", lastErr)
-	return fmt.Errorf("upload for %s failed after all retries: %w", destObject, lastErr)
+	return nil
 }
 
 func calculateFileHash(filePath string) (string, error) {
@@ -354,3 +1096,18 @@ func calculateFileHash(filePath string) (string, error) {
 	}
 	return hex.EncodeToString(hash.Sum(nil)), nil
 }
+
+// fileCRC32C returns the CRC32C (Castagnoli) checksum of a local file, as Cloud Storage
+// computes it.
+func fileCRC32C(filePath string) (uint32, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("could not open local file %s: %w", filePath, err)
+	}
+	defer file.Close()
+	hash := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	if _, err := io.Copy(hash, file); err != nil {
+		return 0, fmt.Errorf("could not checksum local file %s: %w", filePath, err)
+	}
+	return hash.Sum32(), nil
+}
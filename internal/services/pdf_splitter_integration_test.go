@@ -0,0 +1,193 @@
+//go:build integration
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestConcurrentUploadsCreateOneDocument(t *testing.T) {
+	const fileHash = "0123456789abcdef0123456789abcdef"
+	const uploads = 8
+	tests := []struct {
+		name     string
+		received bool // Whether the uploader created the document first.
+	}{
+		{name: "direct uploads"},
+		{name: "uploader's document", received: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			firestoreClient, _ := testsupport.RequireEmulators(t).Clients(ctx, t)
+			collection := fmt.Sprintf("documents-%d", time.Now().UnixNano())
+			logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+			upload := &storage.ObjectAttrs{Bucket: "uploads", Name: "in/spec.pdf", Generation: 1, Size: 1024}
+			if tt.received {
+				received := models.Document{Status: StatusReceived, SourceBucket: upload.Bucket, SourceObject: upload.Name, CreatedAt: time.Now()}
+				if _, err := firestoreClient.Collection(collection).Doc(fileHash).Create(ctx, received); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f := (&PDFSplitterFunction{
+				firestoreClient: firestoreClient,
+				config:          PDFSplitterConfig{CollectionName: collection},
+			}).forUpload(&models.ProcessingProfile{}, "")
+
+			var created, duplicates int
+			var mu sync.Mutex
+			var wg sync.WaitGroup
+			for range uploads {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := f.createInitialDocument(ctx, logCtx, fileHash, fileHash, dedupeKey{}, "", upload)
+					mu.Lock()
+					defer mu.Unlock()
+					switch {
+					case err == nil:
+						created++
+					case errors.Is(err, errDuplicateUpload):
+						duplicates++
+					default:
+						t.Errorf("createInitialDocument() error = %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+			if created != 1 || duplicates != uploads-1 {
+				t.Errorf("%d uploads created a document and %d were duplicates, want 1 and %d", created, duplicates, uploads-1)
+			}
+
+			docs, err := firestoreClient.Collection(collection).Where("fileHash", "==", fileHash).Documents(ctx).GetAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(docs) != 1 {
+				t.Fatalf("%d documents record the file, want 1", len(docs))
+			}
+			docID, existing, err := f.isDuplicate(ctx, fileHash, dedupeKey{}, 0)
+			if err != nil || existing == nil || docID != fileHash {
+				t.Errorf("isDuplicate() = %q, %v, %v, want the created document", docID, existing, err)
+			}
+		})
+	}
+}
+
+func TestPartialUploadFailureBookkeeping(t *testing.T) {
+	ctx := context.Background()
+	firestoreClient, _ := testsupport.RequireEmulators(t).Clients(ctx, t)
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tests := []struct {
+		name           string
+		missing        []pageChunk // Chunks whose split file is not on disk, so fail to upload.
+		maxFailedPages int
+		wantFailed     []int
+		wantErr        bool
+	}{
+		{name: "every page uploaded", maxFailedPages: 2},
+		{name: "failures within the limit", missing: []pageChunk{{StartPage: 3, EndPage: 4}}, maxFailedPages: 2, wantFailed: []int{3, 4}},
+		{name: "failures over the limit", missing: []pageChunk{{StartPage: 1, EndPage: 2}, {StartPage: 5, EndPage: 6}}, maxFailedPages: 2, wantFailed: []int{1, 2, 5, 6}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collection := fmt.Sprintf("documents-%d", time.Now().UnixNano())
+			docRef := firestoreClient.Collection(collection).Doc("doc-1")
+			if _, err := docRef.Set(ctx, map[string]interface{}{"status": "SPLITTING"}); err != nil {
+				t.Fatal(err)
+			}
+			store := objectstore.NewMemory()
+			f := &PDFSplitterFunction{
+				firestoreClient: firestoreClient,
+				store:           store,
+				config: PDFSplitterConfig{
+					CollectionName:   collection,
+					SplitPagesBucket: "pages",
+					ChunkSize:        2,
+					UploadProgress:   UploadProgressConfig{BatchPages: 10, MaxFailedPages: tt.maxFailedPages},
+				},
+			}
+			optimized := filepath.Join(t.TempDir(), "optimized.pdf")
+			splitFileBase := optimized[:len(optimized)-len(".pdf")]
+			for _, chunk := range pageChunks(6, 2) {
+				if slices.Contains(tt.missing, chunk) {
+					continue
+				}
+				if err := os.WriteFile(localSplitPath(splitFileBase, chunk), []byte("%PDF-1.7 chunk"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			failed, _, err := f.uploadSplitPages(ctx, logCtx, docRef, optimized, 6, 0)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("uploadSplitPages() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			doc := readPipelineDocument(ctx, t, firestoreClient, collection, "doc-1")
+			var wantUploaded []int
+			for page := 1; page <= 6; page++ {
+				if !slices.Contains(tt.wantFailed, page) {
+					wantUploaded = append(wantUploaded, page)
+				}
+			}
+			if !slices.Equal(doc.FailedPages, tt.wantFailed) || !slices.Equal(doc.UploadedPages, wantUploaded) {
+				t.Errorf("document pages = uploaded %v, failed %v; want %v, %v", doc.UploadedPages, doc.FailedPages, wantUploaded, tt.wantFailed)
+			}
+			if tt.wantErr {
+				if doc.Status != StatusFailed || failed != nil {
+					t.Errorf("uploadSplitPages() = %v with status %q, want the document failed", failed, doc.Status)
+				}
+				return
+			}
+			if !slices.Equal(chunkPageNumbers(failed), tt.wantFailed) {
+				t.Errorf("uploadSplitPages() failed chunks = %v, want pages %v", failed, tt.wantFailed)
+			}
+
+			// Only the pages of the failed chunks are left for the retranslator.
+			if err := f.createPageRecords(ctx, logCtx, docRef, 6, nil); err != nil {
+				t.Fatal(err)
+			}
+			f.markUploadFailedPages(ctx, logCtx, "doc-1", failed)
+			pageSnaps, err := pagesCollection(firestoreClient, collection, "doc-1").Documents(ctx).GetAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pageSnaps) != 6 {
+				t.Fatalf("document has %d page records, want 6", len(pageSnaps))
+			}
+			for _, snap := range pageSnaps {
+				var page models.Page
+				if err := snap.DataTo(&page); err != nil {
+					t.Fatal(err)
+				}
+				want := models.PageStatusPending
+				if slices.Contains(tt.wantFailed, page.PageNumber) {
+					want = models.PageStatusUploadFailed
+				}
+				if page.Status != want || (want == models.PageStatusUploadFailed) != (page.ErrorDetails != "") {
+					t.Errorf("page %d = status %q, details %q; want %q", page.PageNumber, page.Status, page.ErrorDetails, want)
+				}
+			}
+			for _, chunk := range tt.missing {
+				if _, ok := store.Get("pages", pageRangeObjectName("doc-1", chunk.StartPage, chunk.EndPage, "pdf")); ok {
+					t.Errorf("split file of pages %d-%d uploaded, want it missing", chunk.StartPage, chunk.EndPage)
+				}
+			}
+		})
+	}
+}
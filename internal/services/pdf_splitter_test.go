@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/api/googleapi"
+)
+
+func TestSplitPageObjectNames(t *testing.T) {
+	tests := []struct {
+		tenantID           string
+		startPage, endPage int
+		wantObject         string
+		wantLocal          string
+	}{
+		{startPage: 7, endPage: 7, wantObject: "doc-1/00007.pdf", wantLocal: "/tmp/doc_7.pdf"},
+		{startPage: 11, endPage: 15, wantObject: "doc-1/00011-00015.pdf", wantLocal: "/tmp/doc_11-15.pdf"},
+		{startPage: 123456, endPage: 123456, wantObject: "doc-1/123456.pdf", wantLocal: "/tmp/doc_123456.pdf"},
+		{tenantID: "acme", startPage: 1, endPage: 1, wantObject: "acme/doc-1/00001.pdf", wantLocal: "/tmp/doc_1.pdf"},
+	}
+	for _, tt := range tests {
+		f := (&PDFSplitterFunction{}).forUpload(&models.ProcessingProfile{}, tt.tenantID)
+		if got := pageRangeObjectName(f.root("doc-1"), tt.startPage, tt.endPage, "pdf"); got != tt.wantObject {
+			t.Errorf("object name of pages %d-%d = %q, want %q", tt.startPage, tt.endPage, got, tt.wantObject)
+		}
+		if got := localSplitPath("/tmp/doc", pageChunk{StartPage: tt.startPage, EndPage: tt.endPage}); got != tt.wantLocal {
+			t.Errorf("local split path of pages %d-%d = %q, want %q", tt.startPage, tt.endPage, got, tt.wantLocal)
+		}
+	}
+}
+
+func TestDedupeDocumentID(t *testing.T) {
+	const fileHash = "0123456789abcdef"
+	global := DedupeConfig{Scope: models.DedupeScopeGlobal}.keyFor("uploads", "acme")
+	if got := global.documentID(fileHash); got != fileHash {
+		t.Errorf("global document ID = %q, want the file hash", got)
+	}
+	bucket := DedupeConfig{Scope: models.DedupeScopeBucket}
+	first, again := bucket.keyFor("uploads-a", "acme").documentID(fileHash), bucket.keyFor("uploads-a", "other").documentID(fileHash)
+	if first != again {
+		t.Errorf("bucket-scoped IDs of one bucket differ: %q, %q", first, again)
+	}
+	if other := bucket.keyFor("uploads-b", "acme").documentID(fileHash); other == first {
+		t.Errorf("bucket-scoped IDs of two buckets are both %q", first)
+	}
+	tenant := DedupeConfig{Scope: models.DedupeScopeTenant}
+	if got := tenant.keyFor("uploads", "").documentID(fileHash); got != fileHash {
+		t.Errorf("tenant-scoped ID without a tenant = %q, want the file hash", got)
+	}
+
+	superseding := supersedingDocumentID(global, fileHash, fileHash)
+	if superseding == fileHash || superseding != supersedingDocumentID(global, fileHash, fileHash) {
+		t.Errorf("superseding document ID = %q, want a stable ID other than the file hash", superseding)
+	}
+}
+
+func TestDedupeExpired(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	config := DedupeConfig{TTL: 30 * 24 * time.Hour}
+	tests := []struct {
+		name   string
+		config DedupeConfig
+		doc    models.Document
+		want   bool
+	}{
+		{name: "old and complete", config: config, doc: models.Document{Status: StatusComplete, CreatedAt: now.AddDate(0, -2, 0)}, want: true},
+		{name: "recent", config: config, doc: models.Document{Status: StatusComplete, CreatedAt: now.AddDate(0, 0, -1)}},
+		{name: "old but failed", config: config, doc: models.Document{Status: StatusFailed, CreatedAt: now.AddDate(0, -2, 0)}},
+		{name: "no TTL", doc: models.Document{Status: StatusComplete, CreatedAt: now.AddDate(-5, 0, 0)}},
+	}
+	for _, tt := range tests {
+		if got := tt.config.expired(&tt.doc, now); got != tt.want {
+			t.Errorf("%s: expired() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAwaitsUpload(t *testing.T) {
+	received := models.Document{Status: StatusReceived, SourceBucket: "uploads", SourceObject: "in/spec.pdf"}
+	if !awaitsUpload(&received, "uploads", "in/spec.pdf") {
+		t.Errorf("awaitsUpload() of the uploader's document = false, want true")
+	}
+	if awaitsUpload(&received, "uploads", "in/other.pdf") {
+		t.Errorf("awaitsUpload() of another object = true, want false")
+	}
+	claimed := received
+	claimed.Status = "SPLITTING"
+	if awaitsUpload(&claimed, "uploads", "in/spec.pdf") {
+		t.Errorf("awaitsUpload() of a claimed document = true, want false")
+	}
+}
+
+func TestUploadFileRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		writeErrs []error
+		wantErr   bool
+	}{
+		{name: "first attempt"},
+		{name: "transient failures are retried", writeErrs: []error{&googleapi.Error{Code: http.StatusServiceUnavailable}, &googleapi.Error{Code: http.StatusTooManyRequests}}},
+		{name: "permanent failures are not retried", writeErrs: []error{&googleapi.Error{Code: http.StatusForbidden}}, wantErr: true},
+	}
+	localPath := filepath.Join(t.TempDir(), "page.pdf")
+	if err := os.WriteFile(localPath, []byte("%PDF-1.7 page"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := objectstore.NewMemory()
+			store.FailWrites(tt.writeErrs...)
+			f := &PDFSplitterFunction{store: store, config: PDFSplitterConfig{SplitPagesBucket: "pages"}}
+
+			err := f.uploadFile(context.Background(), localPath, "doc-1/00001.pdf", 0)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("uploadFile() error = %v, want error = %v", err, tt.wantErr)
+			}
+			data, ok := store.Get("pages", "doc-1/00001.pdf")
+			if ok == tt.wantErr {
+				t.Fatalf("page uploaded = %v, want %v", ok, !tt.wantErr)
+			}
+			if ok && string(data) != "%PDF-1.7 page" {
+				t.Errorf("uploaded content = %q", data)
+			}
+		})
+	}
+}
+
+func TestVerifySplitPages(t *testing.T) {
+	chunks := []pageChunk{{StartPage: 1, EndPage: 1}, {StartPage: 2, EndPage: 2}, {StartPage: 3, EndPage: 4}}
+	tests := []struct {
+		name    string
+		objects map[string]string
+		wantErr string
+	}{
+		{
+			name:    "every page",
+			objects: map[string]string{"doc-1/00001.pdf": "%PDF", "doc-1/00002.pdf": "%PDF", "doc-1/00003-00004.pdf": "%PDF"},
+		},
+		{
+			name:    "missing pages",
+			objects: map[string]string{"doc-1/00001.pdf": "%PDF", "doc-10/00002.pdf": "%PDF", "doc-1/00003.pdf": "%PDF"},
+			wantErr: "found 1 of 3 split files: missing page 2, pages 3-4",
+		},
+		{
+			name:    "empty page",
+			objects: map[string]string{"doc-1/00001.pdf": "%PDF", "doc-1/00002.pdf": "", "doc-1/00003-00004.pdf": "%PDF"},
+			wantErr: "found 3 of 3 split files: empty page 2",
+		},
+		{
+			name:    "missing and empty pages",
+			objects: map[string]string{"doc-1/00001.pdf": ""},
+			wantErr: "found 1 of 3 split files: missing page 2, pages 3-4; empty page 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := objectstore.NewMemory()
+			for name, content := range tt.objects {
+				store.Put("pages", name, []byte(content), nil)
+			}
+			f := &PDFSplitterFunction{store: store, config: PDFSplitterConfig{SplitPagesBucket: "pages"}}
+
+			err := f.verifySplitPages(context.Background(), "doc-1", chunks)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verifySplitPages() error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("verifySplitPages() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUploadFileSendsChecksum(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "page.pdf")
+	if err := os.WriteFile(localPath, []byte("123456789"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if checksum, err := fileCRC32C(localPath); err != nil || checksum != 0xe3069283 {
+		t.Fatalf("fileCRC32C() = %08x, %v, want e3069283", checksum, err)
+	}
+	store := objectstore.NewMemory()
+	f := &PDFSplitterFunction{store: store, config: PDFSplitterConfig{SplitPagesBucket: "pages"}}
+	if err := f.uploadFile(context.Background(), localPath, "doc-1/00001.pdf", 0); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := store.Bucket("pages").Attrs(context.Background(), "doc-1/00001.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.CRC32C != 0xe3069283 {
+		t.Errorf("stored CRC32C = %08x, want e3069283", attrs.CRC32C)
+	}
+}
+
+func TestUploadLeaseKey(t *testing.T) {
+	first := uploadLeaseKey("uploads", "in/spec.pdf", 1)
+	if want := "gs://uploads/in/spec.pdf#1"; first != want {
+		t.Errorf("uploadLeaseKey() = %q, want %q", first, want)
+	}
+	if overwritten := uploadLeaseKey("uploads", "in/spec.pdf", 2); overwritten == first {
+		t.Errorf("uploadLeaseKey() is the same for two generations of an object: %q", first)
+	}
+}
+
+func TestLoadUploadLeaseConfig(t *testing.T) {
+	tests := []struct {
+		ttl     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{ttl: "", want: 10 * time.Minute},
+		{ttl: "90s", want: 90 * time.Second},
+		{ttl: "0s", wantErr: true},
+		{ttl: "-1m", wantErr: true},
+		{ttl: "ten minutes", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ttl, func(t *testing.T) {
+			t.Setenv("UPLOAD_LEASE_TTL", tt.ttl)
+			if tt.ttl == "" {
+				os.Unsetenv("UPLOAD_LEASE_TTL")
+			}
+			config, err := loadUploadLeaseConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadUploadLeaseConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && config.TTL != tt.want {
+				t.Errorf("TTL = %v, want %v", config.TTL, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+//go:build integration
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/vertexai/genai"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+// modelFunc is a fake model whose response text is computed from the parts it is sent.
+type modelFunc func(parts []genai.Part) string
+
+func (m modelFunc) GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	return testsupport.TextResponse(m(parts)), nil
+}
+
+// pipelineBuckets are the buckets of one run of the pipeline.
+type pipelineBuckets struct {
+	uploads, splitPages, translated, master, cleaned, sections string
+}
+
+// setPipelineEnv points every stage's configuration at the test's collection and buckets.
+func setPipelineEnv(t *testing.T, collection string, buckets pipelineBuckets) {
+	t.Helper()
+	for key, value := range map[string]string{
+		"PROJECT_ID":                 testsupport.EmulatorProjectID,
+		"GOOGLE_CLOUD_PROJECT_ID":    testsupport.EmulatorProjectID,
+		"FIRESTORE_COLLECTION":       collection,
+		"LEASE_COLLECTION":           collection + "-leases",
+		"TENANT_COLLECTION":          collection + "-tenants",
+		"UPLOADS_BUCKET":             buckets.uploads,
+		"SPLIT_PAGES_BUCKET":         buckets.splitPages,
+		"TRANSLATED_MARKDOWN_BUCKET": buckets.translated,
+		"AGGREGATED_MARKDOWN_BUCKET": buckets.master,
+		"CLEANED_MARKDOWN_BUCKET":    buckets.cleaned,
+		"FINAL_SECTIONS_BUCKET":      buckets.sections,
+	} {
+		t.Setenv(key, value)
+	}
+}
+
+// TestPipeline runs an upload through every stage in the order the workflow calls them,
+// against the emulators, with fake models standing in for Gemini.
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+	emulators := testsupport.RequireEmulators(t)
+	firestoreClient, storageClient := emulators.Clients(ctx, t)
+	created := emulators.CreateBuckets(ctx, t, storageClient, "uploads", "split-pages", "translated", "master", "cleaned", "sections")
+	buckets := pipelineBuckets{created[0], created[1], created[2], created[3], created[4], created[5]}
+	collection := fmt.Sprintf("documents-%d", time.Now().UnixNano())
+	setPipelineEnv(t, collection, buckets)
+	store := objectstore.NewGCS(storageClient)
+	workflows := testsupport.NewFakeWorkflows(t)
+
+	// --- 1. Upload a three-page PDF and split it ---
+	upload := storageClient.Bucket(buckets.uploads).Object("in/pump-standard.pdf")
+	w := upload.NewWriter(ctx)
+	w.ContentType = "application/pdf"
+	if _, err := w.Write(testsupport.FixturePDF(3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	uploadAttrs := w.Attrs()
+
+	splitterConfig, err := loadPDFSplitterConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	splitter := &PDFSplitterFunction{
+		store:            store,
+		firestoreClient:  firestoreClient,
+		executionsClient: workflows.Client(ctx, t),
+		cancellation:     NewCancellationChecker(firestoreClient, collection, 0),
+		config:           *splitterConfig,
+	}
+	if err := splitter.Process(ctx, GCSEvent{
+		Bucket:      buckets.uploads,
+		Name:        uploadAttrs.Name,
+		Generation:  uploadAttrs.Generation,
+		Size:        uploadAttrs.Size,
+		ContentType: uploadAttrs.ContentType,
+	}); err != nil {
+		t.Fatalf("splitter Process() error = %v", err)
+	}
+
+	executions := workflows.Requests()
+	if len(executions) != 1 {
+		t.Fatalf("splitter started %d workflow executions, want 1", len(executions))
+	}
+	var payload models.WorkflowPayload
+	if err := json.Unmarshal([]byte(executions[0].GetExecution().GetArgument()), &payload); err != nil {
+		t.Fatalf("workflow argument is not a payload: %v", err)
+	}
+	if payload.PageCount != 3 || len(payload.Pages) != 3 || !slices.Equal(payload.ProcessingOrder, []int{1, 2, 3}) {
+		t.Fatalf("workflow payload = %+v, want 3 pages in order", payload)
+	}
+	docID := payload.DocumentID
+	doc := readPipelineDocument(ctx, t, firestoreClient, collection, docID)
+	if doc.Status != "TRANSLATING" || doc.WorkflowExecutionID != testsupport.ExecutionID {
+		t.Errorf("after splitting, document status = %q with execution %q, want TRANSLATING with %q", doc.Status, doc.WorkflowExecutionID, testsupport.ExecutionID)
+	}
+	for _, page := range payload.Pages {
+		if _, object, err := gcp.ParseGCSURI(page.GCSUri); err != nil || !strings.HasPrefix(object, docID+"/") {
+			t.Errorf("page %d split file %q is not under the document's prefix", page.PageNumber, page.GCSUri)
+		} else if _, err := storageClient.Bucket(buckets.splitPages).Object(object).Attrs(ctx); err != nil {
+			t.Errorf("page %d split file %s: %v", page.PageNumber, page.GCSUri, err)
+		}
+	}
+
+	// --- 2. Translate each page ---
+	pageText := map[int]string{
+		1: "# 1 Scope\n\nThis standard covers centrifugal pumps.",
+		2: "## 1.1 Exclusions\n\nIt does not cover positive displacement pumps.",
+		3: "# 2 Materials\n\nCasings are made of cast iron.",
+	}
+	translations := make(map[string]string, len(payload.Pages))
+	for _, page := range payload.Pages {
+		translations[page.GCSUri] = pageText[page.PageNumber]
+	}
+	translatorConfig, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	translator := &TranslatorFunction{
+		storageClient:   storageClient,
+		firestoreClient: firestoreClient,
+		profileModels: map[string]gcp.ContentGenerator{gcp.PromptProfileDefault: modelFunc(func(parts []genai.Part) string {
+			return translations[parts[0].(genai.FileData).FileURI]
+		})},
+		profiles:     NewProfileResolver(firestoreClient, collection, time.Minute),
+		cancellation: NewCancellationChecker(firestoreClient, collection, translatorConfig.CancelCheckTTL),
+		config:       *translatorConfig,
+	}
+	for _, index := range payload.ProcessingOrder {
+		page := payload.Pages[index-1]
+		res, err := translator.Process(ctx, testsupport.TranslatorRequest(docID, page.PageNumber, page.GCSUri))
+		if err != nil {
+			t.Fatalf("translator Process() of page %d error = %v", page.PageNumber, err)
+		}
+		if res.Status != "success" {
+			t.Errorf("page %d translation status = %q, want success", page.PageNumber, res.Status)
+		}
+	}
+	pageSnaps, err := pagesCollection(firestoreClient, collection, docID).Documents(ctx).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pageSnaps) != 3 {
+		t.Errorf("document has %d page records, want 3", len(pageSnaps))
+	}
+	for _, snap := range pageSnaps {
+		var page models.Page
+		if err := snap.DataTo(&page); err != nil {
+			t.Fatal(err)
+		}
+		if page.Status != models.PageStatusTranslated {
+			t.Errorf("page %d status = %q, want %q", page.PageNumber, page.Status, models.PageStatusTranslated)
+		}
+	}
+
+	// --- 3. Aggregate, clean and split the document into sections ---
+	aggregatorConfig, err := loadAggregatorConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aggregator := &AggregatorFunction{store: store, firestoreClient: firestoreClient, config: *aggregatorConfig}
+	aggregated, err := aggregator.Process(ctx, testsupport.AggregatorRequest(docID))
+	if err != nil {
+		t.Fatalf("aggregator Process() error = %v", err)
+	}
+
+	cleanerConfig, err := loadCleanerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleaner := &CleanerFunction{
+		storageClient:   storageClient,
+		firestoreClient: firestoreClient,
+		// The master file is already clean, so the model returns it as it is.
+		model:    modelFunc(func(parts []genai.Part) string { return string(parts[0].(genai.Text)) }),
+		profiles: NewProfileResolver(firestoreClient, collection, time.Minute),
+		config:   *cleanerConfig,
+	}
+	cleaned, err := cleaner.Process(ctx, testsupport.CleanerRequest(docID, aggregated.MasterGCSUri))
+	if err != nil {
+		t.Fatalf("cleaner Process() error = %v", err)
+	}
+
+	sectionConfig, err := loadSectionSplitterConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sectionSplitter := &SectionSplitterFunction{
+		storageClient:   storageClient,
+		firestoreClient: firestoreClient,
+		// The model splits the markdown at its headings, as Gemini is prompted to.
+		model: modelFunc(func(parts []genai.Part) string {
+			data, _ := json.Marshal(splitSectionsByHeaders(string(parts[0].(genai.Text))))
+			return string(data)
+		}),
+		store:    store,
+		profiles: NewProfileResolver(firestoreClient, collection, time.Minute),
+		config:   *sectionConfig,
+	}
+	split, err := sectionSplitter.Process(ctx, testsupport.SectionSplitterRequest(docID, cleaned.CleanedGCSUri))
+	if err != nil {
+		t.Fatalf("section splitter Process() error = %v", err)
+	}
+
+	// --- 4. Check the sections and the document's history ---
+	if split.Status != "success" || split.SectionCount != 3 {
+		t.Fatalf("section splitter = %+v, want 3 sections split by the model", split)
+	}
+	want := []struct {
+		title     string
+		startPage int
+		content   string
+	}{
+		{title: "1 Scope", startPage: 1, content: "This standard covers centrifugal pumps."},
+		{title: "1.1 Exclusions", startPage: 2, content: "It does not cover positive displacement pumps."},
+		{title: "2 Materials", startPage: 3, content: "Casings are made of cast iron."},
+	}
+	for i, section := range split.Sections {
+		if section.Title != want[i].title || section.StartPage != want[i].startPage || section.EndPage != want[i].startPage {
+			t.Errorf("section %d = %q, pages %d-%d, want %q on page %d", i+1, section.Title, section.StartPage, section.EndPage, want[i].title, want[i].startPage)
+		}
+		content, err := readMarkdown(ctx, storageClient, section.GCSUri)
+		if err != nil {
+			t.Errorf("section %q: %v", section.Title, err)
+			continue
+		}
+		if !strings.Contains(content, want[i].content) || strings.Contains(content, "<!-- page:") {
+			t.Errorf("section %q content = %q, want its page's text without page anchors", section.Title, content)
+		}
+	}
+
+	doc = readPipelineDocument(ctx, t, firestoreClient, collection, docID)
+	if doc.Status != StatusComplete {
+		t.Errorf("document status = %q, want %q", doc.Status, StatusComplete)
+	}
+	var history []string
+	for _, transition := range doc.StatusHistory {
+		history = append(history, transition.Status)
+	}
+	wantHistory := []string{"SPLITTING", "TRANSLATING", StatusAggregating, StatusCleaning, StatusSplittingSections, StatusComplete}
+	if !isSubsequence(wantHistory, history) {
+		t.Errorf("status history = %v, want it to pass through %v in order", history, wantHistory)
+	}
+}
+
+// readPipelineDocument reads a document record, failing the test if it cannot.
+func readPipelineDocument(ctx context.Context, t *testing.T, client *firestore.Client, collection, docID string) models.Document {
+	t.Helper()
+	snap, err := client.Collection(collection).Doc(docID).Get(ctx)
+	if err != nil {
+		t.Fatalf("failed to read document %s: %v", docID, err)
+	}
+	var doc models.Document
+	if err := snap.DataTo(&doc); err != nil {
+		t.Fatalf("failed to decode document %s: %v", docID, err)
+	}
+	return doc
+}
+
+// isSubsequence reports whether want appears in got in order, not necessarily adjacent.
+func isSubsequence(want, got []string) bool {
+	i := 0
+	for _, status := range got {
+		if i < len(want) && status == want[i] {
+			i++
+		}
+	}
+	return i == len(want)
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand"
+	"slices"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Processing order strategies for the workflow's per-page fan-out.
+const (
+	ProcessingOrderSequential = "sequential"
+	ProcessingOrderStripe     = "stripe"
+	ProcessingOrderShuffle    = "shuffle"
+)
+
+// processingOrder returns the 1-based page numbers of a document in the order the
+// workflow should dispatch them. "stripe" interleaves stripeCount contiguous ranges so
+// the tail of a large document is not always dispatched last; "shuffle" is a seeded
+// permutation. Both are deterministic for the same inputs, so a retried trigger
+// produces the same order.
+func processingOrder(pageCount int, strategy string, stripeCount int, seed int64) ([]int, error) {
+	order := make([]int, 0, pageCount)
+	switch strategy {
+	case "", ProcessingOrderSequential:
+		for page := 1; page <= pageCount; page++ {
+			order = append(order, page)
+		}
+	case ProcessingOrderStripe:
+		if stripeCount < 1 {
+			return nil, fmt.Errorf("stripe count must be at least 1, got %d", stripeCount)
+		}
+		stripeLen := (pageCount + stripeCount - 1) / stripeCount
+		for offset := 0; offset < stripeLen; offset++ {
+			for start := 1; start <= pageCount; start += stripeLen {
+				if page := start + offset; page < start+stripeLen && page <= pageCount {
+					order = append(order, page)
+				}
+			}
+		}
+	case ProcessingOrderShuffle:
+		for _, idx := range rand.New(rand.NewSource(seed)).Perm(pageCount) {
+			order = append(order, idx+1)
+		}
+	default:
+		return nil, fmt.Errorf("unknown processing order %q", strategy)
+	}
+	return order, nil
+}
+
+// prioritizeByAttempts reorders order, over 1-based indices into units, so that the units
+// whose pages were attempted least, by attempts keyed by page number, go first. A unit
+// counts as attempted as often as its most attempted page. Units attempted equally often
+// keep their order, so the suggested order still spreads them over the document.
+func prioritizeByAttempts(order []int, units []pageChunk, attempts map[int]int) []int {
+	unitAttempts := func(unit int) int {
+		most := 0
+		if unit >= 1 && unit <= len(units) {
+			for page := units[unit-1].StartPage; page <= units[unit-1].EndPage; page++ {
+				most = max(most, attempts[page])
+			}
+		}
+		return most
+	}
+	prioritized := slices.Clone(order)
+	slices.SortStableFunc(prioritized, func(a, b int) int {
+		return cmp.Compare(unitAttempts(a), unitAttempts(b))
+	})
+	return prioritized
+}
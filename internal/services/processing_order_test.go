@@ -0,0 +1,122 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+func TestProcessingOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		stripes  int
+		want     []int
+	}{
+		{name: "sequential", strategy: ProcessingOrderSequential, want: []int{1, 2, 3, 4, 5, 6, 7}},
+		{name: "default", want: []int{1, 2, 3, 4, 5, 6, 7}},
+		{name: "stripes", strategy: ProcessingOrderStripe, stripes: 3, want: []int{1, 4, 7, 2, 5, 3, 6}},
+		{name: "one stripe", strategy: ProcessingOrderStripe, stripes: 1, want: []int{1, 2, 3, 4, 5, 6, 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := processingOrder(7, tt.strategy, tt.stripes, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("processingOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessingOrderShuffleIsDeterministic(t *testing.T) {
+	first, err := processingOrder(50, ProcessingOrderShuffle, 0, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := processingOrder(50, ProcessingOrderShuffle, 0, 42)
+	if !reflect.DeepEqual(first, again) {
+		t.Errorf("shuffles with the same seed differ:\n%v\n%v", first, again)
+	}
+	other, _ := processingOrder(50, ProcessingOrderShuffle, 0, 43)
+	if reflect.DeepEqual(first, other) {
+		t.Errorf("shuffles with different seeds are the same: %v", first)
+	}
+	seen := make(map[int]bool)
+	for _, page := range first {
+		seen[page] = true
+	}
+	if len(seen) != 50 {
+		t.Errorf("shuffle holds %d distinct pages, want 50", len(seen))
+	}
+}
+
+func TestPrioritizeByAttempts(t *testing.T) {
+	pages := pageChunks(6, 1)
+	tests := []struct {
+		name     string
+		order    []int
+		units    []pageChunk
+		attempts map[int]int
+		want     []int
+	}{
+		{
+			name:  "no attempts keeps the order",
+			order: []int{1, 4, 2, 5, 3, 6},
+			units: pages,
+			want:  []int{1, 4, 2, 5, 3, 6},
+		},
+		{
+			name:     "fewest attempts first",
+			order:    []int{1, 2, 3, 4, 5, 6},
+			units:    pages,
+			attempts: map[int]int{1: 3, 2: 3, 3: 1, 4: 2},
+			want:     []int{5, 6, 3, 4, 1, 2},
+		},
+		{
+			name:     "ties keep the suggested order",
+			order:    []int{1, 4, 2, 5, 3, 6},
+			units:    pages,
+			attempts: map[int]int{1: 1, 2: 1, 3: 1},
+			want:     []int{4, 5, 6, 1, 2, 3},
+		},
+		{
+			name:     "chunks count their most attempted page",
+			order:    []int{1, 2, 3},
+			units:    pageChunks(6, 2),
+			attempts: map[int]int{1: 1, 2: 4, 3: 2, 4: 2, 6: 1},
+			want:     []int{3, 2, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := prioritizeByAttempts(tt.order, tt.units, tt.attempts)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("prioritizeByAttempts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepairOrderOfChapters(t *testing.T) {
+	doc := &models.Document{
+		PageCount: 9,
+		SplitMode: models.SplitModeBookmark,
+		Chapters: []models.Chapter{
+			{Index: 1, StartPage: 1, EndPage: 2},
+			{Index: 2, StartPage: 3, EndPage: 7},
+			{Index: 3, StartPage: 8, EndPage: 9},
+		},
+	}
+	config := ProcessingOrderConfig{Strategy: ProcessingOrderSequential}
+	got, err := config.RepairOrder(doc, map[int]int{1: 2, 5: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RepairOrder() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// processingProfileMetadataKey is the custom metadata key uploaders set to give the
+// document's processing profile as JSON, sent as the x-goog-meta-processing-profile header.
+const processingProfileMetadataKey = "processing-profile"
+
+// sectionSplitStatusSkipped is the section splitter's status for a document whose
+// processing profile skips section splitting.
+const sectionSplitStatusSkipped = "skipped"
+
+// ErrProcessingProfileRejected is returned for a stored processing profile that fails
+// validation.
+var ErrProcessingProfileRejected = errors.New("processing profile rejected")
+
+// validateProcessingProfile checks every field of profile, returning all problems found
+// joined into a single error.
+func validateProcessingProfile(profile *models.ProcessingProfile) error {
+	var problems []string
+	if profile.ChunkSize < 0 {
+		problems = append(problems, fmt.Sprintf("chunkSize %d must not be negative", profile.ChunkSize))
+	}
+	if _, ok := gcp.TranslatorPromptProfiles[profile.PromptProfile]; profile.PromptProfile != "" && !ok {
+		names := make([]string, 0, len(gcp.TranslatorPromptProfiles))
+		for name := range gcp.TranslatorPromptProfiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		problems = append(problems, fmt.Sprintf("promptProfile %q must be one of %s", profile.PromptProfile, strings.Join(names, ", ")))
+	}
+	switch profile.CleanerMode {
+	case "", CleanerModeLLM, CleanerModeDeterministic, CleanerModeHybrid:
+	default:
+		problems = append(problems, fmt.Sprintf("cleanerMode %q must be %q, %q or %q", profile.CleanerMode, CleanerModeLLM, CleanerModeDeterministic, CleanerModeHybrid))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid processing profile: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// parseProcessingProfile decodes and validates a processing profile given as JSON.
+// Unknown fields are rejected, so a misspelt setting is not silently ignored.
+func parseProcessingProfile(data string) (*models.ProcessingProfile, error) {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var profile models.ProcessingProfile
+	err := decoder.Decode(&profile)
+	if err == nil && decoder.More() {
+		err = errors.New("unexpected data after the JSON object")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid processing profile: %w", err)
+	}
+	if err := validateProcessingProfile(&profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// profileFromMetadata returns the processing profile of an upload, or the default profile
+// of a document split in chunks of chunkSize pages when it has none. An invalid profile is
+// logged and replaced by the default rather than failing the upload. The chunk size is
+// always filled in, so the profile records how the document was split.
+func profileFromMetadata(logCtx *slog.Logger, metadata map[string]string, chunkSize int) *models.ProcessingProfile {
+	profile := &models.ProcessingProfile{}
+	if value := strings.TrimSpace(metadata[processingProfileMetadataKey]); value != "" {
+		parsed, err := parseProcessingProfile(value)
+		if err != nil {
+			logCtx.Warn("Ignoring invalid processing profile metadata; using the default.", "error", err)
+		} else {
+			profile = parsed
+		}
+	}
+	if profile.ChunkSize == 0 {
+		profile.ChunkSize = chunkSize
+	}
+	return profile
+}
+
+type cachedProfile struct {
+	profile  models.ProcessingProfile
+	err      error
+	loadedAt time.Time
+}
+
+// ProfileResolver reads documents' processing profiles from Firestore and caches them for
+// a short TTL, so a stage called for every page reads the profile once per document.
+type ProfileResolver struct {
+	firestoreClient *firestore.Client
+	collection      string
+	ttl             time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedProfile
+}
+
+// NewProfileResolver creates a ProfileResolver over the documents collection.
+func NewProfileResolver(firestoreClient *firestore.Client, collection string, ttl time.Duration) *ProfileResolver {
+	return &ProfileResolver{
+		firestoreClient: firestoreClient,
+		collection:      collection,
+		ttl:             ttl,
+		cache:           make(map[string]cachedProfile),
+	}
+}
+
+// Resolve returns the processing profile of a document. A document without one has the
+// zero profile, which leaves every stage's defaults in effect. A stored profile that fails
+// validation yields an error wrapping ErrProcessingProfileRejected.
+func (r *ProfileResolver) Resolve(ctx context.Context, docID string) (models.ProcessingProfile, error) {
+	r.mu.Lock()
+	entry, ok := r.cache[docID]
+	r.mu.Unlock()
+	if ok && time.Since(entry.loadedAt) < r.ttl {
+		return entry.profile, entry.err
+	}
+
+	profile, err := r.fetch(ctx, docID)
+	if err == nil {
+		if verr := validateProcessingProfile(&profile); verr != nil {
+			profile, err = models.ProcessingProfile{}, fmt.Errorf("%w: %v", ErrProcessingProfileRejected, verr)
+		}
+	}
+	// Transient Firestore errors are not cached; rejected profiles are.
+	if err == nil || errors.Is(err, ErrProcessingProfileRejected) {
+		r.mu.Lock()
+		r.cache[docID] = cachedProfile{profile: profile, err: err, loadedAt: time.Now()}
+		r.mu.Unlock()
+	}
+	return profile, err
+}
+
+// ResolveOrDefault returns the document's processing profile, or the zero profile when it
+// cannot be resolved. A stage can always run with its own defaults, so the problem is only
+// logged.
+func (r *ProfileResolver) ResolveOrDefault(ctx context.Context, logCtx *slog.Logger, docID string) models.ProcessingProfile {
+	profile, err := r.Resolve(ctx, docID)
+	if err != nil {
+		logCtx.Warn("Failed to resolve processing profile; using the stage defaults.", "error", err)
+		return models.ProcessingProfile{}
+	}
+	return profile
+}
+
+// Invalidate drops a document from the cache so the next Resolve re-reads Firestore.
+func (r *ProfileResolver) Invalidate(docID string) {
+	r.mu.Lock()
+	delete(r.cache, docID)
+	r.mu.Unlock()
+}
+
+func (r *ProfileResolver) fetch(ctx context.Context, docID string) (models.ProcessingProfile, error) {
+	snap, err := r.firestoreClient.Collection(r.collection).Doc(docID).Get(ctx)
+	if err != nil {
+		return models.ProcessingProfile{}, fmt.Errorf("failed to read document %s: %w", docID, err)
+	}
+	var doc models.Document
+	if err := snap.DataTo(&doc); err != nil {
+		return models.ProcessingProfile{}, fmt.Errorf("failed to decode document %s: %w", docID, err)
+	}
+	if doc.ProcessingProfile == nil {
+		return models.ProcessingProfile{}, nil
+	}
+	return *doc.ProcessingProfile, nil
+}
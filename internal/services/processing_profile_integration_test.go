@@ -0,0 +1,90 @@
+//go:build integration
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestProfileResolverReadsStoredProfiles(t *testing.T) {
+	ctx := context.Background()
+	firestoreClient, _ := testsupport.RequireEmulators(t).Clients(ctx, t)
+	collection := fmt.Sprintf("documents-%d", time.Now().UnixNano())
+	docs := map[string]map[string]interface{}{
+		"doc-profile":  {"processingProfile": map[string]interface{}{"cleanerMode": CleanerModeHybrid, "skipSections": true}},
+		"doc-none":     {"status": "TRANSLATING"},
+		"doc-rejected": {"processingProfile": map[string]interface{}{"cleanerMode": "fast"}},
+	}
+	for docID, data := range docs {
+		if _, err := firestoreClient.Collection(collection).Doc(docID).Set(ctx, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewProfileResolver(firestoreClient, collection, time.Hour)
+
+	if got, err := r.Resolve(ctx, "doc-profile"); err != nil || got != (models.ProcessingProfile{CleanerMode: CleanerModeHybrid, SkipSections: true}) {
+		t.Errorf("Resolve() = %+v, %v; want the stored profile", got, err)
+	}
+	if got, err := r.Resolve(ctx, "doc-none"); err != nil || got != (models.ProcessingProfile{}) {
+		t.Errorf("Resolve() without a profile = %+v, %v; want the zero profile", got, err)
+	}
+	if _, err := r.Resolve(ctx, "doc-rejected"); !errors.Is(err, ErrProcessingProfileRejected) {
+		t.Errorf("Resolve() of an invalid profile = %v, want ErrProcessingProfileRejected", err)
+	}
+
+	// A changed profile is read again only once the document is invalidated.
+	if _, err := firestoreClient.Collection(collection).Doc("doc-profile").Set(ctx, map[string]interface{}{"processingProfile": map[string]interface{}{}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := r.Resolve(ctx, "doc-profile"); !got.SkipSections {
+		t.Errorf("Resolve() = %+v, want the cached profile", got)
+	}
+	r.Invalidate("doc-profile")
+	if got, _ := r.Resolve(ctx, "doc-profile"); got != (models.ProcessingProfile{}) {
+		t.Errorf("Resolve() after Invalidate = %+v, want the changed profile", got)
+	}
+}
+
+func TestSectionSplitterSkipsByProfile(t *testing.T) {
+	ctx := context.Background()
+	firestoreClient, _ := testsupport.RequireEmulators(t).Clients(ctx, t)
+	collection := fmt.Sprintf("documents-%d", time.Now().UnixNano())
+	if _, err := firestoreClient.Collection(collection).Doc("doc-1").Set(ctx, map[string]interface{}{
+		"status":              "CLEANING",
+		"workflowExecutionId": testsupport.ExecutionID,
+		"processingProfile":   map[string]interface{}{"skipSections": true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	model := testsupport.NewFakeContentGenerator()
+	f := &SectionSplitterFunction{
+		firestoreClient: firestoreClient,
+		model:           model,
+		profiles:        NewProfileResolver(firestoreClient, collection, time.Minute),
+		config: SectionSplitterConfig{
+			CollectionName: collection,
+			Buckets:        ArtifactBuckets{CleanedMarkdown: "cleaned"},
+		},
+	}
+
+	res, err := f.Process(ctx, testsupport.SectionSplitterRequest("doc-1", "gs://cleaned/doc-1/cleaned.md"))
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if res.Status != sectionSplitStatusSkipped || res.SectionCount != 0 {
+		t.Errorf("Process() = %+v, want status %q", res, sectionSplitStatusSkipped)
+	}
+	if calls := model.Calls(); len(calls) != 0 {
+		t.Errorf("model called %d times, want none", len(calls))
+	}
+	if doc := readPipelineDocument(ctx, t, firestoreClient, collection, "doc-1"); doc.Status != StatusComplete {
+		t.Errorf("document status = %q, want %q", doc.Status, StatusComplete)
+	}
+}
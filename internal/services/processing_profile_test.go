@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+// cachedProfileResolver returns a resolver that answers from profiles without reading
+// Firestore, as if each had just been read.
+func cachedProfileResolver(profiles map[string]cachedProfile) *ProfileResolver {
+	r := NewProfileResolver(nil, "documents", time.Hour)
+	for docID, entry := range profiles {
+		entry.loadedAt = time.Now()
+		r.cache[docID] = entry
+	}
+	return r
+}
+
+func TestParseProcessingProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    models.ProcessingProfile
+		wantErr string
+	}{
+		{name: "empty object", data: `{}`},
+		{
+			name: "every field",
+			data: `{"chunkSize": 5, "promptProfile": "drawing", "cleanerMode": "hybrid", "skipSections": true}`,
+			want: models.ProcessingProfile{ChunkSize: 5, PromptProfile: gcp.PromptProfileDrawing, CleanerMode: CleanerModeHybrid, SkipSections: true},
+		},
+		{name: "misspelt field", data: `{"skipSection": true}`, wantErr: "unknown field"},
+		{name: "trailing data", data: `{} {}`, wantErr: "unexpected data"},
+		{name: "not JSON", data: `skip`, wantErr: "invalid processing profile"},
+		{name: "every problem reported", data: `{"chunkSize": -1, "promptProfile": "poetry", "cleanerMode": "fast"}`, wantErr: `chunkSize -1 must not be negative; promptProfile "poetry" must be one of`},
+		{name: "unknown cleaner mode", data: `{"cleanerMode": "fast"}`, wantErr: `cleanerMode "fast"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProcessingProfile(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseProcessingProfile() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseProcessingProfile() error = %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("parseProcessingProfile() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfileFromMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     models.ProcessingProfile
+	}{
+		{name: "no metadata", want: models.ProcessingProfile{ChunkSize: 3}},
+		{name: "blank metadata", metadata: map[string]string{processingProfileMetadataKey: "  "}, want: models.ProcessingProfile{ChunkSize: 3}},
+		{
+			name:     "profile chunk size wins",
+			metadata: map[string]string{processingProfileMetadataKey: `{"chunkSize": 10, "skipSections": true}`},
+			want:     models.ProcessingProfile{ChunkSize: 10, SkipSections: true},
+		},
+		{
+			name:     "configured chunk size filled in",
+			metadata: map[string]string{processingProfileMetadataKey: `{"cleanerMode": "deterministic"}`},
+			want:     models.ProcessingProfile{ChunkSize: 3, CleanerMode: CleanerModeDeterministic},
+		},
+		{name: "invalid profile ignored", metadata: map[string]string{processingProfileMetadataKey: `{"cleanerMode": "fast", "skipSections": true}`}, want: models.ProcessingProfile{ChunkSize: 3}},
+	}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := profileFromMetadata(logCtx, tt.metadata, 3); *got != tt.want {
+				t.Errorf("profileFromMetadata() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfileResolverResolveOrDefault(t *testing.T) {
+	rejected := cachedProfile{err: ErrProcessingProfileRejected}
+	drawing := models.ProcessingProfile{PromptProfile: gcp.PromptProfileDrawing, SkipSections: true}
+	r := cachedProfileResolver(map[string]cachedProfile{"doc-drawing": {profile: drawing}, "doc-rejected": rejected})
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	if got := r.ResolveOrDefault(context.Background(), logCtx, "doc-drawing"); got != drawing {
+		t.Errorf("ResolveOrDefault() = %+v, want %+v", got, drawing)
+	}
+	if _, err := r.Resolve(context.Background(), "doc-rejected"); !errors.Is(err, ErrProcessingProfileRejected) {
+		t.Errorf("Resolve() of a rejected profile = %v, want ErrProcessingProfileRejected", err)
+	}
+	if got := r.ResolveOrDefault(context.Background(), logCtx, "doc-rejected"); got != (models.ProcessingProfile{}) {
+		t.Errorf("ResolveOrDefault() of a rejected profile = %+v, want the zero profile", got)
+	}
+}
+
+func TestTranslatorPromptProfilePrecedence(t *testing.T) {
+	profileModels := map[string]gcp.ContentGenerator{}
+	for name := range gcp.TranslatorPromptProfiles {
+		profileModels[name] = testsupport.NewFakeContentGenerator()
+	}
+	profiles := cachedProfileResolver(map[string]cachedProfile{
+		"doc-drawing":  {profile: models.ProcessingProfile{PromptProfile: gcp.PromptProfileDrawing}},
+		"doc-none":     {},
+		"doc-rejected": {err: ErrProcessingProfileRejected},
+	})
+	tests := []struct {
+		name           string
+		docID          string
+		requestProfile string
+		customPrompt   string
+		want           string
+		wantUserPrompt string
+	}{
+		{name: "default", docID: "doc-none", want: gcp.PromptProfileDefault},
+		{name: "request profile", docID: "doc-none", requestProfile: gcp.PromptProfileContract, want: gcp.PromptProfileContract},
+		{name: "document profile wins over the request", docID: "doc-drawing", requestProfile: gcp.PromptProfileContract, want: gcp.PromptProfileDrawing},
+		{name: "rejected document profile", docID: "doc-rejected", requestProfile: gcp.PromptProfileContract, want: gcp.PromptProfileContract},
+		{name: "unknown request profile", docID: "doc-none", requestProfile: "poetry", want: gcp.PromptProfileDefault},
+		{name: "custom prompt", docID: "doc-drawing", customPrompt: "Only the title block.", want: gcp.PromptProfileDrawing, wantUserPrompt: "Only the title block."},
+	}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &TranslatorFunction{profiles: profiles}
+			req := &models.PageTranslatorRequest{DocumentID: tt.docID, PromptProfile: tt.requestProfile, CustomUserPrompt: tt.customPrompt}
+			profile, model, userPrompt := f.promptFor(context.Background(), logCtx, req, profileModels)
+			if profile != tt.want || model != profileModels[tt.want] {
+				t.Errorf("promptFor() profile = %q, want %q with its model", profile, tt.want)
+			}
+			wantUserPrompt := tt.wantUserPrompt
+			if wantUserPrompt == "" {
+				wantUserPrompt = gcp.TranslatorPromptProfiles[tt.want].User
+			}
+			if userPrompt != wantUserPrompt {
+				t.Errorf("promptFor() user prompt = %q, want %q", userPrompt, wantUserPrompt)
+			}
+		})
+	}
+}
+
+func TestCleanerModePrecedence(t *testing.T) {
+	profiles := cachedProfileResolver(map[string]cachedProfile{
+		"doc-hybrid":   {profile: models.ProcessingProfile{CleanerMode: CleanerModeHybrid}},
+		"doc-none":     {},
+		"doc-rejected": {err: ErrProcessingProfileRejected},
+	})
+	tests := []struct {
+		docID string
+		want  string
+	}{
+		{docID: "doc-hybrid", want: CleanerModeHybrid},
+		{docID: "doc-none", want: CleanerModeDeterministic},
+		{docID: "doc-rejected", want: CleanerModeDeterministic},
+	}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	f := &CleanerFunction{profiles: profiles, config: CleanerConfig{Mode: CleanerModeDeterministic}}
+	for _, tt := range tests {
+		if got := f.modeFor(context.Background(), logCtx, tt.docID); got != tt.want {
+			t.Errorf("modeFor(%q) = %q, want %q", tt.docID, got, tt.want)
+		}
+	}
+}
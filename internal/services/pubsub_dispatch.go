@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// newRunID names a Pub/Sub run of the pipeline, which stands in for the workflow
+// execution ID: it is recorded as the document's workflowExecutionId and passed to every
+// stage as its executionId.
+func newRunID(now time.Time) string {
+	return "run-" + now.UTC().Format("20060102T150405.000000000Z")
+}
+
+// dispatchPages starts a Pub/Sub run over the document's pages in the Pub/Sub mode, in
+// place of a workflow execution. It records the run with every page or chunk of order
+// pending, moves the document to TRANSLATING, then publishes a translator request for each
+// to the translate topic. order is over 1-based chunk indices, as for the workflow.
+func (f *PDFSplitterFunction) dispatchPages(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, pageCount int, order []int) error {
+	snap, err := docRef.Get(ctx)
+	if err != nil {
+		return f.handleError(ctx, logCtx, docRef, "failed to read document", err)
+	}
+	var doc models.Document
+	if err := snap.DataTo(&doc); err != nil {
+		return f.handleError(ctx, logCtx, docRef, "failed to decode document", err)
+	}
+
+	now := time.Now()
+	runID := newRunID(now)
+	chunks := pageChunks(pageCount, f.config.ChunkSize)
+	run := models.PipelineRun{
+		Stage:          models.PipelineRunStageTranslator,
+		PendingPages:   make([]int, len(order)),
+		Remaining:      len(order),
+		Dispatched:     []string{models.PipelineRunStageTranslator},
+		SourceLanguage: doc.SourceLanguage,
+		TargetLanguage: doc.TargetLanguage,
+		TenantID:       doc.TenantID,
+		Traceparent:    gcp.Traceparent(ctx),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	for i, index := range order {
+		run.PendingPages[i] = chunks[index-1].StartPage
+	}
+	if _, err := runRef(f.firestoreClient, f.config.CollectionName, docRef.ID, runID).Create(ctx, run); err != nil {
+		return f.handleError(ctx, logCtx, docRef, "failed to record pipeline run", err)
+	}
+	update := firestore.Update{Path: "workflowExecutionId", Value: runID}
+	if err := gcp.AppendStatusTransition(ctx, docRef, "TRANSLATING", models.TimingStageSplitter, "", update); err != nil {
+		return f.handleError(ctx, logCtx, docRef, "failed to record pipeline run on the document", err)
+	}
+
+	logCtx = logCtx.With("executionId", runID)
+	logCtx.Info("Publishing translator requests.", "processingOrder", f.config.ProcessingOrder.Strategy, "chunkCount", len(order), "topic", f.config.Orchestration.TranslateTopic)
+	manifest := splitPageManifest(f.config.SplitPagesBucket, doc.TenantID, docRef.ID, pageCount, f.config.ChunkSize)
+	results := make([]*pubsub.PublishResult, len(order))
+	for i, index := range order {
+		req := f.translatorRequest(docRef.ID, runID, &doc, chunks[index-1], manifest, run.Traceparent)
+		data, err := json.Marshal(req)
+		if err != nil {
+			return f.handleError(ctx, logCtx, docRef, "failed to encode translator request", err)
+		}
+		results[i] = f.translateTopic.Publish(ctx, &pubsub.Message{
+			Data:       data,
+			Attributes: map[string]string{"documentId": docRef.ID, "stage": models.PipelineRunStageTranslator},
+		})
+	}
+	var errs []error
+	for i, result := range results {
+		if _, err := result.Get(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("page %d: %w", run.PendingPages[i], err))
+		}
+	}
+	if len(errs) > 0 {
+		// The run waits on every page, so it cannot complete with a request missing.
+		return f.handleError(ctx, logCtx, docRef, fmt.Sprintf("failed to publish %d of %d translator requests", len(errs), len(order)), errors.Join(errs...))
+	}
+	logCtx.Info("Hand-off to Pub/Sub complete.")
+	return nil
+}
+
+// translatorRequest builds the translator request of a chunk, passing along the values
+// recorded on the document as the workflow does.
+func (f *PDFSplitterFunction) translatorRequest(docID, runID string, doc *models.Document, chunk pageChunk, manifest *models.PageManifest, traceparent string) *models.PageTranslatorRequest {
+	req := &models.PageTranslatorRequest{
+		DocumentID:       docID,
+		TenantID:         doc.TenantID,
+		PageNumber:       chunk.StartPage,
+		GCSUri:           manifest.GCSUriFor(chunk.StartPage),
+		ExecutionID:      runID,
+		AllowFailure:     f.config.Orchestration.AllowPageFailure,
+		SourceLanguage:   doc.SourceLanguage,
+		TargetLanguage:   doc.TargetLanguage,
+		ProcessingRegion: doc.ProcessingRegion,
+		RedactionMode:    doc.RedactionMode,
+		Traceparent:      traceparent,
+	}
+	if f.config.ChunkSize > 1 {
+		req.PageRange = &models.PageRange{StartPage: chunk.StartPage, EndPage: chunk.EndPage}
+	}
+	return req
+}
@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	executions "cloud.google.com/go/workflows/executions/apiv1"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+var (
+	// ErrInvalidReprocessRequest is returned when a reprocess request cannot be acted on.
+	ErrInvalidReprocessRequest = errors.New("invalid reprocess request")
+	// ErrExecutionActive is returned when the document already has a running workflow.
+	ErrExecutionActive = errors.New("workflow execution already active")
+)
+
+// purgedArtifactClasses are the derived artifacts deleted by a purging reprocess. Split
+// pages and extracted images are kept because the re-triggered workflow starts from them.
+var purgedArtifactClasses = map[string]bool{
+	ArtifactClassPageMarkdown: true,
+	ArtifactClassTable:        true,
+	ArtifactClassMaster:       true,
+	ArtifactClassMasterJSONL:  true,
+	ArtifactClassPreview:      true,
+	ArtifactClassDiff:         true,
+	ArtifactClassCleanedChunk: true,
+	ArtifactClassCleaned:      true,
+	ArtifactClassCleanReport:  true,
+	ArtifactClassSection:      true,
+	ArtifactClassSectionIndex: true,
+}
+
+// ReprocessorConfig holds configuration for the document-reprocessor service.
+type ReprocessorConfig struct {
+	ProjectID        string
+	CollectionName   string
+	WorkflowID       string
+	WorkflowLocation string
+	ProcessingOrder  ProcessingOrderConfig
+	WorkflowRetry    WorkflowRetryConfig
+	InlinePagesMax   int // Longer documents pass the workflow a page manifest.
+	Buckets          ArtifactBuckets
+	OutputNames      *OutputNames
+}
+
+// ReprocessorFunction holds dependencies for re-running the workflow over split pages.
+type ReprocessorFunction struct {
+	serviceClients
+	storageClient    *storage.Client
+	firestoreClient  *firestore.Client
+	executionsClient *executions.Client
+	config           ReprocessorConfig
+}
+
+// NewReprocessor creates a new ReprocessorFunction instance.
+func NewReprocessor(ctx context.Context) (*ReprocessorFunction, error) {
+	projectID := gcp.GetEnv("PROJECT_ID", "")
+	if projectID == "" {
+		return nil, fmt.Errorf("GCP_PROJECT environment variable must be set")
+	}
+	order, err := loadProcessingOrderConfig()
+	if err != nil {
+		return nil, err
+	}
+	workflowRetry, err := loadWorkflowRetryConfig()
+	if err != nil {
+		return nil, err
+	}
+	inlinePagesMax, err := loadWorkflowInlinePagesMax()
+	if err != nil {
+		return nil, err
+	}
+	outputNames, err := loadOutputNames()
+	if err != nil {
+		return nil, err
+	}
+
+	config := ReprocessorConfig{
+		ProjectID:        projectID,
+		CollectionName:   gcp.GetEnv("FIRESTORE_COLLECTION", "documents"),
+		WorkflowLocation: gcp.GetEnv("WORKFLOW_LOCATION", "us-central1"),
+		WorkflowID:       gcp.GetEnv("WORKFLOW_ID", "document-processing-orchestrator"),
+		ProcessingOrder:  order,
+		WorkflowRetry:    workflowRetry,
+		InlinePagesMax:   inlinePagesMax,
+		Buckets:          LoadArtifactBuckets(),
+		OutputNames:      outputNames,
+	}
+	if config.Buckets.SplitPages == "" {
+		return nil, fmt.Errorf("SPLIT_PAGES_BUCKET environment variable must be set")
+	}
+
+	firestoreClient, err := gcp.NewFirestoreClient(ctx, config.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firestore client: %w", err)
+	}
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %w", err)
+	}
+	executionsClient, err := executions.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Workflows Executions client: %w", err)
+	}
+
+	return &ReprocessorFunction{
+		serviceClients:   serviceClients{storageClient, firestoreClient, executionsClient},
+		storageClient:    storageClient,
+		firestoreClient:  firestoreClient,
+		executionsClient: executionsClient,
+		config:           config,
+	}, nil
+}
+
+// Process resets a previously split document and re-triggers the processing workflow
+// over its existing split pages, bypassing the splitter's duplicate-hash check.
+func (f *ReprocessorFunction) Process(ctx context.Context, req *models.ReprocessRequest) (*models.ReprocessResponse, error) {
+	logCtx := slog.With("documentId", req.DocumentID, "fileHash", req.FileHash, "purge", req.Purge)
+	logCtx.Info("Starting reprocess.")
+
+	// --- 1. Resolve the document ---
+	docRef, doc, err := f.resolveDocument(ctx, req)
+	if err != nil {
+		logCtx.Warn("Could not resolve document to reprocess", "error", err)
+		return nil, err
+	}
+	logCtx = logCtx.With("documentId", docRef.ID)
+	if doc.PageCount <= 0 {
+		err := fmt.Errorf("%w: document %s has no split pages to reprocess", ErrInvalidReprocessRequest, docRef.ID)
+		logCtx.Warn("Document was never split", "error", err, "status", doc.Status)
+		return nil, err
+	}
+
+	// --- 2. Refuse to race a running workflow ---
+	parent := workflowParent(f.config.ProjectID, f.config.WorkflowLocation, f.config.WorkflowID)
+	active, err := findActiveExecution(ctx, f.executionsClient, parent, docRef.ID)
+	if err != nil {
+		logCtx.Error("Failed to check for active executions", "error", err)
+		return nil, err
+	}
+	if active != "" {
+		logCtx.Warn("Refusing to reprocess while a workflow execution is active", "activeExecution", active)
+		return nil, fmt.Errorf("%w: %s", ErrExecutionActive, active)
+	}
+
+	// --- 3. Reset the document and optionally purge derived outputs ---
+	// Reprocessing a cancelled document withdraws its cancellation.
+	reset := []firestore.Update{
+		{Path: "errorDetails", Value: firestore.Delete},
+		{Path: "cancelRequested", Value: firestore.Delete},
+		{Path: "cancelRequestedAt", Value: firestore.Delete},
+	}
+	if err := gcp.AppendStatusTransition(ctx, docRef, "REPROCESSING", models.StatusStageReprocessor, "", reset...); err != nil {
+		logCtx.Error("Failed to reset document status", "error", err)
+		return nil, fmt.Errorf("failed to reset document status: %w", err)
+	}
+
+	manifest, _ := documentSplitFiles(f.config.Buckets.SplitPages, docRef.ID, doc)
+	// Attempts are read before a purge resets the page records.
+	attempts, err := pageAttemptCounts(ctx, f.firestoreClient, f.config.CollectionName, docRef.ID)
+	if err != nil {
+		logCtx.Warn("Failed to read page attempts; dispatching in the suggested order.", "error", err)
+	}
+	var purged int
+	if req.Purge {
+		// Extracted images are kept, so the reset records keep their URIs.
+		images, err := pageImageURIs(ctx, f.firestoreClient, f.config.CollectionName, docRef.ID)
+		if err != nil {
+			logCtx.Error("Failed to read page images", "error", err)
+			return nil, err
+		}
+		purged, err = purgeArtifacts(ctx, logCtx, objectstore.NewGCS(f.storageClient), f.config.Buckets, f.config.OutputNames, docRef.ID, doc, purgedArtifactClasses)
+		if err != nil {
+			return nil, err
+		}
+		if err := createPageRecords(ctx, f.firestoreClient, f.config.CollectionName, docRef.ID, doc.PageCount, manifest.GCSUriFor, images); err != nil {
+			logCtx.Error("Failed to reset page records", "error", err)
+			return nil, err
+		}
+		logCtx.Info("Purged derived artifacts and reset page records.", "purgedObjects", purged)
+	}
+
+	// --- 4. Re-trigger the workflow ---
+	order, err := f.config.ProcessingOrder.RepairOrder(doc, attempts)
+	if err != nil {
+		logCtx.Error("Failed to compute processing order", "error", err)
+		return nil, err
+	}
+	payload, err := documentWorkflowPayload(ctx, objectstore.WrapBucket(f.storageClient.Bucket(f.config.Buckets.SplitPages)), manifest, doc, order, f.config.InlinePagesMax)
+	if err != nil {
+		logCtx.Error("Failed to build workflow payload", "error", err)
+		return nil, err
+	}
+	execution, err := startWorkflowExecutionWithRetry(ctx, logCtx, f.executionsClient, parent, payload, f.config.WorkflowRetry)
+	if err != nil {
+		// The split pages are intact, so the retrigger service can start the workflow later.
+		logCtx.Error("Failed to trigger workflow", "error", err)
+		details := firestore.Update{Path: "errorDetails", Value: err.Error()}
+		if updateErr := gcp.AppendStatusTransition(ctx, docRef, StatusPagesReadyWorkflowFailed, models.StatusStageReprocessor, err.Error(), details); updateErr != nil {
+			logCtx.Error("CRITICAL: Failed to update Firestore status to FAILED after a processing error.", "updateError", updateErr)
+		}
+		return nil, err
+	}
+
+	update := firestore.Update{Path: "workflowExecutionId", Value: execution.GetName()}
+	if err := gcp.AppendStatusTransition(ctx, docRef, "TRANSLATING", models.StatusStageReprocessor, "", update); err != nil {
+		logCtx.Warn("Workflow triggered but the execution ID could not be recorded", "error", err, "executionId", execution.GetName())
+	}
+
+	logCtx.Info("Reprocess triggered.", "executionId", execution.GetName())
+	return &models.ReprocessResponse{
+		Status:        "success",
+		DocumentID:    docRef.ID,
+		ExecutionID:   execution.GetName(),
+		PurgedObjects: purged,
+	}, nil
+}
+
+// resolveDocument finds the document named by the request, by ID or by file hash.
+func (f *ReprocessorFunction) resolveDocument(ctx context.Context, req *models.ReprocessRequest) (*firestore.DocumentRef, *models.Document, error) {
+	collection := f.firestoreClient.Collection(f.config.CollectionName)
+
+	var snap *firestore.DocumentSnapshot
+	switch {
+	case req.DocumentID != "":
+		var err error
+		snap, err = collection.Doc(req.DocumentID).Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			return nil, nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, req.DocumentID)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read document %s: %w", req.DocumentID, err)
+		}
+	case req.FileHash != "":
+		if !req.Force {
+			return nil, nil, fmt.Errorf("%w: force must be set when reprocessing by fileHash", ErrInvalidReprocessRequest)
+		}
+		snaps, err := collection.Where("fileHash", "==", req.FileHash).Limit(1).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to query documents by fileHash: %w", err)
+		}
+		if len(snaps) == 0 {
+			return nil, nil, fmt.Errorf("%w: no document with fileHash %s", ErrDocumentNotFound, req.FileHash)
+		}
+		snap = snaps[0]
+	default:
+		return nil, nil, fmt.Errorf("%w: documentId or fileHash is required", ErrInvalidReprocessRequest)
+	}
+
+	var doc models.Document
+	if err := snap.DataTo(&doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode document %s: %w", snap.Ref.ID, err)
+	}
+	return snap.Ref, &doc, nil
+}
+
+// purgeArtifacts deletes every object of the document docID, recorded as record, in the
+// given artifact classes, returning the number of objects deleted.
+func purgeArtifacts(ctx context.Context, logCtx *slog.Logger, store objectstore.Client, buckets ArtifactBuckets, names *OutputNames, docID string, record *models.Document, classes map[string]bool) (int, error) {
+	doc, err := names.DocumentFor(docID, record)
+	if err != nil {
+		return 0, err
+	}
+	locations, err := buckets.Locations(names, doc)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, loc := range locations {
+		if !classes[loc.Class] {
+			continue
+		}
+		deleted, err := deleteLocation(ctx, logCtx, store.Bucket(loc.Bucket), loc)
+		purged += deleted
+		if err != nil {
+			return purged, fmt.Errorf("failed to purge %s objects: %w", loc.Class, err)
+		}
+	}
+	return purged, nil
+}
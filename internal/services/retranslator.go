@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	executions "cloud.google.com/go/workflows/executions/apiv1"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// StatusPagesRetranslated marks a document whose selected pages were translated again,
+// with its aggregated and later outputs removed, ready for the aggregator to be re-run.
+const StatusPagesRetranslated = "PAGES_RETRANSLATED"
+
+// ErrInvalidRetranslateRequest is returned when a retranslate request cannot be acted on.
+var ErrInvalidRetranslateRequest = errors.New("invalid retranslate request")
+
+// retranslatePurgedClasses are the outputs built from the page markdown, which are
+// deleted once pages are translated again so that re-running the later stages rebuilds
+// them rather than reusing them.
+var retranslatePurgedClasses = map[string]bool{
+	ArtifactClassMaster:       true,
+	ArtifactClassMasterJSONL:  true,
+	ArtifactClassPreview:      true,
+	ArtifactClassDiff:         true,
+	ArtifactClassCleanedChunk: true,
+	ArtifactClassCleaned:      true,
+	ArtifactClassCleanReport:  true,
+	ArtifactClassSection:      true,
+	ArtifactClassSectionIndex: true,
+}
+
+// RetranslatorConfig holds configuration for the page-retranslator service.
+type RetranslatorConfig struct {
+	WorkflowID       string
+	WorkflowLocation string
+	Buckets          ArtifactBuckets
+	Concurrency      int // Maximum pages translated at once.
+}
+
+// RetranslatorFunction translates selected pages of an already processed document again,
+// using the page translator's logic.
+type RetranslatorFunction struct {
+	serviceClients
+	translator       *TranslatorFunction
+	executionsClient *executions.Client
+	config           RetranslatorConfig
+}
+
+// NewRetranslator creates a new RetranslatorFunction instance.
+func NewRetranslator(ctx context.Context) (*RetranslatorFunction, error) {
+	translator, err := NewTranslator(ctx)
+	if err != nil {
+		return nil, err
+	}
+	concurrency, err := strconv.Atoi(gcp.GetEnv("RETRANSLATE_CONCURRENCY", "4"))
+	if err != nil || concurrency < 1 {
+		return nil, fmt.Errorf("RETRANSLATE_CONCURRENCY must be a positive integer")
+	}
+	config := RetranslatorConfig{
+		WorkflowLocation: gcp.GetEnv("WORKFLOW_LOCATION", "us-central1"),
+		WorkflowID:       gcp.GetEnv("WORKFLOW_ID", "document-processing-orchestrator"),
+		Buckets:          LoadArtifactBuckets(),
+		Concurrency:      concurrency,
+	}
+	if config.Buckets.SplitPages == "" {
+		return nil, fmt.Errorf("SPLIT_PAGES_BUCKET environment variable must be set")
+	}
+	executionsClient, err := executions.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Workflows Executions client: %w", err)
+	}
+	return &RetranslatorFunction{
+		serviceClients:   serviceClients{translator, executionsClient},
+		translator:       translator,
+		executionsClient: executionsClient,
+		config:           config,
+	}, nil
+}
+
+// Process translates the requested pages of a document again, at most Concurrency at a
+// time, reading each from its split page object. Pages whose split file failed to upload
+// are first backfilled from the document's upload. Once every page succeeds the document's
+// aggregated, cleaned and section outputs are deleted and it is marked
+// StatusPagesRetranslated; if any fails it is marked failed and those outputs are kept.
+func (f *RetranslatorFunction) Process(ctx context.Context, req *models.PageRetranslateRequest) (*models.PageRetranslateResponse, error) {
+	logCtx := slog.With("documentId", req.DocumentID, "pages", req.Pages, "force", req.Force)
+	logCtx.Info("Starting page retranslation.")
+
+	// --- 1. Resolve the document and the chunks holding the requested pages ---
+	docRef, doc, err := f.resolveDocument(ctx, req.DocumentID)
+	if err != nil {
+		logCtx.Warn("Could not resolve document to retranslate", "error", err)
+		return nil, err
+	}
+	chunks, err := retranslateChunks(req.Pages, doc.PageCount, documentUnits(doc))
+	if err != nil {
+		logCtx.Warn("Invalid pages requested", "error", err, "pageCount", doc.PageCount)
+		return nil, err
+	}
+
+	// --- 2. Refuse to race a running workflow ---
+	parent := workflowParent(f.translator.config.ProjectID, f.config.WorkflowLocation, f.config.WorkflowID)
+	active, err := findActiveExecution(ctx, f.executionsClient, parent, docRef.ID)
+	if err != nil {
+		logCtx.Error("Failed to check for active executions", "error", err)
+		return nil, err
+	}
+	if active != "" {
+		logCtx.Warn("Refusing to retranslate while a workflow execution is active", "activeExecution", active)
+		return nil, fmt.Errorf("%w: %s", ErrExecutionActive, active)
+	}
+
+	// --- 3. Translate each chunk again, those attempted least first ---
+	manifest, _ := documentSplitFiles(f.config.Buckets.SplitPages, docRef.ID, doc)
+	attempts, err := pageAttemptCounts(ctx, f.translator.firestoreClient, f.translator.config.CollectionName, docRef.ID)
+	if err != nil {
+		logCtx.Warn("Failed to read page attempts; translating in page order.", "error", err)
+	}
+	order, err := processingOrder(len(chunks), ProcessingOrderSequential, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]models.PageRetranslateResult, len(chunks))
+	eg, gctx := errgroup.WithContext(ctx)
+	eg.SetLimit(f.config.Concurrency)
+	for _, unit := range prioritizeByAttempts(order, chunks, attempts) {
+		i, chunk := unit-1, chunks[unit-1]
+		eg.Go(func() error {
+			results[i] = f.retranslateChunk(gctx, logCtx.With("startPage", chunk.StartPage, "endPage", chunk.EndPage), docRef, doc, manifest, chunk, req.Force)
+			return nil
+		})
+	}
+	_ = eg.Wait()
+
+	var failed []string
+	for _, result := range results {
+		if result.Error != "" {
+			failed = append(failed, describePages(result.StartPage, result.EndPage))
+		}
+	}
+	res := &models.PageRetranslateResponse{Status: "success", DocumentID: docRef.ID, Pages: results}
+	if len(failed) > 0 {
+		res.Status = "partial"
+		details := fmt.Sprintf("retranslation failed for %d of %d requested chunks: %v", len(failed), len(chunks), failed)
+		update := firestore.Update{Path: "errorDetails", Value: details}
+		if err := gcp.AppendStatusTransition(ctx, docRef, StatusFailed, models.StatusStageRetranslator, details, update); err != nil {
+			logCtx.Error("Failed to record retranslation failure", "error", err)
+		}
+		logCtx.Warn("Some pages could not be translated again.", "failed", failed)
+		return res, nil
+	}
+
+	// --- 4. Clear the outputs built from the old pages so the later stages run again ---
+	purged, err := purgeArtifacts(ctx, logCtx, objectstore.NewGCS(f.translator.storageClient), f.config.Buckets, f.translator.config.OutputNames, docRef.ID, doc, retranslatePurgedClasses)
+	if err != nil {
+		return nil, err
+	}
+	res.PurgedObjects = purged
+	reset := firestore.Update{Path: "errorDetails", Value: firestore.Delete}
+	if err := gcp.AppendStatusTransition(ctx, docRef, StatusPagesRetranslated, models.StatusStageRetranslator, "", reset); err != nil {
+		logCtx.Error("Failed to update document status", "error", err)
+		return nil, fmt.Errorf("failed to update document status: %w", err)
+	}
+	logCtx.Info("Page retranslation complete.", "chunkCount", len(chunks), "purgedObjects", purged)
+	return res, nil
+}
+
+// resolveDocument reads the document named by docID, which must have been split.
+func (f *RetranslatorFunction) resolveDocument(ctx context.Context, docID string) (*firestore.DocumentRef, *models.Document, error) {
+	if docID == "" {
+		return nil, nil, fmt.Errorf("%w: documentId is required", ErrInvalidRetranslateRequest)
+	}
+	snap, err := f.translator.firestoreClient.Collection(f.translator.config.CollectionName).Doc(docID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, docID)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read document %s: %w", docID, err)
+	}
+	var doc models.Document
+	if err := snap.DataTo(&doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode document %s: %w", docID, err)
+	}
+	if doc.PageCount <= 0 {
+		return nil, nil, fmt.Errorf("%w: document %s has no split pages", ErrInvalidRetranslateRequest, docID)
+	}
+	return snap.Ref, &doc, nil
+}
+
+// retranslateChunks returns the units, as documentUnits lists them, holding pages, in
+// page order and without repeats. Pages outside [1, pageCount] are refused, as are pages
+// no unit holds.
+func retranslateChunks(pages []int, pageCount int, units []pageChunk) ([]pageChunk, error) {
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("%w: pages is required", ErrInvalidRetranslateRequest)
+	}
+	var chunks []pageChunk
+	for _, page := range pages {
+		if page < 1 || page > pageCount {
+			return nil, fmt.Errorf("%w: page %d is outside the document's %d pages", ErrInvalidRetranslateRequest, page, pageCount)
+		}
+		i := slices.IndexFunc(units, func(unit pageChunk) bool { return unit.StartPage <= page && page <= unit.EndPage })
+		if i < 0 {
+			return nil, fmt.Errorf("%w: page %d is in none of the document's split files", ErrInvalidRetranslateRequest, page)
+		}
+		chunks = append(chunks, units[i])
+	}
+	sortChunks(chunks)
+	return slices.Compact(chunks), nil
+}
+
+// retranslateChunk translates one chunk from its split file, as listed in manifest, first
+// backfilling the file if it failed to upload when the document was split. With force,
+// the existing translation is deleted first, and the translation cache skipped, so that
+// the translator does not reuse either.
+func (f *RetranslatorFunction) retranslateChunk(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, doc *models.Document, manifest *models.PageManifest, chunk pageChunk, force bool) models.PageRetranslateResult {
+	result := models.PageRetranslateResult{StartPage: chunk.StartPage, EndPage: chunk.EndPage}
+	if chunkUploadFailed(doc, chunk) {
+		if err := f.backfillSplitFile(ctx, logCtx, docRef, doc, chunk); err != nil {
+			logCtx.Error("Failed to backfill split file", "error", err)
+			result.Error = err.Error()
+			return result
+		}
+	}
+	req := &models.PageTranslatorRequest{
+		DocumentID:       docRef.ID,
+		TenantID:         doc.TenantID,
+		PageNumber:       chunk.StartPage,
+		GCSUri:           manifest.GCSUriFor(chunk.StartPage),
+		SourceLanguage:   doc.SourceLanguage,
+		TargetLanguage:   doc.TargetLanguage,
+		ProcessingRegion: doc.ProcessingRegion,
+		RedactionMode:    doc.RedactionMode,
+		SkipCache:        force,
+		Traceparent:      gcp.Traceparent(ctx),
+	}
+	if chunk.StartPage != chunk.EndPage {
+		req.PageRange = &models.PageRange{StartPage: chunk.StartPage, EndPage: chunk.EndPage}
+	}
+	if force {
+		objectName, err := f.translator.pageObjectName(ctx, req)
+		if err == nil {
+			err = f.translator.storageClient.Bucket(f.translator.config.MarkdownBucket).Object(objectName).Delete(ctx)
+		}
+		if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			logCtx.Error("Failed to delete existing translation", "error", err, "gcsObject", objectName)
+			result.Error = fmt.Sprintf("failed to delete existing translation %s: %v", objectName, err)
+			return result
+		}
+	}
+	translation, err := f.translator.Process(ctx, req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Translation = translation
+	logCtx.Info("Page translated again.", "status", translation.Status)
+	return result
+}
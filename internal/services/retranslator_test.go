@@ -0,0 +1,82 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+func TestRetranslateChunks(t *testing.T) {
+	chaptered := &models.Document{
+		PageCount: 10,
+		SplitMode: models.SplitModeBookmark,
+		Chapters: []models.Chapter{
+			{Index: 1, Title: "Introduction", StartPage: 1, EndPage: 3},
+			{Index: 2, Title: "Installation", StartPage: 4, EndPage: 9},
+			{Index: 3, Title: "Index", StartPage: 10, EndPage: 10},
+		},
+	}
+	tests := []struct {
+		name    string
+		doc     *models.Document
+		pages   []int
+		want    []pageChunk
+		wantErr bool
+	}{
+		{
+			name:  "single pages",
+			doc:   &models.Document{PageCount: 10},
+			pages: []int{7, 2, 7},
+			want:  []pageChunk{{StartPage: 2, EndPage: 2}, {StartPage: 7, EndPage: 7}},
+		},
+		{
+			name:  "chunks",
+			doc:   &models.Document{PageCount: 10, ChunkSize: 4},
+			pages: []int{10, 2, 3},
+			want:  []pageChunk{{StartPage: 1, EndPage: 4}, {StartPage: 9, EndPage: 10}},
+		},
+		{
+			name:  "chapters",
+			doc:   chaptered,
+			pages: []int{5, 10, 9},
+			want:  []pageChunk{{StartPage: 4, EndPage: 9}, {StartPage: 10, EndPage: 10}},
+		},
+		{name: "page outside the document", doc: chaptered, pages: []int{11}, wantErr: true},
+		{name: "no pages", doc: chaptered, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := retranslateChunks(tt.pages, tt.doc.PageCount, documentUnits(tt.doc))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("retranslateChunks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidRetranslateRequest) {
+				t.Errorf("retranslateChunks() error = %v, want ErrInvalidRetranslateRequest", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("retranslateChunks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetranslateChunksReadChapterFiles(t *testing.T) {
+	doc := &models.Document{
+		PageCount: 6,
+		SplitMode: models.SplitModeBookmark,
+		Chapters: []models.Chapter{
+			{Index: 1, Title: "Scope", StartPage: 1, EndPage: 2, GCSUri: "gs://split/doc-1/chapters/001_scope.pdf"},
+			{Index: 2, Title: "Loads", StartPage: 3, EndPage: 6, GCSUri: "gs://split/doc-1/chapters/002_loads.pdf"},
+		},
+	}
+	chunks, err := retranslateChunks([]int{4}, doc.PageCount, documentUnits(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ := documentSplitFiles("split", "doc-1", doc)
+	if got := manifest.GCSUriFor(chunks[0].StartPage); got != "gs://split/doc-1/chapters/002_loads.pdf" {
+		t.Errorf("page 4 is read from %q, want its chapter's file", got)
+	}
+}
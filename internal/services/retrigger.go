@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	executions "cloud.google.com/go/workflows/executions/apiv1"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+var (
+	// ErrInvalidRetriggerRequest is returned when a retrigger request cannot be acted on.
+	ErrInvalidRetriggerRequest = errors.New("invalid retrigger request")
+	// ErrDocumentNotRetriggerable is returned when the named document is not waiting for its
+	// workflow to be started.
+	ErrDocumentNotRetriggerable = errors.New("document is not waiting for a workflow retrigger")
+)
+
+// RetriggerConfig holds configuration for the workflow-retrigger service.
+type RetriggerConfig struct {
+	ProjectID        string
+	CollectionName   string
+	SplitPagesBucket string
+	WorkflowID       string
+	WorkflowLocation string
+	ProcessingOrder  ProcessingOrderConfig
+	WorkflowRetry    WorkflowRetryConfig
+	InlinePagesMax   int // Longer documents pass the workflow a page manifest.
+	SweepLimit       int // Most documents retriggered by one sweep.
+}
+
+// RetriggerFunction holds dependencies for starting the workflow of documents whose
+// pages were split but whose workflow could not be started.
+type RetriggerFunction struct {
+	serviceClients
+	firestoreClient  *firestore.Client
+	executionsClient *executions.Client
+	store            objectstore.Client
+	config           RetriggerConfig
+}
+
+// NewRetrigger creates a new RetriggerFunction instance.
+func NewRetrigger(ctx context.Context) (*RetriggerFunction, error) {
+	projectID := gcp.GetEnv("PROJECT_ID", "")
+	if projectID == "" {
+		return nil, fmt.Errorf("GCP_PROJECT environment variable must be set")
+	}
+	order, err := loadProcessingOrderConfig()
+	if err != nil {
+		return nil, err
+	}
+	workflowRetry, err := loadWorkflowRetryConfig()
+	if err != nil {
+		return nil, err
+	}
+	inlinePagesMax, err := loadWorkflowInlinePagesMax()
+	if err != nil {
+		return nil, err
+	}
+	sweepLimit, err := strconv.Atoi(gcp.GetEnv("RETRIGGER_SWEEP_LIMIT", "50"))
+	if err != nil || sweepLimit < 1 {
+		return nil, fmt.Errorf("RETRIGGER_SWEEP_LIMIT must be a positive integer")
+	}
+
+	config := RetriggerConfig{
+		ProjectID:        projectID,
+		CollectionName:   gcp.GetEnv("FIRESTORE_COLLECTION", "documents"),
+		SplitPagesBucket: gcp.GetEnv("SPLIT_PAGES_BUCKET", ""),
+		WorkflowLocation: gcp.GetEnv("WORKFLOW_LOCATION", "us-central1"),
+		WorkflowID:       gcp.GetEnv("WORKFLOW_ID", "document-processing-orchestrator"),
+		ProcessingOrder:  order,
+		WorkflowRetry:    workflowRetry,
+		InlinePagesMax:   inlinePagesMax,
+		SweepLimit:       sweepLimit,
+	}
+	if config.SplitPagesBucket == "" {
+		return nil, fmt.Errorf("SPLIT_PAGES_BUCKET environment variable must be set")
+	}
+
+	firestoreClient, err := gcp.NewFirestoreClient(ctx, config.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firestore client: %w", err)
+	}
+	executionsClient, err := executions.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Workflows Executions client: %w", err)
+	}
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %w", err)
+	}
+
+	return &RetriggerFunction{
+		serviceClients:   serviceClients{firestoreClient, executionsClient, storageClient},
+		firestoreClient:  firestoreClient,
+		executionsClient: executionsClient,
+		store:            objectstore.NewGCS(storageClient),
+		config:           config,
+	}, nil
+}
+
+// Process starts the workflow of the named document, or in sweep mode of every document
+// in StatusPagesReadyWorkflowFailed. A document that cannot be started again stays in
+// that status with the new error, so a later sweep retries it. The response is "success"
+// only if every document was triggered, and "partial" otherwise.
+func (f *RetriggerFunction) Process(ctx context.Context, req *models.WorkflowRetriggerRequest) (*models.WorkflowRetriggerResponse, error) {
+	logCtx := slog.With("documentId", req.DocumentID, "sweep", req.Sweep)
+	logCtx.Info("Starting workflow retrigger.")
+
+	snaps, err := f.candidates(ctx, req)
+	if err != nil {
+		logCtx.Warn("Could not select documents to retrigger", "error", err)
+		return nil, err
+	}
+
+	res := &models.WorkflowRetriggerResponse{Status: "success", Documents: []models.RetriggerOutcome{}}
+	for _, snap := range snaps {
+		outcome := f.retrigger(ctx, logCtx.With("documentId", snap.Ref.ID), snap)
+		if outcome.Error != "" {
+			res.Failed++
+			res.Status = "partial"
+		} else {
+			res.Triggered++
+		}
+		res.Documents = append(res.Documents, outcome)
+	}
+	logCtx.Info("Workflow retrigger complete.", "triggered", res.Triggered, "failed", res.Failed)
+	return res, nil
+}
+
+// candidates returns the documents a request retriggers.
+func (f *RetriggerFunction) candidates(ctx context.Context, req *models.WorkflowRetriggerRequest) ([]*firestore.DocumentSnapshot, error) {
+	collection := f.firestoreClient.Collection(f.config.CollectionName)
+	switch {
+	case req.DocumentID != "" && req.Sweep:
+		return nil, fmt.Errorf("%w: documentId and sweep are mutually exclusive", ErrInvalidRetriggerRequest)
+	case req.Sweep:
+		snaps, err := collection.Where("status", "==", StatusPagesReadyWorkflowFailed).Limit(f.config.SweepLimit).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, fmt.Errorf("failed to query documents awaiting a retrigger: %w", err)
+		}
+		return snaps, nil
+	case req.DocumentID != "":
+		snap, err := collection.Doc(req.DocumentID).Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, req.DocumentID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read document %s: %w", req.DocumentID, err)
+		}
+		if current, _ := snap.DataAt("status"); current != StatusPagesReadyWorkflowFailed {
+			return nil, fmt.Errorf("%w: %s has status %v", ErrDocumentNotRetriggerable, req.DocumentID, current)
+		}
+		return []*firestore.DocumentSnapshot{snap}, nil
+	default:
+		return nil, fmt.Errorf("%w: documentId or sweep is required", ErrInvalidRetriggerRequest)
+	}
+}
+
+// retrigger starts the workflow of one document from the page count and chunk size
+// recorded on it. An execution that is already running, such as one whose start was
+// reported as failed but went through, is adopted instead of starting another.
+func (f *RetriggerFunction) retrigger(ctx context.Context, logCtx *slog.Logger, snap *firestore.DocumentSnapshot) models.RetriggerOutcome {
+	docRef := snap.Ref
+	outcome := models.RetriggerOutcome{DocumentID: docRef.ID}
+	fail := func(err error) models.RetriggerOutcome {
+		logCtx.Error("Failed to retrigger workflow", "error", err)
+		details := firestore.Update{Path: "errorDetails", Value: err.Error()}
+		if updateErr := gcp.AppendStatusTransition(ctx, docRef, StatusPagesReadyWorkflowFailed, models.StatusStageRetrigger, err.Error(), details); updateErr != nil {
+			logCtx.Error("Failed to record retrigger failure", "updateError", updateErr)
+		}
+		outcome.Error = err.Error()
+		return outcome
+	}
+
+	var doc models.Document
+	if err := snap.DataTo(&doc); err != nil {
+		return fail(fmt.Errorf("failed to decode document: %w", err))
+	}
+	if doc.PageCount <= 0 {
+		return fail(fmt.Errorf("document has no recorded page count"))
+	}
+
+	parent := workflowParent(f.config.ProjectID, f.config.WorkflowLocation, f.config.WorkflowID)
+	executionName, err := findActiveExecution(ctx, f.executionsClient, parent, docRef.ID)
+	if err != nil {
+		return fail(err)
+	}
+	if executionName != "" {
+		logCtx.Info("Adopting workflow execution that is already running.", "executionId", executionName)
+	} else {
+		manifest, _ := documentSplitFiles(f.config.SplitPagesBucket, docRef.ID, &doc)
+		attempts, err := pageAttemptCounts(ctx, f.firestoreClient, f.config.CollectionName, docRef.ID)
+		if err != nil {
+			logCtx.Warn("Failed to read page attempts; dispatching in the suggested order.", "error", err)
+		}
+		order, err := f.config.ProcessingOrder.RepairOrder(&doc, attempts)
+		if err != nil {
+			return fail(fmt.Errorf("failed to compute processing order: %w", err))
+		}
+		payload, err := documentWorkflowPayload(ctx, f.store.Bucket(f.config.SplitPagesBucket), manifest, &doc, order, f.config.InlinePagesMax)
+		if err != nil {
+			return fail(err)
+		}
+		execution, err := startWorkflowExecutionWithRetry(ctx, logCtx, f.executionsClient, parent, payload, f.config.WorkflowRetry)
+		if err != nil {
+			return fail(err)
+		}
+		executionName = execution.GetName()
+	}
+
+	update := firestore.Update{Path: "workflowExecutionId", Value: executionName}
+	if err := gcp.AppendStatusTransition(ctx, docRef, "TRANSLATING", models.StatusStageRetrigger, "", update); err != nil {
+		// The workflow is running, so reporting a failure would only invite a duplicate.
+		logCtx.Warn("Workflow triggered but the execution ID could not be recorded", "error", err, "executionId", executionName)
+	}
+	logCtx.Info("Workflow retriggered.", "executionId", executionName)
+	outcome.ExecutionID = executionName
+	return outcome
+}
@@ -0,0 +1,136 @@
+//go:build integration
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestRetriggerStatusTransitions(t *testing.T) {
+	ctx := context.Background()
+	firestoreClient, _ := testsupport.RequireEmulators(t).Clients(ctx, t)
+	workflows := testsupport.NewFakeWorkflows(t)
+	collection := fmt.Sprintf("documents-%d", time.Now().UnixNano())
+	docs := map[string]map[string]interface{}{
+		"doc-ready":       {"status": StatusPagesReadyWorkflowFailed, "pageCount": 2, "errorDetails": "workflow start failed"},
+		"doc-no-pages":    {"status": StatusPagesReadyWorkflowFailed},
+		"doc-translating": {"status": "TRANSLATING", "pageCount": 2},
+	}
+	for docID, data := range docs {
+		if _, err := firestoreClient.Collection(collection).Doc(docID).Set(ctx, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := &RetriggerFunction{
+		firestoreClient:  firestoreClient,
+		executionsClient: workflows.Client(ctx, t),
+		store:            objectstore.NewMemory(),
+		config: RetriggerConfig{
+			ProjectID:        "test-project",
+			CollectionName:   collection,
+			SplitPagesBucket: "pages",
+			WorkflowLocation: "us-central1",
+			WorkflowID:       "document-processing-orchestrator",
+			InlinePagesMax:   100,
+			SweepLimit:       10,
+		},
+	}
+	lastTransition := func(doc models.Document) models.StatusTransition {
+		if len(doc.StatusHistory) == 0 {
+			return models.StatusTransition{}
+		}
+		return doc.StatusHistory[len(doc.StatusHistory)-1]
+	}
+
+	// A started workflow moves the document to TRANSLATING with the execution recorded.
+	res, err := f.Process(ctx, &models.WorkflowRetriggerRequest{DocumentID: "doc-ready"})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if res.Status != "success" || res.Triggered != 1 || res.Documents[0].ExecutionID != testsupport.ExecutionID {
+		t.Errorf("Process() = %+v, want doc-ready triggered", res)
+	}
+	doc := readPipelineDocument(ctx, t, firestoreClient, collection, "doc-ready")
+	if doc.Status != "TRANSLATING" || doc.WorkflowExecutionID != testsupport.ExecutionID {
+		t.Errorf("document = status %q, execution %q; want TRANSLATING with the execution", doc.Status, doc.WorkflowExecutionID)
+	}
+	if got := lastTransition(doc); got.Status != "TRANSLATING" || got.Stage != models.StatusStageRetrigger {
+		t.Errorf("last transition = %+v, want TRANSLATING by the retrigger", got)
+	}
+	if got := len(workflows.Requests()); got != 1 {
+		t.Errorf("executions started = %d, want 1", got)
+	}
+
+	// A document that cannot be started stays waiting, with the reason, for a later sweep.
+	res, err = f.Process(ctx, &models.WorkflowRetriggerRequest{Sweep: true})
+	if err != nil {
+		t.Fatalf("Process() sweep error = %v", err)
+	}
+	if res.Status != "partial" || res.Triggered != 0 || res.Failed != 1 || res.Documents[0].DocumentID != "doc-no-pages" {
+		t.Errorf("Process() sweep = %+v, want only doc-no-pages, failed", res)
+	}
+	doc = readPipelineDocument(ctx, t, firestoreClient, collection, "doc-no-pages")
+	if doc.Status != StatusPagesReadyWorkflowFailed || doc.ErrorDetails != "document has no recorded page count" {
+		t.Errorf("document = status %q, details %q; want it still waiting with the reason", doc.Status, doc.ErrorDetails)
+	}
+	if got := lastTransition(doc); got.Status != StatusPagesReadyWorkflowFailed || got.Stage != models.StatusStageRetrigger || got.Detail != doc.ErrorDetails {
+		t.Errorf("last transition = %+v, want the failure recorded by the retrigger", got)
+	}
+
+	// Documents not waiting for a retrigger are refused and left alone.
+	if _, err := f.Process(ctx, &models.WorkflowRetriggerRequest{DocumentID: "doc-translating"}); !errors.Is(err, ErrDocumentNotRetriggerable) {
+		t.Errorf("Process() of a translating document = %v, want ErrDocumentNotRetriggerable", err)
+	}
+	if _, err := f.Process(ctx, &models.WorkflowRetriggerRequest{DocumentID: "doc-missing"}); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Process() of a missing document = %v, want ErrDocumentNotFound", err)
+	}
+	if _, err := f.Process(ctx, &models.WorkflowRetriggerRequest{DocumentID: "doc-ready", Sweep: true}); !errors.Is(err, ErrInvalidRetriggerRequest) {
+		t.Errorf("Process() of a document and a sweep = %v, want ErrInvalidRetriggerRequest", err)
+	}
+	if doc := readPipelineDocument(ctx, t, firestoreClient, collection, "doc-translating"); len(doc.StatusHistory) != 0 {
+		t.Errorf("refused document history = %+v, want it unchanged", doc.StatusHistory)
+	}
+}
+
+func TestRetriggerAdoptsARunningExecution(t *testing.T) {
+	ctx := context.Background()
+	firestoreClient, _ := testsupport.RequireEmulators(t).Clients(ctx, t)
+	workflows := testsupport.NewFakeWorkflows(t)
+	collection := fmt.Sprintf("documents-%d", time.Now().UnixNano())
+	if _, err := firestoreClient.Collection(collection).Doc("doc-1").Set(ctx, map[string]interface{}{
+		"status":    StatusPagesReadyWorkflowFailed,
+		"pageCount": 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	f := &RetriggerFunction{
+		firestoreClient:  firestoreClient,
+		executionsClient: workflows.Client(ctx, t),
+		store:            objectstore.NewMemory(),
+		config:           RetriggerConfig{ProjectID: "test-project", CollectionName: collection, SplitPagesBucket: "pages", InlinePagesMax: 100},
+	}
+	// The start reported as failed went through after all.
+	parent := workflowParent("test-project", "", "")
+	if _, err := startWorkflowExecution(ctx, f.executionsClient, parent, &models.WorkflowPayload{DocumentID: "doc-1", PageCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := f.Process(ctx, &models.WorkflowRetriggerRequest{DocumentID: "doc-1"})
+	if err != nil || res.Triggered != 1 {
+		t.Fatalf("Process() = %+v, %v; want doc-1 triggered", res, err)
+	}
+	if got := len(workflows.Requests()); got != 1 {
+		t.Errorf("executions started = %d, want the running one adopted", got)
+	}
+	if doc := readPipelineDocument(ctx, t, firestoreClient, collection, "doc-1"); doc.Status != "TRANSLATING" || doc.WorkflowExecutionID != testsupport.ExecutionID {
+		t.Errorf("document = status %q, execution %q; want TRANSLATING with the running execution", doc.Status, doc.WorkflowExecutionID)
+	}
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/api/iterator"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// RevisionDifferConfig holds configuration for the revision-differ service.
+type RevisionDifferConfig struct {
+	ProjectID                string
+	CollectionName           string
+	TranslatedMarkdownBucket string
+	DiffsBucket              string
+	MaxDiffBytes             int
+}
+
+// RevisionDifferFunction holds dependencies for the page-level revision diff logic.
+type RevisionDifferFunction struct {
+	serviceClients
+	storageClient   *storage.Client
+	firestoreClient *firestore.Client
+	tenants         *DocumentTenants
+	config          RevisionDifferConfig
+}
+
+// NewRevisionDiffer creates a new RevisionDifferFunction instance.
+func NewRevisionDiffer(ctx context.Context) (*RevisionDifferFunction, error) {
+	projectID := gcp.GetEnv("PROJECT_ID", "")
+	if projectID == "" {
+		return nil, fmt.Errorf("GCP_PROJECT environment variable must be set")
+	}
+	maxDiffBytes, err := strconv.Atoi(gcp.GetEnv("MAX_DIFF_BYTES", "262144"))
+	if err != nil {
+		return nil, fmt.Errorf("MAX_DIFF_BYTES must be an integer: %w", err)
+	}
+
+	config := RevisionDifferConfig{
+		ProjectID:                projectID,
+		CollectionName:           gcp.GetEnv("FIRESTORE_COLLECTION", "documents"),
+		TranslatedMarkdownBucket: gcp.GetEnv("TRANSLATED_MARKDOWN_BUCKET", ""),
+		DiffsBucket:              gcp.GetEnv("DIFFS_BUCKET", gcp.GetEnv("AGGREGATED_MARKDOWN_BUCKET", "")),
+		MaxDiffBytes:             maxDiffBytes,
+	}
+	if config.TranslatedMarkdownBucket == "" || config.DiffsBucket == "" {
+		return nil, fmt.Errorf("TRANSLATED_MARKDOWN_BUCKET and DIFFS_BUCKET (or AGGREGATED_MARKDOWN_BUCKET) must be set")
+	}
+
+	firestoreClient, err := gcp.NewFirestoreClient(ctx, config.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firestore client: %w", err)
+	}
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %w", err)
+	}
+
+	return &RevisionDifferFunction{
+		serviceClients:  serviceClients{storageClient, firestoreClient},
+		storageClient:   storageClient,
+		firestoreClient: firestoreClient,
+		tenants:         NewDocumentTenants(firestoreClient, config.CollectionName),
+		config:          config,
+	}, nil
+}
+
+// revisionDiffReportName is the object name, under a document's diffs prefix, of the
+// operator report.
+const revisionDiffReportName = "report.json"
+
+// Process diffs every translated page of a document against a prior revision, the one
+// the request names or else the document's reprocessOf link. It writes the non-empty
+// diffs and an operator report next to the document's other outputs, and records a
+// summary on the Firestore document.
+func (f *RevisionDifferFunction) Process(ctx context.Context, req *models.RevisionDiffRequest) (*models.RevisionDiffResponse, error) {
+	logCtx := slog.With("documentId", req.DocumentID, "tenantId", req.TenantID, "executionId", req.ExecutionID)
+	logCtx.Info("Starting revision diff.")
+
+	if req.DocumentID == "" {
+		err := models.WithCode(models.ErrorCodeInvalidRequest, fmt.Errorf("documentId must be set"))
+		logCtx.Error("Invalid revision diff request", "error", err)
+		return nil, err
+	}
+	if err := f.tenants.Check(ctx, req.DocumentID, req.TenantID); err != nil {
+		logCtx.Error("Invalid revision diff request", "error", err)
+		return nil, err
+	}
+	baseDocID, err := f.baseRevision(ctx, req)
+	if err != nil {
+		logCtx.Error("Invalid revision diff request", "error", err)
+		return nil, err
+	}
+	logCtx = logCtx.With("baseDocumentId", baseDocID)
+	if err := f.tenants.Check(ctx, baseDocID, req.TenantID); err != nil {
+		logCtx.Error("Invalid revision diff request", "error", err)
+		return nil, err
+	}
+	root := documentRoot(req.TenantID, req.DocumentID)
+
+	// --- 1. Load the translated pages of both revisions ---
+	basePages, err := f.loadRevisionPages(ctx, documentRoot(req.TenantID, baseDocID))
+	if err != nil {
+		logCtx.Error("Failed to load base revision pages", "error", err)
+		return nil, sourceError(err)
+	}
+	newPages, err := f.loadRevisionPages(ctx, root)
+	if err != nil {
+		logCtx.Error("Failed to load new revision pages", "error", err)
+		return nil, sourceError(err)
+	}
+	logCtx.Info("Loaded revision pages.", "basePageCount", len(basePages), "newPageCount", len(newPages))
+
+	// --- 2. Pair pages and write a diff for each one that changed, then the report ---
+	diffs, report := diffRevisions(f.config.DiffsBucket, root, basePages, newPages, f.config.MaxDiffBytes)
+	report.DocumentID = req.DocumentID
+	report.Summary.BaseDocumentID = baseDocID
+	bucket := objectstore.WrapBucket(f.storageClient.Bucket(f.config.DiffsBucket))
+	for _, diff := range diffs {
+		if err := gcp.SaveToGCSAtomically(ctx, logCtx, bucket, diff.ObjectName, diff.Content); err != nil {
+			logCtx.Error("Failed to save page diff", "error", err, "bucket", f.config.DiffsBucket, "gcsObject", diff.ObjectName)
+			return nil, outputError(err)
+		}
+	}
+	reportObject := fmt.Sprintf("%s/diffs/%s", root, revisionDiffReportName)
+	report.Summary.ReportURI = fmt.Sprintf("gs://%s/%s", f.config.DiffsBucket, reportObject)
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode revision diff report: %w", err)
+	}
+	if err := gcp.SaveToGCSAtomically(ctx, logCtx, bucket, reportObject, string(data)); err != nil {
+		logCtx.Error("Failed to save revision diff report", "error", err, "bucket", f.config.DiffsBucket, "gcsObject", reportObject)
+		return nil, outputError(err)
+	}
+
+	// --- 3. Record the summary on the document ---
+	summary := report.Summary
+	docRef := f.firestoreClient.Collection(f.config.CollectionName).Doc(req.DocumentID)
+	if err := gcp.UpdateWithRetry(ctx, docRef, []firestore.Update{{Path: "revisionDiff", Value: summary}}); err != nil {
+		logCtx.Error("Failed to record revision diff summary", "error", err)
+		return nil, fmt.Errorf("failed to update document with diff summary: %w", err)
+	}
+
+	logCtx.Info("Revision diff complete.",
+		"pagesChanged", summary.PagesChanged,
+		"pagesAdded", summary.PagesAdded,
+		"pagesRemoved", summary.PagesRemoved,
+		"totalLinesChanged", summary.TotalLinesChanged,
+		"reportUri", summary.ReportURI,
+	)
+	return &models.RevisionDiffResponse{Status: "success", Summary: summary}, nil
+}
+
+// baseRevision returns the ID of the document the request's document is diffed against:
+// the one the request names, or else the document's reprocessOf link.
+func (f *RevisionDifferFunction) baseRevision(ctx context.Context, req *models.RevisionDiffRequest) (string, error) {
+	baseDocID := req.BaseDocumentID
+	if baseDocID == "" {
+		snap, err := f.firestoreClient.Collection(f.config.CollectionName).Doc(req.DocumentID).Get(ctx)
+		if err != nil {
+			return "", sourceError(fmt.Errorf("failed to read document %s: %w", req.DocumentID, err))
+		}
+		var doc models.Document
+		if err := snap.DataTo(&doc); err != nil {
+			return "", fmt.Errorf("failed to decode document %s: %w", req.DocumentID, err)
+		}
+		baseDocID = doc.ReprocessOf
+	}
+	switch baseDocID {
+	case "":
+		return "", models.WithCode(models.ErrorCodeInvalidRequest, fmt.Errorf("document %s has no reprocessOf link, so baseDocumentId must be set", req.DocumentID))
+	case req.DocumentID:
+		return "", models.WithCode(models.ErrorCodeInvalidRequest, fmt.Errorf("a document cannot be diffed against itself"))
+	}
+	return baseDocID, nil
+}
+
+// revisionDiff is the unified diff of a page, to be saved as ObjectName.
+type revisionDiff struct {
+	ObjectName string
+	Content    string
+}
+
+// diffRevisions pairs the pages of two revisions and diffs each pair that differs. It
+// returns the diffs to save in bucket under root, truncated to maxDiffBytes, and the
+// report of the pages that differ, whose summary counts them. Pages added or changed are
+// diffed to "{root}/diffs/00003.diff", by their new number, and removed pages to
+// "{root}/diffs/removed_00003.diff".
+func diffRevisions(bucket, root string, basePages, newPages []revisionPage, maxDiffBytes int) ([]revisionDiff, *models.RevisionDiffReport) {
+	report := &models.RevisionDiffReport{Pages: []models.RevisionDiffPage{}, CreatedAt: time.Now()}
+	var diffs []revisionDiff
+	for _, pair := range pairRevisionPages(basePages, newPages) {
+		var fromName, toName, fromContent, toContent, objectName string
+		var page models.RevisionDiffPage
+		switch {
+		case pair.Base == nil:
+			report.Summary.PagesAdded++
+			page = models.RevisionDiffPage{Change: models.RevisionPageAdded, NewPage: pair.New.Number}
+			fromName = "/dev/null"
+			toName, toContent = fmt.Sprintf("b/%05d.md", pair.New.Number), pair.New.Content
+			objectName = fmt.Sprintf("%s/diffs/%05d.diff", root, pair.New.Number)
+		case pair.New == nil:
+			report.Summary.PagesRemoved++
+			page = models.RevisionDiffPage{Change: models.RevisionPageRemoved, BasePage: pair.Base.Number}
+			fromName, fromContent = fmt.Sprintf("a/%05d.md", pair.Base.Number), pair.Base.Content
+			toName = "/dev/null"
+			objectName = fmt.Sprintf("%s/diffs/removed_%05d.diff", root, pair.Base.Number)
+		case pair.Base.Hash == pair.New.Hash:
+			continue
+		default:
+			report.Summary.PagesChanged++
+			page = models.RevisionDiffPage{Change: models.RevisionPageChanged, BasePage: pair.Base.Number, NewPage: pair.New.Number}
+			fromName, fromContent = fmt.Sprintf("a/%05d.md", pair.Base.Number), pair.Base.Content
+			toName, toContent = fmt.Sprintf("b/%05d.md", pair.New.Number), pair.New.Content
+			objectName = fmt.Sprintf("%s/diffs/%05d.diff", root, pair.New.Number)
+		}
+
+		diff, linesChanged := unifiedDiff(fromName, toName, fromContent, toContent, 3)
+		report.Summary.TotalLinesChanged += linesChanged
+		page.LinesChanged = linesChanged
+		if diff != "" {
+			content := truncateDiff(diff, maxDiffBytes)
+			diffs = append(diffs, revisionDiff{ObjectName: objectName, Content: content})
+			page.DiffURI = fmt.Sprintf("gs://%s/%s", bucket, objectName)
+			page.Truncated = len(content) != len(diff)
+		}
+		report.Pages = append(report.Pages, page)
+	}
+	return diffs, report
+}
+
+// loadRevisionPages reads every translated page markdown of the document under root,
+// ordered by page.
+func (f *RevisionDifferFunction) loadRevisionPages(ctx context.Context, root string) ([]revisionPage, error) {
+	bucket := f.storageClient.Bucket(f.config.TranslatedMarkdownBucket)
+	it := bucket.Objects(ctx, &storage.Query{Prefix: root + "/"})
+
+	var pages []revisionPage
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list markdown for %s: %w", root, err)
+		}
+		if path.Dir(attrs.Name) != root || !strings.HasSuffix(attrs.Name, ".md") {
+			continue
+		}
+		pageNumber, ok := pageNumberFromObjectName(attrs.Name)
+		if !ok {
+			continue
+		}
+
+		reader, err := bucket.Object(attrs.Name).NewReader(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", attrs.Name, err)
+		}
+		content, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", attrs.Name, err)
+		}
+
+		sum := sha256.Sum256(content)
+		pages = append(pages, revisionPage{Number: pageNumber, Hash: hex.EncodeToString(sum[:]), Content: string(content)})
+	}
+
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Number < pages[j].Number })
+	return pages, nil
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+func TestDiffRevisions(t *testing.T) {
+	// The new revision drops the intro, edits the specs and adds an index, anchored on
+	// the unchanged title, scope and appendix.
+	base := revision("title\n", "intro\n", "scope\n", "specs\nbolt M8\n", "appendix\n")
+	revised := revision("title\n", "scope\n", "specs\nbolt M10\n", "appendix\n", "index\n")
+
+	diffs, report := diffRevisions("diffs", "unit-a/doc", base, revised, 0)
+
+	wantSummary := models.RevisionDiffSummary{PagesChanged: 1, PagesAdded: 1, PagesRemoved: 1, TotalLinesChanged: 4}
+	if report.Summary != wantSummary {
+		t.Errorf("summary = %+v, want %+v", report.Summary, wantSummary)
+	}
+	wantPages := []models.RevisionDiffPage{
+		{Change: models.RevisionPageRemoved, BasePage: 2, LinesChanged: 1, DiffURI: "gs://diffs/unit-a/doc/diffs/removed_00002.diff"},
+		{Change: models.RevisionPageChanged, BasePage: 4, NewPage: 3, LinesChanged: 2, DiffURI: "gs://diffs/unit-a/doc/diffs/00003.diff"},
+		{Change: models.RevisionPageAdded, NewPage: 5, LinesChanged: 1, DiffURI: "gs://diffs/unit-a/doc/diffs/00005.diff"},
+	}
+	if !reflect.DeepEqual(report.Pages, wantPages) {
+		t.Errorf("pages = %+v, want %+v", report.Pages, wantPages)
+	}
+
+	var names []string
+	for _, diff := range diffs {
+		names = append(names, diff.ObjectName)
+	}
+	wantNames := []string{"unit-a/doc/diffs/removed_00002.diff", "unit-a/doc/diffs/00003.diff", "unit-a/doc/diffs/00005.diff"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("diff objects = %v, want %v", names, wantNames)
+	}
+	if want := "--- a/00004.md\n+++ b/00003.md\n@@ -1,2 +1,2 @@\n specs\n-bolt M8\n+bolt M10\n"; diffs[1].Content != want {
+		t.Errorf("changed page diff =\n%s\nwant\n%s", diffs[1].Content, want)
+	}
+}
+
+func TestDiffRevisionsUnchanged(t *testing.T) {
+	pages := revision("one\n", "two\n")
+	diffs, report := diffRevisions("diffs", "doc", pages, revision("one\n", "two\n"), 0)
+	if len(diffs) != 0 || len(report.Pages) != 0 || report.Summary != (models.RevisionDiffSummary{}) {
+		t.Errorf("diffRevisions() of identical revisions = %v, %+v, want nothing", diffs, report)
+	}
+}
+
+func TestDiffRevisionsTruncates(t *testing.T) {
+	long := strings.Repeat("line\n", 100)
+	diffs, report := diffRevisions("diffs", "doc", revision("short\n"), revision(long), 64)
+	if len(diffs) != 1 || !strings.Contains(diffs[0].Content, "diff truncated") {
+		t.Fatalf("diffRevisions() = %v, want one truncated diff", diffs)
+	}
+	if !report.Pages[0].Truncated {
+		t.Error("report page not marked truncated")
+	}
+	if report.Summary.TotalLinesChanged != 101 {
+		t.Errorf("TotalLinesChanged = %d, want the untruncated 101", report.Summary.TotalLinesChanged)
+	}
+}
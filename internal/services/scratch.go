@@ -0,0 +1,129 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// ScratchConfig decides where the splitter keeps the files it works on. The default
+// temporary directory is memory-backed on gen2 functions, so a large upload, together
+// with its optimized copy and split pages, can exhaust the instance's memory.
+type ScratchConfig struct {
+	// Dir is a disk-backed mount used for uploads of at least LargeFileBytes. Empty means
+	// there is none and every upload uses the default temporary directory.
+	Dir            string
+	LargeFileBytes int64
+	// LargeFileUploadChunkBytes is the buffer each split page upload holds in memory when
+	// the upload is large. Zero leaves the client library's default.
+	LargeFileUploadChunkBytes int
+	// SplitSpaceFactor is the free space needed to split a PDF, as a multiple of its
+	// size. Zero disables the check.
+	SplitSpaceFactor float64
+}
+
+// scratchPlan is where and how one upload is processed.
+type scratchPlan struct {
+	Dir              string // Parent of the working directory; empty for the default.
+	Large            bool
+	UploadChunkBytes int // Zero for the client library's default.
+}
+
+// loadScratchConfig reads SCRATCH_DIR, LARGE_FILE_THRESHOLD_BYTES,
+// LARGE_FILE_UPLOAD_CHUNK_BYTES and SPLIT_SPACE_FACTOR.
+func loadScratchConfig() (ScratchConfig, error) {
+	config := ScratchConfig{Dir: gcp.GetEnv("SCRATCH_DIR", "")}
+	threshold, err := strconv.ParseInt(gcp.GetEnv("LARGE_FILE_THRESHOLD_BYTES", "268435456"), 10, 64)
+	if err != nil || threshold < 1 {
+		return config, fmt.Errorf("LARGE_FILE_THRESHOLD_BYTES must be a positive integer")
+	}
+	config.LargeFileBytes = threshold
+	chunkBytes, err := strconv.Atoi(gcp.GetEnv("LARGE_FILE_UPLOAD_CHUNK_BYTES", "2097152"))
+	if err != nil || chunkBytes < 0 {
+		return config, fmt.Errorf("LARGE_FILE_UPLOAD_CHUNK_BYTES must be a non-negative integer")
+	}
+	config.LargeFileUploadChunkBytes = chunkBytes
+	spaceFactor, err := strconv.ParseFloat(gcp.GetEnv("SPLIT_SPACE_FACTOR", "1.5"), 64)
+	if err != nil || spaceFactor < 0 {
+		return config, fmt.Errorf("SPLIT_SPACE_FACTOR must be a non-negative number")
+	}
+	config.SplitSpaceFactor = spaceFactor
+	if config.Dir != "" {
+		info, err := os.Stat(config.Dir)
+		if err != nil {
+			return config, fmt.Errorf("SCRATCH_DIR %s is not usable: %w", config.Dir, err)
+		}
+		if !info.IsDir() {
+			return config, fmt.Errorf("SCRATCH_DIR %s is not a directory", config.Dir)
+		}
+	}
+	return config, nil
+}
+
+// plan returns where to process an upload of size bytes. A negative size, for an object
+// whose size is unknown, is treated as large.
+func (c ScratchConfig) plan(size int64) scratchPlan {
+	if size >= 0 && size < c.LargeFileBytes {
+		return scratchPlan{}
+	}
+	return scratchPlan{Dir: c.Dir, Large: true, UploadChunkBytes: c.LargeFileUploadChunkBytes}
+}
+
+// peakFileSize returns the size of the largest of paths, ignoring any that cannot be
+// read.
+func peakFileSize(paths ...string) int64 {
+	var peak int64
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			peak = max(peak, info.Size())
+		}
+	}
+	return peak
+}
+
+// checkSplitSpace returns a rejection when the file system holding the PDF at path has
+// less free space than splitting it needs, so that an upload too large for the instance
+// fails with a clear status rather than with ENOSPC midway through the split. The check
+// is skipped when the free space cannot be read.
+func (c ScratchConfig) checkSplitSpace(logCtx *slog.Logger, path string) *pdfRejection {
+	return c.checkSpace(logCtx, path, freeSpace)
+}
+
+// checkSpace is checkSplitSpace with the free space of a directory read by free.
+func (c ScratchConfig) checkSpace(logCtx *slog.Logger, path string, free func(dir string) (int64, error)) *pdfRejection {
+	if c.SplitSpaceFactor == 0 {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		logCtx.Warn("Could not read the PDF size; splitting without the free space check.", "error", err)
+		return nil
+	}
+	available, err := free(filepath.Dir(path))
+	if err != nil {
+		logCtx.Warn("Could not read the free space; splitting without the free space check.", "error", err)
+		return nil
+	}
+	needed := int64(float64(info.Size()) * c.SplitSpaceFactor)
+	if available >= needed {
+		return nil
+	}
+	return &pdfRejection{
+		Status:  StatusRejectedTooLarge,
+		Details: fmt.Sprintf("The optimized PDF is %d bytes and splitting it needs about %d bytes of working space, but only %d bytes are free. Please split it into smaller documents.", info.Size(), needed, available),
+	}
+}
+
+// removeScratchFile deletes a working file that is no longer needed, to free its space
+// for the rest of the split. A file that cannot be deleted is left for the working
+// directory's cleanup.
+func removeScratchFile(logCtx *slog.Logger, path string) {
+	if err := os.Remove(path); err != nil {
+		logCtx.Warn("Failed to delete working file", "error", err, "path", path)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package services
+
+import (
+	"errors"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// freeSpace is not supported off unix, where the split space check is skipped.
+func freeSpace(dir string) (int64, error) {
+	return 0, errors.New("free space is only known on unix")
+}
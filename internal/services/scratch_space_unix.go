@@ -0,0 +1,18 @@
+//go:build unix
+
+package services
+
+import (
+	"syscall"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// freeSpace returns the bytes available to the function on the file system holding dir.
+func freeSpace(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
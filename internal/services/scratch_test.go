@@ -0,0 +1,158 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScratchConfigPlan(t *testing.T) {
+	config := ScratchConfig{Dir: "/mnt/scratch", LargeFileBytes: 1000, LargeFileUploadChunkBytes: 4096}
+	large := scratchPlan{Dir: "/mnt/scratch", Large: true, UploadChunkBytes: 4096}
+	tests := []struct {
+		name   string
+		config ScratchConfig
+		size   int64
+		want   scratchPlan
+	}{
+		{name: "empty upload", config: config, size: 0},
+		{name: "just under the threshold", config: config, size: 999},
+		{name: "at the threshold", config: config, size: 1000, want: large},
+		{name: "over the threshold", config: config, size: 1 << 40, want: large},
+		{name: "unknown size", config: config, size: -1, want: large},
+		{
+			name:   "large without a scratch mount",
+			config: ScratchConfig{LargeFileBytes: 1000, LargeFileUploadChunkBytes: 4096},
+			size:   1000,
+			want:   scratchPlan{Large: true, UploadChunkBytes: 4096},
+		},
+		{
+			name:   "large with the default upload buffer",
+			config: ScratchConfig{Dir: "/mnt/scratch", LargeFileBytes: 1000},
+			size:   1000,
+			want:   scratchPlan{Dir: "/mnt/scratch", Large: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.plan(tt.size); got != tt.want {
+				t.Errorf("plan(%d) = %+v, want %+v", tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadScratchConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    ScratchConfig
+		wantErr bool
+	}{
+		{name: "defaults", want: ScratchConfig{LargeFileBytes: 268435456, LargeFileUploadChunkBytes: 2097152, SplitSpaceFactor: 1.5}},
+		{
+			name: "set",
+			env:  map[string]string{"SCRATCH_DIR": dir, "LARGE_FILE_THRESHOLD_BYTES": "1000", "LARGE_FILE_UPLOAD_CHUNK_BYTES": "0", "SPLIT_SPACE_FACTOR": "0"},
+			want: ScratchConfig{Dir: dir, LargeFileBytes: 1000},
+		},
+		{name: "zero threshold", env: map[string]string{"LARGE_FILE_THRESHOLD_BYTES": "0"}, wantErr: true},
+		{name: "negative chunk", env: map[string]string{"LARGE_FILE_UPLOAD_CHUNK_BYTES": "-1"}, wantErr: true},
+		{name: "negative space factor", env: map[string]string{"SPLIT_SPACE_FACTOR": "-0.5"}, wantErr: true},
+		{name: "missing scratch dir", env: map[string]string{"SCRATCH_DIR": filepath.Join(dir, "missing")}, wantErr: true},
+		{name: "scratch dir is a file", env: map[string]string{"SCRATCH_DIR": file}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"SCRATCH_DIR", "LARGE_FILE_THRESHOLD_BYTES", "LARGE_FILE_UPLOAD_CHUNK_BYTES", "SPLIT_SPACE_FACTOR"} {
+				t.Setenv(key, "")
+				os.Unsetenv(key)
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			got, err := loadScratchConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadScratchConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("loadScratchConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPeakFileSize(t *testing.T) {
+	dir := t.TempDir()
+	small, large := filepath.Join(dir, "small.pdf"), filepath.Join(dir, "large.pdf")
+	if err := os.WriteFile(small, make([]byte, 10), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(large, make([]byte, 30), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := peakFileSize(small, filepath.Join(dir, "missing.pdf"), large); got != 30 {
+		t.Errorf("peakFileSize() = %d, want 30", got)
+	}
+	if got := peakFileSize(); got != 0 {
+		t.Errorf("peakFileSize() of nothing = %d, want 0", got)
+	}
+}
+
+func TestCheckSplitSpace(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "optimized.pdf")
+	if err := os.WriteFile(path, make([]byte, 1000), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		factor       float64
+		path         string
+		free         int64
+		freeErr      error
+		wantRejected bool
+	}{
+		{name: "plenty of space", factor: 1.5, path: path, free: 1 << 30},
+		{name: "exactly enough", factor: 1.5, path: path, free: 1500},
+		{name: "one byte short", factor: 1.5, path: path, free: 1499, wantRejected: true},
+		{name: "no space", factor: 1.5, path: path, free: 0, wantRejected: true},
+		{name: "check disabled", factor: 0, path: path, free: 0},
+		{name: "free space unknown", factor: 1.5, path: path, freeErr: errors.New("statfs: not supported")},
+		{name: "PDF missing", factor: 1.5, path: filepath.Join(dir, "missing.pdf"), free: 0},
+	}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var checkedDir string
+			free := func(dir string) (int64, error) {
+				checkedDir = dir
+				return tt.free, tt.freeErr
+			}
+			rejection := ScratchConfig{SplitSpaceFactor: tt.factor}.checkSpace(logCtx, tt.path, free)
+			if (rejection != nil) != tt.wantRejected {
+				t.Fatalf("checkSpace() = %+v, want rejected %v", rejection, tt.wantRejected)
+			}
+			if checkedDir != "" && checkedDir != dir {
+				t.Errorf("free space read for %q, want the PDF's directory %q", checkedDir, dir)
+			}
+			if rejection == nil {
+				return
+			}
+			if rejection.Status != StatusRejectedTooLarge {
+				t.Errorf("rejection status = %q, want %q", rejection.Status, StatusRejectedTooLarge)
+			}
+			if !strings.Contains(rejection.Details, "needs about 1500 bytes") {
+				t.Errorf("rejection details = %q, want the space needed", rejection.Details)
+			}
+		})
+	}
+}
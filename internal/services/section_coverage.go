@@ -0,0 +1,61 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"unicode"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// shortSectionChars is the content length, in letters and digits, below which a section is
+// logged as suspiciously short.
+const shortSectionChars = 40
+
+// loadSectionMinCoverage reads SECTION_MIN_COVERAGE.
+func loadSectionMinCoverage() (float64, error) {
+	minCoverage, err := strconv.ParseFloat(gcp.GetEnv("SECTION_MIN_COVERAGE", "0.95"), 64)
+	if err != nil || minCoverage < 0 || minCoverage > 1 {
+		return 0, fmt.Errorf("SECTION_MIN_COVERAGE must be a number between 0 and 1")
+	}
+	return minCoverage, nil
+}
+
+// contentChars counts the letters and digits in text. Whitespace, punctuation and markdown
+// syntax are ignored, so the count survives reformatting by the model.
+func contentChars(text string) int {
+	count := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			count++
+		}
+	}
+	return count
+}
+
+// sectionCoverage returns the fraction of the source's content found in the sections'
+// titles and content. A model that drops paragraphs while splitting scores below 1; one
+// that duplicates text can score above it. An empty source has full coverage.
+func sectionCoverage(source string, sections []parsedSection) float64 {
+	sourceChars := contentChars(stripPageAnchors(source))
+	if sourceChars == 0 {
+		return 1
+	}
+	sectionChars := 0
+	for _, section := range sections {
+		sectionChars += contentChars(section.Section) + contentChars(stripPageAnchors(section.Content))
+	}
+	return float64(sectionChars) / float64(sourceChars)
+}
+
+// shortSections returns the titles of sections with almost no content.
+func shortSections(sections []parsedSection) []string {
+	var titles []string
+	for _, section := range sections {
+		if contentChars(stripPageAnchors(section.Content)) < shortSectionChars {
+			titles = append(titles, section.Section)
+		}
+	}
+	return titles
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+var (
+	// atxHeaderRegex matches Markdown ATX headers such as "## 2. Scope".
+	atxHeaderRegex = regexp.MustCompile(`^ {0,3}#{1,6}[ \t]+(.*?)[ \t]*#*[ \t]*$`)
+	// numberedHeaderRegex matches lines starting with a multi-level number such as "3.2.1",
+	// capturing what follows. Single numbers are left alone because "1. Item" is far more
+	// often a list entry. See isNumberedHeader for which of these lines are headers.
+	numberedHeaderRegex = regexp.MustCompile(`^\d+(?:\.\d+)+\.?[ \t]+(\S.*?)[ \t]*$`)
+	// fenceRegex matches the opening or closing line of a fenced code block.
+	fenceRegex = regexp.MustCompile("^ {0,3}(```|~~~)")
+	// setextUnderlineRegex matches the underline of a setext header: "=" for level 1,
+	// "-" for level 2.
+	setextUnderlineRegex = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	// setextTitleExcludedRegex matches lines that cannot be a setext header's title: list
+	// items, block quotes, table rows and indented code.
+	setextTitleExcludedRegex = regexp.MustCompile(`^(?: {4}|\t| {0,3}(?:[-*+>|]|\d+[.)])(?:[ \t]|$))`)
+)
+
+// splitSectionsByHeaders deterministically splits markdown into sections at every ATX,
+// setext or multi-level numbered header outside code fences. Unlike the model, each section ends at
+// the next header of any level, so nested sections never overlap. Text before the first
+// header becomes a "preamble" section; a document with no headers is a single "document"
+// section.
+func splitSectionsByHeaders(markdown string) []parsedSection {
+	var sections []parsedSection
+	var current *parsedSection
+	var body strings.Builder
+
+	flush := func() {
+		content := strings.TrimSpace(body.String())
+		if current == nil && stripPageAnchors(content) == "" {
+			// Leading page anchors are carried into the first section.
+			return
+		}
+		body.Reset()
+		if current != nil {
+			current.Content = content
+			sections = append(sections, *current)
+		} else if content != "" {
+			sections = append(sections, parsedSection{Section: "preamble", Content: content, Level: 1})
+		}
+	}
+
+	lines := strings.Split(markdown, "\n")
+	headers, underlines := markdownHeaders(lines)
+	for i, line := range lines {
+		if header, ok := headers[i]; ok {
+			flush()
+			current = &header
+			continue
+		}
+		if underlines[i] {
+			continue
+		}
+		body.WriteString(strings.TrimRight(line, "\r"))
+		body.WriteString("\n")
+	}
+	flush()
+
+	if len(sections) == 1 && current == nil {
+		sections[0].Section = "document"
+	}
+	return sections
+}
+
+// markdownHeaders finds the ATX, setext and multi-level numbered headers among lines,
+// skipping code fences, and returns each header's title and level keyed by its line's
+// index, and the indexes of the setext underlines. Only a single line of text is taken
+// as a setext title, so a paragraph whose last line is underlined is left as text.
+func markdownHeaders(lines []string) (map[int]parsedSection, map[int]bool) {
+	headers := make(map[int]parsedSection)
+	underlines := make(map[int]bool)
+	var fence string
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if m := fenceRegex.FindStringSubmatch(line); m != nil {
+			switch fence {
+			case "":
+				fence = m[1]
+			case m[1]:
+				fence = ""
+			}
+			continue
+		}
+		if fence != "" {
+			continue
+		}
+		if underlines[i] {
+			continue
+		}
+		if m := atxHeaderRegex.FindStringSubmatch(line); m != nil && m[1] != "" {
+			headers[i] = parsedSection{Section: m[1], Level: strings.Count(strings.Fields(line)[0], "#")}
+		} else if level := setextLevel(lines, i); m == nil && level > 0 {
+			headers[i] = parsedSection{Section: strings.TrimSpace(line), Level: level}
+			underlines[i+1] = true
+		} else if m == nil && isNumberedHeader(line) {
+			title := strings.TrimSpace(line)
+			headers[i] = parsedSection{Section: title, Level: sectionLevel(title)}
+		}
+	}
+	return headers, underlines
+}
+
+// setextLevel returns the level of the setext header whose title is lines[i], or 0 if it
+// is not one: a line of text standing alone, after a blank line or at the start, and
+// underlined by the next.
+func setextLevel(lines []string, i int) int {
+	if i+1 >= len(lines) || strings.TrimSpace(stripPageAnchors(lines[i])) == "" || setextTitleExcludedRegex.MatchString(lines[i]) {
+		return 0
+	}
+	if i > 0 && strings.TrimSpace(stripPageAnchors(strings.TrimRight(lines[i-1], "\r"))) != "" {
+		return 0
+	}
+	m := setextUnderlineRegex.FindStringSubmatch(strings.TrimRight(lines[i+1], "\r"))
+	switch {
+	case m == nil:
+		return 0
+	case m[1][0] == '=':
+		return 1
+	default:
+		return 2
+	}
+}
+
+// maxNumberedHeaderWords is the most words a numbered header's title may have.
+const maxNumberedHeaderWords = 12
+
+// isNumberedHeader reports whether line is a numbered header such as "3.2.1 Scope". Prose
+// also starts with decimals, as in "1.5 mm plates are used here.", so the title after the
+// number must read as one: short, capitalised and not ended like a sentence.
+func isNumberedHeader(line string) bool {
+	m := numberedHeaderRegex.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+	title := m[1]
+	first, _ := utf8.DecodeRuneInString(title)
+	last, _ := utf8.DecodeLastRuneInString(title)
+	return unicode.IsUpper(first) &&
+		!strings.ContainsRune(".,;!?", last) &&
+		len(strings.Fields(title)) <= maxNumberedHeaderWords
+}
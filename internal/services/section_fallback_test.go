@@ -0,0 +1,91 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitSectionsByHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     []parsedSection
+	}{
+		{
+			name:     "nested headers",
+			markdown: "Issued 2024.\n\n# 1 Scope\nCovers pumps.\n\n## 1.1 Exclusions\nNot valves.\n\n2.1 Loads\nDead loads.\n",
+			want: []parsedSection{
+				{Section: "preamble", Content: "Issued 2024.", Level: 1},
+				{Section: "1 Scope", Content: "Covers pumps.", Level: 1},
+				{Section: "1.1 Exclusions", Content: "Not valves.", Level: 2},
+				{Section: "2.1 Loads", Content: "Dead loads.", Level: 2},
+			},
+		},
+		{
+			name:     "no headers",
+			markdown: "Just a note.\n\nWith two paragraphs.\n",
+			want:     []parsedSection{{Section: "document", Content: "Just a note.\n\nWith two paragraphs.", Level: 1}},
+		},
+		{
+			name:     "headers in code fences are ignored",
+			markdown: "# Setup\n```\n# not a header\n1.2 Not a header\n```\n~~~\n## neither\n~~~\nDone.\n",
+			want: []parsedSection{
+				{Section: "Setup", Content: "```\n# not a header\n1.2 Not a header\n```\n~~~\n## neither\n~~~\nDone.", Level: 1},
+			},
+		},
+		{
+			name:     "setext headers",
+			markdown: "Pump Manual\n===========\nIssued 2024.\n\nScope\n-----\nCovers pumps.\n\n### Exclusions\nNot valves.\n",
+			want: []parsedSection{
+				{Section: "Pump Manual", Content: "Issued 2024.", Level: 1},
+				{Section: "Scope", Content: "Covers pumps.", Level: 2},
+				{Section: "Exclusions", Content: "Not valves.", Level: 3},
+			},
+		},
+		{
+			name:     "underlines that are not setext headers",
+			markdown: "# Notes\nFirst line\nsecond line\n---\n\n- item\n---\n\n---\n| a |\n|---|\n",
+			want: []parsedSection{
+				{Section: "Notes", Content: "First line\nsecond line\n---\n\n- item\n---\n\n---\n| a |\n|---|", Level: 1},
+			},
+		},
+		{
+			name:     "prose starting with a decimal is not a header",
+			markdown: "# 3 Materials\n1.5 mm thick plates are used here.\n2.5 MPa is the design limit for the welds.\n",
+			want: []parsedSection{
+				{Section: "3 Materials", Content: "1.5 mm thick plates are used here.\n2.5 MPa is the design limit for the welds.", Level: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitSectionsByHeaders(tt.markdown); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitSectionsByHeaders() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsNumberedHeader(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{line: "3.2.1 Scope", want: true},
+		{line: "3.2. Design Loads", want: true},
+		{line: "1.5 Définitions", want: true},
+		{line: "4.1 Requirements for Welded Joints", want: true},
+		{line: "1.5 mm thick plates are used here.", want: false},
+		{line: "2.5 MPa is the design limit.", want: false},
+		{line: "3.2 The plates are welded on site", want: true},
+		{line: "3.2 The plates are welded on site.", want: false},
+		{line: "6.1 Where the flange is bolted to the casing and the gasket is compressed by more than", want: false},
+		{line: "1. Item", want: false},
+		{line: "12 Scope", want: false},
+	}
+	for _, tt := range tests {
+		if got := isNumberedHeader(tt.line); got != tt.want {
+			t.Errorf("isNumberedHeader(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
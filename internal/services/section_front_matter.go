@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/markdown"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// loadFrontMatter reads FRONT_MATTER, which makes the section splitter begin every
+// section file with YAML front matter describing it.
+func loadFrontMatter() (bool, error) {
+	frontMatter, err := strconv.ParseBool(gcp.GetEnv("FRONT_MATTER", "false"))
+	if err != nil {
+		return false, fmt.Errorf("FRONT_MATTER must be a boolean")
+	}
+	return frontMatter, nil
+}
+
+// originalFilename returns the name the document was uploaded under, or "" if it cannot
+// be read; front matter is still written without it.
+func originalFilename(ctx context.Context, logCtx *slog.Logger, client *firestore.Client, collection, docID string) string {
+	snap, err := client.Collection(collection).Doc(docID).Get(ctx)
+	if err != nil {
+		logCtx.Warn("Could not read the document's original filename for front matter", "error", err)
+		return ""
+	}
+	filename, _ := snap.DataAt("originalFilename")
+	name, _ := filename.(string)
+	return name
+}
+
+// sectionFile returns the content of a section's file: the section's content, preceded
+// by front matter when it is enabled. order is the section's 1-based position and pages
+// its page range, zero when unknown.
+func (f *SectionSplitterFunction) sectionFile(docID, filename string, order int, section parsedSection, pages [2]int, generatedAt time.Time) (string, error) {
+	if !f.config.FrontMatter {
+		return section.Content, nil
+	}
+	return markdown.RenderFrontMatter(markdown.FrontMatter{
+		DocumentID:       docID,
+		OriginalFilename: filename,
+		Title:            section.Section,
+		Order:            order,
+		StartPage:        pages[0],
+		EndPage:          pages[1],
+		GeneratedAt:      generatedAt,
+	}, section.Content)
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Section layouts. Flat writes every section directly under the document prefix; nested
+// writes each section under a directory per ancestor, e.g.
+// "{docId}/3_electrical/3_1_cabling/3_1_2_terminations.md".
+const (
+	SectionLayoutFlat   = "flat"
+	SectionLayoutNested = "nested"
+)
+
+// loadSectionLayout reads SECTION_LAYOUT.
+func loadSectionLayout() (string, error) {
+	layout := gcp.GetEnv("SECTION_LAYOUT", SectionLayoutFlat)
+	if layout != SectionLayoutFlat && layout != SectionLayoutNested {
+		return "", fmt.Errorf("SECTION_LAYOUT must be %q or %q, got %q", SectionLayoutFlat, SectionLayoutNested, layout)
+	}
+	return layout, nil
+}
+
+// parsedSectionLevel returns a section's header depth: the level of the header it was
+// split at, or else the depth of its number, with unnumbered titles at the top level.
+func parsedSectionLevel(section parsedSection) int {
+	if section.Level > 0 {
+		return section.Level
+	}
+	return sectionLevel(section.Section)
+}
+
+// sectionParents returns the index of each section's parent, or -1 for top-level
+// sections. A section's parent is the nearest section before it with a lower level, so
+// "3.1.2 Terminations" nests under "3.1 Cabling", and an unnumbered top-level title such
+// as "Appendix" closes every open section.
+func sectionParents(sections []parsedSection) []int {
+	parents := make([]int, len(sections))
+	var open []int // Indexes of the sections enclosing the current one, outermost first.
+	for i, section := range sections {
+		level := parsedSectionLevel(section)
+		for len(open) > 0 && parsedSectionLevel(sections[open[len(open)-1]]) >= level {
+			open = open[:len(open)-1]
+		}
+		parents[i] = -1
+		if len(open) > 0 {
+			parents[i] = open[len(open)-1]
+		}
+		open = append(open, i)
+	}
+	return parents
+}
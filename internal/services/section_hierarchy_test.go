@@ -0,0 +1,78 @@
+package services
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestSectionParents(t *testing.T) {
+	tests := []struct {
+		name     string
+		sections []parsedSection
+		want     []int
+	}{
+		{name: "none", want: []int{}},
+		{
+			name:     "numbered titles",
+			sections: []parsedSection{{Section: "3 Electrical"}, {Section: "3.1 Cabling"}, {Section: "3.1.2 Terminations"}, {Section: "3.2 Earthing"}, {Section: "Appendix"}},
+			want:     []int{-1, 0, 1, 0, -1},
+		},
+		{
+			name:     "skipped levels",
+			sections: []parsedSection{{Section: "Manual", Level: 1}, {Section: "Torque", Level: 3}, {Section: "Scope", Level: 2}, {Section: "Limits", Level: 4}, {Section: "Index", Level: 1}},
+			want:     []int{-1, 0, 0, 2, -1},
+		},
+		{
+			name:     "deeper than the first section",
+			sections: []parsedSection{{Section: "Limits", Level: 3}, {Section: "Scope", Level: 2}, {Section: "Torque", Level: 3}},
+			want:     []int{-1, -1, 1},
+		},
+		{
+			name:     "header level wins over the number",
+			sections: []parsedSection{{Section: "1 Scope", Level: 1}, {Section: "2 Loads", Level: 2}, {Section: "2.1.1 Wind"}},
+			want:     []int{-1, 0, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sectionParents(tt.sections); !slices.Equal(got, tt.want) {
+				t.Errorf("sectionParents() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSectionParentsOfMixedHeaders(t *testing.T) {
+	markdown := "Pump Manual\n===========\nIssued 2024.\n\n## Installation\nMount it.\n\n#### Torque\nHand tight.\n\nCommissioning\n-------------\nPrime it.\n\n### Checks\nListen.\n\nAppendix\n========\nTables.\n"
+	sections := splitSectionsByHeaders(markdown)
+	var titles []string
+	for _, section := range sections {
+		titles = append(titles, section.Section)
+	}
+	wantTitles := []string{"Pump Manual", "Installation", "Torque", "Commissioning", "Checks", "Appendix"}
+	if !slices.Equal(titles, wantTitles) {
+		t.Fatalf("splitSectionsByHeaders() titles = %q, want %q", titles, wantTitles)
+	}
+	// "Torque" skips level 3 and still nests under "Installation"; the setext level-2
+	// "Commissioning" closes it.
+	if got, want := sectionParents(sections), []int{-1, 0, 1, 0, 3, -1}; !slices.Equal(got, want) {
+		t.Errorf("sectionParents() = %v, want %v", got, want)
+	}
+}
+
+func TestLoadSectionLayout(t *testing.T) {
+	t.Setenv("SECTION_LAYOUT", "")
+	os.Unsetenv("SECTION_LAYOUT")
+	if got, err := loadSectionLayout(); err != nil || got != SectionLayoutFlat {
+		t.Errorf("loadSectionLayout() = %q, %v; want the default %q", got, err, SectionLayoutFlat)
+	}
+	t.Setenv("SECTION_LAYOUT", SectionLayoutNested)
+	if got, err := loadSectionLayout(); err != nil || got != SectionLayoutNested {
+		t.Errorf("loadSectionLayout() = %q, %v; want %q", got, err, SectionLayoutNested)
+	}
+	t.Setenv("SECTION_LAYOUT", "tree")
+	if _, err := loadSectionLayout(); err == nil {
+		t.Errorf("loadSectionLayout() accepted %q", "tree")
+	}
+}
@@ -2,15 +2,23 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"regexp"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
 	"cloud.google.com/go/storage"
 	"cloud.google.com/go/vertexai/genai"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
 		_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
 )
@@ -20,119 +28,501 @@ type SectionSplitterConfig struct {
 	ProjectID           string
 	VertexAIRegion      string
 	FinalSectionsBucket string
+	CollectionName      string
+	Models              gcp.VertexModelConfig
+	CompletionTopic     string // Optional. Pub/Sub topic notified when a document completes.
+	InputMode           string // MarkdownInputInline or MarkdownInputFileData.
+	Layout              string // SectionLayoutFlat or SectionLayoutNested.
+	// MinCoverage is the lowest acceptable share of the source's content found in the
+	// model's sections. Below it the document is split by headers instead.
+	MinCoverage float64
+	// Windows sets how a document too large for a single model call is split.
+	Windows SectionWindowConfig
+	// FrontMatter begins each section file with YAML front matter describing it.
+	FrontMatter bool
+	// CleanupIntermediates deletes the document's split pages, translated markdown and
+	// aggregated markdown once it is COMPLETE. Buckets names the buckets to clean.
+	CleanupIntermediates bool
+	Buckets              ArtifactBuckets
+	OutputNames          *OutputNames
+	OutputSigning        OutputSigningConfig
 }
 
 // SectionSplitterFunction holds dependencies for the section splitting logic.
 type SectionSplitterFunction struct {
-	storageClient *storage.Client
-	vertexClient  *gcp.VertexClient
-	config        SectionSplitterConfig
+	serviceClients
+	storageClient   *storage.Client
+	firestoreClient *firestore.Client
+	model           gcp.ContentGenerator
+	completionTopic *pubsub.Topic // Nil when no completion topic is configured.
+	store           objectstore.Client
+	profiles        *ProfileResolver
+	cancellation    *CancellationChecker
+	tenants         *DocumentTenants
+	signer          *outputSigner  // Nil unless SIGN_OUTPUT_URLS is enabled.
+	reporter        *stageReporter // Nil unless ORCHESTRATION_MODE is pubsub.
+	config          SectionSplitterConfig
 }
 
 // parsedSection defines the structure of the JSON objects we expect from the Gemini response.
 type parsedSection struct {
 	Section string `json:"section"`
 	Content string `json:"content"`
+	// Level is the header depth, 1 for top-level sections. The model does not report it,
+	// so it is inferred from the title when zero.
+	Level int `json:"-"`
 }
 
+// sectionManifestName is the object name, under the document prefix, of the manifest
+// listing a document's sections in order.
+const sectionManifestName = "_index.json"
+
 // NewSectionSplitter creates a new SectionSplitterFunction instance.
 func NewSectionSplitter(ctx context.Context) (*SectionSplitterFunction, error) {
-	projectID := gcp.GetEnv("GOOGLE_CLOUD_PROJECT_ID", "")
-	if projectID == "" {
-		return nil, fmt.Errorf("GOOGLE_CLOUD_PROJECT_ID environment variable must be set")
+	config, err := loadSectionSplitterConfig()
+	if err != nil {
+		return nil, err
 	}
 
-	config := SectionSplitterConfig{
-		ProjectID:           projectID,
-		VertexAIRegion:      gcp.GetEnv("VERTEX_AI_REGION", "us-central1"),
-		FinalSectionsBucket: gcp.GetEnv("FINAL_SECTIONS_BUCKET", ""),
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %w", err)
 	}
-	if config.FinalSectionsBucket == "" {
-		return nil, fmt.Errorf("FINAL_SECTIONS_BUCKET must be set")
+	signer, err := newOutputSigner(ctx, config.OutputSigning)
+	if err != nil {
+		return nil, err
 	}
 
-	storageClient, err := storage.NewClient(ctx)
+	if err := gcp.InitTracing(ctx, config.ProjectID); err != nil {
+		return nil, err
+	}
+	if err := gcp.InitMetrics(ctx, config.ProjectID); err != nil {
+		return nil, err
+	}
+
+	firestoreClient, err := gcp.NewFirestoreClient(ctx, config.ProjectID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create storage client: %w", err)
+		return nil, fmt.Errorf("failed to create firestore client: %w", err)
 	}
 
-	vertexClient, err := gcp.NewVertexClient(ctx, config.ProjectID, config.VertexAIRegion)
+	vertexClient, err := gcp.NewVertexClient(ctx, config.ProjectID, config.VertexAIRegion, config.Models)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create vertex client: %w", err)
 	}
 
+	clients := serviceClients{storageClient, firestoreClient, vertexClient}
+	var completionTopic *pubsub.Topic
+	if config.CompletionTopic != "" {
+		pubsubClient, err := gcp.NewPubSubClient(ctx, config.ProjectID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create pubsub client: %w", err)
+		}
+		completionTopic = pubsubClient.Topic(config.CompletionTopic)
+		// Stop flushes completion events still waiting to be published.
+		clients = append(clients, closerFunc(func() error {
+			completionTopic.Stop()
+			return pubsubClient.Close()
+		}))
+	}
+	reporter, err := newStageReporter(ctx, config.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	clients = clients.withReporter(reporter)
+	cancelCheckTTL, err := loadCancelCheckTTL()
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Section splitter initialized.", "model", config.Models.SectionSplitterModelName, "region", config.VertexAIRegion, "completionTopic", config.CompletionTopic, "inputMode", config.InputMode, "layout", config.Layout, "frontMatter", config.FrontMatter)
+
 	return &SectionSplitterFunction{
-		storageClient: storageClient,
-		vertexClient:  vertexClient,
-		config:        config,
+		serviceClients:  clients,
+		storageClient:   storageClient,
+		firestoreClient: firestoreClient,
+		model:           vertexClient.SectionSplitter(),
+		completionTopic: completionTopic,
+		store:           objectstore.NewGCS(storageClient),
+		profiles:        NewProfileResolver(firestoreClient, config.CollectionName, time.Minute),
+		cancellation:    NewCancellationChecker(firestoreClient, config.CollectionName, cancelCheckTTL),
+		tenants:         NewDocumentTenants(firestoreClient, config.CollectionName),
+		signer:          signer,
+		reporter:        reporter,
+		config:          *config,
 	}, nil
 }
 
+// loadSectionSplitterConfig loads and validates the section splitter's environment
+// variables.
+func loadSectionSplitterConfig() (*SectionSplitterConfig, error) {
+	projectID := gcp.GetEnv("GOOGLE_CLOUD_PROJECT_ID", "")
+	if projectID == "" {
+		return nil, fmt.Errorf("GOOGLE_CLOUD_PROJECT_ID environment variable must be set")
+	}
+
+	config := SectionSplitterConfig{
+		ProjectID:            projectID,
+		VertexAIRegion:       gcp.GetEnv("VERTEX_AI_REGION", "us-central1"),
+		FinalSectionsBucket:  gcp.GetEnv("FINAL_SECTIONS_BUCKET", ""),
+		CollectionName:       gcp.GetEnv("FIRESTORE_COLLECTION", "documents"),
+		Models:               gcp.LoadVertexModelConfig(),
+		CompletionTopic:      gcp.GetEnv("COMPLETION_TOPIC", ""),
+		CleanupIntermediates: gcp.GetEnv("CLEANUP_INTERMEDIATES", "false") == "true",
+		Buckets:              LoadArtifactBuckets(),
+	}
+	if config.FinalSectionsBucket == "" || config.Buckets.CleanedMarkdown == "" {
+		return nil, fmt.Errorf("FINAL_SECTIONS_BUCKET and CLEANED_MARKDOWN_BUCKET must be set")
+	}
+	inputMode, err := loadMarkdownInputMode()
+	if err != nil {
+		return nil, err
+	}
+	config.InputMode = inputMode
+	layout, err := loadSectionLayout()
+	if err != nil {
+		return nil, err
+	}
+	config.Layout = layout
+	minCoverage, err := loadSectionMinCoverage()
+	if err != nil {
+		return nil, err
+	}
+	config.MinCoverage = minCoverage
+	windows, err := loadSectionWindowConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Windows = windows
+	frontMatter, err := loadFrontMatter()
+	if err != nil {
+		return nil, err
+	}
+	config.FrontMatter = frontMatter
+	outputNames, err := loadOutputNames()
+	if err != nil {
+		return nil, err
+	}
+	config.OutputNames = outputNames
+	outputSigning, err := loadOutputSigningConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.OutputSigning = outputSigning
+	return &config, nil
+}
+
 // Process handles the core logic of splitting a markdown file into sections.
-func (f *SectionSplitterFunction) Process(ctx context.Context, req *models.SectionSplitterRequest) (*models.SectionSplitterResponse, error) {
+func (f *SectionSplitterFunction) Process(ctx context.Context, req *models.SectionSplitterRequest) (res *models.SectionSplitterResponse, err error) {
 	logCtx := slog.With("documentId", req.DocumentID, "executionId", req.ExecutionID)
+	ctx, span := startProcessSpan(ctx, "sectionSplitter.Process", req.Traceparent, attrDocumentID.String(req.DocumentID))
+	defer func() { endProcessSpan(ctx, span, err) }()
+	// Checked first, so that a request naming the wrong tenant leaves the document as is.
+	if err := f.tenants.Check(ctx, req.DocumentID, req.TenantID); err != nil {
+		logCtx.Error("Invalid section splitting request", "error", err, "tenantId", req.TenantID)
+		return nil, err
+	}
+	if err := checkExecution(ctx, logCtx, f.firestoreClient, f.config.CollectionName, req.DocumentID, req.ExecutionID); err != nil {
+		logCtx.Error("Refused section splitting request", "error", err)
+		return nil, err
+	}
+	timer := startStage(models.TokenUsageStageSectionSplitter)
+	defer func() {
+		status := ""
+		if res != nil {
+			status = res.Status
+		}
+		timing := timer.record(ctx, logCtx, f.firestoreClient, f.config.CollectionName, req.DocumentID, status, err)
+		if res != nil {
+			res.Timing = timing
+		}
+		if err != nil {
+			recordStageFailure(ctx, logCtx, f.firestoreClient, f.config.CollectionName, req.DocumentID, models.TokenUsageStageSectionSplitter, err)
+		}
+	}()
 	logCtx.Info("Starting section splitting.", "gcsUri", req.CleanedGCSUri)
-
-	// --- 1. Call the pre-configured section splitter model ---
-	model := f.vertexClient.SectionSplitterModel
-	prompt := genai.Text(gcp.SectionSplitterUserPrompt)
-	filePart := genai.FileData{
-		MIMEType: "text/markdown",
-		FileURI:  req.CleanedGCSUri,
+	if _, _, err := gcp.ValidateDocumentURI(req.CleanedGCSUri, req.DocumentID, req.TenantID, f.config.Buckets.CleanedMarkdown); err != nil {
+		logCtx.Error("Invalid section splitting request", "error", err)
+		return nil, err
 	}
+	if err := f.cancellation.Check(ctx, logCtx, req.DocumentID); err != nil {
+		return nil, err
+	}
+	if profile := f.profiles.ResolveOrDefault(ctx, logCtx, req.DocumentID); profile.SkipSections {
+		// The cleaned markdown is the document's final output.
+		logCtx.Info("Processing profile skips section splitting; completing the document.")
+		f.complete(ctx, logCtx, req.DocumentID, 0, sectionSplitStatusSkipped)
+		return &models.SectionSplitterResponse{Status: sectionSplitStatusSkipped}, nil
+	}
+	recordStatus(ctx, logCtx, f.firestoreClient, f.config.CollectionName, req.DocumentID, StatusSplittingSections, models.TokenUsageStageSectionSplitter)
 
-	resp, err := model.GenerateContent(ctx, filePart, prompt)
+	// --- 1. Read the cleaned markdown, rejecting an empty file before any model call ---
+	// The content is read even in file data mode, since the header fallback needs it.
+	markdown, err := readMarkdown(ctx, f.storageClient, req.CleanedGCSUri)
 	if err != nil {
-		logCtx.Error("Call to Vertex AI for section splitting failed", "error", err)
-		return nil, fmt.Errorf("failed to generate sections from gemini: %w", err)
+		logCtx.Error("Failed to read cleaned markdown", "error", err, "gcsUri", req.CleanedGCSUri)
+		return nil, sourceError(err)
 	}
+	if strings.TrimSpace(markdown) == "" {
+		err := fmt.Errorf("%w: %s", ErrEmptyMarkdown, req.CleanedGCSUri)
+		logCtx.Error("Cleaned markdown has no content to split", "error", err)
+		return nil, sourceError(err)
+	}
+	logCtx.Info("Splitting cleaned markdown.", "sizeBytes", len(markdown), "inputMode", f.config.InputMode)
 
-	// --- 2. Extract and parse the JSON response ---
-	jsonString := f.extractJSONContent(resp)
-	if jsonString == "" {
-		err := fmt.Errorf("gemini returned an empty response instead of JSON for document ID %s", req.DocumentID)
-		logCtx.Error("Empty response from Gemini", "error", err)
+	// --- 2. Split it with the model, in windows when it is large, or else by its headers ---
+	var markdownPart genai.Part = genai.Text(markdown)
+	if f.config.InputMode == MarkdownInputFileData {
+		markdownPart = genai.FileData{
+			MIMEType: "text/markdown",
+			FileURI:  req.CleanedGCSUri,
+		}
+	}
+	split, err := f.generateSections(ctx, logCtx, req.DocumentID, markdownPart, markdown)
+	if err != nil {
 		return nil, err
 	}
-
-	var sections []parsedSection
-	if err := json.Unmarshal([]byte(jsonString), &sections); err != nil {
-		logCtx.Error("Failed to unmarshal JSON response from Gemini", "error", err, "responseBody", jsonString)
-		return nil, fmt.Errorf("failed to parse JSON from model for document ID %s: %w", req.DocumentID, err)
+	usage := split.Usage
+	if err := recordTokenUsage(ctx, f.firestoreClient, f.config.CollectionName, req.DocumentID, models.TokenUsageStageSectionSplitter, usage); err != nil {
+		logCtx.Warn("Failed to record token usage", "error", err)
 	}
+	sections, status, coverage := split.Sections, split.Status, split.Coverage
 
 	if len(sections) == 0 {
-		logCtx.Warn("Model returned a valid but empty JSON array. No sections to process.")
-		return &models.SectionSplitterResponse{Status: "success", SectionCount: 0}, nil
+		logCtx.Warn("No sections to process.", "status", status)
+		f.complete(ctx, logCtx, req.DocumentID, 0, status)
+		return &models.SectionSplitterResponse{Status: status, SectionCount: 0, Usage: usage, Model: f.config.Models.SectionSplitterModelName, Coverage: coverage, WindowCount: split.WindowCount, MergeConflicts: split.MergeConflicts > 0}, nil
 	}
 
-	// --- 3. Save each section to a separate file in GCS ---
+	// --- 3. Save each section to a separate file in GCS, then the manifest ---
 	logCtx.Info("Successfully parsed sections. Saving to GCS...", "sectionCount", len(sections))
-	bucketHandle := f.storageClient.Bucket(f.config.FinalSectionsBucket)
-	var savedCount int
+	bucket := objectstore.WrapBucket(f.storageClient.Bucket(f.config.FinalSectionsBucket))
+	var filename string
+	if f.config.FrontMatter {
+		filename = originalFilename(ctx, logCtx, f.firestoreClient, f.config.CollectionName, req.DocumentID)
+	}
+	savedCount, summaries, manifestURI, err := f.saveSections(ctx, logCtx, bucket, req.DocumentID, req.TenantID, filename, sections)
+	if err != nil {
+		return nil, outputError(err)
+	}
+
+	logCtx.Info("Section splitting complete.", "savedCount", savedCount, "totalSections", len(sections), "status", status)
+	f.complete(ctx, logCtx, req.DocumentID, savedCount, status)
+
+	return &models.SectionSplitterResponse{
+		Status:         status,
+		SectionCount:   savedCount,
+		Usage:          usage,
+		Model:          f.config.Models.SectionSplitterModelName,
+		Sections:       summaries,
+		ManifestURI:    manifestURI,
+		Coverage:       coverage,
+		WindowCount:    split.WindowCount,
+		MergeConflicts: split.MergeConflicts > 0,
+	}, nil
+}
+
+// sectionSplit is the outcome of splitting a document into sections.
+type sectionSplit struct {
+	Sections []parsedSection
+	Status   string // "success", or "success_fallback" when split by headers.
+	Coverage float64
+	Usage    *models.TokenUsage
+	// WindowCount is the number of windows the document was split in, or zero when it was
+	// sent whole, and MergeConflicts the number of sections two windows disagreed on.
+	WindowCount    int
+	MergeConflicts int
+}
+
+// generateSections runs the model over markdown, or over windows of it when it exceeds
+// the window threshold, falling back to splitting markdown by its headers when the
+// model's output is not valid section JSON or does not cover the source. markdownPart is
+// the markdown as it is sent whole.
+func (f *SectionSplitterFunction) generateSections(ctx context.Context, logCtx *slog.Logger, docID string, markdownPart genai.Part, markdown string) (*sectionSplit, error) {
+	split := &sectionSplit{Status: "success"}
+	if windows := f.config.Windows.split(markdown); len(windows) > 1 {
+		logCtx.Info("Cleaned markdown exceeds the window threshold; splitting sections in windows.", "sizeBytes", len(markdown), "thresholdBytes", f.config.Windows.ThresholdBytes, "windowCount", len(windows))
+		sections, usage, conflicts, fellBack, err := f.splitInWindows(ctx, logCtx, docID, windows)
+		if err != nil {
+			return nil, err
+		}
+		split.Sections, split.Usage, split.WindowCount, split.MergeConflicts = sections, usage, len(windows), conflicts
+		if fellBack {
+			split.Status = "success_fallback"
+		}
+		if conflicts > 0 {
+			logCtx.Warn("Windows disagreed on sections they overlap; kept the longer version of each.", "conflicts", conflicts)
+		}
+	} else {
+		genCtx, genSpan := startSpan(gcp.WithAuditScope(ctx, docID, "document"), "gemini.GenerateContent")
+		resp, err := f.model.GenerateContent(genCtx, markdownPart, genai.Text(gcp.SectionSplitterUserPrompt))
+		endSpan(genSpan, err)
+		if filtered := asFilteredResponseError(resp, err); filtered != nil {
+			logCtx.Warn("Gemini blocked section splitting", "error", filtered)
+			return nil, modelError(fmt.Errorf("failed to generate sections: %w", filtered))
+		}
+		if err != nil {
+			logCtx.Error("Call to Vertex AI for section splitting failed", "error", err)
+			return nil, modelError(fmt.Errorf("failed to generate sections from gemini: %w", err))
+		}
+		split.Usage = tokenUsageFrom(resp)
+		split.Sections, err = f.decodeSections(resp, docID)
+		if err != nil {
+			logCtx.Warn("Model output is not valid section JSON; falling back to header-based splitting.", "error", err)
+			split.Sections, split.Status = splitSectionsByHeaders(markdown), "success_fallback"
+		}
+	}
+
+	// The model can silently drop paragraphs, so check its sections cover the source.
+	split.Coverage = sectionCoverage(markdown, split.Sections)
+	if short := shortSections(split.Sections); len(short) > 0 {
+		logCtx.Warn("Some sections are suspiciously short.", "count", len(short), "titles", short)
+	}
+	if split.Status == "success" && split.Coverage < f.config.MinCoverage {
+		logCtx.Warn("Model sections do not cover the source; falling back to header-based splitting.", "coverage", split.Coverage, "minCoverage", f.config.MinCoverage, "sectionCount", len(split.Sections))
+		split.Sections, split.Status = splitSectionsByHeaders(markdown), "success_fallback"
+		split.Coverage = sectionCoverage(markdown, split.Sections)
+	}
+	logCtx.Info("Section coverage checked.", "coverage", split.Coverage, "status", split.Status)
+	return split, nil
+}
+
+// saveSections saves each section of document docID, of tenant tenantID, as its own
+// object under the document's prefix, followed by the manifest describing them. A section that fails to save is logged and skipped. It
+// returns the number of sections saved, their summaries and the manifest's URI. With
+// front matter enabled, each section names filename as the document's original file.
+func (f *SectionSplitterFunction) saveSections(ctx context.Context, logCtx *slog.Logger, bucket objectstore.Bucket, docID, tenantID, filename string, sections []parsedSection) (int, []models.SectionSummary, string, error) {
+	var savedCount, existingCount int
+	var summaries []models.SectionSummary
+	outputDoc, err := f.config.OutputNames.Document(ctx, f.firestoreClient, f.config.CollectionName, docID, tenantID)
+	if err != nil {
+		logCtx.Error("Failed to resolve output names", "error", err)
+		return 0, nil, "", err
+	}
+
+	// Page anchors carried through from the aggregator give each section's page span;
+	// they are stripped from the saved content.
+	pageRanges := sectionPageRanges(sections)
+
+	manifest := models.SectionManifest{DocumentID: docID, Sections: []models.SectionManifestEntry{}}
+	usedNames := make(map[string]bool, len(sections))
+
+	// Numbered titles and header levels give each section's parent. In the nested layout
+	// a section is saved under its parent's path, which is recorded even when the parent
+	// fails to save so that its children still nest.
+	parents := sectionParents(sections)
+	children := make([][]int, len(sections))
+	for i, parent := range parents {
+		if parent >= 0 {
+			children[parent] = append(children[parent], i+1)
+		}
+	}
+	paths := make([]string, len(sections))
+	generatedAt := time.Now().UTC().Truncate(time.Second)
 
 	for i, section := range sections {
+		section.Content = stripPageAnchors(section.Content)
 		sanitizedTitle := f.sanitizeFileName(section.Section)
 		if sanitizedTitle == "" {
 			sanitizedTitle = fmt.Sprintf("untitled_section_%d", i+1)
 		}
+		name := sanitizedTitle
+		if f.config.Layout == SectionLayoutNested && parents[i] >= 0 {
+			name = paths[parents[i]] + "/" + sanitizedTitle
+		}
+		paths[i] = uniqueSectionName(name, usedNames)
 
-		objectName := fmt.Sprintf("%s/%s.md", req.DocumentID, sanitizedTitle)
+		objectName, err := f.config.OutputNames.Section(outputDoc, paths[i], sanitizedTitle, i+1)
+		if err != nil {
+			logCtx.Error("Failed to name section", "error", err, "sectionTitle", section.Section)
+			continue
+		}
+
+		fileContent, err := f.sectionFile(docID, filename, i+1, section, pageRanges[i], generatedAt)
+		if err != nil {
+			logCtx.Error("Failed to render section front matter", "error", err, "sectionTitle", section.Section)
+			continue
+		}
 
-		if err := gcp.SaveToGCSAtomically(ctx, bucketHandle, objectName, section.Content); err != nil {
-			logCtx.Error("Failed to save section", "error", err, "sectionTitle", section.Section, "objectName", objectName)
+		result, err := gcp.SaveToGCS(ctx, logCtx, bucket, objectName, fileContent, gcp.SaveOptions{})
+		if err != nil {
+			logCtx.Error("Failed to save section", "error", err, "sectionTitle", section.Section, "gcsObject", objectName)
 			// We choose to continue processing other sections even if one fails.
 		} else {
+			if result == gcp.SaveExisted {
+				existingCount++
+			}
 			savedCount++
+			summaries = append(summaries, models.SectionSummary{
+				Title:     section.Section,
+				StartPage: pageRanges[i][0],
+				EndPage:   pageRanges[i][1],
+				GCSUri:    fmt.Sprintf("gs://%s/%s", bucket.Name(), objectName),
+				SignedURL: f.signer.sign(ctx, logCtx, bucket.Name(), objectName),
+			})
+			level := parsedSectionLevel(section)
+			manifest.Sections = append(manifest.Sections, models.SectionManifestEntry{
+				Order:         i + 1,
+				OriginalTitle: section.Section,
+				ObjectName:    objectName,
+				ContentLength: len(section.Content),
+				Level:         level,
+				StartPage:     pageRanges[i][0],
+				EndPage:       pageRanges[i][1],
+				Parent:        parents[i] + 1,
+				Children:      children[i],
+			})
 		}
 	}
 
-	logCtx.Info("Section splitting complete.", "savedCount", savedCount, "totalSections", len(sections))
+	if existingCount > 0 {
+		// A retried invocation finds the sections of the earlier attempt already saved.
+		logCtx.Info("Kept sections saved by an earlier attempt.", "existingCount", existingCount, "savedCount", savedCount)
+	}
+	manifestURI, err := f.saveManifest(ctx, logCtx, bucket, documentRoot(tenantID, docID), &manifest)
+	if err != nil {
+		return savedCount, summaries, "", err
+	}
+	return savedCount, summaries, manifestURI, nil
+}
 
-	return &models.SectionSplitterResponse{
-		Status:       "success",
-		SectionCount: savedCount,
-	}, nil
+// saveManifest writes the section manifest under root, the document's prefix, and returns
+// its URI.
+func (f *SectionSplitterFunction) saveManifest(ctx context.Context, logCtx *slog.Logger, bucket objectstore.Bucket, root string, manifest *models.SectionManifest) (string, error) {
+	objectName := fmt.Sprintf("%s/%s", root, sectionManifestName)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		logCtx.Error("Failed to encode section manifest", "error", err)
+		return "", fmt.Errorf("failed to encode section manifest: %w", err)
+	}
+	if err := gcp.SaveToGCSAtomically(ctx, logCtx, bucket, objectName, string(data)); err != nil {
+		logCtx.Error("Failed to save section manifest", "error", err, "gcsObject", objectName)
+		return "", err
+	}
+	return fmt.Sprintf("gs://%s/%s", bucket.Name(), objectName), nil
+}
+
+// uniqueSectionName returns name, or name with the lowest numeric suffix ("name_2",
+// "name_3", ...) not yet in used, and records the result in used. Without it, sections
+// whose titles sanitize to the same name would overwrite one another.
+func uniqueSectionName(name string, used map[string]bool) string {
+	candidate := name
+	for n := 2; used[candidate]; n++ {
+		candidate = fmt.Sprintf("%s_%d", name, n)
+	}
+	used[candidate] = true
+	return candidate
+}
+
+// sectionNumberRegex matches a leading section number such as "3", "3." or "3.2.1".
+var sectionNumberRegex = regexp.MustCompile(`^(\d+(?:\.\d+)*)\.?(?:\s|$)`)
+
+// sectionLevel infers a header depth from a numbered title, e.g. 3 for "3.2.1 Scope".
+// Unnumbered titles are treated as top level.
+func sectionLevel(title string) int {
+	m := sectionNumberRegex.FindStringSubmatch(strings.TrimSpace(title))
+	if m == nil {
+		return 1
+	}
+	return strings.Count(m[1], ".") + 1
 }
 
 // extractJSONContent robustly gets the raw text content from the model response.
@@ -155,21 +545,54 @@ func (f *SectionSplitterFunction) extractJSONContent(resp *genai.GenerateContent
 var nonAlphanumericRegex = regexp.MustCompile(`[^a-z0-9]+`)
 
 // sanitizeFileName converts a section title into a safe GCS object name component.
+// Accented Latin letters are transliterated to ASCII. Letters and digits that have no
+// ASCII form, such as CJK characters, are kept as "u" followed by their hex code point,
+// so distinct titles stay distinct. Titles with nothing usable, and titles that had to
+// be truncated, get a short hash of the original title so names remain unique.
 func (f *SectionSplitterFunction) sanitizeFileName(title string) string {
-	// Convert to lowercase
-	lower := strings.ToLower(title)
+	// Convert to lowercase and transliterate what we can
+	lower := models.FoldToASCII(strings.ToLower(title))
+
+	var encoded strings.Builder
+	for _, r := range lower {
+		switch {
+		case r < utf8.RuneSelf:
+			encoded.WriteRune(r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			fmt.Fprintf(&encoded, "_u%x_", r)
+		default:
+			encoded.WriteByte(' ')
+		}
+	}
+
 	// Replace any sequence of non-alphanumeric characters with a single underscore
-	sanitized := nonAlphanumericRegex.ReplaceAllString(lower, "_")
+	sanitized := nonAlphanumericRegex.ReplaceAllString(encoded.String(), "_")
 	// Remove leading/trailing underscores
 	sanitized = strings.Trim(sanitized, "_")
 
+	if sanitized == "" {
+		if strings.TrimSpace(title) == "" {
+			return ""
+		}
+		return "section_" + titleHash(title)
+	}
+
 	// Truncate to a reasonable length to avoid overly long filenames
 	const maxLength = 100
 	if len(sanitized) > maxLength {
-		sanitized = sanitized[:maxLength]
-		// Trim again in case we cut on an underscore
-		sanitized = strings.Trim(sanitized, "_")
+		cut := sanitized[:maxLength-9]
+		// Drop a word or encoded character cut in two, unless it is the only one.
+		if i := strings.LastIndexByte(cut, '_'); i > 0 && sanitized[len(cut)] != '_' {
+			cut = cut[:i]
+		}
+		sanitized = strings.Trim(cut, "_") + "_" + titleHash(title)
 	}
 
 	return sanitized
 }
+
+// titleHash returns a short, stable hash of a section title.
+func titleHash(title string) string {
+	sum := sha256.Sum256([]byte(title))
+	return hex.EncodeToString(sum[:4])
+}
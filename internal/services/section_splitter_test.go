@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/vertexai/genai"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/markdown"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	longTitle := strings.Repeat("Very long title words ", 10)
+	longCJK := strings.Repeat("长", 40)
+	tests := []struct {
+		name  string
+		title string
+		want  string
+	}{
+		{name: "German umlauts", title: "3.1 Überblick über die Anlage", want: "3_1_uberblick_uber_die_anlage"},
+		{name: "German sharp s", title: "Straße & Maßnahmen", want: "strasse_massnahmen"},
+		{name: "French accents", title: "Sécurité électrique — Généralités", want: "securite_electrique_generalites"},
+		{name: "ligatures", title: "Ça va? Œuvre Ærø", want: "ca_va_oeuvre_aero"},
+		{name: "CJK", title: "第3章 安全要求", want: "u7b2c_3_u7ae0_u5b89_u5168_u8981_u6c42"},
+		{name: "Cyrillic", title: "Введение", want: "u432_u432_u435_u434_u435_u43d_u438_u435"},
+		{name: "emoji are dropped", title: "🚀 Launch 🚀", want: "launch"},
+		{name: "only emoji", title: "🚀", want: "section_" + titleHash("🚀")},
+		{name: "blank", title: "   ", want: ""},
+		{
+			name:  "long title",
+			title: longTitle,
+			want:  "very_long_title_words_very_long_title_words_very_long_title_words_very_long_title_words_" + titleHash(longTitle),
+		},
+		{
+			name:  "long CJK title",
+			title: longCJK,
+			want:  strings.Repeat("u957f_", 15) + titleHash(longCJK),
+		},
+	}
+	f := &SectionSplitterFunction{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := f.sanitizeFileName(tt.title)
+			if got != tt.want {
+				t.Errorf("sanitizeFileName(%q) = %q, want %q", tt.title, got, tt.want)
+			}
+			if len(got) > 100 {
+				t.Errorf("sanitizeFileName(%q) is %d bytes long, want at most 100", tt.title, len(got))
+			}
+		})
+	}
+}
+
+func TestSanitizeFileNameKeepsTitlesDistinct(t *testing.T) {
+	f := &SectionSplitterFunction{}
+	titles := []string{
+		"安全要求", "安全规定", "Введение", "Заключение",
+		strings.Repeat("长", 40) + "一", strings.Repeat("长", 40) + "二",
+	}
+	seen := make(map[string]string)
+	for _, title := range titles {
+		name := f.sanitizeFileName(title)
+		if other, ok := seen[name]; ok {
+			t.Errorf("%q and %q both give %q", other, title, name)
+		}
+		seen[name] = title
+		if again := f.sanitizeFileName(title); again != name {
+			t.Errorf("sanitizeFileName(%q) is not stable: %q, then %q", title, name, again)
+		}
+	}
+}
+
+func TestUniqueSectionName(t *testing.T) {
+	used := make(map[string]bool)
+	var got []string
+	for _, name := range []string{"scope", "scope", "scope_2", "scope"} {
+		got = append(got, uniqueSectionName(name, used))
+	}
+	if want := "scope scope_2 scope_2_2 scope_3"; strings.Join(got, " ") != want {
+		t.Errorf("uniqueSectionName() = %v, want %s", got, want)
+	}
+}
+
+func TestSectionFile(t *testing.T) {
+	section := parsedSection{Section: `Pumps: "rotary"`, Content: "Text.", Level: 1}
+	generatedAt := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+	off := &SectionSplitterFunction{}
+	if got, err := off.sectionFile("doc-1", "manual.pdf", 2, section, [2]int{4, 6}, generatedAt); err != nil || got != "Text." {
+		t.Errorf("sectionFile() without front matter = %q, %v, want %q", got, err, "Text.")
+	}
+
+	on := &SectionSplitterFunction{config: SectionSplitterConfig{FrontMatter: true}}
+	file, err := on.sectionFile("doc-1", "manual.pdf", 2, section, [2]int{4, 6}, generatedAt)
+	if err != nil {
+		t.Fatalf("sectionFile() error = %v", err)
+	}
+	fm, content, err := markdown.ParseFrontMatter(file)
+	if err != nil {
+		t.Fatalf("ParseFrontMatter() error = %v", err)
+	}
+	want := markdown.FrontMatter{DocumentID: "doc-1", OriginalFilename: "manual.pdf", Title: section.Section, Order: 2, StartPage: 4, EndPage: 6, GeneratedAt: generatedAt}
+	if fm == nil || *fm != want || content != "Text." {
+		t.Errorf("sectionFile() parsed back to %+v, %q, want %+v, %q", fm, content, want, "Text.")
+	}
+}
+
+func TestGenerateSectionsBlockedCandidate(t *testing.T) {
+	model := testsupport.NewFakeContentGenerator(testsupport.FakeResult{Err: testsupport.BlockedCandidateError(genai.HarmCategorySexuallyExplicit)})
+	f := &SectionSplitterFunction{model: model}
+	source := "# Pump\n\nCheck the impeller.\n\n# Valve\n\nCheck the seat.\n"
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// A blocked split fails rather than falling back to the headers, which would pass off
+	// the blocked content as split.
+	split, err := f.generateSections(context.Background(), logCtx, "doc-1", genai.Text(source), source)
+	if split != nil {
+		t.Errorf("generateSections() = %+v, want no split", split)
+	}
+	var filtered *FilteredResponseError
+	if !errors.As(err, &filtered) || models.CodeOf(err) != models.ErrorCodeLLMBlocked {
+		t.Fatalf("generateSections() error = %v, want an LLM_BLOCKED FilteredResponseError", err)
+	}
+	if filtered.Reason() != genai.FinishReasonSafety.String() || filtered.Category() != genai.HarmCategorySexuallyExplicit.String() {
+		t.Errorf("blocked by %s (%s), want %s (%s)", filtered.Reason(), filtered.Category(), genai.FinishReasonSafety, genai.HarmCategorySexuallyExplicit)
+	}
+	if calls := model.Calls(); len(calls) != 1 {
+		t.Errorf("model called %d times, want once", len(calls))
+	}
+}
@@ -0,0 +1,354 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"golang.org/x/sync/errgroup"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// SectionWindowConfig sets how cleaned markdown too large for a single section splitting
+// call is split in windows, each sent to the model on its own.
+type SectionWindowConfig struct {
+	ThresholdBytes int // Larger cleaned markdown is split in windows.
+	TargetBytes    int // Approximate size of each window.
+	// OverlapBytes caps the text at the end of a window repeated at the start of the next,
+	// so that a section cut by the window boundary is also seen by the next window.
+	OverlapBytes int
+	Concurrency  int // Maximum concurrent window calls.
+}
+
+// loadSectionWindowConfig reads SECTION_WINDOW_THRESHOLD_BYTES,
+// SECTION_WINDOW_TARGET_BYTES, SECTION_WINDOW_OVERLAP_BYTES and
+// SECTION_WINDOW_CONCURRENCY.
+func loadSectionWindowConfig() (SectionWindowConfig, error) {
+	var config SectionWindowConfig
+	threshold, err := strconv.Atoi(gcp.GetEnv("SECTION_WINDOW_THRESHOLD_BYTES", "262144"))
+	if err != nil || threshold <= 0 {
+		return config, fmt.Errorf("SECTION_WINDOW_THRESHOLD_BYTES must be a positive integer")
+	}
+	config.ThresholdBytes = threshold
+	target, err := strconv.Atoi(gcp.GetEnv("SECTION_WINDOW_TARGET_BYTES", "65536"))
+	if err != nil || target <= 0 {
+		return config, fmt.Errorf("SECTION_WINDOW_TARGET_BYTES must be a positive integer")
+	}
+	config.TargetBytes = target
+	overlap, err := strconv.Atoi(gcp.GetEnv("SECTION_WINDOW_OVERLAP_BYTES", "4096"))
+	if err != nil || overlap < 0 || overlap >= target {
+		return config, fmt.Errorf("SECTION_WINDOW_OVERLAP_BYTES must be a non-negative integer below SECTION_WINDOW_TARGET_BYTES")
+	}
+	config.OverlapBytes = overlap
+	concurrency, err := strconv.Atoi(gcp.GetEnv("SECTION_WINDOW_CONCURRENCY", "4"))
+	if err != nil || concurrency < 1 {
+		return config, fmt.Errorf("SECTION_WINDOW_CONCURRENCY must be a positive integer")
+	}
+	config.Concurrency = concurrency
+	return config, nil
+}
+
+// split returns the windows markdown is sent to the model in: markdown itself when it is
+// within the threshold, otherwise the windows sectionWindows gives.
+func (c SectionWindowConfig) split(markdown string) []string {
+	if c.ThresholdBytes == 0 || len(markdown) <= c.ThresholdBytes {
+		return []string{markdown}
+	}
+	return sectionWindows(markdown, c.TargetBytes, c.OverlapBytes)
+}
+
+// sectionWindows groups the pieces of markdown into windows of roughly targetBytes. A
+// window after the first begins with as many of the previous window's last pieces as fit
+// in overlapBytes, and always ends with at least one piece the previous window did not
+// have. The windows are made of whole lines of markdown and, overlap aside, cover it.
+func sectionWindows(markdown string, targetBytes, overlapBytes int) []string {
+	pieces := sectionWindowPieces(markdown, targetBytes)
+	var windows []string
+	start, fresh := 0, 0 // fresh is the first piece no window has had yet.
+	for {
+		end, size := start, 0
+		for end < len(pieces) && (end <= fresh || size+len(pieces[end]) <= targetBytes) {
+			size += len(pieces[end])
+			end++
+		}
+		windows = append(windows, strings.Join(pieces[start:end], ""))
+		if end == len(pieces) {
+			return windows
+		}
+		next, overlap := end, 0
+		for next-1 > start && overlap+len(pieces[next-1]) <= overlapBytes {
+			next--
+			overlap += len(pieces[next])
+		}
+		start, fresh = next, end
+	}
+}
+
+// sectionWindowPieces splits markdown at each of its headers, as splitSectionsByHeaders
+// finds them, so that windows begin at a header where they can. A piece larger than
+// maxBytes, a long section without subheaders, is split further between paragraphs.
+func sectionWindowPieces(markdown string, maxBytes int) []string {
+	lines := strings.Split(markdown, "\n")
+	headers, _ := markdownHeaders(lines)
+	var pieces []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			pieces = append(pieces, splitParagraphs(current.String(), maxBytes)...)
+			current.Reset()
+		}
+	}
+	for i, line := range lines {
+		if _, ok := headers[i]; ok {
+			flush()
+		}
+		current.WriteString(line)
+		if i < len(lines)-1 {
+			current.WriteString("\n")
+		}
+	}
+	flush()
+	return pieces
+}
+
+// splitParagraphs splits text between paragraphs into parts of at most maxBytes. A single
+// paragraph larger than maxBytes is a part of its own.
+func splitParagraphs(text string, maxBytes int) []string {
+	if len(text) <= maxBytes {
+		return []string{text}
+	}
+	var parts []string
+	var current strings.Builder
+	for _, paragraph := range strings.SplitAfter(text, "\n\n") {
+		if current.Len() > 0 && current.Len()+len(paragraph) > maxBytes {
+			parts = append(parts, current.String())
+			current.Reset()
+		}
+		current.WriteString(paragraph)
+	}
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+// splitInWindows runs the model over each window of a document and merges the sections
+// of all windows. A window whose output is not valid section JSON is split by its headers
+// instead, and fellBack reports whether any was. It returns the merged sections, the
+// combined token usage and the number of merge conflicts.
+func (f *SectionSplitterFunction) splitInWindows(ctx context.Context, logCtx *slog.Logger, docID string, windows []string) (sections []parsedSection, usage *models.TokenUsage, conflicts int, fellBack bool, err error) {
+	windowSections := make([][]parsedSection, len(windows))
+	usages := make([]*models.TokenUsage, len(windows))
+	fallbacks := make([]bool, len(windows))
+	eg, gctx := errgroup.WithContext(ctx)
+	eg.SetLimit(f.config.Windows.Concurrency)
+	for i, window := range windows {
+		eg.Go(func() error {
+			windowLog := logCtx.With("window", i+1, "windowCount", len(windows))
+			prompt := gcp.SectionSplitterUserPrompt + "\n\n" + fmt.Sprintf(gcp.SectionSplitterWindowPromptFormat, i+1, len(windows))
+			genCtx, genSpan := startSpan(gcp.WithAuditScope(gctx, docID, fmt.Sprintf("window-%04d", i)), "gemini.GenerateContent")
+			resp, err := f.model.GenerateContent(genCtx, genai.Text(window), genai.Text(prompt))
+			endSpan(genSpan, err)
+			if filtered := asFilteredResponseError(resp, err); filtered != nil {
+				windowLog.Warn("Gemini blocked section splitting", "error", filtered)
+				return modelError(fmt.Errorf("failed to generate sections of window %d of %d: %w", i+1, len(windows), filtered))
+			}
+			if err != nil {
+				windowLog.Error("Call to Vertex AI for section splitting failed", "error", err)
+				return modelError(fmt.Errorf("failed to generate sections of window %d of %d from gemini: %w", i+1, len(windows), err))
+			}
+			usages[i] = tokenUsageFrom(resp)
+			parsed, err := f.decodeSections(resp, docID)
+			if err != nil {
+				windowLog.Warn("Model output is not valid section JSON; splitting the window by its headers.", "error", err)
+				parsed, fallbacks[i] = windowSectionsByHeaders(window, i > 0), true
+			}
+			windowSections[i] = parsed
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, nil, 0, false, err
+	}
+	for i := range windows {
+		usage = addTokenUsage(usage, usages[i])
+		fellBack = fellBack || fallbacks[i]
+	}
+	sections, conflicts = mergeWindowSections(windowSections)
+	return sections, usage, conflicts, fellBack, nil
+}
+
+// windowSectionsByHeaders splits a window by its headers. The text before the first
+// header of a window after the first continues the previous window, so it is left
+// untitled, as the model is asked to leave it.
+func windowSectionsByHeaders(window string, continued bool) []parsedSection {
+	sections := splitSectionsByHeaders(window)
+	if !continued || len(sections) == 0 {
+		return sections
+	}
+	lines := strings.Split(window, "\n")
+	headers, _ := markdownHeaders(lines)
+	for i, line := range lines {
+		if strings.TrimSpace(stripPageAnchors(line)) == "" {
+			continue
+		}
+		if _, ok := headers[i]; !ok {
+			sections[0].Section = ""
+		}
+		break
+	}
+	return sections
+}
+
+// mergeWindowSections merges the sections of consecutive windows into the document's
+// sections, in order. A window's sections are matched against the sections of the window
+// before it by normalized title, in order, for as long as they match from its start;
+// they are the window's overlap, and each is stitched into the section it repeats. An
+// untitled section continues the section before it, or at the start of a window the
+// section of the overlap whose text it repeats, and is stitched onto it. Text before the
+// document's first header is the "preamble". It returns the merged sections and the number of sections
+// repeated in the overlap whose two versions could not be stitched together, of which
+// the longer is kept.
+func mergeWindowSections(windows [][]parsedSection) ([]parsedSection, int) {
+	var merged []parsedSection
+	conflicts := 0
+	previous := 0 // The first merged section the previous window added or changed.
+	for _, sections := range windows {
+		windowStart := len(merged)
+		first := windowStart // The first merged section this window added or changed.
+		cursor, matching := previous, true
+		last := len(merged) - 1
+		for i, section := range sections {
+			key := normalizedSectionTitle(section.Section)
+			if key == "" && last < 0 {
+				section.Section = "preamble"
+			}
+			switch {
+			case key == "" && last >= 0:
+				target := last
+				if _, ok := stitchSectionContent(merged[last].Content, section.Content); !ok && i == 0 {
+					// The continuation may repeat a section of the overlap other than the last.
+					for j := last - 1; j >= previous; j-- {
+						if repeatsSectionContent(merged[j].Content, section.Content) {
+							target = j
+							break
+						}
+					}
+				}
+				content, ok := stitchSectionContent(merged[target].Content, section.Content)
+				if !ok {
+					content = strings.TrimSpace(merged[target].Content) + "\n\n" + strings.TrimSpace(section.Content)
+				}
+				merged[target].Content = content
+				first = min(first, target)
+				last = target
+				continue
+			case matching && key != "":
+				if j := indexSectionTitle(merged[cursor:windowStart], key); j >= 0 {
+					target := cursor + j
+					content, ok := stitchSectionContent(merged[target].Content, section.Content)
+					if !ok {
+						conflicts++
+						if contentChars(section.Content) > contentChars(merged[target].Content) {
+							content = section.Content
+						} else {
+							content = merged[target].Content
+						}
+					}
+					merged[target].Content = content
+					first = min(first, target)
+					cursor, last = target+1, target
+					continue
+				}
+			}
+			matching = false
+			merged = append(merged, section)
+			last = len(merged) - 1
+		}
+		previous = first
+	}
+	return merged, conflicts
+}
+
+// indexSectionTitle returns the index of the first of sections whose normalized title is
+// key, or -1.
+func indexSectionTitle(sections []parsedSection, key string) int {
+	for i, section := range sections {
+		if normalizedSectionTitle(section.Section) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// normalizedSectionTitle reduces a title to its lowercased letters and digits, separated
+// by single spaces, so that a title the model formats differently in two windows matches.
+func normalizedSectionTitle(title string) string {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// stitchSectionContent joins two versions of a section's content from consecutive
+// windows: when one contains the other, the longer is kept, and when the end of first is
+// the start of second, from the beginning of a line, they are joined on that overlap. ok
+// is false when neither is the case, and first is returned.
+func stitchSectionContent(first, second string) (string, bool) {
+	first, second = strings.TrimSpace(first), strings.TrimSpace(second)
+	if strings.Contains(second, first) {
+		return second, true
+	}
+	if strings.Contains(first, second) {
+		return first, true
+	}
+	if i := overlapStart(first, second); i >= 0 {
+		return first[:i] + second, true
+	}
+	return first, false
+}
+
+// repeatsSectionContent reports whether second repeats text at the end of first: all of
+// second is in first, or second begins with the end of first.
+func repeatsSectionContent(first, second string) bool {
+	first, second = strings.TrimSpace(first), strings.TrimSpace(second)
+	return strings.Contains(first, second) || overlapStart(first, second) >= 0
+}
+
+// overlapStart returns where, at the beginning of a line, the longest end of first that
+// second begins with starts, or -1 when second begins with no end of first.
+func overlapStart(first, second string) int {
+	firstLine, _, _ := strings.Cut(second, "\n")
+	for i := 0; i < len(first); i++ {
+		j := strings.Index(first[i:], firstLine)
+		if j < 0 {
+			break
+		}
+		// The earliest match is the longest overlap.
+		i += j
+		if (i == 0 || first[i-1] == '\n') && strings.HasPrefix(second, first[i:]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// decodeSections reads the model's sections from resp.
+func (f *SectionSplitterFunction) decodeSections(resp *genai.GenerateContentResponse, docID string) ([]parsedSection, error) {
+	jsonString := f.extractJSONContent(resp)
+	if jsonString == "" {
+		return nil, fmt.Errorf("gemini returned an empty response instead of JSON for document ID %s", docID)
+	}
+	var sections []parsedSection
+	if err := json.Unmarshal([]byte(jsonString), &sections); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON from model for document ID %s (%d bytes): %w", docID, len(jsonString), err)
+	}
+	return sections, nil
+}
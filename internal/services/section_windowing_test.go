@@ -0,0 +1,193 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSectionWindows(t *testing.T) {
+	markdown := "# 1 Scope\nCovers pumps.\n\n# 2 Loads\nDead loads.\n\n# 3 Materials\nSteel plates.\n\n# 4 Welding\nFull penetration.\n"
+	windows := sectionWindows(markdown, 60, 30)
+	if len(windows) < 2 {
+		t.Fatalf("sectionWindows() = %q, want several windows", windows)
+	}
+	for i, window := range windows {
+		if !strings.HasPrefix(window, "# ") {
+			t.Errorf("window %d = %q, want it to begin at a header", i+1, window)
+		}
+	}
+	// Dropping each window's overlap with the previous window gives back the markdown.
+	covered, overlapped := windows[0], false
+	for _, window := range windows[1:] {
+		if !strings.HasPrefix(markdown, covered) {
+			t.Fatalf("windows cover %q, want a start of the markdown", covered)
+		}
+		rest := markdown[len(covered):]
+		i := strings.Index(window, rest[:min(len(rest), 8)])
+		if i < 0 || !strings.HasSuffix(covered, window[:i]) {
+			t.Fatalf("window %q does not continue %q", window, covered)
+		}
+		overlapped = overlapped || i > 0
+		covered += window[i:]
+	}
+	if covered != markdown {
+		t.Errorf("windows cover %q, want %q", covered, markdown)
+	}
+	if !overlapped {
+		t.Errorf("sectionWindows() = %q, want windows that repeat the end of the previous one", windows)
+	}
+}
+
+func TestSectionWindowsSplitsLongSections(t *testing.T) {
+	paragraph := strings.Repeat("word ", 10) + "\n\n"
+	markdown := "# 1 Scope\n" + strings.Repeat(paragraph, 6)
+	windows := sectionWindows(markdown, 2*len(paragraph), 0)
+	if len(windows) < 3 {
+		t.Fatalf("sectionWindows() = %q, want the section split between paragraphs", windows)
+	}
+	if got := strings.Join(windows, ""); got != markdown {
+		t.Errorf("windows without overlap join to %q, want %q", got, markdown)
+	}
+}
+
+func TestWindowSectionsByHeaders(t *testing.T) {
+	tests := []struct {
+		name      string
+		window    string
+		continued bool
+		want      []parsedSection
+	}{
+		{
+			name:   "first window keeps its preamble",
+			window: "Issued 2024.\n\n# 1 Scope\nCovers pumps.\n",
+			want: []parsedSection{
+				{Section: "preamble", Content: "Issued 2024.", Level: 1},
+				{Section: "1 Scope", Content: "Covers pumps.", Level: 1},
+			},
+		},
+		{
+			name:      "text before the first header continues the previous window",
+			window:    "the rest of the scope.\n\n# 2 Loads\nDead loads.\n",
+			continued: true,
+			want: []parsedSection{
+				{Section: "", Content: "the rest of the scope.", Level: 1},
+				{Section: "2 Loads", Content: "Dead loads.", Level: 1},
+			},
+		},
+		{
+			name:      "window without headers continues the previous window",
+			window:    "More of the scope.\n",
+			continued: true,
+			want:      []parsedSection{{Section: "", Content: "More of the scope.", Level: 1}},
+		},
+		{
+			name:      "window beginning at a header after a page anchor",
+			window:    "<!-- page: 4 -->\n\n# 2 Loads\nDead loads.\n",
+			continued: true,
+			want:      []parsedSection{{Section: "2 Loads", Content: "<!-- page: 4 -->\n\nDead loads.", Level: 1}},
+		},
+		{
+			name:      "window beginning at a header",
+			window:    "# 2 Loads\nDead loads.\n",
+			continued: true,
+			want:      []parsedSection{{Section: "2 Loads", Content: "Dead loads.", Level: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := windowSectionsByHeaders(tt.window, tt.continued); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("windowSectionsByHeaders() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeWindowSections(t *testing.T) {
+	tests := []struct {
+		name          string
+		windows       [][]parsedSection
+		want          []parsedSection
+		wantConflicts int
+	}{
+		{
+			name: "sections repeated in the overlap are merged",
+			windows: [][]parsedSection{
+				{{Section: "1 Scope", Content: "Covers pumps."}, {Section: "2 Loads", Content: "Dead loads."}},
+				{{Section: "2. LOADS", Content: "Dead loads.\n\nLive loads."}, {Section: "3 Materials", Content: "Steel."}},
+			},
+			want: []parsedSection{
+				{Section: "1 Scope", Content: "Covers pumps."},
+				{Section: "2 Loads", Content: "Dead loads.\n\nLive loads."},
+				{Section: "3 Materials", Content: "Steel."},
+			},
+		},
+		{
+			name: "duplicate title later in the document is kept",
+			windows: [][]parsedSection{
+				{{Section: "1 Scope", Content: "Covers pumps."}, {Section: "Notes", Content: "First notes."}},
+				{{Section: "Notes", Content: "First notes."}, {Section: "2 Loads", Content: "Dead loads."}},
+				{{Section: "2 Loads", Content: "Dead loads."}, {Section: "Notes", Content: "Second notes."}},
+			},
+			want: []parsedSection{
+				{Section: "1 Scope", Content: "Covers pumps."},
+				{Section: "Notes", Content: "First notes."},
+				{Section: "2 Loads", Content: "Dead loads."},
+				{Section: "Notes", Content: "Second notes."},
+			},
+		},
+		{
+			name: "section spanning three windows",
+			windows: [][]parsedSection{
+				{{Section: "1 Scope", Content: "Covers pumps."}, {Section: "2 Loads", Content: "Part one."}},
+				{{Section: "", Content: "Part one.\nPart two."}},
+				{{Section: "", Content: "Part two.\nPart three."}, {Section: "3 Materials", Content: "Steel."}},
+			},
+			want: []parsedSection{
+				{Section: "1 Scope", Content: "Covers pumps."},
+				{Section: "2 Loads", Content: "Part one.\nPart two.\nPart three."},
+				{Section: "3 Materials", Content: "Steel."},
+			},
+		},
+		{
+			name: "trailing content without a header",
+			windows: [][]parsedSection{
+				{{Section: "1 Scope", Content: "Covers pumps."}},
+				{{Section: "", Content: "Signed by the engineer."}},
+			},
+			want: []parsedSection{
+				{Section: "1 Scope", Content: "Covers pumps.\n\nSigned by the engineer."},
+			},
+		},
+		{
+			name: "untitled text before the first header is the preamble",
+			windows: [][]parsedSection{
+				{{Section: "", Content: "Issued 2024."}, {Section: "1 Scope", Content: "Covers pumps."}},
+			},
+			want: []parsedSection{
+				{Section: "preamble", Content: "Issued 2024."},
+				{Section: "1 Scope", Content: "Covers pumps."},
+			},
+		},
+		{
+			name: "conflicting versions keep the longer",
+			windows: [][]parsedSection{
+				{{Section: "1 Scope", Content: "Covers pumps."}},
+				{{Section: "1 Scope", Content: "Covers pumps and valves."}},
+			},
+			want:          []parsedSection{{Section: "1 Scope", Content: "Covers pumps and valves."}},
+			wantConflicts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflicts := mergeWindowSections(tt.windows)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeWindowSections() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+			if conflicts != tt.wantConflicts {
+				t.Errorf("mergeWindowSections() conflicts = %d, want %d", conflicts, tt.wantConflicts)
+			}
+		})
+	}
+}
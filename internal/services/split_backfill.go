@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// chunkUploadFailed reports whether any page of chunk is among the document's pages that
+// failed to upload when it was split.
+func chunkUploadFailed(doc *models.Document, chunk pageChunk) bool {
+	return slices.ContainsFunc(doc.FailedPages, func(pageNumber int) bool {
+		return pageNumber >= chunk.StartPage && pageNumber <= chunk.EndPage
+	})
+}
+
+// backfillSplitFile restores the split file of a chunk that failed to upload when the
+// document was split, extracting its pages again from the upload the document records,
+// and moves the pages from the document's failedPages to its uploadedPages. A document
+// split from a bundle finds its pages at their offset within the bundle.
+func (f *RetranslatorFunction) backfillSplitFile(ctx context.Context, logCtx *slog.Logger, docRef *firestore.DocumentRef, doc *models.Document, chunk pageChunk) error {
+	if doc.SourceBucket == "" || doc.SourceObject == "" {
+		return fmt.Errorf("document %s does not record the upload it was split from", docRef.ID)
+	}
+	dir, err := os.MkdirTemp("", "backfill-")
+	if err != nil {
+		return fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source.pdf")
+	if err := f.downloadSource(ctx, doc, source); err != nil {
+		return err
+	}
+	offset := max(doc.BundleStartPage-1, 0)
+	path := filepath.Join(dir, "pages.pdf")
+	pages := fmt.Sprintf("%d-%d", chunk.StartPage+offset, chunk.EndPage+offset)
+	if err := api.TrimFile(source, path, []string{pages}, nil); err != nil {
+		return fmt.Errorf("failed to extract %s from the upload: %w", describePages(chunk.StartPage, chunk.EndPage), err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read extracted pages: %w", err)
+	}
+
+	objectName := pageRangeObjectName(documentRoot(doc.TenantID, docRef.ID), chunk.StartPage, chunk.EndPage, "pdf")
+	bucket := objectstore.NewGCS(f.translator.storageClient).Bucket(f.config.Buckets.SplitPages)
+	result, err := gcp.SaveToGCS(ctx, logCtx, bucket, objectName, string(content), gcp.SaveOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to upload split file %s: %w", objectName, err)
+	}
+
+	pageNumbers := chunkPageNumbers([]pageChunk{chunk})
+	backfilled := make([]interface{}, len(pageNumbers))
+	for i, pageNumber := range pageNumbers {
+		backfilled[i] = pageNumber
+	}
+	if _, err := docRef.Update(ctx, []firestore.Update{
+		{Path: "failedPages", Value: firestore.ArrayRemove(backfilled...)},
+		{Path: "uploadedPages", Value: firestore.ArrayUnion(backfilled...)},
+	}); err != nil {
+		// The split file exists, which is all the translator needs.
+		logCtx.Warn("Backfilled split file but could not record it", "error", err, "gcsObject", objectName)
+	}
+	logCtx.Info("Backfilled split file from the upload.", "gcsObject", objectName, "alreadyExisted", result == gcp.SaveExisted)
+	return nil
+}
+
+// downloadSource copies the generation of the upload the document was split from to path.
+func (f *RetranslatorFunction) downloadSource(ctx context.Context, doc *models.Document, path string) error {
+	object := f.translator.storageClient.Bucket(doc.SourceBucket).Object(doc.SourceObject)
+	if doc.SourceGeneration != 0 {
+		object = object.Generation(doc.SourceGeneration)
+	}
+	reader, err := object.NewReader(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read upload gs://%s/%s: %w", doc.SourceBucket, doc.SourceObject, err)
+	}
+	defer reader.Close()
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file at %s: %w", path, err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, reader); err != nil {
+		return fmt.Errorf("failed to copy upload to local file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// The ProcessMessage methods run a stage for a request delivered by Pub/Sub in the Pub/Sub
+// orchestration mode, and report the outcome to the pipeline coordinator. data is the
+// data of the Pub/Sub CloudEvent. A nil result acknowledges the message and an error has
+// Pub/Sub redeliver it, as stageReporter.finish describes. Dry-run requests are dropped.
+
+// ProcessMessage translates the page or chunk of a PageTranslatorRequest.
+func (f *TranslatorFunction) ProcessMessage(ctx context.Context, data []byte) error {
+	if f.reporter == nil {
+		return errNotPubSubMode
+	}
+	var req models.PageTranslatorRequest
+	messageID, err := decodeStageMessage(data, &req)
+	if err != nil {
+		return nil
+	}
+	startPage, _ := req.Pages()
+	logCtx := slog.With("documentId", req.DocumentID, "executionId", req.ExecutionID, "pageNumber", startPage, "messageId", messageID)
+	if req.DryRun {
+		logCtx.Warn("Dropping a dry-run request; dry runs are made over HTTP, as they report to no coordinator.")
+		return nil
+	}
+	res, err := f.Process(ctx, &req)
+	event := models.StageCompletedEvent{DocumentID: req.DocumentID, RunID: req.ExecutionID, Stage: models.PipelineRunStageTranslator, Page: startPage}
+	if res != nil {
+		event.Status, event.OutputGCSUri = res.Status, res.OutputGCSUri
+	}
+	return f.reporter.finish(ctx, logCtx, event, err)
+}
+
+// ProcessMessage aggregates the pages named by a MarkdownAggregatorRequest.
+func (f *AggregatorFunction) ProcessMessage(ctx context.Context, data []byte) error {
+	if f.reporter == nil {
+		return errNotPubSubMode
+	}
+	var req models.MarkdownAggregatorRequest
+	messageID, err := decodeStageMessage(data, &req)
+	if err != nil {
+		return nil
+	}
+	logCtx := slog.With("documentId", req.DocumentID, "executionId", req.ExecutionID, "messageId", messageID)
+	res, err := f.Process(ctx, &req)
+	event := models.StageCompletedEvent{DocumentID: req.DocumentID, RunID: req.ExecutionID, Stage: models.PipelineRunStageAggregator}
+	if res != nil {
+		event.Status, event.OutputGCSUri = res.Status, res.MasterGCSUri
+	}
+	return f.reporter.finish(ctx, logCtx, event, err)
+}
+
+// ProcessMessage cleans the master file named by a MarkdownCleanerRequest.
+func (f *CleanerFunction) ProcessMessage(ctx context.Context, data []byte) error {
+	if f.reporter == nil {
+		return errNotPubSubMode
+	}
+	var req models.MarkdownCleanerRequest
+	messageID, err := decodeStageMessage(data, &req)
+	if err != nil {
+		return nil
+	}
+	logCtx := slog.With("documentId", req.DocumentID, "executionId", req.ExecutionID, "messageId", messageID)
+	if req.DryRun {
+		logCtx.Warn("Dropping a dry-run request; dry runs are made over HTTP, as they report to no coordinator.")
+		return nil
+	}
+	res, err := f.Process(ctx, &req)
+	event := models.StageCompletedEvent{DocumentID: req.DocumentID, RunID: req.ExecutionID, Stage: models.PipelineRunStageCleaner}
+	if res != nil {
+		event.Status, event.OutputGCSUri = res.Status, res.CleanedGCSUri
+	}
+	return f.reporter.finish(ctx, logCtx, event, err)
+}
+
+// ProcessMessage splits the cleaned markdown named by a SectionSplitterRequest.
+func (f *SectionSplitterFunction) ProcessMessage(ctx context.Context, data []byte) error {
+	if f.reporter == nil {
+		return errNotPubSubMode
+	}
+	var req models.SectionSplitterRequest
+	messageID, err := decodeStageMessage(data, &req)
+	if err != nil {
+		return nil
+	}
+	logCtx := slog.With("documentId", req.DocumentID, "executionId", req.ExecutionID, "messageId", messageID)
+	res, err := f.Process(ctx, &req)
+	event := models.StageCompletedEvent{DocumentID: req.DocumentID, RunID: req.ExecutionID, Stage: models.PipelineRunStageSectionSplitter}
+	if res != nil {
+		event.Status = res.Status
+	}
+	return f.reporter.finish(ctx, logCtx, event, err)
+}
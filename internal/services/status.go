@@ -0,0 +1,340 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+var (
+	// ErrDocumentNotFound is returned when a document ID has no Firestore record.
+	ErrDocumentNotFound = errors.New("document not found")
+	// ErrInvalidStatusRequest is returned when a status request names no document.
+	ErrInvalidStatusRequest = errors.New("invalid status request")
+)
+
+// StatusConfig holds configuration for the document-status service.
+type StatusConfig struct {
+	ProjectID      string
+	CollectionName string
+	Buckets        ArtifactBuckets
+	OutputNames    *OutputNames
+	SignedURLTTL   time.Duration
+	OutputSigning  OutputSigningConfig
+	// VertexAIRegion, Models and Estimate are as the translator's, so that document
+	// estimates agree with its dry runs.
+	VertexAIRegion string
+	Models         gcp.VertexModelConfig
+	Estimate       CostEstimateConfig
+}
+
+// StatusFunction holds dependencies for the read-only document status endpoints.
+type StatusFunction struct {
+	serviceClients
+	storageClient   *storage.Client
+	firestoreClient *firestore.Client
+	signer          *outputSigner // Nil unless SIGN_OUTPUT_URLS is enabled.
+	config          StatusConfig
+
+	// vertexClient is created by the first document estimate, or vertexErr set.
+	vertexOnce   sync.Once
+	vertexClient *gcp.VertexClient
+	vertexErr    error
+}
+
+// NewStatus creates a new StatusFunction instance.
+func NewStatus(ctx context.Context) (*StatusFunction, error) {
+	projectID := gcp.GetEnv("PROJECT_ID", "")
+	if projectID == "" {
+		return nil, fmt.Errorf("GCP_PROJECT environment variable must be set")
+	}
+	signedURLTTL, err := time.ParseDuration(gcp.GetEnv("SIGNED_URL_TTL", "15m"))
+	if err != nil {
+		return nil, fmt.Errorf("SIGNED_URL_TTL must be a valid duration: %w", err)
+	}
+	outputSigning, err := loadOutputSigningConfig()
+	if err != nil {
+		return nil, err
+	}
+	estimate, err := loadCostEstimateConfig("TRANSLATOR_OUTPUT_TOKEN_RATIO", "0.5,2")
+	if err != nil {
+		return nil, err
+	}
+	outputNames, err := loadOutputNames()
+	if err != nil {
+		return nil, err
+	}
+
+	config := StatusConfig{
+		ProjectID:      projectID,
+		CollectionName: gcp.GetEnv("FIRESTORE_COLLECTION", "documents"),
+		Buckets:        LoadArtifactBuckets(),
+		OutputNames:    outputNames,
+		SignedURLTTL:   signedURLTTL,
+		OutputSigning:  outputSigning,
+		VertexAIRegion: gcp.GetEnv("VERTEX_AI_REGION", "us-central1"),
+		Models:         gcp.LoadVertexModelConfig(),
+		Estimate:       estimate,
+	}
+
+	firestoreClient, err := gcp.NewFirestoreClient(ctx, config.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firestore client: %w", err)
+	}
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %w", err)
+	}
+	signer, err := newOutputSigner(ctx, config.OutputSigning)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &StatusFunction{
+		storageClient:   storageClient,
+		firestoreClient: firestoreClient,
+		signer:          signer,
+		config:          config,
+	}
+	f.serviceClients = serviceClients{storageClient, firestoreClient, closerFunc(func() error {
+		f.vertexOnce.Do(func() {}) // No estimate creates the client once closed.
+		if f.vertexClient == nil {
+			return nil
+		}
+		return f.vertexClient.Close()
+	})}
+	return f, nil
+}
+
+// getDocument reads a document record, mapping a missing record, or with tenantID set one
+// of another tenant, to ErrDocumentNotFound.
+func (f *StatusFunction) getDocument(ctx context.Context, docID, tenantID string) (*models.Document, error) {
+	snap, err := f.firestoreClient.Collection(f.config.CollectionName).Doc(docID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, docID)
+		}
+		slog.Error("Failed to read document", "error", err, "documentId", docID)
+		return nil, fmt.Errorf("failed to read document %s: %w", docID, err)
+	}
+	var doc models.Document
+	if err := snap.DataTo(&doc); err != nil {
+		slog.Error("Failed to decode document", "error", err, "documentId", docID)
+		return nil, fmt.Errorf("failed to decode document %s: %w", docID, err)
+	}
+	if tenantID != "" && doc.TenantID != tenantID {
+		return nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, docID)
+	}
+	return &doc, nil
+}
+
+// GetStatus summarizes a document's pipeline progress from its Firestore record and, when
+// present, its page records.
+func (f *StatusFunction) GetStatus(ctx context.Context, req *models.DocumentStatusRequest) (*models.DocumentStatusResponse, error) {
+	logCtx := slog.With("documentId", req.DocumentID, "fileHash", req.FileHash)
+
+	snap, err := f.findDocument(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	var doc models.Document
+	if err := snap.DataTo(&doc); err != nil {
+		logCtx.Error("Failed to decode document", "error", err)
+		return nil, fmt.Errorf("failed to decode document %s: %w", snap.Ref.ID, err)
+	}
+	docID := snap.Ref.ID
+	logCtx = logCtx.With("documentId", docID)
+
+	pages, err := f.listPages(ctx, docID)
+	if err != nil {
+		logCtx.Error("Failed to read page records", "error", err)
+		return nil, err
+	}
+
+	res := &models.DocumentStatusResponse{
+		DocumentID:          docID,
+		TenantID:            doc.TenantID,
+		FileHash:            doc.FileHash,
+		OriginalFilename:    doc.OriginalFilename,
+		OriginalObjectPath:  doc.OriginalObjectPath,
+		FilenameSlug:        doc.FilenameSlug,
+		Status:              doc.Status,
+		ErrorDetails:        doc.ErrorDetails,
+		PageCount:           doc.PageCount,
+		ChunkSize:           doc.ChunkSize,
+		WorkflowExecutionID: doc.WorkflowExecutionID,
+		CreatedAt:           doc.CreatedAt,
+		UpdatedAt:           snap.UpdateTime,
+		PageStatusCounts:    make(map[string]int),
+		Outputs:             f.documentOutputs(ctx, logCtx, docID, &doc),
+		StatusHistory:       doc.StatusHistory,
+	}
+	for _, page := range pages {
+		res.PageStatusCounts[page.Status]++
+		switch page.Status {
+		case models.PageStatusTranslated, models.PageStatusSkipped, models.PageStatusFailedSoft:
+			res.PagesCompleted++
+		}
+		if page.UpdatedAt.After(res.UpdatedAt) {
+			res.UpdatedAt = page.UpdatedAt
+		}
+	}
+	if req.IncludePages {
+		if f.config.Buckets.SplitPages != "" {
+			// Records created before page URIs were stored take theirs from the manifest.
+			manifest, _ := documentSplitFiles(f.config.Buckets.SplitPages, docID, &doc)
+			for i := range pages {
+				if pages[i].GCSUri == "" {
+					pages[i].GCSUri = manifest.GCSUriFor(pages[i].PageNumber)
+				}
+			}
+		}
+		res.Pages = pages
+	}
+	if len(doc.Timings) > 0 {
+		res.Timings = make(map[string]models.StageTiming, len(doc.Timings))
+		for stage, timing := range doc.Timings {
+			if timing.Count > 0 {
+				timing.AvgMs = timing.TotalMs / timing.Count
+			}
+			res.Timings[stage] = timing
+		}
+	}
+	logCtx.Info("Read document status.", "status", doc.Status, "pageRecords", len(pages))
+	return res, nil
+}
+
+// findDocument reads the document named by the request, by ID or by file hash. With a
+// tenant ID set, a document of another tenant is not found, rather than refused, so that
+// a tenant cannot tell which documents others have.
+func (f *StatusFunction) findDocument(ctx context.Context, req *models.DocumentStatusRequest) (*firestore.DocumentSnapshot, error) {
+	collection := f.firestoreClient.Collection(f.config.CollectionName)
+	switch {
+	case req.DocumentID != "":
+		snap, err := collection.Doc(req.DocumentID).Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, req.DocumentID)
+		}
+		if err != nil {
+			slog.Error("Failed to read document", "error", err, "documentId", req.DocumentID)
+			return nil, fmt.Errorf("failed to read document %s: %w", req.DocumentID, err)
+		}
+		if tenantID, _ := snap.DataAt("tenantId"); req.TenantID != "" && tenantID != req.TenantID {
+			return nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, req.DocumentID)
+		}
+		return snap, nil
+	case req.FileHash != "":
+		query := collection.Where("fileHash", "==", req.FileHash)
+		if req.TenantID != "" {
+			query = query.Where("tenantId", "==", req.TenantID)
+		}
+		snaps, err := query.Limit(1).Documents(ctx).GetAll()
+		if err != nil {
+			slog.Error("Failed to query documents by fileHash", "error", err, "fileHash", req.FileHash)
+			return nil, fmt.Errorf("failed to query documents by fileHash: %w", err)
+		}
+		if len(snaps) == 0 {
+			return nil, fmt.Errorf("%w: no document with fileHash %s", ErrDocumentNotFound, req.FileHash)
+		}
+		return snaps[0], nil
+	default:
+		return nil, fmt.Errorf("%w: documentId or fileHash is required", ErrInvalidStatusRequest)
+	}
+}
+
+// listPages reads every page record of a document in page order.
+func (f *StatusFunction) listPages(ctx context.Context, docID string) ([]models.Page, error) {
+	it := pagesCollection(f.firestoreClient, f.config.CollectionName, docID).OrderBy("pageNumber", firestore.Asc).Documents(ctx)
+	defer it.Stop()
+
+	var pages []models.Page
+	for {
+		snap, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list page records of %s: %w", docID, err)
+		}
+		var page models.Page
+		if err := snap.DataTo(&page); err != nil {
+			return nil, fmt.Errorf("failed to decode page record %s: %w", snap.Ref.ID, err)
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
+
+// documentOutputs returns the output URIs of the aggregation, cleaning and section
+// splitting stages of the document, omitting any whose bucket is not configured, and
+// signed URLs to them when signing is enabled.
+func (f *StatusFunction) documentOutputs(ctx context.Context, logCtx *slog.Logger, docID string, record *models.Document) models.DocumentOutputs {
+	var outputs models.DocumentOutputs
+	locations, err := f.documentLocations(docID, record)
+	if err != nil {
+		logCtx.Warn("Failed to locate document outputs", "error", err)
+		return outputs
+	}
+	for _, loc := range locations {
+		uri := fmt.Sprintf("gs://%s/%s", loc.Bucket, loc.Prefix)
+		switch loc.Class {
+		case ArtifactClassMaster:
+			outputs.MasterGCSUri = uri
+			outputs.MasterSignedURL = f.signer.sign(ctx, logCtx, loc.Bucket, loc.Prefix)
+		case ArtifactClassCleaned:
+			outputs.CleanedGCSUri = uri
+			outputs.CleanedSignedURL = f.signer.sign(ctx, logCtx, loc.Bucket, loc.Prefix)
+		case ArtifactClassSection:
+			outputs.SectionsPrefix = uri
+			if f.signer != nil {
+				outputs.SectionFiles = f.signedSectionFiles(ctx, logCtx, loc)
+			}
+		}
+	}
+	return outputs
+}
+
+// documentLocations returns the locations of the document's derived artifacts.
+func (f *StatusFunction) documentLocations(docID string, record *models.Document) ([]ArtifactLocation, error) {
+	doc, err := f.config.OutputNames.DocumentFor(docID, record)
+	if err != nil {
+		return nil, err
+	}
+	return f.config.Buckets.Locations(f.config.OutputNames, doc)
+}
+
+// signedSectionFiles links to every section of a document. A listing failure is logged
+// and leaves the response with the prefix only.
+func (f *StatusFunction) signedSectionFiles(ctx context.Context, logCtx *slog.Logger, loc ArtifactLocation) []models.OutputLink {
+	var links []models.OutputLink
+	it := f.storageClient.Bucket(loc.Bucket).Objects(ctx, &storage.Query{Prefix: loc.Prefix})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return links
+		}
+		if err != nil {
+			logCtx.Warn("Failed to list section files; returning the sections prefix only.", "error", err, "bucket", loc.Bucket, "prefix", loc.Prefix)
+			return nil
+		}
+		if !loc.Contains(attrs.Name) {
+			continue
+		}
+		links = append(links, models.OutputLink{
+			GCSUri:    fmt.Sprintf("gs://%s/%s", attrs.Bucket, attrs.Name),
+			SignedURL: f.signer.sign(ctx, logCtx, attrs.Bucket, attrs.Name),
+		})
+	}
+}
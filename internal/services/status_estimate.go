@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"golang.org/x/sync/errgroup"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// estimateConcurrency caps the token counts made at once for a document estimate.
+const estimateConcurrency = 8
+
+// EstimateDocument estimates what translating every page of a document would cost, as the
+// sum of what a translator dry run of each of its split files would return. Pages are
+// counted with the document's prompt profile, language hints and processing region;
+// custom prompts a workflow may pass are not known here. The split pages must still
+// exist, so a document whose intermediates were cleaned up cannot be estimated.
+func (f *StatusFunction) EstimateDocument(ctx context.Context, req *models.DocumentEstimateRequest) (*models.DocumentEstimateResponse, error) {
+	logCtx := slog.With("documentId", req.DocumentID)
+	if req.DocumentID == "" {
+		return nil, fmt.Errorf("%w: documentId must be set", ErrInvalidStatusRequest)
+	}
+	doc, err := f.getDocument(ctx, req.DocumentID, req.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if doc.PageCount == 0 {
+		return nil, fmt.Errorf("%w: document %s has not been split into pages", ErrInvalidStatusRequest, req.DocumentID)
+	}
+	if f.config.Buckets.SplitPages == "" {
+		err := errors.New("SPLIT_PAGES_BUCKET must be set to estimate documents")
+		logCtx.Error("Cannot estimate document", "error", err)
+		return nil, err
+	}
+
+	region := doc.ProcessingRegion
+	if region == "" {
+		region = f.config.VertexAIRegion
+	}
+	counters, err := f.translatorCounters(ctx, region)
+	if err != nil {
+		logCtx.Error("Cannot estimate document", "error", err, "processingRegion", region)
+		return nil, err
+	}
+	profile := gcp.PromptProfileDefault
+	if doc.ProcessingProfile != nil && counters[doc.ProcessingProfile.PromptProfile] != nil {
+		profile = doc.ProcessingProfile.PromptProfile
+	}
+	counter := counters[profile]
+	prompt := gcp.TranslatorPromptProfiles[profile].User
+	if languagePrompt := translatorLanguagePrompt(doc.SourceLanguage, doc.TargetLanguage); languagePrompt != "" {
+		prompt += "\n\n" + languagePrompt
+	}
+
+	res := &models.DocumentEstimateResponse{
+		DocumentID: req.DocumentID,
+		PageCount:  doc.PageCount,
+		Total:      f.config.Estimate.estimate(f.config.Models.TranslatorModelName, 0),
+	}
+	res.Pages, err = f.estimateSplitFiles(ctx, req.DocumentID, doc, counter, prompt)
+	if err != nil {
+		logCtx.Error("Failed to estimate document", "error", err)
+		return nil, err
+	}
+	for _, page := range res.Pages {
+		addCostEstimate(&res.Total, page.Estimate)
+	}
+	logCtx.Info("Estimated document translation cost.", "pageCount", doc.PageCount, "inputTokens", res.Total.InputTokens, "outputTokensLow", res.Total.OutputTokensLow, "outputTokensHigh", res.Total.OutputTokensHigh)
+	return res, nil
+}
+
+// estimateSplitFiles estimates translating each of the files doc was split into, its
+// chunks or, split by bookmark, its chapters, counting their tokens with counter.
+func (f *StatusFunction) estimateSplitFiles(ctx context.Context, docID string, doc *models.Document, counter gcp.TokenCounter, prompt string) ([]models.PageEstimate, error) {
+	manifest, _ := documentSplitFiles(f.config.Buckets.SplitPages, docID, doc)
+	units := documentUnits(doc)
+	estimates := make([]models.PageEstimate, len(units))
+	eg, gctx := errgroup.WithContext(ctx)
+	eg.SetLimit(estimateConcurrency)
+	for i, unit := range units {
+		uri := manifest.GCSUriFor(unit.StartPage)
+		eg.Go(func() error {
+			filePart := genai.FileData{
+				MIMEType: "application/pdf",
+				FileURI:  uri,
+			}
+			inputTokens, err := countTokens(gctx, counter, filePart, genai.Text(prompt))
+			if err != nil {
+				return fmt.Errorf("failed to estimate %s: %w", describePages(unit.StartPage, unit.EndPage), err)
+			}
+			estimates[i] = models.PageEstimate{
+				StartPage: unit.StartPage,
+				EndPage:   unit.EndPage,
+				GCSUri:    uri,
+				Estimate:  f.config.Estimate.estimate(f.config.Models.TranslatorModelName, inputTokens),
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return estimates, nil
+}
+
+// translatorCounters returns the translator token counters of region. The Vertex AI
+// client is only created for the first estimate, so that the other endpoints do not need
+// Vertex AI.
+func (f *StatusFunction) translatorCounters(ctx context.Context, region string) (map[string]gcp.TokenCounter, error) {
+	f.vertexOnce.Do(func() {
+		f.vertexClient, f.vertexErr = gcp.NewVertexClient(context.WithoutCancel(ctx), f.config.ProjectID, f.config.VertexAIRegion, f.config.Models)
+	})
+	if f.vertexErr != nil {
+		return nil, fmt.Errorf("failed to create vertex client: %w", f.vertexErr)
+	}
+	if f.vertexClient == nil {
+		return nil, errors.New("the status function is closed")
+	}
+	client, err := f.vertexClient.ForRegion(ctx, region)
+	if err != nil {
+		return nil, models.WithCode(models.ErrorCodeLLMUnavailable, &RegionUnavailableError{Region: region, Err: err})
+	}
+	return client.TranslatorCounters(), nil
+}
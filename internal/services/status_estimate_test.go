@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestEstimateSplitFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		doc      *models.Document
+		wantURIs []string
+	}{
+		{
+			name:     "chunks",
+			doc:      &models.Document{TenantID: "acme", PageCount: 5, ChunkSize: 2},
+			wantURIs: []string{"gs://split/acme/doc-1/00001-00002.pdf", "gs://split/acme/doc-1/00003-00004.pdf", "gs://split/acme/doc-1/00005.pdf"},
+		},
+		{
+			name: "chapters",
+			doc: &models.Document{
+				PageCount: 5,
+				SplitMode: models.SplitModeBookmark,
+				Chapters: []models.Chapter{
+					{Index: 1, Title: "Scope", StartPage: 1, EndPage: 4, GCSUri: "gs://split/doc-1/chapters/001_scope.pdf"},
+					{Index: 2, Title: "Annex", StartPage: 5, EndPage: 5, GCSUri: "gs://split/doc-1/chapters/002_annex.pdf"},
+				},
+			},
+			wantURIs: []string{"gs://split/doc-1/chapters/001_scope.pdf", "gs://split/doc-1/chapters/002_annex.pdf"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &StatusFunction{config: StatusConfig{
+				Buckets:  ArtifactBuckets{SplitPages: "split"},
+				Models:   gcp.VertexModelConfig{TranslatorModelName: "gemini"},
+				Estimate: CostEstimateConfig{OutputRatioLow: 1, OutputRatioHigh: 2},
+			}}
+			counter := &testsupport.FakeTokenCounter{Tokens: 100}
+			got, err := f.estimateSplitFiles(context.Background(), "doc-1", tt.doc, counter, "Translate.")
+			if err != nil {
+				t.Fatalf("estimateSplitFiles() error = %v", err)
+			}
+			if len(got) != len(tt.wantURIs) || len(counter.Calls()) != len(tt.wantURIs) {
+				t.Fatalf("estimateSplitFiles() = %+v after %d token counts, want %d estimates", got, len(counter.Calls()), len(tt.wantURIs))
+			}
+			units := documentUnits(tt.doc)
+			for i, estimate := range got {
+				if estimate.GCSUri != tt.wantURIs[i] {
+					t.Errorf("estimate %d GCSUri = %q, want %q", i, estimate.GCSUri, tt.wantURIs[i])
+				}
+				if estimate.StartPage != units[i].StartPage || estimate.EndPage != units[i].EndPage {
+					t.Errorf("estimate %d pages = %d-%d, want %d-%d", i, estimate.StartPage, estimate.EndPage, units[i].StartPage, units[i].EndPage)
+				}
+				if estimate.Estimate.InputTokens != 100 || estimate.Estimate.OutputTokensHigh != 200 {
+					t.Errorf("estimate %d = %+v, want 100 input and up to 200 output tokens", i, estimate.Estimate)
+				}
+			}
+			counted := make(map[string]bool)
+			for _, call := range counter.Calls() {
+				counted[call[0].(genai.FileData).FileURI] = true
+			}
+			for _, uri := range tt.wantURIs {
+				if !counted[uri] {
+					t.Errorf("tokens of %s were not counted", uri)
+				}
+			}
+		})
+	}
+}
+
+func TestEstimateSplitFilesFails(t *testing.T) {
+	f := &StatusFunction{config: StatusConfig{Buckets: ArtifactBuckets{SplitPages: "split"}}}
+	counter := &testsupport.FakeTokenCounter{Err: errors.New("quota exceeded")}
+	doc := &models.Document{PageCount: 2}
+	if _, err := f.estimateSplitFiles(context.Background(), "doc-1", doc, counter, "Translate."); err == nil {
+		t.Error("estimateSplitFiles() error = nil, want the token count's error")
+	}
+}
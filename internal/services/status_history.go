@@ -0,0 +1,50 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Document statuses recorded by the stages after translation. COMPLETE is StatusComplete.
+const (
+	StatusAggregating       = "AGGREGATING"
+	StatusCleaning          = "CLEANING"
+	StatusSplittingSections = "SPLITTING_SECTIONS"
+	StatusFailed            = "FAILED"
+)
+
+// recordStatus moves the document to status and appends the transition to its history.
+// The status is bookkeeping, so failing to record it is logged and never fails the stage.
+func recordStatus(ctx context.Context, logCtx *slog.Logger, client *firestore.Client, collection, docID, status, stage string) {
+	if docID == "" {
+		return
+	}
+	docRef := client.Collection(collection).Doc(docID)
+	if err := gcp.AppendStatusTransition(ctx, docRef, status, stage, ""); err != nil {
+		logCtx.Warn("Failed to record document status", "error", err, "status", status, "stage", stage)
+	}
+}
+
+// recordStageFailure marks the document FAILED with stageErr as its error details, or
+// CANCELLED when the stage stopped for the document's cancellation. It is deferred from
+// Process, where the request may already be cancelled; the write does not depend on the
+// request's context.
+func recordStageFailure(ctx context.Context, logCtx *slog.Logger, client *firestore.Client, collection, docID, stage string, stageErr error) {
+	if docID == "" {
+		return
+	}
+	if models.CodeOf(stageErr) == models.ErrorCodeCancelled {
+		recordCancelled(ctx, logCtx, client, collection, docID, stage)
+		return
+	}
+	docRef := client.Collection(collection).Doc(docID)
+	details := firestore.Update{Path: "errorDetails", Value: stageErr.Error()}
+	if err := gcp.AppendStatusTransition(ctx, docRef, StatusFailed, stage, stageErr.Error(), details); err != nil {
+		logCtx.Error("Failed to record document failure", "error", err, "stage", stage)
+	}
+}
@@ -0,0 +1,295 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/workflows/executions/apiv1/executionspb"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// StatusStalled is recorded by the stuck-document sweeper on a document that stopped
+// making progress and cannot be retriggered. Its errorDetails say why.
+const StatusStalled = "STALLED"
+
+// stuckSweepStatuses are the statuses of a document in progress, which the sweeper looks
+// for stuck documents in. Documents waiting on a retrigger, or on a manual re-run after
+// retranslation, are left to those.
+var stuckSweepStatuses = []string{"SPLITTING", "TRANSLATING", StatusAggregating, StatusCleaning, StatusSplittingSections}
+
+// Actions the sweeper takes on a document.
+const (
+	sweepActionSkip      = "skip"
+	sweepActionRetrigger = "retrigger"
+	sweepActionStall     = "stall"
+	sweepActionCancel    = "cancel"
+)
+
+// Execution states that are not Workflows states, for documents whose execution cannot be
+// looked up.
+const (
+	executionStateNone     = "NONE"      // No execution is recorded on the document.
+	executionStateNotFound = "NOT_FOUND" // The recorded execution no longer exists.
+	executionStatePubSub   = "PUBSUB"    // The document is processed by a Pub/Sub run.
+)
+
+// StuckSweeperConfig holds configuration for the stuck-document sweeper.
+type StuckSweeperConfig struct {
+	// Threshold is how long a document's status may go unchanged before it is checked.
+	Threshold time.Duration
+	// Limit caps the documents acted on by one sweep.
+	Limit int
+	// MaxRetriggers caps the times the sweeper retriggers a document before it stalls it
+	// instead.
+	MaxRetriggers int
+}
+
+// loadStuckSweeperConfig reads STUCK_THRESHOLD, STUCK_SWEEP_LIMIT and
+// STUCK_MAX_RETRIGGERS.
+func loadStuckSweeperConfig() (StuckSweeperConfig, error) {
+	threshold, err := time.ParseDuration(gcp.GetEnv("STUCK_THRESHOLD", "2h"))
+	if err != nil || threshold <= 0 {
+		return StuckSweeperConfig{}, fmt.Errorf("STUCK_THRESHOLD must be a positive duration")
+	}
+	limit, err := strconv.Atoi(gcp.GetEnv("STUCK_SWEEP_LIMIT", "20"))
+	if err != nil || limit < 1 {
+		return StuckSweeperConfig{}, fmt.Errorf("STUCK_SWEEP_LIMIT must be a positive integer")
+	}
+	maxRetriggers, err := strconv.Atoi(gcp.GetEnv("STUCK_MAX_RETRIGGERS", "1"))
+	if err != nil || maxRetriggers < 0 {
+		return StuckSweeperConfig{}, fmt.Errorf("STUCK_MAX_RETRIGGERS must be a non-negative integer")
+	}
+	return StuckSweeperConfig{Threshold: threshold, Limit: limit, MaxRetriggers: maxRetriggers}, nil
+}
+
+// StuckSweeperFunction finds documents left in progress by a workflow execution that
+// died without reporting it, and retriggers or stalls them. It is run on a schedule. It
+// starts workflows as the workflow retrigger does, and is configured like it.
+type StuckSweeperFunction struct {
+	retrigger *RetriggerFunction
+	config    StuckSweeperConfig
+}
+
+// NewStuckSweeper creates a new StuckSweeperFunction instance.
+func NewStuckSweeper(ctx context.Context) (*StuckSweeperFunction, error) {
+	config, err := loadStuckSweeperConfig()
+	if err != nil {
+		return nil, err
+	}
+	retrigger, err := NewRetrigger(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Stuck-document sweeper initialized.", "threshold", config.Threshold, "limit", config.Limit, "maxRetriggers", config.MaxRetriggers)
+	return &StuckSweeperFunction{retrigger: retrigger, config: config}, nil
+}
+
+// Close closes the sweeper's clients.
+func (f *StuckSweeperFunction) Close() error {
+	return f.retrigger.Close()
+}
+
+// sweepDecision is what the sweeper does with a document, and why.
+type sweepDecision struct {
+	Action string
+	Reason string
+}
+
+// decideStuckDocument decides what to do with doc, given the state of its workflow
+// execution, one of the Workflows states or the executionState* values. A document is
+// left alone unless it is in progress, its status has not changed for the threshold, and
+// its execution is not running. A stuck document whose cancellation was requested is
+// cancelled. One whose pages are ready is retriggered, at most MaxRetriggers times. Any
+// other, and any processed by a Pub/Sub run, which cannot be retriggered, is stalled.
+//
+// A retrigger starts the workflow from the beginning rather than at the stage the document
+// is stuck in: the workflow takes no stage to start from, and starting over costs little
+// more than the stuck stage, as the translator reuses every page already translated from
+// the same source generation and the later stages replace their outputs whole.
+func decideStuckDocument(doc *models.Document, executionState string, now time.Time, config StuckSweeperConfig) sweepDecision {
+	if !slices.Contains(stuckSweepStatuses, doc.Status) {
+		return sweepDecision{Action: sweepActionSkip, Reason: "status " + doc.Status + " is not in progress"}
+	}
+	since := lastStatusChange(doc)
+	idle := now.Sub(since)
+	if idle < config.Threshold {
+		return sweepDecision{Action: sweepActionSkip, Reason: "status changed recently"}
+	}
+	if executionState == executionspb.Execution_ACTIVE.String() || executionState == executionspb.Execution_QUEUED.String() {
+		return sweepDecision{Action: sweepActionSkip, Reason: "workflow execution is " + executionState}
+	}
+
+	stuck := fmt.Sprintf("%s since %s (%s)", doc.Status, since.UTC().Format(time.RFC3339), idle.Round(time.Minute))
+	switch executionState {
+	case executionStateNone:
+		stuck += " with no workflow execution recorded"
+	case executionStatePubSub:
+		stuck += " in Pub/Sub run " + doc.WorkflowExecutionID
+	default:
+		stuck += fmt.Sprintf(" with workflow execution %s %s", doc.WorkflowExecutionID, executionState)
+	}
+	retriggers := sweeperRetriggers(doc)
+	switch {
+	case doc.CancelRequested:
+		return sweepDecision{Action: sweepActionCancel, Reason: stuck + "; cancellation requested"}
+	case executionState == executionStatePubSub:
+		return sweepDecision{Action: sweepActionStall, Reason: stuck + "; Pub/Sub runs cannot be retriggered"}
+	case doc.Status == "SPLITTING" || doc.PageCount <= 0:
+		return sweepDecision{Action: sweepActionStall, Reason: stuck + "; its pages were never all split"}
+	case retriggers >= config.MaxRetriggers:
+		return sweepDecision{Action: sweepActionStall, Reason: fmt.Sprintf("%s; already retriggered %d time(s)", stuck, retriggers)}
+	default:
+		return sweepDecision{Action: sweepActionRetrigger, Reason: stuck}
+	}
+}
+
+// lastStatusChange returns when doc's status last changed: its newest status transition,
+// or its creation if it has none.
+func lastStatusChange(doc *models.Document) time.Time {
+	last := doc.CreatedAt
+	for _, transition := range doc.StatusHistory {
+		if transition.Timestamp.After(last) {
+			last = transition.Timestamp
+		}
+	}
+	return last
+}
+
+// sweeperRetriggers returns the times the sweeper has retriggered doc.
+func sweeperRetriggers(doc *models.Document) int {
+	count := 0
+	for _, transition := range doc.StatusHistory {
+		if transition.Stage == models.StatusStageSweeper && transition.Status == StatusPagesReadyWorkflowFailed {
+			count++
+		}
+	}
+	return count
+}
+
+// Process sweeps the documents in progress once, acting on at most Limit of them. A
+// document whose action fails is counted and left for the next sweep. It returns an
+// error only when the documents could not be listed.
+func (f *StuckSweeperFunction) Process(ctx context.Context) (*models.StuckSweepResponse, error) {
+	client := f.retrigger.firestoreClient
+	res := &models.StuckSweepResponse{Documents: []models.StuckSweepOutcome{}}
+	now := time.Now()
+	it := client.Collection(f.retrigger.config.CollectionName).Where("status", "in", stuckSweepStatuses).Documents(ctx)
+	defer it.Stop()
+	for len(res.Documents) < f.config.Limit {
+		snap, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			slog.Error("Failed to list documents in progress", "error", err)
+			return nil, fmt.Errorf("failed to list documents in progress: %w", err)
+		}
+		res.Scanned++
+		logCtx := slog.With("documentId", snap.Ref.ID)
+		var doc models.Document
+		if err := snap.DataTo(&doc); err != nil {
+			logCtx.Warn("Skipping a document that cannot be decoded", "error", err)
+			continue
+		}
+		// Only documents idle past the threshold are worth an Executions API call.
+		if now.Sub(lastStatusChange(&doc)) < f.config.Threshold {
+			continue
+		}
+		state, err := f.executionState(ctx, doc.WorkflowExecutionID)
+		if err != nil {
+			logCtx.Warn("Skipping a document whose workflow execution cannot be read", "error", err, "executionId", doc.WorkflowExecutionID)
+			continue
+		}
+		decision := decideStuckDocument(&doc, state, now, f.config)
+		if decision.Action == sweepActionSkip {
+			continue
+		}
+		outcome, acted := f.act(ctx, logCtx, snap, decision)
+		if !acted {
+			continue
+		}
+		switch {
+		case outcome.Error != "":
+			res.Failed++
+		case decision.Action == sweepActionRetrigger:
+			res.Retriggered++
+		case decision.Action == sweepActionStall:
+			res.Stalled++
+		case decision.Action == sweepActionCancel:
+			res.Cancelled++
+		}
+		res.Documents = append(res.Documents, outcome)
+	}
+	slog.Info("Stuck-document sweep complete.", "scanned", res.Scanned, "retriggered", res.Retriggered, "stalled", res.Stalled, "cancelled", res.Cancelled, "failed", res.Failed)
+	return res, nil
+}
+
+// executionState returns the state of the workflow execution recorded on a document, or
+// one of the executionState* values when there is none to look up.
+func (f *StuckSweeperFunction) executionState(ctx context.Context, executionID string) (string, error) {
+	switch {
+	case executionID == "":
+		return executionStateNone, nil
+	case !strings.HasPrefix(executionID, "projects/"):
+		// Pub/Sub runs record their run ID in place of an execution name.
+		return executionStatePubSub, nil
+	}
+	execution, err := f.retrigger.executionsClient.GetExecution(ctx, &executionspb.GetExecutionRequest{Name: executionID})
+	if status.Code(err) == codes.NotFound {
+		return executionStateNotFound, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get workflow execution: %w", err)
+	}
+	return execution.GetState().String(), nil
+}
+
+// act carries out decision on the document read as snap. A document that has changed
+// since it was read has made progress after all; it is left alone, and act returns false.
+func (f *StuckSweeperFunction) act(ctx context.Context, logCtx *slog.Logger, snap *firestore.DocumentSnapshot, decision sweepDecision) (models.StuckSweepOutcome, bool) {
+	outcome := models.StuckSweepOutcome{DocumentID: snap.Ref.ID, Action: decision.Action, Reason: decision.Reason}
+	logCtx.Warn("Found a stuck document.", "action", decision.Action, "reason", decision.Reason)
+
+	if decision.Action == sweepActionCancel {
+		recordCancelled(ctx, logCtx, f.retrigger.firestoreClient, f.retrigger.config.CollectionName, snap.Ref.ID, models.StatusStageSweeper)
+		return outcome, true
+	}
+	next := StatusStalled
+	if decision.Action == sweepActionRetrigger {
+		next = StatusPagesReadyWorkflowFailed
+	}
+	transition := models.StatusTransition{Status: next, Timestamp: time.Now(), Stage: models.StatusStageSweeper, Detail: decision.Reason}
+	_, err := snap.Ref.Update(ctx, []firestore.Update{
+		{Path: "status", Value: next},
+		{Path: "statusHistory", Value: firestore.ArrayUnion(transition)},
+		{Path: "errorDetails", Value: decision.Reason},
+	}, firestore.LastUpdateTime(snap.UpdateTime))
+	if status.Code(err) == codes.FailedPrecondition {
+		logCtx.Info("Document changed since it was read; leaving it.")
+		return outcome, false
+	}
+	if err != nil {
+		logCtx.Error("Failed to record stuck document", "error", err, "status", next)
+		outcome.Error = err.Error()
+		return outcome, true
+	}
+
+	if decision.Action == sweepActionRetrigger {
+		// A failed retrigger leaves the document in PAGES_READY_WORKFLOW_FAILED, for the
+		// workflow retrigger's own sweep.
+		retriggered := f.retrigger.retrigger(ctx, logCtx, snap)
+		outcome.ExecutionID, outcome.Error = retriggered.ExecutionID, retriggered.Error
+	}
+	return outcome, true
+}
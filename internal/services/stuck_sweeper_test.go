@@ -0,0 +1,95 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/workflows/executions/apiv1/executionspb"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+func TestDecideStuckDocument(t *testing.T) {
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	config := StuckSweeperConfig{Threshold: 2 * time.Hour, Limit: 20, MaxRetriggers: 2}
+	failed := executionspb.Execution_FAILED.String()
+
+	// stuckDoc returns a document of status whose status last changed age ago, after the
+	// sweeper had retriggered it retriggers times.
+	stuckDoc := func(status string, age time.Duration, retriggers int) *models.Document {
+		changed := now.Add(-age)
+		doc := &models.Document{Status: status, PageCount: 12, WorkflowExecutionID: "projects/p/locations/l/workflows/w/executions/e", CreatedAt: changed.Add(-time.Hour)}
+		for range retriggers {
+			doc.StatusHistory = append(doc.StatusHistory,
+				models.StatusTransition{Status: StatusPagesReadyWorkflowFailed, Stage: models.StatusStageSweeper, Timestamp: changed.Add(-time.Minute)},
+				models.StatusTransition{Status: "TRANSLATING", Stage: models.StatusStageRetrigger, Timestamp: changed.Add(-time.Minute)},
+			)
+		}
+		doc.StatusHistory = append(doc.StatusHistory, models.StatusTransition{Status: status, Timestamp: changed})
+		return doc
+	}
+	with := func(doc *models.Document, edit func(*models.Document)) *models.Document {
+		edit(doc)
+		return doc
+	}
+
+	tests := []struct {
+		name       string
+		doc        *models.Document
+		state      string
+		wantAction string
+		wantReason string
+	}{
+		{name: "complete", doc: stuckDoc("COMPLETE", 5*time.Hour, 0), state: failed, wantAction: sweepActionSkip, wantReason: "not in progress"},
+		{name: "waiting on the retrigger", doc: stuckDoc(StatusPagesReadyWorkflowFailed, 5*time.Hour, 0), state: failed, wantAction: sweepActionSkip, wantReason: "not in progress"},
+		{name: "stalled", doc: stuckDoc(StatusStalled, 5*time.Hour, 0), state: failed, wantAction: sweepActionSkip, wantReason: "not in progress"},
+		{name: "translating recently", doc: stuckDoc("TRANSLATING", time.Hour, 0), state: failed, wantAction: sweepActionSkip, wantReason: "changed recently"},
+		{name: "translating just under the threshold", doc: stuckDoc("TRANSLATING", 2*time.Hour-time.Second, 0), state: failed, wantAction: sweepActionSkip, wantReason: "changed recently"},
+		{name: "translating at the threshold", doc: stuckDoc("TRANSLATING", 2*time.Hour, 0), state: failed, wantAction: sweepActionRetrigger, wantReason: "TRANSLATING since"},
+		{name: "execution active", doc: stuckDoc("TRANSLATING", 5*time.Hour, 0), state: executionspb.Execution_ACTIVE.String(), wantAction: sweepActionSkip, wantReason: "is ACTIVE"},
+		{name: "execution queued", doc: stuckDoc(StatusCleaning, 5*time.Hour, 0), state: executionspb.Execution_QUEUED.String(), wantAction: sweepActionSkip, wantReason: "is QUEUED"},
+		{name: "translating, execution failed", doc: stuckDoc("TRANSLATING", 5*time.Hour, 0), state: failed, wantAction: sweepActionRetrigger, wantReason: "execution projects/p/locations/l/workflows/w/executions/e FAILED"},
+		{name: "aggregating, execution succeeded", doc: stuckDoc(StatusAggregating, 5*time.Hour, 1), state: executionspb.Execution_SUCCEEDED.String(), wantAction: sweepActionRetrigger, wantReason: "AGGREGATING since"},
+		{name: "cleaning, execution not found", doc: stuckDoc(StatusCleaning, 5*time.Hour, 0), state: executionStateNotFound, wantAction: sweepActionRetrigger, wantReason: "NOT_FOUND"},
+		{name: "splitting sections, no execution", doc: stuckDoc(StatusSplittingSections, 5*time.Hour, 0), state: executionStateNone, wantAction: sweepActionRetrigger, wantReason: "no workflow execution recorded"},
+		{name: "one retrigger short of the cap", doc: stuckDoc("TRANSLATING", 5*time.Hour, 1), state: failed, wantAction: sweepActionRetrigger},
+		{name: "at the retrigger cap", doc: stuckDoc("TRANSLATING", 5*time.Hour, 2), state: failed, wantAction: sweepActionStall, wantReason: "already retriggered 2 time(s)"},
+		{name: "past the retrigger cap", doc: stuckDoc(StatusCleaning, 5*time.Hour, 3), state: failed, wantAction: sweepActionStall, wantReason: "already retriggered 3 time(s)"},
+		{name: "splitting", doc: stuckDoc("SPLITTING", 5*time.Hour, 0), state: failed, wantAction: sweepActionStall, wantReason: "never all split"},
+		{name: "no page count", doc: with(stuckDoc("TRANSLATING", 5*time.Hour, 0), func(d *models.Document) { d.PageCount = 0 }), state: failed, wantAction: sweepActionStall, wantReason: "never all split"},
+		{name: "pub/sub run", doc: with(stuckDoc("TRANSLATING", 5*time.Hour, 0), func(d *models.Document) { d.WorkflowExecutionID = "run-1" }), state: executionStatePubSub, wantAction: sweepActionStall, wantReason: "in Pub/Sub run run-1"},
+		{name: "cancellation requested", doc: with(stuckDoc("TRANSLATING", 5*time.Hour, 2), func(d *models.Document) { d.CancelRequested = true }), state: failed, wantAction: sweepActionCancel, wantReason: "cancellation requested"},
+		{name: "cancellation requested, recently", doc: with(stuckDoc("TRANSLATING", time.Hour, 0), func(d *models.Document) { d.CancelRequested = true }), state: failed, wantAction: sweepActionSkip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decideStuckDocument(tt.doc, tt.state, now, config)
+			if got.Action != tt.wantAction || !strings.Contains(got.Reason, tt.wantReason) {
+				t.Errorf("decideStuckDocument() = %+v, want %s containing %q", got, tt.wantAction, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestDecideStuckDocumentWithoutRetriggers(t *testing.T) {
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	doc := &models.Document{Status: "TRANSLATING", PageCount: 3, CreatedAt: now.Add(-5 * time.Hour)}
+	config := StuckSweeperConfig{Threshold: 2 * time.Hour, Limit: 20, MaxRetriggers: 0}
+	got := decideStuckDocument(doc, executionStateNone, now, config)
+	if got.Action != sweepActionStall {
+		t.Errorf("decideStuckDocument() with MaxRetriggers 0 = %+v, want %s", got, sweepActionStall)
+	}
+}
+
+func TestSweeperRetriggers(t *testing.T) {
+	doc := &models.Document{StatusHistory: []models.StatusTransition{
+		{Status: StatusPagesReadyWorkflowFailed, Stage: models.StatusStageSweeper},
+		{Status: StatusPagesReadyWorkflowFailed, Stage: models.StatusStageRetrigger},
+		{Status: StatusStalled, Stage: models.StatusStageSweeper},
+		{Status: StatusPagesReadyWorkflowFailed, Stage: models.StatusStageSweeper},
+	}}
+	if got := sweeperRetriggers(doc); got != 2 {
+		t.Errorf("sweeperRetriggers() = %d, want 2", got)
+	}
+}
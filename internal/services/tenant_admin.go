@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+var (
+	// ErrTenantExists is returned when creating a tenant whose ID is already taken.
+	ErrTenantExists = errors.New("tenant already exists")
+	// ErrInvalidTenantConfig is returned when a submitted config fails validation or probing.
+	ErrInvalidTenantConfig = errors.New("invalid tenant config")
+)
+
+// TenantAdminConfig holds configuration for the tenant admin service.
+type TenantAdminConfig struct {
+	ProjectID        string
+	TenantCollection string
+}
+
+// TenantAdminFunction holds dependencies for managing tenant configs.
+type TenantAdminFunction struct {
+	serviceClients
+	firestoreClient *firestore.Client
+	storageClient   *storage.Client
+	tenants         *TenantStore
+	config          TenantAdminConfig
+}
+
+// NewTenantAdmin creates a new TenantAdminFunction instance.
+func NewTenantAdmin(ctx context.Context) (*TenantAdminFunction, error) {
+	projectID := gcp.GetEnv("PROJECT_ID", "")
+	if projectID == "" {
+		return nil, fmt.Errorf("GCP_PROJECT environment variable must be set")
+	}
+
+	config := TenantAdminConfig{
+		ProjectID:        projectID,
+		TenantCollection: gcp.GetEnv("TENANT_COLLECTION", "tenants"),
+	}
+
+	firestoreClient, err := gcp.NewFirestoreClient(ctx, config.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firestore client: %w", err)
+	}
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %w", err)
+	}
+
+	return &TenantAdminFunction{
+		serviceClients:  serviceClients{firestoreClient, storageClient},
+		firestoreClient: firestoreClient,
+		storageClient:   storageClient,
+		tenants:         NewTenantStore(firestoreClient, config.TenantCollection, tenantConfigTTL),
+		config:          config,
+	}, nil
+}
+
+// Get returns the stored config for a tenant without validating it, so an admin can
+// inspect and repair a config that is currently being rejected.
+func (f *TenantAdminFunction) Get(ctx context.Context, tenantID string) (*models.TenantConfig, error) {
+	tenant, err := f.tenants.fetch(ctx, tenantID)
+	if err != nil && !errors.Is(err, ErrTenantNotFound) {
+		slog.Error("Failed to read tenant config", "error", err, "tenantId", tenantID)
+	}
+	return tenant, err
+}
+
+// Put validates a tenant config, probes every referenced bucket, and stores it.
+// When create is true the tenant must not already exist; otherwise it must.
+func (f *TenantAdminFunction) Put(ctx context.Context, tenant *models.TenantConfig, create bool) (*models.TenantConfig, error) {
+	logCtx := slog.With("tenantId", tenant.TenantID, "create", create)
+
+	if err := tenant.Validate(); err != nil {
+		logCtx.Warn("Rejected tenant config", "error", err)
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTenantConfig, err)
+	}
+	if err := f.probeBuckets(ctx, tenant); err != nil {
+		logCtx.Warn("Tenant bucket probe failed", "error", err)
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTenantConfig, err)
+	}
+
+	tenant.UpdatedAt = time.Now()
+	docRef := f.firestoreClient.Collection(f.config.TenantCollection).Doc(tenant.TenantID)
+	var err error
+	if create {
+		_, err = docRef.Create(ctx, tenant)
+		if status.Code(err) == codes.AlreadyExists {
+			return nil, fmt.Errorf("%w: %s", ErrTenantExists, tenant.TenantID)
+		}
+	} else {
+		err = f.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			if _, err := tx.Get(docRef); err != nil {
+				if status.Code(err) == codes.NotFound {
+					return fmt.Errorf("%w: %s", ErrTenantNotFound, tenant.TenantID)
+				}
+				return err
+			}
+			return tx.Set(docRef, tenant)
+		})
+	}
+	if err != nil {
+		logCtx.Error("Failed to store tenant config", "error", err)
+		return nil, err
+	}
+
+	f.tenants.Invalidate(tenant.TenantID)
+	logCtx.Info("Tenant config stored.")
+	return tenant, nil
+}
+
+// probeBuckets checks that every bucket in the config exists and is readable by the
+// pipeline's service account.
+func (f *TenantAdminFunction) probeBuckets(ctx context.Context, tenant *models.TenantConfig) error {
+	for field, bucket := range tenant.Buckets() {
+		if _, err := f.storageClient.Bucket(bucket).Attrs(ctx); err != nil {
+			if errors.Is(err, storage.ErrBucketNotExist) {
+				return fmt.Errorf("%s: bucket %q does not exist", field, bucket)
+			}
+			return fmt.Errorf("%s: bucket %q is not accessible: %v", field, bucket, err)
+		}
+	}
+	return nil
+}
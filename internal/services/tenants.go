@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// StatusRejectedTenantConfig is recorded on a document whose tenant config fails validation.
+const StatusRejectedTenantConfig = "REJECTED_TENANT_CONFIG"
+
+// tenantConfigTTL is how long a TenantStore caches a tenant's config, and so how long an
+// admin update takes to reach the stages.
+const tenantConfigTTL = time.Minute
+
+var (
+	// ErrTenantNotFound is returned when no config exists for a tenant ID.
+	ErrTenantNotFound = errors.New("tenant not found")
+	// ErrTenantConfigRejected is returned when a stored tenant config fails validation.
+	ErrTenantConfigRejected = errors.New("tenant config rejected")
+)
+
+type cachedTenant struct {
+	config   *models.TenantConfig
+	err      error
+	loadedAt time.Time
+}
+
+// TenantStore loads tenant configs from Firestore and caches them for a short TTL,
+// so every stage can check its tenant without a read per request.
+type TenantStore struct {
+	firestoreClient *firestore.Client
+	collection      string
+	ttl             time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedTenant
+}
+
+// NewTenantStore creates a TenantStore over the given collection.
+func NewTenantStore(firestoreClient *firestore.Client, collection string, ttl time.Duration) *TenantStore {
+	return &TenantStore{
+		firestoreClient: firestoreClient,
+		collection:      collection,
+		ttl:             ttl,
+		cache:           make(map[string]cachedTenant),
+	}
+}
+
+// Load returns the validated config for a tenant. A stored config that fails validation
+// yields an error wrapping ErrTenantConfigRejected; callers should mark the document
+// REJECTED_TENANT_CONFIG rather than attempt to process it.
+func (s *TenantStore) Load(ctx context.Context, tenantID string) (*models.TenantConfig, error) {
+	s.mu.Lock()
+	entry, ok := s.cache[tenantID]
+	s.mu.Unlock()
+	if ok && time.Since(entry.loadedAt) < s.ttl {
+		return entry.config, entry.err
+	}
+
+	config, err := s.fetch(ctx, tenantID)
+	if err == nil {
+		if verr := config.Validate(); verr != nil {
+			config, err = nil, fmt.Errorf("%w: %v", ErrTenantConfigRejected, verr)
+		}
+	}
+	// Transient Firestore errors are not cached; not-found and rejected configs are.
+	if err == nil || errors.Is(err, ErrTenantNotFound) || errors.Is(err, ErrTenantConfigRejected) {
+		s.mu.Lock()
+		s.cache[tenantID] = cachedTenant{config: config, err: err, loadedAt: time.Now()}
+		s.mu.Unlock()
+	}
+	return config, err
+}
+
+// Require returns the config of tenantID for a stage about to process one of its
+// documents. A document without a tenant, or whose tenant has no stored config, is
+// processed with the service's own settings, and nil is returned for it. A stored config
+// that fails validation is an INVALID_REQUEST error wrapping ErrTenantConfigRejected, as
+// processing would only fail halfway through. A nil TenantStore, as the local flow runs
+// the stages with, requires nothing.
+func (s *TenantStore) Require(ctx context.Context, tenantID string) (*models.TenantConfig, error) {
+	if s == nil || tenantID == "" {
+		return nil, nil
+	}
+	config, err := s.Load(ctx, tenantID)
+	switch {
+	case errors.Is(err, ErrTenantNotFound):
+		return nil, nil
+	case errors.Is(err, ErrTenantConfigRejected):
+		return nil, models.WithCode(models.ErrorCodeInvalidRequest, fmt.Errorf("tenant %s: %w", tenantID, err))
+	case err != nil:
+		return nil, err
+	}
+	return config, nil
+}
+
+// Invalidate drops a tenant from the cache so the next Load re-reads Firestore.
+func (s *TenantStore) Invalidate(tenantID string) {
+	s.mu.Lock()
+	delete(s.cache, tenantID)
+	s.mu.Unlock()
+}
+
+func (s *TenantStore) fetch(ctx context.Context, tenantID string) (*models.TenantConfig, error) {
+	snap, err := s.firestoreClient.Collection(s.collection).Doc(tenantID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("%w: %s", ErrTenantNotFound, tenantID)
+		}
+		return nil, fmt.Errorf("failed to read tenant %s: %w", tenantID, err)
+	}
+	var config models.TenantConfig
+	if err := snap.DataTo(&config); err != nil {
+		return nil, fmt.Errorf("failed to decode tenant %s: %w", tenantID, err)
+	}
+	config.TenantID = snap.Ref.ID
+	return &config, nil
+}
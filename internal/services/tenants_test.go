@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+// cachedTenantStore returns a TenantStore without a Firestore client whose cache already
+// holds the given entries, so Load never reaches Firestore.
+func cachedTenantStore(entries map[string]cachedTenant) *TenantStore {
+	store := NewTenantStore(nil, "tenants", time.Hour)
+	for tenantID, entry := range entries {
+		entry.loadedAt = time.Now()
+		store.cache[tenantID] = entry
+	}
+	return store
+}
+
+func TestTenantStoreRequire(t *testing.T) {
+	valid := &models.TenantConfig{TenantID: "unit-a"}
+	store := cachedTenantStore(map[string]cachedTenant{
+		"unit-a":  {config: valid},
+		"missing": {err: ErrTenantNotFound},
+		"broken":  {err: fmt.Errorf("%w: invalid tenant config: priority 11 must be between 0 and 10", ErrTenantConfigRejected)},
+	})
+
+	tests := []struct {
+		name         string
+		store        *TenantStore
+		tenantID     string
+		wantConfig   *models.TenantConfig
+		wantRejected bool
+	}{
+		{name: "nil store", store: nil, tenantID: "unit-a"},
+		{name: "no tenant", store: store, tenantID: ""},
+		{name: "valid config", store: store, tenantID: "unit-a", wantConfig: valid},
+		{name: "tenant without a config", store: store, tenantID: "missing"},
+		{name: "rejected config", store: store, tenantID: "broken", wantRejected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := tt.store.Require(context.Background(), tt.tenantID)
+			if config != tt.wantConfig {
+				t.Errorf("Require() config = %v, want %v", config, tt.wantConfig)
+			}
+			if !tt.wantRejected {
+				if err != nil {
+					t.Fatalf("Require() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrTenantConfigRejected) {
+				t.Fatalf("Require() error = %v, want ErrTenantConfigRejected", err)
+			}
+			if code := models.CodeOf(err); code != models.ErrorCodeInvalidRequest {
+				t.Errorf("Require() error code = %s, want %s", code, models.ErrorCodeInvalidRequest)
+			}
+		})
+	}
+}
+
+func TestTenantStoreInvalidate(t *testing.T) {
+	store := cachedTenantStore(map[string]cachedTenant{"unit-a": {config: &models.TenantConfig{TenantID: "unit-a"}}})
+	store.Invalidate("unit-a")
+	if _, ok := store.cache["unit-a"]; ok {
+		t.Error("Invalidate() left the tenant cached")
+	}
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// stageStatusFailed is the timing status recorded for a stage run that returned an error.
+const stageStatusFailed = "failed"
+
+// stageTimer measures one run of a pipeline stage.
+type stageTimer struct {
+	stage     string
+	startedAt time.Time
+}
+
+// startStage starts timing a run of stage.
+func startStage(stage string) stageTimer {
+	return stageTimer{stage: stage, startedAt: time.Now()}
+}
+
+// timing returns the run's timing, ending now. The status is "failed" when err is set.
+func (t stageTimer) timing(status string, err error) *models.StageTiming {
+	if err != nil {
+		status = stageStatusFailed
+	}
+	completedAt := time.Now()
+	return &models.StageTiming{
+		StartedAt:   t.startedAt,
+		CompletedAt: completedAt,
+		DurationMs:  completedAt.Sub(t.startedAt).Milliseconds(),
+		Status:      status,
+	}
+}
+
+// record writes the run's timing to the document in a single update and returns it. It
+// is deferred from Process, so the update is made without the request's cancellation; a
+// stage that timed out is still recorded. Failing to record is logged, not returned.
+func (t stageTimer) record(ctx context.Context, logCtx *slog.Logger, client *firestore.Client, collection, docID, status string, err error) *models.StageTiming {
+	timing := t.timing(status, err)
+	if docID == "" {
+		return timing
+	}
+	if recErr := recordStageTiming(ctx, client, collection, docID, t.stage, timing); recErr != nil {
+		logCtx.Warn("Failed to record stage timing", "error", recErr, "stage", t.stage)
+	}
+	logCtx.Info("Stage finished.", "stage", t.stage, "status", timing.Status, "durationMs", timing.DurationMs)
+	return timing
+}
+
+// recordStageTiming replaces the document's timing for stage.
+func recordStageTiming(ctx context.Context, client *firestore.Client, collection, docID, stage string, timing *models.StageTiming) error {
+	updates := []firestore.Update{{Path: "timings." + stage, Value: timing}}
+	if err := gcp.UpdateWithRetry(ctx, client.Collection(collection).Doc(docID), updates); err != nil {
+		return fmt.Errorf("failed to record %s timing: %w", stage, err)
+	}
+	return nil
+}
+
+// recordPageTiming folds one page run into the document's aggregate timing for stage.
+// Transforms are applied server-side, so concurrent page runs aggregate correctly.
+func recordPageTiming(ctx context.Context, client *firestore.Client, collection, docID, stage string, timing *models.StageTiming) error {
+	prefix := "timings." + stage + "."
+	failed := int64(0)
+	if timing.Status == stageStatusFailed {
+		failed = 1
+	}
+	updates := []firestore.Update{
+		{Path: prefix + "count", Value: firestore.Increment(1)},
+		{Path: prefix + "failedCount", Value: firestore.Increment(failed)},
+		{Path: prefix + "totalMs", Value: firestore.Increment(timing.DurationMs)},
+		{Path: prefix + "minMs", Value: firestore.FieldTransformMinimum(timing.DurationMs)},
+		{Path: prefix + "maxMs", Value: firestore.FieldTransformMaximum(timing.DurationMs)},
+		{Path: prefix + "completedAt", Value: timing.CompletedAt},
+		{Path: prefix + "status", Value: timing.Status},
+	}
+	if _, err := client.Collection(collection).Doc(docID).Update(ctx, updates); err != nil {
+		return fmt.Errorf("failed to record %s page timing: %w", stage, err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Span attribute keys shared by the stages.
+const (
+	attrDocumentID = attribute.Key("document.id")
+	attrPageNumber = attribute.Key("document.page_number")
+	attrEndPage    = attribute.Key("document.end_page")
+	attrGCSURI     = attribute.Key("gcs.uri")
+	attrBytes      = attribute.Key("gcs.bytes")
+	attrAttempts   = attribute.Key("gemini.attempts")
+)
+
+var tracer = otel.Tracer(gcp.TracerName + "/internal/services")
+
+// startProcessSpan starts the root span of a stage's Process call. A traceparent passed on
+// by the workflow makes the span a child of the trace begun by the splitter.
+func startProcessSpan(ctx context.Context, name, traceparent string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx = gcp.ContextWithTraceparent(ctx, traceparent)
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+}
+
+// endProcessSpan ends a root span started by startProcessSpan and flushes the trace and
+// the metrics recorded by the call.
+func endProcessSpan(ctx context.Context, span trace.Span, err error) {
+	endSpan(span, err)
+	gcp.FlushTraces(context.WithoutCancel(ctx))
+	gcp.FlushMetrics(context.WithoutCancel(ctx))
+}
+
+// startSpan starts a child span of the span in ctx.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan marks span as failed if err is set, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
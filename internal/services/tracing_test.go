@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/vertexai/genai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+var (
+	spanRecorderOnce sync.Once
+	testSpanRecorder *tracetest.SpanRecorder
+)
+
+// spanRecorder installs a tracer provider recording every span, once for the whole test
+// binary: the package tracer is bound to the first provider installed after it. Tests
+// tell their spans apart by trace ID.
+func spanRecorder() *tracetest.SpanRecorder {
+	spanRecorderOnce.Do(func() {
+		testSpanRecorder = tracetest.NewSpanRecorder()
+		gcp.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(testSpanRecorder)))
+	})
+	return testSpanRecorder
+}
+
+// endedSpans returns the ended spans of trace traceID, keyed by name.
+func endedSpans(recorder *tracetest.SpanRecorder, traceID trace.TraceID) map[string]sdktrace.ReadOnlySpan {
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() == traceID {
+			spans[span.Name()] = span
+		}
+	}
+	return spans
+}
+
+func TestProcessSpanJoinsTheWorkflowTrace(t *testing.T) {
+	recorder := spanRecorder()
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	model := testsupport.NewFakeContentGenerator(testsupport.FakeResult{Response: testsupport.TextResponse(`[{"title": "Pump", "content": "# Pump\n\nCheck the impeller."}]`)})
+	f := &SectionSplitterFunction{model: model}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	source := "# Pump\n\nCheck the impeller.\n"
+
+	ctx, span := startProcessSpan(context.Background(), "sectionSplitter.Process", traceparent, attrDocumentID.String("doc-1"))
+	if got := gcp.Traceparent(ctx); got == "" || got == traceparent {
+		t.Errorf("Traceparent() = %q, want the process span's own", got)
+	}
+	if _, err := f.generateSections(ctx, logCtx, "doc-1", genai.Text(source), source); err != nil {
+		t.Fatalf("generateSections() error = %v", err)
+	}
+	endProcessSpan(ctx, span, nil)
+
+	spans := endedSpans(recorder, span.SpanContext().TraceID())
+	root, ok := spans["sectionSplitter.Process"]
+	if !ok {
+		t.Fatalf("spans = %v, want the process span", spans)
+	}
+	if got := root.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("process span trace = %s, want the workflow's", got)
+	}
+	if got := root.Parent().SpanID().String(); got != "00f067aa0ba902b7" || !root.Parent().IsRemote() {
+		t.Errorf("process span parent = %s, want the workflow's remote span", got)
+	}
+	if root.SpanKind() != trace.SpanKindServer || root.Status().Code != codes.Unset {
+		t.Errorf("process span = kind %s, status %+v; want an unfailed server span", root.SpanKind(), root.Status())
+	}
+	if !hasAttribute(root.Attributes(), attrDocumentID.String("doc-1")) {
+		t.Errorf("process span attributes = %v, want the document ID", root.Attributes())
+	}
+	call, ok := spans["gemini.GenerateContent"]
+	if !ok {
+		t.Fatalf("spans = %v, want the model call's", spans)
+	}
+	if call.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Errorf("model call span parent = %s, want the process span", call.Parent().SpanID())
+	}
+}
+
+func TestFailedSpanRecordsTheError(t *testing.T) {
+	recorder := spanRecorder()
+	ctx, span := startProcessSpan(context.Background(), "cleaner.Process", "")
+	_, child := startSpan(ctx, "gcs.Read", attrGCSURI.String("gs://master/doc-1/master.md"))
+	endSpan(child, errors.New("object not found"))
+	endProcessSpan(ctx, span, errors.New("failed to read master"))
+
+	spans := endedSpans(recorder, span.SpanContext().TraceID())
+	for name, wantMessage := range map[string]string{"gcs.Read": "object not found", "cleaner.Process": "failed to read master"} {
+		got, ok := spans[name]
+		if !ok {
+			t.Errorf("spans = %v, want %s", spans, name)
+			continue
+		}
+		if got.Status().Code != codes.Error || got.Status().Description != wantMessage {
+			t.Errorf("%s status = %+v, want an error of %q", name, got.Status(), wantMessage)
+		}
+		if events := got.Events(); len(events) != 1 || events[0].Name != "exception" {
+			t.Errorf("%s events = %+v, want the error recorded", name, events)
+		}
+	}
+	if root := spans["cleaner.Process"]; root != nil && root.Parent().IsValid() {
+		t.Errorf("process span parent = %s, want a new trace", root.Parent().SpanID())
+	}
+}
+
+func hasAttribute(attrs []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, attr := range attrs {
+		if attr == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// TranslationCacheConfig sets up the cross-document translation cache, which reuses the
+// translation of a page for identical pages of other documents, such as the unchanged
+// pages of a new revision of a specification.
+type TranslationCacheConfig struct {
+	Collection string // Empty disables the cache.
+	// TTL is how long an entry is reused for. Expired entries are deleted when read.
+	TTL time.Duration
+}
+
+// loadTranslationCacheConfig reads TRANSLATION_CACHE_COLLECTION and TRANSLATION_CACHE_TTL.
+func loadTranslationCacheConfig() (TranslationCacheConfig, error) {
+	config := TranslationCacheConfig{Collection: gcp.GetEnv("TRANSLATION_CACHE_COLLECTION", "")}
+	ttl, err := time.ParseDuration(gcp.GetEnv("TRANSLATION_CACHE_TTL", "720h"))
+	if err != nil || ttl <= 0 {
+		return config, fmt.Errorf("TRANSLATION_CACHE_TTL must be a positive duration")
+	}
+	config.TTL = ttl
+	return config, nil
+}
+
+// Enabled reports whether translations are cached.
+func (c TranslationCacheConfig) Enabled() bool {
+	return c.Collection != ""
+}
+
+// translationCacheKey is what a cached translation must match to be reused.
+type translationCacheKey struct {
+	PageHash       string
+	Model          string
+	PromptProfile  string
+	PromptVersion  string
+	SourceLanguage string
+	TargetLanguage string
+	TenantID       string
+}
+
+// ID returns the ID of the key's cache entry. The parts are hashed, as model names may
+// hold characters a document ID cannot. The tenant is only part of the hash when there is
+// one, so that the entries of documents without a tenant keep their IDs. The prompt
+// version is left out, so that the entry made with an earlier prompt is found, dropped
+// as stale and replaced.
+func (k translationCacheKey) ID() string {
+	parts := []string{k.PageHash, k.Model, k.PromptProfile, k.SourceLanguage, k.TargetLanguage}
+	if k.TenantID != "" {
+		parts = append(parts, k.TenantID)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// matches reports whether entry was made for the key. An entry of another model, prompt
+// profile or prompt version, however it came to be stored under the key's ID, is stale.
+func (k translationCacheKey) matches(entry *models.TranslationCacheEntry) bool {
+	return entry.PageHash == k.PageHash &&
+		entry.Model == k.Model &&
+		entry.PromptProfile == k.PromptProfile &&
+		entry.PromptVersion == k.PromptVersion &&
+		entry.SourceLanguage == k.SourceLanguage &&
+		entry.TargetLanguage == k.TargetLanguage &&
+		entry.TenantID == k.TenantID
+}
+
+// cachedTranslation is a cache entry found for a page, with the markdown it names.
+type cachedTranslation struct {
+	Entry    *models.TranslationCacheEntry
+	Markdown string
+}
+
+// promptVersion returns a short hash of the system and user prompts a page is translated
+// with, so that changing either retires the translations made with the old ones.
+func promptVersion(systemPrompt, userPrompt string) string {
+	sum := sha256.Sum256([]byte(systemPrompt + "\x00" + userPrompt))
+	return hex.EncodeToString(sum[:8])
+}
+
+// translationCacheKeyFor hashes the page PDF at gcsURI and returns the key of its
+// translation with the given prompt profile and prompts, and the request's language hints.
+func (f *TranslatorFunction) translationCacheKeyFor(ctx context.Context, req *models.PageTranslatorRequest, profile, systemPrompt, userPrompt string) (translationCacheKey, error) {
+	bucket, object, err := gcp.ParseGCSURI(req.GCSUri)
+	if err != nil {
+		return translationCacheKey{}, err
+	}
+	reader, err := f.storageClient.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		return translationCacheKey{}, fmt.Errorf("failed to open %s: %w", req.GCSUri, err)
+	}
+	defer reader.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return translationCacheKey{}, fmt.Errorf("failed to hash %s: %w", req.GCSUri, err)
+	}
+	return translationCacheKey{
+		PageHash:       hex.EncodeToString(hash.Sum(nil)),
+		Model:          f.config.Models.TranslatorModelName,
+		PromptProfile:  profile,
+		PromptVersion:  promptVersion(systemPrompt, userPrompt),
+		SourceLanguage: req.SourceLanguage,
+		TargetLanguage: req.TargetLanguage,
+		TenantID:       req.TenantID,
+	}, nil
+}
+
+// lookupCachedTranslation returns the cached translation of key, or nil on a miss. An
+// entry that has expired, is stale, or whose markdown has since been deleted is a miss,
+// and is deleted so that the translation made instead replaces it. Failures are logged
+// and treated as a miss; the cache only ever saves a model call.
+func (f *TranslatorFunction) lookupCachedTranslation(ctx context.Context, logCtx *slog.Logger, key translationCacheKey, now time.Time) *cachedTranslation {
+	ref := f.firestoreClient.Collection(f.config.Cache.Collection).Doc(key.ID())
+	snap, err := ref.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		logCtx.Warn("Failed to read the translation cache; translating instead.", "error", err)
+		return nil
+	}
+	var entry models.TranslationCacheEntry
+	if err := snap.DataTo(&entry); err != nil {
+		logCtx.Warn("Failed to decode a translation cache entry; translating instead.", "error", err, "cacheEntry", ref.ID)
+		return nil
+	}
+
+	var markdown string
+	reason := ""
+	switch {
+	case !key.matches(&entry):
+		reason = "stale"
+	case now.Sub(entry.CreatedAt) > f.config.Cache.TTL:
+		reason = "expired"
+	default:
+		markdown, err = f.readCachedMarkdown(ctx, entry.MarkdownGCSUri)
+		if errors.Is(err, objectstore.ErrObjectNotExist) {
+			reason = "markdown deleted"
+		} else if err != nil {
+			logCtx.Warn("Failed to read cached markdown; translating instead.", "error", err, "markdownGcsUri", entry.MarkdownGCSUri)
+			return nil
+		}
+	}
+	if reason != "" {
+		logCtx.Info("Dropping a translation cache entry.", "reason", reason, "cacheEntry", ref.ID, "model", entry.Model, "createdAt", entry.CreatedAt)
+		if _, err := ref.Delete(ctx, firestore.LastUpdateTime(snap.UpdateTime)); err != nil && status.Code(err) != codes.FailedPrecondition {
+			logCtx.Warn("Failed to delete a translation cache entry", "error", err, "cacheEntry", ref.ID)
+		}
+		return nil
+	}
+	return &cachedTranslation{Entry: &entry, Markdown: markdown}
+}
+
+// readCachedMarkdown reads the markdown a cache entry names.
+func (f *TranslatorFunction) readCachedMarkdown(ctx context.Context, gcsURI string) (string, error) {
+	bucket, object, err := gcp.ParseGCSURI(gcsURI)
+	if err != nil {
+		return "", err
+	}
+	return readObject(ctx, objectstore.WrapBucket(f.storageClient.Bucket(bucket)), object)
+}
+
+// storeCachedTranslation records the translation of key stored at markdownGCSUri,
+// replacing any earlier entry. Failing to is only logged.
+func (f *TranslatorFunction) storeCachedTranslation(ctx context.Context, logCtx *slog.Logger, key translationCacheKey, docID, markdownGCSUri string, usage *models.TokenUsage) {
+	entry := models.TranslationCacheEntry{
+		PageHash:       key.PageHash,
+		Model:          key.Model,
+		PromptProfile:  key.PromptProfile,
+		PromptVersion:  key.PromptVersion,
+		SourceLanguage: key.SourceLanguage,
+		TargetLanguage: key.TargetLanguage,
+		TenantID:       key.TenantID,
+		MarkdownGCSUri: markdownGCSUri,
+		DocumentID:     docID,
+		Usage:          usage,
+		CreatedAt:      time.Now(),
+	}
+	if _, err := f.firestoreClient.Collection(f.config.Cache.Collection).Doc(key.ID()).Set(ctx, entry); err != nil {
+		logCtx.Warn("Failed to store the translation in the cache", "error", err)
+		return
+	}
+	logCtx.Info("Stored the translation in the cache.", "cacheEntry", key.ID())
+}
@@ -0,0 +1,88 @@
+//go:build integration
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestLookupCachedTranslation(t *testing.T) {
+	ctx := context.Background()
+	emulators := testsupport.RequireEmulators(t)
+	firestoreClient, storageClient := emulators.Clients(ctx, t)
+	translated := emulators.CreateBuckets(ctx, t, storageClient, "translated")[0]
+	w := storageClient.Bucket(translated).Object("doc-1/00001.md").NewWriter(ctx)
+	if _, err := io.WriteString(w, "# Pump"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	markdownGCSUri := fmt.Sprintf("gs://%s/doc-1/00001.md", translated)
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	key := translationCacheKey{PageHash: "abc123", Model: "gemini-2.0-flash", PromptProfile: "default", PromptVersion: promptVersion("system", "user")}
+
+	tests := []struct {
+		name     string
+		stored   *translationCacheKey // The key the entry was stored under, if any.
+		markdown string               // The URI of the entry's markdown, if not markdownGCSUri.
+		age      time.Duration
+		wantHit  bool
+	}{
+		{name: "hit", stored: &key, wantHit: true},
+		{name: "miss"},
+		{name: "model changed", stored: &translationCacheKey{PageHash: "abc123", Model: "gemini-1.5-pro", PromptProfile: "default", PromptVersion: key.PromptVersion}},
+		{name: "prompt changed", stored: &translationCacheKey{PageHash: "abc123", Model: "gemini-2.0-flash", PromptProfile: "default", PromptVersion: promptVersion("system", "older user")}},
+		{name: "expired", stored: &key, age: 2 * time.Hour},
+		{name: "markdown deleted", stored: &key, markdown: fmt.Sprintf("gs://%s/doc-1/missing.md", translated)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &TranslatorFunction{
+				storageClient:   storageClient,
+				firestoreClient: firestoreClient,
+				config: TranslatorConfig{Cache: TranslationCacheConfig{
+					Collection: fmt.Sprintf("translation-cache-%d", time.Now().UnixNano()),
+					TTL:        time.Hour,
+				}},
+			}
+			if tt.stored != nil {
+				// The entry is stored under the ID of the key it is looked up with, as the
+				// ID leaves out the prompt version and an older entry may be there.
+				entry := cacheEntryFor(*tt.stored)
+				entry.MarkdownGCSUri, entry.DocumentID, entry.CreatedAt = markdownGCSUri, "doc-1", time.Now()
+				if tt.markdown != "" {
+					entry.MarkdownGCSUri = tt.markdown
+				}
+				if _, err := firestoreClient.Collection(f.config.Cache.Collection).Doc(key.ID()).Set(ctx, entry); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cached := f.lookupCachedTranslation(ctx, logCtx, key, time.Now().Add(tt.age))
+			if (cached != nil) != tt.wantHit {
+				t.Fatalf("lookupCachedTranslation() = %+v, want hit %v", cached, tt.wantHit)
+			}
+			snap, err := firestoreClient.Collection(f.config.Cache.Collection).Doc(key.ID()).Get(ctx)
+			if tt.wantHit {
+				if cached.Markdown != "# Pump" || cached.Entry.MarkdownGCSUri != markdownGCSUri {
+					t.Errorf("lookupCachedTranslation() = %q from %s", cached.Markdown, cached.Entry.MarkdownGCSUri)
+				}
+				if err != nil {
+					t.Errorf("cache entry gone after a hit: %v", err)
+				}
+				return
+			}
+			if tt.stored != nil && snap.Exists() {
+				t.Errorf("%s cache entry was not deleted", tt.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+// cacheEntryFor returns the entry storeCachedTranslation records for key.
+func cacheEntryFor(key translationCacheKey) *models.TranslationCacheEntry {
+	return &models.TranslationCacheEntry{
+		PageHash:       key.PageHash,
+		Model:          key.Model,
+		PromptProfile:  key.PromptProfile,
+		PromptVersion:  key.PromptVersion,
+		SourceLanguage: key.SourceLanguage,
+		TargetLanguage: key.TargetLanguage,
+		TenantID:       key.TenantID,
+	}
+}
+
+func TestTranslationCacheKeyMatches(t *testing.T) {
+	key := translationCacheKey{
+		PageHash:       "abc123",
+		Model:          "gemini-2.0-flash",
+		PromptProfile:  "default",
+		PromptVersion:  promptVersion("system", "user"),
+		SourceLanguage: "de",
+		TargetLanguage: "en",
+		TenantID:       "acme",
+	}
+	tests := []struct {
+		name      string
+		edit      func(*models.TranslationCacheEntry)
+		wantMatch bool
+	}{
+		{name: "same key", edit: func(*models.TranslationCacheEntry) {}, wantMatch: true},
+		{name: "other model", edit: func(e *models.TranslationCacheEntry) { e.Model = "gemini-1.5-pro" }},
+		{name: "other prompt profile", edit: func(e *models.TranslationCacheEntry) { e.PromptProfile = "drawing" }},
+		{name: "other prompt version", edit: func(e *models.TranslationCacheEntry) { e.PromptVersion = promptVersion("system", "older user") }},
+		{name: "entry from before prompt versions", edit: func(e *models.TranslationCacheEntry) { e.PromptVersion = "" }},
+		{name: "other page", edit: func(e *models.TranslationCacheEntry) { e.PageHash = "def456" }},
+		{name: "other target language", edit: func(e *models.TranslationCacheEntry) { e.TargetLanguage = "fr" }},
+		{name: "other tenant", edit: func(e *models.TranslationCacheEntry) { e.TenantID = "globex" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := cacheEntryFor(key)
+			tt.edit(entry)
+			if got := key.matches(entry); got != tt.wantMatch {
+				t.Errorf("matches() = %v, want %v", got, tt.wantMatch)
+			}
+		})
+	}
+}
+
+func TestTranslationCacheKeyID(t *testing.T) {
+	key := translationCacheKey{PageHash: "abc123", Model: "gemini-2.0-flash", PromptProfile: "default", PromptVersion: promptVersion("system", "user")}
+	id := key.ID()
+	if len(id) != 64 {
+		t.Errorf("ID() = %q, want a SHA-256 in hex", id)
+	}
+
+	// A new prompt version replaces the entry under the same ID.
+	newPrompt := key
+	newPrompt.PromptVersion = promptVersion("system", "new user")
+	if newPrompt.ID() != id {
+		t.Errorf("ID() changed with the prompt version")
+	}
+	for name, other := range map[string]translationCacheKey{
+		"model":          {PageHash: "abc123", Model: "gemini-1.5-pro", PromptProfile: "default"},
+		"prompt profile": {PageHash: "abc123", Model: "gemini-2.0-flash", PromptProfile: "drawing"},
+		"tenant":         {PageHash: "abc123", Model: "gemini-2.0-flash", PromptProfile: "default", TenantID: "acme"},
+	} {
+		if other.ID() == id {
+			t.Errorf("ID() unchanged by another %s", name)
+		}
+	}
+}
+
+func TestPromptVersion(t *testing.T) {
+	version := promptVersion("system", "user")
+	if len(version) != 16 || version != promptVersion("system", "user") {
+		t.Errorf("promptVersion() = %q, want a stable 16 character hash", version)
+	}
+	for _, other := range []string{promptVersion("system", "user2"), promptVersion("system2", "user"), promptVersion("systemu", "ser")} {
+		if other == version {
+			t.Errorf("promptVersion() = %q for different prompts", other)
+		}
+	}
+}
+
+func TestLoadTranslationCacheConfig(t *testing.T) {
+	for _, key := range []string{"TRANSLATION_CACHE_COLLECTION", "TRANSLATION_CACHE_TTL"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+	config, err := loadTranslationCacheConfig()
+	if err != nil || config.Enabled() || config.TTL != 720*time.Hour {
+		t.Errorf("loadTranslationCacheConfig() = %+v, %v; want it disabled with a 720h TTL", config, err)
+	}
+	t.Setenv("TRANSLATION_CACHE_COLLECTION", "translation-cache")
+	t.Setenv("TRANSLATION_CACHE_TTL", "24h")
+	if config, err := loadTranslationCacheConfig(); err != nil || !config.Enabled() || config.TTL != 24*time.Hour {
+		t.Errorf("loadTranslationCacheConfig() = %+v, %v; want it enabled with a 24h TTL", config, err)
+	}
+	for _, ttl := range []string{"0s", "-1h", "a month"} {
+		t.Setenv("TRANSLATION_CACHE_TTL", ttl)
+		if _, err := loadTranslationCacheConfig(); err == nil {
+			t.Errorf("loadTranslationCacheConfig() accepted TTL %q", ttl)
+		}
+	}
+}
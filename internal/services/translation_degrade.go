@@ -0,0 +1,210 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// degradedInputMIMEType is the type of the image an oversize page is re-rendered to.
+const degradedInputMIMEType = "image/jpeg"
+
+// OversizeDegradeConfig sets how pages over the model's input limit are re-rendered
+// before falling back to a placeholder.
+type OversizeDegradeConfig struct {
+	// DPIs are the resolutions tried, highest first. Empty disables re-rendering.
+	DPIs []int
+	// MaxSourceBytes caps the size of a page downloaded to be re-rendered.
+	MaxSourceBytes int64
+}
+
+// loadOversizeDegradeConfig reads TRANSLATOR_DEGRADE_DPI and TRANSLATOR_DEGRADE_MAX_BYTES.
+func loadOversizeDegradeConfig() (OversizeDegradeConfig, error) {
+	var config OversizeDegradeConfig
+	for _, field := range strings.Split(gcp.GetEnv("TRANSLATOR_DEGRADE_DPI", "150,100,72"), ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		dpi, err := strconv.Atoi(field)
+		if err != nil || dpi <= 0 || (len(config.DPIs) > 0 && dpi >= config.DPIs[len(config.DPIs)-1]) {
+			return config, fmt.Errorf("TRANSLATOR_DEGRADE_DPI must be a comma-separated list of decreasing positive resolutions")
+		}
+		config.DPIs = append(config.DPIs, dpi)
+	}
+	maxSourceBytes, err := strconv.ParseInt(gcp.GetEnv("TRANSLATOR_DEGRADE_MAX_BYTES", "268435456"), 10, 64)
+	if err != nil || maxSourceBytes <= 0 {
+		return config, fmt.Errorf("TRANSLATOR_DEGRADE_MAX_BYTES must be a positive integer")
+	}
+	config.MaxSourceBytes = maxSourceBytes
+	return config, nil
+}
+
+// exceedsInputLimit reports whether a page of size bytes is too large to send the model.
+func (c TranslatorConfig) exceedsInputLimit(size int64) bool {
+	return size > c.MaxInputBytes
+}
+
+// degradedInputLimit is the size a re-rendered page must fit in. It is sent inline, so
+// it is held to the inline limit as well as the model's.
+func (c TranslatorConfig) degradedInputLimit() int64 {
+	if c.InlineMaxBytes > 0 {
+		return min(c.MaxInputBytes, c.InlineMaxBytes)
+	}
+	return c.MaxInputBytes
+}
+
+// pageRenderer renders a single-page PDF to a JPEG image at the given resolution.
+type pageRenderer interface {
+	Render(pdf []byte, dpi int) ([]byte, error)
+}
+
+// rasterRenderer renders a page from its raster content, as extracted for image
+// references. A page over the model's input limit is almost always a scan or a fold-out
+// drawing carried by one large image, so its largest image, scaled to the page's size at
+// the resolution asked for, stands in for the page.
+type rasterRenderer struct{}
+
+// errNoRasterContent is returned by rasterRenderer for a page without a decodable image.
+var errNoRasterContent = errors.New("the page has no raster content to re-render")
+
+func (rasterRenderer) Render(pdf []byte, dpi int) ([]byte, error) {
+	images, _, err := extractPageImages(bytes.NewReader(pdf))
+	if err != nil {
+		return nil, err
+	}
+	var largest image.Image
+	for _, extracted := range images {
+		img, _, err := image.Decode(bytes.NewReader(extracted.data))
+		if err != nil {
+			continue
+		}
+		if largest == nil || pixelCount(img.Bounds()) > pixelCount(largest.Bounds()) {
+			largest = img
+		}
+	}
+	if largest == nil {
+		return nil, errNoRasterContent
+	}
+
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	dims, err := api.PageDims(bytes.NewReader(pdf), conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page size: %w", err)
+	}
+	if len(dims) == 0 {
+		return nil, fmt.Errorf("the PDF has no pages")
+	}
+	// Page sizes are in points, 72 to the inch. The image is never scaled up.
+	bounds := largest.Bounds()
+	scale := min(dims[0].Width*float64(dpi)/72/float64(bounds.Dx()), dims[0].Height*float64(dpi)/72/float64(bounds.Dy()), 1)
+	width, height := max(int(float64(bounds.Dx())*scale), 1), max(int(float64(bounds.Dy())*scale), 1)
+	rendered := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(rendered, rendered.Bounds(), largest, bounds, draw.Src, nil)
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, rendered, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, fmt.Errorf("failed to encode page image: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+func pixelCount(r image.Rectangle) int {
+	return r.Dx() * r.Dy()
+}
+
+// degradedPage is an oversize page re-rendered to fit the model's input limit.
+type degradedPage struct {
+	Data []byte
+	DPI  int
+}
+
+// input describes the page for the translator's response; nil for a page not degraded.
+func (d *degradedPage) input() *models.DegradedInput {
+	if d == nil {
+		return nil
+	}
+	return &models.DegradedInput{DPI: d.DPI, SizeBytes: int64(len(d.Data))}
+}
+
+// degradePage renders pdf at each of dpis in turn and returns the first rendering no
+// larger than maxBytes, or nil when none is.
+func degradePage(logCtx *slog.Logger, renderer pageRenderer, pdf []byte, dpis []int, maxBytes int64) (*degradedPage, error) {
+	for _, dpi := range dpis {
+		data, err := renderer.Render(pdf, dpi)
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) <= maxBytes {
+			return &degradedPage{Data: data, DPI: dpi}, nil
+		}
+		logCtx.Info("Re-rendered page is still over the input limit.", "dpi", dpi, "renderedBytes", len(data), "maxBytes", maxBytes)
+	}
+	return nil, nil
+}
+
+// degradeOversizePage re-renders a page over the model's input limit so that it can be
+// translated from a smaller image. It returns nil, having logged why, when the page
+// cannot be: re-rendering is disabled, the request is for several pages or redacted, or
+// no rendering fits. The page then gets the oversize placeholder.
+func (f *TranslatorFunction) degradeOversizePage(ctx context.Context, logCtx *slog.Logger, req *models.PageTranslatorRequest, inputSize int64) *degradedPage {
+	startPage, endPage := req.Pages()
+	switch {
+	case len(f.config.Degrade.DPIs) == 0 || f.renderer == nil:
+		return nil
+	case startPage != endPage:
+		logCtx.Info("Oversize chunks are not re-rendered.")
+		return nil
+	case req.RedactionMode == models.RedactionModeDLP:
+		logCtx.Info("Oversize redacted pages are not re-rendered.")
+		return nil
+	case inputSize > f.config.Degrade.MaxSourceBytes:
+		logCtx.Info("Page is too large to re-render.", "inputBytes", inputSize, "maxSourceBytes", f.config.Degrade.MaxSourceBytes)
+		return nil
+	}
+
+	bucket, object, err := gcp.ParseGCSURI(req.GCSUri)
+	if err != nil {
+		logCtx.Warn("Failed to re-render oversize page", "error", err)
+		return nil
+	}
+	reader, err := f.storageClient.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		logCtx.Warn("Failed to download oversize page to re-render it", "error", err)
+		return nil
+	}
+	pdf, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		logCtx.Warn("Failed to download oversize page to re-render it", "error", err)
+		return nil
+	}
+
+	degraded, err := degradePage(logCtx, f.renderer, pdf, f.config.Degrade.DPIs, f.config.degradedInputLimit())
+	switch {
+	case err != nil:
+		logCtx.Warn("Failed to re-render oversize page", "error", err)
+		return nil
+	case degraded == nil:
+		logCtx.Warn("No re-rendering of the oversize page fits the input limit.", "dpis", f.config.Degrade.DPIs)
+		return nil
+	}
+	logCtx.Info("Translating the oversize page from a re-rendered image.", "inputBytes", inputSize, "renderedBytes", len(degraded.Data), "dpi", degraded.DPI)
+	return degraded
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// fakeRenderer renders a page to Sizes[dpi] bytes, recording the resolutions asked for.
+type fakeRenderer struct {
+	Sizes map[int]int
+	Err   error
+	DPIs  []int
+}
+
+func (r *fakeRenderer) Render(pdf []byte, dpi int) ([]byte, error) {
+	r.DPIs = append(r.DPIs, dpi)
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return make([]byte, r.Sizes[dpi]), nil
+}
+
+func TestLoadOversizeDegradeConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		dpis    string
+		want    []int
+		wantErr bool
+	}{
+		{name: "list", dpis: "150, 100,72", want: []int{150, 100, 72}},
+		{name: "disabled", dpis: "", want: nil},
+		{name: "increasing", dpis: "72,150", wantErr: true},
+		{name: "not a number", dpis: "high", wantErr: true},
+		{name: "zero", dpis: "0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRANSLATOR_DEGRADE_DPI", tt.dpis)
+			config, err := loadOversizeDegradeConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadOversizeDegradeConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(config.DPIs, tt.want) {
+				t.Errorf("DPIs = %v, want %v", config.DPIs, tt.want)
+			}
+		})
+	}
+}
+
+func TestDegradedInputLimit(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxInput      int64
+		inlineMax     int64
+		wantLimit     int64
+		pageSize      int64
+		wantOverLimit bool
+	}{
+		{name: "inline limit is lower", maxInput: 50, inlineMax: 15, wantLimit: 15, pageSize: 40},
+		{name: "model limit is lower", maxInput: 10, inlineMax: 15, wantLimit: 10, pageSize: 11, wantOverLimit: true},
+		{name: "inline sends disabled", maxInput: 50, inlineMax: 0, wantLimit: 50, pageSize: 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := TranslatorConfig{MaxInputBytes: tt.maxInput, InlineMaxBytes: tt.inlineMax}
+			if got := config.degradedInputLimit(); got != tt.wantLimit {
+				t.Errorf("degradedInputLimit() = %d, want %d", got, tt.wantLimit)
+			}
+			if got := config.exceedsInputLimit(tt.pageSize); got != tt.wantOverLimit {
+				t.Errorf("exceedsInputLimit(%d) = %v, want %v", tt.pageSize, got, tt.wantOverLimit)
+			}
+		})
+	}
+}
+
+func TestDegradePage(t *testing.T) {
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dpis := []int{150, 100, 72}
+	tests := []struct {
+		name     string
+		renderer *fakeRenderer
+		want     *degradedPage
+		wantDPIs []int
+		wantErr  bool
+	}{
+		{
+			name:     "first resolution fits",
+			renderer: &fakeRenderer{Sizes: map[int]int{150: 10, 100: 5, 72: 2}},
+			want:     &degradedPage{Data: make([]byte, 10), DPI: 150},
+			wantDPIs: []int{150},
+		},
+		{
+			name:     "lower resolution fits",
+			renderer: &fakeRenderer{Sizes: map[int]int{150: 40, 100: 20, 72: 9}},
+			want:     &degradedPage{Data: make([]byte, 9), DPI: 72},
+			wantDPIs: []int{150, 100, 72},
+		},
+		{
+			name:     "nothing fits, so the page gets a placeholder",
+			renderer: &fakeRenderer{Sizes: map[int]int{150: 40, 100: 20, 72: 11}},
+			wantDPIs: []int{150, 100, 72},
+		},
+		{
+			name:     "render error",
+			renderer: &fakeRenderer{Err: errNoRasterContent},
+			wantDPIs: []int{150},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := degradePage(logCtx, tt.renderer, []byte("%PDF-"), dpis, 10)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("degradePage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("degradePage() = %+v, want %+v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.renderer.DPIs, tt.wantDPIs) {
+				t.Errorf("rendered at %v, want %v", tt.renderer.DPIs, tt.wantDPIs)
+			}
+		})
+	}
+}
+
+func TestDegradeOversizePageFallsBackToPlaceholder(t *testing.T) {
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	chunk := &models.PageRange{StartPage: 1, EndPage: 2}
+	tests := []struct {
+		name string
+		dpis []int
+		req  *models.PageTranslatorRequest
+	}{
+		{name: "re-rendering disabled", req: &models.PageTranslatorRequest{PageNumber: 1}},
+		{name: "chunk", dpis: []int{72}, req: &models.PageTranslatorRequest{PageRange: chunk}},
+		{name: "redacted page", dpis: []int{72}, req: &models.PageTranslatorRequest{PageNumber: 1, RedactionMode: models.RedactionModeDLP}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renderer := &fakeRenderer{Sizes: map[int]int{72: 1}}
+			f := &TranslatorFunction{renderer: renderer, config: TranslatorConfig{
+				MaxInputBytes: 10,
+				Degrade:       OversizeDegradeConfig{DPIs: tt.dpis, MaxSourceBytes: 100},
+			}}
+			if got := f.degradeOversizePage(context.Background(), logCtx, tt.req, 50); got != nil {
+				t.Errorf("degradeOversizePage() = %+v, want nil", got)
+			}
+			if len(renderer.DPIs) > 0 {
+				t.Errorf("page was rendered at %v", renderer.DPIs)
+			}
+		})
+	}
+}
+
+func TestRasterRendererScalesDown(t *testing.T) {
+	// A 2000x1000 pixel scan imported onto a page of its own size in points, so at 72 DPI
+	// it renders at 2000x1000 and at 36 DPI at half that.
+	scan := image.NewGray(image.Rect(0, 0, 2000, 1000))
+	for x := 0; x < 2000; x += 10 {
+		for y := 0; y < 1000; y++ {
+			scan.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, scan); err != nil {
+		t.Fatal(err)
+	}
+	var pdf bytes.Buffer
+	if err := api.ImportImages(nil, &pdf, []io.Reader{&encoded}, nil, nil); err != nil {
+		t.Fatalf("ImportImages() error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		dpi           int
+		width, height int
+	}{
+		{dpi: 36, width: 1000, height: 500},
+		{dpi: 300, width: 2000, height: 1000},
+	} {
+		data, err := rasterRenderer{}.Render(pdf.Bytes(), tt.dpi)
+		if err != nil {
+			t.Fatalf("Render(%d) error = %v", tt.dpi, err)
+		}
+		config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Render(%d) did not return a JPEG: %v", tt.dpi, err)
+		}
+		if config.Width != tt.width || config.Height != tt.height {
+			t.Errorf("Render(%d) = %dx%d, want %dx%d", tt.dpi, config.Width, config.Height, tt.width, tt.height)
+		}
+	}
+}
+
+func TestRasterRendererWithoutImages(t *testing.T) {
+	if _, err := (rasterRenderer{}).Render(testsupport.FixturePDF(1), 72); !errors.Is(err, errNoRasterContent) {
+		t.Errorf("Render() error = %v, want errNoRasterContent", err)
+	}
+}
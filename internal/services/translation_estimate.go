@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// errDryRunUnavailable is returned for a dry run by a translator or cleaner without token
+// counters, as the local flow runs them.
+var errDryRunUnavailable = errors.New("dry runs need the Vertex AI token counters")
+
+// estimate answers a dry-run request: it checks the request and its page as a translation
+// would, counts the input tokens of the model call that would translate the page, and
+// returns their estimated cost. The model is not called, nothing is written and the page
+// records are left as they are. The page is estimated as if translated afresh, whatever
+// output or cached translation might be reused, and a page to be redacted as if sent
+// itself rather than its redacted text.
+func (f *TranslatorFunction) estimate(ctx context.Context, logCtx *slog.Logger, req *models.PageTranslatorRequest) (*models.PageTranslatorResponse, error) {
+	logCtx = logCtx.With("dryRun", true)
+	if err := f.validateRequest(logCtx, req); err != nil {
+		return nil, err
+	}
+	inputAttrs, err := f.inputAttrs(ctx, req.GCSUri)
+	if err != nil {
+		logCtx.Error("Failed to inspect page to estimate", "error", err, "gcsUri", req.GCSUri)
+		return nil, sourceError(err)
+	}
+	modelName := f.config.Models.TranslatorModelName
+	if f.config.exceedsInputLimit(inputAttrs.Size) {
+		logCtx.Info("Page is too large to send to the model; estimating no cost.", "inputBytes", inputAttrs.Size, "maxInputBytes", f.config.MaxInputBytes)
+		estimate := f.config.Estimate.estimate(modelName, 0)
+		return &models.PageTranslatorResponse{Status: statusDryRun, Estimate: &estimate}, nil
+	}
+
+	counters, region, err := f.profileCountersFor(ctx, logCtx, req)
+	if err != nil {
+		return nil, err
+	}
+	profile, _, promptText := f.promptFor(ctx, logCtx, req, f.profileModels)
+	if languagePrompt := translatorLanguagePrompt(req.SourceLanguage, req.TargetLanguage); languagePrompt != "" {
+		promptText += "\n\n" + languagePrompt
+	}
+	counter, ok := counters[profile]
+	if !ok {
+		logCtx.Error("Cannot estimate the translation", "error", errDryRunUnavailable)
+		return nil, models.WithCode(models.ErrorCodeInvalidRequest, errDryRunUnavailable)
+	}
+	filePart := genai.FileData{
+		MIMEType: "application/pdf",
+		FileURI:  req.GCSUri,
+	}
+	inputTokens, err := countTokens(ctx, counter, filePart, genai.Text(promptText))
+	if err != nil {
+		logCtx.Error("Failed to count the tokens of the page", "error", err)
+		return nil, err
+	}
+
+	estimate := f.config.Estimate.estimate(modelName, inputTokens)
+	logCtx.Info("Estimated translation cost.", "inputTokens", estimate.InputTokens, "outputTokensLow", estimate.OutputTokensLow, "outputTokensHigh", estimate.OutputTokensHigh)
+	return &models.PageTranslatorResponse{
+		Status:         statusDryRun,
+		Model:          modelName,
+		PromptProfile:  profile,
+		CustomPrompt:   req.CustomUserPrompt != "",
+		SourceLanguage: req.SourceLanguage,
+		TargetLanguage: req.TargetLanguage,
+		Region:         region,
+		Estimate:       &estimate,
+	}, nil
+}
+
+// profileCountersFor returns the translator token counters of the request's processing
+// region, as profileModelsFor returns its models, so that a dry run sends the page to no
+// other region than a translation would.
+func (f *TranslatorFunction) profileCountersFor(ctx context.Context, logCtx *slog.Logger, req *models.PageTranslatorRequest) (map[string]gcp.TokenCounter, string, error) {
+	if req.ProcessingRegion == "" || req.ProcessingRegion == f.config.VertexAIRegion {
+		return f.profileCounters, f.config.VertexAIRegion, nil
+	}
+	if f.vertexClient == nil {
+		err := &RegionUnavailableError{Region: req.ProcessingRegion, Err: errors.New("no Vertex AI client to create it from")}
+		logCtx.Error("Cannot estimate in the page's processing region", "error", err)
+		return nil, "", models.WithCode(models.ErrorCodeLLMUnavailable, err)
+	}
+	client, err := f.vertexClient.ForRegion(ctx, req.ProcessingRegion)
+	if err != nil {
+		err := &RegionUnavailableError{Region: req.ProcessingRegion, Err: err}
+		logCtx.Error("Cannot estimate in the page's processing region", "error", err)
+		return nil, "", models.WithCode(models.ErrorCodeLLMUnavailable, err)
+	}
+	return client.TranslatorCounters(), req.ProcessingRegion, nil
+}
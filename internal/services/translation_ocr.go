@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// ocrQualityFlags are the quality flags of a page that Gemini read as a picture of text
+// rather than transcribing it, as it does with scans that have no text layer. Too dense
+// an output or an echo of the PDF's source is a fault of the model's output instead,
+// which OCR text would not fix.
+var ocrQualityFlags = map[string]bool{
+	QualityFlagEmpty:          true,
+	QualityFlagLowTextDensity: true,
+	QualityFlagImageHeavy:     true,
+	QualityFlagUnreadable:     true,
+}
+
+// needsOCR reports whether a translation flagged with flags should be made again from
+// OCR text. Truncation alone does not qualify: the model did read the page.
+func needsOCR(flags []string) bool {
+	for _, flag := range flags {
+		if ocrQualityFlags[flag] {
+			return true
+		}
+	}
+	return false
+}
+
+// translateWithOCR runs the page at gcsURI through Document AI OCR and asks the model to
+// structure the OCR text into markdown, with the page itself alongside for layout.
+// promptText is the prompt the page was first translated with. It returns the markdown,
+// the model's response, the number of model attempts made and whether the output ended
+// truncated. Any failure is returned for the caller to fall back to the first translation.
+func (f *TranslatorFunction) translateWithOCR(ctx context.Context, logCtx *slog.Logger, model gcp.ContentGenerator, gcsURI, promptText, pages string) (string, *genai.GenerateContentResponse, int, bool, error) {
+	data, err := f.downloadPage(ctx, gcsURI)
+	if err != nil {
+		return "", nil, 0, false, err
+	}
+	text, err := f.ocr.OCR(ctx, data)
+	if err != nil {
+		return "", nil, 0, false, err
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", nil, 0, false, fmt.Errorf("OCR found no text on %s", pages)
+	}
+	logCtx.Info("OCR read the page; asking the model to structure its text.", "ocrChars", len(text))
+
+	prompt := genai.Text(promptText + "\n\n" + fmt.Sprintf(gcp.TranslatorOCRPromptFormat, text))
+	filePart := genai.FileData{MIMEType: "application/pdf", FileURI: gcsURI}
+	resp, attempts, truncated, err := f.generateTranslation(ctx, logCtx, model, filePart, prompt, pages)
+	if err != nil {
+		return "", nil, attempts, false, err
+	}
+	markdown, err := extractMarkdown(logCtx, resp)
+	if err != nil {
+		return "", resp, attempts, false, fmt.Errorf("failed to translate %s: %w", pages, err)
+	}
+	if err := checkRefusal(markdown, pages); err != nil {
+		return "", resp, attempts, false, err
+	}
+	markdown = f.tidyOutput(logCtx, markdown)
+	return markdown, resp, attempts, truncated, nil
+}
+
+// downloadPage reads the page PDF at gcsURI for OCR. Pages above InlineMaxBytes are
+// refused, as they would be for an inline upload.
+func (f *TranslatorFunction) downloadPage(ctx context.Context, gcsURI string) ([]byte, error) {
+	bucket, object, err := gcp.ParseGCSURI(gcsURI)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := f.storageClient.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", gcsURI, err)
+	}
+	defer reader.Close()
+	if reader.Attrs.Size > f.config.InlineMaxBytes {
+		return nil, fmt.Errorf("page %s of %d bytes exceeds the %d byte OCR limit", gcsURI, reader.Attrs.Size, f.config.InlineMaxBytes)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", gcsURI, err)
+	}
+	return data, nil
+}
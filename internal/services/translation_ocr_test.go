@@ -0,0 +1,46 @@
+package services
+
+import "testing"
+
+func TestNeedsOCR(t *testing.T) {
+	// Every quality flag is routed one way or the other, so a new flag needs a decision.
+	routes := map[string]bool{
+		QualityFlagEmpty:           true,
+		QualityFlagLowTextDensity:  true,
+		QualityFlagImageHeavy:      true,
+		QualityFlagUnreadable:      true,
+		QualityFlagHighTextDensity: false,
+		QualityFlagSourceEcho:      false,
+		QualityFlagTruncated:       false,
+	}
+	for flag := range qualityPenalties {
+		want, ok := routes[flag]
+		if !ok {
+			t.Errorf("quality flag %q has no OCR route", flag)
+			continue
+		}
+		if got := needsOCR([]string{flag}); got != want {
+			t.Errorf("needsOCR([%s]) = %v, want %v", flag, got, want)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		flags []string
+		want  bool
+	}{
+		{name: "no flags"},
+		{name: "unknown flag", flags: []string{"shaky_scan"}},
+		{name: "truncated alone", flags: []string{QualityFlagTruncated}},
+		{name: "truncated and sparse", flags: []string{QualityFlagTruncated, QualityFlagLowTextDensity}, want: true},
+		{name: "dense and echoing", flags: []string{QualityFlagHighTextDensity, QualityFlagSourceEcho}},
+		{name: "empty and image heavy", flags: []string{QualityFlagEmpty, QualityFlagImageHeavy}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := needsOCR(tt.flags); got != tt.want {
+				t.Errorf("needsOCR(%v) = %v, want %v", tt.flags, got, tt.want)
+			}
+		})
+	}
+}
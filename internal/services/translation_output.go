@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/markdown"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// TranslationOutputConfig sets how the markdown the model returns for a page is tidied and
+// checked before it is stored.
+type TranslationOutputConfig struct {
+	// Chatter lists the phrases opening the lines the model writes before and after the
+	// markdown, which are removed.
+	Chatter markdown.Chatter
+	// MalformedRetries caps the times a page whose markdown fails validation is translated
+	// again, with a corrective instruction, before it fails with LLM_MALFORMED_OUTPUT.
+	MalformedRetries int
+}
+
+// loadTranslationOutputConfig reads TRANSLATOR_PREAMBLE_PHRASES,
+// TRANSLATOR_EPILOGUE_PHRASES and TRANSLATOR_MALFORMED_RETRIES.
+func loadTranslationOutputConfig() (TranslationOutputConfig, error) {
+	retries, err := strconv.Atoi(gcp.GetEnv("TRANSLATOR_MALFORMED_RETRIES", "1"))
+	if err != nil || retries < 0 {
+		return TranslationOutputConfig{}, fmt.Errorf("TRANSLATOR_MALFORMED_RETRIES must be a non-negative integer")
+	}
+	return TranslationOutputConfig{
+		Chatter: markdown.Chatter{
+			Preambles: loadPhrases("TRANSLATOR_PREAMBLE_PHRASES", markdown.DefaultPreambles),
+			Epilogues: loadPhrases("TRANSLATOR_EPILOGUE_PHRASES", markdown.DefaultEpilogues),
+		},
+		MalformedRetries: retries,
+	}, nil
+}
+
+// loadPhrases reads a "|"-separated list of phrases from key, which replaces the defaults
+// when set. Phrases may hold commas, as in "Sure, here is".
+func loadPhrases(key string, defaults []string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return defaults
+	}
+	var phrases []string
+	for _, phrase := range strings.Split(value, "|") {
+		if phrase = strings.ToLower(strings.TrimSpace(phrase)); phrase != "" {
+			phrases = append(phrases, phrase)
+		}
+	}
+	return phrases
+}
+
+// tidyOutput removes what the model wrote around a page's markdown, as
+// markdown.PostprocessOutput does, and logs what it removed.
+func (f *TranslatorFunction) tidyOutput(logCtx *slog.Logger, md string) string {
+	tidied, fixes := markdown.PostprocessOutput(md, f.config.Output.Chatter)
+	if fixes.Changed() {
+		logCtx.Info("Tidied the model's output.", "preambles", fixes.Preambles, "epilogues", fixes.Epilogues, "unwrapped", fixes.Unwrapped)
+	}
+	return tidied
+}
+
+// generatedMarkdown is the validated markdown of a page and what it took to get it.
+type generatedMarkdown struct {
+	Markdown  string
+	Attempts  int
+	Truncated bool
+	Usage     *models.TokenUsage // Of every response, including those rejected.
+}
+
+// generateMarkdown translates the pages described by pages by calling generate with
+// promptText, and returns the tidied markdown of the response. Markdown that fails
+// validation is asked for again, with the reason and a corrective instruction appended
+// to promptText, up to MalformedRetries times before failing with LLM_MALFORMED_OUTPUT.
+// Output still truncated at the token limit is not validated, as its last code block is
+// expected to be cut short; it is flagged by the quality check instead. The token usage
+// of each response is recorded on the document as it comes in, since it is billed either
+// way.
+func (f *TranslatorFunction) generateMarkdown(ctx context.Context, logCtx *slog.Logger, docID, promptText, pages string, generate func(prompt string) (*genai.GenerateContentResponse, int, bool, error)) (*generatedMarkdown, error) {
+	var out generatedMarkdown
+	prompt := promptText
+	for try := 0; ; try++ {
+		resp, attempts, truncated, err := generate(prompt)
+		out.Attempts += attempts
+		if err != nil {
+			return nil, err
+		}
+		usage := tokenUsageFrom(resp)
+		if err := recordTokenUsage(ctx, f.firestoreClient, f.config.CollectionName, docID, models.TokenUsageStageTranslator, usage); err != nil {
+			logCtx.Warn("Failed to record token usage", "error", err)
+		}
+		out.Usage = addTokenUsage(out.Usage, usage)
+
+		md, err := extractMarkdown(logCtx, resp)
+		if err != nil {
+			logCtx.Warn("Gemini response was blocked", "error", err)
+			return nil, modelError(fmt.Errorf("failed to translate %s: %w", pages, err))
+		}
+		// Sanity check for LLM refusal.
+		if err := checkRefusal(md, pages); err != nil {
+			logCtx.Error("LLM refusal detected", "error", err, "response", md)
+			return nil, modelError(err) // This will fail the step in the workflow.
+		}
+		out.Markdown, out.Truncated = f.tidyOutput(logCtx, md), truncated
+		if truncated {
+			return &out, nil
+		}
+		err = markdown.ValidateOutput(out.Markdown)
+		if err == nil {
+			return &out, nil
+		}
+		if try >= f.config.Output.MalformedRetries {
+			logCtx.Error("Translation is malformed; giving up.", "error", err, "tries", try+1)
+			return nil, models.WithCode(models.ErrorCodeLLMMalformed, fmt.Errorf("translation of %s is malformed after %d tries: %w", pages, try+1, err))
+		}
+		logCtx.Warn("Translation is malformed; asking for it again.", "error", err, "try", try+1)
+		prompt = promptText + "\n\n" + fmt.Sprintf(gcp.TranslatorMalformedOutputPromptFormat, err)
+	}
+}
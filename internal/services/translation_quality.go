@@ -0,0 +1,128 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// Quality flags raised on a translated page. Each lowers the page's confidence by its
+// qualityPenalties entry.
+const (
+	QualityFlagEmpty           = "empty_output"
+	QualityFlagLowTextDensity  = "low_text_density"
+	QualityFlagHighTextDensity = "high_text_density"
+	QualityFlagImageHeavy      = "image_heavy"
+	QualityFlagUnreadable      = "unreadable_content"
+	QualityFlagSourceEcho      = "source_echo"
+	QualityFlagTruncated       = "truncated_output"
+)
+
+var qualityPenalties = map[string]float64{
+	QualityFlagEmpty:           0.6,
+	QualityFlagLowTextDensity:  0.3,
+	QualityFlagHighTextDensity: 0.3,
+	QualityFlagImageHeavy:      0.2,
+	QualityFlagUnreadable:      0.4,
+	QualityFlagSourceEcho:      0.6,
+	QualityFlagTruncated:       0.4,
+}
+
+var (
+	// imageLineRegex matches lines that stand in for an image rather than carry text,
+	// such as "![...]" links or "Image: ..." descriptions.
+	imageLineRegex = regexp.MustCompile(`(?i)^\s*(?:!\[|\[?\*{0,2}(?:image|figure|diagram|photo|picture)(?: description)?\*{0,2}\s*[:\]])`)
+	// unreadableRegex matches what the model writes when it cannot make out content.
+	unreadableRegex = regexp.MustCompile(`(?i)\b(?:illegible|unreadable|not legible|unable to read|cannot be read|can't read|cannot read)\b`)
+	// sourceEchoRegex matches raw PDF syntax, which the model copies out instead of
+	// translating when it reads the page as text.
+	sourceEchoRegex = regexp.MustCompile(`(?m)^%PDF-\d|\bendobj\b|\bendstream\b|/Type\s*/Page\b`)
+)
+
+// TranslationQualityConfig holds the thresholds of the translation quality heuristics.
+type TranslationQualityConfig struct {
+	// MinCharsPerKB is the least markdown expected per KB of page PDF. Scans of handwriting
+	// or rotated pages tend to come back much shorter than their size suggests.
+	MinCharsPerKB float64
+	// MaxCharsPerKB is the most markdown expected per KB of page PDF, or 0 for no limit.
+	// Far longer output is usually the model repeating itself.
+	MaxCharsPerKB float64
+	// MaxImageLineFraction is the largest share of non-empty lines that may be image
+	// descriptions before the page is flagged as mostly images.
+	MaxImageLineFraction float64
+}
+
+// loadTranslationQualityConfig reads TRANSLATOR_QUALITY_MIN_CHARS_PER_KB,
+// TRANSLATOR_QUALITY_MAX_CHARS_PER_KB and TRANSLATOR_QUALITY_MAX_IMAGE_LINE_FRACTION.
+func loadTranslationQualityConfig() (TranslationQualityConfig, error) {
+	minCharsPerKB, err := strconv.ParseFloat(gcp.GetEnv("TRANSLATOR_QUALITY_MIN_CHARS_PER_KB", "5"), 64)
+	if err != nil || minCharsPerKB < 0 {
+		return TranslationQualityConfig{}, fmt.Errorf("TRANSLATOR_QUALITY_MIN_CHARS_PER_KB must be a non-negative number")
+	}
+	maxCharsPerKB, err := strconv.ParseFloat(gcp.GetEnv("TRANSLATOR_QUALITY_MAX_CHARS_PER_KB", "2000"), 64)
+	if err != nil || maxCharsPerKB < 0 || (maxCharsPerKB > 0 && maxCharsPerKB < minCharsPerKB) {
+		return TranslationQualityConfig{}, fmt.Errorf("TRANSLATOR_QUALITY_MAX_CHARS_PER_KB must be 0 or a number no less than TRANSLATOR_QUALITY_MIN_CHARS_PER_KB")
+	}
+	maxImageLineFraction, err := strconv.ParseFloat(gcp.GetEnv("TRANSLATOR_QUALITY_MAX_IMAGE_LINE_FRACTION", "0.5"), 64)
+	if err != nil || maxImageLineFraction < 0 || maxImageLineFraction > 1 {
+		return TranslationQualityConfig{}, fmt.Errorf("TRANSLATOR_QUALITY_MAX_IMAGE_LINE_FRACTION must be a number between 0 and 1")
+	}
+	return TranslationQualityConfig{MinCharsPerKB: minCharsPerKB, MaxCharsPerKB: maxCharsPerKB, MaxImageLineFraction: maxImageLineFraction}, nil
+}
+
+// assessTranslation scores a page's markdown between 0 and 1 and returns the flags that
+// lowered the score. inputBytes is the size of the page PDF, or a negative number when it
+// is unknown, in which case the density checks are skipped. truncated reports that the
+// model's output still ended at its token limit.
+func assessTranslation(markdown string, inputBytes int64, truncated bool, cfg TranslationQualityConfig) (float64, []string) {
+	var flags []string
+	if truncated {
+		flags = append(flags, QualityFlagTruncated)
+	}
+	trimmed := strings.TrimSpace(markdown)
+	if trimmed == "" {
+		flags = append(flags, QualityFlagEmpty)
+	} else {
+		if inputBytes > 0 {
+			kb := float64(inputBytes) / 1024
+			if float64(len(trimmed)) < cfg.MinCharsPerKB*kb {
+				flags = append(flags, QualityFlagLowTextDensity)
+			}
+			if cfg.MaxCharsPerKB > 0 && float64(len(trimmed)) > cfg.MaxCharsPerKB*kb {
+				flags = append(flags, QualityFlagHighTextDensity)
+			}
+		}
+		lines, imageLines := 0, 0
+		for _, line := range strings.Split(trimmed, "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			lines++
+			if imageLineRegex.MatchString(line) {
+				imageLines++
+			}
+		}
+		if lines > 0 && float64(imageLines)/float64(lines) > cfg.MaxImageLineFraction {
+			flags = append(flags, QualityFlagImageHeavy)
+		}
+		if unreadableRegex.MatchString(trimmed) {
+			flags = append(flags, QualityFlagUnreadable)
+		}
+		if sourceEchoRegex.MatchString(trimmed) {
+			flags = append(flags, QualityFlagSourceEcho)
+		}
+	}
+
+	confidence := 1.0
+	for _, flag := range flags {
+		confidence -= qualityPenalties[flag]
+	}
+	if confidence < 0 {
+		confidence = 0
+	}
+	return confidence, flags
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAssessTranslation(t *testing.T) {
+	cfg := TranslationQualityConfig{MinCharsPerKB: 5, MaxCharsPerKB: 2000, MaxImageLineFraction: 0.5}
+	text := strings.Repeat("x", 100)
+	tests := []struct {
+		name           string
+		markdown       string
+		inputBytes     int64
+		truncated      bool
+		cfg            TranslationQualityConfig
+		wantConfidence float64
+		wantFlags      []string
+	}{
+		{name: "good page", markdown: "# Pump\n\nThe impeller clearance is 0.35 mm.", inputBytes: 1024, cfg: cfg, wantConfidence: 1},
+		{name: "empty output", markdown: "", inputBytes: 1024, cfg: cfg, wantConfidence: 0.4, wantFlags: []string{QualityFlagEmpty}},
+		{name: "whitespace only", markdown: " \n\n\t", inputBytes: 1024, cfg: cfg, wantConfidence: 0.4, wantFlags: []string{QualityFlagEmpty}},
+		{name: "empty and truncated", markdown: "", truncated: true, cfg: cfg, wantConfidence: 0, wantFlags: []string{QualityFlagTruncated, QualityFlagEmpty}},
+		{name: "truncated", markdown: text, cfg: cfg, truncated: true, wantConfidence: 0.6, wantFlags: []string{QualityFlagTruncated}},
+		// 100 chars is exactly 5 per KB of a 20 KB page.
+		{name: "density at the minimum", markdown: text, inputBytes: 20 * 1024, cfg: cfg, wantConfidence: 1},
+		{name: "density under the minimum", markdown: text, inputBytes: 20*1024 + 1, cfg: cfg, wantConfidence: 0.7, wantFlags: []string{QualityFlagLowTextDensity}},
+		// 2000 chars is exactly 2000 per KB of a 1 KB page.
+		{name: "density at the maximum", markdown: strings.Repeat("x", 2000), inputBytes: 1024, cfg: cfg, wantConfidence: 1},
+		{name: "density over the maximum", markdown: strings.Repeat("x", 2001), inputBytes: 1024, cfg: cfg, wantConfidence: 0.7, wantFlags: []string{QualityFlagHighTextDensity}},
+		{name: "no maximum", markdown: strings.Repeat("x", 100000), inputBytes: 1024, cfg: TranslationQualityConfig{MinCharsPerKB: 5, MaxImageLineFraction: 0.5}, wantConfidence: 1},
+		{name: "unknown page size", markdown: "x", inputBytes: -1, cfg: cfg, wantConfidence: 1},
+		{name: "image lines at the fraction", markdown: "![pump](images/pump.png)\nThe impeller clearance is 0.35 mm.", cfg: cfg, wantConfidence: 1},
+		{name: "image lines over the fraction", markdown: "![pump](images/pump.png)\n\n**Figure:** exploded view\nThe impeller clearance is 0.35 mm.", cfg: cfg, wantConfidence: 0.8, wantFlags: []string{QualityFlagImageHeavy}},
+		{name: "unreadable", markdown: "The serial number is illegible.", cfg: cfg, wantConfidence: 0.6, wantFlags: []string{QualityFlagUnreadable}},
+		{name: "echoed PDF source", markdown: "%PDF-1.7\n1 0 obj\n<< /Type /Page /Contents 2 0 R >>\nendobj", cfg: cfg, wantConfidence: 0.4, wantFlags: []string{QualityFlagSourceEcho}},
+		{name: "echoed content stream", markdown: "BT /F1 12 Tf (Pump) Tj ET\nendstream", cfg: cfg, wantConfidence: 0.4, wantFlags: []string{QualityFlagSourceEcho}},
+		{name: "PDF mentioned in prose", markdown: "See the PDF-1 appendix; the stream ends at the weir.", cfg: cfg, wantConfidence: 1},
+		{
+			name:           "confidence clamped at zero",
+			markdown:       "![scan](images/scan.png)\nillegible\nendobj",
+			inputBytes:     1024 * 1024,
+			truncated:      true,
+			cfg:            cfg,
+			wantConfidence: 0,
+			wantFlags:      []string{QualityFlagTruncated, QualityFlagLowTextDensity, QualityFlagUnreadable, QualityFlagSourceEcho},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			confidence, flags := assessTranslation(tt.markdown, tt.inputBytes, tt.truncated, tt.cfg)
+			if !slices.Equal(flags, tt.wantFlags) {
+				t.Errorf("assessTranslation() flags = %v, want %v", flags, tt.wantFlags)
+			}
+			if diff := confidence - tt.wantConfidence; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("assessTranslation() confidence = %v, want %v", confidence, tt.wantConfidence)
+			}
+		})
+	}
+}
+
+func TestLoadTranslationQualityConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    TranslationQualityConfig
+		wantErr bool
+	}{
+		{name: "defaults", want: TranslationQualityConfig{MinCharsPerKB: 5, MaxCharsPerKB: 2000, MaxImageLineFraction: 0.5}},
+		{
+			name: "set",
+			env:  map[string]string{"TRANSLATOR_QUALITY_MIN_CHARS_PER_KB": "10", "TRANSLATOR_QUALITY_MAX_CHARS_PER_KB": "0", "TRANSLATOR_QUALITY_MAX_IMAGE_LINE_FRACTION": "1"},
+			want: TranslationQualityConfig{MinCharsPerKB: 10, MaxImageLineFraction: 1},
+		},
+		{name: "negative minimum", env: map[string]string{"TRANSLATOR_QUALITY_MIN_CHARS_PER_KB": "-1"}, wantErr: true},
+		{name: "negative maximum", env: map[string]string{"TRANSLATOR_QUALITY_MAX_CHARS_PER_KB": "-1"}, wantErr: true},
+		{name: "maximum under the minimum", env: map[string]string{"TRANSLATOR_QUALITY_MIN_CHARS_PER_KB": "50", "TRANSLATOR_QUALITY_MAX_CHARS_PER_KB": "40"}, wantErr: true},
+		{name: "fraction over 1", env: map[string]string{"TRANSLATOR_QUALITY_MAX_IMAGE_LINE_FRACTION": "1.5"}, wantErr: true},
+		{name: "not a number", env: map[string]string{"TRANSLATOR_QUALITY_MAX_CHARS_PER_KB": "lots"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"TRANSLATOR_QUALITY_MIN_CHARS_PER_KB", "TRANSLATOR_QUALITY_MAX_CHARS_PER_KB", "TRANSLATOR_QUALITY_MAX_IMAGE_LINE_FRACTION"} {
+				t.Setenv(key, "")
+				os.Unsetenv(key)
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			got, err := loadTranslationQualityConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadTranslationQualityConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("loadTranslationQualityConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
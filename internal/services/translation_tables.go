@@ -0,0 +1,194 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// tablesPrefix returns the prefix under which the CSV files of the tables of the document
+// under root are written in the translated markdown bucket.
+func tablesPrefix(root string) string {
+	return root + "/tables/"
+}
+
+// pageTablesPrefix returns the prefix of the CSV files of the tables on pages startPage
+// to endPage, "{docId}/tables/{page}_" for a single page of a document without a tenant.
+func pageTablesPrefix(root string, startPage, endPage int) string {
+	label := strconv.Itoa(startPage)
+	if endPage != startPage {
+		label = fmt.Sprintf("%d-%d", startPage, endPage)
+	}
+	return tablesPrefix(root) + label + "_"
+}
+
+// extractedTable is one table of the model's table extraction output.
+type extractedTable struct {
+	Title   string        `json:"title"`
+	Headers []tableCell   `json:"headers"`
+	Rows    [][]tableCell `json:"rows"`
+}
+
+// tableCell is the text of a table cell. The model sometimes writes numeric cells as JSON
+// numbers, so numbers and booleans are kept as written and null is an empty cell.
+type tableCell string
+
+func (c *tableCell) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = tableCell(text)
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case nil:
+		*c = ""
+	case json.Number:
+		*c = tableCell(value.String())
+	case bool:
+		*c = tableCell(strconv.FormatBool(value))
+	default:
+		return fmt.Errorf("cell must be a string, number, boolean or null, got %s", data)
+	}
+	return nil
+}
+
+// validate checks that the table has headers and that every row has one cell per header.
+func (t extractedTable) validate() error {
+	if len(t.Headers) == 0 {
+		return errors.New("table has no headers")
+	}
+	for i, row := range t.Rows {
+		if len(row) != len(t.Headers) {
+			return fmt.Errorf("row %d has %d cells for %d headers", i+1, len(row), len(t.Headers))
+		}
+	}
+	return nil
+}
+
+// csv returns the table as CSV with the headers as the first record. encoding/csv quotes
+// cells holding commas, quotes or line breaks.
+func (t extractedTable) csv() (string, error) {
+	var buf strings.Builder
+	writer := csv.NewWriter(&buf)
+	records := make([][]string, 0, len(t.Rows)+1)
+	for _, row := range append([][]tableCell{t.Headers}, t.Rows...) {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			record[i] = string(cell)
+		}
+		records = append(records, record)
+	}
+	if err := writer.WriteAll(records); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// parseTables parses the model's table extraction output, a JSON array of tables that may
+// be wrapped in a code fence. Tables that are malformed are left out and described in the
+// returned problems; output that is not a JSON array at all is an error.
+func parseTables(text string) ([]extractedTable, []string, error) {
+	text = strings.TrimSpace(text)
+	text = strings.TrimPrefix(text, "```json")
+	text = strings.TrimPrefix(text, "```")
+	text = strings.TrimSuffix(text, "```")
+	var items []json.RawMessage
+	if err := json.Unmarshal([]byte(strings.TrimSpace(text)), &items); err != nil {
+		return nil, nil, fmt.Errorf("table output is not a JSON array: %w", err)
+	}
+	var tables []extractedTable
+	var problems []string
+	for i, item := range items {
+		var table extractedTable
+		err := json.Unmarshal(item, &table)
+		if err == nil {
+			err = table.validate()
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("table %d: %v", i+1, err))
+			continue
+		}
+		tables = append(tables, table)
+	}
+	return tables, problems, nil
+}
+
+// tableExtraction is the outcome of extracting the tables of a page.
+type tableExtraction struct {
+	URIs     []string
+	Skipped  int
+	Usage    *models.TokenUsage
+	Attempts int
+}
+
+// extractTables asks model for the tables on the request's pages and writes each as a CSV
+// file under pageTablesPrefix, replacing the files of an earlier translation. Tables are a
+// by-product of the page, so failures are logged and never fail its translation.
+func (f *TranslatorFunction) extractTables(ctx context.Context, logCtx *slog.Logger, model gcp.ContentGenerator, req *models.PageTranslatorRequest) tableExtraction {
+	startPage, endPage := req.Pages()
+	var result tableExtraction
+	filePart := genai.FileData{MIMEType: "application/pdf", FileURI: req.GCSUri}
+	resp, attempts, truncated, err := f.generateTranslation(ctx, logCtx, model, filePart, genai.Text(gcp.TranslatorTablesPrompt), describePages(startPage, endPage))
+	result.Attempts = attempts
+	if err != nil {
+		logCtx.Warn("Table extraction failed; the page is kept without tables.", "error", err)
+		return result
+	}
+	result.Usage = tokenUsageFrom(resp)
+	if err := recordTokenUsage(ctx, f.firestoreClient, f.config.CollectionName, req.DocumentID, models.TokenUsageStageTranslator, result.Usage); err != nil {
+		logCtx.Warn("Failed to record token usage", "error", err)
+	}
+	if truncated {
+		logCtx.Warn("Table extraction output was cut off at the token limit; the page is kept without tables.")
+		return result
+	}
+	tables, problems, err := parseTables(responseText(resp))
+	if err != nil {
+		logCtx.Warn("Table extraction output is malformed; the page is kept without tables.", "error", err)
+		return result
+	}
+	if len(problems) > 0 {
+		logCtx.Warn("Skipping malformed tables.", "problems", problems)
+		result.Skipped = len(problems)
+	}
+
+	bucket := objectstore.WrapBucket(f.storageClient.Bucket(f.config.MarkdownBucket))
+	prefix := pageTablesPrefix(documentRoot(req.TenantID, req.DocumentID), startPage, endPage)
+	if _, err := deletePrefix(ctx, logCtx, bucket, prefix); err != nil {
+		logCtx.Warn("Failed to remove tables of an earlier translation; the page is kept without tables.", "error", err)
+		return result
+	}
+	for i, table := range tables {
+		objectName := fmt.Sprintf("%s%d.csv", prefix, i+1)
+		content, err := table.csv()
+		if err == nil {
+			err = gcp.SaveToGCSAtomically(ctx, logCtx, bucket, objectName, content)
+		}
+		if err != nil {
+			logCtx.Warn("Failed to write table", "error", err, "gcsObject", objectName)
+			result.Skipped++
+			continue
+		}
+		result.URIs = append(result.URIs, fmt.Sprintf("gs://%s/%s", f.config.MarkdownBucket, objectName))
+	}
+	logCtx.Info("Tables extracted.", "tables", len(result.URIs), "skipped", result.Skipped)
+	return result
+}
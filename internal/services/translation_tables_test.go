@@ -0,0 +1,107 @@
+package services
+
+import (
+	"encoding/csv"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTables(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		want         []extractedTable
+		wantProblems int
+		wantErr      bool
+	}{
+		{name: "no tables", text: "[]"},
+		{
+			name: "fenced",
+			text: "```json\n[{\"title\": \"Ratings\", \"headers\": [\"Pump\", \"Flow\"], \"rows\": [[\"P-1\", \"10\"]]}]\n```",
+			want: []extractedTable{{Title: "Ratings", Headers: []tableCell{"Pump", "Flow"}, Rows: [][]tableCell{{"P-1", "10"}}}},
+		},
+		{
+			name: "numbers, booleans and null",
+			text: `[{"headers": ["Pump", "Flow", "Spare", "Note"], "rows": [["P-1", 10.50, true, null]]}]`,
+			want: []extractedTable{{Headers: []tableCell{"Pump", "Flow", "Spare", "Note"}, Rows: [][]tableCell{{"P-1", "10.50", "true", ""}}}},
+		},
+		{
+			name: "malformed tables are skipped",
+			text: `[
+				{"headers": ["A"], "rows": [["1"]]},
+				{"headers": [], "rows": [["1"]]},
+				{"headers": ["A", "B"], "rows": [["1"]]},
+				{"headers": ["A"], "rows": [[{"nested": true}]]},
+				"not a table"
+			]`,
+			want:         []extractedTable{{Headers: []tableCell{"A"}, Rows: [][]tableCell{{"1"}}}},
+			wantProblems: 4,
+		},
+		{name: "not an array", text: `{"headers": ["A"]}`, wantErr: true},
+		{name: "cut off", text: `[{"headers": ["A"], "rows": [["1"`, wantErr: true},
+		{name: "prose", text: "There are no tables on this page.", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, problems, err := parseTables(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTables() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTables() = %+v, want %+v", got, tt.want)
+			}
+			if len(problems) != tt.wantProblems {
+				t.Errorf("parseTables() problems = %q, want %d", problems, tt.wantProblems)
+			}
+		})
+	}
+}
+
+func TestExtractedTableCSV(t *testing.T) {
+	table := extractedTable{
+		Headers: []tableCell{"Pump, model", `Head "H"`, "Notes"},
+		Rows: [][]tableCell{
+			{"P-1", "10", "line one\nline two"},
+			{`"quoted"`, "", "trailing comma,"},
+			{"Größe", "½\"", " leading space"},
+		},
+	}
+	content, err := table.csv()
+	if err != nil {
+		t.Fatalf("csv() error = %v", err)
+	}
+	records, err := csv.NewReader(strings.NewReader(content)).ReadAll()
+	if err != nil {
+		t.Fatalf("csv() wrote unreadable CSV: %v\n%s", err, content)
+	}
+	want := [][]string{
+		{"Pump, model", `Head "H"`, "Notes"},
+		{"P-1", "10", "line one\nline two"},
+		{`"quoted"`, "", "trailing comma,"},
+		{"Größe", "½\"", " leading space"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("csv() read back as %q, want %q\n%s", records, want, content)
+	}
+	if !strings.HasPrefix(content, `"Pump, model","Head ""H""",Notes`+"\n") {
+		t.Errorf("csv() header record = %q", strings.SplitN(content, "\n", 2)[0])
+	}
+}
+
+func TestPageTablesPrefix(t *testing.T) {
+	tests := []struct {
+		root               string
+		startPage, endPage int
+		want               string
+	}{
+		{root: "doc-1", startPage: 7, endPage: 7, want: "doc-1/tables/7_"},
+		{root: "doc-1", startPage: 11, endPage: 15, want: "doc-1/tables/11-15_"},
+		{root: "acme/doc-1", startPage: 1, endPage: 1, want: "acme/doc-1/tables/1_"},
+	}
+	for _, tt := range tests {
+		if got := pageTablesPrefix(tt.root, tt.startPage, tt.endPage); got != tt.want {
+			t.Errorf("pageTablesPrefix(%q, %d, %d) = %q, want %q", tt.root, tt.startPage, tt.endPage, got, tt.want)
+		}
+	}
+}
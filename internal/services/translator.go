@@ -1,15 +1,25 @@
 package services
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/storage"
 	"cloud.google.com/go/vertexai/genai"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
 	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 		_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
 )
 
@@ -18,13 +28,66 @@ type TranslatorConfig struct {
 	ProjectID      string
 	VertexAIRegion string
 	MarkdownBucket string
+	CollectionName string
+	PagesBucket    string        // Source of split pages; requests may name no other bucket.
+	ImagesBucket   string        // Optional. Source of extracted page images.
+	ImageLinkMode  string        // "relative" or "signed".
+	ImageURLTTL    time.Duration // Lifetime of signed image URLs.
+	MaxInputBytes  int64         // Pages larger than this are not sent to the model.
+	// TenantCollection holds the tenant configs; a tenant whose config fails validation
+	// has its pages refused.
+	TenantCollection string
+	// InlineMaxBytes caps the size of a page that is downloaded and sent inline when
+	// Vertex AI is denied access to its GCS URI.
+	InlineMaxBytes int64
+	// MaxContinuations caps the follow-up calls made when a response stops at the output
+	// token limit.
+	MaxContinuations int
+	Retry            GeminiRetryConfig
+	Quality          TranslationQualityConfig
+	Output           TranslationOutputConfig
+	Models           gcp.VertexModelConfig
+	OutputNames      *OutputNames
+	// OCRProcessor is the Document AI processor that pages read as images are sent to,
+	// as a full resource name. Empty disables the OCR fallback.
+	OCRProcessor string
+	// DLPInfoTypes are the Cloud DLP info types redacted from pages with a redactionMode
+	// of "dlp". Empty disables redaction, and such pages fail.
+	DLPInfoTypes []string
+	// ExtractTables writes the tables of every page as CSV files next to the markdown.
+	// Requests can also ask for it page by page.
+	ExtractTables bool
+	// ExtractImages references the images of every single-page request in its markdown,
+	// extracting them from the page PDF into ImagesBucket when the splitter has not.
+	ExtractImages bool
+	// Cache reuses the translations of identical pages across documents.
+	Cache TranslationCacheConfig
+	// CancelCheckTTL is how long a document is known not to be cancelled before it is
+	// read again.
+	CancelCheckTTL time.Duration
+	// Estimate sets how dry runs estimate the cost of translating pages.
+	Estimate CostEstimateConfig
+	// Degrade sets how pages over MaxInputBytes are re-rendered to fit it.
+	Degrade OversizeDegradeConfig
 }
 
 // TranslatorFunction holds the dependencies for the translation logic.
 type TranslatorFunction struct {
-	storageClient *storage.Client
-	vertexClient  *gcp.VertexClient
-	config        TranslatorConfig
+	serviceClients
+	storageClient   *storage.Client
+	firestoreClient *firestore.Client
+	profileModels   map[string]gcp.ContentGenerator // Keyed by prompt profile.
+	profileCounters map[string]gcp.TokenCounter     // As profileModels, for dry runs.
+	vertexClient    *gcp.VertexClient               // Creates the clients of other processing regions.
+	ocr             gcp.OCRClient                   // Nil unless DOC_AI_PROCESSOR is set.
+	redactor        gcp.Redactor                    // Nil unless DLP_INFO_TYPES is set.
+	renderer        pageRenderer                    // Re-renders pages over the input limit.
+	profiles        *ProfileResolver
+	cancellation    *CancellationChecker
+	tenants         *DocumentTenants
+	tenantConfigs   *TenantStore
+	reporter        *stageReporter // Nil unless ORCHESTRATION_MODE is pubsub.
+	config          TranslatorConfig
 }
 
 // loadConfig loads and validates all necessary environment variables for this service.
@@ -37,11 +100,110 @@ func loadConfig() (*TranslatorConfig, error) {
 	if markdownBucket == "" {
 		return nil, fmt.Errorf("TRANSLATED_MARKDOWN_BUCKET environment variable must be set")
 	}
+	pagesBucket := gcp.GetEnv("SPLIT_PAGES_BUCKET", "")
+	if pagesBucket == "" {
+		return nil, fmt.Errorf("SPLIT_PAGES_BUCKET environment variable must be set")
+	}
+
+	imageLinkMode := gcp.GetEnv("IMAGE_LINK_MODE", ImageLinkModeRelative)
+	if imageLinkMode != ImageLinkModeRelative && imageLinkMode != ImageLinkModeSigned {
+		return nil, fmt.Errorf("IMAGE_LINK_MODE must be %q or %q, got %q", ImageLinkModeRelative, ImageLinkModeSigned, imageLinkMode)
+	}
+	imageURLTTL, err := time.ParseDuration(gcp.GetEnv("IMAGE_URL_TTL", "168h"))
+	if err != nil {
+		return nil, fmt.Errorf("IMAGE_URL_TTL must be a valid duration: %w", err)
+	}
+
+	maxInputBytes, err := strconv.ParseInt(gcp.GetEnv("TRANSLATOR_MAX_INPUT_BYTES", "52428800"), 10, 64)
+	if err != nil || maxInputBytes <= 0 {
+		return nil, fmt.Errorf("TRANSLATOR_MAX_INPUT_BYTES must be a positive integer")
+	}
+
+	inlineMaxBytes, err := strconv.ParseInt(gcp.GetEnv("TRANSLATOR_INLINE_MAX_BYTES", "15728640"), 10, 64)
+	if err != nil || inlineMaxBytes < 0 {
+		return nil, fmt.Errorf("TRANSLATOR_INLINE_MAX_BYTES must be a non-negative integer")
+	}
+
+	retry, err := loadGeminiRetryConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	maxContinuations, err := loadMaxContinuations()
+	if err != nil {
+		return nil, err
+	}
+
+	quality, err := loadTranslationQualityConfig()
+	if err != nil {
+		return nil, err
+	}
+	output, err := loadTranslationOutputConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	outputNames, err := loadOutputNames()
+	if err != nil {
+		return nil, err
+	}
+
+	extractTables, err := strconv.ParseBool(gcp.GetEnv("EXTRACT_TABLES", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("EXTRACT_TABLES must be a boolean")
+	}
+	extractImages, err := strconv.ParseBool(gcp.GetEnv("EXTRACT_IMAGES", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("EXTRACT_IMAGES must be a boolean")
+	}
+	imagesBucket := gcp.GetEnv("IMAGES_BUCKET", "")
+	if extractImages && imagesBucket == "" {
+		return nil, fmt.Errorf("IMAGES_BUCKET environment variable must be set when EXTRACT_IMAGES is true")
+	}
+
+	cache, err := loadTranslationCacheConfig()
+	if err != nil {
+		return nil, err
+	}
+	cancelCheckTTL, err := loadCancelCheckTTL()
+	if err != nil {
+		return nil, err
+	}
+	estimate, err := loadCostEstimateConfig("TRANSLATOR_OUTPUT_TOKEN_RATIO", "0.5,2")
+	if err != nil {
+		return nil, err
+	}
+	degrade, err := loadOversizeDegradeConfig()
+	if err != nil {
+		return nil, err
+	}
 
 	return &TranslatorConfig{
-		ProjectID:      projectID,
-		VertexAIRegion: gcp.GetEnv("VERTEX_AI_REGION", "us-central1"),
-		MarkdownBucket: markdownBucket,
+		ProjectID:        projectID,
+		VertexAIRegion:   gcp.GetEnv("VERTEX_AI_REGION", "us-central1"),
+		MarkdownBucket:   markdownBucket,
+		CollectionName:   gcp.GetEnv("FIRESTORE_COLLECTION", "documents"),
+		TenantCollection: gcp.GetEnv("TENANT_COLLECTION", "tenants"),
+		PagesBucket:      pagesBucket,
+		ImagesBucket:     imagesBucket,
+		ImageLinkMode:    imageLinkMode,
+		ImageURLTTL:      imageURLTTL,
+		MaxInputBytes:    maxInputBytes,
+		InlineMaxBytes:   inlineMaxBytes,
+		MaxContinuations: maxContinuations,
+		Retry:            retry,
+		Quality:          quality,
+		Output:           output,
+		OutputNames:      outputNames,
+		OCRProcessor:     gcp.GetEnv("DOC_AI_PROCESSOR", ""),
+		DLPInfoTypes:     loadDLPInfoTypes(),
+		ExtractTables:    extractTables,
+		ExtractImages:    extractImages,
+		Cache:            cache,
+		Models:           gcp.LoadVertexModelConfig(),
+		CancelCheckTTL:   cancelCheckTTL,
+		Estimate:         estimate,
+		Degrade:          degrade,
 	}, nil
 }
 
@@ -57,85 +219,838 @@ func NewTranslator(ctx context.Context) (*TranslatorFunction, error) {
 		return nil, fmt.Errorf("failed to create storage client: %w", err)
 	}
 
-	vertexClient, err := gcp.NewVertexClient(ctx, config.ProjectID, config.VertexAIRegion)
+	if err := gcp.InitTracing(ctx, config.ProjectID); err != nil {
+		return nil, err
+	}
+	if err := gcp.InitMetrics(ctx, config.ProjectID); err != nil {
+		return nil, err
+	}
+
+	firestoreClient, err := gcp.NewFirestoreClient(ctx, config.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firestore client: %w", err)
+	}
+
+	vertexClient, err := gcp.NewVertexClient(ctx, config.ProjectID, config.VertexAIRegion, config.Models)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create vertex client: %w", err)
 	}
+	var ocr gcp.OCRClient
+	if config.OCRProcessor != "" {
+		ocr, err = gcp.NewDocumentAIClient(ctx, config.OCRProcessor)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var redactor gcp.Redactor
+	if len(config.DLPInfoTypes) > 0 {
+		redactor, err = gcp.NewDLPClient(ctx, config.ProjectID, config.DLPInfoTypes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	reporter, err := newStageReporter(ctx, config.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Translator initialized.", "model", config.Models.TranslatorModelName, "region", config.VertexAIRegion, "ocrProcessor", config.OCRProcessor, "dlpInfoTypes", config.DLPInfoTypes, "translationCache", config.Cache.Collection)
 
 	return &TranslatorFunction{
-		storageClient: storageClient,
-		vertexClient:  vertexClient,
-		config:        *config,
+		serviceClients:  serviceClients{storageClient, firestoreClient, vertexClient}.withReporter(reporter),
+		storageClient:   storageClient,
+		firestoreClient: firestoreClient,
+		profileModels:   vertexClient.TranslatorProfiles(),
+		profileCounters: vertexClient.TranslatorCounters(),
+		vertexClient:    vertexClient,
+		ocr:             ocr,
+		redactor:        redactor,
+		renderer:        rasterRenderer{},
+		profiles:        NewProfileResolver(firestoreClient, config.CollectionName, time.Minute),
+		cancellation:    NewCancellationChecker(firestoreClient, config.CollectionName, config.CancelCheckTTL),
+		tenants:         NewDocumentTenants(firestoreClient, config.CollectionName),
+		tenantConfigs:   NewTenantStore(firestoreClient, config.TenantCollection, tenantConfigTTL),
+		reporter:        reporter,
+		config:          *config,
 	}, nil
 }
 
-// Process handles the core logic of translating a single PDF page to Markdown.
-func (f *TranslatorFunction) Process(ctx context.Context, req *models.PageTranslatorRequest) (*models.PageTranslatorResponse, error) {
+// Process handles the core logic of translating a single PDF page to Markdown, and keeps
+// the page's Firestore record in step with the outcome.
+func (f *TranslatorFunction) Process(ctx context.Context, req *models.PageTranslatorRequest) (res *models.PageTranslatorResponse, err error) {
 	logCtx := slog.With(
 		"documentId", req.DocumentID,
 		"pageNumber", req.PageNumber,
 		"executionId", req.ExecutionID,
 	)
+	spanStart, spanEnd := req.Pages()
+	ctx, span := startProcessSpan(ctx, "translator.Process", req.Traceparent,
+		attrDocumentID.String(req.DocumentID),
+		attrPageNumber.Int(spanStart),
+		attrEndPage.Int(spanEnd),
+		attrGCSURI.String(req.GCSUri),
+	)
+	defer func() { endProcessSpan(ctx, span, err) }()
+	// Checked first, so that a request naming the wrong tenant leaves the document as is.
+	if err := f.tenants.Check(ctx, req.DocumentID, req.TenantID); err != nil {
+		logCtx.Error("Invalid translation request", "error", err, "tenantId", req.TenantID)
+		return nil, err
+	}
+	if _, err := f.tenantConfigs.Require(ctx, req.TenantID); err != nil {
+		logCtx.Error("Cannot translate for the document's tenant", "error", err, "tenantId", req.TenantID)
+		return nil, err
+	}
+	if req.DryRun {
+		return f.estimate(ctx, logCtx, req)
+	}
+	if err := checkExecution(ctx, logCtx, f.firestoreClient, f.config.CollectionName, req.DocumentID, req.ExecutionID); err != nil {
+		logCtx.Error("Refused translation request", "error", err)
+		return nil, err
+	}
+	timer := startStage(models.TokenUsageStageTranslator)
+	defer func() {
+		status := ""
+		if res != nil {
+			status = res.Status
+		}
+		timing := timer.timing(status, err)
+		if err != nil {
+			gcp.RecordPageTranslated(ctx, "error")
+		} else {
+			gcp.RecordPageTranslated(ctx, status)
+		}
+		if recErr := recordPageTiming(context.WithoutCancel(ctx), f.firestoreClient, f.config.CollectionName, req.DocumentID, models.TokenUsageStageTranslator, timing); recErr != nil {
+			logCtx.Warn("Failed to record page timing", "error", recErr)
+		}
+		if res != nil {
+			res.Timing = timing
+		}
+	}()
+	startPage, endPage := req.Pages()
+	if req.PageRange != nil {
+		logCtx = logCtx.With("startPage", startPage, "endPage", endPage)
+	}
+	if err := f.validateRequest(logCtx, req); err != nil {
+		return nil, err
+	}
+	if err := f.cancellation.Check(ctx, logCtx, req.DocumentID); err != nil {
+		recordCancelled(ctx, logCtx, f.firestoreClient, f.config.CollectionName, req.DocumentID, models.TokenUsageStageTranslator)
+		return nil, err
+	}
+	ctx = f.cancellation.WithCheck(ctx, logCtx, req.DocumentID)
 	logCtx.Info("Starting translation.")
 
-	model := f.vertexClient.TranslatorModel
-	prompt := genai.Text(gcp.TranslatorUserPrompt)
-	filePart := genai.FileData{
-		MIMEType: "application/pdf",
-		FileURI:  req.GCSUri,
+	if err := updatePageRangeRecords(ctx, f.firestoreClient, f.config.CollectionName, req.DocumentID, startPage, endPage, map[string]interface{}{
+		"status":       models.PageStatusTranslating,
+		"gcsUri":       req.GCSUri,
+		"attemptCount": firestore.Increment(1),
+	}); err != nil {
+		logCtx.Warn("Failed to mark page as translating", "error", err)
 	}
 
-	geminiResp, err := model.GenerateContent(ctx, filePart, prompt)
+	res, err = f.translate(ctx, logCtx, req)
+	if code := models.CodeOf(err); code == models.ErrorCodeSourceNotReady || code == models.ErrorCodeCancelled {
+		// Neither a failure nor a soft one: the workflow retries the page once it is
+		// uploaded, and a cancelled document's pages are left for a reprocessing.
+		if code == models.ErrorCodeCancelled {
+			recordCancelled(ctx, logCtx, f.firestoreClient, f.config.CollectionName, req.DocumentID, models.TokenUsageStageTranslator)
+		}
+		if recErr := updatePageRangeRecords(ctx, f.firestoreClient, f.config.CollectionName, req.DocumentID, startPage, endPage, map[string]interface{}{
+			"status": models.PageStatusPending,
+		}); recErr != nil {
+			logCtx.Warn("Failed to return page to pending", "error", recErr)
+		}
+		return nil, err
+	}
+	var filtered *FilteredResponseError
+	if err != nil && (req.AllowFailure || errors.As(err, &filtered)) {
+		return f.failSoft(ctx, logCtx, req, err)
+	}
 	if err != nil {
-		logCtx.Error("Call to Vertex AI failed", "error", err)
-		return nil, fmt.Errorf("failed to generate content from gemini: %w", err)
+		if recErr := updatePageRangeRecords(ctx, f.firestoreClient, f.config.CollectionName, req.DocumentID, startPage, endPage, withBlockDetails(map[string]interface{}{
+			"status":       models.PageStatusFailed,
+			"errorDetails": err.Error(),
+		}, err)); recErr != nil {
+			logCtx.Error("Failed to record page failure", "error", recErr)
+		}
+		return nil, err
+	}
+
+	pageStatus := models.PageStatusTranslated
+	if res.Status != "success" {
+		pageStatus = models.PageStatusSkipped
+	}
+	pageUpdates := map[string]interface{}{
+		"status":        pageStatus,
+		"markdownUri":   res.OutputGCSUri,
+		"errorDetails":  firestore.Delete,
+		"blockReason":   firestore.Delete,
+		"blockCategory": firestore.Delete,
+	}
+	if res.Confidence != nil {
+		pageUpdates["confidence"] = *res.Confidence
+		pageUpdates["qualityFlags"] = res.Flags
 	}
+	if res.Method == models.TranslationMethodGeminiRedacted {
+		pageUpdates["redactions"] = res.Redactions
+	}
+	if res.Degraded != nil {
+		pageUpdates["degradedInput"] = true
+		pageUpdates["renderedDpi"] = res.Degraded.DPI
+		pageUpdates["renderedSizeBytes"] = res.Degraded.SizeBytes
+	}
+	if err := updatePageRangeRecords(ctx, f.firestoreClient, f.config.CollectionName, req.DocumentID, startPage, endPage, pageUpdates); err != nil {
+		// The aggregator gates on page records, so an unrecorded success must be retried.
+		logCtx.Error("Failed to record page completion", "error", err)
+		return nil, err
+	}
+	return res, nil
+}
 
-	markdownContent := f.extractMarkdown(geminiResp, req)
+// validateRequest rejects a request with an invalid page range or for a page outside the
+// split pages bucket and the document's own objects.
+func (f *TranslatorFunction) validateRequest(logCtx *slog.Logger, req *models.PageTranslatorRequest) error {
+	startPage, endPage := req.Pages()
+	if startPage < 1 || endPage < startPage {
+		err := models.WithCode(models.ErrorCodeInvalidRequest, fmt.Errorf("invalid page range %d-%d", startPage, endPage))
+		logCtx.Error("Invalid translation request", "error", err)
+		return err
+	}
+	if _, _, err := gcp.ValidateDocumentURI(req.GCSUri, req.DocumentID, req.TenantID, f.config.PagesBucket); err != nil {
+		logCtx.Error("Invalid translation request", "error", err, "gcsUri", req.GCSUri)
+		return err
+	}
+	return nil
+}
 
-	// Sanity check for LLM refusal.
-	refusalPhrases := []string{
-		"i am unable to",
-		"i cannot fulfill",
-		"i cannot answer",
-		"i cannot provide",
-		"as a large language model",
+// failSoft writes a failure placeholder in place of the page's markdown and records the
+// page as FAILED_SOFT, so the workflow can carry on without it. If the placeholder cannot
+// be stored the original failure is returned as a hard error.
+func (f *TranslatorFunction) failSoft(ctx context.Context, logCtx *slog.Logger, req *models.PageTranslatorRequest, translateErr error) (*models.PageTranslatorResponse, error) {
+	startPage, endPage := req.Pages()
+	objectName, err := f.pageObjectName(ctx, req)
+	if err != nil {
+		logCtx.Error("Failed to name failure placeholder", "error", err)
+		return nil, translateErr
 	}
-	lowerMarkdownContent := strings.ToLower(markdownContent)
-	for _, phrase := range refusalPhrases {
-		if strings.Contains(lowerMarkdownContent, phrase) {
-			err := fmt.Errorf("gemini response indicates refusal for page %d", req.PageNumber)
-			logCtx.Error("LLM refusal detected", "error", err, "response", markdownContent)
-			return nil, err // This will fail the step in the workflow.
+	outputGCSUri := fmt.Sprintf("gs://%s/%s", f.config.MarkdownBucket, objectName)
+	logCtx.Warn("Translation failed; writing failure placeholder because allowFailure is set.", "error", translateErr)
+
+	placeholder := failedPagePlaceholder(startPage, endPage, translateErr.Error())
+	placeholderMetadata := map[string]string{gcp.PlaceholderMetadataKey: "failed"}
+	if err := gcp.SaveToGCSAtomicallyWithMetadata(ctx, logCtx, objectstore.WrapBucket(f.storageClient.Bucket(f.config.MarkdownBucket)), objectName, placeholder, placeholderMetadata); err != nil {
+		logCtx.Error("Failed to save failure placeholder to GCS", "error", err, "bucket", f.config.MarkdownBucket, "gcsObject", objectName)
+		if recErr := updatePageRangeRecords(ctx, f.firestoreClient, f.config.CollectionName, req.DocumentID, startPage, endPage, withBlockDetails(map[string]interface{}{
+			"status":       models.PageStatusFailed,
+			"errorDetails": translateErr.Error(),
+		}, translateErr)); recErr != nil {
+			logCtx.Error("Failed to record page failure", "error", recErr)
+		}
+		return nil, translateErr
+	}
+
+	if err := updatePageRangeRecords(ctx, f.firestoreClient, f.config.CollectionName, req.DocumentID, startPage, endPage, withBlockDetails(map[string]interface{}{
+		"status":       models.PageStatusFailedSoft,
+		"markdownUri":  outputGCSUri,
+		"errorDetails": translateErr.Error(),
+	}, translateErr)); err != nil {
+		logCtx.Error("Failed to record soft page failure", "error", err)
+		return nil, err
+	}
+
+	return &models.PageTranslatorResponse{
+		Status:       "failed_soft",
+		OutputGCSUri: outputGCSUri,
+		Error:        translateErr.Error(),
+	}, nil
+}
+
+// withBlockDetails adds the block reason and offending harm category to the page record
+// updates of a translation that failed because Gemini blocked it.
+func withBlockDetails(updates map[string]interface{}, err error) map[string]interface{} {
+	var filtered *FilteredResponseError
+	if !errors.As(err, &filtered) {
+		return updates
+	}
+	updates["blockReason"] = filtered.Reason()
+	if category := filtered.Category(); category != "" {
+		updates["blockCategory"] = category
+	}
+	return updates
+}
+
+// translate runs the model over a single page or chunk and stores the resulting markdown.
+func (f *TranslatorFunction) translate(ctx context.Context, logCtx *slog.Logger, req *models.PageTranslatorRequest) (*models.PageTranslatorResponse, error) {
+	startPage, endPage := req.Pages()
+	objectName, err := f.pageObjectName(ctx, req)
+	if err != nil {
+		logCtx.Error("Failed to name translation output", "error", err)
+		return nil, err
+	}
+	bucketHandle := f.storageClient.Bucket(f.config.MarkdownBucket)
+	outputGCSUri := fmt.Sprintf("gs://%s/%s", f.config.MarkdownBucket, objectName)
+
+	// --- Reuse a complete translation of the same source page from an earlier invocation ---
+	// A zero generation, when the input cannot be inspected, matches any output.
+	inputAttrs, inputErr := f.inputAttrs(ctx, req.GCSUri)
+	var sourceGeneration int64
+	if inputErr == nil {
+		sourceGeneration = inputAttrs.Generation
+	}
+	outputState, err := f.checkExistingOutput(ctx, logCtx, bucketHandle, objectName, sourceGeneration)
+	if err != nil {
+		return nil, err
+	}
+	if outputState == models.TranslationOutputReused {
+		logCtx.Info("Complete output already exists; skipping translation.", "outputGcsUri", outputGCSUri)
+		return &models.PageTranslatorResponse{Status: "success", OutputGCSUri: outputGCSUri, Output: outputState}, nil
+	}
+
+	// --- Re-render inputs the model cannot accept, or skip them, before paying for the upload ---
+	inputSize := int64(-1)
+	var degraded *degradedPage
+	switch {
+	case errors.Is(inputErr, storage.ErrObjectNotExist):
+		return nil, f.missingInputError(ctx, logCtx, req, inputErr)
+	case inputErr != nil:
+		logCtx.Warn("Could not determine input size; continuing without the size check.", "error", inputErr, "gcsUri", req.GCSUri)
+	case f.config.exceedsInputLimit(inputAttrs.Size):
+		if degraded = f.degradeOversizePage(ctx, logCtx, req, inputAttrs.Size); degraded == nil {
+			return f.writeOversizePlaceholder(ctx, logCtx, req, bucketHandle, objectName, inputAttrs.Size)
+		}
+		inputSize = int64(len(degraded.Data))
+	default:
+		inputSize = inputAttrs.Size
+	}
+
+	// --- Resolve the models of the page's processing region, never falling back to another ---
+	profileModels, region, err := f.profileModelsFor(ctx, logCtx, req)
+	if err != nil {
+		return nil, err
+	}
+	redact := req.RedactionMode == models.RedactionModeDLP
+
+	var imageObjects []string
+	if (req.IncludeImages || f.config.ExtractImages) && f.config.ImagesBucket != "" {
+		if degraded != nil {
+			logCtx.Info("Image references are not supported for re-rendered pages; translating as text only.")
+		} else if redact {
+			logCtx.Info("Image references are not supported for redacted pages; translating as text only.")
+		} else if startPage == endPage {
+			imageObjects = f.recordedPageImages(ctx, logCtx, req.DocumentID, startPage)
+			if len(imageObjects) == 0 && f.config.ExtractImages {
+				imageObjects = f.extractImages(ctx, logCtx, req.DocumentID, documentRoot(req.TenantID, req.DocumentID), startPage, req.GCSUri)
+			}
+		} else {
+			logCtx.Info("Image references are not supported for multi-page chunks; translating as text only.")
+		}
+	}
+
+	profile, model, promptText := f.promptFor(ctx, logCtx, req, profileModels)
+	if len(imageObjects) > 0 {
+		promptText += "\n\n" + fmt.Sprintf(gcp.TranslatorImagesPromptFormat, len(imageObjects))
+	}
+	if languagePrompt := translatorLanguagePrompt(req.SourceLanguage, req.TargetLanguage); languagePrompt != "" {
+		promptText += "\n\n" + languagePrompt
+	}
+	outputMetadata := sourceGenerationMetadata(sourceGeneration)
+
+	// --- Reuse the translation of an identical page of another document, if cached ---
+	// Redacted pages, image references and custom prompts make a translation particular
+	// to its document, so those are never cached.
+	var cacheKey *translationCacheKey
+	if f.config.Cache.Enabled() && !redact && degraded == nil && len(imageObjects) == 0 && req.CustomUserPrompt == "" {
+		key, err := f.translationCacheKeyFor(ctx, req, profile, gcp.TranslatorPromptProfiles[profile].System, promptText)
+		if err != nil {
+			logCtx.Warn("Failed to hash the page for the translation cache; translating without it.", "error", err)
+		} else if req.SkipCache {
+			cacheKey = &key
+		} else if cached := f.lookupCachedTranslation(ctx, logCtx, key, time.Now()); cached != nil {
+			res, err := f.saveCachedTranslation(ctx, logCtx, req, cached, model, bucketHandle, objectName, outputMetadata, inputSize)
+			if err != nil {
+				return nil, err
+			}
+			res.PromptProfile, res.Output, res.Region = profile, outputState, region
+			return res, nil
+		} else {
+			cacheKey = &key
+		}
+	}
+
+	method := models.TranslationMethodGemini
+	if redact {
+		method = models.TranslationMethodGeminiRedacted
+	}
+	// Model calls are audited, when AUDIT_BUCKET is set, under the pages they translate.
+	auditCtx := gcp.WithAuditScope(ctx, req.DocumentID, pageRangeName(startPage, endPage))
+	var redactions int
+	generate := func(prompt string) (*genai.GenerateContentResponse, int, bool, error) {
+		if redact {
+			resp, attempts, truncated, findings, err := f.generateRedacted(auditCtx, logCtx, model, req, prompt, describePages(startPage, endPage))
+			redactions = findings
+			return resp, attempts, truncated, err
 		}
+		var input genai.Part = genai.FileData{
+			MIMEType: "application/pdf",
+			FileURI:  req.GCSUri,
+		}
+		if degraded != nil {
+			input = genai.Blob{MIMEType: degradedInputMIMEType, Data: degraded.Data}
+		}
+		resp, attempts, truncated, err := f.generateTranslation(auditCtx, logCtx, model, input, genai.Text(prompt), describePages(startPage, endPage))
+		return resp, attempts, truncated, modelError(err)
+	}
+	generated, err := f.generateMarkdown(ctx, logCtx, req.DocumentID, promptText, describePages(startPage, endPage), generate)
+	if err != nil {
+		return nil, err
 	}
+	markdownContent, attempts, truncated, usage := generated.Markdown, generated.Attempts, generated.Truncated, generated.Usage
 
 	if markdownContent == "" {
 		logCtx.Warn("No markdown content extracted from response. Treating as empty page.")
 	}
 
-	// --- Use the shared, atomic GCS save function ---
-	objectName := fmt.Sprintf("%s/%05d.md", req.DocumentID, req.PageNumber)
-	bucketHandle := f.storageClient.Bucket(f.config.MarkdownBucket)
+	confidence, qualityFlags := assessTranslation(markdownContent, inputSize, truncated, f.config.Quality)
+	if len(qualityFlags) > 0 {
+		logCtx.Warn("Translation flagged as low quality.", "confidence", confidence, "flags", qualityFlags)
+	}
+
+	// --- Pages read as a picture of text are translated again from OCR text ---
+	if f.ocr != nil && !redact && degraded == nil && needsOCR(qualityFlags) {
+		ocrMarkdown, ocrResp, ocrAttempts, ocrTruncated, err := f.translateWithOCR(ctx, logCtx, model, req.GCSUri, promptText, describePages(startPage, endPage))
+		attempts += ocrAttempts
+		ocrUsage := tokenUsageFrom(ocrResp)
+		if err := recordTokenUsage(ctx, f.firestoreClient, f.config.CollectionName, req.DocumentID, models.TokenUsageStageTranslator, ocrUsage); err != nil {
+			logCtx.Warn("Failed to record token usage", "error", err)
+		}
+		usage = addTokenUsage(usage, ocrUsage)
+		if err != nil {
+			logCtx.Warn("OCR fallback failed; keeping the model's own translation.", "error", err)
+		} else {
+			markdownContent, method = ocrMarkdown, models.TranslationMethodGeminiOCR
+			confidence, qualityFlags = assessTranslation(markdownContent, inputSize, ocrTruncated, f.config.Quality)
+			logCtx.Info("Translated from OCR text.", "confidence", confidence, "flags", qualityFlags)
+		}
+	}
+
+	if len(imageObjects) > 0 {
+		links, err := imageLinks(f.storageClient.Bucket(f.config.ImagesBucket), documentRoot(req.TenantID, req.DocumentID), imageObjects, f.config.ImageLinkMode, f.config.ImageURLTTL)
+		if err != nil {
+			logCtx.Warn("Failed to build image links; leaving image references unresolved.", "error", err)
+		} else {
+			markdownContent = rewriteImageReferences(markdownContent, links)
+		}
+	}
 
-	if err := gcp.SaveToGCSAtomically(ctx, bucketHandle, objectName, markdownContent); err != nil {
+	// --- Use the shared, atomic GCS save function, recording the source page's generation ---
+	if err := gcp.SaveToGCSAtomicallyWithMetadata(ctx, logCtx, objectstore.WrapBucket(bucketHandle), objectName, markdownContent, outputMetadata); err != nil {
 		// The shared function logs the generic error, but we add our own with more context.
-		logCtx.Error("Failed to save to GCS atomically", "error", err, "bucket", f.config.MarkdownBucket, "object", objectName)
-		return nil, err
+		logCtx.Error("Failed to save to GCS atomically", "error", err, "bucket", f.config.MarkdownBucket, "gcsObject", objectName)
+		return nil, outputError(err)
+	}
+
+	// Only translations that raised no quality flag are worth reusing.
+	if cacheKey != nil && len(qualityFlags) == 0 {
+		f.storeCachedTranslation(ctx, logCtx, *cacheKey, req.DocumentID, outputGCSUri, usage)
+	}
+
+	// --- Tables are extracted in a second call, once the markdown is safely stored ---
+	var tables tableExtraction
+	if req.ExtractTables || f.config.ExtractTables {
+		if redact {
+			logCtx.Info("Table extraction is not supported for redacted pages; skipping it.")
+		} else if degraded != nil {
+			logCtx.Info("Table extraction is not supported for re-rendered pages; skipping it.")
+		} else {
+			tables = f.extractTables(ctx, logCtx, model, req)
+			attempts += tables.Attempts
+			usage = addTokenUsage(usage, tables.Usage)
+		}
+	}
+
+	logCtx.Info("Translation complete.", "outputGcsUri", outputGCSUri, "attempts", attempts, "output", outputState)
+	return &models.PageTranslatorResponse{
+		Status:         "success",
+		OutputGCSUri:   outputGCSUri,
+		Attempts:       attempts,
+		Usage:          usage,
+		Model:          f.config.Models.TranslatorModelName,
+		PromptProfile:  profile,
+		CustomPrompt:   req.CustomUserPrompt != "",
+		SourceLanguage: req.SourceLanguage,
+		TargetLanguage: req.TargetLanguage,
+		Confidence:     &confidence,
+		Flags:          qualityFlags,
+		Output:         outputState,
+		Method:         method,
+		Region:         region,
+		Redactions:     redactions,
+		Tables:         tables.URIs,
+		TablesSkipped:  tables.Skipped,
+		Images:         imageObjectURIs(f.config.ImagesBucket, imageObjects),
+		Degraded:       degraded.input(),
+	}, nil
+}
+
+// sourceGenerationMetadata is the custom metadata of a page's translation that records the
+// generation of the source page it was made from, if known.
+func sourceGenerationMetadata(sourceGeneration int64) map[string]string {
+	if sourceGeneration == 0 {
+		return nil
+	}
+	return map[string]string{gcp.SourceGenerationMetadataKey: strconv.FormatInt(sourceGeneration, 10)}
+}
+
+// saveCachedTranslation stores the markdown of a cache hit as the request's output, in
+// place of calling the model, and extracts the page's tables if they are asked for.
+func (f *TranslatorFunction) saveCachedTranslation(ctx context.Context, logCtx *slog.Logger, req *models.PageTranslatorRequest, cached *cachedTranslation, model gcp.ContentGenerator, bucketHandle *storage.BucketHandle, objectName string, outputMetadata map[string]string, inputSize int64) (*models.PageTranslatorResponse, error) {
+	if err := gcp.SaveToGCSAtomicallyWithMetadata(ctx, logCtx, objectstore.WrapBucket(bucketHandle), objectName, cached.Markdown, outputMetadata); err != nil {
+		logCtx.Error("Failed to save the cached translation", "error", err, "bucket", f.config.MarkdownBucket, "gcsObject", objectName)
+		return nil, outputError(err)
+	}
+	confidence, qualityFlags := assessTranslation(cached.Markdown, inputSize, false, f.config.Quality)
+	var tables tableExtraction
+	if req.ExtractTables || f.config.ExtractTables {
+		tables = f.extractTables(ctx, logCtx, model, req)
 	}
 
 	outputGCSUri := fmt.Sprintf("gs://%s/%s", f.config.MarkdownBucket, objectName)
-	logCtx.Info("Translation complete.", "outputGcsUri", outputGCSUri)
+	var tokensSaved int64
+	if cached.Entry.Usage != nil {
+		tokensSaved = cached.Entry.Usage.TotalTokens
+	}
+	logCtx.Info("Translation copied from the cache.", "outputGcsUri", outputGCSUri, "cachedGcsUri", cached.Entry.MarkdownGCSUri, "cachedDocumentId", cached.Entry.DocumentID, "tokensSaved", tokensSaved)
 	return &models.PageTranslatorResponse{
-		Status:       "success",
-		OutputGCSUri: outputGCSUri,
+		Status:         "success",
+		OutputGCSUri:   outputGCSUri,
+		Attempts:       tables.Attempts,
+		Usage:          tables.Usage,
+		Model:          cached.Entry.Model,
+		SourceLanguage: req.SourceLanguage,
+		TargetLanguage: req.TargetLanguage,
+		Confidence:     &confidence,
+		Flags:          qualityFlags,
+		Tables:         tables.URIs,
+		TablesSkipped:  tables.Skipped,
+		CacheHit:       true,
 	}, nil
 }
 
-// extractMarkdown parses the model's response and robustly extracts text content.
-func (f *TranslatorFunction) extractMarkdown(resp *genai.GenerateContentResponse, req *models.PageTranslatorRequest) string {
+// pageObjectName returns the name of the translation of the request's pages, as
+// OUTPUT_PAGE_TEMPLATE gives it.
+func (f *TranslatorFunction) pageObjectName(ctx context.Context, req *models.PageTranslatorRequest) (string, error) {
+	doc, err := f.config.OutputNames.Document(ctx, f.firestoreClient, f.config.CollectionName, req.DocumentID, req.TenantID)
+	if err != nil {
+		return "", sourceError(err)
+	}
+	startPage, endPage := req.Pages()
+	return f.config.OutputNames.Page(doc, startPage, endPage)
+}
+
+// generateTranslation runs the model over the PDF of the pages described by pages. A GCS
+// input that Vertex AI is denied access to is retried as inline bytes, and output cut off
+// at the token limit is continued. It returns the response, the number of model attempts
+// made and whether the output still ends truncated.
+func (f *TranslatorFunction) generateTranslation(ctx context.Context, logCtx *slog.Logger, model gcp.ContentGenerator, input, prompt genai.Part, pages string) (*genai.GenerateContentResponse, int, bool, error) {
+	resp, attempts, err := generateWithRetry(ctx, logCtx, model, f.config.Retry, input, prompt)
+	if file, ok := input.(genai.FileData); ok {
+		if err != nil && status.Code(err) == codes.PermissionDenied {
+			resp, input, attempts, err = f.generateInline(ctx, logCtx, model, file.FileURI, prompt, attempts, err)
+		} else if err == nil {
+			logCtx.Info("Translated from GCS URI.", "inputMode", "gcs_uri")
+		}
+	}
+	var truncated bool
+	if err == nil {
+		var continuationAttempts int
+		resp, continuationAttempts, truncated, err = continueTruncated(ctx, logCtx, model, f.config.Retry, f.config.MaxContinuations, resp, input, prompt)
+		attempts += continuationAttempts
+	}
+	if filtered := asFilteredResponseError(resp, err); filtered != nil {
+		// Retrying would be filtered the same way, so this is reported as its own error.
+		logCtx.Warn("Gemini output was filtered", "error", filtered, "attempts", attempts)
+		return nil, attempts, false, fmt.Errorf("failed to translate %s: %w", pages, filtered)
+	}
+	if err != nil {
+		logCtx.Error("Call to Vertex AI failed", "error", err, "attempts", attempts)
+		return nil, attempts, false, fmt.Errorf("failed to generate content from gemini after %d attempt(s): %w", attempts, err)
+	}
+	return resp, attempts, truncated, nil
+}
+
+// promptFor returns the prompt profile to translate the request with, the model carrying
+// that profile's system prompt, and the user prompt: the request's custom prompt if it has
+// one, otherwise the profile's. The prompt profile of the document's processing profile
+// takes precedence over the request's, and an unknown profile falls back to the default.
+func (f *TranslatorFunction) promptFor(ctx context.Context, logCtx *slog.Logger, req *models.PageTranslatorRequest, profileModels map[string]gcp.ContentGenerator) (string, gcp.ContentGenerator, string) {
+	profile := req.PromptProfile
+	if documentProfile := f.profiles.ResolveOrDefault(ctx, logCtx, req.DocumentID); documentProfile.PromptProfile != "" {
+		profile = documentProfile.PromptProfile
+	}
+	if profile == "" {
+		profile = gcp.PromptProfileDefault
+	}
+	prompt, ok := gcp.TranslatorPromptProfiles[profile]
+	model := profileModels[profile]
+	if !ok || model == nil {
+		logCtx.Warn("Unknown prompt profile; using the default.", "promptProfile", profile)
+		profile = gcp.PromptProfileDefault
+		prompt = gcp.TranslatorPromptProfiles[profile]
+		model = profileModels[profile]
+	}
+	userPrompt := prompt.User
+	if req.CustomUserPrompt != "" {
+		userPrompt = req.CustomUserPrompt
+	}
+	logCtx.Info("Selected translator prompt.", "promptProfile", profile, "customPrompt", req.CustomUserPrompt != "")
+	return profile, model, userPrompt
+}
+
+// checkExistingOutput reports what the translation should do with objectName: reuse it,
+// when it holds a complete translation of the source page's sourceGeneration; replace it,
+// deleting it first so that the atomic save does not skip it forever; or write it fresh.
+// An empty or unmarked object (left by a crashed writer), a placeholder and the output
+// of an earlier generation of the page are replaced. Outputs written before source
+// generations were recorded, and any output when sourceGeneration is zero, are reused.
+func (f *TranslatorFunction) checkExistingOutput(ctx context.Context, logCtx *slog.Logger, bucketHandle *storage.BucketHandle, objectName string, sourceGeneration int64) (string, error) {
+	obj := bucketHandle.Object(objectName)
+	attrs, err := obj.Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return models.TranslationOutputFresh, nil
+	}
+	if err != nil {
+		logCtx.Error("Failed to inspect existing output", "error", err, "gcsObject", objectName)
+		return "", fmt.Errorf("failed to inspect existing output %s: %w", objectName, err)
+	}
+	if reusableOutput(attrs, sourceGeneration) {
+		return models.TranslationOutputReused, nil
+	}
+
+	logCtx.Warn("Existing output is incomplete, a placeholder or of an earlier source page; translating again.",
+		"gcsObject", objectName,
+		"size", attrs.Size,
+		"complete", attrs.Metadata[gcp.CompleteMetadataKey],
+		"placeholder", attrs.Metadata[gcp.PlaceholderMetadataKey],
+		"storedSourceGeneration", attrs.Metadata[gcp.SourceGenerationMetadataKey],
+		"sourceGeneration", sourceGeneration,
+	)
+	if err := obj.If(storage.Conditions{GenerationMatch: attrs.Generation}).Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		logCtx.Error("Failed to delete stale output", "error", err, "gcsObject", objectName)
+		return "", fmt.Errorf("failed to delete stale output %s: %w", objectName, err)
+	}
+	return models.TranslationOutputRegenerated, nil
+}
+
+// reusableOutput reports whether an existing output object holds a complete translation
+// of the source page's sourceGeneration, as described on checkExistingOutput.
+func reusableOutput(attrs *storage.ObjectAttrs, sourceGeneration int64) bool {
+	storedGeneration := attrs.Metadata[gcp.SourceGenerationMetadataKey]
+	sameSource := sourceGeneration == 0 || storedGeneration == "" || storedGeneration == strconv.FormatInt(sourceGeneration, 10)
+	return gcp.IsCompleteObject(attrs) && attrs.Metadata[gcp.PlaceholderMetadataKey] == "" && sameSource
+}
+
+// generateInline retries a translation whose GCS URI Vertex AI was denied access to, by
+// downloading the page with the function's own credentials and sending it as an inline
+// blob, which is returned alongside the response. Pages above InlineMaxBytes are not
+// downloaded and uriErr is returned unchanged.
+func (f *TranslatorFunction) generateInline(ctx context.Context, logCtx *slog.Logger, model gcp.ContentGenerator, gcsURI string, prompt genai.Part, uriAttempts int, uriErr error) (*genai.GenerateContentResponse, genai.Part, int, error) {
+	bucket, object, err := gcp.ParseGCSURI(gcsURI)
+	if err != nil {
+		return nil, nil, uriAttempts, uriErr
+	}
+	obj := f.storageClient.Bucket(bucket).Object(object)
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		logCtx.Warn("Vertex AI was denied access to the page and it could not be inspected for inline upload.", "error", err, "gcsUri", gcsURI)
+		return nil, nil, uriAttempts, uriErr
+	}
+	if attrs.Size > f.config.InlineMaxBytes {
+		logCtx.Warn("Vertex AI was denied access to the page and it is too large to send inline.", "inputBytes", attrs.Size, "inlineMaxBytes", f.config.InlineMaxBytes)
+		return nil, nil, uriAttempts, uriErr
+	}
+
+	reader, err := obj.NewReader(ctx)
+	if err != nil {
+		logCtx.Warn("Failed to open page for inline upload.", "error", err, "gcsUri", gcsURI)
+		return nil, nil, uriAttempts, uriErr
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		logCtx.Warn("Failed to download page for inline upload.", "error", err, "gcsUri", gcsURI)
+		return nil, nil, uriAttempts, uriErr
+	}
+
+	logCtx.Warn("Vertex AI was denied access to the page's GCS URI; retrying with inline bytes.", "uriError", uriErr, "inputBytes", len(data))
+	blob := genai.Blob{MIMEType: "application/pdf", Data: data}
+	resp, attempts, err := generateWithRetry(ctx, logCtx, model, f.config.Retry, blob, prompt)
+	if err != nil {
+		return nil, nil, uriAttempts + attempts, err
+	}
+	logCtx.Info("Translated from inline bytes.", "inputMode", "inline")
+	return resp, blob, uriAttempts + attempts, nil
+}
+
+// inputAttrs returns the attributes of the page object referenced by gcsURI.
+func (f *TranslatorFunction) inputAttrs(ctx context.Context, gcsURI string) (*storage.ObjectAttrs, error) {
+	bucket, object, err := gcp.ParseGCSURI(gcsURI)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := f.storageClient.Bucket(bucket).Object(object).Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attributes of %s: %w", gcsURI, err)
+	}
+	return attrs, nil
+}
+
+// missingInputError classifies a page input that does not exist. While the document is
+// SPLITTING the splitter may still be uploading it, after starting the workflow early, so
+// it is reported as SOURCE_NOT_READY, which is retried. Otherwise the page is not coming
+// and it is NOT_FOUND_SOURCE.
+func (f *TranslatorFunction) missingInputError(ctx context.Context, logCtx *slog.Logger, req *models.PageTranslatorRequest, inputErr error) error {
+	snap, err := f.firestoreClient.Collection(f.config.CollectionName).Doc(req.DocumentID).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		logCtx.Error("Input page does not exist and its document could not be read", "error", err, "gcsUri", req.GCSUri)
+		return fmt.Errorf("failed to read document %s: %w", req.DocumentID, err)
+	}
+	if err == nil {
+		var doc models.Document
+		if err := snap.DataTo(&doc); err != nil {
+			return fmt.Errorf("failed to decode document %s: %w", req.DocumentID, err)
+		}
+		if doc.Status == "SPLITTING" {
+			logCtx.Warn("Input page has not been uploaded yet.", "gcsUri", req.GCSUri, "pagesUploaded", doc.PagesUploaded, "pageCount", doc.PageCount)
+			return models.WithCode(models.ErrorCodeSourceNotReady, fmt.Errorf("input page is still being uploaded: %w", inputErr))
+		}
+		if slices.Contains(doc.FailedPages, req.PageNumber) {
+			logCtx.Error("Input page failed to upload; it is left for the page retranslator to backfill.", "gcsUri", req.GCSUri)
+			return sourceError(fmt.Errorf("input page failed to upload: %w", inputErr))
+		}
+	}
+	logCtx.Error("Input page does not exist", "error", inputErr, "gcsUri", req.GCSUri)
+	return sourceError(inputErr)
+}
+
+// writeOversizePlaceholder stores a placeholder for a page that exceeds the model's input
+// limit. Retrying such a page can never succeed, so it is reported as a soft outcome.
+func (f *TranslatorFunction) writeOversizePlaceholder(ctx context.Context, logCtx *slog.Logger, req *models.PageTranslatorRequest, bucketHandle *storage.BucketHandle, objectName string, inputSize int64) (*models.PageTranslatorResponse, error) {
+	logCtx.Warn("Page exceeds the model input limit; writing placeholder.", "inputBytes", inputSize, "maxInputBytes", f.config.MaxInputBytes)
+
+	startPage, endPage := req.Pages()
+	placeholder := fmt.Sprintf("<!-- %s SKIPPED: input of %d bytes exceeds the %d byte model limit -->", strings.ToUpper(describePages(startPage, endPage)), inputSize, f.config.MaxInputBytes)
+	placeholderMetadata := map[string]string{gcp.PlaceholderMetadataKey: "oversize"}
+	if err := gcp.SaveToGCSAtomicallyWithMetadata(ctx, logCtx, objectstore.WrapBucket(bucketHandle), objectName, placeholder, placeholderMetadata); err != nil {
+		logCtx.Error("Failed to save placeholder to GCS", "error", err, "bucket", f.config.MarkdownBucket, "gcsObject", objectName)
+		return nil, outputError(err)
+	}
+
+	return &models.PageTranslatorResponse{
+		Status:       "skipped_oversize",
+		OutputGCSUri: fmt.Sprintf("gs://%s/%s", f.config.MarkdownBucket, objectName),
+	}, nil
+}
+
+// recordedPageImages returns the image objects the splitter recorded on a page record of
+// docID. A record that cannot be read degrades the page to text-only translation rather
+// than failing it.
+func (f *TranslatorFunction) recordedPageImages(ctx context.Context, logCtx *slog.Logger, docID string, pageNumber int) []string {
+	page, err := getPageRecord(ctx, f.firestoreClient, f.config.CollectionName, docID, pageNumber)
+	if err != nil {
+		logCtx.Warn("Failed to read the page's images; translating as text only.", "error", err)
+		return nil
+	}
+	if page == nil || len(page.ImageURIs) == 0 {
+		return nil
+	}
+	objects := imageObjectNames(f.config.ImagesBucket, page.ImageURIs)
+	logCtx.Info("Found extracted page images.", "imageCount", len(objects))
+	return objects
+}
+
+// extractImages extracts the embedded images of a page from its PDF at gcsURI, stores
+// them in the images bucket under root and records their URIs on the page record of
+// docID, returning their object names. Like recordedPageImages, any failure degrades the
+// page to text-only translation, and a page without images is simply translated as text.
+func (f *TranslatorFunction) extractImages(ctx context.Context, logCtx *slog.Logger, docID, root string, pageNumber int, gcsURI string) []string {
+	bucket, object, err := gcp.ParseGCSURI(gcsURI)
+	if err != nil {
+		logCtx.Warn("Failed to parse page URI for image extraction; translating as text only.", "error", err, "gcsUri", gcsURI)
+		return nil
+	}
+	reader, err := f.storageClient.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		logCtx.Warn("Failed to open page for image extraction; translating as text only.", "error", err, "gcsUri", gcsURI)
+		return nil
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		logCtx.Warn("Failed to download page for image extraction; translating as text only.", "error", err, "gcsUri", gcsURI)
+		return nil
+	}
+
+	images, skipped, err := extractPageImages(bytes.NewReader(data))
+	if err != nil {
+		logCtx.Warn("Image extraction failed; translating as text only.", "error", err)
+		return nil
+	}
+	if skipped > 0 {
+		logCtx.Warn("Skipped page images that could not be decoded.", "skippedImages", skipped)
+	}
+	if len(images) == 0 {
+		return nil
+	}
+	objects, err := storePageImages(ctx, objectstore.WrapBucket(f.storageClient.Bucket(f.config.ImagesBucket)), root, pageNumber, images)
+	if err != nil {
+		logCtx.Warn("Failed to store extracted page images; translating as text only.", "error", err)
+		return nil
+	}
+	uris := imageObjectURIs(f.config.ImagesBucket, objects)
+	if err := updatePageRecord(ctx, f.firestoreClient, f.config.CollectionName, docID, pageNumber, map[string]interface{}{"imageUris": uris}); err != nil {
+		// The images are referenced all the same; a retry extracts them again.
+		logCtx.Warn("Failed to record extracted page images", "error", err)
+	}
+	logCtx.Info("Extracted page images.", "imageCount", len(objects))
+	return objects
+}
+
+// describePages renders a page range for messages, e.g. "page 4" or "pages 4-8".
+func describePages(startPage, endPage int) string {
+	if startPage == endPage {
+		return fmt.Sprintf("page %d", startPage)
+	}
+	return fmt.Sprintf("pages %d-%d", startPage, endPage)
+}
+
+// checkRefusal returns an error wrapping errModelRefusal if markdown reads as the model
+// declining to translate the pages described by pages.
+func checkRefusal(markdown, pages string) error {
+	refusalPhrases := []string{
+		"i am unable to",
+		"i cannot fulfill",
+		"i cannot answer",
+		"i cannot provide",
+		"as a large language model",
+	}
+	lowerMarkdown := strings.ToLower(markdown)
+	for _, phrase := range refusalPhrases {
+		if strings.Contains(lowerMarkdown, phrase) {
+			return fmt.Errorf("%w for %s", errModelRefusal, pages)
+		}
+	}
+	return nil
+}
+
+// extractMarkdown parses the model's response and robustly extracts text content. A
+// response whose prompt was blocked or whose output was filtered returns a
+// FilteredResponseError carrying the block reason and safety ratings, rather than passing
+// for an empty page.
+func extractMarkdown(logCtx *slog.Logger, resp *genai.GenerateContentResponse) (string, error) {
+	if filtered := asFilteredResponseError(resp, nil); filtered != nil {
+		return "", filtered
+	}
 	if resp == nil || len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
-		return ""
+		return "", nil
 	}
 
 	var markdownContent strings.Builder
@@ -148,18 +1063,12 @@ func (f *TranslatorFunction) extractMarkdown(resp *genai.GenerateContentResponse
 	}
 
 	if textPartsFound > 1 {
-		slog.Warn(
-			"Gemini response contained multiple text parts; they have been concatenated.",
-			"documentId", req.DocumentID,
-			"pageNumber", req.PageNumber,
-			"executionId", req.ExecutionID,
-			"partCount", textPartsFound,
-		)
+		logCtx.Warn("Gemini response contained multiple text parts; they have been concatenated.", "partCount", textPartsFound)
 	}
 
 	contentStr := strings.TrimSpace(markdownContent.String())
 	contentStr = strings.TrimPrefix(contentStr, "```markdown")
 	contentStr = strings.TrimPrefix(contentStr, "```")
 	contentStr = strings.TrimSuffix(contentStr, "```")
-	return strings.TrimSpace(contentStr)
+	return strings.TrimSpace(contentStr), nil
 }
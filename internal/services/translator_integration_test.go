@@ -0,0 +1,223 @@
+//go:build integration
+
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"cloud.google.com/go/vertexai/genai"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestCheckExistingOutputReplacesEmptyObject(t *testing.T) {
+	ctx := context.Background()
+	emulators := testsupport.RequireEmulators(t)
+	_, storageClient := emulators.Clients(ctx, t)
+	bucket := storageClient.Bucket(emulators.CreateBuckets(ctx, t, storageClient, "translated")[0])
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	f := &TranslatorFunction{}
+
+	// A crashed writer left a zero-byte object without the completion marker.
+	if err := bucket.Object("doc/00001.md").NewWriter(ctx).Close(); err != nil {
+		t.Fatal(err)
+	}
+	state, err := f.checkExistingOutput(ctx, logCtx, bucket, "doc/00001.md", 0)
+	if err != nil {
+		t.Fatalf("checkExistingOutput() error = %v", err)
+	}
+	if state != models.TranslationOutputRegenerated {
+		t.Errorf("checkExistingOutput() = %q, want %q", state, models.TranslationOutputRegenerated)
+	}
+	if _, err := bucket.Object("doc/00001.md").Attrs(ctx); !errors.Is(err, storage.ErrObjectNotExist) {
+		t.Errorf("empty object was not deleted: %v", err)
+	}
+
+	if err := gcp.SaveToGCSAtomically(ctx, logCtx, objectstore.WrapBucket(bucket), "doc/00001.md", "# Page 1"); err != nil {
+		t.Fatal(err)
+	}
+	state, err = f.checkExistingOutput(ctx, logCtx, bucket, "doc/00001.md", 0)
+	if err != nil {
+		t.Fatalf("checkExistingOutput() error = %v", err)
+	}
+	if state != models.TranslationOutputReused {
+		t.Errorf("checkExistingOutput() of a complete translation = %q, want %q", state, models.TranslationOutputReused)
+	}
+}
+
+func TestGenerateTranslationInlineFallback(t *testing.T) {
+	ctx := context.Background()
+	emulators := testsupport.RequireEmulators(t)
+	_, storageClient := emulators.Clients(ctx, t)
+	bucket := emulators.CreateBuckets(ctx, t, storageClient, "pages")[0]
+	page := testsupport.FixturePDF(1)
+	w := storageClient.Bucket(bucket).Object("doc-1/00001.pdf").NewWriter(ctx)
+	if _, err := w.Write(page); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pageURI := fmt.Sprintf("gs://%s/doc-1/00001.pdf", bucket)
+	denied := status.Error(codes.PermissionDenied, "the service agent cannot read the object")
+	ok := testsupport.FakeResult{Response: testsupport.TextResponse("# Page")}
+
+	tests := []struct {
+		name         string
+		uri          string
+		inlineMax    int64
+		results      []testsupport.FakeResult
+		wantInputs   []string // The input of each model call: "uri" or "inline".
+		wantAttempts int
+		wantCode     codes.Code // The gRPC code of the error returned, if any.
+	}{
+		{name: "URI readable", uri: pageURI, inlineMax: 1 << 20, results: []testsupport.FakeResult{ok}, wantInputs: []string{"uri"}, wantAttempts: 1},
+		{name: "URI denied", uri: pageURI, inlineMax: 1 << 20, results: []testsupport.FakeResult{{Err: denied}, ok}, wantInputs: []string{"uri", "inline"}, wantAttempts: 2},
+		{name: "URI denied, too large to inline", uri: pageURI, inlineMax: int64(len(page)) - 1, results: []testsupport.FakeResult{{Err: denied}}, wantInputs: []string{"uri"}, wantAttempts: 1, wantCode: codes.PermissionDenied},
+		{name: "URI denied, page missing", uri: fmt.Sprintf("gs://%s/doc-1/00002.pdf", bucket), inlineMax: 1 << 20, results: []testsupport.FakeResult{{Err: denied}}, wantInputs: []string{"uri"}, wantAttempts: 1, wantCode: codes.PermissionDenied},
+		{
+			name: "inline fails too", uri: pageURI, inlineMax: 1 << 20,
+			results:    []testsupport.FakeResult{{Err: denied}, {Err: status.Error(codes.InvalidArgument, "the document has no pages")}},
+			wantInputs: []string{"uri", "inline"}, wantAttempts: 2, wantCode: codes.InvalidArgument,
+		},
+		{name: "other errors not inlined", uri: pageURI, inlineMax: 1 << 20, results: []testsupport.FakeResult{{Err: status.Error(codes.InvalidArgument, "bad request")}}, wantInputs: []string{"uri"}, wantAttempts: 1, wantCode: codes.InvalidArgument},
+	}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := testsupport.NewFakeContentGenerator(tt.results...)
+			f := &TranslatorFunction{storageClient: storageClient, config: TranslatorConfig{InlineMaxBytes: tt.inlineMax}}
+			input := genai.FileData{MIMEType: "application/pdf", FileURI: tt.uri}
+
+			resp, attempts, _, err := f.generateTranslation(ctx, logCtx, model, input, genai.Text("translate"), "page 1")
+			if tt.wantCode != codes.OK {
+				if status.Code(err) != tt.wantCode {
+					t.Errorf("generateTranslation() error = %v, want %s", err, tt.wantCode)
+				}
+			} else if err != nil || resp == nil {
+				t.Errorf("generateTranslation() = %v, %v; want a response", resp, err)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+
+			calls := model.Calls()
+			if len(calls) != len(tt.wantInputs) {
+				t.Fatalf("model called %d times, want %d", len(calls), len(tt.wantInputs))
+			}
+			for i, call := range calls {
+				switch input := call[0].(type) {
+				case genai.FileData:
+					if tt.wantInputs[i] != "uri" || input.FileURI != tt.uri {
+						t.Errorf("call %d input = URI %s, want %s", i+1, input.FileURI, tt.wantInputs[i])
+					}
+				case genai.Blob:
+					if tt.wantInputs[i] != "inline" || !bytes.Equal(input.Data, page) || input.MIMEType != "application/pdf" {
+						t.Errorf("call %d input = %d inline bytes, want %s", i+1, len(input.Data), tt.wantInputs[i])
+					}
+				default:
+					t.Errorf("call %d input = %T, want %s", i+1, input, tt.wantInputs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTranslatorRetranslatesAChangedSourcePage(t *testing.T) {
+	ctx := context.Background()
+	emulators := testsupport.RequireEmulators(t)
+	firestoreClient, storageClient := emulators.Clients(ctx, t)
+	created := emulators.CreateBuckets(ctx, t, storageClient, "split-pages", "translated")
+	collection := fmt.Sprintf("documents-%d", time.Now().UnixNano())
+	setPipelineEnv(t, collection, pipelineBuckets{splitPages: created[0], translated: created[1]})
+	if _, err := firestoreClient.Collection(collection).Doc("doc-1").Set(ctx, map[string]interface{}{
+		"status":              "TRANSLATING",
+		"workflowExecutionId": testsupport.ExecutionID,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// writePage uploads the split page, as the splitter does on each split, and returns
+	// the generation it was written as: a new one each time, though the bytes are the same.
+	writePage := func() int64 {
+		t.Helper()
+		w := storageClient.Bucket(created[0]).Object("doc-1/00001.pdf").NewWriter(ctx)
+		if _, err := w.Write(testsupport.FixturePDF(1)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return w.Attrs().Generation
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls int
+	f := &TranslatorFunction{
+		storageClient:   storageClient,
+		firestoreClient: firestoreClient,
+		profileModels: map[string]gcp.ContentGenerator{gcp.PromptProfileDefault: modelFunc(func([]genai.Part) string {
+			calls++
+			return fmt.Sprintf("# Revision %d", calls)
+		})},
+		profiles:     NewProfileResolver(firestoreClient, collection, time.Minute),
+		cancellation: NewCancellationChecker(firestoreClient, collection, config.CancelCheckTTL),
+		config:       *config,
+	}
+	req := testsupport.TranslatorRequest("doc-1", 1, fmt.Sprintf("gs://%s/doc-1/00001.pdf", created[0]))
+
+	steps := []struct {
+		name      string
+		split     bool // Whether the page is uploaded again first.
+		want      string
+		wantCalls int
+	}{
+		{name: "first translation", split: true, want: models.TranslationOutputFresh, wantCalls: 1},
+		{name: "same source page", want: models.TranslationOutputReused, wantCalls: 1},
+		{name: "source page split again", split: true, want: models.TranslationOutputRegenerated, wantCalls: 2},
+		{name: "new source page unchanged", want: models.TranslationOutputReused, wantCalls: 2},
+	}
+	var generation int64
+	for _, step := range steps {
+		if step.split {
+			generation = writePage()
+		}
+		res, err := f.Process(ctx, req)
+		if err != nil {
+			t.Fatalf("%s: Process() error = %v", step.name, err)
+		}
+		if res.Status != "success" || res.Output != step.want {
+			t.Errorf("%s: Process() = status %q, output %q; want success, %q", step.name, res.Status, res.Output, step.want)
+		}
+		if calls != step.wantCalls {
+			t.Errorf("%s: model called %d times in all, want %d", step.name, calls, step.wantCalls)
+		}
+		_, object, err := gcp.ParseGCSURI(res.OutputGCSUri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		attrs, err := storageClient.Bucket(created[1]).Object(object).Attrs(ctx)
+		if err != nil {
+			t.Fatalf("%s: output %s: %v", step.name, res.OutputGCSUri, err)
+		}
+		if got := attrs.Metadata[gcp.SourceGenerationMetadataKey]; got != strconv.FormatInt(generation, 10) {
+			t.Errorf("%s: output made from source generation %q, want %d", step.name, got, generation)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"cloud.google.com/go/vertexai/genai"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestReusableOutput(t *testing.T) {
+	tests := []struct {
+		name             string
+		metadata         map[string]string
+		size             int64
+		sourceGeneration int64
+		want             bool
+	}{
+		{name: "complete", metadata: map[string]string{gcp.CompleteMetadataKey: "true"}, size: 120, want: true},
+		{name: "empty leftover of a crashed writer", size: 0},
+		{name: "unmarked object written before the marker", size: 120},
+		{name: "failure placeholder", metadata: map[string]string{gcp.CompleteMetadataKey: "true", gcp.PlaceholderMetadataKey: "failed"}, size: 40},
+		{
+			name:             "same source generation",
+			metadata:         map[string]string{gcp.CompleteMetadataKey: "true", gcp.SourceGenerationMetadataKey: "7"},
+			size:             120,
+			sourceGeneration: 7,
+			want:             true,
+		},
+		{
+			name:             "earlier source generation",
+			metadata:         map[string]string{gcp.CompleteMetadataKey: "true", gcp.SourceGenerationMetadataKey: "6"},
+			size:             120,
+			sourceGeneration: 7,
+		},
+		{
+			name:             "unrecorded source generation",
+			metadata:         map[string]string{gcp.CompleteMetadataKey: "true"},
+			size:             120,
+			sourceGeneration: 7,
+			want:             true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs := &storage.ObjectAttrs{Size: tt.size, Metadata: tt.metadata}
+			if got := reusableOutput(attrs, tt.sourceGeneration); got != tt.want {
+				t.Errorf("reusableOutput() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractMarkdown(t *testing.T) {
+	safetyStop := testsupport.TextResponse("partial")
+	safetyStop.Candidates[0].FinishReason = genai.FinishReasonSafety
+	tests := []struct {
+		name         string
+		resp         *genai.GenerateContentResponse
+		want         string
+		wantFiltered bool
+	}{
+		{name: "plain", resp: testsupport.TextResponse("# Title\n\nBody."), want: "# Title\n\nBody."},
+		{name: "multiple parts are concatenated", resp: testsupport.TextResponse("# Title\n", "Body."), want: "# Title\nBody."},
+		{name: "markdown fence", resp: testsupport.TextResponse("```markdown\n# Title\n```"), want: "# Title"},
+		{name: "bare fence", resp: testsupport.TextResponse("  ```\n# Title\n```  "), want: "# Title"},
+		{name: "no candidates", resp: &genai.GenerateContentResponse{}},
+		{name: "candidate without content", resp: &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{}}}},
+		{name: "blocked prompt", resp: testsupport.BlockedResponse(genai.BlockedReasonSafety), wantFiltered: true},
+		{name: "output stopped for safety", resp: safetyStop, wantFiltered: true},
+	}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractMarkdown(logCtx, tt.resp)
+			var filtered *FilteredResponseError
+			if errors.As(err, &filtered) != tt.wantFiltered {
+				t.Fatalf("extractMarkdown() error = %v, want filtered = %v", err, tt.wantFiltered)
+			}
+			if !tt.wantFiltered && err != nil {
+				t.Fatalf("extractMarkdown() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("extractMarkdown() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateTranslationRetries(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "backend unavailable")
+	tests := []struct {
+		name         string
+		results      []testsupport.FakeResult
+		wantAttempts int
+		wantErr      bool
+		wantFiltered bool
+	}{
+		{
+			name:         "first attempt",
+			results:      []testsupport.FakeResult{{Response: testsupport.TextResponse("# Page")}},
+			wantAttempts: 1,
+		},
+		{
+			name: "transient errors are retried",
+			results: []testsupport.FakeResult{
+				{Err: unavailable},
+				{Err: status.Error(codes.ResourceExhausted, "quota")},
+				{Response: testsupport.TextResponse("# Page")},
+			},
+			wantAttempts: 3,
+		},
+		{
+			name:         "retries run out",
+			results:      []testsupport.FakeResult{{Err: unavailable}},
+			wantAttempts: 3,
+			wantErr:      true,
+		},
+		{
+			name:         "permanent errors are not retried",
+			results:      []testsupport.FakeResult{{Err: status.Error(codes.InvalidArgument, "bad request")}},
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "blocked prompts are not retried",
+			results:      []testsupport.FakeResult{{Response: testsupport.BlockedResponse(genai.BlockedReasonSafety)}},
+			wantAttempts: 1,
+			wantErr:      true,
+			wantFiltered: true,
+		},
+		{
+			name:         "blocked candidates are not retried",
+			results:      []testsupport.FakeResult{{Err: testsupport.BlockedCandidateError(genai.HarmCategoryDangerousContent)}},
+			wantAttempts: 1,
+			wantErr:      true,
+			wantFiltered: true,
+		},
+	}
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := testsupport.NewFakeContentGenerator(tt.results...)
+			f := &TranslatorFunction{config: TranslatorConfig{Retry: GeminiRetryConfig{MaxRetries: 2, BaseDelay: time.Millisecond}}}
+			resp, attempts, _, err := f.generateTranslation(context.Background(), logCtx, model, genai.Text("page"), genai.Text("translate"), "page 1")
+			if attempts != tt.wantAttempts || len(model.Calls()) != tt.wantAttempts {
+				t.Errorf("attempts = %d with %d calls, want %d", attempts, len(model.Calls()), tt.wantAttempts)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("generateTranslation() error = %v, want error = %v", err, tt.wantErr)
+			}
+			var filtered *FilteredResponseError
+			if errors.As(err, &filtered) != tt.wantFiltered {
+				t.Errorf("generateTranslation() error = %v, want filtered = %v", err, tt.wantFiltered)
+			}
+			if err == nil {
+				if got, _ := extractMarkdown(logCtx, resp); got != "# Page" {
+					t.Errorf("translation = %q, want %q", got, "# Page")
+				}
+			}
+		})
+	}
+}
+
+func TestWithBlockDetails(t *testing.T) {
+	blocked := fmt.Errorf("failed to translate page 1: %w", asFilteredResponseError(nil, testsupport.BlockedCandidateError(genai.HarmCategoryDangerousContent)))
+	updates := withBlockDetails(map[string]interface{}{"status": models.PageStatusFailed}, blocked)
+	want := map[string]interface{}{
+		"status":        models.PageStatusFailed,
+		"blockReason":   genai.FinishReasonSafety.String(),
+		"blockCategory": genai.HarmCategoryDangerousContent.String(),
+	}
+	if !reflect.DeepEqual(updates, want) {
+		t.Errorf("withBlockDetails() = %v, want %v", updates, want)
+	}
+
+	updates = withBlockDetails(map[string]interface{}{"status": models.PageStatusFailed}, errors.New("connection reset"))
+	if len(updates) != 1 {
+		t.Errorf("withBlockDetails() of an unblocked failure = %v, want the updates unchanged", updates)
+	}
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// UploadFilter selects the uploaded objects the splitter processes. Anything else in the
+// upload bucket, such as zip bundles, thumbnails and folder placeholders, is skipped
+// without creating a document.
+type UploadFilter struct {
+	Prefix string // Only object names starting with Prefix are processed; empty accepts all.
+	Suffix string // Only object names ending in Suffix are processed; empty accepts all.
+}
+
+// skipProcessingMetadataKey is the custom metadata key uploaders set to "true" to store
+// an object in the upload bucket without it being split, sent as the
+// x-goog-meta-skip-processing header.
+const skipProcessingMetadataKey = "skip-processing"
+
+// loadUploadFilter reads INPUT_PREFIX and INPUT_SUFFIX.
+func loadUploadFilter() UploadFilter {
+	return UploadFilter{
+		Prefix: gcp.GetEnv("INPUT_PREFIX", ""),
+		Suffix: gcp.GetEnv("INPUT_SUFFIX", ""),
+	}
+}
+
+// skipName returns why an object is skipped on its name alone, or "" if the name is
+// accepted. It is checked before the object's attributes are read.
+func (u UploadFilter) skipName(name string) string {
+	switch {
+	case strings.HasSuffix(name, "/"):
+		return "folder placeholder"
+	case strings.HasPrefix(path.Base(name), "."), strings.HasPrefix(path.Base(name), "~"):
+		// Editors and sync tools leave lock files such as "~$manual.pdf", and partial
+		// uploads such as ".manual.pdf.part", beside the files they work on.
+		return "temporary or hidden file"
+	case !strings.HasPrefix(name, u.Prefix):
+		return "name outside INPUT_PREFIX"
+	case !strings.HasSuffix(name, u.Suffix):
+		return "name does not match INPUT_SUFFIX"
+	}
+	return ""
+}
+
+// skipAttrs returns why an object is skipped on its attributes, or "" if it is accepted.
+// PDFs must be labelled application/pdf, or carry a generic content type and a .pdf
+// extension, as uploads from some tools do. Objects marked with skipProcessingMetadataKey
+// are skipped whatever their content.
+func (u UploadFilter) skipAttrs(attrs *storage.ObjectAttrs) string {
+	if attrs.Metadata[skipProcessingMetadataKey] == "true" {
+		return "marked " + skipProcessingMetadataKey
+	}
+	if attrs.Size == 0 {
+		return "empty object"
+	}
+	contentType, _, _ := strings.Cut(strings.ToLower(attrs.ContentType), ";")
+	switch strings.TrimSpace(contentType) {
+	case "application/pdf":
+		return ""
+	case "", "application/octet-stream":
+		if strings.HasSuffix(strings.ToLower(attrs.Name), ".pdf") {
+			return ""
+		}
+		return "generic content type without a .pdf extension"
+	}
+	return "content type " + attrs.ContentType + " is not a PDF"
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestUploadFilterSkipName(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   UploadFilter
+		object   string
+		wantSkip string
+	}{
+		{name: "any name", object: "manuals/pump.pdf"},
+		{name: "folder placeholder", object: "manuals/", wantSkip: "folder placeholder"},
+		{name: "folder placeholder inside the prefix", filter: UploadFilter{Prefix: "incoming/"}, object: "incoming/2024/", wantSkip: "folder placeholder"},
+		{name: "office lock file", object: "manuals/~$pump.pdf", wantSkip: "temporary or hidden file"},
+		{name: "partial upload", object: ".pump.pdf.part", wantSkip: "temporary or hidden file"},
+		{name: "hidden file in a folder", object: "manuals/.pump.pdf", wantSkip: "temporary or hidden file"},
+		{name: "hidden folder", object: ".trash/pump.pdf"},
+		{name: "tilde inside the name", object: "manuals/pump~v2.pdf"},
+		{name: "inside the prefix", filter: UploadFilter{Prefix: "incoming/"}, object: "incoming/pump.pdf"},
+		{name: "outside the prefix", filter: UploadFilter{Prefix: "incoming/"}, object: "archive/pump.pdf", wantSkip: "name outside INPUT_PREFIX"},
+		{name: "prefix without its slash", filter: UploadFilter{Prefix: "incoming/"}, object: "incoming.pdf", wantSkip: "name outside INPUT_PREFIX"},
+		{name: "matching suffix", filter: UploadFilter{Suffix: ".pdf"}, object: "pump.pdf"},
+		{name: "other suffix", filter: UploadFilter{Suffix: ".pdf"}, object: "pump.zip", wantSkip: "name does not match INPUT_SUFFIX"},
+		{name: "suffix is case sensitive", filter: UploadFilter{Suffix: ".pdf"}, object: "pump.PDF", wantSkip: "name does not match INPUT_SUFFIX"},
+		{name: "thumbnail", filter: UploadFilter{Prefix: "incoming/", Suffix: ".pdf"}, object: "incoming/pump.pdf.png", wantSkip: "name does not match INPUT_SUFFIX"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.skipName(tt.object); got != tt.wantSkip {
+				t.Errorf("skipName(%q) = %q, want %q", tt.object, got, tt.wantSkip)
+			}
+		})
+	}
+}
+
+func TestUploadFilterSkipAttrs(t *testing.T) {
+	tests := []struct {
+		name     string
+		attrs    storage.ObjectAttrs
+		wantSkip string
+	}{
+		{name: "PDF", attrs: storage.ObjectAttrs{Name: "pump.pdf", Size: 100, ContentType: "application/pdf"}},
+		{name: "PDF with parameters", attrs: storage.ObjectAttrs{Name: "pump", Size: 100, ContentType: "Application/PDF; charset=binary"}},
+		{name: "octet stream with a .pdf extension", attrs: storage.ObjectAttrs{Name: "pump.PDF", Size: 100, ContentType: "application/octet-stream"}},
+		{name: "no content type with a .pdf extension", attrs: storage.ObjectAttrs{Name: "pump.pdf", Size: 100}},
+		{name: "octet stream without a .pdf extension", attrs: storage.ObjectAttrs{Name: "pump.bin", Size: 100, ContentType: "application/octet-stream"}, wantSkip: "generic content type without a .pdf extension"},
+		{name: "no content type without a .pdf extension", attrs: storage.ObjectAttrs{Name: "pump", Size: 100}, wantSkip: "generic content type without a .pdf extension"},
+		{name: "zip bundle", attrs: storage.ObjectAttrs{Name: "pump.zip", Size: 100, ContentType: "application/zip"}, wantSkip: "content type application/zip is not a PDF"},
+		{name: "thumbnail named .pdf", attrs: storage.ObjectAttrs{Name: "pump.pdf", Size: 100, ContentType: "image/png"}, wantSkip: "content type image/png is not a PDF"},
+		{name: "empty object", attrs: storage.ObjectAttrs{Name: "pump.pdf", ContentType: "application/pdf"}, wantSkip: "empty object"},
+		{name: "marked to skip", attrs: storage.ObjectAttrs{Name: "pump.pdf", Size: 100, ContentType: "application/pdf", Metadata: map[string]string{skipProcessingMetadataKey: "true"}}, wantSkip: "marked skip-processing"},
+		{name: "empty object marked to skip", attrs: storage.ObjectAttrs{Name: "pump.pdf", Metadata: map[string]string{skipProcessingMetadataKey: "true"}}, wantSkip: "marked skip-processing"},
+		{name: "marker not true", attrs: storage.ObjectAttrs{Name: "pump.pdf", Size: 100, ContentType: "application/pdf", Metadata: map[string]string{skipProcessingMetadataKey: "false"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (UploadFilter{}).skipAttrs(&tt.attrs); got != tt.wantSkip {
+				t.Errorf("skipAttrs() = %q, want %q", got, tt.wantSkip)
+			}
+		})
+	}
+}
+
+func TestLoadUploadFilter(t *testing.T) {
+	t.Setenv("INPUT_PREFIX", "incoming/")
+	t.Setenv("INPUT_SUFFIX", ".pdf")
+	if got, want := loadUploadFilter(), (UploadFilter{Prefix: "incoming/", Suffix: ".pdf"}); got != want {
+		t.Errorf("loadUploadFilter() = %+v, want %+v", got, want)
+	}
+}
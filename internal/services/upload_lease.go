@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// UploadLeaseConfig sets how the splitter claims an upload before processing it.
+// Eventarc delivers finalize events at least once, and a quick redelivery can reach a
+// second instance while the first is still working, before either has created the
+// document the duplicate check looks for.
+type UploadLeaseConfig struct {
+	Collection string
+	// TTL should exceed the function's timeout, so that a lease only expires early when
+	// its holder crashed.
+	TTL time.Duration
+}
+
+// loadUploadLeaseConfig reads LEASE_COLLECTION and UPLOAD_LEASE_TTL.
+func loadUploadLeaseConfig() (UploadLeaseConfig, error) {
+	config := UploadLeaseConfig{Collection: gcp.GetEnv("LEASE_COLLECTION", "leases")}
+	ttl, err := time.ParseDuration(gcp.GetEnv("UPLOAD_LEASE_TTL", "10m"))
+	if err != nil || ttl <= 0 {
+		return config, fmt.Errorf("UPLOAD_LEASE_TTL must be a positive duration")
+	}
+	config.TTL = ttl
+	return config, nil
+}
+
+// uploadLeaseKey identifies one version of an uploaded object, so that overwriting an
+// upload is processed even while the earlier version's lease is held.
+func uploadLeaseKey(bucket, name string, generation int64) string {
+	return fmt.Sprintf("gs://%s/%s#%d", bucket, name, generation)
+}
+
+// acquireUploadLease claims one version of an uploaded object, failing with
+// gcp.ErrLeaseHeld if another instance is processing it.
+func (f *PDFSplitterFunction) acquireUploadLease(ctx context.Context, bucket, name string, generation int64) (*gcp.Lease, error) {
+	return gcp.AcquireLease(ctx, f.firestoreClient, f.config.UploadLease.Collection, uploadLeaseKey(bucket, name, generation), f.config.UploadLease.TTL)
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+
+	"cloud.google.com/go/firestore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// UploadProgressConfig sets when the splitter starts the workflow and how it reports the
+// upload of split pages.
+type UploadProgressConfig struct {
+	// EarlyStart starts the workflow as soon as the page count is known, while the pages
+	// are still uploading. The document stays SPLITTING until every page is uploaded, and
+	// the translator answers a page that is not there yet with a retryable error.
+	EarlyStart bool
+	// BatchPages is the number of uploaded pages between two increments of the document's
+	// pagesUploaded field.
+	BatchPages int
+	// MaxFailedPages is the most pages whose split files may fail to upload with the
+	// document still handed on to the workflow over the rest. 0 fails the document on any
+	// upload failure.
+	MaxFailedPages int
+}
+
+// loadUploadProgressConfig reads START_WORKFLOW_EARLY, UPLOAD_PROGRESS_BATCH_PAGES and
+// MAX_FAILED_UPLOAD_PAGES.
+func loadUploadProgressConfig() (UploadProgressConfig, error) {
+	batchPages, err := strconv.Atoi(gcp.GetEnv("UPLOAD_PROGRESS_BATCH_PAGES", "25"))
+	if err != nil || batchPages < 1 {
+		return UploadProgressConfig{}, fmt.Errorf("UPLOAD_PROGRESS_BATCH_PAGES must be a positive integer")
+	}
+	maxFailedPages, err := strconv.Atoi(gcp.GetEnv("MAX_FAILED_UPLOAD_PAGES", "0"))
+	if err != nil || maxFailedPages < 0 {
+		return UploadProgressConfig{}, fmt.Errorf("MAX_FAILED_UPLOAD_PAGES must be a non-negative integer")
+	}
+	return UploadProgressConfig{
+		EarlyStart:     gcp.GetEnv("START_WORKFLOW_EARLY", "true") == "true",
+		BatchPages:     batchPages,
+		MaxFailedPages: maxFailedPages,
+	}, nil
+}
+
+// uploadProgress counts a document's uploaded pages into its pagesUploaded field, one
+// increment per batch of pages. It is safe for concurrent use by the upload goroutines.
+// The count is progress reporting only, so failed increments are logged and dropped.
+type uploadProgress struct {
+	docRef  *firestore.DocumentRef
+	batch   int
+	mu      sync.Mutex
+	pending int
+}
+
+func newUploadProgress(docRef *firestore.DocumentRef, batch int) *uploadProgress {
+	return &uploadProgress{docRef: docRef, batch: batch}
+}
+
+// add counts pages as uploaded, writing the count once a batch has accumulated.
+func (p *uploadProgress) add(ctx context.Context, logCtx *slog.Logger, pages int) {
+	p.mu.Lock()
+	p.pending += pages
+	if p.pending < p.batch {
+		p.mu.Unlock()
+		return
+	}
+	n := p.pending
+	p.pending = 0
+	p.mu.Unlock()
+	p.write(ctx, logCtx, n)
+}
+
+// flush writes the pages counted since the last batch.
+func (p *uploadProgress) flush(ctx context.Context, logCtx *slog.Logger) {
+	p.mu.Lock()
+	n := p.pending
+	p.pending = 0
+	p.mu.Unlock()
+	if n > 0 {
+		p.write(ctx, logCtx, n)
+	}
+}
+
+func (p *uploadProgress) write(ctx context.Context, logCtx *slog.Logger, n int) {
+	if _, err := p.docRef.Update(ctx, []firestore.Update{{Path: "pagesUploaded", Value: firestore.Increment(n)}}); err != nil {
+		logCtx.Warn("Failed to record upload progress", "error", err, "pages", n)
+	}
+}
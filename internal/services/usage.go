@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// tokenUsageFrom returns the token counts reported on a Gemini response, or nil when the
+// response carries no usage metadata.
+func tokenUsageFrom(resp *genai.GenerateContentResponse) *models.TokenUsage {
+	if resp == nil || resp.UsageMetadata == nil {
+		return nil
+	}
+	return &models.TokenUsage{
+		PromptTokens:     int64(resp.UsageMetadata.PromptTokenCount),
+		CandidatesTokens: int64(resp.UsageMetadata.CandidatesTokenCount),
+		TotalTokens:      int64(resp.UsageMetadata.TotalTokenCount),
+	}
+}
+
+// addTokenUsage returns the sum of total and usage, either of which may be nil.
+func addTokenUsage(total, usage *models.TokenUsage) *models.TokenUsage {
+	if usage == nil {
+		return total
+	}
+	if total == nil {
+		return &models.TokenUsage{PromptTokens: usage.PromptTokens, CandidatesTokens: usage.CandidatesTokens, TotalTokens: usage.TotalTokens}
+	}
+	return &models.TokenUsage{
+		PromptTokens:     total.PromptTokens + usage.PromptTokens,
+		CandidatesTokens: total.CandidatesTokens + usage.CandidatesTokens,
+		TotalTokens:      total.TotalTokens + usage.TotalTokens,
+	}
+}
+
+// recordTokenUsage adds usage to the document's running total for stage. Increments are
+// applied server-side, so concurrent page translations accumulate correctly.
+func recordTokenUsage(ctx context.Context, client *firestore.Client, collection, docID, stage string, usage *models.TokenUsage) error {
+	if usage == nil {
+		return nil
+	}
+	prefix := "tokenUsage." + stage + "."
+	updates := []firestore.Update{
+		{Path: prefix + "promptTokens", Value: firestore.Increment(usage.PromptTokens)},
+		{Path: prefix + "candidatesTokens", Value: firestore.Increment(usage.CandidatesTokens)},
+		{Path: prefix + "totalTokens", Value: firestore.Increment(usage.TotalTokens)},
+	}
+	if _, err := client.Collection(collection).Doc(docID).Update(ctx, updates); err != nil {
+		return fmt.Errorf("failed to record %s token usage: %w", stage, err)
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	executions "cloud.google.com/go/workflows/executions/apiv1"
+	"cloud.google.com/go/workflows/executions/apiv1/executionspb"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/gcp/objectstore"
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+// StatusPagesReadyWorkflowFailed is recorded on a document whose pages are split and
+// uploaded but whose processing workflow could not be started. The workflow retrigger
+// service starts it again from the split pages.
+const StatusPagesReadyWorkflowFailed = "PAGES_READY_WORKFLOW_FAILED"
+
+// WorkflowRetryConfig controls how failures to start a workflow execution are retried.
+type WorkflowRetryConfig struct {
+	MaxRetries int           // Retries after the first attempt; 0 disables retrying.
+	BaseDelay  time.Duration // Backoff before the first retry, doubled on each one after.
+}
+
+// loadWorkflowRetryConfig reads WORKFLOW_TRIGGER_MAX_RETRIES and
+// WORKFLOW_TRIGGER_RETRY_BASE_MS.
+func loadWorkflowRetryConfig() (WorkflowRetryConfig, error) {
+	maxRetries, err := strconv.Atoi(gcp.GetEnv("WORKFLOW_TRIGGER_MAX_RETRIES", "3"))
+	if err != nil || maxRetries < 0 {
+		return WorkflowRetryConfig{}, fmt.Errorf("WORKFLOW_TRIGGER_MAX_RETRIES must be a non-negative integer")
+	}
+	baseMS, err := strconv.Atoi(gcp.GetEnv("WORKFLOW_TRIGGER_RETRY_BASE_MS", "1000"))
+	if err != nil || baseMS <= 0 {
+		return WorkflowRetryConfig{}, fmt.Errorf("WORKFLOW_TRIGGER_RETRY_BASE_MS must be a positive integer")
+	}
+	return WorkflowRetryConfig{MaxRetries: maxRetries, BaseDelay: time.Duration(baseMS) * time.Millisecond}, nil
+}
+
+// ProcessingOrderConfig is the page dispatch order suggested to the workflow.
+type ProcessingOrderConfig struct {
+	Strategy string // One of the ProcessingOrder* strategies.
+	Stripes  int    // Number of interleaved ranges for the "stripe" order.
+	Seed     string // Optional fixed seed for the "shuffle" order.
+}
+
+// loadProcessingOrderConfig reads PROCESSING_ORDER, PROCESSING_ORDER_STRIPES and
+// PROCESSING_ORDER_SEED.
+func loadProcessingOrderConfig() (ProcessingOrderConfig, error) {
+	config := ProcessingOrderConfig{
+		Strategy: gcp.GetEnv("PROCESSING_ORDER", ProcessingOrderSequential),
+		Seed:     gcp.GetEnv("PROCESSING_ORDER_SEED", ""),
+	}
+	stripes, err := strconv.Atoi(gcp.GetEnv("PROCESSING_ORDER_STRIPES", "4"))
+	if err != nil {
+		return config, fmt.Errorf("PROCESSING_ORDER_STRIPES must be an integer: %w", err)
+	}
+	config.Stripes = stripes
+	if _, err := processingOrder(0, config.Strategy, config.Stripes, 0); err != nil {
+		return config, fmt.Errorf("invalid PROCESSING_ORDER configuration: %w", err)
+	}
+	if config.Seed != "" {
+		if _, err := strconv.ParseInt(config.Seed, 10, 64); err != nil {
+			return config, fmt.Errorf("PROCESSING_ORDER_SEED must be an integer: %w", err)
+		}
+	}
+	return config, nil
+}
+
+// Order returns the suggested dispatch order of count units for a file.
+func (c ProcessingOrderConfig) Order(count int, fileHash string) ([]int, error) {
+	return processingOrder(count, c.Strategy, c.Stripes, c.seedFor(fileHash))
+}
+
+// RepairOrder is Order for a document run again: the split files whose pages were
+// attempted least, by attempts keyed by page number, go first, so that pages starved of
+// quota by the earlier runs are not starved again.
+func (c ProcessingOrderConfig) RepairOrder(doc *models.Document, attempts map[int]int) ([]int, error) {
+	units := documentUnits(doc)
+	order, err := c.Order(len(units), doc.FileHash)
+	if err != nil {
+		return nil, err
+	}
+	return prioritizeByAttempts(order, units, attempts), nil
+}
+
+// seedFor returns the configured shuffle seed, or one derived from the file hash so
+// that every trigger for the same file yields the same order.
+func (c ProcessingOrderConfig) seedFor(fileHash string) int64 {
+	if c.Seed != "" {
+		seed, _ := strconv.ParseInt(c.Seed, 10, 64)
+		return seed
+	}
+	if len(fileHash) < 16 {
+		return 0
+	}
+	seed, _ := strconv.ParseUint(fileHash[:16], 16, 64)
+	return int64(seed)
+}
+
+// workflowParent returns the resource name of the processing workflow.
+func workflowParent(projectID, location, workflowID string) string {
+	return fmt.Sprintf("projects/%s/locations/%s/workflows/%s", projectID, location, workflowID)
+}
+
+// workflowPayload builds the argument of a processing workflow execution over the split
+// files of manifest. With chunking the workflow dispatches chunks rather than pages, so
+// order is over 1-based chunk indices into Chunks. Up to inlinePagesMax pages are listed
+// in the argument itself; a longer document's manifest is written to splitPages and
+// passed by URI instead, keeping the argument within the Workflows size limit.
+func workflowPayload(ctx context.Context, splitPages objectstore.Bucket, manifest *models.PageManifest, sourceGeneration int64, order []int, inlinePagesMax int) (*models.WorkflowPayload, error) {
+	payload := &models.WorkflowPayload{
+		DocumentID:       manifest.DocumentID,
+		PageCount:        manifest.PageCount,
+		TenantID:         manifest.TenantID,
+		ProcessingOrder:  order,
+		SourceGeneration: sourceGeneration,
+		Traceparent:      gcp.Traceparent(ctx),
+	}
+	if manifest.ChunkSize > 1 {
+		payload.ChunkSize = manifest.ChunkSize
+		for _, chunk := range pageChunks(manifest.PageCount, manifest.ChunkSize) {
+			payload.Chunks = append(payload.Chunks, models.WorkflowChunk{
+				PageRange: models.PageRange{StartPage: chunk.StartPage, EndPage: chunk.EndPage},
+				GCSUri:    manifest.GCSUriFor(chunk.StartPage),
+			})
+		}
+	}
+	if len(manifest.Pages) <= inlinePagesMax {
+		payload.Pages = manifest.Pages
+		return payload, nil
+	}
+	manifestGCSUri, err := writePageManifest(ctx, splitPages, manifest)
+	if err != nil {
+		return nil, err
+	}
+	payload.ManifestGCSUri = manifestGCSUri
+	return payload, nil
+}
+
+// startWorkflowExecution starts an execution of the workflow named by parent.
+func startWorkflowExecution(ctx context.Context, client *executions.Client, parent string, payload *models.WorkflowPayload) (*executionspb.Execution, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal workflow payload: %w", err)
+	}
+	execution, err := client.CreateExecution(ctx, &executionspb.CreateExecutionRequest{
+		Parent:    parent,
+		Execution: &executionspb.Execution{Argument: string(payloadBytes)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workflow execution: %w", err)
+	}
+	return execution, nil
+}
+
+// startWorkflowExecutionWithRetry starts an execution like startWorkflowExecution,
+// retrying failures with jittered exponential backoff. IAM changes take a while to
+// propagate, so permission errors are retried too; a missing workflow or a rejected
+// argument is not.
+func startWorkflowExecutionWithRetry(ctx context.Context, logCtx *slog.Logger, client *executions.Client, parent string, payload *models.WorkflowPayload, cfg WorkflowRetryConfig) (*executionspb.Execution, error) {
+	for attempt := 1; ; attempt++ {
+		execution, err := startWorkflowExecution(ctx, client, parent, payload)
+		if err == nil {
+			return execution, nil
+		}
+		if attempt > cfg.MaxRetries || !isRetryableWorkflowError(err) || ctx.Err() != nil {
+			return nil, fmt.Errorf("gave up after %d attempt(s): %w", attempt, err)
+		}
+
+		delay := geminiBackoff(cfg.BaseDelay, attempt)
+		logCtx.Warn("Failed to start workflow execution; retrying.", "error", err, "attempt", attempt, "backoff", delay.String())
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("gave up retrying after %d attempts: %w", attempt, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// isRetryableWorkflowError reports whether starting an execution may succeed if tried
+// again.
+func isRetryableWorkflowError(err error) bool {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.NotFound, codes.FailedPrecondition, codes.Unimplemented:
+		return false
+	default:
+		return true
+	}
+}
+
+// findActiveExecution returns the name of an ACTIVE execution of the workflow whose
+// argument names docID, or "" when there is none.
+func findActiveExecution(ctx context.Context, client *executions.Client, parent, docID string) (string, error) {
+	it := client.ListExecutions(ctx, &executionspb.ListExecutionsRequest{
+		Parent: parent,
+		View:   executionspb.ExecutionView_FULL,
+		Filter: `state="ACTIVE"`,
+	})
+	for {
+		execution, err := it.Next()
+		if err == iterator.Done {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to list active workflow executions: %w", err)
+		}
+		var argument struct {
+			DocumentID string `json:"documentId"`
+		}
+		if err := json.Unmarshal([]byte(execution.GetArgument()), &argument); err != nil {
+			continue
+		}
+		if argument.DocumentID == docID {
+			return execution.GetName(), nil
+		}
+	}
+}
+
+// sameExecution reports whether executionID, as passed to a worker, identifies the
+// recorded execution. Workers receive the short execution ID while the document records
+// the full resource name, so either form is accepted.
+func sameExecution(recorded, executionID string) bool {
+	return recorded == executionID || path.Base(recorded) == executionID
+}
+
+// ErrStaleExecution is wrapped by the INVALID_REQUEST error a stage refuses a request
+// with when it comes from a workflow execution other than the document's.
+var ErrStaleExecution = errors.New("stale workflow execution")
+
+// checkExecution refuses a request from an execution other than the one recorded on the
+// document, which indicates a double-triggered workflow: only the recorded execution may
+// move the document on. It is called before a stage records anything on the document, so
+// the refusal leaves the recorded execution's progress as is. A request without an
+// execution ID, a document without one, and a document that cannot be read are accepted,
+// as a manual run or a failed lookup says nothing about a second execution.
+func checkExecution(ctx context.Context, logCtx *slog.Logger, client *firestore.Client, collection, docID, executionID string) error {
+	if executionID == "" {
+		return nil
+	}
+	snap, err := client.Collection(collection).Doc(docID).Get(ctx)
+	if err != nil {
+		logCtx.Warn("Could not read document to validate the execution ID", "error", err)
+		return nil
+	}
+	var doc models.Document
+	if err := snap.DataTo(&doc); err != nil {
+		logCtx.Warn("Could not decode document to validate the execution ID", "error", err)
+		return nil
+	}
+	if err := staleExecutionError(docID, doc.WorkflowExecutionID, executionID); err != nil {
+		logCtx.Warn("Refusing a request from a stale or duplicate workflow execution.", "recordedExecutionId", doc.WorkflowExecutionID)
+		return err
+	}
+	return nil
+}
+
+// staleExecutionError returns the INVALID_REQUEST error wrapping ErrStaleExecution if
+// executionID is not the recorded execution of document docID, or nil.
+func staleExecutionError(docID, recorded, executionID string) error {
+	if executionID == "" || recorded == "" || sameExecution(recorded, executionID) {
+		return nil
+	}
+	return models.WithCode(models.ErrorCodeInvalidRequest, fmt.Errorf("%w: %s is processed by execution %s, not %s", ErrStaleExecution, docID, recorded, executionID))
+}
@@ -0,0 +1,37 @@
+//go:build integration
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/testsupport"
+)
+
+func TestCheckExecutionRefusesStaleExecution(t *testing.T) {
+	ctx := context.Background()
+	firestoreClient, _ := testsupport.RequireEmulators(t).Clients(ctx, t)
+	collection := fmt.Sprintf("documents-%d", time.Now().UnixNano())
+	logCtx := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if _, err := firestoreClient.Collection(collection).Doc("doc-1").Set(ctx, map[string]interface{}{
+		"workflowExecutionId": "projects/p/locations/l/workflows/w/executions/exec-1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkExecution(ctx, logCtx, firestoreClient, collection, "doc-1", "exec-1"); err != nil {
+		t.Errorf("checkExecution() of the recorded execution = %v, want nil", err)
+	}
+	if err := checkExecution(ctx, logCtx, firestoreClient, collection, "doc-1", "exec-2"); !errors.Is(err, ErrStaleExecution) {
+		t.Errorf("checkExecution() of another execution = %v, want ErrStaleExecution", err)
+	}
+	if err := checkExecution(ctx, logCtx, firestoreClient, collection, "doc-missing", "exec-2"); err != nil {
+		t.Errorf("checkExecution() of a missing document = %v, want nil", err)
+	}
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+func TestStaleExecutionError(t *testing.T) {
+	const recorded = "projects/p/locations/l/workflows/w/executions/exec-1"
+	tests := []struct {
+		name        string
+		recorded    string
+		executionID string
+		wantStale   bool
+	}{
+		{name: "full name", recorded: recorded, executionID: recorded},
+		{name: "short ID", recorded: recorded, executionID: "exec-1"},
+		{name: "other execution", recorded: recorded, executionID: "exec-2", wantStale: true},
+		{name: "other execution by full name", recorded: recorded, executionID: "projects/p/locations/l/workflows/w/executions/exec-2", wantStale: true},
+		{name: "manual run without an execution", recorded: recorded},
+		{name: "document without an execution", executionID: "exec-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := staleExecutionError("doc-1", tt.recorded, tt.executionID)
+			if !tt.wantStale {
+				if err != nil {
+					t.Fatalf("staleExecutionError() = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrStaleExecution) {
+				t.Fatalf("staleExecutionError() = %v, want ErrStaleExecution", err)
+			}
+			if code := models.CodeOf(err); code != models.ErrorCodeInvalidRequest {
+				t.Errorf("error code = %q, want %q", code, models.ErrorCodeInvalidRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package testsupport
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FakeRedactor is a gcp.Redactor that replaces fixed strings, standing in for Cloud DLP.
+// It records the text and location of every call.
+type FakeRedactor struct {
+	// Findings maps each string to redact to the info type it is replaced by, such as
+	// "jane@example.com" to "EMAIL_ADDRESS".
+	Findings map[string]string
+	// Err, when set, is returned by every call.
+	Err error
+
+	mu    sync.Mutex
+	calls []RedactCall
+}
+
+// RedactCall is one call received by a FakeRedactor.
+type RedactCall struct {
+	Text     string
+	Location string
+}
+
+// Redact implements gcp.Redactor. Longer strings are replaced first, so that a finding
+// containing another is redacted whole.
+func (r *FakeRedactor) Redact(ctx context.Context, text, location string) (string, int, error) {
+	r.mu.Lock()
+	r.calls = append(r.calls, RedactCall{Text: text, Location: location})
+	r.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return "", 0, err
+	}
+	if r.Err != nil {
+		return "", 0, r.Err
+	}
+	values := make([]string, 0, len(r.Findings))
+	for value := range r.Findings {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	findings := 0
+	for _, value := range values {
+		findings += strings.Count(text, value)
+		text = strings.ReplaceAll(text, value, "["+r.Findings[value]+"]")
+	}
+	return text, findings, nil
+}
+
+// Calls returns every call made so far.
+func (r *FakeRedactor) Calls() []RedactCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RedactCall(nil), r.calls...)
+}
@@ -0,0 +1,104 @@
+package testsupport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// Emulators locates the Firestore emulator and the fake Cloud Storage server that
+// integration tests run the services against. The Cloud client libraries connect to them
+// through FIRESTORE_EMULATOR_HOST and STORAGE_EMULATOR_HOST; docker-compose.yml in this
+// directory starts both on the ports it expects:
+//
+//	docker compose -f internal/testsupport/docker-compose.yml up -d
+//	FIRESTORE_EMULATOR_HOST=localhost:8081 STORAGE_EMULATOR_HOST=http://localhost:4443 \
+//		go test -tags integration ./...
+type Emulators struct {
+	FirestoreHost string
+	StorageHost   string
+	ProjectID     string
+}
+
+// EmulatorProjectID is the project the emulators are used with.
+const EmulatorProjectID = "integration-test"
+
+// RequireEmulators returns the emulators named by the environment, skipping the test
+// unless both are set and reachable, so that integration tests pass on machines without
+// them.
+func RequireEmulators(tb testing.TB) Emulators {
+	tb.Helper()
+	e := Emulators{
+		FirestoreHost: os.Getenv("FIRESTORE_EMULATOR_HOST"),
+		StorageHost:   os.Getenv("STORAGE_EMULATOR_HOST"),
+		ProjectID:     EmulatorProjectID,
+	}
+	if e.FirestoreHost == "" || e.StorageHost == "" {
+		tb.Skip("FIRESTORE_EMULATOR_HOST and STORAGE_EMULATOR_HOST must be set to run integration tests")
+	}
+	if err := waitReachable(tb, "http://"+e.FirestoreHost); err != nil {
+		tb.Skipf("Firestore emulator is not reachable: %v", err)
+	}
+	if err := waitReachable(tb, e.StorageHost+"/storage/v1/b"); err != nil {
+		tb.Skipf("Cloud Storage emulator is not reachable: %v", err)
+	}
+	return e
+}
+
+// waitReachable polls url for up to ten seconds, giving emulators started alongside the
+// tests time to come up.
+func waitReachable(tb testing.TB, url string) error {
+	tb.Helper()
+	client := &http.Client{Timeout: time.Second}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// Clients returns Firestore and Cloud Storage clients of the emulators, closed when the
+// test ends.
+func (e Emulators) Clients(ctx context.Context, tb testing.TB) (*firestore.Client, *storage.Client) {
+	tb.Helper()
+	firestoreClient, err := firestore.NewClient(ctx, e.ProjectID)
+	if err != nil {
+		tb.Fatalf("failed to create Firestore emulator client: %v", err)
+	}
+	tb.Cleanup(func() { firestoreClient.Close() })
+	storageClient, err := storage.NewClient(ctx, option.WithoutAuthentication())
+	if err != nil {
+		tb.Fatalf("failed to create Cloud Storage emulator client: %v", err)
+	}
+	tb.Cleanup(func() { storageClient.Close() })
+	return firestoreClient, storageClient
+}
+
+// CreateBuckets creates each bucket, named with a suffix unique to the test, and returns
+// the names in order, so that tests sharing the emulator do not see each other's objects.
+func (e Emulators) CreateBuckets(ctx context.Context, tb testing.TB, client *storage.Client, names ...string) []string {
+	tb.Helper()
+	suffix := fmt.Sprintf("-%d", time.Now().UnixNano())
+	created := make([]string, len(names))
+	for i, name := range names {
+		created[i] = name + suffix
+		if err := client.Bucket(created[i]).Create(ctx, e.ProjectID, nil); err != nil {
+			tb.Fatalf("failed to create bucket %s: %v", created[i], err)
+		}
+	}
+	return created
+}
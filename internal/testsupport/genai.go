@@ -0,0 +1,117 @@
+// Package testsupport provides in-memory fakes of the GCP dependencies used by the services,
+// and the emulator setup, fixtures and request builders of their integration tests.
+package testsupport
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// FakeResult is one scripted outcome of a FakeContentGenerator call.
+type FakeResult struct {
+	Response *genai.GenerateContentResponse
+	Err      error
+}
+
+// FakeContentGenerator is a gcp.ContentGenerator that replays scripted results in order
+// and records the parts of every call it receives. Once the script is exhausted the last
+// result is repeated.
+type FakeContentGenerator struct {
+	mu      sync.Mutex
+	results []FakeResult
+	calls   [][]genai.Part
+}
+
+// NewFakeContentGenerator returns a fake that replays results in order.
+func NewFakeContentGenerator(results ...FakeResult) *FakeContentGenerator {
+	return &FakeContentGenerator{results: results}
+}
+
+// GenerateContent implements gcp.ContentGenerator.
+func (g *FakeContentGenerator) GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.calls = append(g.calls, parts)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(g.results) == 0 {
+		return nil, fmt.Errorf("testsupport: no scripted result for call %d", len(g.calls))
+	}
+	index := len(g.calls) - 1
+	if index >= len(g.results) {
+		index = len(g.results) - 1
+	}
+	return g.results[index].Response, g.results[index].Err
+}
+
+// Calls returns the parts of every call made so far.
+func (g *FakeContentGenerator) Calls() [][]genai.Part {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([][]genai.Part(nil), g.calls...)
+}
+
+// FakeTokenCounter is a gcp.TokenCounter that counts Tokens for every call, or fails with
+// Err, and records the parts of every call it receives.
+type FakeTokenCounter struct {
+	Tokens int32
+	Err    error
+
+	mu    sync.Mutex
+	calls [][]genai.Part
+}
+
+// CountTokens implements gcp.TokenCounter.
+func (c *FakeTokenCounter) CountTokens(ctx context.Context, parts ...genai.Part) (*genai.CountTokensResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, parts)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	return &genai.CountTokensResponse{TotalTokens: c.Tokens}, nil
+}
+
+// Calls returns the parts of every call made so far.
+func (c *FakeTokenCounter) Calls() [][]genai.Part {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]genai.Part(nil), c.calls...)
+}
+
+// TextResponse builds a single-candidate response whose content is the given text parts.
+func TextResponse(texts ...string) *genai.GenerateContentResponse {
+	parts := make([]genai.Part, 0, len(texts))
+	for _, text := range texts {
+		parts = append(parts, genai.Text(text))
+	}
+	return &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{Content: &genai.Content{Role: "model", Parts: parts}}},
+	}
+}
+
+// BlockedResponse builds a response whose prompt was blocked, leaving no candidates.
+func BlockedResponse(reason genai.BlockedReason) *genai.GenerateContentResponse {
+	return &genai.GenerateContentResponse{
+		PromptFeedback: &genai.PromptFeedback{BlockReason: reason},
+	}
+}
+
+// BlockedCandidateError builds the error the genai client returns when the output was
+// stopped by the safety filters, with a rating of category marked as blocking it.
+func BlockedCandidateError(category genai.HarmCategory) error {
+	return &genai.BlockedError{Candidate: &genai.Candidate{
+		FinishReason: genai.FinishReasonSafety,
+		SafetyRatings: []*genai.SafetyRating{
+			{Category: genai.HarmCategoryHarassment, Probability: genai.HarmProbabilityNegligible},
+			{Category: category, Probability: genai.HarmProbabilityHigh, Blocked: true},
+		},
+	}}
+}
@@ -0,0 +1,46 @@
+package testsupport
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// FixturePDF builds a PDF of pageCount letter-size pages, page N reading "Page N", for
+// tests that upload a document to the splitter. It is generated rather than checked in so
+// tests can pick the page count.
+func FixturePDF(pageCount int) []byte {
+	// Objects 1 and 2 are the catalog and page tree, 3 the font, and each page is followed
+	// by its content stream.
+	var objects []string
+	kids := ""
+	for page := 1; page <= pageCount; page++ {
+		kids += fmt.Sprintf("%d 0 R ", 4+2*(page-1))
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids, pageCount),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	)
+	for page := 1; page <= pageCount; page++ {
+		content := fmt.Sprintf("BT /F1 24 Tf 72 720 Td (Page %d) Tj ET", page)
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", 5+2*(page-1)),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
@@ -0,0 +1,53 @@
+package testsupport
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// FakePubSub is an in-process Pub/Sub server for tests of the Pub/Sub orchestration mode.
+// It keeps every message published to it, delivered or not.
+type FakePubSub struct {
+	server *pstest.Server
+}
+
+// NewFakePubSub starts a fake Pub/Sub server, stopped when the test ends.
+func NewFakePubSub(tb testing.TB) *FakePubSub {
+	tb.Helper()
+	server := pstest.NewServer()
+	tb.Cleanup(func() { server.Close() })
+	return &FakePubSub{server: server}
+}
+
+// Client returns a Pub/Sub client of the fake for the emulator project, closed when the
+// test ends.
+func (p *FakePubSub) Client(ctx context.Context, tb testing.TB) *pubsub.Client {
+	tb.Helper()
+	conn, err := grpc.NewClient(p.server.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		tb.Fatalf("failed to dial the fake Pub/Sub server: %v", err)
+	}
+	client, err := pubsub.NewClient(ctx, EmulatorProjectID, option.WithGRPCConn(conn))
+	if err != nil {
+		tb.Fatalf("failed to create fake Pub/Sub client: %v", err)
+	}
+	tb.Cleanup(func() { client.Close() })
+	return client
+}
+
+// Messages returns the messages published so far to the topic with ID topicID.
+func (p *FakePubSub) Messages(topicID string) []*pstest.Message {
+	var messages []*pstest.Message
+	for _, message := range p.server.Messages() {
+		if message.Topic == "projects/"+EmulatorProjectID+"/topics/"+topicID {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
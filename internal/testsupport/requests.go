@@ -0,0 +1,42 @@
+package testsupport
+
+import (
+	"fmt"
+
+	"github.com/Lllllllleong/engineeringdocumentflow/internal/models"
+)
+
+// ExecutionID is the workflow execution the request builders claim to come from.
+const ExecutionID = "projects/integration-test/locations/us-central1/workflows/test/executions/test"
+
+// GCSURI returns the gs:// URI of an object.
+func GCSURI(bucket, object string) string {
+	return fmt.Sprintf("gs://%s/%s", bucket, object)
+}
+
+// TranslatorRequest builds the translator request for a single page, whose split PDF is
+// at gcsURI.
+func TranslatorRequest(docID string, page int, gcsURI string) *models.PageTranslatorRequest {
+	return &models.PageTranslatorRequest{
+		DocumentID:  docID,
+		PageNumber:  page,
+		GCSUri:      gcsURI,
+		ExecutionID: ExecutionID,
+	}
+}
+
+// AggregatorRequest builds the aggregator request for a document.
+func AggregatorRequest(docID string) *models.MarkdownAggregatorRequest {
+	return &models.MarkdownAggregatorRequest{DocumentID: docID, ExecutionID: ExecutionID}
+}
+
+// CleanerRequest builds the cleaner request for the master file at masterGCSURI.
+func CleanerRequest(docID, masterGCSURI string) *models.MarkdownCleanerRequest {
+	return &models.MarkdownCleanerRequest{DocumentID: docID, MasterGCSUri: masterGCSURI, ExecutionID: ExecutionID}
+}
+
+// SectionSplitterRequest builds the section splitter request for the cleaned markdown
+// at cleanedGCSURI.
+func SectionSplitterRequest(docID, cleanedGCSURI string) *models.SectionSplitterRequest {
+	return &models.SectionSplitterRequest{DocumentID: docID, CleanedGCSUri: cleanedGCSURI, ExecutionID: ExecutionID}
+}
@@ -0,0 +1,115 @@
+package testsupport
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+
+	executions "cloud.google.com/go/workflows/executions/apiv1"
+	"cloud.google.com/go/workflows/executions/apiv1/executionspb"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// FakeWorkflows is an in-process Workflows Executions server that records the executions
+// the splitter starts, naming each ExecutionID, without running them, and the executions
+// cancelled. Tests play the workflow's part by calling the stages themselves.
+type FakeWorkflows struct {
+	executionspb.UnimplementedExecutionsServer
+	mu        sync.Mutex
+	requests  []*executionspb.CreateExecutionRequest
+	cancelled []string
+	addr      string
+}
+
+// NewFakeWorkflows starts a fake Workflows Executions server, stopped when the test ends.
+func NewFakeWorkflows(tb testing.TB) *FakeWorkflows {
+	tb.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("failed to listen for the fake Workflows server: %v", err)
+	}
+	w := &FakeWorkflows{addr: listener.Addr().String()}
+	server := grpc.NewServer()
+	executionspb.RegisterExecutionsServer(server, w)
+	go server.Serve(listener)
+	tb.Cleanup(server.Stop)
+	return w
+}
+
+// Client returns an Executions client of the fake, closed when the test ends.
+func (w *FakeWorkflows) Client(ctx context.Context, tb testing.TB) *executions.Client {
+	tb.Helper()
+	client, err := executions.NewClient(ctx,
+		option.WithEndpoint(w.addr),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	)
+	if err != nil {
+		tb.Fatalf("failed to create fake Workflows client: %v", err)
+	}
+	tb.Cleanup(func() { client.Close() })
+	return client
+}
+
+// CreateExecution implements executionspb.ExecutionsServer.
+func (w *FakeWorkflows) CreateExecution(ctx context.Context, req *executionspb.CreateExecutionRequest) (*executionspb.Execution, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.requests = append(w.requests, req)
+	return &executionspb.Execution{
+		Name:     ExecutionID,
+		Argument: req.GetExecution().GetArgument(),
+		State:    executionspb.Execution_ACTIVE,
+	}, nil
+}
+
+// Requests returns every execution started so far.
+func (w *FakeWorkflows) Requests() []*executionspb.CreateExecutionRequest {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]*executionspb.CreateExecutionRequest(nil), w.requests...)
+}
+
+// ListExecutions implements executionspb.ExecutionsServer, listing every execution started
+// so far, in one page, as active until it is cancelled. Filters are ignored.
+func (w *FakeWorkflows) ListExecutions(ctx context.Context, req *executionspb.ListExecutionsRequest) (*executionspb.ListExecutionsResponse, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	res := &executionspb.ListExecutionsResponse{}
+	if slices.Contains(w.cancelled, ExecutionID) {
+		return res, nil
+	}
+	for _, created := range w.requests {
+		res.Executions = append(res.Executions, &executionspb.Execution{
+			Name:     ExecutionID,
+			Argument: created.GetExecution().GetArgument(),
+			State:    executionspb.Execution_ACTIVE,
+		})
+	}
+	return res, nil
+}
+
+// CancelExecution implements executionspb.ExecutionsServer. Any execution can be
+// cancelled, once.
+func (w *FakeWorkflows) CancelExecution(ctx context.Context, req *executionspb.CancelExecutionRequest) (*executionspb.Execution, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if slices.Contains(w.cancelled, req.GetName()) {
+		return nil, status.Errorf(codes.FailedPrecondition, "execution %s is already cancelled", req.GetName())
+	}
+	w.cancelled = append(w.cancelled, req.GetName())
+	return &executionspb.Execution{Name: req.GetName(), State: executionspb.Execution_CANCELLED}, nil
+}
+
+// Cancelled returns the name of every execution cancelled so far.
+func (w *FakeWorkflows) Cancelled() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return slices.Clone(w.cancelled)
+}
@@ -23,8 +23,42 @@ FUNCTIONS=(
   "markdown-aggregator"
   "markdown-cleaner"
   "section-splitter"
+  "tenant-admin"
+  "revision-differ"
+  "document-status"
+  "document-admin"
+  "document-reprocessor"
+  "workflow-retrigger"
+  "page-retranslator"
+  "document-uploader"
+  "stuck-sweeper"
 )
 
+# --- The Pub/Sub orchestration mode chains the stages through topics ---
+if [ "${ORCHESTRATION_MODE}" = "pubsub" ]; then
+  FUNCTIONS+=("pipeline-coordinator")
+  for TOPIC in "${TRANSLATE_TOPIC}" "${AGGREGATE_TOPIC}" "${CLEAN_TOPIC}" "${SECTION_SPLIT_TOPIC}" "${COORDINATOR_TOPIC}"; do
+    gcloud pubsub topics describe "${TOPIC}" >/dev/null 2>&1 || gcloud pubsub topics create "${TOPIC}" --quiet
+  done
+fi
+
+# deploy_pubsub_entry deploys the Pub/Sub entry point $1 of the function being built,
+# triggered by topic $2, when ORCHESTRATION_MODE is pubsub.
+deploy_pubsub_entry() {
+  if [ "${ORCHESTRATION_MODE}" != "pubsub" ]; then
+    return
+  fi
+  gcloud functions deploy "$1" \
+    --gen2 \
+    --runtime=go123 \
+    --region="${REGION}" \
+    --source="${BUILD_DIR}" \
+    --entry-point="$1" \
+    --trigger-topic="$2" \
+    --service-account="${SERVICE_ACCOUNT_EMAIL}" \
+    --quiet
+}
+
 # --- Define the project's Go module path from go.mod ---
 # This is the key to solving the error.
 MODULE_PATH="github.com/Lllllllleong/engineeringdocumentflow"
@@ -85,6 +119,7 @@ for FUNCTION_NAME in "${FUNCTIONS[@]}"; do
         --no-allow-unauthenticated \
         --service-account="${SERVICE_ACCOUNT_EMAIL}" \
         --quiet
+      deploy_pubsub_entry TranslatePageFromPubSub "${TRANSLATE_TOPIC}"
       ;;
     "markdown-aggregator")
       gcloud functions deploy HandleAggregateMarkdown \
@@ -97,6 +132,7 @@ for FUNCTION_NAME in "${FUNCTIONS[@]}"; do
         --no-allow-unauthenticated \
         --service-account="${SERVICE_ACCOUNT_EMAIL}" \
         --quiet
+      deploy_pubsub_entry AggregateMarkdownFromPubSub "${AGGREGATE_TOPIC}"
       ;;
     "markdown-cleaner")
       gcloud functions deploy HandleCleanMarkdown \
@@ -109,6 +145,7 @@ for FUNCTION_NAME in "${FUNCTIONS[@]}"; do
         --no-allow-unauthenticated \
         --service-account="${SERVICE_ACCOUNT_EMAIL}" \
         --quiet
+      deploy_pubsub_entry CleanMarkdownFromPubSub "${CLEAN_TOPIC}"
       ;;
     "section-splitter")
       gcloud functions deploy HandleSplitSections \
@@ -121,29 +158,151 @@ for FUNCTION_NAME in "${FUNCTIONS[@]}"; do
         --no-allow-unauthenticated \
         --service-account="${SERVICE_ACCOUNT_EMAIL}" \
         --quiet
+      deploy_pubsub_entry SplitSectionsFromPubSub "${SECTION_SPLIT_TOPIC}"
+      ;;
+    "tenant-admin")
+      gcloud functions deploy HandleTenantAdmin \
+        --gen2 \
+        --runtime=go123 \
+        --region="${REGION}" \
+        --source="${BUILD_DIR}" \
+        --entry-point=handleTenantAdmin \
+        --trigger-http \
+        --no-allow-unauthenticated \
+        --service-account="${SERVICE_ACCOUNT_EMAIL}" \
+        --quiet
+      ;;
+    "revision-differ")
+      gcloud functions deploy HandleDiffRevisions \
+        --gen2 \
+        --runtime=go123 \
+        --region="${REGION}" \
+        --source="${BUILD_DIR}" \
+        --entry-point=handleDiffRevisions \
+        --trigger-http \
+        --no-allow-unauthenticated \
+        --service-account="${SERVICE_ACCOUNT_EMAIL}" \
+        --quiet
+      ;;
+    "document-status")
+      gcloud functions deploy HandleDocumentStatus \
+        --gen2 \
+        --runtime=go123 \
+        --region="${REGION}" \
+        --source="${BUILD_DIR}" \
+        --entry-point=handleDocumentStatus \
+        --trigger-http \
+        --no-allow-unauthenticated \
+        --service-account="${SERVICE_ACCOUNT_EMAIL}" \
+        --quiet
+      ;;
+    "document-admin")
+      gcloud functions deploy HandleDocumentAdmin \
+        --gen2 \
+        --runtime=go123 \
+        --region="${REGION}" \
+        --source="${BUILD_DIR}" \
+        --entry-point=handleDocumentAdmin \
+        --trigger-http \
+        --no-allow-unauthenticated \
+        --service-account="${SERVICE_ACCOUNT_EMAIL}" \
+        --quiet
+      ;;
+    "document-reprocessor")
+      gcloud functions deploy HandleReprocessDocument \
+        --gen2 \
+        --runtime=go123 \
+        --region="${REGION}" \
+        --source="${BUILD_DIR}" \
+        --entry-point=handleReprocessDocument \
+        --trigger-http \
+        --no-allow-unauthenticated \
+        --service-account="${SERVICE_ACCOUNT_EMAIL}" \
+        --quiet
+      ;;
+    "workflow-retrigger")
+      gcloud functions deploy HandleRetriggerWorkflow \
+        --gen2 \
+        --runtime=go123 \
+        --region="${REGION}" \
+        --source="${BUILD_DIR}" \
+        --entry-point=handleRetriggerWorkflow \
+        --trigger-http \
+        --no-allow-unauthenticated \
+        --service-account="${SERVICE_ACCOUNT_EMAIL}" \
+        --quiet
+      ;;
+    "page-retranslator")
+      gcloud functions deploy HandleRetranslatePages \
+        --gen2 \
+        --runtime=go123 \
+        --region="${REGION}" \
+        --source="${BUILD_DIR}" \
+        --entry-point=handleRetranslatePages \
+        --trigger-http \
+        --no-allow-unauthenticated \
+        --service-account="${SERVICE_ACCOUNT_EMAIL}" \
+        --quiet
+      ;;
+    "document-uploader")
+      gcloud functions deploy HandleUploadDocument \
+        --gen2 \
+        --runtime=go123 \
+        --region="${REGION}" \
+        --source="${BUILD_DIR}" \
+        --entry-point=handleUploadDocument \
+        --trigger-http \
+        --no-allow-unauthenticated \
+        --service-account="${SERVICE_ACCOUNT_EMAIL}" \
+        --quiet
+      ;;
+    "pipeline-coordinator")
+      deploy_pubsub_entry CoordinatePipeline "${COORDINATOR_TOPIC}"
+      ;;
+    "stuck-sweeper")
+      SWEEP_TOPIC="${STUCK_SWEEP_TOPIC:-stuck-document-sweep}"
+      gcloud pubsub topics describe "${SWEEP_TOPIC}" >/dev/null 2>&1 || gcloud pubsub topics create "${SWEEP_TOPIC}" --quiet
+      gcloud functions deploy SweepStuckDocuments \
+        --gen2 \
+        --runtime=go123 \
+        --region="${REGION}" \
+        --source="${BUILD_DIR}" \
+        --entry-point=SweepStuckDocuments \
+        --trigger-topic="${SWEEP_TOPIC}" \
+        --service-account="${SERVICE_ACCOUNT_EMAIL}" \
+        --quiet
+      gcloud scheduler jobs describe stuck-document-sweep --location="${REGION}" >/dev/null 2>&1 || \
+        gcloud scheduler jobs create pubsub stuck-document-sweep \
+          --location="${REGION}" \
+          --schedule="${STUCK_SWEEP_SCHEDULE:-*/30 * * * *}" \
+          --topic="${SWEEP_TOPIC}" \
+          --message-body="{}" \
+          --quiet
       ;;
   esac
 done
 
-# --- Deploy the Orchestrator Workflow ---
-echo "-----------------------------------------------------"
-echo ">>> Deploying Cloud Workflow"
-echo "-----------------------------------------------------"
-gcloud workflows deploy document-processing-orchestrator \
-  --source=./orchestration/document-processing-orchestrator.yaml \
-  --location="${WORKFLOW_LOCATION}" \
-  --service-account="${SERVICE_ACCOUNT_EMAIL}" \
-  --quiet
-
-# --- Dynamically set the worker URL for the workflow ---
-echo "--> Setting PDF_SPLITTER_WORKER_URL environment variable for the workflow..."
-PDF_SPLITTER_URL=$(gcloud functions describe SplitAndPublish --region "${REGION}" --format 'value(serviceConfig.uri)')
-gcloud workflows deploy document-processing-orchestrator \
+# --- Deploy the Orchestrator Workflow, unless the stages are chained through Pub/Sub ---
+if [ "${ORCHESTRATION_MODE}" != "pubsub" ]; then
+  echo "-----------------------------------------------------"
+  echo ">>> Deploying Cloud Workflow"
+  echo "-----------------------------------------------------"
+  gcloud workflows deploy document-processing-orchestrator \
     --source=./orchestration/document-processing-orchestrator.yaml \
     --location="${WORKFLOW_LOCATION}" \
     --service-account="${SERVICE_ACCOUNT_EMAIL}" \
-    --set-env-vars="PDF_SPLITTER_WORKER_URL=${PDF_SPLITTER_URL}" \
     --quiet
 
+  # --- Dynamically set the worker URL for the workflow ---
+  echo "--> Setting PDF_SPLITTER_WORKER_URL environment variable for the workflow..."
+  PDF_SPLITTER_URL=$(gcloud functions describe SplitAndPublish --region "${REGION}" --format 'value(serviceConfig.uri)')
+  gcloud workflows deploy document-processing-orchestrator \
+      --source=./orchestration/document-processing-orchestrator.yaml \
+      --location="${WORKFLOW_LOCATION}" \
+      --service-account="${SERVICE_ACCOUNT_EMAIL}" \
+      --set-env-vars="PDF_SPLITTER_WORKER_URL=${PDF_SPLITTER_URL}" \
+      --quiet
+fi
+
 
 echo "✅ Deployment complete."
\ No newline at end of file
@@ -22,18 +22,429 @@ export VERTEX_AI_REGION="us-central1" # Can be different from REGION if needed
 export SERVICE_ACCOUNT_EMAIL="pipeline-runner-sa@${PROJECT_ID}.iam.gserviceaccount.com"
 
 # --- GCS Buckets (as per the revised plan) ---
-# Each variable corresponds to a bucket used by one or more services.
+# Each variable corresponds to a bucket used by one or more services. The translator,
+# cleaner and section splitter only read request URIs under {docId}/ in the bucket of
+# their input (split pages, aggregated and cleaned markdown respectively).
 export UPLOADS_BUCKET="${PROJECT_ID}-ingest"
 export SPLIT_PAGES_BUCKET="${PROJECT_ID}-split-pages"
 export TRANSLATED_MARKDOWN_BUCKET="${PROJECT_ID}-translated-markdown"
 export AGGREGATED_MARKDOWN_BUCKET="${PROJECT_ID}-aggregated-markdown"
 export CLEANED_MARKDOWN_BUCKET="${PROJECT_ID}-cleaned-markdown"
 export FINAL_SECTIONS_BUCKET="${PROJECT_ID}-final-sections"
+# Optional: uncomment to extract embedded page images for figure links.
+# export IMAGES_BUCKET="${PROJECT_ID}-page-images"
+
+# --- Splitting (optional) ---
+# Pages per split file sent to the translator. 1 (the default) translates page by page.
+# export CHUNK_SIZE="1"
+# "page" (the default) splits into files of CHUNK_SIZE pages. "bookmark" splits into a
+# file per top-level outline entry, stored as {docId}/chapters/{idx}_{slug}.pdf, and passes
+# the chapters to the workflow; documents without a usable outline are split into pages.
+# It requires ORCHESTRATION_MODE=workflows.
+# export SPLIT_MODE="page"
+# Documents with more pages are rejected as REJECTED_TOO_LARGE without being split.
+# export MAX_PAGE_COUNT="2000"
+# Only uploads whose names start with INPUT_PREFIX and end with INPUT_SUFFIX are split.
+# Other objects, folder placeholders, hidden and temporary files (names starting with
+# "." or "~"), empty objects, non-PDF content types and uploads with the metadata
+# x-goog-meta-skip-processing=true are skipped without creating a document.
+# export INPUT_PREFIX="incoming/"
+# export INPUT_SUFFIX=".pdf"
+# Uploads of at least LARGE_FILE_THRESHOLD_BYTES are worked on under SCRATCH_DIR, a
+# disk-backed mount, instead of the memory-backed /tmp, and their pages are uploaded
+# with LARGE_FILE_UPLOAD_CHUNK_BYTES buffers (0 keeps the 16 MiB default).
+# export SCRATCH_DIR="/mnt/scratch"
+# export LARGE_FILE_THRESHOLD_BYTES="268435456"
+# export LARGE_FILE_UPLOAD_CHUNK_BYTES="2097152"
+# Working files are deleted as soon as they are used. A PDF is only split if its working
+# directory has SPLIT_SPACE_FACTOR times its optimized size free; otherwise the upload is
+# REJECTED_TOO_LARGE. 0 disables the check.
+# export SPLIT_SPACE_FACTOR="1.5"
+# Each upload is leased in LEASE_COLLECTION while it is split, so a redelivered event
+# reaching a second instance is skipped. The lease expires after UPLOAD_LEASE_TTL if its
+# holder crashes; keep it longer than the splitter's timeout.
+# export LEASE_COLLECTION="leases"
+# export UPLOAD_LEASE_TTL="10m"
+# The workflow starts as soon as the page count is known, while pages still upload; the
+# document stays SPLITTING until they are all uploaded, and the translator answers a page
+# not uploaded yet with a retryable SOURCE_NOT_READY error. "false" starts it afterwards.
+# The document's pagesUploaded field counts uploaded pages, updated every
+# UPLOAD_PROGRESS_BATCH_PAGES pages.
+# export START_WORKFLOW_EARLY="true"
+# export UPLOAD_PROGRESS_BATCH_PAGES="25"
+# Every page is uploaded even when some fail, and the document's uploadedPages and
+# failedPages fields list the outcome. With at most MAX_FAILED_UPLOAD_PAGES failed pages
+# the workflow runs over the rest, and the failed pages are marked UPLOAD_FAILED for the
+# page retranslator to backfill from the upload. 0 fails the document on any failure.
+# export MAX_FAILED_UPLOAD_PAGES="0"
+
+# --- Document Bundles (optional) ---
+# Uploads with the metadata x-goog-meta-bundle=true hold several documents. They are
+# split at their top-level bookmarks or, without any, where Gemini finds each document
+# starts in the first BUNDLE_DETECTION_PAGES pages, and each document is processed as
+# a document of its own.
+# export BUNDLE_DETECTION_PAGES="10"
+
+# --- Duplicate Uploads (optional) ---
+# A file already processed is skipped when uploaded again. DEDUPE_SCOPE narrows where it
+# must be unique: "global", "bucket" for once per source bucket, or "tenant" for once per
+# x-goog-meta-tenant-id (uploads without one are checked globally). Documents created
+# under another scope do not block uploads. After DEDUPE_TTL_DAYS a completed document no
+# longer blocks its file; the new document records it as supersedesDocumentId. 0 blocks
+# for good. Set both the same on the splitter and the document-uploader.
+# export DEDUPE_SCOPE="global"
+# export DEDUPE_TTL_DAYS="0"
+
+# --- Tenants (optional) ---
+# A document's tenant is that of the bucket it was uploaded to, from TENANT_BUCKETS, a
+# comma-separated list of bucket=tenant pairs, or else its x-goog-meta-tenant-id. Every
+# object derived from a tenant's document is stored under "{tenantId}/", so shared buckets
+# can be split between tenants with IAM conditions on the object name prefix. The workflow
+# passes the tenantId it is started with on each stage request, and a stage refuses a
+# request whose tenant is not the document's. Set the same on the splitter and the
+# document-uploader.
+# A tenant with a config in TENANT_COLLECTION that fails validation has its uploads
+# recorded as REJECTED_TENANT_CONFIG by the splitter, and its requests refused by the
+# translator and cleaner. Configs are cached for a minute.
+# export TENANT_BUCKETS="unit-a-uploads=unit-a,unit-b-uploads=unit-b"
+
+# --- HTTP Uploads (optional) ---
+# The document-uploader function accepts PDFs POSTed to /upload, as an application/pdf
+# body or the "file" part of a multipart form, and stores them in UPLOADS_BUCKET as
+# INPUT_PREFIX{sha256}.pdf with the file name in x-goog-meta-original-filename. It creates
+# the document as RECEIVED so its ID can be returned at once; the splitter takes it over.
+# Larger uploads are rejected with 413. Cloud Functions caps HTTP/1 request bodies at
+# 32 MiB.
+# export MAX_UPLOAD_BYTES="33554432"
 
 # --- Workflow & Firestore Configuration ---
 export WORKFLOW_LOCATION="us-central1"
 export WORKFLOW_ID="document-processing-orchestrator"
 export FIRESTORE_COLLECTION="documents"
+export TENANT_COLLECTION="tenants"
+
+# --- Workflow Trigger Retries (optional) ---
+# Attempts to start the workflow after pages are split. A document whose workflow still
+# cannot be started is left in PAGES_READY_WORKFLOW_FAILED for the workflow-retrigger
+# function, which retriggers up to RETRIGGER_SWEEP_LIMIT documents per sweep.
+# export WORKFLOW_TRIGGER_MAX_RETRIES="3"
+# export WORKFLOW_TRIGGER_RETRY_BASE_MS="1000"
+# export RETRIGGER_SWEEP_LIMIT="50"
+
+# --- Stuck Document Sweeper (optional) ---
+# The stuck-sweeper function runs on STUCK_SWEEP_SCHEDULE through STUCK_SWEEP_TOPIC. It
+# looks at documents left SPLITTING, TRANSLATING, AGGREGATING, CLEANING or
+# SPLITTING_SECTIONS for longer than STUCK_THRESHOLD whose workflow execution is no longer
+# running. Those with split pages are retriggered, at most STUCK_MAX_RETRIGGERS times;
+# the rest are marked STALLED with the reason in errorDetails. At most STUCK_SWEEP_LIMIT
+# documents are acted on per run. It reads the workflow retrigger's settings too.
+# export STUCK_SWEEP_TOPIC="stuck-document-sweep"
+# export STUCK_SWEEP_SCHEDULE="*/30 * * * *"
+# export STUCK_THRESHOLD="2h"
+# export STUCK_MAX_RETRIGGERS="1"
+# export STUCK_SWEEP_LIMIT="20"
+
+# --- Pub/Sub Orchestration (optional) ---
+# ORCHESTRATION_MODE="pubsub" chains the stages through Pub/Sub where Cloud Workflows is
+# unavailable. The splitter publishes a translator request per page or chunk, once every
+# page is uploaded, to TRANSLATE_TOPIC. Each stage's *FromPubSub entry point reports to
+# COORDINATOR_TOPIC, and the pipeline-coordinator function counts the pages down in the
+# document's runs subcollection and publishes the next stage's request. Failed pages are
+# replaced by placeholders unless PUBSUB_ALLOW_PAGE_FAILURE is "false". The workflow
+# retrigger and reprocessor still need Cloud Workflows.
+# export ORCHESTRATION_MODE="workflows"
+# export TRANSLATE_TOPIC="translate-pages"
+# export AGGREGATE_TOPIC="aggregate-markdown"
+# export CLEAN_TOPIC="clean-markdown"
+# export SECTION_SPLIT_TOPIC="split-sections"
+# export COORDINATOR_TOPIC="pipeline-coordinator"
+# export PUBSUB_ALLOW_PAGE_FAILURE="true"
+
+# --- Workflow Page List (optional) ---
+# The workflow argument lists the split file of every page as "pages". Documents with more
+# pages than this get "manifestGcsUri" instead, naming {docId}/manifest.json in the split
+# pages bucket, which holds the same list. 0 always writes the manifest.
+# export WORKFLOW_INLINE_PAGES_MAX="100"
+
+# --- Page Retranslation (optional) ---
+# Pages the page-retranslator function translates at once.
+# export RETRANSLATE_CONCURRENCY="4"
+
+# --- Gemini Retry Tuning (optional) ---
+# In-process retries for transient Vertex AI errors (429/500/503/deadline).
+# export GEMINI_MAX_RETRIES="3"
+# export GEMINI_RETRY_BASE_MS="500"
+
+# --- Vertex AI Call Limits (optional) ---
+# Per-instance limits on Gemini calls, shared by every model the function uses. 0 means
+# unlimited. Waits longer than VERTEX_LIMIT_WAIT_WARN are logged.
+# export VERTEX_MAX_CONCURRENT="8"
+# export VERTEX_QPM="0"
+# export VERTEX_LIMIT_WAIT_WARN="5s"
+
+# --- Gemini Models (optional) ---
+# Per-stage models. Unset stages use GEMINI_MODEL_NAME, then gemini-1.5-pro.
+# export GEMINI_MODEL_NAME="gemini-1.5-pro"
+# export TRANSLATOR_MODEL_NAME="gemini-1.5-pro"
+# export CLEANER_MODEL_NAME="gemini-1.5-flash"
+# export SECTION_SPLITTER_MODEL_NAME="gemini-1.5-pro"
+# export BUNDLE_SPLITTER_MODEL_NAME="gemini-1.5-flash"
+
+# --- Gemini Audit Trail (optional) ---
+# Set AUDIT_BUCKET to record every Gemini call's prompt, config and raw response as
+# {docId}/audit/{stage}/{page-or-chunk}-{startedAt}.json. Unset disables auditing.
+# Writes are best effort and give up after AUDIT_WRITE_TIMEOUT. Artifact listings include
+# the records when the status service has AUDIT_BUCKET set.
+# export AUDIT_BUCKET="${PROJECT_ID}-audit"
+# export AUDIT_WRITE_TIMEOUT="5s"
+
+# --- Cleaner Chunking (optional) ---
+# Master files larger than the threshold (bytes) are cleaned in page-aligned chunks.
+# Each cleaned chunk is checkpointed under {docId}/cleaned_chunks/ in the cleaned
+# markdown bucket, so a retried cleanup only cleans the chunks it had not finished.
+# export CLEANER_CHUNK_THRESHOLD_BYTES="32768"
+# export CLEANER_CHUNK_TARGET_BYTES="32768"
+# export CLEANER_CHUNK_CONCURRENCY="4"
+
+# --- Cleaner Mode (optional) ---
+# "llm" sends the master file to the cleaner model; "deterministic" only removes page
+# separators, merges tables split across pages, rejoins hyphenated words and fixes
+# heading levels, without a model call; "hybrid" does both, the Go pass first. Tables
+# merge when this fraction of their header cells match.
+# export CLEANER_MODE="llm"
+# export CLEANER_TABLE_HEADER_SIMILARITY="0.8"
+
+# --- Cleaner Output Check (optional) ---
+# The cleaner refuses to save markdown that keeps less than this fraction of the master
+# file's characters, failing with OUTPUT_REJECTED instead; 0 disables the check. Set
+# CLEANER_REPORT to also save the before/after counts as {docId}/clean_report.json.
+# export CLEANER_MIN_RETAINED_RATIO="0.7"
+# export CLEANER_REPORT="false"
+
+# --- Markdown Input (optional) ---
+# How the cleaner and section splitter pass markdown to Gemini: "inline" sends the text,
+# "file_data" passes the GCS URI.
+# export MARKDOWN_INPUT_MODE="inline"
+
+# --- Markdown Compression (optional) ---
+# Store translated pages, cleaned markdown and sections gzip-compressed
+# (Content-Encoding: gzip). Readers decompress them transparently.
+# export COMPRESS_MARKDOWN="false"
+
+# --- Translation Quality (optional) ---
+# Pages with less or more markdown per KB of PDF than these bounds (a maximum of 0 has no
+# limit), or a larger share of image-description lines, are flagged with a lower
+# confidence in the translator response and page record.
+# export TRANSLATOR_QUALITY_MIN_CHARS_PER_KB="5"
+# export TRANSLATOR_QUALITY_MAX_CHARS_PER_KB="2000"
+# export TRANSLATOR_QUALITY_MAX_IMAGE_LINE_FRACTION="0.5"
+# Follow-up calls made when a page's translation stops at the output token limit. Pages
+# still truncated after this many are flagged "truncated_output".
+# export TRANSLATOR_MAX_CONTINUATIONS="2"
+
+# --- Translation Output (optional) ---
+# Lines the model writes before or after a page's markdown are removed when they open with
+# one of these phrases, matched without regard to case. Lists are separated by "|" and
+# replace the built-in ones; set an empty list to remove nothing.
+# export TRANSLATOR_PREAMBLE_PHRASES="here is the markdown|here is the translation|sure, here"
+# export TRANSLATOR_EPILOGUE_PHRASES="let me know if|i hope this helps"
+# Times a page whose markdown has an unclosed code block or no text is translated again,
+# with a corrective instruction, before it fails with LLM_MALFORMED_OUTPUT.
+# export TRANSLATOR_MALFORMED_RETRIES="1"
+
+# --- Oversize Pages (optional) ---
+# Pages over TRANSLATOR_MAX_INPUT_BYTES are re-rendered from their largest image at each
+# of TRANSLATOR_DEGRADE_DPI in turn, and translated from the first JPEG that fits both it
+# and TRANSLATOR_INLINE_MAX_BYTES; the page record gets degradedInput, renderedDpi and
+# renderedSizeBytes. Chunks, redacted pages, pages over TRANSLATOR_DEGRADE_MAX_BYTES and
+# pages no rendering fits get a skipped_oversize placeholder. An empty
+# TRANSLATOR_DEGRADE_DPI disables re-rendering.
+# export TRANSLATOR_MAX_INPUT_BYTES="52428800"
+# export TRANSLATOR_DEGRADE_DPI="150,100,72"
+# export TRANSLATOR_DEGRADE_MAX_BYTES="268435456"
+
+# --- OCR Fallback (optional) ---
+# Document AI OCR processor, as projects/{project}/locations/{location}/processors/{id}.
+# When set, pages flagged empty, low density, image heavy or unreadable are OCRed and
+# translated again from the OCR text; their translator response has method "gemini+ocr".
+# export DOC_AI_PROCESSOR=""
+
+# --- Table Extraction (optional) ---
+# Ask Gemini for the tables of every translated page in a second call and write each as
+# gs://$TRANSLATED_MARKDOWN_BUCKET/{docId}/tables/{page}_{n}.csv. Requests can also set
+# "extractTables": true page by page. Redacted pages are never extracted.
+# export EXTRACT_TABLES="true"
+
+# --- Image Extraction (optional) ---
+# Reference the embedded images of every single-page request in its markdown, extracting
+# them from the page PDF into gs://$IMAGES_BUCKET/{docId}/pages/{page}/img_{n}.{ext} when
+# the splitter has not. Requests can also set "includeImages": true page by page. Images
+# are linked relative to the page markdown, or with signed URLs valid for IMAGE_URL_TTL
+# when IMAGE_LINK_MODE is "signed". Images that cannot be decoded are skipped.
+# export EXTRACT_IMAGES="true"
+# export IMAGE_LINK_MODE="relative"
+# export IMAGE_URL_TTL="168h"
+
+# --- Revision Diffs (optional) ---
+# The revision-differ diffs the translated pages of a document against those of a prior
+# revision: the baseDocumentId of its request, or else the document named by the upload's
+# x-goog-meta-reprocess-of. Diffs are written to DIFFS_BUCKET, by default the aggregated
+# markdown bucket, as {docId}/diffs/{page}.diff with an operator report in
+# {docId}/diffs/report.json. Diffs over MAX_DIFF_BYTES are cut with a marker.
+# export DIFFS_BUCKET=""
+# export MAX_DIFF_BYTES="262144"
+
+# --- Translation Cache (optional) ---
+# Firestore collection caching page translations across documents. Pages whose PDF bytes,
+# model, prompt profile and language hints match a cached translation have its markdown
+# copied instead of calling Gemini; their translator response has "cacheHit": true.
+# Redacted pages, pages with image references and custom prompts are never cached.
+# Entries older than TRANSLATION_CACHE_TTL, or whose markdown has since been deleted
+# (for example by CLEANUP_INTERMEDIATES), are dropped when read and translated again.
+# export TRANSLATION_CACHE_COLLECTION="translationCache"
+# export TRANSLATION_CACHE_TTL="720h"
+
+# --- Data Residency (optional) ---
+# Uploads with the metadata x-goog-meta-processing-region=<region> are translated by
+# Vertex AI in that region only; a page fails rather than fall back to VERTEX_AI_REGION.
+# With x-goog-meta-redaction-mode=dlp the page PDF is not sent to Gemini at all: it is
+# OCRed by DOC_AI_PROCESSOR (keep it in the same jurisdiction), the DLP_INFO_TYPES found
+# in its text are redacted by Cloud DLP, and only the redacted text is translated. Such
+# pages fail unless both are set. The cleaner and section splitter still run in
+# VERTEX_AI_REGION, and restricted bundles are only split by their bookmarks.
+# export DLP_INFO_TYPES="PERSON_NAME,EMAIL_ADDRESS,PHONE_NUMBER,STREET_ADDRESS"
+
+# --- Processing Profiles (no variables) ---
+# Uploads with the metadata x-goog-meta-processing-profile set to a JSON object override
+# the stages' settings for that document, for example
+#   {"chunkSize": 5, "promptProfile": "drawing", "cleanerMode": "hybrid", "skipSections": true}
+# Every field is optional. Unknown fields or invalid values discard the whole profile, and
+# the upload is processed with the defaults. With "skipSections" the section splitter
+# completes the document with status "skipped", leaving the cleaned markdown as its output.
+
+# --- Intermediate Cleanup (optional) ---
+# Delete each document's split pages, translated markdown and aggregated markdown once the
+# section splitter marks it COMPLETE. Cleaned markdown, final sections and extracted
+# tables are kept.
+# export CLEANUP_INTERMEDIATES="true"
+
+# --- Tracing (optional) ---
+# Export OpenTelemetry spans for every stage to Cloud Trace.
+# export ENABLE_TRACING="true"
+
+# --- Metrics (optional) ---
+# Export pipeline metrics to Cloud Monitoring: pages_translated_total{status},
+# gemini_latency_ms{stage,code}, gcs_upload_retries_total, documents_completed_total and
+# aggregation_duration_ms. A failed export is logged and never fails a request.
+# export ENABLE_METRICS="true"
+
+# --- HTTP Request Limits (optional) ---
+# Largest accepted request body, and the deadline for processing a request. Keep the
+# timeout below the function timeout so a slow request gets a 504 instead of being killed.
+# export MAX_REQUEST_BYTES="1048576"
+# export REQUEST_TIMEOUT="9m"
+
+# --- Health Checks (optional) ---
+# GET /healthz on the HTTP workers reports whether their clients were created. With
+# DEEP_HEALTHCHECK set it also looks up an object in the bucket each worker writes to.
+# export DEEP_HEALTHCHECK="false"
+
+# --- Section Coverage (optional) ---
+# Below this share of the source's content, the model's sections are replaced by a
+# deterministic header-based split.
+# export SECTION_MIN_COVERAGE="0.95"
+
+# --- Section Layout (optional) ---
+# "flat" saves every section directly under {docId}/; "nested" saves each section under
+# a directory per parent section, e.g. {docId}/3_electrical/3_1_cabling/3_1_2_terminations.md.
+# export SECTION_LAYOUT="flat"
+# Begin each section file with YAML front matter: documentId, originalFilename, title,
+# order, startPage/endPage (when the document has page anchors) and generatedAt.
+# export FRONT_MATTER="false"
+
+# --- Section Windows (optional) ---
+# Cleaned markdown larger than SECTION_WINDOW_THRESHOLD_BYTES is split into windows of
+# about SECTION_WINDOW_TARGET_BYTES, starting at headers where possible, with up to
+# SECTION_WINDOW_OVERLAP_BYTES of each window repeated at the start of the next. Each
+# window is split by its own model call, and the sections are merged by title; the
+# section splitter response reports windowCount and mergeConflicts.
+# export SECTION_WINDOW_THRESHOLD_BYTES="262144"
+# export SECTION_WINDOW_TARGET_BYTES="65536"
+# export SECTION_WINDOW_OVERLAP_BYTES="4096"
+# export SECTION_WINDOW_CONCURRENCY="4"
+
+# --- Output Names (optional) ---
+# Go text/template names of translated pages, master files (aggregated and cleaned) and
+# sections. Fields: .DocID, .OriginalFilename (the upload's base name without its
+# extension) and .FilenameSlug (that name lowercased and folded to ASCII letters, digits
+# and "-", without double extensions) everywhere; .Page, .StartPage and .EndPage for
+# pages; .Path (the section path SECTION_LAYOUT gives), .Title and .Order for sections.
+# Functions: pad N WIDTH, lower, upper. Templates are checked at startup; names with "." or ".." segments are
+# refused, and master files must be named under {{.DocID}}/. Artifact listing, purges
+# and intermediate cleanup find the outputs through the same templates, so set them
+# alike on every service.
+# export OUTPUT_PAGE_TEMPLATE='{{.DocID}}/{{pad .StartPage 5}}{{if ne .StartPage .EndPage}}-{{pad .EndPage 5}}{{end}}.md'
+# export OUTPUT_MASTER_TEMPLATE='{{.DocID}}/master.md'
+# export OUTPUT_SECTION_TEMPLATE='{{.DocID}}/{{.Path}}.md'
+
+# --- Aggregator Prefetch (optional) ---
+# Page objects read ahead of the writer, and the largest page (bytes) buffered in memory.
+# export AGGREGATOR_PREFETCH="8"
+# export AGGREGATOR_PREFETCH_MAX_BYTES="4194304"
+# Precede each page with a "<!-- page: N -->" anchor rather than a "---" separator.
+# export AGGREGATOR_PAGE_ANCHORS="true"
+# Strip stray code fences from each page, demote a level-1 title repeated from the page
+# before and collapse runs of blank lines. "false" appends pages exactly as translated.
+# export AGGREGATOR_NORMALIZE_PAGES="true"
+
+# --- Aggregation Formats (optional) ---
+# Formats the aggregator writes, in one pass over the translated pages. "markdown" is the
+# master.md the cleaner reads and cannot be left out; "jsonl" adds master.jsonl next to
+# it, one {"page": N, "markdown": "..."} line per page file ("endPage" is added for
+# multi-page chunks). Intermediate cleanup deletes master.jsonl along with master.md.
+# export AGGREGATION_FORMATS="markdown,jsonl"
+
+# --- Document Listing (optional) ---
+# GET /documents on the document-admin function lists documents by status, creation time
+# and filename. Filtering by status and creation time together needs a composite index:
+#   gcloud firestore indexes composite create --collection-group="$FIRESTORE_COLLECTION" \
+#     --field-config=field-path=status,order=ascending \
+#     --field-config=field-path=createdAt,order=descending
+# Set this once the index is built; until then such requests are refused with a 400.
+# export DOCUMENT_STATUS_CREATED_AT_INDEX="true"
+
+# --- Document Cancellation (optional) ---
+# POST /documents/{id}:cancel on the document-admin function sets cancelRequested on a
+# document. Each stage checks it before starting work, and the translator and cleaner
+# also between model calls and chunks; the first to notice it moves the document to
+# CANCELLED and fails with a CANCELLED error, which is not retryable. The request also
+# cancels the document's workflow execution, so its service account needs the Workflows
+# Invoker role, and the coordinator ignores later completions of a Pub/Sub run. A stage
+# instance rereads a document not cancelled at most every CANCEL_CHECK_TTL.
+# export CANCEL_CHECK_TTL="30s"
+
+# --- Cost Estimates (optional) ---
+# A translator or cleaner request with "dryRun": true counts the input tokens of the
+# model calls it would make, without making them or writing anything, and returns them
+# with the output tokens expected of them, from the stage's low,high ratio of output to
+# input tokens, and, for models listed in MODEL_PRICING, their cost in US dollars per
+# million tokens. GET /estimate?documentId=... on the document-status function sums the
+# estimates of every split page of a document; it needs the Vertex AI settings and
+# TRANSLATOR_OUTPUT_TOKEN_RATIO of the translator.
+# export MODEL_PRICING='{"gemini-2.5-pro": {"inputPerMillion": 1.25, "outputPerMillion": 10}}'
+# export TRANSLATOR_OUTPUT_TOKEN_RATIO="0.5,2"
+# export CLEANER_OUTPUT_TOKEN_RATIO="0.7,1.1"
+
+# --- Signed Output URLs (optional) ---
+# Return V4 signed URLs to the master, cleaned and section files alongside their gs://
+# URIs, from the document-status function and in the section splitter's response. URLs
+# are signed through the IAM Credentials SignBlob API, so no key file is needed, as
+# SIGNING_SERVICE_ACCOUNT or, by default, the function's own service account, which needs
+# roles/iam.serviceAccountTokenCreator on that account. Signing failures only drop the
+# signed URL. SIGNED_URL_TTL also applies to signed artifact listings.
+# export SIGN_OUTPUT_URLS="true"
+# export SIGNED_URL_TTL="15m"
+# export SIGNING_SERVICE_ACCOUNT=""
 
 # --- Cloud Function URLs (REMOVED) ---
 # These are now set dynamically by the ./scripts/deploy.sh script after